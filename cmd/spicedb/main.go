@@ -34,6 +34,7 @@ func main() {
 
 	// Enable consistent hashring gRPC load balancer
 	balancer.Register(cmdutil.ConsistentHashringBuilder)
+	balancer.Register(cmdutil.OutlierEjectingHashringBuilder)
 
 	// Create a root command
 	rootCmd := cmd.NewRootCommand("spicedb")
@@ -110,12 +110,11 @@ func (mdb *memdbDatastore) loadChanges(_ context.Context, currentTxn int64, opti
 	for changeRaw := it.Next(); changeRaw != nil; changeRaw = it.Next() {
 		change := changeRaw.(*changelog)
 
-		if options.Content&datastore.WatchRelationships == datastore.WatchRelationships && len(change.changes.RelationshipChanges) > 0 {
-			changes = append(changes, &change.changes)
-		}
-
-		if options.Content&datastore.WatchSchema == datastore.WatchSchema &&
-			len(change.changes.ChangedDefinitions) > 0 || len(change.changes.DeletedCaveats) > 0 || len(change.changes.DeletedNamespaces) > 0 {
+		includesRelationships := options.Content&datastore.WatchRelationships == datastore.WatchRelationships &&
+			len(change.changes.RelationshipChanges) > 0
+		includesSchema := options.Content&datastore.WatchSchema == datastore.WatchSchema &&
+			(len(change.changes.ChangedDefinitions) > 0 || len(change.changes.DeletedCaveats) > 0 || len(change.changes.DeletedNamespaces) > 0)
+		if includesRelationships || includesSchema {
 			changes = append(changes, &change.changes)
 		}
 
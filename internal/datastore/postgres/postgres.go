@@ -318,6 +318,7 @@ func newPostgresDatastore(
 		dburl:                   pgURL,
 		readPool:                pgxcommon.MustNewInterceptorPooler(readPool, config.queryInterceptor),
 		writePool:               nil, /* disabled by default */
+		readPoolStats:           readPool,
 		watchBufferLength:       config.watchBufferLength,
 		watchBufferWriteTimeout: config.watchBufferWriteTimeout,
 		optimizedRevisionQuery:  revisionQuery,
@@ -343,6 +344,7 @@ func newPostgresDatastore(
 
 	if isPrimary {
 		datastore.writePool = pgxcommon.MustNewInterceptorPooler(writePool, config.queryInterceptor)
+		datastore.writePoolStats = writePool
 	}
 
 	datastore.SetOptimizedRevisionFunc(datastore.optimizedRevisionFunc)
@@ -373,6 +375,8 @@ type pgDatastore struct {
 
 	dburl                   string
 	readPool, writePool     pgxcommon.ConnPooler
+	readPoolStats           *pgxpool.Pool
+	writePoolStats          *pgxpool.Pool
 	watchBufferLength       uint16
 	watchBufferWriteTimeout time.Duration
 	optimizedRevisionQuery  string
@@ -396,6 +400,24 @@ type pgDatastore struct {
 	filterMaximumIDCount uint16
 }
 
+// ConnPoolStats implements datastore.ConnPoolStatsReporter.
+func (pgd *pgDatastore) ConnPoolStats() []datastore.ConnPoolStats {
+	stats := []datastore.ConnPoolStats{connPoolStats("read", pgd.readPoolStats)}
+	if pgd.writePoolStats != nil {
+		stats = append(stats, connPoolStats("write", pgd.writePoolStats))
+	}
+	return stats
+}
+
+func connPoolStats(name string, pool *pgxpool.Pool) datastore.ConnPoolStats {
+	stat := pool.Stat()
+	return datastore.ConnPoolStats{
+		Name:          name,
+		AcquiredConns: uint32(max(stat.AcquiredConns(), 0)),
+		MaxConns:      uint32(max(stat.MaxConns(), 0)),
+	}
+}
+
 func (pgd *pgDatastore) IsStrictReadModeEnabled() bool {
 	return pgd.inStrictReadMode
 }
@@ -756,4 +778,7 @@ func DefaultQueryExecMode(poolConfig *pgxpool.Config) *pgxpool.Config {
 	return poolConfig
 }
 
-var _ datastore.Datastore = &pgDatastore{}
+var (
+	_ datastore.Datastore             = &pgDatastore{}
+	_ datastore.ConnPoolStatsReporter = &pgDatastore{}
+)
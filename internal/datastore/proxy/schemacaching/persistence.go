@@ -0,0 +1,92 @@
+package schemacaching
+
+import (
+	"context"
+	"strings"
+
+	"github.com/zapravila/spicedb/internal/warmstart"
+	"github.com/zapravila/spicedb/pkg/cache"
+	"github.com/zapravila/spicedb/pkg/datastore"
+)
+
+// PersistenceCodec persists the names of the definitions cached by the just-in-time schema cache,
+// for warm-starting the cache. As the cached entries are keyed by the revision at which they were
+// read, the definitions are loaded again on restore, at the revision which requests will select.
+type PersistenceCodec struct {
+	ds datastore.Datastore
+}
+
+var _ warmstart.Codec[cache.StringKey, CacheEntry] = PersistenceCodec{}
+
+// NewPersistenceCodec returns a codec which loads persisted definitions from the given datastore.
+func NewPersistenceCodec(ds datastore.Datastore) PersistenceCodec {
+	return PersistenceCodec{ds: ds}
+}
+
+// PersistedKey returns the cache key without its revision.
+func (pc PersistenceCodec) PersistedKey(key cache.StringKey) ([]byte, bool) {
+	definitionKey, _, ok := strings.Cut(string(key), "@")
+	return []byte(definitionKey), ok
+}
+
+// Load reads the definitions with the persisted keys given at the optimized revision of the
+// datastore, under the keys at which they are looked up at that revision.
+func (pc PersistenceCodec) Load(ctx context.Context, persistedKeys [][]byte) ([]warmstart.Entry[cache.StringKey, CacheEntry], error) {
+	var namespaceNames, caveatNames []string
+	for _, persistedKey := range persistedKeys {
+		prefix, name, ok := strings.Cut(string(persistedKey), ":")
+		if !ok {
+			continue
+		}
+
+		switch prefix {
+		case namespaceCacheKeyPrefix:
+			namespaceNames = append(namespaceNames, name)
+		case caveatCacheKeyPrefix:
+			caveatNames = append(caveatNames, name)
+		}
+	}
+
+	rev, err := pc.ds.OptimizedRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reader := pc.ds.SnapshotReader(rev)
+
+	entries := make([]warmstart.Entry[cache.StringKey, CacheEntry], 0, len(namespaceNames)+len(caveatNames))
+	if len(namespaceNames) > 0 {
+		namespaces, err := reader.LookupNamespacesWithNames(ctx, namespaceNames)
+		if err != nil {
+			return nil, err
+		}
+		entries = appendLoadedEntries(entries, namespaceCacheKeyPrefix, rev, namespaces, estimatedNamespaceDefinitionSize)
+	}
+
+	if len(caveatNames) > 0 {
+		caveats, err := reader.LookupCaveatsWithNames(ctx, caveatNames)
+		if err != nil {
+			return nil, err
+		}
+		entries = appendLoadedEntries(entries, caveatCacheKeyPrefix, rev, caveats, estimatedCaveatDefinitionSize)
+	}
+
+	return entries, nil
+}
+
+func appendLoadedEntries[T schemaDefinition](
+	entries []warmstart.Entry[cache.StringKey, CacheEntry],
+	prefix string,
+	rev datastore.Revision,
+	defs []datastore.RevisionedDefinition[T],
+	estimator func(sizeVT int) int64,
+) []warmstart.Entry[cache.StringKey, CacheEntry] {
+	for _, def := range defs {
+		entry := &cacheEntry{def.Definition, def.LastWrittenRevision, estimator(def.Definition.SizeVT()), nil}
+		entries = append(entries, warmstart.Entry[cache.StringKey, CacheEntry]{
+			Key:   cache.StringKey(prefix + ":" + def.Definition.GetName() + "@" + rev.String()),
+			Value: entry,
+			Cost:  entry.Size(),
+		})
+	}
+	return entries
+}
@@ -0,0 +1,72 @@
+package schemacaching
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/pkg/cache"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/testutil"
+)
+
+func TestPersistenceCodec(t *testing.T) {
+	ds, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ds.Close() })
+
+	namespace := &core.NamespaceDefinition{Name: "document"}
+	caveat := &core.CaveatDefinition{Name: "somecaveat", SerializedExpression: []byte("expression")}
+
+	ctx := context.Background()
+	written, err := ds.ReadWriteTx(ctx, func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		if err := rwt.WriteNamespaces(ctx, namespace); err != nil {
+			return err
+		}
+		return rwt.WriteCaveats(ctx, []*core.CaveatDefinition{caveat})
+	})
+	require.NoError(t, err)
+
+	codec := NewPersistenceCodec(ds)
+
+	// Keys are persisted without the revision at which the definitions were read.
+	persistedKey, ok := codec.PersistedKey(cache.StringKey(namespaceCacheKeyPrefix + ":document@" + written.String()))
+	require.True(t, ok)
+	require.Equal(t, namespaceCacheKeyPrefix+":document", string(persistedKey))
+
+	_, ok = codec.PersistedKey("malformed")
+	require.False(t, ok)
+
+	entries, err := codec.Load(ctx, [][]byte{
+		[]byte(namespaceCacheKeyPrefix + ":document"),
+		[]byte(caveatCacheKeyPrefix + ":somecaveat"),
+		[]byte(namespaceCacheKeyPrefix + ":missing"),
+		[]byte("malformed"),
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// Definitions are loaded under the keys at which they are read at the optimized revision.
+	requireLoadedAtOptimizedRevision := func(expectedPrefix string, key cache.StringKey) {
+		prefix, revision, ok := strings.Cut(string(key), "@")
+		require.True(t, ok)
+		require.Equal(t, expectedPrefix, prefix)
+
+		rev, err := ds.RevisionFromString(revision)
+		require.NoError(t, err)
+		require.False(t, rev.LessThan(written))
+	}
+
+	requireLoadedAtOptimizedRevision(namespaceCacheKeyPrefix+":document", entries[0].Key)
+	testutil.RequireProtoEqual(t, namespace, entries[0].Value.definition.(*core.NamespaceDefinition), "definitions differ")
+	require.True(t, written.Equal(entries[0].Value.updated))
+	require.Equal(t, entries[0].Value.Size(), entries[0].Cost)
+
+	requireLoadedAtOptimizedRevision(caveatCacheKeyPrefix+":somecaveat", entries[1].Key)
+	testutil.RequireProtoEqual(t, caveat, entries[1].Value.definition.(*core.CaveatDefinition), "definitions differ")
+	require.True(t, written.Equal(entries[1].Value.updated))
+}
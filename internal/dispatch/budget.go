@@ -0,0 +1,166 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/pkg/spiceerrors"
+)
+
+// DispatchBudgetMetadataKey is the metadata key under which the remaining dispatch budget of a
+// request is sent along with the requests dispatched to other nodes, so that their sub-dispatches
+// are bounded by it.
+const DispatchBudgetMetadataKey = "x-spicedb-dispatch-budget-remaining"
+
+type dispatchBudgetKey struct{}
+
+// DispatchBudget is the maximum number of dispatches which may be performed to answer a single
+// request, shared by all of its dispatches. It is enforced exactly for the dispatches performed on
+// a single node, but only approximately across nodes: see OutgoingContextWithDispatchBudget.
+type DispatchBudget struct {
+	limit uint32
+	used  atomic.Uint64
+}
+
+// ContextWithDispatchBudget returns a context in which the dispatches of a request are limited to
+// the given number. A limit of zero does not limit dispatches.
+func ContextWithDispatchBudget(ctx context.Context, limit uint32) context.Context {
+	if limit == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, dispatchBudgetKey{}, &DispatchBudget{limit: limit})
+}
+
+// DispatchBudgetFromContext returns the dispatch budget of the request, if any.
+func DispatchBudgetFromContext(ctx context.Context) *DispatchBudget {
+	budget, _ := ctx.Value(dispatchBudgetKey{}).(*DispatchBudget)
+	return budget
+}
+
+// Limit returns the maximum number of dispatches of the request.
+func (b *DispatchBudget) Limit() uint32 {
+	return b.limit
+}
+
+// Used returns the number of dispatches performed so far.
+func (b *DispatchBudget) Used() uint32 {
+	return uint32(min(b.used.Load(), uint64(^uint32(0))))
+}
+
+// Remaining returns the number of dispatches which may still be performed.
+func (b *DispatchBudget) Remaining() uint32 {
+	used := b.used.Load()
+	if used >= uint64(b.limit) {
+		return 0
+	}
+	return b.limit - uint32(used)
+}
+
+// Charge records dispatches performed elsewhere, such as by another node, against the budget.
+func (b *DispatchBudget) Charge(dispatchCount uint32) {
+	b.used.Add(uint64(dispatchCount))
+}
+
+// ConsumeDispatchBudget records a dispatch against the budget of the request, if any, returning a
+// DispatchBudgetExceededError if the budget is exhausted.
+func ConsumeDispatchBudget(ctx context.Context) error {
+	budget := DispatchBudgetFromContext(ctx)
+	if budget == nil {
+		return nil
+	}
+
+	used := budget.used.Add(1)
+	if used > uint64(budget.limit) {
+		return NewDispatchBudgetExceededError(budget.limit, uint32(min(used-1, uint64(^uint32(0)))))
+	}
+	return nil
+}
+
+// CheckDispatchBudget returns a DispatchBudgetExceededError if the dispatch budget of the request,
+// if any, is exhausted, without recording a dispatch.
+func CheckDispatchBudget(ctx context.Context) error {
+	budget := DispatchBudgetFromContext(ctx)
+	if budget == nil || budget.Remaining() > 0 {
+		return nil
+	}
+	return NewDispatchBudgetExceededError(budget.limit, budget.Used())
+}
+
+// OutgoingContextWithDispatchBudget adds the remaining dispatch budget of the request, if any, to
+// the outgoing metadata of a request dispatched to another node.
+//
+// NOTE: the number of concurrent dispatches is not known when each is sent, so every concurrent
+// dispatch to another node is given the full remaining budget, and their dispatches are only
+// charged against the budget once they return. A request fanning out across nodes may therefore
+// perform up to the remaining budget on each of its in-flight remote dispatches, and the budget
+// bounds the work of a request approximately rather than exactly. Splitting the budget evenly
+// would instead require knowing every sibling up front, and halving it per dispatch would shrink
+// it exponentially along a chain of dispatches.
+func OutgoingContextWithDispatchBudget(ctx context.Context) context.Context {
+	budget := DispatchBudgetFromContext(ctx)
+	if budget == nil {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, DispatchBudgetMetadataKey, strconv.FormatUint(uint64(budget.Remaining()), 10))
+}
+
+// ContextWithIncomingDispatchBudget returns a context limited by the dispatch budget received from
+// the node which dispatched the request, if any.
+func ContextWithIncomingDispatchBudget(ctx context.Context) (context.Context, error) {
+	values := metadata.ValueFromIncomingContext(ctx, DispatchBudgetMetadataKey)
+	if len(values) == 0 {
+		return ctx, nil
+	}
+
+	remaining, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil {
+		return ctx, status.Errorf(codes.InvalidArgument, "invalid dispatch budget `%s`: %s", values[0], err)
+	}
+	if remaining == 0 {
+		return ctx, NewDispatchBudgetExceededError(0, 0)
+	}
+	return ContextWithDispatchBudget(ctx, uint32(remaining)), nil
+}
+
+// DispatchBudgetExceededError is an error returned when a request has performed more dispatches
+// than allowed by its dispatch budget.
+type DispatchBudgetExceededError struct {
+	error
+
+	// Limit is the dispatch budget of the request.
+	Limit uint32
+
+	// DispatchCount is the number of dispatches performed before the budget was exhausted.
+	DispatchCount uint32
+}
+
+// NewDispatchBudgetExceededError creates a new DispatchBudgetExceededError.
+func NewDispatchBudgetExceededError(limit uint32, dispatchCount uint32) error {
+	return DispatchBudgetExceededError{
+		fmt.Errorf("the request has exceeded its dispatch budget of %d dispatches after performing %d dispatches: this usually indicates a very broad or expensive query", limit, dispatchCount),
+		limit,
+		dispatchCount,
+	}
+}
+
+// GRPCStatus implements retrieving the gRPC status for the error.
+func (err DispatchBudgetExceededError) GRPCStatus() *status.Status {
+	return spiceerrors.WithCodeAndDetails(
+		err,
+		codes.ResourceExhausted,
+		spiceerrors.ForReason(
+			v1.ErrorReason_ERROR_REASON_UNSPECIFIED,
+			map[string]string{
+				"dispatch_budget": strconv.FormatUint(uint64(err.Limit), 10),
+				"dispatch_count":  strconv.FormatUint(uint64(err.DispatchCount), 10),
+			},
+		),
+	)
+}
@@ -0,0 +1,61 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestDispatchBudget(t *testing.T) {
+	ctx := context.Background()
+	require.Nil(t, DispatchBudgetFromContext(ContextWithDispatchBudget(ctx, 0)))
+	require.NoError(t, ConsumeDispatchBudget(ctx))
+	require.NoError(t, CheckDispatchBudget(ctx))
+
+	ctx = ContextWithDispatchBudget(ctx, 3)
+	budget := DispatchBudgetFromContext(ctx)
+	require.NotNil(t, budget)
+
+	require.NoError(t, ConsumeDispatchBudget(ctx))
+	budget.Charge(1)
+	require.Equal(t, uint32(2), budget.Used())
+	require.Equal(t, uint32(1), budget.Remaining())
+	require.NoError(t, CheckDispatchBudget(ctx))
+
+	require.NoError(t, ConsumeDispatchBudget(ctx))
+	require.Error(t, CheckDispatchBudget(ctx))
+
+	err := ConsumeDispatchBudget(ctx)
+	var budgetErr DispatchBudgetExceededError
+	require.True(t, errors.As(err, &budgetErr))
+	require.Equal(t, uint32(3), budgetErr.Limit)
+	require.Equal(t, uint32(3), budgetErr.DispatchCount)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestDispatchBudgetPropagation(t *testing.T) {
+	ctx := ContextWithDispatchBudget(context.Background(), 10)
+	DispatchBudgetFromContext(ctx).Charge(4)
+
+	outgoing, _ := metadata.FromOutgoingContext(OutgoingContextWithDispatchBudget(ctx))
+	incoming, err := ContextWithIncomingDispatchBudget(metadata.NewIncomingContext(context.Background(), outgoing))
+	require.NoError(t, err)
+	require.Equal(t, uint32(6), DispatchBudgetFromContext(incoming).Limit())
+
+	// Requests without a budget are not limited.
+	incoming, err = ContextWithIncomingDispatchBudget(context.Background())
+	require.NoError(t, err)
+	require.Nil(t, DispatchBudgetFromContext(incoming))
+
+	// Exhausted and invalid budgets are refused.
+	_, err = ContextWithIncomingDispatchBudget(metadata.NewIncomingContext(context.Background(), metadata.Pairs(DispatchBudgetMetadataKey, "0")))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = ContextWithIncomingDispatchBudget(metadata.NewIncomingContext(context.Background(), metadata.Pairs(DispatchBudgetMetadataKey, "lots")))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
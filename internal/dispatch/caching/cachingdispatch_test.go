@@ -183,3 +183,27 @@ func (ddm delegateDispatchMock) ReadyState() dispatch.ReadyState {
 }
 
 var _ dispatch.Dispatcher = &delegateDispatchMock{}
+
+func TestEncodeCachedResult(t *testing.T) {
+	for _, result := range []any{
+		[]byte{},
+		[]byte("check result"),
+		[][]byte{},
+		[][]byte{{}, []byte("a"), []byte("second result")},
+	} {
+		encoded, ok := EncodeCachedResult(result)
+		require.True(t, ok)
+
+		decoded, _, err := DecodeCachedResult(encoded)
+		require.NoError(t, err)
+		require.Equal(t, result, decoded)
+	}
+
+	_, ok := EncodeCachedResult("unsupported")
+	require.False(t, ok)
+
+	for _, malformed := range [][]byte{nil, {0x05}, {0x02, 0x02, 0x01, 'a'}, {0x02, 0x01, 0x05, 'a'}} {
+		_, _, err := DecodeCachedResult(malformed)
+		require.Error(t, err)
+	}
+}
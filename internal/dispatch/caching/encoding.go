@@ -0,0 +1,92 @@
+package caching
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// resultKind identifies the type of an encoded cached result.
+type resultKind byte
+
+const (
+	// singleResult is the result of a unary dispatch, cached as []byte.
+	singleResult resultKind = iota + 1
+
+	// streamedResults are the results of a streaming dispatch, cached as [][]byte.
+	streamedResults
+)
+
+var errMalformedResult = errors.New("malformed cached dispatch result")
+
+// EncodeCachedResult encodes a result, as cached by the Dispatcher, so that it can be shared
+// with other nodes. Returns false if the value is not a cached result.
+func EncodeCachedResult(value any) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		encoded := make([]byte, 0, 1+len(v))
+		encoded = append(encoded, byte(singleResult))
+		return append(encoded, v...), true
+
+	case [][]byte:
+		size := 1 + binary.MaxVarintLen64
+		for _, slice := range v {
+			size += binary.MaxVarintLen64 + len(slice)
+		}
+
+		encoded := make([]byte, 0, size)
+		encoded = append(encoded, byte(streamedResults))
+		encoded = binary.AppendUvarint(encoded, uint64(len(v)))
+		for _, slice := range v {
+			encoded = binary.AppendUvarint(encoded, uint64(len(slice)))
+			encoded = append(encoded, slice...)
+		}
+		return encoded, true
+
+	default:
+		return nil, false
+	}
+}
+
+// DecodeCachedResult decodes a result encoded by EncodeCachedResult, returning it along with its
+// cost in the cache.
+func DecodeCachedResult(encoded []byte) (any, int64, error) {
+	if len(encoded) == 0 {
+		return nil, 0, errMalformedResult
+	}
+
+	switch resultKind(encoded[0]) {
+	case singleResult:
+		result := encoded[1:]
+		return result, sliceSize(result), nil
+
+	case streamedResults:
+		remaining := encoded[1:]
+		count, n := binary.Uvarint(remaining)
+		if n <= 0 || count > uint64(len(remaining)) {
+			return nil, 0, errMalformedResult
+		}
+		remaining = remaining[n:]
+
+		var size int64
+		results := make([][]byte, 0, count)
+		for i := uint64(0); i < count; i++ {
+			length, n := binary.Uvarint(remaining)
+			if n <= 0 || length > uint64(len(remaining)-n) {
+				return nil, 0, errMalformedResult
+			}
+			remaining = remaining[n:]
+			results = append(results, remaining[:length])
+			size += sliceSize(remaining[:length])
+			remaining = remaining[length:]
+		}
+
+		if len(remaining) > 0 {
+			return nil, 0, errMalformedResult
+		}
+		return results, size, nil
+
+	default:
+		return nil, 0, fmt.Errorf("%w: unknown kind %d", errMalformedResult, encoded[0])
+	}
+}
@@ -19,6 +19,7 @@ type optionState struct {
 	prometheusSubsystem   string
 	cache                 cache.Cache[keys.DispatchCacheKey, any]
 	concurrencyLimits     graph.ConcurrencyLimits
+	adaptiveLimits        *graph.AdaptiveConcurrencyLimits
 	remoteDispatchTimeout time.Duration
 	dispatchChunkSize     uint16
 }
@@ -51,6 +52,14 @@ func ConcurrencyLimits(limits graph.ConcurrencyLimits) Option {
 	}
 }
 
+// AdaptiveConcurrencyLimits sets concurrency limits which adapt to the latency
+// of the datastore and to redispatch errors, replacing ConcurrencyLimits.
+func AdaptiveConcurrencyLimits(limits *graph.AdaptiveConcurrencyLimits) Option {
+	return func(state *optionState) {
+		state.adaptiveLimits = limits
+	}
+}
+
 // DispatchChunkSize sets the maximum number of items to be dispatched in a single dispatch request
 func DispatchChunkSize(dispatchChunkSize uint16) Option {
 	return func(state *optionState) {
@@ -80,7 +89,7 @@ func NewClusterDispatcher(dispatch dispatch.Dispatcher, options ...Option) (disp
 		chunkSize = 100
 		log.Warn().Msgf("ClusterDispatcher: dispatchChunkSize not set, defaulting to %d", chunkSize)
 	}
-	clusterDispatch := graph.NewDispatcher(dispatch, opts.concurrencyLimits, opts.dispatchChunkSize)
+	clusterDispatch := newGraphDispatcher(dispatch, opts)
 
 	if opts.prometheusSubsystem == "" {
 		opts.prometheusSubsystem = "dispatch"
@@ -93,3 +102,10 @@ func NewClusterDispatcher(dispatch dispatch.Dispatcher, options ...Option) (disp
 	cachingClusterDispatch.SetDelegate(clusterDispatch)
 	return cachingClusterDispatch, nil
 }
+
+func newGraphDispatcher(redispatcher dispatch.Dispatcher, opts optionState) dispatch.Dispatcher {
+	if opts.adaptiveLimits != nil {
+		return graph.NewDispatcherWithAdaptiveLimits(redispatcher, opts.adaptiveLimits, opts.dispatchChunkSize)
+	}
+	return graph.NewDispatcher(redispatcher, opts.concurrencyLimits, opts.dispatchChunkSize)
+}
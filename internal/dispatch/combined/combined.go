@@ -15,6 +15,7 @@ import (
 	"github.com/zapravila/spicedb/internal/dispatch/caching"
 	"github.com/zapravila/spicedb/internal/dispatch/graph"
 	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	"github.com/zapravila/spicedb/internal/dispatch/peercache"
 	"github.com/zapravila/spicedb/internal/dispatch/remote"
 	"github.com/zapravila/spicedb/internal/dispatch/singleflight"
 	"github.com/zapravila/spicedb/internal/grpchelpers"
@@ -35,10 +36,17 @@ type optionState struct {
 	grpcDialOpts           []grpc.DialOption
 	cache                  cache.Cache[keys.DispatchCacheKey, any]
 	concurrencyLimits      graph.ConcurrencyLimits
+	adaptiveLimits         *graph.AdaptiveConcurrencyLimits
 	remoteDispatchTimeout  time.Duration
 	secondaryUpstreamAddrs map[string]string
 	secondaryUpstreamExprs map[string]string
+	secondaryUpstreamZones map[string]string
+	zone                   string
+	crossZoneLatencyBudget time.Duration
+	zoneLatencyBudgets     map[string]time.Duration
 	dispatchChunkSize      uint16
+	sharedCacheEnabled     bool
+	sharedCacheTimeout     time.Duration
 }
 
 // MetricsEnabled enables issuing prometheus metrics
@@ -87,6 +95,38 @@ func SecondaryUpstreamExprs(addrs map[string]string) Option {
 	}
 }
 
+// SecondaryUpstreamZones sets the zone of each named secondary upstream, so
+// that the secondary upstreams in the same zone as this node are preferred.
+func SecondaryUpstreamZones(zones map[string]string) Option {
+	return func(state *optionState) {
+		state.secondaryUpstreamZones = zones
+	}
+}
+
+// Zone sets the zone of this node and of its upstream.
+func Zone(zone string) Option {
+	return func(state *optionState) {
+		state.zone = zone
+	}
+}
+
+// CrossZoneLatencyBudget sets the duration to wait for the upstreams of this
+// node's zone before also dispatching to the secondary upstreams of other
+// zones.
+func CrossZoneLatencyBudget(budget time.Duration) Option {
+	return func(state *optionState) {
+		state.crossZoneLatencyBudget = budget
+	}
+}
+
+// ZoneLatencyBudgets overrides the cross-zone latency budget for the
+// secondary upstreams of specific zones.
+func ZoneLatencyBudgets(budgets map[string]time.Duration) Option {
+	return func(state *optionState) {
+		state.zoneLatencyBudgets = budgets
+	}
+}
+
 // GrpcPresharedKey sets the preshared key used to authenticate for optional
 // cluster dispatching.
 func GrpcPresharedKey(key string) Option {
@@ -110,6 +150,23 @@ func Cache(c cache.Cache[keys.DispatchCacheKey, any]) Option {
 	}
 }
 
+// SharedCache enables looking up and storing cached dispatch results in the
+// shared tier of the dispatch cache, held by the nodes of the upstream cluster.
+// Has no effect without an upstream.
+func SharedCache(enabled bool) Option {
+	return func(state *optionState) {
+		state.sharedCacheEnabled = enabled
+	}
+}
+
+// SharedCacheLookupTimeout sets the maximum duration of a lookup in the shared
+// tier of the dispatch cache, after which it is treated as a miss.
+func SharedCacheLookupTimeout(timeout time.Duration) Option {
+	return func(state *optionState) {
+		state.sharedCacheTimeout = timeout
+	}
+}
+
 // ConcurrencyLimits sets the max number of goroutines per operation
 func ConcurrencyLimits(limits graph.ConcurrencyLimits) Option {
 	return func(state *optionState) {
@@ -117,6 +174,14 @@ func ConcurrencyLimits(limits graph.ConcurrencyLimits) Option {
 	}
 }
 
+// AdaptiveConcurrencyLimits sets concurrency limits which adapt to the latency
+// of the datastore and to redispatch errors, replacing ConcurrencyLimits.
+func AdaptiveConcurrencyLimits(limits *graph.AdaptiveConcurrencyLimits) Option {
+	return func(state *optionState) {
+		state.adaptiveLimits = limits
+	}
+}
+
 // DispatchChunkSize sets the maximum number of items to be dispatched in a single dispatch request
 func DispatchChunkSize(dispatchChunkSize uint16) Option {
 	return func(state *optionState) {
@@ -145,20 +210,8 @@ func NewDispatcher(options ...Option) (dispatch.Dispatcher, error) {
 		opts.prometheusSubsystem = "dispatch_client"
 	}
 
-	cachingRedispatch, err := caching.NewCachingDispatcher(opts.cache, opts.metricsEnabled, opts.prometheusSubsystem, &keys.CanonicalKeyHandler{})
-	if err != nil {
-		return nil, err
-	}
-
-	chunkSize := opts.dispatchChunkSize
-	if chunkSize == 0 {
-		chunkSize = 100
-		log.Warn().Msgf("CombinedDispatcher: dispatchChunkSize not set, defaulting to %d", chunkSize)
-	}
-	redispatch := graph.NewDispatcher(cachingRedispatch, opts.concurrencyLimits, chunkSize)
-	redispatch = singleflight.New(redispatch, &keys.CanonicalKeyHandler{})
-
-	// If an upstream is specified, create a cluster dispatcher.
+	// If an upstream is specified, connect to the cluster.
+	var conn *grpc.ClientConn
 	if opts.upstreamAddr != "" {
 		if opts.upstreamCAPath != "" {
 			customCertOpt, err := grpcutil.WithCustomCerts(grpcutil.VerifyCA, opts.upstreamCAPath)
@@ -174,10 +227,56 @@ func NewDispatcher(options ...Option) (dispatch.Dispatcher, error) {
 
 		opts.grpcDialOpts = append(opts.grpcDialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor("s2")))
 
-		conn, err := grpchelpers.Dial(context.Background(), opts.upstreamAddr, opts.grpcDialOpts...)
+		var err error
+		conn, err = grpchelpers.Dial(context.Background(), opts.upstreamAddr, opts.grpcDialOpts...)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	// Requests are dispatched to the node owning them, which caches them itself, so the shared
+	// tier is only looked up for requests evaluated locally when their owners have been ejected.
+	dispatchCache := opts.cache
+	var peerCache *peercache.Cache
+	if conn != nil && opts.sharedCacheEnabled {
+		peerCacheOptions := []peercache.Option{}
+		if opts.sharedCacheTimeout > 0 {
+			peerCacheOptions = append(peerCacheOptions, peercache.LookupTimeout(opts.sharedCacheTimeout))
+		}
+
+		var err error
+		peerCache, err = peercache.NewCache(dispatchCache, peercache.NewClient(conn), peerCacheOptions...)
+		if err != nil {
+			return nil, err
+		}
+		dispatchCache = peerCache.WithoutSharedLookups()
+	}
+
+	cachingRedispatch, err := caching.NewCachingDispatcher(dispatchCache, opts.metricsEnabled, opts.prometheusSubsystem, &keys.CanonicalKeyHandler{})
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := opts.dispatchChunkSize
+	if chunkSize == 0 {
+		chunkSize = 100
+		log.Warn().Msgf("CombinedDispatcher: dispatchChunkSize not set, defaulting to %d", chunkSize)
+	}
+	var redispatch dispatch.Dispatcher
+	if opts.adaptiveLimits != nil {
+		redispatch = graph.NewDispatcherWithAdaptiveLimits(cachingRedispatch, opts.adaptiveLimits, chunkSize)
+	} else {
+		redispatch = graph.NewDispatcher(cachingRedispatch, opts.concurrencyLimits, chunkSize)
+	}
+	redispatch = singleflight.New(redispatch, &keys.CanonicalKeyHandler{})
+
+	// If an upstream is specified, create a cluster dispatcher.
+	if conn != nil {
+		for name := range opts.secondaryUpstreamZones {
+			if _, ok := opts.secondaryUpstreamAddrs[name]; !ok {
+				return nil, fmt.Errorf("zone configured for unknown secondary upstream `%s`", name)
+			}
+		}
 
 		secondaryClients := make(map[string]remote.SecondaryDispatch, len(opts.secondaryUpstreamAddrs))
 		for name, addr := range opts.secondaryUpstreamAddrs {
@@ -188,6 +287,7 @@ func NewDispatcher(options ...Option) (dispatch.Dispatcher, error) {
 			secondaryClients[name] = remote.SecondaryDispatch{
 				Name:   name,
 				Client: v1.NewDispatchServiceClient(secondaryConn),
+				Zone:   opts.secondaryUpstreamZones[name],
 			}
 		}
 
@@ -200,9 +300,23 @@ func NewDispatcher(options ...Option) (dispatch.Dispatcher, error) {
 			secondaryExprs[name] = parsed
 		}
 
+		localFallback := redispatch
+		if peerCache != nil {
+			cachingFallback, err := caching.NewCachingDispatcher(peerCache, false, opts.prometheusSubsystem, &keys.CanonicalKeyHandler{})
+			if err != nil {
+				return nil, err
+			}
+			cachingFallback.SetDelegate(redispatch)
+			localFallback = cachingFallback
+		}
+
 		redispatch = remote.NewClusterDispatcher(v1.NewDispatchServiceClient(conn), conn, remote.ClusterDispatcherConfig{
 			KeyHandler:             &keys.CanonicalKeyHandler{},
 			DispatchOverallTimeout: opts.remoteDispatchTimeout,
+			LocalFallback:          localFallback,
+			Zone:                   opts.zone,
+			CrossZoneLatencyBudget: opts.crossZoneLatencyBudget,
+			ZoneLatencyBudgets:     opts.zoneLatencyBudgets,
 		}, secondaryClients, secondaryExprs)
 		redispatch = singleflight.New(redispatch, &keys.CanonicalKeyHandler{})
 	}
@@ -37,3 +37,18 @@ func (err MaxDepthExceededError) GRPCStatus() *status.Status {
 		),
 	)
 }
+
+// SubjectRelationsSupportedMetadataKey is the header metadata key with which a node indicates that
+// it has handled the additional subject relations of a dispatched LookupSubjects request. Nodes
+// predating them ignore the additional relations, so their results would be silently incomplete.
+const SubjectRelationsSupportedMetadataKey = "x-spicedb-dispatch-subject-relations-supported"
+
+// NewSubjectRelationsUnsupportedError creates an error indicating that a LookupSubjects request
+// for multiple subject relations was dispatched to a node which does not support them.
+func NewSubjectRelationsUnsupportedError() error {
+	return spiceerrors.WithCodeAndReason(
+		fmt.Errorf("the dispatched node does not support looking up subjects of multiple types; retry once all nodes have been upgraded"),
+		codes.FailedPrecondition,
+		v1.ErrorReason_ERROR_REASON_UNSPECIFIED,
+	)
+}
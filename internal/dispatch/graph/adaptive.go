@@ -0,0 +1,353 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/graph"
+	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore/options"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+)
+
+const (
+	// DefaultAdaptiveMinLimit is the default floor of an adaptive concurrency limit.
+	DefaultAdaptiveMinLimit = 4
+
+	// DefaultAdaptiveMaxLimit is the default ceiling of an adaptive concurrency limit.
+	DefaultAdaptiveMaxLimit = 200
+
+	// DefaultAdaptiveLatencyThreshold is the default datastore query latency above which an
+	// adaptive concurrency limit is decreased.
+	DefaultAdaptiveLatencyThreshold = 100 * time.Millisecond
+
+	// DefaultAdaptiveBackoffRatio is the default factor by which an adaptive concurrency limit is
+	// multiplied when decreased.
+	DefaultAdaptiveBackoffRatio = 0.9
+
+	// minDecreaseInterval is the minimum interval between decreases of an adaptive concurrency
+	// limit, so that a burst of samples caused by a single overload does not collapse the limit
+	// to its floor.
+	minDecreaseInterval = 100 * time.Millisecond
+
+	reasonLatency = "latency"
+	reasonError   = "error"
+)
+
+var (
+	adaptiveLimitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "adaptive_concurrency_limit",
+		Help:      "current adaptive concurrency limit, by dispatch type",
+	}, []string{"dispatch_type"})
+
+	adaptiveLimitDecreasesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "adaptive_concurrency_limit_decreases_total",
+		Help:      "number of decreases of an adaptive concurrency limit, by dispatch type and reason",
+	}, []string{"dispatch_type", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(adaptiveLimitGauge, adaptiveLimitDecreasesCounter)
+}
+
+// AdaptiveConcurrencyConfig configures concurrency limits which adapt to the latency of the
+// datastore and to errors returned by redispatches, using additive-increase/multiplicative-decrease.
+//
+//go:generate go run github.com/ecordell/optgen -output zz_generated.adaptive.options.go . AdaptiveConcurrencyConfig
+type AdaptiveConcurrencyConfig struct {
+	Enabled          bool          `debugmap:"visible"`
+	MinLimit         uint16        `debugmap:"visible"`
+	MaxLimit         uint16        `debugmap:"visible"`
+	LatencyThreshold time.Duration `debugmap:"visible"`
+	BackoffRatio     float64       `debugmap:"visible"`
+}
+
+func (ac AdaptiveConcurrencyConfig) withDefaults() AdaptiveConcurrencyConfig {
+	if ac.MinLimit == 0 {
+		ac.MinLimit = DefaultAdaptiveMinLimit
+	}
+	if ac.MaxLimit == 0 {
+		ac.MaxLimit = DefaultAdaptiveMaxLimit
+	}
+	if ac.MaxLimit < ac.MinLimit {
+		ac.MaxLimit = ac.MinLimit
+	}
+	if ac.LatencyThreshold <= 0 {
+		ac.LatencyThreshold = DefaultAdaptiveLatencyThreshold
+	}
+	if ac.BackoffRatio <= 0 || ac.BackoffRatio >= 1 {
+		ac.BackoffRatio = DefaultAdaptiveBackoffRatio
+	}
+	return ac
+}
+
+// AdaptiveConcurrencyLimits holds an adaptive concurrency limit per dispatch type. A single
+// instance may be shared by all the dispatchers of a process, as they share its datastore.
+type AdaptiveConcurrencyLimits struct {
+	config             AdaptiveConcurrencyConfig
+	check              *AdaptiveLimiter
+	reachableResources *AdaptiveLimiter
+	lookupResources    *AdaptiveLimiter
+	lookupSubjects     *AdaptiveLimiter
+}
+
+// NewAdaptiveConcurrencyLimits creates adaptive concurrency limits starting from the given
+// limits, clamped to the configured floor and ceiling.
+func NewAdaptiveConcurrencyLimits(initial ConcurrencyLimits, config AdaptiveConcurrencyConfig) *AdaptiveConcurrencyLimits {
+	initial = limitsOrDefaults(initial, defaultConcurrencyLimit)
+	config = config.withDefaults()
+	return &AdaptiveConcurrencyLimits{
+		config:             config,
+		check:              NewAdaptiveLimiter("check", initial.Check, config),
+		reachableResources: NewAdaptiveLimiter("reachable_resources", initial.ReachableResources, config),
+		lookupResources:    NewAdaptiveLimiter("lookup_resources", initial.LookupResources, config),
+		lookupSubjects:     NewAdaptiveLimiter("lookup_subjects", initial.LookupSubjects, config),
+	}
+}
+
+// Current returns the current limits.
+func (al *AdaptiveConcurrencyLimits) Current() ConcurrencyLimits {
+	return ConcurrencyLimits{
+		Check:              al.check.Limit(),
+		ReachableResources: al.reachableResources.Limit(),
+		LookupResources:    al.lookupResources.Limit(),
+		LookupSubjects:     al.lookupSubjects.Limit(),
+	}
+}
+
+// Saturation returns the fraction by which the most backed off of the limits has decreased below
+// its initial limit, from 0 when no limit has decreased to 1.
+func (al *AdaptiveConcurrencyLimits) Saturation() float64 {
+	return max(
+		al.check.Saturation(),
+		al.reachableResources.Saturation(),
+		al.lookupResources.Saturation(),
+		al.lookupSubjects.Saturation(),
+	)
+}
+
+func (al *AdaptiveConcurrencyLimits) MarshalZerologObject(e *zerolog.Event) {
+	e.Object("current", al.Current())
+	e.Uint16("min-limit", al.config.MinLimit)
+	e.Uint16("max-limit", al.config.MaxLimit)
+	e.Dur("latency-threshold", al.config.LatencyThreshold)
+	e.Float64("backoff-ratio", al.config.BackoffRatio)
+}
+
+// AdaptiveLimiter is a graph.ConcurrencyLimiter whose limit is increased by about one for every
+// limit's worth of successful samples, and multiplied by the backoff ratio whenever a datastore
+// query is slower than the latency threshold or a dispatch fails due to overload.
+type AdaptiveLimiter struct {
+	dispatchType     string
+	minLimit         uint16
+	maxLimit         uint16
+	latencyThreshold time.Duration
+	backoffRatio     float64
+	initial          uint16
+
+	lock         sync.Mutex
+	limit        float64
+	lastDecrease time.Time
+
+	current atomic.Uint32
+}
+
+var _ graph.ConcurrencyLimiter = (*AdaptiveLimiter)(nil)
+
+// NewAdaptiveLimiter creates an AdaptiveLimiter for the given dispatch type, starting at the
+// initial limit.
+func NewAdaptiveLimiter(dispatchType string, initial uint16, config AdaptiveConcurrencyConfig) *AdaptiveLimiter {
+	config = config.withDefaults()
+	al := &AdaptiveLimiter{
+		dispatchType:     dispatchType,
+		minLimit:         config.MinLimit,
+		maxLimit:         config.MaxLimit,
+		latencyThreshold: config.LatencyThreshold,
+		backoffRatio:     config.BackoffRatio,
+	}
+	al.setLimit(math.Min(math.Max(float64(initial), float64(al.minLimit)), float64(al.maxLimit)))
+	al.initial = al.Limit()
+	return al
+}
+
+// Limit returns the current limit.
+func (al *AdaptiveLimiter) Limit() uint16 {
+	return uint16(al.current.Load())
+}
+
+// Saturation returns the fraction by which the limit has decreased below its initial limit, from 0
+// when it has not decreased to 1.
+func (al *AdaptiveLimiter) Saturation() float64 {
+	if al.initial == 0 {
+		return 0
+	}
+	return max(0, 1-float64(al.Limit())/float64(al.initial))
+}
+
+// Observe records a sample of work performed under the limit with the given context, which increases
+// the limit unless it failed due to overload or took longer than the latency threshold.
+func (al *AdaptiveLimiter) Observe(ctx context.Context, latency time.Duration, err error) {
+	var reason string
+	switch {
+	case isOverloadError(ctx, err):
+		reason = reasonError
+	case err != nil:
+		// Errors unrelated to load say nothing about the limit.
+		return
+	case latency > al.latencyThreshold:
+		reason = reasonLatency
+	}
+
+	al.lock.Lock()
+	defer al.lock.Unlock()
+
+	if reason == "" {
+		al.setLimit(math.Min(al.limit+1/al.limit, float64(al.maxLimit)))
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(al.lastDecrease) < minDecreaseInterval {
+		return
+	}
+	al.lastDecrease = now
+	al.setLimit(math.Max(al.limit*al.backoffRatio, float64(al.minLimit)))
+	adaptiveLimitDecreasesCounter.WithLabelValues(al.dispatchType, reason).Inc()
+}
+
+func (al *AdaptiveLimiter) setLimit(limit float64) {
+	al.limit = limit
+	if al.current.Swap(uint32(limit)) != uint32(limit) {
+		adaptiveLimitGauge.WithLabelValues(al.dispatchType).Set(float64(uint32(limit)))
+	}
+}
+
+// isOverloadError returns whether the error of work performed with the given context indicates
+// overload. Errors caused by the cancellation or deadline of the caller's context do not, as they
+// say nothing about the load of the datastore or the dispatched nodes.
+func isOverloadError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	// Timeouts only indicate overload if imposed by the datastore or the dispatched node itself,
+	// rather than inherited from the caller's deadline.
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		_, hasDeadline := ctx.Deadline()
+		return !hasDeadline
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// withObservedDatastore returns a context whose datastore reports the latency of relationship
+// queries to the limiter, if any.
+func withObservedDatastore(ctx context.Context, limiter *AdaptiveLimiter) context.Context {
+	if limiter == nil {
+		return ctx
+	}
+
+	ds := datastoremw.FromContext(ctx)
+	if ds == nil {
+		return ctx
+	}
+
+	// Replace rather than nest the observer of a parent dispatch made by this process.
+	if observed, ok := ds.(observedDatastore); ok {
+		ds = observed.Datastore
+	}
+	return datastoremw.ContextWithDatastore(ctx, observedDatastore{ds, limiter})
+}
+
+type observedDatastore struct {
+	datastore.Datastore
+	limiter *AdaptiveLimiter
+}
+
+func (od observedDatastore) SnapshotReader(rev datastore.Revision) datastore.Reader {
+	return observedReader{od.Datastore.SnapshotReader(rev), od.limiter}
+}
+
+type observedReader struct {
+	datastore.Reader
+	limiter *AdaptiveLimiter
+}
+
+func (r observedReader) QueryRelationships(ctx context.Context, filter datastore.RelationshipsFilter, opts ...options.QueryOptionsOption) (datastore.RelationshipIterator, error) {
+	start := time.Now()
+	it, err := r.Reader.QueryRelationships(ctx, filter, opts...)
+	r.limiter.Observe(ctx, time.Since(start), err)
+	return it, err
+}
+
+func (r observedReader) ReverseQueryRelationships(ctx context.Context, subjectsFilter datastore.SubjectsFilter, opts ...options.ReverseQueryOptionsOption) (datastore.RelationshipIterator, error) {
+	start := time.Now()
+	it, err := r.Reader.ReverseQueryRelationships(ctx, subjectsFilter, opts...)
+	r.limiter.Observe(ctx, time.Since(start), err)
+	return it, err
+}
+
+// observeRedispatchError reports the error, if any, of a redispatched request to the limiter. The
+// latency of redispatched requests is not observed, as it covers their entire subproblem.
+func observeRedispatchError(ctx context.Context, limiter *AdaptiveLimiter, err error) {
+	if err != nil {
+		limiter.Observe(ctx, 0, err)
+	}
+}
+
+// observedRedispatcher reports the errors of redispatched requests to the limiter of their
+// dispatch type.
+type observedRedispatcher struct {
+	dispatch.Dispatcher
+	limits *AdaptiveConcurrencyLimits
+}
+
+func (od observedRedispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
+	resp, err := od.Dispatcher.DispatchCheck(ctx, req)
+	observeRedispatchError(ctx, od.limits.check, err)
+	return resp, err
+}
+
+func (od observedRedispatcher) DispatchReachableResources(req *v1.DispatchReachableResourcesRequest, stream dispatch.ReachableResourcesStream) error {
+	err := od.Dispatcher.DispatchReachableResources(req, stream)
+	observeRedispatchError(stream.Context(), od.limits.reachableResources, err)
+	return err
+}
+
+func (od observedRedispatcher) DispatchLookupResources(req *v1.DispatchLookupResourcesRequest, stream dispatch.LookupResourcesStream) error {
+	err := od.Dispatcher.DispatchLookupResources(req, stream)
+	observeRedispatchError(stream.Context(), od.limits.lookupResources, err)
+	return err
+}
+
+func (od observedRedispatcher) DispatchLookupResources2(req *v1.DispatchLookupResources2Request, stream dispatch.LookupResources2Stream) error {
+	err := od.Dispatcher.DispatchLookupResources2(req, stream)
+	observeRedispatchError(stream.Context(), od.limits.lookupResources, err)
+	return err
+}
+
+func (od observedRedispatcher) DispatchLookupSubjects(req *v1.DispatchLookupSubjectsRequest, stream dispatch.LookupSubjectsStream) error {
+	err := od.Dispatcher.DispatchLookupSubjects(req, stream)
+	observeRedispatchError(stream.Context(), od.limits.lookupSubjects, err)
+	return err
+}
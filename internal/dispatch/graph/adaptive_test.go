@@ -0,0 +1,151 @@
+package graph
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/dispatch/caching"
+	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	log "github.com/zapravila/spicedb/internal/logging"
+	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/internal/testfixtures"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	t.Parallel()
+
+	config := AdaptiveConcurrencyConfig{
+		MinLimit:         4,
+		MaxLimit:         12,
+		LatencyThreshold: 10 * time.Millisecond,
+		BackoffRatio:     0.5,
+	}
+
+	// The initial limit is clamped to the floor and ceiling.
+	require.Equal(t, uint16(4), NewAdaptiveLimiter("test", 1, config).Limit())
+	require.Equal(t, uint16(12), NewAdaptiveLimiter("test", 100, config).Limit())
+
+	al := NewAdaptiveLimiter("test", 10, config)
+	require.Equal(t, uint16(10), al.Limit())
+
+	ctx := context.Background()
+
+	// A limit's worth of successful samples increases the limit by about one.
+	for range 11 {
+		al.Observe(ctx, time.Millisecond, nil)
+	}
+	require.Equal(t, uint16(11), al.Limit())
+
+	// Errors unrelated to load are ignored.
+	al.Observe(ctx, 0, status.Error(codes.NotFound, "not found"))
+	require.Equal(t, uint16(11), al.Limit())
+
+	// Overload errors decrease the limit, at most once per interval.
+	al.Observe(ctx, 0, status.Error(codes.Unavailable, "unavailable"))
+	require.Equal(t, uint16(5), al.Limit())
+	al.Observe(ctx, 0, context.DeadlineExceeded)
+	require.Equal(t, uint16(5), al.Limit())
+
+	// Errors due to the caller's own deadline or cancellation are ignored.
+	al.lastDecrease = time.Time{}
+	deadlineCtx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	al.Observe(deadlineCtx, 0, status.Error(codes.DeadlineExceeded, "deadline exceeded"))
+	require.Equal(t, uint16(5), al.Limit())
+
+	canceledCtx, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	al.Observe(canceledCtx, 0, status.Error(codes.Unavailable, "unavailable"))
+	require.Equal(t, uint16(5), al.Limit())
+
+	// Errors not caused by the caller's deadline still count.
+	other := NewAdaptiveLimiter("test", 10, config)
+	other.Observe(deadlineCtx, 0, status.Error(codes.ResourceExhausted, "exhausted"))
+	require.Equal(t, uint16(5), other.Limit())
+
+	// Slow samples decrease the limit, down to the floor.
+	al.lastDecrease = time.Time{}
+	al.Observe(ctx, time.Second, nil)
+	require.Equal(t, uint16(4), al.Limit())
+
+	// The limit never exceeds the ceiling.
+	for range 1000 {
+		al.Observe(ctx, 0, nil)
+	}
+	require.Equal(t, uint16(12), al.Limit())
+}
+
+func TestAdaptiveConcurrencyLimitsDefaults(t *testing.T) {
+	t.Parallel()
+
+	limits := NewAdaptiveConcurrencyLimits(ConcurrencyLimits{Check: 20}, AdaptiveConcurrencyConfig{})
+	require.Equal(t, ConcurrencyLimits{
+		Check:              20,
+		ReachableResources: defaultConcurrencyLimit,
+		LookupResources:    defaultConcurrencyLimit,
+		LookupSubjects:     defaultConcurrencyLimit,
+	}, limits.Current())
+	require.Equal(t, DefaultAdaptiveLatencyThreshold, limits.config.LatencyThreshold)
+	require.Equal(t, DefaultAdaptiveBackoffRatio, limits.config.BackoffRatio)
+}
+
+func TestAdaptiveDispatcherObservesDatastoreLatency(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name             string
+		latencyThreshold time.Duration
+		expectDecrease   bool
+	}{
+		{"fast datastore", time.Hour, false},
+		{"slow datastore", time.Nanosecond, true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+
+			rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+			require.NoError(err)
+			ds, revision := testfixtures.StandardDatastoreWithData(rawDS, require)
+
+			limits := NewAdaptiveConcurrencyLimits(SharedConcurrencyLimits(10), AdaptiveConcurrencyConfig{
+				LatencyThreshold: tc.latencyThreshold,
+			})
+
+			cachingDispatcher, err := caching.NewCachingDispatcher(caching.DispatchTestCache(t), false, "", &keys.CanonicalKeyHandler{})
+			require.NoError(err)
+			cachingDispatcher.SetDelegate(NewDispatcherWithAdaptiveLimits(cachingDispatcher, limits, 100))
+
+			ctx := log.Logger.WithContext(datastoremw.ContextWithHandle(context.Background()))
+			require.NoError(datastoremw.SetInContext(ctx, ds))
+
+			resp, err := cachingDispatcher.DispatchCheck(ctx, &v1.DispatchCheckRequest{
+				ResourceRelation: RR("document", "view"),
+				ResourceIds:      []string{"masterplan"},
+				ResultsSetting:   v1.DispatchCheckRequest_ALLOW_SINGLE_RESULT,
+				Subject:          tuple.ObjectAndRelation("user", "eng_lead", "..."),
+				Metadata: &v1.ResolverMeta{
+					AtRevision:     revision.String(),
+					DepthRemaining: 50,
+				},
+			})
+			require.NoError(err)
+			require.Equal(v1.ResourceCheckResult_MEMBER, resp.ResultsByResourceId["masterplan"].Membership)
+
+			if tc.expectDecrease {
+				require.Less(limits.Current().Check, uint16(10))
+			} else {
+				require.GreaterOrEqual(limits.Current().Check, uint16(10))
+			}
+		})
+	}
+}
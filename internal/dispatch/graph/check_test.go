@@ -182,6 +182,40 @@ func TestMaxDepth(t *testing.T) {
 	require.Error(err)
 }
 
+func TestDispatchBudget(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(err)
+
+	ds, _ := testfixtures.StandardDatastoreWithSchema(rawDS, require)
+
+	mutation := tuple.Create(tuple.Parse("folder:oops#parent@folder:oops"))
+
+	ctx := log.Logger.WithContext(datastoremw.ContextWithHandle(context.Background()))
+	require.NoError(datastoremw.SetInContext(ctx, ds))
+
+	revision, err := common.UpdateTuplesInDatastore(ctx, ds, mutation)
+	require.NoError(err)
+
+	dispatcher := NewLocalOnlyDispatcher(10, 100)
+
+	_, err = dispatcher.DispatchCheck(dispatch.ContextWithDispatchBudget(ctx, 5), &v1.DispatchCheckRequest{
+		ResourceRelation: RR("folder", "view"),
+		ResourceIds:      []string{"oops"},
+		ResultsSetting:   v1.DispatchCheckRequest_ALLOW_SINGLE_RESULT,
+		Subject:          ONR("user", "fake", graph.Ellipsis),
+		Metadata: &v1.ResolverMeta{
+			AtRevision:     revision.String(),
+			DepthRemaining: 50,
+		},
+	})
+
+	require.Error(err)
+	require.Contains(err.Error(), "exceeded its dispatch budget of 5 dispatches after performing 5 dispatches")
+}
+
 func TestCheckMetadata(t *testing.T) {
 	t.Parallel()
 	type expected struct {
@@ -1954,6 +1988,90 @@ func TestCheckHintsPartialApplicationOverArrow(t *testing.T) {
 	require.Equal(v1.ResourceCheckResult_MEMBER, resp.ResultsByResourceId["anotherdoc"].Membership)
 }
 
+func TestCheckHintsCollected(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	dispatcher := NewLocalOnlyDispatcher(10, 100)
+
+	ds, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(err)
+
+	ds, revision := testfixtures.DatastoreFromSchemaAndTestRelationships(ds, `
+		definition user {}
+
+		definition organization {
+			relation member: user
+		}
+
+		definition document {
+			relation org: organization
+			relation viewer: user
+			permission view = viewer + org->member
+		}
+
+	`, []*core.RelationTuple{
+		tuple.MustParse("document:somedoc#org@organization:someorg"),
+		tuple.MustParse("organization:someorg#member@user:tom"),
+	}, require)
+
+	ctx := datastoremw.ContextWithHandle(context.Background())
+	require.NoError(datastoremw.SetInContext(ctx, ds))
+
+	tom := ONR("user", "tom", graph.Ellipsis)
+	check := func(ctx context.Context, resourceID string, checkHints []*v1.CheckHint) *v1.DispatchCheckResponse {
+		resp, err := dispatcher.DispatchCheck(ctx, &v1.DispatchCheckRequest{
+			ResourceRelation: RR("document", "view"),
+			ResourceIds:      []string{resourceID},
+			Subject:          tom,
+			ResultsSetting:   v1.DispatchCheckRequest_ALLOW_SINGLE_RESULT,
+			Metadata: &v1.ResolverMeta{
+				AtRevision:     revision.String(),
+				DepthRemaining: 50,
+			},
+			CheckHints: checkHints,
+		})
+		require.NoError(err)
+		return resp
+	}
+
+	containsHint := func(checkHints []*v1.CheckHint, expected *v1.CheckHint) bool {
+		for _, hint := range checkHints {
+			if hint.EqualVT(expected) {
+				return true
+			}
+		}
+		return false
+	}
+
+	collector := graph.NewCheckHintCollector(graph.DefaultMaximumCollectedCheckHints)
+	resp := check(graph.ContextWithCheckHintCollector(ctx, collector), "somedoc", nil)
+	require.Equal(v1.ResourceCheckResult_MEMBER, resp.ResultsByResourceId["somedoc"].Membership)
+
+	collected := collector.Hints()
+	member := &v1.ResourceCheckResult{Membership: v1.ResourceCheckResult_MEMBER}
+	require.True(containsHint(collected, hints.CheckHintForComputedUserset("document", "somedoc", "view", tom, member)))
+	require.True(containsHint(collected, hints.CheckHintForArrow("document", "somedoc", "org", "member", tom, member)))
+	require.True(containsHint(collected, hints.CheckHintForComputedUserset("organization", "someorg", "member", tom, member)))
+
+	// Reusing the collected hints resolves the check without any further dispatches.
+	resp = check(ctx, "somedoc", collected)
+	require.Equal(v1.ResourceCheckResult_MEMBER, resp.ResultsByResourceId["somedoc"].Membership)
+	require.Equal(uint32(1), resp.Metadata.DispatchCount)
+
+	// Negative results are collected as well.
+	collector = graph.NewCheckHintCollector(graph.DefaultMaximumCollectedCheckHints)
+	resp = check(graph.ContextWithCheckHintCollector(ctx, collector), "anotherdoc", nil)
+	require.Empty(resp.ResultsByResourceId)
+	require.True(containsHint(collector.Hints(), hints.CheckHintForComputedUserset("document", "anotherdoc", "view", tom, &v1.ResourceCheckResult{
+		Membership: v1.ResourceCheckResult_NOT_MEMBER,
+	})))
+
+	resp = check(ctx, "anotherdoc", collector.Hints())
+	require.Empty(resp.ResultsByResourceId)
+	require.Equal(uint32(1), resp.Metadata.DispatchCount)
+}
+
 func newLocalDispatcherWithConcurrencyLimit(t testing.TB, concurrencyLimit uint16) (context.Context, dispatch.Dispatcher, datastore.Revision) {
 	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
 	require.NoError(t, err)
@@ -94,13 +94,7 @@ func NewLocalOnlyDispatcherWithLimits(concurrencyLimits ConcurrencyLimits, dispa
 		log.Warn().Msgf("LocalOnlyDispatcher: dispatchChunkSize not set, defaulting to %d", chunkSize)
 	}
 
-	d.checker = graph.NewConcurrentChecker(d, concurrencyLimits.Check, chunkSize)
-	d.expander = graph.NewConcurrentExpander(d)
-	d.reachableResourcesHandler = graph.NewCursoredReachableResources(d, concurrencyLimits.ReachableResources, chunkSize)
-	d.lookupResourcesHandler = graph.NewCursoredLookupResources(d, d, concurrencyLimits.LookupResources, chunkSize)
-	d.lookupSubjectsHandler = graph.NewConcurrentLookupSubjects(d, concurrencyLimits.LookupSubjects, chunkSize)
-	d.lookupResourcesHandler2 = graph.NewCursoredLookupResources2(d, d, concurrencyLimits.LookupResources, chunkSize)
-
+	d.setHandlers(d, staticLimiters(concurrencyLimits), chunkSize)
 	return d
 }
 
@@ -114,20 +108,45 @@ func NewDispatcher(redispatcher dispatch.Dispatcher, concurrencyLimits Concurren
 		log.Warn().Msgf("Dispatcher: dispatchChunkSize not set, defaulting to %d", chunkSize)
 	}
 
-	checker := graph.NewConcurrentChecker(redispatcher, concurrencyLimits.Check, chunkSize)
-	expander := graph.NewConcurrentExpander(redispatcher)
-	reachableResourcesHandler := graph.NewCursoredReachableResources(redispatcher, concurrencyLimits.ReachableResources, chunkSize)
-	lookupResourcesHandler := graph.NewCursoredLookupResources(redispatcher, redispatcher, concurrencyLimits.LookupResources, chunkSize)
-	lookupSubjectsHandler := graph.NewConcurrentLookupSubjects(redispatcher, concurrencyLimits.LookupSubjects, chunkSize)
-	lookupResourcesHandler2 := graph.NewCursoredLookupResources2(redispatcher, redispatcher, concurrencyLimits.LookupResources, chunkSize)
+	d := &localDispatcher{}
+	d.setHandlers(redispatcher, staticLimiters(concurrencyLimits), chunkSize)
+	return d
+}
 
-	return &localDispatcher{
-		checker:                   checker,
-		expander:                  expander,
-		reachableResourcesHandler: reachableResourcesHandler,
-		lookupResourcesHandler:    lookupResourcesHandler,
-		lookupSubjectsHandler:     lookupSubjectsHandler,
-		lookupResourcesHandler2:   lookupResourcesHandler2,
+// NewDispatcherWithAdaptiveLimits creates a dispatcher that consults with the graph and
+// redispatches subproblems to the provided redispatcher, with concurrency limits which adapt to
+// the latency of the datastore and to the errors returned by the redispatcher.
+func NewDispatcherWithAdaptiveLimits(redispatcher dispatch.Dispatcher, adaptiveLimits *AdaptiveConcurrencyLimits, dispatchChunkSize uint16) dispatch.Dispatcher {
+	chunkSize := dispatchChunkSize
+	if chunkSize == 0 {
+		chunkSize = 100
+		log.Warn().Msgf("Dispatcher: dispatchChunkSize not set, defaulting to %d", chunkSize)
+	}
+
+	d := &localDispatcher{adaptiveLimits: *adaptiveLimits}
+	d.setHandlers(observedRedispatcher{redispatcher, adaptiveLimits}, concurrencyLimiters{
+		check:              adaptiveLimits.check,
+		reachableResources: adaptiveLimits.reachableResources,
+		lookupResources:    adaptiveLimits.lookupResources,
+		lookupSubjects:     adaptiveLimits.lookupSubjects,
+	}, chunkSize)
+	return d
+}
+
+// concurrencyLimiters holds the concurrency limiter for each dispatch type.
+type concurrencyLimiters struct {
+	check              graph.ConcurrencyLimiter
+	reachableResources graph.ConcurrencyLimiter
+	lookupResources    graph.ConcurrencyLimiter
+	lookupSubjects     graph.ConcurrencyLimiter
+}
+
+func staticLimiters(limits ConcurrencyLimits) concurrencyLimiters {
+	return concurrencyLimiters{
+		check:              graph.StaticConcurrencyLimit(limits.Check),
+		reachableResources: graph.StaticConcurrencyLimit(limits.ReachableResources),
+		lookupResources:    graph.StaticConcurrencyLimit(limits.LookupResources),
+		lookupSubjects:     graph.StaticConcurrencyLimit(limits.LookupSubjects),
 	}
 }
 
@@ -138,6 +157,19 @@ type localDispatcher struct {
 	lookupResourcesHandler    *graph.CursoredLookupResources
 	lookupSubjectsHandler     *graph.ConcurrentLookupSubjects
 	lookupResourcesHandler2   *graph.CursoredLookupResources2
+
+	// adaptiveLimits receives the latency of the datastore queries made by dispatches, and is
+	// empty if the concurrency limits are static.
+	adaptiveLimits AdaptiveConcurrencyLimits
+}
+
+func (ld *localDispatcher) setHandlers(redispatcher dispatch.Dispatcher, limiters concurrencyLimiters, chunkSize uint16) {
+	ld.checker = graph.NewConcurrentChecker(redispatcher, limiters.check, chunkSize)
+	ld.expander = graph.NewConcurrentExpander(redispatcher)
+	ld.reachableResourcesHandler = graph.NewCursoredReachableResources(redispatcher, limiters.reachableResources, chunkSize)
+	ld.lookupResourcesHandler = graph.NewCursoredLookupResources(redispatcher, redispatcher, limiters.lookupResources, chunkSize)
+	ld.lookupSubjectsHandler = graph.NewConcurrentLookupSubjects(redispatcher, limiters.lookupSubjects, chunkSize)
+	ld.lookupResourcesHandler2 = graph.NewCursoredLookupResources2(redispatcher, redispatcher, limiters.lookupResources, chunkSize)
 }
 
 func (ld *localDispatcher) loadNamespace(ctx context.Context, nsName string, revision datastore.Revision) (*core.NamespaceDefinition, error) {
@@ -183,6 +215,7 @@ func (ld *localDispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCh
 		attribute.String("subject", tuple.StringONR(req.Subject)),
 	))
 	defer span.End()
+	ctx = withObservedDatastore(ctx, ld.adaptiveLimits.check)
 
 	if err := dispatch.CheckDepth(ctx, req); err != nil {
 		if req.Debug != v1.DispatchCheckRequest_ENABLE_BASIC_DEBUGGING {
@@ -206,6 +239,10 @@ func (ld *localDispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCh
 		}, rewriteError(ctx, err)
 	}
 
+	if err := dispatch.ConsumeDispatchBudget(ctx); err != nil {
+		return &v1.DispatchCheckResponse{Metadata: emptyMetadata}, rewriteError(ctx, err)
+	}
+
 	revision, err := ld.parseRevision(ctx, req.Metadata.AtRevision)
 	if err != nil {
 		return &v1.DispatchCheckResponse{Metadata: emptyMetadata}, rewriteError(ctx, err)
@@ -248,14 +285,14 @@ func (ld *localDispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCh
 			OriginalRelationName: req.ResourceRelation.Relation,
 		}
 
-		resp, err := ld.checker.Check(ctx, validatedReq, relation)
+		resp, err := ld.checker.Check(ctx, validatedReq, ns, relation)
 		return resp, rewriteError(ctx, err)
 	}
 
 	resp, err := ld.checker.Check(ctx, graph.ValidatedCheckRequest{
 		DispatchCheckRequest: req,
 		Revision:             revision,
-	}, relation)
+	}, ns, relation)
 	return resp, rewriteError(ctx, err)
 }
 
@@ -270,6 +307,10 @@ func (ld *localDispatcher) DispatchExpand(ctx context.Context, req *v1.DispatchE
 		return &v1.DispatchExpandResponse{Metadata: emptyMetadata}, err
 	}
 
+	if err := dispatch.ConsumeDispatchBudget(ctx); err != nil {
+		return &v1.DispatchExpandResponse{Metadata: emptyMetadata}, err
+	}
+
 	revision, err := ld.parseRevision(ctx, req.Metadata.AtRevision)
 	if err != nil {
 		return &v1.DispatchExpandResponse{Metadata: emptyMetadata}, err
@@ -305,11 +346,16 @@ func (ld *localDispatcher) DispatchReachableResources(
 		attribute.StringSlice("subject-ids", req.SubjectIds),
 	))
 	defer span.End()
+	ctx = withObservedDatastore(ctx, ld.adaptiveLimits.reachableResources)
 
 	if err := dispatch.CheckDepth(ctx, req); err != nil {
 		return err
 	}
 
+	if err := dispatch.ConsumeDispatchBudget(ctx); err != nil {
+		return err
+	}
+
 	revision, err := ld.parseRevision(ctx, req.Metadata.AtRevision)
 	if err != nil {
 		return err
@@ -334,11 +380,16 @@ func (ld *localDispatcher) DispatchLookupResources(
 		attribute.String("subject", tuple.StringONR(req.Subject)),
 	))
 	defer span.End()
+	ctx = withObservedDatastore(ctx, ld.adaptiveLimits.lookupResources)
 
 	if err := dispatch.CheckDepth(ctx, req); err != nil {
 		return err
 	}
 
+	if err := dispatch.ConsumeDispatchBudget(ctx); err != nil {
+		return err
+	}
+
 	revision, err := ld.parseRevision(ctx, req.Metadata.AtRevision)
 	if err != nil {
 		return err
@@ -362,11 +413,16 @@ func (ld *localDispatcher) DispatchLookupResources2(
 		attribute.String("subject", tuple.StringONR(req.TerminalSubject)),
 	))
 	defer span.End()
+	ctx = withObservedDatastore(ctx, ld.adaptiveLimits.lookupResources)
 
 	if err := dispatch.CheckDepth(ctx, req); err != nil {
 		return err
 	}
 
+	if err := dispatch.ConsumeDispatchBudget(ctx); err != nil {
+		return err
+	}
+
 	revision, err := ld.parseRevision(ctx, req.Metadata.AtRevision)
 	if err != nil {
 		return err
@@ -396,11 +452,16 @@ func (ld *localDispatcher) DispatchLookupSubjects(
 		attribute.StringSlice("resource-ids", req.ResourceIds),
 	))
 	defer span.End()
+	ctx = withObservedDatastore(ctx, ld.adaptiveLimits.lookupSubjects)
 
 	if err := dispatch.CheckDepth(ctx, req); err != nil {
 		return err
 	}
 
+	if err := dispatch.ConsumeDispatchBudget(ctx); err != nil {
+		return err
+	}
+
 	revision, err := ld.parseRevision(ctx, req.Metadata.AtRevision)
 	if err != nil {
 		return err
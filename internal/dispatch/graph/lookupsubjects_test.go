@@ -191,6 +191,44 @@ func TestSimpleLookupSubjects(t *testing.T) {
 	}
 }
 
+func TestLookupSubjectsAdditionalSubjectRelations(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	ctx, dis, revision := newLocalDispatcher(t)
+	defer dis.Close()
+
+	stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupSubjectsResponse](ctx)
+	err := dis.DispatchLookupSubjects(&v1.DispatchLookupSubjectsRequest{
+		ResourceRelation:           RR("document", "parent"),
+		ResourceIds:                []string{"masterplan"},
+		SubjectRelation:            RR("user", "..."),
+		AdditionalSubjectRelations: []*corev1.RelationReference{RR("folder", "...")},
+		Metadata: &v1.ResolverMeta{
+			AtRevision:     revision.String(),
+			DepthRemaining: 50,
+		},
+	}, stream)
+	require.NoError(err)
+
+	// Each response holds the subjects of a single relation, given in the response.
+	foundByRelation := map[string][]string{}
+	for _, result := range stream.Results() {
+		require.NotNil(result.SubjectRelation)
+		key := tuple.StringRR(result.SubjectRelation)
+		for _, found := range result.FoundSubjectsByResourceId["masterplan"].GetFoundSubjects() {
+			foundByRelation[key] = append(foundByRelation[key], found.SubjectId)
+		}
+	}
+
+	for _, found := range foundByRelation {
+		sort.Strings(found)
+	}
+	require.Equal(map[string][]string{
+		"folder#...": {"plans", "strategy"},
+	}, foundByRelation)
+}
+
 func TestLookupSubjectsMaxDepth(t *testing.T) {
 	t.Parallel()
 	require := require.New(t)
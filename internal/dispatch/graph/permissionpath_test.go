@@ -0,0 +1,226 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zapravila/spicedb/internal/graph"
+	"github.com/zapravila/spicedb/internal/graph/computed"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+func TestFindPermissionPaths(t *testing.T) {
+	t.Parallel()
+
+	schema := `
+		definition user {}
+
+		caveat somecaveat(somecondition int) {
+			somecondition == 42
+		}
+
+		definition group {
+			relation member: user | group#member
+		}
+
+		definition folder {
+			relation viewer: user | group#member
+			permission view = viewer
+		}
+
+		definition document {
+			relation parent: folder
+			relation viewer: user | user:* | user with somecaveat
+			relation approved: user
+			permission view = viewer + parent->view
+			permission approved_view = view & approved
+		}
+	`
+
+	rels := []*core.RelationTuple{
+		tuple.MustParse("document:first#parent@folder:somefolder"),
+		tuple.MustParse("folder:somefolder#viewer@group:engineering#member"),
+		tuple.MustParse("group:engineering#member@group:backend#member"),
+		tuple.MustParse("group:backend#member@user:tom"),
+		tuple.MustParse("document:first#approved@user:tom"),
+		tuple.MustParse("document:first#viewer@user:fred"),
+		tuple.MustParse("document:first#viewer@user:sarah[somecaveat]"),
+		tuple.MustParse("document:public#viewer@user:*"),
+	}
+
+	tcs := []struct {
+		name          string
+		resourceID    string
+		permission    string
+		subject       string
+		caveatContext map[string]any
+		expectedPaths [][]string
+	}{
+		{
+			"direct",
+			"first",
+			"view",
+			"user:fred",
+			nil,
+			[][]string{{"document:first#viewer@user:fred"}},
+		},
+		{
+			"through arrow and nested groups",
+			"first",
+			"view",
+			"user:tom",
+			nil,
+			[][]string{{
+				"document:first#parent@folder:somefolder",
+				"folder:somefolder#viewer@group:engineering#member",
+				"group:engineering#member@group:backend#member",
+				"group:backend#member@user:tom",
+			}},
+		},
+		{
+			"intersection",
+			"first",
+			"approved_view",
+			"user:tom",
+			nil,
+			[][]string{
+				{
+					"document:first#parent@folder:somefolder",
+					"folder:somefolder#viewer@group:engineering#member",
+					"group:engineering#member@group:backend#member",
+					"group:backend#member@user:tom",
+				},
+				{"document:first#approved@user:tom"},
+			},
+		},
+		{
+			"wildcard",
+			"public",
+			"view",
+			"user:tom",
+			nil,
+			[][]string{{"document:public#viewer@user:*"}},
+		},
+		{
+			"wildcard subject",
+			"public",
+			"view",
+			"user:*",
+			nil,
+			[][]string{{"document:public#viewer@user:*"}},
+		},
+		{
+			"satisfied caveat",
+			"first",
+			"view",
+			"user:sarah",
+			map[string]any{"somecondition": int64(42)},
+			[][]string{{"document:first#viewer@user:sarah[somecaveat]"}},
+		},
+		{
+			"unsatisfied caveat",
+			"first",
+			"view",
+			"user:sarah",
+			map[string]any{"somecondition": int64(41)},
+			nil,
+		},
+		{
+			"no permission",
+			"first",
+			"view",
+			"user:unknown",
+			nil,
+			nil,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			require := require.New(t)
+
+			ctx, dispatcher, revision := newLocalDispatcherWithSchemaAndRels(t, schema, rels)
+			paths, err := graph.FindPermissionPaths(ctx, dispatcher, graph.PermissionPathParameters{
+				ResourceType:      "document",
+				ResourceID:        tc.resourceID,
+				Permission:        tc.permission,
+				Subject:           tuple.ParseSubjectONR(tc.subject),
+				CaveatContext:     tc.caveatContext,
+				AtRevision:        revision,
+				MaximumDepth:      50,
+				DispatchChunkSize: 100,
+			})
+			require.NoError(err)
+
+			var found [][]string
+			for _, path := range paths {
+				rels := make([]string, 0, len(path))
+				for _, rel := range path.Relationships() {
+					rels = append(rels, tuple.MustString(rel))
+				}
+				found = append(found, rels)
+			}
+			require.Equal(tc.expectedPaths, found)
+		})
+	}
+}
+
+func TestFindPermissionPathsFromRecordedCheck(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	schema := `
+		definition user {}
+
+		definition group {
+			relation member: user | group#member
+		}
+
+		definition document {
+			relation owner: group#member
+			relation viewer: user
+			permission view = owner + viewer
+		}
+	`
+
+	rels := []*core.RelationTuple{
+		tuple.MustParse("document:first#owner@group:engineering#member"),
+		tuple.MustParse("group:engineering#member@user:tom"),
+		tuple.MustParse("document:first#viewer@user:tom"),
+	}
+
+	ctx, dispatcher, revision := newLocalDispatcherWithSchemaAndRels(t, schema, rels)
+	recorder := graph.NewProofRecorder(graph.DefaultMaximumRecordedProofRelationships)
+
+	result, _, err := computed.ComputeCheck(graph.ContextWithProofRecorder(ctx, recorder), dispatcher, computed.CheckParameters{
+		ResourceType: RR("document", "view"),
+		Subject:      ONR("user", "tom", "..."),
+		AtRevision:   revision,
+		MaximumDepth: 50,
+		DebugOption:  computed.NoDebugging,
+	}, "first", 100)
+	require.NoError(err)
+	require.Equal(v1.ResourceCheckResult_MEMBER, result.Membership)
+
+	paths, err := graph.FindPermissionPaths(ctx, dispatcher, graph.PermissionPathParameters{
+		ResourceType:      "document",
+		ResourceID:        "first",
+		Permission:        "view",
+		Subject:           ONR("user", "tom", "..."),
+		AtRevision:        revision,
+		MaximumDepth:      50,
+		DispatchChunkSize: 100,
+		Recorder:          recorder,
+		Minimal:           true,
+	})
+	require.NoError(err)
+
+	// The direct viewer relationship is shorter than the path through the group.
+	require.Len(paths, 1)
+	require.Equal("document:first#view(union: viewer) -> document:first#viewer@user:tom", paths[0].String())
+}
@@ -110,9 +110,17 @@ func lookupResourcesRequest2ToKey(req *v1.DispatchLookupResources2Request, optio
 
 // lookupSubjectsRequestToKey converts a lookup subjects request into a cache key
 func lookupSubjectsRequestToKey(req *v1.DispatchLookupSubjectsRequest, option dispatchCacheKeyHashComputeOption) DispatchCacheKey {
-	return dispatchCacheKeyHash(lookupSubjectsPrefix, req.Metadata.AtRevision, option,
+	args := []hashableValue{
 		hashableRelationReference{req.ResourceRelation},
 		hashableRelationReference{req.SubjectRelation},
 		hashableIds(req.ResourceIds),
-	)
+	}
+
+	// NOTE: the additional subject relations are only hashed if given, so that the keys of requests
+	// without them are unchanged.
+	if len(req.AdditionalSubjectRelations) > 0 {
+		args = append(args, hashableRelationReferences(req.AdditionalSubjectRelations))
+	}
+
+	return dispatchCacheKeyHash(lookupSubjectsPrefix, req.Metadata.AtRevision, option, args...)
 }
@@ -405,6 +405,21 @@ func TestStableCacheKeys(t *testing.T) {
 			},
 			"d699c5b5d3a6dfade601",
 		},
+		{
+			"lookup subjects with additional subject relations",
+			func() DispatchCacheKey {
+				return lookupSubjectsRequestToKey(&v1.DispatchLookupSubjectsRequest{
+					ResourceRelation:           RR("document", "view"),
+					SubjectRelation:            RR("user", "..."),
+					ResourceIds:                []string{"mariah", "tom"},
+					AdditionalSubjectRelations: []*core.RelationReference{RR("group", "member")},
+					Metadata: &v1.ResolverMeta{
+						AtRevision: "1234",
+					},
+				}, computeBothHashes)
+			},
+			"8be4e2f0b3e7e9af8501",
+		},
 		{
 			"lookup resources 2",
 			func() DispatchCacheKey {
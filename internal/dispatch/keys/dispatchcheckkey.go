@@ -9,6 +9,8 @@ import (
 type DispatchCacheKey struct {
 	stableSum          uint64
 	processSpecificSum uint64
+	secondaryStableSum uint64
+	atRevision         string
 }
 
 // StableSumAsBytes returns the stable portion of the dispatch cache key as bytes. Note that since
@@ -18,6 +20,20 @@ func (dck DispatchCacheKey) StableSumAsBytes() []byte {
 	return binary.AppendUvarint(make([]byte, 0, 8), dck.stableSum)
 }
 
+// StableKey returns the key in a form which is stable across processes, for sharing cached
+// results. As the process-specific sum cannot be shared, it consists of the stable sum, a
+// second stable sum computed with a distinct hashing algorithm, and the revision, so that results
+// are scoped to their revision and keys are as unlikely to overlap as the key itself.
+func (dck DispatchCacheKey) StableKey() []byte {
+	stableKey := binary.AppendUvarint(dck.StableSumAsBytes(), dck.secondaryStableSum)
+	return append(stableKey, dck.atRevision...)
+}
+
+// AtRevision returns the revision at which the dispatch operation was computed.
+func (dck DispatchCacheKey) AtRevision() string {
+	return dck.atRevision
+}
+
 // AsUInt64s returns the cache key in the form of two uint64's. This method returns uint64s created
 // from two distinct hashing algorithms, which should make the risk of key overlap incredibly
 // unlikely.
@@ -31,4 +47,4 @@ func (dck DispatchCacheKey) KeyString() string {
 	return string(firstBytes) + string(secondBytes)
 }
 
-var emptyDispatchCacheKey = DispatchCacheKey{0, 0}
+var emptyDispatchCacheKey = DispatchCacheKey{}
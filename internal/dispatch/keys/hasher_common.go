@@ -26,6 +26,15 @@ func (hrr hashableRelationReference) AppendToHash(hasher hasherInterface) {
 	hasher.WriteString(tuple.StringRR(hrr.RelationReference))
 }
 
+type hashableRelationReferences []*core.RelationReference
+
+func (hrrs hashableRelationReferences) AppendToHash(hasher hasherInterface) {
+	for _, rr := range hrrs {
+		hasher.WriteString(tuple.StringRR(rr))
+		hasher.WriteString(",")
+	}
+}
+
 type hashableResultSetting v1.DispatchCheckRequest_ResultsSetting
 
 func (hrs hashableResultSetting) AppendToHash(hasher hasherInterface) {
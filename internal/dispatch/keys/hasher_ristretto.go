@@ -5,6 +5,8 @@ package keys
 
 import (
 	"fmt"
+	"hash"
+	"hash/fnv"
 	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
@@ -20,13 +22,16 @@ func dispatchCacheKeyHash(prefix cachePrefix, atRevision string, computeOption d
 	}
 
 	hasher.WriteString(atRevision)
-	return hasher.BuildKey()
+	key := hasher.BuildKey()
+	key.atRevision = atRevision
+	return key
 }
 
 type dispatchCacheKeyHasher struct {
-	stableHasher       *xxhash.Digest
-	computeOption      dispatchCacheKeyHashComputeOption
-	processSpecificSum uint64
+	stableHasher          *xxhash.Digest
+	secondaryStableHasher hash.Hash64
+	computeOption         dispatchCacheKeyHashComputeOption
+	processSpecificSum    uint64
 }
 
 func newDispatchCacheKeyHasher(prefix cachePrefix, computeOption dispatchCacheKeyHashComputeOption) *dispatchCacheKeyHasher {
@@ -34,6 +39,9 @@ func newDispatchCacheKeyHasher(prefix cachePrefix, computeOption dispatchCacheKe
 		stableHasher:  xxhash.New(),
 		computeOption: computeOption,
 	}
+	if computeOption == computeBothHashes {
+		h.secondaryStableHasher = fnv.New64a()
+	}
 
 	prefixString := string(prefix)
 	h.WriteString(prefixString)
@@ -56,6 +64,9 @@ func (h *dispatchCacheKeyHasher) mustWriteString(value string) {
 
 	if h.computeOption == computeBothHashes {
 		h.processSpecificSum = runMemHash(h.processSpecificSum, []byte(value))
+
+		// NOTE: writes to an FNV hash never return an error.
+		_, _ = h.secondaryStableHasher.Write([]byte(value))
 	}
 }
 
@@ -76,8 +87,12 @@ func runMemHash(seed uint64, data []byte) uint64 {
 
 // BuildKey returns the constructed DispatchCheckKey.
 func (h *dispatchCacheKeyHasher) BuildKey() DispatchCacheKey {
-	return DispatchCacheKey{
+	key := DispatchCacheKey{
 		stableSum:          h.stableHasher.Sum64(),
 		processSpecificSum: h.processSpecificSum,
 	}
+	if h.secondaryStableHasher != nil {
+		key.secondaryStableSum = h.secondaryStableHasher.Sum64()
+	}
+	return key
 }
@@ -0,0 +1,328 @@
+package materialized
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/graph/computed"
+	"github.com/zapravila/spicedb/internal/namespace"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	"github.com/zapravila/spicedb/pkg/genutil/slicez"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	// lookupBatchSize is the number of resources whose subjects are looked up by each dispatch when
+	// building or updating an index.
+	lookupBatchSize = 100
+
+	// lookupConcurrency is the maximum number of batches of resources whose subjects are looked up
+	// concurrently.
+	lookupConcurrency = 4
+)
+
+// IndexedPermission is a permission whose subjects of a type are indexed.
+type IndexedPermission struct {
+	ResourceType string
+	Permission   string
+	SubjectType  string
+}
+
+// ParseIndexedPermission parses an indexed permission of the form `resource#permission@subject`,
+// such as `document#view@user`.
+func ParseIndexedPermission(value string) (IndexedPermission, error) {
+	resource, subjectType, ok := strings.Cut(value, "@")
+	if !ok || subjectType == "" {
+		return IndexedPermission{}, fmt.Errorf("invalid indexed permission `%s`: expected `resource#permission@subject`", value)
+	}
+
+	resourceType, permission, ok := strings.Cut(resource, "#")
+	if !ok || resourceType == "" || permission == "" {
+		return IndexedPermission{}, fmt.Errorf("invalid indexed permission `%s`: expected `resource#permission@subject`", value)
+	}
+
+	return IndexedPermission{ResourceType: resourceType, Permission: permission, SubjectType: subjectType}, nil
+}
+
+func (ip IndexedPermission) String() string {
+	return tuple.JoinRelRef(ip.ResourceType, ip.Permission) + "@" + ip.SubjectType
+}
+
+func (ip IndexedPermission) resourceRelation() *core.RelationReference {
+	return &core.RelationReference{Namespace: ip.ResourceType, Relation: ip.Permission}
+}
+
+func (ip IndexedPermission) subjectRelation() *core.RelationReference {
+	return &core.RelationReference{Namespace: ip.SubjectType, Relation: tuple.Ellipsis}
+}
+
+// index holds the subjects with an indexed permission on each resource, as of the revisions in
+// [validFrom, coveredThrough]: the index is updated at validFrom, and no relationships have changed
+// since then up to coveredThrough.
+//
+// Only unconditional permissions can be answered from the index: resources on which a wildcard
+// has the permission, and subjects with a conditional permission, are recorded so that the
+// requests for them are left to the delegate.
+type index struct {
+	IndexedPermission
+
+	mu             sync.RWMutex
+	relations      *computed.PermissionRelations
+	validFrom      datastore.Revision
+	coveredThrough datastore.Revision
+
+	subjectsByResource map[string]map[string]computed.Permissionship
+	resourcesBySubject map[string]map[string]computed.Permissionship
+	wildcardResources  *mapz.Set[string]
+}
+
+func newIndex(permission IndexedPermission) *index {
+	return &index{IndexedPermission: permission}
+}
+
+// covers returns whether the index is valid at the revision.
+func (idx *index) covers(revision datastore.Revision) bool {
+	return idx.relations != nil && !revision.LessThan(idx.validFrom) && !revision.GreaterThan(idx.coveredThrough)
+}
+
+// check returns the permissionship of the subject on the resource, and whether it was answered by
+// the index at the revision.
+func (idx *index) check(revision datastore.Revision, resourceID string, subjectID string) (computed.Permissionship, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if !idx.covers(revision) || idx.wildcardResources.Has(resourceID) {
+		return computed.NoPermission, false
+	}
+
+	permissionship := idx.subjectsByResource[resourceID][subjectID]
+	return permissionship, permissionship != computed.ConditionalPermission
+}
+
+// lookupResources returns the sorted IDs of the resources on which the subject has the permission,
+// and whether they were answered by the index at the revision.
+func (idx *index) lookupResources(revision datastore.Revision, subjectID string) ([]string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if !idx.covers(revision) || !idx.wildcardResources.IsEmpty() {
+		return nil, false
+	}
+
+	resourceIDs := make([]string, 0, len(idx.resourcesBySubject[subjectID]))
+	for resourceID, permissionship := range idx.resourcesBySubject[subjectID] {
+		if permissionship == computed.ConditionalPermission {
+			return nil, false
+		}
+		resourceIDs = append(resourceIDs, resourceID)
+	}
+
+	slices.Sort(resourceIDs)
+	return resourceIDs, true
+}
+
+// invalidate marks the index as not covering any revision, until it is rebuilt.
+func (idx *index) invalidate() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.relations = nil
+}
+
+// isAffectedBySchemaChanges returns whether the schema changes could change the permission, such
+// that the index must be rebuilt. An index which is not valid is always rebuilt.
+//
+// Changes to caveats are conservatively considered to affect the permission, as caveats are not
+// tracked by the relations from which it is computed.
+func (idx *index) isAffectedBySchemaChanges(changes *datastore.RevisionChanges) bool {
+	idx.mu.RLock()
+	relations := idx.relations
+	idx.mu.RUnlock()
+
+	if relations == nil || len(changes.DeletedCaveats) > 0 {
+		return true
+	}
+
+	dependsOn := func(name string) bool {
+		return name == idx.SubjectType || relations.DependsOnDefinition(name)
+	}
+	if slices.ContainsFunc(changes.DeletedNamespaces, dependsOn) {
+		return true
+	}
+
+	return slices.ContainsFunc(changes.ChangedDefinitions, func(def datastore.SchemaDefinition) bool {
+		if _, ok := def.(*core.NamespaceDefinition); !ok {
+			return true
+		}
+		return dependsOn(def.GetName())
+	})
+}
+
+// build rebuilds the index at the revision, from the subjects of each resource of the type. The
+// index keeps answering the revisions it covers until the rebuilt index replaces it.
+func (idx *index) build(ctx context.Context, d dispatch.LookupSubjects, ds datastore.Datastore, revision datastore.Revision, maximumDepth uint32) error {
+	reader := ds.SnapshotReader(revision)
+	if err := namespace.CheckNamespaceAndRelations(ctx, []namespace.TypeAndRelationToCheck{
+		{NamespaceName: idx.ResourceType, RelationName: idx.Permission, AllowEllipsis: false},
+		{NamespaceName: idx.SubjectType, RelationName: tuple.Ellipsis, AllowEllipsis: true},
+	}, reader); err != nil {
+		return err
+	}
+
+	relations, err := computed.RelationsForPermission(ctx, reader, idx.resourceRelation())
+	if err != nil {
+		return err
+	}
+
+	// A resource without any relationship cannot have the permission.
+	it, err := reader.QueryRelationships(ctx, datastore.RelationshipsFilter{OptionalResourceType: idx.ResourceType})
+	if err != nil {
+		return err
+	}
+
+	resourceIDs := mapz.NewSet[string]()
+	for rel := it.Next(); rel != nil; rel = it.Next() {
+		resourceIDs.Add(rel.ResourceAndRelation.ObjectId)
+	}
+	if it.Err() != nil {
+		it.Close()
+		return it.Err()
+	}
+	it.Close()
+
+	subjectsByResource, err := idx.lookupSubjects(ctx, d, revision, resourceIDs.AsSlice(), maximumDepth)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.subjectsByResource = make(map[string]map[string]computed.Permissionship, len(subjectsByResource))
+	idx.resourcesBySubject = make(map[string]map[string]computed.Permissionship)
+	idx.wildcardResources = mapz.NewSet[string]()
+	for resourceID, subjects := range subjectsByResource {
+		idx.setSubjects(resourceID, subjects)
+	}
+
+	idx.relations = relations
+	idx.validFrom = revision
+	idx.coveredThrough = revision
+	return nil
+}
+
+// apply updates the index with the relationship changes made at the revision, which must directly
+// follow the revisions covered by the index.
+func (idx *index) apply(ctx context.Context, d dispatch.Dispatcher, changes []*core.RelationTupleUpdate, revision datastore.Revision, maximumDepth uint32) error {
+	idx.mu.RLock()
+	relations, beforeRevision := idx.relations, idx.coveredThrough
+	idx.mu.RUnlock()
+
+	if relations == nil {
+		return nil
+	}
+
+	affected := slices.ContainsFunc(changes, func(update *core.RelationTupleUpdate) bool {
+		return relations.IsAffectedBy(update.Tuple.ResourceAndRelation.Namespace, update.Tuple.ResourceAndRelation.Relation)
+	})
+	if !affected {
+		idx.advance(revision)
+		return nil
+	}
+
+	resourceIDs, err := relations.AffectedResources(ctx, d, changes, []datastore.Revision{beforeRevision, revision}, maximumDepth, 0)
+	if err != nil {
+		return err
+	}
+
+	subjectsByResource, err := idx.lookupSubjects(ctx, d, revision, resourceIDs, maximumDepth)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for resourceID, subjects := range subjectsByResource {
+		idx.setSubjects(resourceID, subjects)
+	}
+
+	idx.validFrom = revision
+	idx.coveredThrough = revision
+	return nil
+}
+
+// advance records that no relationships of the index have changed up to the revision.
+func (idx *index) advance(revision datastore.Revision) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.relations != nil && revision.GreaterThan(idx.coveredThrough) {
+		idx.coveredThrough = revision
+	}
+}
+
+// lookupSubjects looks up the subjects of the resources at the revision, in batches of
+// lookupBatchSize resources dispatched concurrently, up to lookupConcurrency at a time.
+func (idx *index) lookupSubjects(ctx context.Context, d dispatch.LookupSubjects, revision datastore.Revision, resourceIDs []string, maximumDepth uint32) (map[string]map[string]computed.Permissionship, error) {
+	var mu sync.Mutex
+	subjectsByResource := make(map[string]map[string]computed.Permissionship, len(resourceIDs))
+
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(lookupConcurrency)
+	slicez.ForEachChunk(resourceIDs, lookupBatchSize, func(batch []string) {
+		g.Go(func() error {
+			found, err := computed.LookupSubjectPermissionships(groupCtx, d, revision, idx.resourceRelation(), batch, idx.subjectRelation(), maximumDepth)
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for resourceID, subjects := range found {
+				subjectsByResource[resourceID] = subjects
+			}
+			return nil
+		})
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return subjectsByResource, nil
+}
+
+// setSubjects replaces the subjects of the resource. Must be called with the lock held.
+func (idx *index) setSubjects(resourceID string, subjects map[string]computed.Permissionship) {
+	for subjectID := range idx.subjectsByResource[resourceID] {
+		delete(idx.resourcesBySubject[subjectID], resourceID)
+		if len(idx.resourcesBySubject[subjectID]) == 0 {
+			delete(idx.resourcesBySubject, subjectID)
+		}
+	}
+	delete(idx.subjectsByResource, resourceID)
+	idx.wildcardResources.Delete(resourceID)
+
+	if _, ok := subjects[tuple.PublicWildcard]; ok {
+		idx.wildcardResources.Add(resourceID)
+	}
+
+	if len(subjects) == 0 {
+		return
+	}
+
+	idx.subjectsByResource[resourceID] = subjects
+	for subjectID, permissionship := range subjects {
+		if idx.resourcesBySubject[subjectID] == nil {
+			idx.resourcesBySubject[subjectID] = make(map[string]computed.Permissionship)
+		}
+		idx.resourcesBySubject[subjectID][resourceID] = permissionship
+	}
+}
@@ -0,0 +1,371 @@
+// Package materialized implements a dispatcher answering checks and lookups of hot permissions from
+// indexes of their subjects, maintained incrementally from the datastore's change stream.
+package materialized
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zapravila/spicedb/internal/datastore/revisions"
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/graph"
+	"github.com/zapravila/spicedb/internal/graph/computed"
+	log "github.com/zapravila/spicedb/internal/logging"
+	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	// cursorVersion is the dispatch version of the cursors of the lookups answered by an index,
+	// which differs from that of the graph so that the delegate rejects them as invalid.
+	cursorVersion = 1 << 16
+
+	// rebuildDelay is the delay before rebuilding the indexes after the watch of the datastore fails.
+	rebuildDelay = 5 * time.Second
+)
+
+var (
+	lagGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "spicedb",
+		Subsystem: "materialized_index",
+		Name:      "lag_seconds",
+		Help:      "delay between the commit of the last revision applied to a materialized permission index and its application, by index",
+	}, []string{"index"})
+
+	requestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "materialized_index",
+		Name:      "requests_total",
+		Help:      "number of dispatched requests for an indexed permission, by index, method and whether they were answered by the index",
+	}, []string{"index", "method", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(lagGauge, requestsCounter)
+}
+
+// Dispatcher is a dispatcher which answers the checks and lookups of the indexed permissions from
+// their indexes, when the revision of the request is covered by the index, and delegates all other
+// requests.
+type Dispatcher struct {
+	d            dispatch.Dispatcher
+	ds           datastore.Datastore
+	maximumDepth uint32
+	indexes      []*index
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewDispatcher creates a new dispatch.Dispatcher which maintains an index of each of the given
+// permissions, computed with the delegate at the head revision of the datastore and then updated
+// from its changes.
+func NewDispatcher(delegate dispatch.Dispatcher, ds datastore.Datastore, permissions []IndexedPermission, maximumDepth uint32) *Dispatcher {
+	indexes := make([]*index, 0, len(permissions))
+	for _, permission := range permissions {
+		indexes = append(indexes, newIndex(permission))
+	}
+
+	ctx, cancel := context.WithCancel(datastoremw.ContextWithDatastore(context.Background(), ds))
+	md := &Dispatcher{
+		d:            delegate,
+		ds:           ds,
+		maximumDepth: maximumDepth,
+		indexes:      indexes,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+
+	go md.maintain(ctx)
+	return md
+}
+
+// maintain builds the indexes and updates them from the changes of the datastore, rebuilding them
+// whenever the watch fails, until the context is canceled.
+func (md *Dispatcher) maintain(ctx context.Context) {
+	defer close(md.done)
+
+	for {
+		err := md.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		for _, idx := range md.indexes {
+			idx.invalidate()
+		}
+		log.Ctx(ctx).Warn().Err(err).Dur("retry-after", rebuildDelay).Msg("materialized permission indexes failed to update; rebuilding")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rebuildDelay):
+		}
+	}
+}
+
+func (md *Dispatcher) watch(ctx context.Context) error {
+	headRevision, err := md.ds.HeadRevision(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := md.build(ctx, headRevision, md.indexes); err != nil {
+		return err
+	}
+
+	updates, errchan := md.ds.Watch(ctx, headRevision, datastore.WatchOptions{
+		Content: datastore.WatchRelationships | datastore.WatchSchema | datastore.WatchCheckpoints,
+	})
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return <-errchan
+			}
+
+			// The permissions affected by schema changes may now be computed from other relations,
+			// so their indexes are rebuilt, while the others are updated.
+			var rebuilt []*index
+			if len(update.ChangedDefinitions) > 0 || len(update.DeletedNamespaces) > 0 || len(update.DeletedCaveats) > 0 {
+				for _, idx := range md.indexes {
+					if idx.isAffectedBySchemaChanges(update) {
+						rebuilt = append(rebuilt, idx)
+					}
+				}
+
+				if err := md.build(ctx, update.Revision, rebuilt); err != nil {
+					return err
+				}
+			}
+
+			for _, idx := range md.indexes {
+				if slices.Contains(rebuilt, idx) {
+					continue
+				}
+
+				if err := idx.apply(ctx, md.d, update.RelationshipChanges, update.Revision, md.maximumDepth); err != nil {
+					return err
+				}
+			}
+
+			md.recordLag(update.Revision)
+
+		case err := <-errchan:
+			return err
+		}
+	}
+}
+
+// build rebuilds the indexes at the revision.
+func (md *Dispatcher) build(ctx context.Context, revision datastore.Revision, indexes []*index) error {
+	for _, idx := range indexes {
+		if err := idx.build(ctx, md.d, md.ds, revision, md.maximumDepth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (md *Dispatcher) recordLag(revision datastore.Revision) {
+	timestamped, ok := revision.(revisions.WithTimestampRevision)
+	if !ok {
+		return
+	}
+
+	lag := time.Since(time.Unix(0, timestamped.TimestampNanoSec())).Seconds()
+	for _, idx := range md.indexes {
+		lagGauge.WithLabelValues(idx.String()).Set(max(lag, 0))
+	}
+}
+
+// indexFor returns the index of the permission for subjects of the type, if any.
+func (md *Dispatcher) indexFor(resourceType string, permission string, subjectType string, subjectRelation string) *index {
+	if subjectRelation != tuple.Ellipsis {
+		return nil
+	}
+
+	for _, idx := range md.indexes {
+		if idx.ResourceType == resourceType && idx.Permission == permission && idx.SubjectType == subjectType {
+			return idx
+		}
+	}
+	return nil
+}
+
+// revisionOf parses the revision of the request, returning false if it cannot be parsed, in which
+// case the request is left to the delegate to fail.
+func (md *Dispatcher) revisionOf(metadata *v1.ResolverMeta) (datastore.Revision, bool) {
+	revision, err := md.ds.RevisionFromString(metadata.GetAtRevision())
+	return revision, err == nil
+}
+
+func (md *Dispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
+	idx := md.indexFor(req.ResourceRelation.Namespace, req.ResourceRelation.Relation, req.Subject.Namespace, req.Subject.Relation)
+	if idx == nil || req.Debug != v1.DispatchCheckRequest_NO_DEBUG {
+		return md.d.DispatchCheck(ctx, req)
+	}
+
+	revision, ok := md.revisionOf(req.Metadata)
+	if !ok {
+		return md.d.DispatchCheck(ctx, req)
+	}
+
+	results := make(map[string]*v1.ResourceCheckResult, len(req.ResourceIds))
+	for _, resourceID := range req.ResourceIds {
+		permissionship, ok := idx.check(revision, resourceID, req.Subject.ObjectId)
+		if !ok {
+			requestsCounter.WithLabelValues(idx.String(), "DispatchCheck", "delegated").Inc()
+			return md.d.DispatchCheck(ctx, req)
+		}
+
+		if permissionship == computed.HasPermission {
+			results[resourceID] = &v1.ResourceCheckResult{Membership: v1.ResourceCheckResult_MEMBER}
+		}
+	}
+
+	requestsCounter.WithLabelValues(idx.String(), "DispatchCheck", "indexed").Inc()
+	return &v1.DispatchCheckResponse{
+		Metadata:            indexedResponseMeta(),
+		ResultsByResourceId: results,
+	}, nil
+}
+
+func (md *Dispatcher) DispatchLookupResources(req *v1.DispatchLookupResourcesRequest, stream dispatch.LookupResourcesStream) error {
+	idx := md.indexFor(req.ObjectRelation.Namespace, req.ObjectRelation.Relation, req.Subject.Namespace, req.Subject.Relation)
+	if idx == nil {
+		return md.d.DispatchLookupResources(req, stream)
+	}
+
+	return md.lookupResources(idx, "DispatchLookupResources", req.Metadata, req.Subject.ObjectId, req.OptionalCursor, req.OptionalLimit,
+		func() error {
+			return md.d.DispatchLookupResources(req, stream)
+		},
+		func(resourceID string, cursor *v1.Cursor) error {
+			return stream.Publish(&v1.DispatchLookupResourcesResponse{
+				ResolvedResource: &v1.ResolvedResource{
+					ResourceId:     resourceID,
+					Permissionship: v1.ResolvedResource_HAS_PERMISSION,
+				},
+				Metadata:            indexedResponseMeta(),
+				AfterResponseCursor: cursor,
+			})
+		})
+}
+
+func (md *Dispatcher) DispatchLookupResources2(req *v1.DispatchLookupResources2Request, stream dispatch.LookupResources2Stream) error {
+	idx := md.indexFor(req.ResourceRelation.Namespace, req.ResourceRelation.Relation, req.SubjectRelation.Namespace, req.SubjectRelation.Relation)
+	if idx == nil || len(req.SubjectIds) != 1 || req.TerminalSubject.ObjectId != req.SubjectIds[0] {
+		return md.d.DispatchLookupResources2(req, stream)
+	}
+
+	subjectID := req.SubjectIds[0]
+	return md.lookupResources(idx, "DispatchLookupResources2", req.Metadata, subjectID, req.OptionalCursor, req.OptionalLimit,
+		func() error {
+			return md.d.DispatchLookupResources2(req, stream)
+		},
+		func(resourceID string, cursor *v1.Cursor) error {
+			return stream.Publish(&v1.DispatchLookupResources2Response{
+				Resource: &v1.PossibleResource{
+					ResourceId:    resourceID,
+					ForSubjectIds: []string{subjectID},
+				},
+				Metadata:            indexedResponseMeta(),
+				AfterResponseCursor: cursor,
+			})
+		})
+}
+
+// lookupResources publishes the resources on which the subject has the permission from the index,
+// in order of their IDs, and otherwise delegates the lookup.
+//
+// The cursors published hold the ID of the resource after which to continue. As the index only
+// covers its most recent revisions, continuing from them fails as an invalid cursor once the
+// revision of the lookup is no longer covered.
+func (md *Dispatcher) lookupResources(
+	idx *index,
+	method string,
+	metadata *v1.ResolverMeta,
+	subjectID string,
+	cursor *v1.Cursor,
+	limit uint32,
+	delegate func() error,
+	publish func(resourceID string, cursor *v1.Cursor) error,
+) error {
+	if cursor != nil && cursor.DispatchVersion != cursorVersion {
+		return delegate()
+	}
+
+	revision, ok := md.revisionOf(metadata)
+	if !ok {
+		return delegate()
+	}
+
+	resourceIDs, ok := idx.lookupResources(revision, subjectID)
+	if !ok {
+		if cursor != nil {
+			return graph.NewInvalidCursorErr(cursorVersion, cursor)
+		}
+
+		requestsCounter.WithLabelValues(idx.String(), method, "delegated").Inc()
+		return delegate()
+	}
+
+	requestsCounter.WithLabelValues(idx.String(), method, "indexed").Inc()
+
+	if len(cursor.GetSections()) > 0 {
+		after := cursor.Sections[0]
+		start, found := slices.BinarySearch(resourceIDs, after)
+		if found {
+			start++
+		}
+		resourceIDs = resourceIDs[start:]
+	}
+
+	if limit > 0 && uint32(len(resourceIDs)) > limit {
+		resourceIDs = resourceIDs[:limit]
+	}
+
+	for _, resourceID := range resourceIDs {
+		if err := publish(resourceID, &v1.Cursor{DispatchVersion: cursorVersion, Sections: []string{resourceID}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexedResponseMeta() *v1.ResponseMeta {
+	return &v1.ResponseMeta{
+		DispatchCount:       1,
+		CachedDispatchCount: 1,
+		DepthRequired:       1,
+	}
+}
+
+func (md *Dispatcher) DispatchExpand(ctx context.Context, req *v1.DispatchExpandRequest) (*v1.DispatchExpandResponse, error) {
+	return md.d.DispatchExpand(ctx, req)
+}
+
+func (md *Dispatcher) DispatchReachableResources(req *v1.DispatchReachableResourcesRequest, stream dispatch.ReachableResourcesStream) error {
+	return md.d.DispatchReachableResources(req, stream)
+}
+
+func (md *Dispatcher) DispatchLookupSubjects(req *v1.DispatchLookupSubjectsRequest, stream dispatch.LookupSubjectsStream) error {
+	return md.d.DispatchLookupSubjects(req, stream)
+}
+
+// Close stops maintaining the indexes and closes the delegate.
+func (md *Dispatcher) Close() error {
+	md.cancel()
+	<-md.done
+	return md.d.Close()
+}
+
+func (md *Dispatcher) ReadyState() dispatch.ReadyState { return md.d.ReadyState() }
+
+var _ dispatch.Dispatcher = &Dispatcher{}
@@ -0,0 +1,287 @@
+package materialized
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/dispatch/graph"
+	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const testSchema = `
+	definition user {}
+
+	definition folder {
+		relation parent: folder
+		relation viewer: user
+		permission view = viewer + parent->view
+	}
+
+	definition document {
+		relation parent: folder
+		relation viewer: user | user:*
+		permission view = viewer + parent->view
+	}
+`
+
+var viewPermission = IndexedPermission{ResourceType: "document", Permission: "view", SubjectType: "user"}
+
+func TestParseIndexedPermission(t *testing.T) {
+	permission, err := ParseIndexedPermission("document#view@user")
+	require.NoError(t, err)
+	require.Equal(t, viewPermission, permission)
+	require.Equal(t, "document#view@user", permission.String())
+
+	for _, invalid := range []string{"", "document", "document#view", "document@user", "#view@user", "document#@user", "document#view@"} {
+		_, err := ParseIndexedPermission(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestMaterializedDispatcher(t *testing.T) {
+	require := require.New(t)
+
+	ds, revision := newDatastore(t, []*core.RelationTuple{
+		tuple.MustParse("folder:root#viewer@user:jill"),
+		tuple.MustParse("folder:child#parent@folder:root"),
+		tuple.MustParse("document:first#parent@folder:child"),
+		tuple.MustParse("document:second#viewer@user:tom"),
+	})
+
+	md := NewDispatcher(graph.NewLocalOnlyDispatcher(10, 100), ds, []IndexedPermission{viewPermission}, 50)
+	t.Cleanup(func() { require.NoError(md.Close()) })
+
+	ctx := datastoremw.ContextWithDatastore(context.Background(), ds)
+	requireCovered(t, md, revision)
+
+	requireCheck(t, md, ctx, revision, "jill", true, "first")
+	requireCheck(t, md, ctx, revision, "tom", true, "second")
+	requireLookup(t, md, ctx, revision, "jill", 0, "first")
+
+	// Grant a permission through the arrow of the folder's parent.
+	revision = write(t, ds,
+		tuple.Create(tuple.MustParse("folder:other#viewer@user:tom")),
+		tuple.Touch(tuple.MustParse("folder:root#parent@folder:other")),
+		tuple.Create(tuple.MustParse("document:third#parent@folder:root")),
+	)
+	requireCovered(t, md, revision)
+
+	requireCheck(t, md, ctx, revision, "tom", true, "first", "second", "third")
+	requireCheck(t, md, ctx, revision, "jill", true, "first", "third")
+	requireLookup(t, md, ctx, revision, "tom", 0, "first", "second", "third")
+
+	// Remove a permission.
+	revision = write(t, ds, tuple.Delete(tuple.MustParse("folder:child#parent@folder:root")))
+	requireCovered(t, md, revision)
+
+	requireCheck(t, md, ctx, revision, "jill", true, "third")
+	requireLookup(t, md, ctx, revision, "tom", 0, "second", "third")
+}
+
+func TestMaterializedDispatcherDelegates(t *testing.T) {
+	require := require.New(t)
+
+	ds, revision := newDatastore(t, []*core.RelationTuple{
+		tuple.MustParse("document:first#viewer@user:tom"),
+	})
+
+	md := NewDispatcher(graph.NewLocalOnlyDispatcher(10, 100), ds, []IndexedPermission{viewPermission}, 50)
+	t.Cleanup(func() { require.NoError(md.Close()) })
+
+	ctx := datastoremw.ContextWithDatastore(context.Background(), ds)
+	requireCovered(t, md, revision)
+
+	// The revisions before the index are not covered.
+	idx := md.indexes[0]
+	_, ok := idx.check(revision, "first", "tom")
+	require.True(ok)
+
+	written := write(t, ds, tuple.Create(tuple.MustParse("document:second#viewer@user:*")))
+	requireCovered(t, md, written)
+
+	_, ok = idx.check(revision, "first", "tom")
+	require.False(ok)
+
+	// Resources with a wildcard are left to the delegate, which still answers correctly.
+	_, ok = idx.check(written, "second", "fred")
+	require.False(ok)
+	requireCheck(t, md, ctx, written, "fred", false, "second")
+
+	_, ok = idx.lookupResources(written, "tom")
+	require.False(ok)
+	requireLookup(t, md, ctx, written, "tom", 0, "first", "second")
+}
+
+func TestMaterializedDispatcherLookupCursors(t *testing.T) {
+	require := require.New(t)
+
+	ds, revision := newDatastore(t, []*core.RelationTuple{
+		tuple.MustParse("document:a#viewer@user:tom"),
+		tuple.MustParse("document:b#viewer@user:tom"),
+		tuple.MustParse("document:c#viewer@user:tom"),
+	})
+
+	md := NewDispatcher(graph.NewLocalOnlyDispatcher(10, 100), ds, []IndexedPermission{viewPermission}, 50)
+	t.Cleanup(func() { require.NoError(md.Close()) })
+
+	ctx := datastoremw.ContextWithDatastore(context.Background(), ds)
+	requireCovered(t, md, revision)
+
+	var cursor *v1.Cursor
+	var found []string
+	for {
+		results := lookup(t, md, ctx, revision, "tom", 2, cursor)
+		if len(results) == 0 {
+			break
+		}
+
+		for _, result := range results {
+			found = append(found, result.ResolvedResource.ResourceId)
+		}
+		cursor = results[len(results)-1].AfterResponseCursor
+	}
+	require.Equal([]string{"a", "b", "c"}, found)
+
+	// Once the revision is no longer covered, the cursor is invalid.
+	write(t, ds, tuple.Create(tuple.MustParse("document:d#viewer@user:tom")))
+	require.Eventually(func() bool {
+		_, ok := md.indexes[0].lookupResources(revision, "tom")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResourcesResponse](ctx)
+	err := md.DispatchLookupResources(lookupRequest(revision, "tom", 2, &v1.Cursor{DispatchVersion: cursorVersion, Sections: []string{"a"}}), stream)
+	require.ErrorContains(err, "the supplied cursor is no longer valid")
+}
+
+func newDatastore(t *testing.T, relationships []*core.RelationTuple) (datastore.Datastore, datastore.Revision) {
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(t, err)
+	t.Cleanup(func() { rawDS.Close() })
+
+	return testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, testSchema, relationships, require.New(t))
+}
+
+func write(t *testing.T, ds datastore.Datastore, updates ...*core.RelationTupleUpdate) datastore.Revision {
+	revision, err := ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		return rwt.WriteRelationships(ctx, updates)
+	})
+	require.NoError(t, err)
+	return revision
+}
+
+func requireCovered(t *testing.T, md *Dispatcher, revision datastore.Revision) {
+	require.Eventually(t, func() bool {
+		idx := md.indexes[0]
+		idx.mu.RLock()
+		defer idx.mu.RUnlock()
+		return idx.covers(revision)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// requireCheck checks the subject on the resources, requiring it to have the permission on those
+// expected, and that the index answered for each resource if indexed is true.
+func requireCheck(t *testing.T, md *Dispatcher, ctx context.Context, revision datastore.Revision, subjectID string, indexed bool, expected ...string) {
+	resourceIDs := []string{"first", "second", "third"}
+	if indexed {
+		for _, resourceID := range resourceIDs {
+			_, ok := md.indexes[0].check(revision, resourceID, subjectID)
+			require.True(t, ok, resourceID)
+		}
+	}
+
+	resp, err := md.DispatchCheck(ctx, &v1.DispatchCheckRequest{
+		Metadata:         &v1.ResolverMeta{AtRevision: revision.String(), DepthRemaining: 50},
+		ResourceRelation: viewPermission.resourceRelation(),
+		ResourceIds:      resourceIDs,
+		Subject:          &core.ObjectAndRelation{Namespace: "user", ObjectId: subjectID, Relation: tuple.Ellipsis},
+		ResultsSetting:   v1.DispatchCheckRequest_REQUIRE_ALL_RESULTS,
+	})
+	require.NoError(t, err)
+
+	found := make([]string, 0, len(resp.ResultsByResourceId))
+	for resourceID, result := range resp.ResultsByResourceId {
+		if result.Membership == v1.ResourceCheckResult_MEMBER {
+			found = append(found, resourceID)
+		}
+	}
+	require.ElementsMatch(t, expected, found)
+}
+
+func requireLookup(t *testing.T, md *Dispatcher, ctx context.Context, revision datastore.Revision, subjectID string, limit uint32, expected ...string) {
+	found := make([]string, 0, len(expected))
+	for _, result := range lookup(t, md, ctx, revision, subjectID, limit, nil) {
+		found = append(found, result.ResolvedResource.ResourceId)
+	}
+	require.ElementsMatch(t, expected, found)
+}
+
+func lookup(t *testing.T, md *Dispatcher, ctx context.Context, revision datastore.Revision, subjectID string, limit uint32, cursor *v1.Cursor) []*v1.DispatchLookupResourcesResponse {
+	stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResourcesResponse](ctx)
+	require.NoError(t, md.DispatchLookupResources(lookupRequest(revision, subjectID, limit, cursor), stream))
+	return stream.Results()
+}
+
+func lookupRequest(revision datastore.Revision, subjectID string, limit uint32, cursor *v1.Cursor) *v1.DispatchLookupResourcesRequest {
+	bf, err := v1.NewTraversalBloomFilter(50)
+	if err != nil {
+		panic(err)
+	}
+
+	return &v1.DispatchLookupResourcesRequest{
+		Metadata:       &v1.ResolverMeta{AtRevision: revision.String(), DepthRemaining: 50, TraversalBloom: bf},
+		ObjectRelation: viewPermission.resourceRelation(),
+		Subject:        &core.ObjectAndRelation{Namespace: "user", ObjectId: subjectID, Relation: tuple.Ellipsis},
+		OptionalCursor: cursor,
+		OptionalLimit:  limit,
+	}
+}
+
+func TestMaterializedDispatcherSchemaChanges(t *testing.T) {
+	require := require.New(t)
+
+	ds, revision := newDatastore(t, []*core.RelationTuple{
+		tuple.MustParse("document:first#viewer@user:tom"),
+	})
+
+	md := NewDispatcher(graph.NewLocalOnlyDispatcher(10, 100), ds, []IndexedPermission{viewPermission}, 50)
+	t.Cleanup(func() { require.NoError(md.Close()) })
+	requireCovered(t, md, revision)
+
+	idx := md.indexes[0]
+	for _, tc := range []struct {
+		name     string
+		changes  datastore.RevisionChanges
+		affected bool
+	}{
+		{"unrelated definition", datastore.RevisionChanges{ChangedDefinitions: []datastore.SchemaDefinition{&core.NamespaceDefinition{Name: "team"}}}, false},
+		{"unrelated deletion", datastore.RevisionChanges{DeletedNamespaces: []string{"team"}}, false},
+		{"resource definition", datastore.RevisionChanges{ChangedDefinitions: []datastore.SchemaDefinition{&core.NamespaceDefinition{Name: "document"}}}, true},
+		{"arrowed definition", datastore.RevisionChanges{ChangedDefinitions: []datastore.SchemaDefinition{&core.NamespaceDefinition{Name: "folder"}}}, true},
+		{"subject definition", datastore.RevisionChanges{DeletedNamespaces: []string{"user"}}, true},
+		{"caveat", datastore.RevisionChanges{ChangedDefinitions: []datastore.SchemaDefinition{&core.CaveatDefinition{Name: "somecaveat"}}}, true},
+	} {
+		require.Equal(tc.affected, idx.isAffectedBySchemaChanges(&tc.changes), tc.name)
+	}
+
+	// An unrelated schema change does not rebuild the index, which keeps covering the revisions
+	// before it.
+	written, err := ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		return rwt.WriteNamespaces(ctx, &core.NamespaceDefinition{Name: "team"})
+	})
+	require.NoError(err)
+	requireCovered(t, md, written)
+
+	_, ok := idx.check(revision, "first", "tom")
+	require.True(ok)
+}
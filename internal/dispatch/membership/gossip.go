@@ -0,0 +1,449 @@
+package membership
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+)
+
+const (
+	// DefaultGossipInterval is the default interval at which a node gossips with its peers.
+	DefaultGossipInterval = 1 * time.Second
+
+	// DefaultGossipFailureTimeout is the default duration after which a member which has not been
+	// heard from is considered failed and removed from the cluster.
+	DefaultGossipFailureTimeout = 10 * time.Second
+
+	// DefaultGossipFanout is the default number of peers with which a node gossips each interval.
+	DefaultGossipFanout = 3
+
+	// gossipRetentionFactor is the multiple of the failure timeout for which failed and departed
+	// members are remembered, so that stale gossip about them is not mistaken for a rejoin.
+	gossipRetentionFactor = 10
+
+	// maximumGossipMessageSize is the maximum size of a gossip message accepted from a peer.
+	maximumGossipMessageSize = 4 << 20
+)
+
+// ErrInvalidGossipMessage is returned when a gossip message from a peer cannot be authenticated.
+var ErrInvalidGossipMessage = errors.New("invalid gossip message")
+
+// GossipConfig is the configuration for a Gossip membership.
+type GossipConfig struct {
+	// DispatchAddr is the address at which this node serves dispatch, as advertised to peers. It
+	// also uniquely identifies the node within the cluster.
+	DispatchAddr string
+
+	// BindAddr is the address on which to listen for gossip from peers. Ignored if Listener is set.
+	BindAddr string
+
+	// AdvertiseAddr is the address at which peers can reach this node's gossip listener. Defaults
+	// to the address of the listener.
+	AdvertiseAddr string
+
+	// Seeds are the gossip addresses of existing members through which to join the cluster.
+	Seeds []string
+
+	// SecretKey, if given, is used to authenticate gossip messages. All members must share it.
+	SecretKey []byte
+
+	// Interval is the interval at which to gossip with peers.
+	Interval time.Duration
+
+	// FailureTimeout is the duration after which a member not heard from is considered failed.
+	FailureTimeout time.Duration
+
+	// Fanout is the number of peers with which to gossip each interval.
+	Fanout int
+
+	// Listener, if given, is used to receive gossip instead of listening on BindAddr.
+	Listener net.Listener
+
+	// Dialer, if given, is used to connect to peers instead of TCP.
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// gossipMember is the state of a member, as exchanged between peers.
+type gossipMember struct {
+	DispatchAddr string `json:"dispatchAddr"`
+	GossipAddr   string `json:"gossipAddr"`
+
+	// Generation identifies a run of the member's process, so that a restarted member supersedes
+	// its prior state.
+	Generation int64 `json:"generation"`
+
+	// Heartbeat is incremented by the member each interval.
+	Heartbeat uint64 `json:"heartbeat"`
+
+	// Left indicates the member left the cluster.
+	Left bool `json:"left,omitempty"`
+}
+
+func (gm gossipMember) supersedes(other gossipMember) bool {
+	if gm.Generation != other.Generation {
+		return gm.Generation > other.Generation
+	}
+	if gm.Heartbeat != other.Heartbeat {
+		return gm.Heartbeat > other.Heartbeat
+	}
+	return gm.Left && !other.Left
+}
+
+type gossipMessage struct {
+	Payload json.RawMessage `json:"payload"`
+	MAC     []byte          `json:"mac,omitempty"`
+}
+
+// memberState is the local state of a peer.
+type memberState struct {
+	gossipMember
+
+	// updatedAt is the local time at which the member's state last advanced.
+	updatedAt time.Time
+}
+
+func (ms memberState) isLive(now time.Time, failureTimeout time.Duration) bool {
+	return !ms.Left && now.Sub(ms.updatedAt) < failureTimeout
+}
+
+// Gossip is a Membership in which the members exchange their view of the cluster with one another,
+// with members joining via one or more seeds, and leaving either explicitly when closed or by being
+// considered failed once they have not been heard from for the failure timeout.
+type Gossip struct {
+	*memberSet
+
+	config   GossipConfig
+	listener net.Listener
+
+	lock  sync.Mutex
+	self  gossipMember
+	peers map[string]memberState
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGossip starts a Gossip membership with the given configuration.
+func NewGossip(config GossipConfig) (*Gossip, error) {
+	if config.DispatchAddr == "" {
+		return nil, fmt.Errorf("missing dispatch address for gossip membership")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultGossipInterval
+	}
+	if config.FailureTimeout <= 0 {
+		config.FailureTimeout = DefaultGossipFailureTimeout
+	}
+	if config.Fanout <= 0 {
+		config.Fanout = DefaultGossipFanout
+	}
+	if config.Dialer == nil {
+		dialer := &net.Dialer{}
+		config.Dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}
+	}
+
+	listener := config.Listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", config.BindAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for gossip: %w", err)
+		}
+	}
+
+	advertiseAddr := config.AdvertiseAddr
+	if advertiseAddr == "" {
+		advertiseAddr = listener.Addr().String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &Gossip{
+		memberSet: newMemberSet(),
+		config:    config,
+		listener:  listener,
+		self: gossipMember{
+			DispatchAddr: config.DispatchAddr,
+			GossipAddr:   advertiseAddr,
+			Generation:   time.Now().UnixNano(),
+		},
+		peers:  map[string]memberState{},
+		cancel: cancel,
+	}
+	g.updateMembers(time.Now())
+
+	g.wg.Add(2)
+	go g.serve()
+	go g.run(ctx)
+	return g, nil
+}
+
+// GossipAddr returns the address at which peers can reach this node's gossip listener.
+func (g *Gossip) GossipAddr() string {
+	return g.self.GossipAddr
+}
+
+// Close leaves the cluster, notifying peers, and stops gossiping.
+func (g *Gossip) Close() error {
+	g.stop(true)
+	return nil
+}
+
+func (g *Gossip) stop(leave bool) {
+	if leave {
+		g.lock.Lock()
+		g.self.Left = true
+		g.self.Heartbeat++
+		g.lock.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), g.config.Interval)
+		g.gossipRound(ctx)
+		cancel()
+	}
+
+	g.cancel()
+	_ = g.listener.Close()
+	g.wg.Wait()
+}
+
+func (g *Gossip) run(ctx context.Context) {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(g.config.Interval)
+	defer ticker.Stop()
+
+	g.gossipRound(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			g.lock.Lock()
+			g.self.Heartbeat++
+			g.lock.Unlock()
+
+			g.gossipRound(ctx)
+		}
+	}
+}
+
+// gossipRound exchanges state with up to the fanout number of live peers, or with the seeds if
+// there are none.
+func (g *Gossip) gossipRound(ctx context.Context) {
+	now := time.Now()
+	targets := g.gossipTargets(now)
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := g.exchange(ctx, target); err != nil {
+			log.Debug().Err(err).Str("peer", target).Msg("failed to gossip with dispatch cluster peer")
+		}
+	}
+	g.updateMembers(time.Now())
+}
+
+func (g *Gossip) gossipTargets(now time.Time) []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	live := make([]string, 0, len(g.peers))
+	for _, peer := range g.peers {
+		if peer.isLive(now, g.config.FailureTimeout) {
+			live = append(live, peer.GossipAddr)
+		}
+	}
+
+	if len(live) == 0 {
+		for _, seed := range g.config.Seeds {
+			if seed != g.self.GossipAddr {
+				live = append(live, seed)
+			}
+		}
+	}
+
+	rand.Shuffle(len(live), func(i, j int) {
+		live[i], live[j] = live[j], live[i]
+	})
+	if len(live) > g.config.Fanout {
+		live = live[:g.config.Fanout]
+	}
+	return live
+}
+
+// exchange sends the local state to the peer and merges the state it returns.
+func (g *Gossip) exchange(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, g.config.Interval)
+	defer cancel()
+
+	conn, err := g.config.Dialer(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	if err := g.writeState(conn); err != nil {
+		return err
+	}
+
+	members, err := g.readState(conn)
+	if err != nil {
+		return err
+	}
+
+	g.merge(members, time.Now())
+	return nil
+}
+
+func (g *Gossip) serve() {
+	defer g.wg.Done()
+
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer conn.Close()
+
+			if err := conn.SetDeadline(time.Now().Add(g.config.Interval)); err != nil {
+				return
+			}
+
+			members, err := g.readState(conn)
+			if err != nil {
+				log.Debug().Err(err).Str("peer", conn.RemoteAddr().String()).Msg("received invalid gossip from dispatch cluster peer")
+				return
+			}
+
+			g.merge(members, time.Now())
+			if err := g.writeState(conn); err != nil {
+				log.Debug().Err(err).Str("peer", conn.RemoteAddr().String()).Msg("failed to reply to dispatch cluster peer")
+			}
+		}()
+	}
+}
+
+func (g *Gossip) writeState(w io.Writer) error {
+	g.lock.Lock()
+	members := make([]gossipMember, 0, len(g.peers)+1)
+	members = append(members, g.self)
+	for _, peer := range g.peers {
+		members = append(members, peer.gossipMember)
+	}
+	g.lock.Unlock()
+
+	payload, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+
+	message := gossipMessage{Payload: payload}
+	if len(g.config.SecretKey) > 0 {
+		message.MAC = g.sign(payload)
+	}
+	return json.NewEncoder(w).Encode(message)
+}
+
+func (g *Gossip) readState(r io.Reader) ([]gossipMember, error) {
+	var message gossipMessage
+	if err := json.NewDecoder(io.LimitReader(r, maximumGossipMessageSize)).Decode(&message); err != nil {
+		return nil, err
+	}
+
+	if len(g.config.SecretKey) > 0 && !hmac.Equal(message.MAC, g.sign(message.Payload)) {
+		return nil, ErrInvalidGossipMessage
+	}
+
+	var members []gossipMember
+	if err := json.Unmarshal(message.Payload, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (g *Gossip) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, g.config.SecretKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// merge merges the state received from a peer into the local state.
+func (g *Gossip) merge(members []gossipMember, now time.Time) {
+	g.lock.Lock()
+	changed := false
+	for _, member := range members {
+		if member.DispatchAddr == "" || member.DispatchAddr == g.self.DispatchAddr {
+			continue
+		}
+
+		existing, ok := g.peers[member.DispatchAddr]
+		if ok && !member.supersedes(existing.gossipMember) {
+			continue
+		}
+
+		// NOTE: departed members unknown to this node are not added, as there is nothing to remove.
+		if !ok && member.Left {
+			continue
+		}
+
+		g.peers[member.DispatchAddr] = memberState{gossipMember: member, updatedAt: now}
+		changed = true
+	}
+	g.lock.Unlock()
+
+	if changed {
+		g.updateMembers(now)
+	}
+}
+
+// updateMembers forgets members which have been gone for the retention period, and updates the
+// set of live members.
+func (g *Gossip) updateMembers(now time.Time) {
+	g.lock.Lock()
+	members := make([]string, 0, len(g.peers)+1)
+	if !g.self.Left {
+		members = append(members, g.self.DispatchAddr)
+	}
+
+	for addr, peer := range g.peers {
+		if peer.isLive(now, g.config.FailureTimeout) {
+			members = append(members, addr)
+			continue
+		}
+
+		if now.Sub(peer.updatedAt) > g.config.FailureTimeout*gossipRetentionFactor {
+			delete(g.peers, addr)
+		}
+	}
+	g.lock.Unlock()
+
+	previous := g.Members()
+	if g.set(members) {
+		slices.Sort(members)
+		log.Info().Strs("previous", previous).Strs("members", members).Msg("dispatch cluster membership changed")
+	}
+}
@@ -0,0 +1,152 @@
+package membership
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+const (
+	testGossipInterval       = 10 * time.Millisecond
+	testGossipFailureTimeout = 200 * time.Millisecond
+)
+
+func newTestGossip(t *testing.T, dispatchAddr string, key []byte, seeds ...string) *Gossip {
+	g, err := NewGossip(GossipConfig{
+		DispatchAddr:   dispatchAddr,
+		BindAddr:       "127.0.0.1:0",
+		Seeds:          seeds,
+		SecretKey:      key,
+		Interval:       testGossipInterval,
+		FailureTimeout: testGossipFailureTimeout,
+	})
+	require.NoError(t, err)
+	return g
+}
+
+func requireMembers(t *testing.T, expected []string, memberships ...*Gossip) {
+	t.Helper()
+	for _, g := range memberships {
+		require.Eventually(t, func() bool {
+			members := g.Members()
+			if len(members) != len(expected) {
+				return false
+			}
+			for index, member := range members {
+				if member != expected[index] {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, testGossipInterval)
+	}
+}
+
+func TestGossipJoinLeaveAndFailure(t *testing.T) {
+	key := []byte("secret")
+	first := newTestGossip(t, "node-a:50053", key)
+	second := newTestGossip(t, "node-b:50053", key, first.GossipAddr())
+	third := newTestGossip(t, "node-c:50053", key, first.GossipAddr())
+	t.Cleanup(func() {
+		require.NoError(t, first.Close())
+	})
+
+	requireMembers(t, []string{"node-a:50053", "node-b:50053", "node-c:50053"}, first, second, third)
+
+	// A node leaving is removed without waiting for the failure timeout.
+	require.NoError(t, third.Close())
+	requireMembers(t, []string{"node-a:50053", "node-b:50053"}, first, second)
+
+	// A node failing is removed once the failure timeout has passed.
+	second.stop(false)
+	requireMembers(t, []string{"node-a:50053"}, first)
+
+	// A node restarting at the same address rejoins.
+	restarted := newTestGossip(t, "node-b:50053", key, first.GossipAddr())
+	t.Cleanup(func() {
+		require.NoError(t, restarted.Close())
+	})
+	requireMembers(t, []string{"node-a:50053", "node-b:50053"}, first, restarted)
+}
+
+func TestGossipRejectsUnauthenticatedPeers(t *testing.T) {
+	first := newTestGossip(t, "node-a:50053", []byte("secret"))
+	other := newTestGossip(t, "node-b:50053", []byte("another"), first.GossipAddr())
+	t.Cleanup(func() {
+		require.NoError(t, other.Close())
+		require.NoError(t, first.Close())
+	})
+
+	time.Sleep(10 * testGossipInterval)
+	require.Equal(t, []string{"node-a:50053"}, first.Members())
+	require.Equal(t, []string{"node-b:50053"}, other.Members())
+
+	conn, err := net.Dial("tcp", first.GossipAddr())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(`{"payload":[{"dispatchAddr":"evil:50053","generation":1}]}` + "\n"))
+	require.NoError(t, err)
+
+	time.Sleep(5 * testGossipInterval)
+	require.Equal(t, []string{"node-a:50053"}, first.Members())
+}
+
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (fcc *fakeClientConn) UpdateState(state resolver.State) error {
+	fcc.states <- state
+	return nil
+}
+
+func (fcc *fakeClientConn) ReportError(error) {}
+
+func (fcc *fakeClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return nil
+}
+
+func TestResolverFollowsMembership(t *testing.T) {
+	first := newTestGossip(t, "node-a:50053", nil)
+	t.Cleanup(func() {
+		require.NoError(t, first.Close())
+	})
+
+	cc := &fakeClientConn{states: make(chan resolver.State, 100)}
+	r, err := NewResolverBuilder(first).Build(resolver.Target{}, cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	t.Cleanup(r.Close)
+
+	addrs := func(state resolver.State) []string {
+		found := make([]string, 0, len(state.Addresses))
+		for _, addr := range state.Addresses {
+			found = append(found, addr.Addr)
+		}
+		return found
+	}
+	require.Equal(t, []string{"node-a:50053"}, addrs(<-cc.states))
+
+	second := newTestGossip(t, "node-b:50053", nil, first.GossipAddr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for {
+		select {
+		case state := <-cc.states:
+			if len(state.Addresses) == 2 {
+				require.Equal(t, []string{"node-a:50053", "node-b:50053"}, addrs(state))
+				require.NoError(t, second.Close())
+				return
+			}
+		case <-ctx.Done():
+			require.Fail(t, "resolver was not updated with the new member")
+		}
+	}
+}
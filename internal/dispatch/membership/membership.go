@@ -0,0 +1,80 @@
+// Package membership provides sources for the members of a dispatch cluster, for use in place of
+// DNS or Kubernetes-based resolution of the upstream dispatch address. Members are supplied to
+// the dispatch hashring via a gRPC resolver, so that nodes can join and leave the cluster without
+// restarts.
+package membership
+
+import (
+	"slices"
+	"sync"
+)
+
+// Membership is a source of the dispatch addresses of the members of a cluster.
+type Membership interface {
+	// Members returns the dispatch addresses of the current members, sorted.
+	Members() []string
+
+	// Subscribe registers a function to be invoked with the dispatch addresses of the members
+	// whenever they change, and returns a function to unregister it. The function is invoked with
+	// the current members before Subscribe returns.
+	Subscribe(onChange func(members []string)) (unsubscribe func())
+
+	// Close stops the membership source.
+	Close() error
+}
+
+// memberSet holds the current members of a Membership and notifies subscribers of changes. It is
+// safe for concurrent use.
+type memberSet struct {
+	lock        sync.Mutex
+	members     []string
+	subscribers map[int]func([]string)
+	nextID      int
+}
+
+func newMemberSet() *memberSet {
+	return &memberSet{subscribers: map[int]func([]string){}}
+}
+
+func (ms *memberSet) Members() []string {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	return slices.Clone(ms.members)
+}
+
+func (ms *memberSet) Subscribe(onChange func(members []string)) func() {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	id := ms.nextID
+	ms.nextID++
+	ms.subscribers[id] = onChange
+	onChange(slices.Clone(ms.members))
+
+	return func() {
+		ms.lock.Lock()
+		defer ms.lock.Unlock()
+		delete(ms.subscribers, id)
+	}
+}
+
+// set replaces the members, notifying subscribers if they changed. Returns whether they changed.
+func (ms *memberSet) set(members []string) bool {
+	members = slices.Clone(members)
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if slices.Equal(ms.members, members) {
+		return false
+	}
+
+	ms.members = members
+	for _, onChange := range ms.subscribers {
+		onChange(slices.Clone(members))
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package membership
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/resolver"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+)
+
+// ResolverScheme is the scheme of the target addresses resolved by the resolver returned from
+// NewResolverBuilder, such as `membership:///dispatch`.
+const ResolverScheme = "membership"
+
+// NewResolverBuilder returns a gRPC resolver builder which resolves any target to the current
+// members of the given Membership, updating the connection as members join and leave. It is meant
+// to be given to the dispatch connection via grpc.WithResolvers, so that the members are fed to
+// the consistent hashring balancer.
+func NewResolverBuilder(m Membership) resolver.Builder {
+	return &resolverBuilder{m: m}
+}
+
+var errNoMembers = errors.New("no dispatch cluster members")
+
+type resolverBuilder struct {
+	m Membership
+}
+
+func (rb *resolverBuilder) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &membershipResolver{}
+	r.unsubscribe = rb.m.Subscribe(func(members []string) {
+		if len(members) == 0 {
+			cc.ReportError(errNoMembers)
+			return
+		}
+
+		addresses := make([]resolver.Address, 0, len(members))
+		for _, member := range members {
+			addresses = append(addresses, resolver.Address{Addr: member})
+		}
+
+		if err := cc.UpdateState(resolver.State{Addresses: addresses}); err != nil {
+			log.Warn().Err(err).Strs("members", members).Msg("failed to update dispatch connection with cluster members")
+		}
+	})
+	return r, nil
+}
+
+func (rb *resolverBuilder) Scheme() string {
+	return ResolverScheme
+}
+
+type membershipResolver struct {
+	unsubscribe func()
+}
+
+// ResolveNow is a no-op, as the connection is updated whenever the members change.
+func (r *membershipResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *membershipResolver) Close() {
+	r.unsubscribe()
+}
@@ -0,0 +1,119 @@
+package membership
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+)
+
+// DefaultStaticFileReloadInterval is the default interval at which a static peers file is checked
+// for changes.
+const DefaultStaticFileReloadInterval = 5 * time.Second
+
+// StaticFile is a Membership read from a file listing the dispatch address of each member, one
+// per line. Blank lines and lines starting with `#` are ignored. The file is reloaded whenever it
+// changes.
+type StaticFile struct {
+	*memberSet
+
+	path     string
+	contents []byte
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewStaticFile returns a Membership read from the file at the given path, which is checked for
+// changes at the given interval. Returns an error if the file cannot be read initially; later
+// errors are logged and the last members read are kept.
+func NewStaticFile(path string, reloadInterval time.Duration) (*StaticFile, error) {
+	sf := &StaticFile{
+		memberSet: newMemberSet(),
+		path:      path,
+		done:      make(chan struct{}),
+	}
+
+	if _, err := sf.reload(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sf.cancel = cancel
+	go sf.watch(ctx, reloadInterval)
+	return sf, nil
+}
+
+func (sf *StaticFile) watch(ctx context.Context, reloadInterval time.Duration) {
+	defer close(sf.done)
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			changed, err := sf.reload()
+			if err != nil {
+				log.Warn().Err(err).Str("path", sf.path).Msg("failed to reload dispatch cluster peers file; keeping the existing peers")
+				continue
+			}
+
+			if changed {
+				log.Info().Str("path", sf.path).Strs("peers", sf.Members()).Msg("reloaded dispatch cluster peers file")
+			}
+		}
+	}
+}
+
+// reload reads the file, updating the members if its contents changed. Returns whether the
+// members changed.
+func (sf *StaticFile) reload() (bool, error) {
+	contents, err := os.ReadFile(sf.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read dispatch cluster peers file: %w", err)
+	}
+
+	if sf.contents != nil && bytes.Equal(contents, sf.contents) {
+		return false, nil
+	}
+
+	members, err := parsePeers(contents)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse dispatch cluster peers file `%s`: %w", sf.path, err)
+	}
+
+	sf.contents = contents
+	return sf.set(members), nil
+}
+
+func parsePeers(contents []byte) ([]string, error) {
+	var members []string
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.ContainsAny(line, " \t") {
+			return nil, fmt.Errorf("invalid peer address `%s` on line %d", line, lineNumber)
+		}
+		members = append(members, line)
+	}
+	return members, scanner.Err()
+}
+
+// Close stops watching the file.
+func (sf *StaticFile) Close() error {
+	sf.cancel()
+	<-sf.done
+	return nil
+}
@@ -0,0 +1,49 @@
+package membership
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticFileReload(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "peers")
+	require.NoError(os.WriteFile(path, []byte(`
+		# the initial peers
+		node-b:50053
+		node-a:50053
+	`), 0o600))
+
+	sf, err := NewStaticFile(path, 10*time.Millisecond)
+	require.NoError(err)
+	t.Cleanup(func() { require.NoError(sf.Close()) })
+
+	require.Equal([]string{"node-a:50053", "node-b:50053"}, sf.Members())
+
+	updates := make(chan []string, 10)
+	unsubscribe := sf.Subscribe(func(members []string) {
+		updates <- members
+	})
+	t.Cleanup(unsubscribe)
+	require.Equal([]string{"node-a:50053", "node-b:50053"}, <-updates)
+
+	// A node joins and another leaves.
+	require.NoError(os.WriteFile(path, []byte("node-a:50053\nnode-c:50053\n"), 0o600))
+	require.Equal([]string{"node-a:50053", "node-c:50053"}, <-updates)
+
+	// An invalid file keeps the existing members.
+	require.NoError(os.WriteFile(path, []byte("node-a:50053 node-d:50053\n"), 0o600))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal([]string{"node-a:50053", "node-c:50053"}, sf.Members())
+	require.Empty(updates)
+}
+
+func TestStaticFileMissing(t *testing.T) {
+	_, err := NewStaticFile(filepath.Join(t.TempDir(), "missing"), time.Second)
+	require.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package outlier
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/authzed/consistent"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+const (
+	// BalancerName is the name of the balancer, for use in the service config of dispatch
+	// connections.
+	BalancerName = "consistent-hashring-outlier-ejection"
+
+	// maxRehashAttempts is the maximum number of times the key of a request owned by an ejected
+	// peer is rehashed to find another peer, before the request is failed to be evaluated locally.
+	maxRehashAttempts = 3
+)
+
+// BalancerConfig is the service config of the balancer, combining the configuration of the
+// ejection of peers with that of the consistent hashring.
+type BalancerConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	Config
+	Hashring *consistent.BalancerConfig `json:"hashring,omitempty"`
+}
+
+// ServiceConfigJSON encodes the config into the gRPC Service Config JSON format.
+func (c *BalancerConfig) ServiceConfigJSON() (string, error) {
+	type wrapper struct {
+		Config []map[string]*BalancerConfig `json:"loadBalancingConfig"`
+	}
+
+	j, err := json.Marshal(wrapper{Config: []map[string]*BalancerConfig{{BalancerName: c}}})
+	if err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
+
+// NewBuilder returns a balancer.Builder which ejects failing peers from the hashrings built by the
+// given consistent hashring builder.
+func NewBuilder(hashring consistent.Builder) balancer.Builder {
+	return &builder{hashring: hashring}
+}
+
+type builder struct {
+	hashring consistent.Builder
+}
+
+var (
+	_ balancer.Builder      = (*builder)(nil)
+	_ balancer.ConfigParser = (*builder)(nil)
+)
+
+func (b *builder) Name() string { return BalancerName }
+
+func (b *builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	bal := &ejectingBalancer{
+		tracker:  newTracker(),
+		subConns: map[balancer.SubConn]string{},
+	}
+	bal.child = b.hashring.Build(&ejectingClientConn{ClientConn: cc, balancer: bal}, opts)
+	return bal
+}
+
+func (b *builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var config BalancerConfig
+	if err := json.Unmarshal(js, &config); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal outlier ejection balancer config %s: %w", string(js), err)
+	}
+
+	hashringJSON := []byte("{}")
+	if config.Hashring != nil {
+		var err error
+		hashringJSON, err = json.Marshal(config.Hashring)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hashringConfig, err := b.hashring.ParseConfig(hashringJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Hashring = hashringConfig.(*consistent.BalancerConfig)
+	return &config, nil
+}
+
+// ejectingBalancer wraps a consistent hashring balancer, wrapping its pickers to skip ejected peers
+// and to observe the outcome of the calls made to each peer.
+type ejectingBalancer struct {
+	child   balancer.Balancer
+	tracker *tracker
+
+	lock     sync.RWMutex
+	subConns map[balancer.SubConn]string
+}
+
+func (b *ejectingBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	config := Config{}
+	if s.BalancerConfig != nil {
+		balancerConfig := s.BalancerConfig.(*BalancerConfig)
+		config = balancerConfig.Config
+		s.BalancerConfig = balancerConfig.Hashring
+	}
+
+	addrs := make([]string, 0, len(s.ResolverState.Addresses))
+	for _, addr := range s.ResolverState.Addresses {
+		addrs = append(addrs, addr.Addr)
+	}
+	b.tracker.update(config, addrs)
+
+	return b.child.UpdateClientConnState(s)
+}
+
+func (b *ejectingBalancer) ResolverError(err error) {
+	b.child.ResolverError(err)
+}
+
+func (b *ejectingBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	b.child.UpdateSubConnState(sc, state)
+}
+
+func (b *ejectingBalancer) Close() {
+	b.child.Close()
+}
+
+func (b *ejectingBalancer) subConnAddr(sc balancer.SubConn) (string, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	addr, ok := b.subConns[sc]
+	return addr, ok
+}
+
+// ejectingClientConn records the address of each SubConn created by the hashring balancer, and
+// wraps the pickers it produces.
+type ejectingClientConn struct {
+	balancer.ClientConn
+	balancer *ejectingBalancer
+}
+
+func (cc *ejectingClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := cc.ClientConn.NewSubConn(addrs, opts)
+	if err != nil || len(addrs) == 0 {
+		return sc, err
+	}
+
+	cc.balancer.lock.Lock()
+	defer cc.balancer.lock.Unlock()
+	cc.balancer.subConns[sc] = addrs[0].Addr
+	return sc, nil
+}
+
+func (cc *ejectingClientConn) RemoveSubConn(sc balancer.SubConn) {
+	cc.balancer.lock.Lock()
+	delete(cc.balancer.subConns, sc)
+	cc.balancer.lock.Unlock()
+
+	cc.ClientConn.RemoveSubConn(sc)
+}
+
+func (cc *ejectingClientConn) UpdateState(state balancer.State) {
+	state.Picker = &ejectingPicker{child: state.Picker, balancer: cc.balancer}
+	cc.ClientConn.UpdateState(state)
+}
+
+type ejectingPicker struct {
+	child    balancer.Picker
+	balancer *ejectingBalancer
+}
+
+// Pick picks the peer owning the request in the hashring. If that peer is ejected, the key of the
+// request is rehashed, deterministically, to pick another peer.
+func (p *ejectingPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	result, err := p.child.Pick(info)
+	if err != nil {
+		return result, err
+	}
+
+	addr, ok := p.balancer.subConnAddr(result.SubConn)
+	if !ok {
+		return result, nil
+	}
+
+	if p.balancer.tracker.isEjected(addr) {
+		key, ok := info.Ctx.Value(consistent.CtxKey).([]byte)
+		if !ok {
+			return result, nil
+		}
+
+		found := false
+		for attempt := uint32(1); attempt <= maxRehashAttempts; attempt++ {
+			info.Ctx = context.WithValue(info.Ctx, consistent.CtxKey, rehashedKey(key, attempt))
+			result, err = p.child.Pick(info)
+			if err != nil {
+				return result, err
+			}
+
+			addr, ok = p.balancer.subConnAddr(result.SubConn)
+			if !ok || !p.balancer.tracker.isEjected(addr) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			rehashedPicksCounter.WithLabelValues("exhausted").Inc()
+			return balancer.PickResult{}, ErrPeersEjected
+		}
+		rehashedPicksCounter.WithLabelValues("rehashed").Inc()
+	}
+
+	start := time.Now()
+	childDone := result.Done
+	result.Done = func(doneInfo balancer.DoneInfo) {
+		p.balancer.tracker.observe(addr, info.FullMethodName, time.Since(start), doneInfo.Err, exceededDispatchDeadline(info.Ctx))
+		if childDone != nil {
+			childDone(doneInfo)
+		}
+	}
+	return result, nil
+}
+
+// rehashedKey returns the key under which a request owned by an ejected peer is placed on the
+// hashring for the given attempt.
+func rehashedKey(key []byte, attempt uint32) []byte {
+	rehashed := make([]byte, 0, len(key)+5)
+	rehashed = append(rehashed, key...)
+	rehashed = append(rehashed, 0)
+	return binary.BigEndian.AppendUint32(rehashed, attempt)
+}
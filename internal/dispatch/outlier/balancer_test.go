@@ -0,0 +1,133 @@
+package outlier
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/authzed/consistent"
+	"github.com/cespare/xxhash/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func (*fakeSubConn) Connect() {}
+
+type fakeClientConn struct {
+	balancer.ClientConn
+	picker balancer.Picker
+}
+
+func (cc *fakeClientConn) NewSubConn(addrs []resolver.Address, _ balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	return &fakeSubConn{addr: addrs[0].Addr}, nil
+}
+
+func (cc *fakeClientConn) RemoveSubConn(balancer.SubConn) {}
+
+func (cc *fakeClientConn) UpdateState(state balancer.State) {
+	cc.picker = state.Picker
+}
+
+func TestBalancerConfig(t *testing.T) {
+	builder := NewBuilder(consistent.NewBuilder(xxhash.Sum64)).(balancer.ConfigParser)
+
+	serviceConfigJSON, err := (&BalancerConfig{
+		Config:   Config{ConsecutiveFailures: 2, BaseEjectionTime: time.Second},
+		Hashring: &consistent.BalancerConfig{ReplicationFactor: 50, Spread: 1},
+	}).ServiceConfigJSON()
+	require.NoError(t, err)
+
+	var serviceConfig struct {
+		Config []map[string]json.RawMessage `json:"loadBalancingConfig"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(serviceConfigJSON), &serviceConfig))
+	require.Len(t, serviceConfig.Config, 1)
+
+	parsed, err := builder.ParseConfig(serviceConfig.Config[0][BalancerName])
+	require.NoError(t, err)
+
+	config := parsed.(*BalancerConfig)
+	require.Equal(t, uint32(2), config.ConsecutiveFailures)
+	require.Equal(t, time.Second, config.BaseEjectionTime)
+	require.Equal(t, uint16(50), config.Hashring.ReplicationFactor)
+
+	// The hashring config defaults when absent.
+	parsed, err = builder.ParseConfig([]byte(`{}`))
+	require.NoError(t, err)
+	require.NotNil(t, parsed.(*BalancerConfig).Hashring)
+}
+
+func TestEjectingPicker(t *testing.T) {
+	cc := &fakeClientConn{}
+	bal := NewBuilder(consistent.NewBuilder(xxhash.Sum64)).Build(cc, balancer.BuildOptions{})
+	t.Cleanup(bal.Close)
+
+	addrs := []resolver.Address{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}, {Addr: "d"}}
+	require.NoError(t, bal.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: addrs},
+		BalancerConfig: &BalancerConfig{
+			Config:   Config{ConsecutiveFailures: 1, MaxEjectionPercent: 100},
+			Hashring: &consistent.BalancerConfig{ReplicationFactor: 100, Spread: 1},
+		},
+	}))
+	require.NotNil(t, cc.picker)
+
+	pick := func(key string) (string, error) {
+		result, err := cc.picker.Pick(balancer.PickInfo{
+			FullMethodName: checkMethod,
+			Ctx:            context.WithValue(context.Background(), consistent.CtxKey, []byte(key)),
+		})
+		if err != nil {
+			return "", err
+		}
+		result.Done(balancer.DoneInfo{})
+		return result.SubConn.(*fakeSubConn).addr, nil
+	}
+
+	owner, err := pick("somekey")
+	require.NoError(t, err)
+
+	// Requests are picked consistently.
+	for range 10 {
+		picked, err := pick("somekey")
+		require.NoError(t, err)
+		require.Equal(t, owner, picked)
+	}
+
+	// Once the owner fails, it is ejected and its requests are rehashed onto other peers.
+	result, err := cc.picker.Pick(balancer.PickInfo{
+		FullMethodName: checkMethod,
+		Ctx:            context.WithValue(context.Background(), consistent.CtxKey, []byte("somekey")),
+	})
+	require.NoError(t, err)
+	result.Done(balancer.DoneInfo{Err: ErrPeersEjected})
+
+	rehashed, err := pick("somekey")
+	require.NoError(t, err)
+	require.NotEqual(t, owner, rehashed)
+
+	for range 10 {
+		picked, err := pick("somekey")
+		require.NoError(t, err)
+		require.Equal(t, rehashed, picked)
+	}
+
+	// Once all other peers are ejected, the request fails so that it is evaluated locally.
+	b := bal.(*ejectingBalancer)
+	for _, addr := range addrs {
+		b.tracker.observe(addr.Addr, checkMethod, time.Millisecond, ErrPeersEjected, false)
+	}
+	for _, addr := range addrs {
+		require.True(t, b.tracker.isEjected(addr.Addr), "expected %s to be ejected", addr.Addr)
+	}
+
+	_, err = pick("somekey")
+	require.True(t, IsPeersEjectedError(err))
+}
@@ -0,0 +1,301 @@
+// Package outlier implements circuit breaking for the peers of a dispatch cluster. Peers which fail
+// or are slow to respond to consecutive dispatches are ejected from the consistent hashring for a
+// growing period of time, during which the keys they own are rehashed onto other ring members.
+package outlier
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+)
+
+const (
+	// DefaultConsecutiveFailures is the default number of consecutive failed dispatches after
+	// which a peer is ejected.
+	DefaultConsecutiveFailures = 5
+
+	// DefaultBaseEjectionTime is the default duration of the first ejection of a peer, which is
+	// multiplied by the number of times the peer has been ejected without recovering.
+	DefaultBaseEjectionTime = 30 * time.Second
+
+	// DefaultMaxEjectionTime is the default maximum duration of an ejection.
+	DefaultMaxEjectionTime = 5 * time.Minute
+
+	// DefaultMaxEjectionPercent is the default maximum percentage of the peers which may be
+	// ejected at once, so that a failure shared by all peers does not eject the whole cluster.
+	DefaultMaxEjectionPercent = 50
+
+	// dispatchServicePrefix is the prefix of the methods of the dispatch service, whose outcomes
+	// determine the health of peers.
+	dispatchServicePrefix = "/dispatch.v1.DispatchService/"
+
+	// checkMethod is the dispatch method to which the slow dispatch threshold applies, as other
+	// dispatch methods are streaming and may legitimately take long.
+	checkMethod = dispatchServicePrefix + "DispatchCheck"
+
+	errPeersEjectedMessage = "all candidate dispatch peers are ejected"
+)
+
+var (
+	ejectionsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "peer_ejections_total",
+		Help:      "number of times a dispatch peer was ejected from the hashring, by peer and reason",
+	}, []string{"peer", "reason"})
+
+	ejectedPeersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "ejected_peers",
+		Help:      "number of dispatch peers currently ejected from the hashring",
+	})
+
+	rehashedPicksCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "ejected_peer_rehashes_total",
+		Help:      "number of requests owned by an ejected peer, by whether they were rehashed to another peer",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(ejectionsCounter, ejectedPeersGauge, rehashedPicksCounter)
+}
+
+// Config configures the ejection of peers.
+type Config struct {
+	// ConsecutiveFailures is the number of consecutive failed dispatches after which a peer is
+	// ejected.
+	ConsecutiveFailures uint32 `json:"consecutiveFailures,omitempty"`
+
+	// BaseEjectionTime is the duration of the first ejection of a peer.
+	BaseEjectionTime time.Duration `json:"baseEjectionTime,omitempty"`
+
+	// MaxEjectionTime is the maximum duration of an ejection.
+	MaxEjectionTime time.Duration `json:"maxEjectionTime,omitempty"`
+
+	// MaxEjectionPercent is the maximum percentage of peers ejected at once.
+	MaxEjectionPercent uint32 `json:"maxEjectionPercent,omitempty"`
+
+	// SlowCheckThreshold is the duration above which a dispatched check counts as a failure.
+	// Zero disables the threshold.
+	SlowCheckThreshold time.Duration `json:"slowCheckThreshold,omitempty"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = DefaultConsecutiveFailures
+	}
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = DefaultBaseEjectionTime
+	}
+	if c.MaxEjectionTime < c.BaseEjectionTime {
+		c.MaxEjectionTime = max(DefaultMaxEjectionTime, c.BaseEjectionTime)
+	}
+	if c.MaxEjectionPercent == 0 || c.MaxEjectionPercent > 100 {
+		c.MaxEjectionPercent = DefaultMaxEjectionPercent
+	}
+	return c
+}
+
+// IsPeersEjectedError returns whether the error was returned because the peers owning a request
+// are all ejected, in which case the request should be evaluated locally.
+func IsPeersEjectedError(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Unavailable && s.Message() == errPeersEjectedMessage
+}
+
+// ErrPeersEjected is returned by the picker when the peers owning a request are all ejected.
+var ErrPeersEjected = status.Error(codes.Unavailable, errPeersEjectedMessage)
+
+// peerState is the circuit breaker of a single peer.
+type peerState struct {
+	consecutiveFailures uint32
+	ejections           uint32
+	ejectedUntil        time.Time
+}
+
+// tracker tracks the outcome of the dispatches made to each peer, ejecting failing peers.
+type tracker struct {
+	now func() time.Time
+
+	lock   sync.RWMutex
+	config Config
+	peers  map[string]*peerState
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		now:    time.Now,
+		config: Config{}.withDefaults(),
+		peers:  map[string]*peerState{},
+	}
+}
+
+// update sets the configuration and the known peers, forgetting the state of removed peers.
+func (t *tracker) update(config Config, addrs []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.config = config.withDefaults()
+
+	known := make(map[string]*peerState, len(addrs))
+	for _, addr := range addrs {
+		if state, ok := t.peers[addr]; ok {
+			known[addr] = state
+		} else {
+			known[addr] = &peerState{}
+		}
+	}
+	for addr, state := range t.peers {
+		if _, ok := known[addr]; !ok && !state.ejectedUntil.IsZero() {
+			ejectedPeersGauge.Dec()
+		}
+	}
+	t.peers = known
+}
+
+// isEjected returns whether the peer is currently ejected.
+func (t *tracker) isEjected(addr string) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	state, ok := t.peers[addr]
+	return ok && t.isEjectedLocked(state)
+}
+
+func (t *tracker) isEjectedLocked(state *peerState) bool {
+	return !state.ejectedUntil.IsZero() && t.now().Before(state.ejectedUntil)
+}
+
+// observe records the outcome of a call to a peer, and whether the call exceeded the timeout
+// imposed by the dispatcher.
+func (t *tracker) observe(addr string, method string, latency time.Duration, err error, exceededDispatchDeadline bool) {
+	if !strings.HasPrefix(method, dispatchServicePrefix) {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	reason := ""
+	switch {
+	case isPeerFailure(err, exceededDispatchDeadline):
+		reason = "error"
+	case err != nil:
+		// Errors caused by the request rather than the peer do not count.
+		return
+	case method == checkMethod && t.config.SlowCheckThreshold > 0 && latency > t.config.SlowCheckThreshold:
+		reason = "slow"
+	}
+
+	state, ok := t.peers[addr]
+	if !ok {
+		return
+	}
+
+	if !state.ejectedUntil.IsZero() && !t.isEjectedLocked(state) {
+		// The ejection has expired and the peer is being retried.
+		state.ejectedUntil = time.Time{}
+		ejectedPeersGauge.Dec()
+		if reason == "" {
+			log.Info().Str("peer", addr).Msg("dispatch peer recovered and was returned to the hashring")
+			state.ejections = 0
+			state.consecutiveFailures = 0
+			return
+		}
+
+		// A failure after an ejection immediately ejects the peer again.
+		state.consecutiveFailures = t.config.ConsecutiveFailures - 1
+	}
+
+	if reason == "" {
+		state.consecutiveFailures = 0
+		return
+	}
+
+	if t.isEjectedLocked(state) {
+		// Calls started before the ejection do not extend it.
+		return
+	}
+
+	state.consecutiveFailures++
+	if state.consecutiveFailures < t.config.ConsecutiveFailures {
+		return
+	}
+
+	if !t.canEjectLocked() {
+		log.Warn().Str("peer", addr).Str("reason", reason).Msg("dispatch peer is failing but was not ejected, as the maximum number of peers are ejected")
+		return
+	}
+
+	state.ejections++
+	ejectionTime := min(t.config.BaseEjectionTime*time.Duration(state.ejections), t.config.MaxEjectionTime)
+	state.ejectedUntil = t.now().Add(ejectionTime)
+	state.consecutiveFailures = 0
+
+	ejectionsCounter.WithLabelValues(addr, reason).Inc()
+	ejectedPeersGauge.Inc()
+	log.Warn().Str("peer", addr).Str("reason", reason).Err(err).Dur("duration", ejectionTime).
+		Msg("ejected failing dispatch peer from the hashring; its requests will be rehashed")
+}
+
+func (t *tracker) canEjectLocked() bool {
+	ejected := 0
+	for _, state := range t.peers {
+		if t.isEjectedLocked(state) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= len(t.peers)*int(t.config.MaxEjectionPercent)
+}
+
+type dispatchDeadlineKey struct{}
+
+// WithDispatchTimeout returns a context for a call to a peer, with the timeout imposed by the
+// dispatcher. If the call exceeds this timeout, the peer is considered to have failed; calls which
+// instead exceed an earlier deadline of the caller are not.
+func WithDispatchTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	return context.WithValue(ctx, dispatchDeadlineKey{}, deadline), cancel
+}
+
+// exceededDispatchDeadline returns whether the context has expired due to the timeout imposed by
+// the dispatcher, rather than the deadline of the caller.
+func exceededDispatchDeadline(ctx context.Context) bool {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false
+	}
+
+	imposed, ok := ctx.Value(dispatchDeadlineKey{}).(time.Time)
+	if !ok {
+		return false
+	}
+
+	deadline, _ := ctx.Deadline()
+	return deadline.Equal(imposed)
+}
+
+// isPeerFailure returns whether the error indicates that the peer, rather than the request, failed:
+// the peer was unavailable, such as due to a connection failure, or the call exceeded the timeout
+// imposed by the dispatcher. Other errors, including deadlines and resource exhaustion caused by
+// the request itself, do not count.
+func isPeerFailure(err error, exceededDispatchDeadline bool) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		return exceededDispatchDeadline
+	}
+	return status.Code(err) == codes.Unavailable
+}
@@ -0,0 +1,178 @@
+package outlier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = dispatchServicePrefix + "DispatchLookupSubjects"
+
+var errUnavailable = status.Error(codes.Unavailable, "unavailable")
+
+func newTestTracker(config Config, addrs ...string) (*tracker, *time.Time) {
+	now := time.Now()
+	t := newTracker()
+	t.now = func() time.Time { return now }
+	t.update(config, addrs)
+	return t, &now
+}
+
+func TestTrackerEjectsAfterConsecutiveFailures(t *testing.T) {
+	tr, _ := newTestTracker(Config{ConsecutiveFailures: 3}, "a", "b", "c", "d")
+
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	require.False(t, tr.isEjected("a"))
+
+	// A success resets the count of consecutive failures.
+	tr.observe("a", testMethod, time.Millisecond, nil, false)
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	require.False(t, tr.isEjected("a"))
+
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	require.True(t, tr.isEjected("a"))
+	require.False(t, tr.isEjected("b"))
+}
+
+func TestTrackerIgnoresUnrelatedOutcomes(t *testing.T) {
+	tr, _ := newTestTracker(Config{ConsecutiveFailures: 1}, "a", "b")
+
+	// Errors caused by the request do not count.
+	tr.observe("a", testMethod, time.Millisecond, status.Error(codes.InvalidArgument, "invalid"), false)
+	tr.observe("a", testMethod, time.Millisecond, errors.New("some error"), false)
+	tr.observe("a", testMethod, time.Millisecond, status.Error(codes.ResourceExhausted, "exhausted"), false)
+	require.False(t, tr.isEjected("a"))
+
+	// Deadlines only count if imposed by the dispatcher.
+	tr.observe("a", testMethod, time.Millisecond, status.Error(codes.DeadlineExceeded, "deadline"), false)
+	tr.observe("a", testMethod, time.Millisecond, context.DeadlineExceeded, false)
+	require.False(t, tr.isEjected("a"))
+
+	tr.observe("b", testMethod, time.Millisecond, status.Error(codes.DeadlineExceeded, "deadline"), true)
+	require.True(t, tr.isEjected("b"))
+
+	// Calls to other services do not count.
+	tr.observe("a", "/grpc.health.v1.Health/Check", time.Millisecond, errUnavailable, false)
+	require.False(t, tr.isEjected("a"))
+
+	// Unknown peers are ignored.
+	tr.observe("c", testMethod, time.Millisecond, errUnavailable, false)
+	require.False(t, tr.isEjected("c"))
+}
+
+func TestExceededDispatchDeadline(t *testing.T) {
+	// The dispatcher's timeout was exceeded.
+	ctx, cancel := WithDispatchTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	require.True(t, exceededDispatchDeadline(ctx))
+
+	// The caller's earlier deadline was exceeded.
+	callerCtx, callerCancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer callerCancel()
+	ctx, cancel = WithDispatchTimeout(callerCtx, time.Hour)
+	defer cancel()
+	<-ctx.Done()
+	require.False(t, exceededDispatchDeadline(ctx))
+
+	// The call was canceled.
+	ctx, cancel = WithDispatchTimeout(context.Background(), time.Hour)
+	cancel()
+	require.False(t, exceededDispatchDeadline(ctx))
+
+	// No timeout was imposed by the dispatcher.
+	require.False(t, exceededDispatchDeadline(callerCtx))
+}
+
+func TestTrackerSlowChecks(t *testing.T) {
+	tr, _ := newTestTracker(Config{ConsecutiveFailures: 1, SlowCheckThreshold: time.Second}, "a", "b")
+
+	// Slow streaming dispatches are expected.
+	tr.observe("a", testMethod, time.Minute, nil, false)
+	require.False(t, tr.isEjected("a"))
+
+	tr.observe("a", checkMethod, time.Millisecond, nil, false)
+	require.False(t, tr.isEjected("a"))
+
+	tr.observe("a", checkMethod, time.Minute, nil, false)
+	require.True(t, tr.isEjected("a"))
+}
+
+func TestTrackerMaxEjectionPercent(t *testing.T) {
+	tr, _ := newTestTracker(Config{ConsecutiveFailures: 1, MaxEjectionPercent: 50}, "a", "b", "c", "d")
+
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		tr.observe(addr, testMethod, time.Millisecond, errUnavailable, false)
+	}
+
+	ejected := 0
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		if tr.isEjected(addr) {
+			ejected++
+		}
+	}
+	require.Equal(t, 2, ejected)
+}
+
+func TestTrackerEjectionExpiry(t *testing.T) {
+	tr, now := newTestTracker(Config{
+		ConsecutiveFailures: 2,
+		BaseEjectionTime:    10 * time.Second,
+		MaxEjectionTime:     15 * time.Second,
+	}, "a", "b")
+
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	require.True(t, tr.isEjected("a"))
+
+	// Calls which complete during the ejection do not extend it.
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+
+	*now = now.Add(11 * time.Second)
+	require.False(t, tr.isEjected("a"))
+
+	// A failure once the ejection has expired ejects the peer again, for longer.
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	require.True(t, tr.isEjected("a"))
+
+	*now = now.Add(11 * time.Second)
+	require.True(t, tr.isEjected("a"))
+
+	*now = now.Add(5 * time.Second)
+	require.False(t, tr.isEjected("a"))
+
+	// A success once the ejection has expired readmits the peer.
+	tr.observe("a", testMethod, time.Millisecond, nil, false)
+	require.False(t, tr.isEjected("a"))
+	require.Equal(t, uint32(0), tr.peers["a"].ejections)
+
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	require.False(t, tr.isEjected("a"))
+}
+
+func TestTrackerForgetsRemovedPeers(t *testing.T) {
+	tr, _ := newTestTracker(Config{ConsecutiveFailures: 1}, "a", "b")
+
+	tr.observe("a", testMethod, time.Millisecond, errUnavailable, false)
+	require.True(t, tr.isEjected("a"))
+
+	tr.update(Config{ConsecutiveFailures: 1}, []string{"b", "c"})
+	require.False(t, tr.isEjected("a"))
+	require.Len(t, tr.peers, 2)
+
+	tr.update(Config{ConsecutiveFailures: 1}, []string{"a", "b", "c"})
+	require.False(t, tr.isEjected("a"))
+}
+
+func TestIsPeersEjectedError(t *testing.T) {
+	require.True(t, IsPeersEjectedError(ErrPeersEjected))
+	require.False(t, IsPeersEjectedError(errUnavailable))
+	require.False(t, IsPeersEjectedError(nil))
+}
@@ -0,0 +1,31 @@
+package peercache
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedEntry = errors.New("malformed shared dispatch cache entry")
+
+// encodeEntry encodes a key and its encoded value into the payload of a Set call.
+func encodeEntry(key []byte, value []byte) []byte {
+	encoded := make([]byte, 0, binary.MaxVarintLen64+len(key)+len(value))
+	encoded = binary.AppendUvarint(encoded, uint64(len(key)))
+	encoded = append(encoded, key...)
+	return append(encoded, value...)
+}
+
+// decodeEntry decodes the payload of a Set call into its key and encoded value.
+func decodeEntry(encoded []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(encoded)
+	if n <= 0 || length == 0 || length > uint64(len(encoded)-n) {
+		return nil, nil, errMalformedEntry
+	}
+
+	key := encoded[n : n+int(length)]
+	value := encoded[n+int(length):]
+	if len(value) == 0 {
+		return nil, nil, errMalformedEntry
+	}
+	return key, value, nil
+}
@@ -0,0 +1,282 @@
+// Package peercache implements a second tier for the dispatch cache which is shared between the
+// nodes of a dispatch cluster. Each entry is owned by the node selected for its key by the
+// dispatch hashring, so that a result computed on any node can be served to every other node and
+// hot entries are stored once per cluster rather than once per node.
+package peercache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/zapravila/spicedb/internal/dispatch/caching"
+	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/pkg/cache"
+)
+
+const (
+	// DefaultLookupTimeout is the default maximum duration of a lookup in the shared tier, after
+	// which the lookup is treated as a miss.
+	DefaultLookupTimeout = 25 * time.Millisecond
+
+	// DefaultStoreTimeout is the default maximum duration of storing an entry in the shared tier.
+	DefaultStoreTimeout = 1 * time.Second
+
+	// DefaultNegativeLookupTTL is the default duration for which a key missing from the shared
+	// tier, or whose lookup failed, is not looked up again.
+	DefaultNegativeLookupTTL = 1 * time.Second
+
+	// pendingStoreLimit is the maximum number of entries waiting to be stored in the shared tier;
+	// further entries are dropped.
+	pendingStoreLimit = 1024
+
+	// negativeLookupLimit is the maximum number of keys recorded as missing from the shared tier.
+	negativeLookupLimit = 100_000
+
+	localTier  = "local"
+	sharedTier = "shared"
+)
+
+var (
+	tierLookupsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "cache_tier_lookups_total",
+		Help:      "number of dispatch cache lookups per cache tier, by whether the entry was found",
+	}, []string{"tier", "result"})
+
+	sharedTierStoresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "cache_shared_tier_stores_total",
+		Help:      "number of entries sent to be stored in the shared dispatch cache tier, by outcome",
+	}, []string{"result"})
+
+	sharedTierStoredBytesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "dispatch",
+		Name:      "cache_shared_tier_stored_bytes_total",
+		Help:      "number of bytes sent to be stored in the shared dispatch cache tier",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(tierLookupsCounter, sharedTierStoresCounter, sharedTierStoredBytesCounter)
+}
+
+// Option is a function-style option for configuring a Cache.
+type Option func(*Cache)
+
+// LookupTimeout sets the maximum duration of a lookup in the shared tier.
+func LookupTimeout(timeout time.Duration) Option {
+	return func(c *Cache) {
+		c.lookupTimeout = timeout
+	}
+}
+
+// NegativeLookupTTL sets the duration for which a key missing from the shared tier, or whose
+// lookup failed, is not looked up again. Zero disables negative caching.
+func NegativeLookupTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.negativeLookupTTL = ttl
+	}
+}
+
+// Cache is a dispatch cache which looks up entries missing from a local cache in the shared tier,
+// and stores entries in both. Entries found in the shared tier are added to the local cache.
+//
+// As lookups in the shared tier are synchronous, keys missing from the shared tier or whose
+// lookup failed are recorded for a short duration, during which they are treated as misses
+// without a further lookup.
+type Cache struct {
+	local             cache.Cache[keys.DispatchCacheKey, any]
+	client            Client
+	lookupTimeout     time.Duration
+	negativeLookupTTL time.Duration
+	negative          cache.Cache[keys.DispatchCacheKey, struct{}]
+
+	pending chan pendingStore
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type pendingStore struct {
+	key   []byte
+	value []byte
+}
+
+var _ cache.Cache[keys.DispatchCacheKey, any] = (*Cache)(nil)
+
+// NewCache returns a Cache in front of the shared tier reached via the given client.
+func NewCache(local cache.Cache[keys.DispatchCacheKey, any], client Client, options ...Option) (*Cache, error) {
+	if local == nil {
+		local = cache.NoopCache[keys.DispatchCacheKey, any]()
+	}
+
+	c := &Cache{
+		local:             local,
+		client:            client,
+		lookupTimeout:     DefaultLookupTimeout,
+		negativeLookupTTL: DefaultNegativeLookupTTL,
+		negative:          cache.NoopCache[keys.DispatchCacheKey, struct{}](),
+		pending:           make(chan pendingStore, pendingStoreLimit),
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.negativeLookupTTL > 0 {
+		negative, err := cache.NewStandardCache[keys.DispatchCacheKey, struct{}](&cache.Config{
+			NumCounters: negativeLookupLimit * 10,
+			MaxCost:     negativeLookupLimit,
+			DefaultTTL:  c.negativeLookupTTL,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.negative = negative
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	c.wg.Add(1)
+	go c.store(ctx)
+	return c, nil
+}
+
+// Get returns the entry from the local cache if present, otherwise from the shared tier.
+func (c *Cache) Get(key keys.DispatchCacheKey) (any, bool) {
+	if value, ok := c.local.Get(key); ok {
+		tierLookupsCounter.WithLabelValues(localTier, "hit").Inc()
+		return value, true
+	}
+	tierLookupsCounter.WithLabelValues(localTier, "miss").Inc()
+
+	if _, ok := c.negative.Get(key); ok {
+		tierLookupsCounter.WithLabelValues(sharedTier, "skipped").Inc()
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.lookupTimeout)
+	defer cancel()
+
+	encoded, err := c.client.Get(ctx, key.StableKey())
+	if err != nil {
+		log.Debug().Err(err).Msg("failed to look up entry in shared dispatch cache")
+		tierLookupsCounter.WithLabelValues(sharedTier, "error").Inc()
+		c.negative.Set(key, struct{}{}, 1)
+		return nil, false
+	}
+	if encoded == nil {
+		tierLookupsCounter.WithLabelValues(sharedTier, "miss").Inc()
+		c.negative.Set(key, struct{}{}, 1)
+		return nil, false
+	}
+
+	value, cost, err := caching.DecodeCachedResult(encoded)
+	if err != nil {
+		log.Warn().Err(err).Msg("received invalid entry from shared dispatch cache")
+		tierLookupsCounter.WithLabelValues(sharedTier, "error").Inc()
+		return nil, false
+	}
+
+	tierLookupsCounter.WithLabelValues(sharedTier, "hit").Inc()
+	c.local.Set(key, value, cost)
+	return value, true
+}
+
+// Set stores the entry in the local cache, and queues it to be stored in the shared tier.
+func (c *Cache) Set(key keys.DispatchCacheKey, value any, cost int64) bool {
+	added := c.local.Set(key, value, cost)
+
+	encoded, ok := caching.EncodeCachedResult(value)
+	if !ok {
+		return added
+	}
+
+	select {
+	case c.pending <- pendingStore{key: key.StableKey(), value: encoded}:
+	default:
+		sharedTierStoresCounter.WithLabelValues("dropped").Inc()
+	}
+	return added
+}
+
+func (c *Cache) store(ctx context.Context) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case entry := <-c.pending:
+			storeCtx, cancel := context.WithTimeout(ctx, DefaultStoreTimeout)
+			err := c.client.Set(storeCtx, entry.key, entry.value)
+			cancel()
+			if err != nil {
+				log.Debug().Err(err).Msg("failed to store entry in shared dispatch cache")
+				sharedTierStoresCounter.WithLabelValues("error").Inc()
+				continue
+			}
+
+			sharedTierStoresCounter.WithLabelValues("stored").Inc()
+			sharedTierStoredBytesCounter.Add(float64(len(entry.key) + len(entry.value)))
+		}
+	}
+}
+
+// Wait waits for the local cache to apply updates.
+func (c *Cache) Wait() {
+	c.local.Wait()
+}
+
+// Close stops storing entries in the shared tier and closes the local cache.
+func (c *Cache) Close() {
+	c.cancel()
+	c.wg.Wait()
+	c.negative.Close()
+	c.local.Close()
+}
+
+// GetMetrics returns the metrics of the local cache; metrics for the shared tier are reported to
+// prometheus by this package and by the cache of each node owning shared entries.
+func (c *Cache) GetMetrics() cache.Metrics {
+	return c.local.GetMetrics()
+}
+
+func (c *Cache) MarshalZerologObject(e *zerolog.Event) {
+	e.Object("local", c.local).Bool("shared", true).Dur("lookupTimeout", c.lookupTimeout).Dur("negativeLookupTTL", c.negativeLookupTTL)
+}
+
+// WithoutSharedLookups returns a view of the cache which stores entries in both tiers, but looks
+// them up only in the local cache. It is used in front of dispatches to other nodes: a request
+// missing from the local cache is dispatched to the node owning it, which has its own cache, so
+// looking it up in the shared tier first would only add a synchronous call to its latency.
+func (c *Cache) WithoutSharedLookups() cache.Cache[keys.DispatchCacheKey, any] {
+	return &localLookupsCache{c}
+}
+
+type localLookupsCache struct {
+	*Cache
+}
+
+func (llc *localLookupsCache) Get(key keys.DispatchCacheKey) (any, bool) {
+	value, ok := llc.local.Get(key)
+	if ok {
+		tierLookupsCounter.WithLabelValues(localTier, "hit").Inc()
+	} else {
+		tierLookupsCounter.WithLabelValues(localTier, "miss").Inc()
+	}
+	return value, ok
+}
+
+func (llc *localLookupsCache) MarshalZerologObject(e *zerolog.Event) {
+	llc.Cache.MarshalZerologObject(e)
+	e.Bool("sharedLookups", false)
+}
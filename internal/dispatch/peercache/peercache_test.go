@@ -0,0 +1,223 @@
+package peercache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/authzed/grpcutil"
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	"github.com/zapravila/spicedb/pkg/cache"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+)
+
+func newTestCache(t *testing.T) cache.Cache[keys.DispatchCacheKey, any] {
+	c, err := cache.NewStandardCache[keys.DispatchCacheKey, any](&cache.Config{
+		NumCounters: 1000,
+		MaxCost:     humanize.MiByte,
+	})
+	require.NoError(t, err)
+	return c
+}
+
+func newTestSharedTier(t *testing.T) (cache.Cache[cache.StringKey, []byte], *grpc.ClientConn) {
+	shared, err := cache.NewStandardCache[cache.StringKey, []byte](&cache.Config{
+		NumCounters: 1000,
+		MaxCost:     humanize.MiByte,
+	})
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(humanize.MiByte)
+	srv := grpc.NewServer()
+	RegisterServer(srv, shared)
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, conn.Close())
+		srv.Stop()
+		shared.Close()
+	})
+	return shared, conn
+}
+
+func checkKey(t *testing.T, resourceID string, revision string) keys.DispatchCacheKey {
+	key, err := (&keys.DirectKeyHandler{}).CheckCacheKey(context.Background(), &v1.DispatchCheckRequest{
+		ResourceRelation: &core.RelationReference{Namespace: "document", Relation: "view"},
+		ResourceIds:      []string{resourceID},
+		Subject:          &core.ObjectAndRelation{Namespace: "user", ObjectId: "tom", Relation: "..."},
+		Metadata:         &v1.ResolverMeta{AtRevision: revision, DepthRemaining: 50},
+	})
+	require.NoError(t, err)
+	return key
+}
+
+func TestSharedTierAcrossNodes(t *testing.T) {
+	shared, conn := newTestSharedTier(t)
+
+	first, err := NewCache(newTestCache(t), NewClient(conn), LookupTimeout(time.Second), NegativeLookupTTL(0))
+	require.NoError(t, err)
+	t.Cleanup(first.Close)
+	second, err := NewCache(newTestCache(t), NewClient(conn), LookupTimeout(time.Second), NegativeLookupTTL(0))
+	require.NoError(t, err)
+	t.Cleanup(second.Close)
+
+	checkResult := []byte("check result")
+	streamedResults := [][]byte{[]byte("first result"), []byte("second result")}
+
+	first.Set(checkKey(t, "first", "1"), checkResult, int64(len(checkResult)))
+	first.Set(checkKey(t, "second", "1"), streamedResults, 32)
+
+	require.Eventually(t, func() bool {
+		shared.Wait()
+		_, ok := second.Get(checkKey(t, "second", "1"))
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	found, ok := second.Get(checkKey(t, "first", "1"))
+	require.True(t, ok)
+	require.Equal(t, checkResult, found)
+
+	found, ok = second.Get(checkKey(t, "second", "1"))
+	require.True(t, ok)
+	require.Equal(t, streamedResults, found)
+
+	// Entries are scoped to the revision at which they were computed.
+	_, ok = second.Get(checkKey(t, "first", "2"))
+	require.False(t, ok)
+
+	// Entries found in the shared tier are added to the local tier.
+	second.Wait()
+	found, ok = second.local.Get(checkKey(t, "first", "1"))
+	require.True(t, ok)
+	require.Equal(t, checkResult, found)
+}
+
+func TestSharedTierKeysIncludeSecondaryHash(t *testing.T) {
+	key := checkKey(t, "first", "1")
+	require.Greater(t, len(key.StableKey()), len(key.StableSumAsBytes())+len("1"))
+}
+
+type countingClient struct {
+	lookups atomic.Int32
+	stores  atomic.Int32
+}
+
+func (cc *countingClient) Get(context.Context, []byte) ([]byte, error) {
+	cc.lookups.Add(1)
+	return nil, nil
+}
+
+func (cc *countingClient) Set(context.Context, []byte, []byte) error {
+	cc.stores.Add(1)
+	return nil
+}
+
+func TestSharedTierNegativeLookups(t *testing.T) {
+	client := &countingClient{}
+	c, err := NewCache(newTestCache(t), client, NegativeLookupTTL(time.Minute))
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	key := checkKey(t, "first", "1")
+	_, ok := c.Get(key)
+	require.False(t, ok)
+	require.Equal(t, int32(1), client.lookups.Load())
+
+	// A key recently missing from the shared tier is not looked up again.
+	c.negative.Wait()
+	_, ok = c.Get(key)
+	require.False(t, ok)
+	require.Equal(t, int32(1), client.lookups.Load())
+
+	_, ok = c.Get(checkKey(t, "second", "1"))
+	require.False(t, ok)
+	require.Equal(t, int32(2), client.lookups.Load())
+}
+
+func TestWithoutSharedLookups(t *testing.T) {
+	client := &countingClient{}
+	c, err := NewCache(newTestCache(t), client, NegativeLookupTTL(0))
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	view := c.WithoutSharedLookups()
+	key := checkKey(t, "first", "1")
+	_, ok := view.Get(key)
+	require.False(t, ok)
+	require.Equal(t, int32(0), client.lookups.Load())
+
+	// Entries are still stored in both tiers.
+	view.Set(key, []byte("check result"), 12)
+	view.Wait()
+	require.Eventually(t, func() bool {
+		return client.stores.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	found, ok := view.Get(key)
+	require.True(t, ok)
+	require.Equal(t, []byte("check result"), found)
+	require.Equal(t, int32(0), client.lookups.Load())
+}
+
+type failingClient struct{}
+
+func (failingClient) Get(context.Context, []byte) ([]byte, error) {
+	return nil, context.DeadlineExceeded
+}
+
+func (failingClient) Set(context.Context, []byte, []byte) error {
+	return context.DeadlineExceeded
+}
+
+func TestSharedTierUnavailable(t *testing.T) {
+	c, err := NewCache(newTestCache(t), failingClient{})
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	key := checkKey(t, "first", "1")
+	_, ok := c.Get(key)
+	require.False(t, ok)
+
+	c.Set(key, []byte("check result"), 12)
+	c.Wait()
+
+	found, ok := c.Get(key)
+	require.True(t, ok)
+	require.Equal(t, []byte("check result"), found)
+}
+
+func TestSharedTierRejectsMalformedEntries(t *testing.T) {
+	_, conn := newTestSharedTier(t)
+
+	for _, payload := range [][]byte{
+		nil,
+		{0x05, 'a'},
+		encodeEntry([]byte("key"), nil),
+		encodeEntry([]byte("key"), []byte{0xff}),
+		encodeEntry([]byte("key"), []byte{0x02, 0x02, 0x01, 'a'}),
+	} {
+		err := conn.Invoke(context.Background(), setMethod, wrapperspb.Bytes(payload), new(wrapperspb.BytesValue))
+		grpcutil.RequireStatus(t, codes.InvalidArgument, err)
+	}
+}
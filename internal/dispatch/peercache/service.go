@@ -0,0 +1,146 @@
+package peercache
+
+import (
+	"context"
+
+	"github.com/authzed/consistent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/zapravila/spicedb/internal/dispatch/caching"
+	"github.com/zapravila/spicedb/pkg/cache"
+)
+
+const (
+	serviceName = "spicedb.dispatchcache.v1.DispatchCacheService"
+	getMethod   = "/" + serviceName + "/Get"
+	setMethod   = "/" + serviceName + "/Set"
+)
+
+// cacheServiceServer is the server API for the shared dispatch cache service, served alongside
+// the dispatch service on each node of the cluster.
+type cacheServiceServer interface {
+	// Get returns the encoded value for the peer key given, or an empty value if none is found.
+	Get(context.Context, *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+
+	// Set stores the encoded entry given.
+	Set(context.Context, *wrapperspb.BytesValue) (*emptypb.Empty, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*cacheServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(wrapperspb.BytesValue)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(cacheServiceServer).Get(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: getMethod}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(cacheServiceServer).Get(ctx, req.(*wrapperspb.BytesValue))
+				})
+			},
+		},
+		{
+			MethodName: "Set",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(wrapperspb.BytesValue)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(cacheServiceServer).Set(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: setMethod}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(cacheServiceServer).Set(ctx, req.(*wrapperspb.BytesValue))
+				})
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// RegisterServer registers the shared dispatch cache service with the given server, storing the
+// entries owned by this node in the given cache.
+func RegisterServer(srv *grpc.Server, shared cache.Cache[cache.StringKey, []byte]) {
+	srv.RegisterService(&serviceDesc, &cacheServer{shared: shared})
+}
+
+type cacheServer struct {
+	shared cache.Cache[cache.StringKey, []byte]
+}
+
+func (cs *cacheServer) Get(_ context.Context, req *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	if len(req.Value) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing shared dispatch cache key")
+	}
+
+	value, ok := cs.shared.Get(cache.StringKey(req.Value))
+	if !ok {
+		return &wrapperspb.BytesValue{}, nil
+	}
+	return &wrapperspb.BytesValue{Value: value}, nil
+}
+
+func (cs *cacheServer) Set(_ context.Context, req *wrapperspb.BytesValue) (*emptypb.Empty, error) {
+	key, value, err := decodeEntry(req.Value)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, _, err := caching.DecodeCachedResult(value); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	cs.shared.Set(cache.StringKey(key), value, int64(len(key)+len(value)))
+	return &emptypb.Empty{}, nil
+}
+
+// Client looks up and stores dispatch results in the shared cache of the peer owning them.
+type Client interface {
+	// Get returns the encoded value for the peer key, or nil if none is found.
+	Get(ctx context.Context, key []byte) ([]byte, error)
+
+	// Set stores the encoded value under the peer key.
+	Set(ctx context.Context, key []byte, value []byte) error
+}
+
+// NewClient returns a Client which invokes the shared dispatch cache service over the given
+// connection. The connection is expected to use the consistent hashring balancer, with the peer
+// key being used to select the owning peer.
+func NewClient(conn grpc.ClientConnInterface) Client {
+	return &grpcClient{conn: conn}
+}
+
+type grpcClient struct {
+	conn grpc.ClientConnInterface
+}
+
+func (gc *grpcClient) Get(ctx context.Context, key []byte) ([]byte, error) {
+	ctx = context.WithValue(ctx, consistent.CtxKey, key)
+
+	resp := new(wrapperspb.BytesValue)
+	if err := gc.conn.Invoke(ctx, getMethod, wrapperspb.Bytes(key), resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Value) == 0 {
+		return nil, nil
+	}
+	return resp.Value, nil
+}
+
+func (gc *grpcClient) Set(ctx context.Context, key []byte, value []byte) error {
+	ctx = context.WithValue(ctx, consistent.CtxKey, key)
+	return gc.conn.Invoke(ctx, setMethod, wrapperspb.Bytes(encodeEntry(key, value)), new(emptypb.Empty))
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 
 	"github.com/zapravila/spicedb/internal/dispatch"
 	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	"github.com/zapravila/spicedb/internal/dispatch/outlier"
 	log "github.com/zapravila/spicedb/internal/logging"
 	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
 	"github.com/zapravila/spicedb/pkg/spiceerrors"
@@ -49,6 +51,23 @@ type ClusterDispatcherConfig struct {
 	// DispatchOverallTimeout is the maximum duration of a dispatched request
 	// before it should timeout.
 	DispatchOverallTimeout time.Duration
+
+	// LocalFallback, if given, evaluates requests which cannot be dispatched
+	// because the peers owning them are all ejected from the hashring.
+	LocalFallback dispatch.Dispatcher
+
+	// Zone is the zone of this node and of the primary upstream. Secondary
+	// dispatchers in the same zone are dispatched to alongside the primary.
+	Zone string
+
+	// CrossZoneLatencyBudget is the duration to wait for the upstreams of
+	// this node's zone before also dispatching to the secondary dispatchers
+	// of other zones. Defaults to DefaultCrossZoneLatencyBudget.
+	CrossZoneLatencyBudget time.Duration
+
+	// ZoneLatencyBudgets overrides CrossZoneLatencyBudget for the secondary
+	// dispatchers of specific zones.
+	ZoneLatencyBudgets map[string]time.Duration
 }
 
 // SecondaryDispatch defines a struct holding a client and its name for secondary
@@ -56,6 +75,10 @@ type ClusterDispatcherConfig struct {
 type SecondaryDispatch struct {
 	Name   string
 	Client ClusterClient
+
+	// Zone is the zone of the secondary upstream, used to prefer the
+	// upstreams in the same zone as this node.
+	Zone string
 }
 
 // NewClusterDispatcher creates a dispatcher implementation that uses the provided client
@@ -71,6 +94,11 @@ func NewClusterDispatcher(client ClusterClient, conn *grpc.ClientConn, config Cl
 		dispatchOverallTimeout = 60 * time.Second
 	}
 
+	crossZoneLatencyBudget := config.CrossZoneLatencyBudget
+	if crossZoneLatencyBudget <= 0 {
+		crossZoneLatencyBudget = DefaultCrossZoneLatencyBudget
+	}
+
 	return &clusterDispatcher{
 		clusterClient:          client,
 		conn:                   conn,
@@ -78,6 +106,10 @@ func NewClusterDispatcher(client ClusterClient, conn *grpc.ClientConn, config Cl
 		dispatchOverallTimeout: dispatchOverallTimeout,
 		secondaryDispatch:      secondaryDispatch,
 		secondaryDispatchExprs: secondaryDispatchExprs,
+		localFallback:          config.LocalFallback,
+		locality:               newLocality(config.Zone, secondaryDispatch),
+		crossZoneLatencyBudget: crossZoneLatencyBudget,
+		zoneLatencyBudgets:     config.ZoneLatencyBudgets,
 	}
 }
 
@@ -88,6 +120,21 @@ type clusterDispatcher struct {
 	dispatchOverallTimeout time.Duration
 	secondaryDispatch      map[string]SecondaryDispatch
 	secondaryDispatchExprs map[string]*DispatchExpr
+	localFallback          dispatch.Dispatcher
+	locality               Locality
+	crossZoneLatencyBudget time.Duration
+	zoneLatencyBudgets     map[string]time.Duration
+}
+
+// shouldFallBack returns whether a request which failed to be dispatched with
+// the given error should be evaluated locally instead.
+func (cr *clusterDispatcher) shouldFallBack(requestKind string, err error) bool {
+	if cr.localFallback == nil || !outlier.IsPeersEjectedError(err) {
+		return false
+	}
+
+	dispatchCounter.WithLabelValues(requestKind, "(local)").Add(1)
+	return true
 }
 
 func (cr *clusterDispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
@@ -95,6 +142,11 @@ func (cr *clusterDispatcher) DispatchCheck(ctx context.Context, req *v1.Dispatch
 		return &v1.DispatchCheckResponse{Metadata: emptyMetadata}, err
 	}
 
+	if err := dispatch.CheckDispatchBudget(ctx); err != nil {
+		return &v1.DispatchCheckResponse{Metadata: emptyMetadata}, err
+	}
+	ctx = dispatch.OutgoingContextWithDispatchBudget(ctx)
+
 	requestKey, err := cr.keyHandler.CheckDispatchKey(ctx, req)
 	if err != nil {
 		return &v1.DispatchCheckResponse{Metadata: emptyMetadata}, err
@@ -108,9 +160,12 @@ func (cr *clusterDispatcher) DispatchCheck(ctx context.Context, req *v1.Dispatch
 			return resp, err
 		}
 
-		err = adjustMetadataForDispatch(resp.Metadata)
+		err = adjustMetadataForDispatch(ctx, resp.Metadata)
 		return resp, err
 	})
+	if cr.shouldFallBack("check", err) {
+		return cr.localFallback.DispatchCheck(ctx, req)
+	}
 	if err != nil {
 		return &v1.DispatchCheckResponse{Metadata: requestFailureMetadata}, err
 	}
@@ -135,13 +190,20 @@ type respTuple[S responseMessage] struct {
 	err  error
 }
 
-type secondaryRespTuple[S responseMessage] struct {
-	handlerName string
-	resp        S
+type candidateRespTuple[S responseMessage] struct {
+	index    int
+	resp     S
+	err      error
+	duration time.Duration
 }
 
+// dispatchRequest dispatches the request to the primary and to any secondary dispatchers selected
+// by the dispatch expression of the request kind, returning the first successful response. The
+// upstreams of this node's zone are dispatched to immediately, while those of other zones are
+// only dispatched to once their latency budget has elapsed without a response, or once all
+// upstreams dispatched to before them have failed.
 func dispatchRequest[Q requestMessage, S responseMessage](ctx context.Context, cr *clusterDispatcher, reqKey string, req Q, handler func(context.Context, ClusterClient) (S, error)) (S, error) {
-	withTimeout, cancelFn := context.WithTimeout(ctx, cr.dispatchOverallTimeout)
+	withTimeout, cancelFn := outlier.WithDispatchTimeout(ctx, cr.dispatchOverallTimeout)
 	defer cancelFn()
 
 	if len(cr.secondaryDispatchExprs) == 0 || len(cr.secondaryDispatch) == 0 {
@@ -154,66 +216,94 @@ func dispatchRequest[Q requestMessage, S responseMessage](ctx context.Context, c
 		return handler(withTimeout, cr.clusterClient)
 	}
 
-	// Otherwise invoke in parallel with any secondary matches.
-	primaryResultChan := make(chan respTuple[S], 1)
-	secondaryResultChan := make(chan secondaryRespTuple[S], len(cr.secondaryDispatch))
-
-	// Run the main dispatch.
-	go func() {
-		resp, err := handler(withTimeout, cr.clusterClient)
-		primaryResultChan <- respTuple[S]{resp, err}
-	}()
-
-	result, err := RunDispatchExpr(expr, req)
+	result, err := RunDispatchExpr(expr, req, cr.locality)
 	if err != nil {
 		log.Warn().Err(err).Msg("error when trying to evaluate the dispatch expression")
 	}
 
 	log.Trace().Str("secondary-dispatchers", strings.Join(result, ",")).Object("request", req).Msg("running secondary dispatchers")
 
-	for _, secondaryDispatchName := range result {
-		secondary, ok := cr.secondaryDispatch[secondaryDispatchName]
-		if !ok {
-			log.Warn().Str("secondary-dispatcher-name", secondaryDispatchName).Msg("received unknown secondary dispatcher")
-			continue
-		}
-
-		log.Trace().Str("secondary-dispatcher", secondary.Name).Object("request", req).Msg("running secondary dispatcher")
-		go func() {
-			resp, err := handler(withTimeout, secondary.Client)
-			if err != nil {
-				// For secondary dispatches, ignore any errors, as only the primary will be handled in
-				// that scenario.
-				log.Trace().Str("secondary", secondary.Name).Err(err).Msg("got ignored secondary dispatch error")
-				return
+	candidates := cr.dispatchCandidates(result, true)
+	resultChan := make(chan candidateRespTuple[S], len(candidates))
+	completed := make([]bool, len(candidates))
+	started := time.Now()
+	next := 0
+
+	// The timer fires when the latency budget of the next candidate elapses. Spurious firings,
+	// e.g. of a stale expiry, are harmless.
+	budgetTimer := time.NewTimer(0)
+	defer budgetTimer.Stop()
+
+	abandonRemaining := func() {
+		for index := range next {
+			if !completed[index] {
+				recordZoneDispatch(reqKey, candidates[index].zone, zoneOutcomeAbandoned, 0)
 			}
-
-			secondaryResultChan <- secondaryRespTuple[S]{resp: resp, handlerName: secondary.Name}
-		}()
+		}
 	}
 
-	var foundError error
-	select {
-	case <-withTimeout.Done():
-		return *new(S), fmt.Errorf("check dispatch has timed out")
+	var primaryErr, lastErr error
+	for {
+		// Dispatch to every candidate whose latency budget has elapsed or, if all dispatches so far
+		// have failed, to the next candidate.
+		for next < len(candidates) && (candidates[next].delay <= time.Since(started) || slices.Index(completed[:next], false) < 0) {
+			index := next
+			candidate := candidates[index]
+			next++
+
+			log.Trace().Str("dispatcher", candidate.handlerName()).Str("zone", candidate.zone).Object("request", req).Msg("running dispatcher")
+			go func() {
+				dispatchStarted := time.Now()
+				resp, err := handler(withTimeout, candidate.client)
+				resultChan <- candidateRespTuple[S]{index, resp, err, time.Since(dispatchStarted)}
+			}()
+		}
 
-	case r := <-primaryResultChan:
-		if r.err == nil {
-			dispatchCounter.WithLabelValues(reqKey, "(primary)").Add(1)
-			return r.resp, nil
+		var budgetElapsed <-chan time.Time
+		if next < len(candidates) {
+			budgetTimer.Reset(candidates[next].delay - time.Since(started))
+			budgetElapsed = budgetTimer.C
 		}
 
-		// Otherwise, if an error was found, log it and we'll return after *all* the secondaries have run.
-		// This allows an otherwise error-state to be handled by one of the secondaries.
-		foundError = r.err
+		select {
+		case <-withTimeout.Done():
+			abandonRemaining()
+			return *new(S), fmt.Errorf("check dispatch has timed out")
 
-	case r := <-secondaryResultChan:
-		dispatchCounter.WithLabelValues(reqKey, r.handlerName).Add(1)
-		return r.resp, nil
-	}
+		case <-budgetElapsed:
+			continue
+
+		case r := <-resultChan:
+			completed[r.index] = true
+			candidate := candidates[r.index]
+			if r.err == nil {
+				recordZoneDispatch(reqKey, candidate.zone, zoneOutcomeSuccess, r.duration)
+				abandonRemaining()
+				dispatchCounter.WithLabelValues(reqKey, candidate.handlerName()).Add(1)
+				return r.resp, nil
+			}
+
+			recordZoneDispatch(reqKey, candidate.zone, zoneOutcomeError, r.duration)
 
-	dispatchCounter.WithLabelValues(reqKey, "(primary)").Add(1)
-	return *new(S), foundError
+			// Errors are only returned once every candidate has failed, so that an otherwise
+			// error-state can be handled by one of the secondaries. The error of the primary is
+			// preferred, as that of the secondaries are otherwise ignored.
+			if candidate.name == primaryDispatcher {
+				primaryErr = r.err
+			} else {
+				log.Trace().Str("secondary", candidate.name).Err(r.err).Msg("got ignored secondary dispatch error")
+			}
+			lastErr = r.err
+
+			if next == len(candidates) && slices.Index(completed, false) < 0 {
+				dispatchCounter.WithLabelValues(reqKey, "(primary)").Add(1)
+				if primaryErr != nil {
+					return *new(S), primaryErr
+				}
+				return *new(S), lastErr
+			}
+		}
+	}
 }
 
 type requestMessageWithCursor interface {
@@ -250,7 +340,7 @@ func publishClient[Q requestMessageWithCursor, R responseMessageWithCursor](ctx
 				return err
 			}
 
-			merr := adjustMetadataForDispatch(result.GetMetadata())
+			merr := adjustMetadataForDispatch(ctx, result.GetMetadata())
 			if merr != nil {
 				return merr
 			}
@@ -274,7 +364,8 @@ func publishClient[Q requestMessageWithCursor, R responseMessageWithCursor](ctx
 // dispatchStreamingRequest handles the dispatching of a streaming request to the primary and any
 // secondary dispatchers. Unlike the non-streaming version, this will first attempt to dispatch
 // from the allowed secondary dispatchers before falling back to the primary, rather than running
-// them in parallel.
+// them in parallel. The secondary dispatchers are attempted in order of locality, starting with
+// those in the same zone as this node.
 func dispatchStreamingRequest[Q requestMessageWithCursor, R responseMessageWithCursor](
 	ctx context.Context,
 	cr *clusterDispatcher,
@@ -283,7 +374,7 @@ func dispatchStreamingRequest[Q requestMessageWithCursor, R responseMessageWithC
 	stream dispatch.Stream[R],
 	handler func(context.Context, ClusterClient) (receiver[R], error),
 ) error {
-	withTimeout, cancelFn := context.WithTimeout(ctx, cr.dispatchOverallTimeout)
+	withTimeout, cancelFn := outlier.WithDispatchTimeout(ctx, cr.dispatchOverallTimeout)
 	defer cancelFn()
 
 	client, err := handler(withTimeout, cr.clusterClient)
@@ -316,7 +407,7 @@ func dispatchStreamingRequest[Q requestMessageWithCursor, R responseMessageWithC
 			}
 
 			log.Debug().Str("secondary-dispatcher", secondary.Name).Object("request", req).Msg("running secondary dispatcher based on cursor")
-			return publishClient[Q](withTimeout, secondaryClient, stream, cursorLockedSecondaryName)
+			return publishCandidate[Q](withTimeout, reqKey, dispatchCandidate{name: cursorLockedSecondaryName, zone: secondary.Zone}, secondaryClient, stream)
 		}
 
 		return fmt.Errorf("unknown secondary dispatcher in cursor: %s", cursorLockedSecondaryName)
@@ -329,27 +420,22 @@ func dispatchStreamingRequest[Q requestMessageWithCursor, R responseMessageWithC
 		return publishClient[Q](withTimeout, client, stream, primaryDispatcher)
 	}
 
-	result, err := RunDispatchExpr(expr, req)
+	result, err := RunDispatchExpr(expr, req, cr.locality)
 	if err != nil {
 		log.Warn().Err(err).Msg("error when trying to evaluate the dispatch expression")
 	}
 
-	for _, secondaryDispatchName := range result {
-		secondary, ok := cr.secondaryDispatch[secondaryDispatchName]
-		if !ok {
-			log.Warn().Str("secondary-dispatcher-name", secondaryDispatchName).Msg("received unknown secondary dispatcher")
-			continue
-		}
-
-		log.Trace().Str("secondary-dispatcher", secondary.Name).Object("request", req).Msg("running secondary dispatcher")
-		secondaryClient, err := handler(withTimeout, secondary.Client)
+	for _, secondary := range cr.dispatchCandidates(result, false) {
+		log.Trace().Str("secondary-dispatcher", secondary.name).Str("zone", secondary.zone).Object("request", req).Msg("running secondary dispatcher")
+		secondaryClient, err := handler(withTimeout, secondary.client)
 		if err != nil {
-			log.Warn().Str("secondary-dispatcher", secondary.Name).Err(err).Msg("failed to create secondary dispatch client")
+			recordZoneDispatch(reqKey, secondary.zone, zoneOutcomeError, 0)
+			log.Warn().Str("secondary-dispatcher", secondary.name).Err(err).Msg("failed to create secondary dispatch client")
 			continue
 		}
 
-		if err := publishClient[Q](withTimeout, secondaryClient, stream, secondaryDispatchName); err != nil {
-			log.Warn().Str("secondary-dispatcher", secondary.Name).Err(err).Msg("failed to publish secondary dispatch response")
+		if err := publishCandidate[Q](withTimeout, reqKey, secondary, secondaryClient, stream); err != nil {
+			log.Warn().Str("secondary-dispatcher", secondary.name).Err(err).Msg("failed to publish secondary dispatch response")
 			continue
 		}
 
@@ -357,10 +443,24 @@ func dispatchStreamingRequest[Q requestMessageWithCursor, R responseMessageWithC
 	}
 
 	// Fallback: use the primary client if no secondary matched.
-	return publishClient[Q](withTimeout, client, stream, primaryDispatcher)
+	return publishCandidate[Q](withTimeout, reqKey, dispatchCandidate{name: primaryDispatcher, zone: cr.locality.Zone}, client, stream)
+}
+
+// publishCandidate publishes the responses of a dispatch to the given candidate, recording the
+// outcome of the dispatch by the zone of the candidate.
+func publishCandidate[Q requestMessageWithCursor, R responseMessageWithCursor](ctx context.Context, reqKey string, candidate dispatchCandidate, client receiver[R], stream dispatch.Stream[R]) error {
+	started := time.Now()
+	err := publishClient[Q](ctx, client, stream, candidate.name)
+
+	outcome := zoneOutcomeSuccess
+	if err != nil {
+		outcome = zoneOutcomeError
+	}
+	recordZoneDispatch(reqKey, candidate.zone, outcome, time.Since(started))
+	return err
 }
 
-func adjustMetadataForDispatch(metadata *v1.ResponseMeta) error {
+func adjustMetadataForDispatch(ctx context.Context, metadata *v1.ResponseMeta) error {
 	if metadata == nil {
 		return spiceerrors.MustBugf("received a nil metadata")
 	}
@@ -371,6 +471,11 @@ func adjustMetadataForDispatch(metadata *v1.ResponseMeta) error {
 		metadata.DispatchCount++
 	}
 
+	// The dispatches performed by the downstream node count against the budget of the request.
+	if budget := dispatch.DispatchBudgetFromContext(ctx); budget != nil {
+		budget.Charge(metadata.DispatchCount)
+	}
+
 	return nil
 }
 
@@ -379,6 +484,11 @@ func (cr *clusterDispatcher) DispatchExpand(ctx context.Context, req *v1.Dispatc
 		return &v1.DispatchExpandResponse{Metadata: emptyMetadata}, err
 	}
 
+	if err := dispatch.CheckDispatchBudget(ctx); err != nil {
+		return &v1.DispatchExpandResponse{Metadata: emptyMetadata}, err
+	}
+	ctx = dispatch.OutgoingContextWithDispatchBudget(ctx)
+
 	requestKey, err := cr.keyHandler.ExpandDispatchKey(ctx, req)
 	if err != nil {
 		return &v1.DispatchExpandResponse{Metadata: emptyMetadata}, err
@@ -386,15 +496,18 @@ func (cr *clusterDispatcher) DispatchExpand(ctx context.Context, req *v1.Dispatc
 
 	ctx = context.WithValue(ctx, consistent.CtxKey, requestKey)
 
-	withTimeout, cancelFn := context.WithTimeout(ctx, cr.dispatchOverallTimeout)
+	withTimeout, cancelFn := outlier.WithDispatchTimeout(ctx, cr.dispatchOverallTimeout)
 	defer cancelFn()
 
 	resp, err := cr.clusterClient.DispatchExpand(withTimeout, req)
+	if cr.shouldFallBack("expand", err) {
+		return cr.localFallback.DispatchExpand(ctx, req)
+	}
 	if err != nil {
 		return &v1.DispatchExpandResponse{Metadata: requestFailureMetadata}, err
 	}
 
-	err = adjustMetadataForDispatch(resp.Metadata)
+	err = adjustMetadataForDispatch(ctx, resp.Metadata)
 	return resp, err
 }
 
@@ -414,10 +527,18 @@ func (cr *clusterDispatcher) DispatchReachableResources(
 		return err
 	}
 
-	withTimeout, cancelFn := context.WithTimeout(ctx, cr.dispatchOverallTimeout)
+	if err := dispatch.CheckDispatchBudget(ctx); err != nil {
+		return err
+	}
+	ctx = dispatch.OutgoingContextWithDispatchBudget(ctx)
+
+	withTimeout, cancelFn := outlier.WithDispatchTimeout(ctx, cr.dispatchOverallTimeout)
 	defer cancelFn()
 
 	client, err := cr.clusterClient.DispatchReachableResources(withTimeout, req)
+	if cr.shouldFallBack("reachableresources", err) {
+		return cr.localFallback.DispatchReachableResources(req, stream)
+	}
 	if err != nil {
 		return err
 	}
@@ -435,7 +556,7 @@ func (cr *clusterDispatcher) DispatchReachableResources(
 				return err
 			}
 
-			merr := adjustMetadataForDispatch(result.Metadata)
+			merr := adjustMetadataForDispatch(ctx, result.Metadata)
 			if merr != nil {
 				return merr
 			}
@@ -464,10 +585,18 @@ func (cr *clusterDispatcher) DispatchLookupResources(
 		return err
 	}
 
-	withTimeout, cancelFn := context.WithTimeout(ctx, cr.dispatchOverallTimeout)
+	if err := dispatch.CheckDispatchBudget(ctx); err != nil {
+		return err
+	}
+	ctx = dispatch.OutgoingContextWithDispatchBudget(ctx)
+
+	withTimeout, cancelFn := outlier.WithDispatchTimeout(ctx, cr.dispatchOverallTimeout)
 	defer cancelFn()
 
 	client, err := cr.clusterClient.DispatchLookupResources(withTimeout, req)
+	if cr.shouldFallBack("lookupresources", err) {
+		return cr.localFallback.DispatchLookupResources(req, stream)
+	}
 	if err != nil {
 		return err
 	}
@@ -485,7 +614,7 @@ func (cr *clusterDispatcher) DispatchLookupResources(
 				return err
 			}
 
-			merr := adjustMetadataForDispatch(result.Metadata)
+			merr := adjustMetadataForDispatch(ctx, result.Metadata)
 			if merr != nil {
 				return merr
 			}
@@ -514,10 +643,19 @@ func (cr *clusterDispatcher) DispatchLookupResources2(
 		return err
 	}
 
-	return dispatchStreamingRequest(ctx, cr, "lookupresources", req, stream,
+	if err := dispatch.CheckDispatchBudget(ctx); err != nil {
+		return err
+	}
+	ctx = dispatch.OutgoingContextWithDispatchBudget(ctx)
+
+	err = dispatchStreamingRequest(ctx, cr, "lookupresources", req, stream,
 		func(ctx context.Context, client ClusterClient) (receiver[*v1.DispatchLookupResources2Response], error) {
 			return client.DispatchLookupResources2(ctx, req)
 		})
+	if cr.shouldFallBack("lookupresources", err) {
+		return cr.localFallback.DispatchLookupResources2(req, stream)
+	}
+	return err
 }
 
 func (cr *clusterDispatcher) DispatchLookupSubjects(
@@ -536,14 +674,23 @@ func (cr *clusterDispatcher) DispatchLookupSubjects(
 		return err
 	}
 
-	withTimeout, cancelFn := context.WithTimeout(ctx, cr.dispatchOverallTimeout)
+	if err := dispatch.CheckDispatchBudget(ctx); err != nil {
+		return err
+	}
+	ctx = dispatch.OutgoingContextWithDispatchBudget(ctx)
+
+	withTimeout, cancelFn := outlier.WithDispatchTimeout(ctx, cr.dispatchOverallTimeout)
 	defer cancelFn()
 
 	client, err := cr.clusterClient.DispatchLookupSubjects(withTimeout, req)
+	if cr.shouldFallBack("lookupsubjects", err) {
+		return cr.localFallback.DispatchLookupSubjects(req, stream)
+	}
 	if err != nil {
 		return err
 	}
 
+	checkedSupport := len(req.AdditionalSubjectRelations) == 0
 	for {
 		select {
 		case <-withTimeout.Done():
@@ -551,13 +698,28 @@ func (cr *clusterDispatcher) DispatchLookupSubjects(
 
 		default:
 			result, err := client.Recv()
-			if errors.Is(err, io.EOF) {
-				return nil
-			} else if err != nil {
+			if err != nil && !errors.Is(err, io.EOF) {
 				return err
 			}
 
-			merr := adjustMetadataForDispatch(result.Metadata)
+			// Nodes predating multiple subject relations ignore them, so refuse their results
+			// rather than silently returning only the subjects of the first relation.
+			if !checkedSupport {
+				header, herr := client.Header()
+				if herr != nil {
+					return herr
+				}
+				if len(header.Get(dispatch.SubjectRelationsSupportedMetadataKey)) == 0 {
+					return dispatch.NewSubjectRelationsUnsupportedError()
+				}
+				checkedSupport = true
+			}
+
+			if err != nil {
+				return nil
+			}
+
+			merr := adjustMetadataForDispatch(ctx, result.Metadata)
 			if merr != nil {
 				return merr
 			}
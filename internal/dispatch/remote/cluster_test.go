@@ -12,10 +12,14 @@ import (
 	humanize "github.com/dustin/go-humanize"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 
 	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	"github.com/zapravila/spicedb/internal/dispatch/outlier"
 	"github.com/zapravila/spicedb/internal/grpchelpers"
 	corev1 "github.com/zapravila/spicedb/pkg/proto/core/v1"
 	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
@@ -29,6 +33,10 @@ type fakeDispatchSvc struct {
 }
 
 func (fds *fakeDispatchSvc) DispatchCheck(context.Context, *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
+	if fds.dispatchCount == 999 {
+		return nil, fmt.Errorf("error")
+	}
+
 	time.Sleep(fds.sleepTime)
 	return &v1.DispatchCheckResponse{
 		Metadata: &v1.ResponseMeta{
@@ -260,6 +268,124 @@ func TestCheckSecondaryDispatch(t *testing.T) {
 	}
 }
 
+func TestCheckLocalityAwareDispatch(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		expr               string
+		primaryDispatch    *fakeDispatchSvc
+		zoneLatencyBudgets map[string]time.Duration
+		expectedResult     uint32
+		minimumDuration    time.Duration
+		maximumDuration    time.Duration
+	}{
+		{
+			"same zone secondary preferred",
+			"['near', 'far']",
+			&fakeDispatchSvc{dispatchCount: 1, sleepTime: 1 * time.Second},
+			map[string]time.Duration{"b": 500 * time.Millisecond},
+			2,
+			0,
+			500 * time.Millisecond,
+		},
+		{
+			"cross zone secondary after latency budget",
+			"['far']",
+			&fakeDispatchSvc{dispatchCount: 1, sleepTime: 1 * time.Second},
+			map[string]time.Duration{"b": 100 * time.Millisecond},
+			3,
+			100 * time.Millisecond,
+			1 * time.Second,
+		},
+		{
+			"primary within latency budget",
+			"['far']",
+			&fakeDispatchSvc{dispatchCount: 1},
+			map[string]time.Duration{"b": 1 * time.Second},
+			1,
+			0,
+			1 * time.Second,
+		},
+		{
+			"cross zone fallback on primary failure",
+			"['far']",
+			&fakeDispatchSvc{dispatchCount: 999},
+			map[string]time.Duration{"b": 10 * time.Second},
+			3,
+			0,
+			5 * time.Second,
+		},
+		{
+			"expression selects by zone",
+			"upstream_zones.filter(name, upstream_zones[name] != zone)",
+			&fakeDispatchSvc{dispatchCount: 1, sleepTime: 1 * time.Second},
+			map[string]time.Duration{"b": 0},
+			3,
+			0,
+			1 * time.Second,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			conn := connectionForDispatching(t, tc.primaryDispatch)
+			nearConn := connectionForDispatching(t, &fakeDispatchSvc{dispatchCount: 2})
+			farConn := connectionForDispatching(t, &fakeDispatchSvc{dispatchCount: 3})
+
+			parsed, err := ParseDispatchExpression("check", tc.expr)
+			require.NoError(t, err)
+
+			dispatcher := NewClusterDispatcher(v1.NewDispatchServiceClient(conn), conn, ClusterDispatcherConfig{
+				KeyHandler:             &keys.DirectKeyHandler{},
+				DispatchOverallTimeout: 30 * time.Second,
+				Zone:                   "a",
+				ZoneLatencyBudgets:     tc.zoneLatencyBudgets,
+			}, map[string]SecondaryDispatch{
+				"near": {Name: "near", Client: v1.NewDispatchServiceClient(nearConn), Zone: "a"},
+				"far":  {Name: "far", Client: v1.NewDispatchServiceClient(farConn), Zone: "b"},
+			}, map[string]*DispatchExpr{
+				"check": parsed,
+			})
+
+			started := time.Now()
+			resp, err := dispatcher.DispatchCheck(context.Background(), &v1.DispatchCheckRequest{
+				ResourceRelation: &corev1.RelationReference{Namespace: "somenamespace", Relation: "somerelation"},
+				ResourceIds:      []string{"foo"},
+				Metadata:         &v1.ResolverMeta{DepthRemaining: 50},
+				Subject:          &corev1.ObjectAndRelation{Namespace: "foo", ObjectId: "bar", Relation: "..."},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedResult, resp.Metadata.DispatchCount)
+			require.GreaterOrEqual(t, time.Since(started), tc.minimumDuration)
+			require.Less(t, time.Since(started), tc.maximumDuration)
+		})
+	}
+}
+
+func TestCheckSecondaryDispatchAllFailing(t *testing.T) {
+	conn := connectionForDispatching(t, &fakeDispatchSvc{dispatchCount: 999})
+	secondaryConn := connectionForDispatching(t, &fakeDispatchSvc{dispatchCount: 999})
+
+	parsed, err := ParseDispatchExpression("check", "['secondary']")
+	require.NoError(t, err)
+
+	dispatcher := NewClusterDispatcher(v1.NewDispatchServiceClient(conn), conn, ClusterDispatcherConfig{
+		KeyHandler:             &keys.DirectKeyHandler{},
+		DispatchOverallTimeout: 30 * time.Second,
+	}, map[string]SecondaryDispatch{
+		"secondary": {Name: "secondary", Client: v1.NewDispatchServiceClient(secondaryConn), Zone: "b"},
+	}, map[string]*DispatchExpr{
+		"check": parsed,
+	})
+
+	_, err = dispatcher.DispatchCheck(context.Background(), &v1.DispatchCheckRequest{
+		ResourceRelation: &corev1.RelationReference{Namespace: "somenamespace", Relation: "somerelation"},
+		ResourceIds:      []string{"foo"},
+		Metadata:         &v1.ResolverMeta{DepthRemaining: 50},
+		Subject:          &corev1.ObjectAndRelation{Namespace: "foo", ObjectId: "bar", Relation: "..."},
+	})
+	require.ErrorContains(t, err, "error")
+}
+
 func TestLRSecondaryDispatch(t *testing.T) {
 	for _, tc := range []struct {
 		name                  string
@@ -565,3 +691,144 @@ func connectionForDispatching(t *testing.T, svc v1.DispatchServiceServer) *grpc.
 
 	return conn
 }
+
+type ejectedPeersClient struct {
+	v1.DispatchServiceClient
+}
+
+func (ejectedPeersClient) DispatchCheck(context.Context, *v1.DispatchCheckRequest, ...grpc.CallOption) (*v1.DispatchCheckResponse, error) {
+	return nil, outlier.ErrPeersEjected
+}
+
+func (ejectedPeersClient) DispatchLookupSubjects(context.Context, *v1.DispatchLookupSubjectsRequest, ...grpc.CallOption) (v1.DispatchService_DispatchLookupSubjectsClient, error) {
+	return nil, outlier.ErrPeersEjected
+}
+
+type localFallbackDispatcher struct {
+	dispatch.Dispatcher
+
+	checks         int
+	lookupSubjects int
+}
+
+func (lfd *localFallbackDispatcher) DispatchCheck(context.Context, *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
+	lfd.checks++
+	return &v1.DispatchCheckResponse{Metadata: emptyMetadata}, nil
+}
+
+func (lfd *localFallbackDispatcher) DispatchLookupSubjects(_ *v1.DispatchLookupSubjectsRequest, stream dispatch.LookupSubjectsStream) error {
+	lfd.lookupSubjects++
+	return stream.Publish(&v1.DispatchLookupSubjectsResponse{Metadata: emptyMetadata})
+}
+
+func TestEjectedPeersLocalFallback(t *testing.T) {
+	conn := connectionForDispatching(t, &fakeDispatchSvc{})
+
+	checkReq := &v1.DispatchCheckRequest{
+		ResourceRelation: &corev1.RelationReference{Namespace: "sometype", Relation: "somerel"},
+		ResourceIds:      []string{"foo"},
+		Metadata:         &v1.ResolverMeta{DepthRemaining: 50},
+		Subject:          &corev1.ObjectAndRelation{Namespace: "foo", ObjectId: "bar", Relation: "..."},
+	}
+	lookupSubjectsReq := &v1.DispatchLookupSubjectsRequest{
+		ResourceRelation: &corev1.RelationReference{Namespace: "sometype", Relation: "somerel"},
+		ResourceIds:      []string{"foo"},
+		Metadata:         &v1.ResolverMeta{DepthRemaining: 50},
+		SubjectRelation:  &corev1.RelationReference{Namespace: "sometype", Relation: "somerel"},
+	}
+
+	// Without a fallback, the error is returned.
+	dispatcher := NewClusterDispatcher(ejectedPeersClient{}, conn, ClusterDispatcherConfig{
+		KeyHandler: &keys.DirectKeyHandler{},
+	}, nil, nil)
+
+	_, err := dispatcher.DispatchCheck(context.Background(), checkReq)
+	require.True(t, outlier.IsPeersEjectedError(err))
+
+	// With a fallback, the requests are evaluated locally.
+	fallback := &localFallbackDispatcher{}
+	dispatcher = NewClusterDispatcher(ejectedPeersClient{}, conn, ClusterDispatcherConfig{
+		KeyHandler:    &keys.DirectKeyHandler{},
+		LocalFallback: fallback,
+	}, nil, nil)
+
+	resp, err := dispatcher.DispatchCheck(context.Background(), checkReq)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Equal(t, 1, fallback.checks)
+
+	stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupSubjectsResponse](context.Background())
+	err = dispatcher.DispatchLookupSubjects(lookupSubjectsReq, stream)
+	require.NoError(t, err)
+	require.Len(t, stream.Results(), 1)
+	require.Equal(t, 1, fallback.lookupSubjects)
+}
+
+type subjectRelationsDispatchSvc struct {
+	v1.UnimplementedDispatchServiceServer
+
+	supported bool
+}
+
+func (srds *subjectRelationsDispatchSvc) DispatchLookupSubjects(_ *v1.DispatchLookupSubjectsRequest, srv v1.DispatchService_DispatchLookupSubjectsServer) error {
+	if srds.supported {
+		if err := srv.SetHeader(metadata.Pairs(dispatch.SubjectRelationsSupportedMetadataKey, "true")); err != nil {
+			return err
+		}
+	}
+
+	return srv.Send(&v1.DispatchLookupSubjectsResponse{
+		Metadata: emptyMetadata,
+	})
+}
+
+func TestLookupSubjectsAdditionalRelationsRequireSupport(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		supported           bool
+		additionalRelations []*corev1.RelationReference
+		expectedCode        codes.Code
+	}{
+		{
+			"no additional relations on an old node",
+			false,
+			nil,
+			codes.OK,
+		},
+		{
+			"additional relations on an old node",
+			false,
+			[]*corev1.RelationReference{{Namespace: "othertype", Relation: "..."}},
+			codes.FailedPrecondition,
+		},
+		{
+			"additional relations on a new node",
+			true,
+			[]*corev1.RelationReference{{Namespace: "othertype", Relation: "..."}},
+			codes.OK,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			conn := connectionForDispatching(t, &subjectRelationsDispatchSvc{supported: tc.supported})
+			dispatcher := NewClusterDispatcher(v1.NewDispatchServiceClient(conn), conn, ClusterDispatcherConfig{
+				KeyHandler: &keys.DirectKeyHandler{},
+			}, nil, nil)
+
+			stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupSubjectsResponse](context.Background())
+			err := dispatcher.DispatchLookupSubjects(&v1.DispatchLookupSubjectsRequest{
+				ResourceRelation:           &corev1.RelationReference{Namespace: "sometype", Relation: "somerel"},
+				ResourceIds:                []string{"foo"},
+				Metadata:                   &v1.ResolverMeta{DepthRemaining: 50},
+				SubjectRelation:            &corev1.RelationReference{Namespace: "sometype", Relation: "somerel"},
+				AdditionalSubjectRelations: tc.additionalRelations,
+			}, stream)
+			require.Equal(t, tc.expectedCode, status.Code(err))
+			if tc.expectedCode == codes.OK {
+				require.Len(t, stream.Results(), 1)
+			} else {
+				require.Empty(t, stream.Results())
+			}
+		})
+	}
+}
@@ -15,6 +15,10 @@ import (
 
 // DispatchExpr is a CEL expression that can be run to determine the secondary dispatchers, if any,
 // to invoke for the incoming request.
+//
+// Besides the `request`, expressions have access to the locality of the dispatch: `zone`, the zone
+// of this node, and `upstream_zones`, a map from the name of each secondary dispatcher to its zone,
+// e.g. `zone == 'us-east' ? ['us-east-replica', 'us-west'] : ['us-west']`.
 type DispatchExpr struct {
 	env        *cel.Env
 	registry   *types.Registry
@@ -38,6 +42,8 @@ func ParseDispatchExpression(methodName string, exprString string) (*DispatchExp
 	opts := make([]cel.EnvOption, 0)
 	opts = append(opts, cel.OptionalTypes(cel.OptionalTypesVersion(0)))
 	opts = append(opts, cel.Variable("request", cel.DynType))
+	opts = append(opts, cel.Variable("zone", cel.StringType))
+	opts = append(opts, cel.Variable("upstream_zones", cel.MapType(cel.StringType, cel.StringType)))
 
 	celEnv, err := cel.NewEnv(opts...)
 	if err != nil {
@@ -61,9 +67,18 @@ func ParseDispatchExpression(methodName string, exprString string) (*DispatchExp
 	}, nil
 }
 
+// Locality describes where a dispatch takes place, for use by dispatch expressions.
+type Locality struct {
+	// Zone is the zone of this node.
+	Zone string
+
+	// UpstreamZones maps the name of each secondary dispatcher to its zone.
+	UpstreamZones map[string]string
+}
+
 // RunDispatchExpr runs a dispatch CEL expression over the given request and returns the secondary dispatchers
 // to invoke, if any.
-func RunDispatchExpr[R any](de *DispatchExpr, request R) ([]string, error) {
+func RunDispatchExpr[R any](de *DispatchExpr, request R, locality Locality) ([]string, error) {
 	celopts := make([]cel.ProgramOption, 0, 3)
 
 	celopts = append(celopts, cel.EvalOptions(cel.OptTrackState))
@@ -77,8 +92,15 @@ func RunDispatchExpr[R any](de *DispatchExpr, request R) ([]string, error) {
 
 	// Mark any unspecified variables as unknown, to ensure that partial application
 	// will result in producing a type of Unknown.
+	upstreamZones := locality.UpstreamZones
+	if upstreamZones == nil {
+		upstreamZones = map[string]string{}
+	}
+
 	activation, err := de.env.PartialVars(map[string]any{
-		"request": de.registry.NativeToValue(request),
+		"request":        de.registry.NativeToValue(request),
+		"zone":           locality.Zone,
+		"upstream_zones": upstreamZones,
 	})
 	if err != nil {
 		return nil, err
@@ -94,6 +94,13 @@ func TestRunCheckDispatchExpr(t *testing.T) {
 			[]string{"other"},
 			"",
 		},
+		{
+			"locality",
+			"upstream_zones.filter(name, upstream_zones[name] == zone)",
+			nil,
+			[]string{"near"},
+			"",
+		},
 		{
 			"invalid field",
 			"request.resource_relation.invalidfield == 'somethingelse' ? ['prewarm'] : ['other']",
@@ -114,7 +121,10 @@ func TestRunCheckDispatchExpr(t *testing.T) {
 			parsed, err := ParseDispatchExpression("check", tc.expr)
 			require.NoError(t, err)
 
-			resp, err := RunDispatchExpr(parsed, tc.request)
+			resp, err := RunDispatchExpr(parsed, tc.request, Locality{
+				Zone:          "a",
+				UpstreamZones: map[string]string{"near": "a", "far": "b"},
+			})
 			if tc.expectedError != "" {
 				require.ErrorContains(t, err, tc.expectedError)
 			} else {
@@ -0,0 +1,123 @@
+package remote
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+)
+
+// DefaultCrossZoneLatencyBudget is the default duration a dispatch waits for the upstreams of its
+// own zone before also dispatching to the secondary upstreams of other zones.
+const DefaultCrossZoneLatencyBudget = 20 * time.Millisecond
+
+const (
+	zoneOutcomeSuccess   = "success"
+	zoneOutcomeError     = "error"
+	zoneOutcomeAbandoned = "abandoned"
+)
+
+var zoneDispatchCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spicedb",
+	Subsystem: "dispatch",
+	Name:      "remote_dispatch_zone_total",
+	Help:      "number of dispatches routed between upstreams by dispatch expressions, by the zone of the upstream and the outcome of the dispatch",
+}, []string{"request_kind", "zone", "outcome"})
+
+var zoneDispatchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "spicedb",
+	Subsystem: "dispatch",
+	Name:      "remote_dispatch_zone_duration_seconds",
+	Help:      "duration of the successful dispatches routed between upstreams by dispatch expressions, by the zone of the upstream",
+	Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"request_kind", "zone"})
+
+func init() {
+	prometheus.MustRegister(zoneDispatchCounter, zoneDispatchDuration)
+}
+
+// recordZoneDispatch records the outcome of a dispatch to an upstream in the given zone.
+func recordZoneDispatch(requestKind string, zone string, outcome string, duration time.Duration) {
+	zoneDispatchCounter.WithLabelValues(requestKind, zone, outcome).Inc()
+	if outcome == zoneOutcomeSuccess {
+		zoneDispatchDuration.WithLabelValues(requestKind, zone).Observe(duration.Seconds())
+	}
+}
+
+// dispatchCandidate is an upstream to which a request may be dispatched.
+type dispatchCandidate struct {
+	// name is the name of the secondary dispatcher, or primaryDispatcher for the primary.
+	name   string
+	zone   string
+	client ClusterClient
+
+	// delay is the duration after the start of the dispatch at which the candidate is dispatched
+	// to, unless all candidates dispatched to before it have failed.
+	delay time.Duration
+}
+
+// handlerName returns the name of the candidate, as reported in metrics.
+func (dc dispatchCandidate) handlerName() string {
+	if dc.name == primaryDispatcher {
+		return "(primary)"
+	}
+	return dc.name
+}
+
+// newLocality returns the locality of the dispatches of a node in the given zone, for use by
+// dispatch expressions.
+func newLocality(zone string, secondaryDispatch map[string]SecondaryDispatch) Locality {
+	upstreamZones := make(map[string]string, len(secondaryDispatch))
+	for name, secondary := range secondaryDispatch {
+		upstreamZones[name] = secondary.Zone
+	}
+	return Locality{Zone: zone, UpstreamZones: upstreamZones}
+}
+
+// latencyBudget returns the duration to wait for the upstreams of this node's zone before
+// dispatching to an upstream in the given zone.
+func (cr *clusterDispatcher) latencyBudget(zone string) time.Duration {
+	if zone == cr.locality.Zone {
+		return 0
+	}
+	if budget, ok := cr.zoneLatencyBudgets[zone]; ok {
+		return budget
+	}
+	return cr.crossZoneLatencyBudget
+}
+
+// dispatchCandidates returns the primary and the named secondary dispatchers, ordered by locality:
+// the upstreams of this node's zone first, followed by those of other zones by latency budget.
+func (cr *clusterDispatcher) dispatchCandidates(secondaryNames []string, includePrimary bool) []dispatchCandidate {
+	candidates := make([]dispatchCandidate, 0, len(secondaryNames)+1)
+	if includePrimary {
+		candidates = append(candidates, dispatchCandidate{
+			name:   primaryDispatcher,
+			zone:   cr.locality.Zone,
+			client: cr.clusterClient,
+		})
+	}
+
+	for _, name := range secondaryNames {
+		secondary, ok := cr.secondaryDispatch[name]
+		if !ok {
+			log.Warn().Str("secondary-dispatcher-name", name).Msg("received unknown secondary dispatcher")
+			continue
+		}
+
+		candidates = append(candidates, dispatchCandidate{
+			name:   name,
+			zone:   secondary.Zone,
+			client: secondary.Client,
+			delay:  cr.latencyBudget(secondary.Zone),
+		})
+	}
+
+	slices.SortStableFunc(candidates, func(a, b dispatchCandidate) int {
+		return cmp.Compare(a.delay, b.delay)
+	})
+	return candidates
+}
@@ -42,9 +42,21 @@ type Dispatcher struct {
 
 	checkGroup  singleflight.Group[string, *v1.DispatchCheckResponse]
 	expandGroup singleflight.Group[string, *v1.DispatchExpandResponse]
+
+	reachableResourcesGroup streamGroup[*v1.DispatchReachableResourcesResponse]
+	lookupResources2Group   streamGroup[*v1.DispatchLookupResources2Response]
+	lookupSubjectsGroup     streamGroup[*v1.DispatchLookupSubjectsResponse]
 }
 
 func (d *Dispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
+	// The dispatch budget of a request is held in its context, so a shared check would be charged to,
+	// and limited by, the budget of whichever request started it: requests limited by a budget are
+	// therefore never single flighted.
+	if dispatch.DispatchBudgetFromContext(ctx) != nil {
+		singleFlightCount.WithLabelValues("DispatchCheck", "budget").Inc()
+		return d.delegate.DispatchCheck(ctx, req)
+	}
+
 	key, err := d.keyHandler.CheckDispatchKey(ctx, req)
 	if err != nil {
 		return &v1.DispatchCheckResponse{Metadata: &v1.ResponseMeta{DispatchCount: 1}},
@@ -93,6 +105,11 @@ func (d *Dispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckReq
 }
 
 func (d *Dispatcher) DispatchExpand(ctx context.Context, req *v1.DispatchExpandRequest) (*v1.DispatchExpandResponse, error) {
+	if dispatch.DispatchBudgetFromContext(ctx) != nil {
+		singleFlightCount.WithLabelValues("DispatchExpand", "budget").Inc()
+		return d.delegate.DispatchExpand(ctx, req)
+	}
+
 	key, err := d.keyHandler.ExpandDispatchKey(ctx, req)
 	if err != nil {
 		return &v1.DispatchExpandResponse{Metadata: &v1.ResponseMeta{DispatchCount: 1}},
@@ -136,7 +153,12 @@ func (d *Dispatcher) DispatchExpand(ctx context.Context, req *v1.DispatchExpandR
 }
 
 func (d *Dispatcher) DispatchReachableResources(req *v1.DispatchReachableResourcesRequest, stream dispatch.ReachableResourcesStream) error {
-	return d.delegate.DispatchReachableResources(req, stream)
+	key, err := d.keyHandler.ReachableResourcesDispatchKey(stream.Context(), req)
+	if err != nil {
+		return status.Error(codes.Internal, "unexpected DispatchReachableResources error")
+	}
+
+	return dispatchStream("DispatchReachableResources", &d.reachableResourcesGroup, key, req, stream, d.delegate.DispatchReachableResources)
 }
 
 func (d *Dispatcher) DispatchLookupResources(req *v1.DispatchLookupResourcesRequest, stream dispatch.LookupResourcesStream) error {
@@ -144,11 +166,21 @@ func (d *Dispatcher) DispatchLookupResources(req *v1.DispatchLookupResourcesRequ
 }
 
 func (d *Dispatcher) DispatchLookupResources2(req *v1.DispatchLookupResources2Request, stream dispatch.LookupResources2Stream) error {
-	return d.delegate.DispatchLookupResources2(req, stream)
+	key, err := d.keyHandler.LookupResources2DispatchKey(stream.Context(), req)
+	if err != nil {
+		return status.Error(codes.Internal, "unexpected DispatchLookupResources2 error")
+	}
+
+	return dispatchStream("DispatchLookupResources2", &d.lookupResources2Group, key, req, stream, d.delegate.DispatchLookupResources2)
 }
 
 func (d *Dispatcher) DispatchLookupSubjects(req *v1.DispatchLookupSubjectsRequest, stream dispatch.LookupSubjectsStream) error {
-	return d.delegate.DispatchLookupSubjects(req, stream)
+	key, err := d.keyHandler.LookupSubjectsDispatchKey(stream.Context(), req)
+	if err != nil {
+		return status.Error(codes.Internal, "unexpected DispatchLookupSubjects error")
+	}
+
+	return dispatchStream("DispatchLookupSubjects", &d.lookupSubjectsGroup, key, req, stream, d.delegate.DispatchLookupSubjects)
 }
 
 func (d *Dispatcher) Close() error                    { return d.delegate.Close() }
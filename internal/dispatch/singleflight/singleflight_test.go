@@ -65,6 +65,39 @@ func TestSingleFlightDispatcher(t *testing.T) {
 	require.Equal(t, uint64(2), called.Load(), "should have dispatched %d calls but did %d", uint64(2), called.Load())
 }
 
+func TestSingleFlightDispatcherBypassesBudgetedRequests(t *testing.T) {
+	var called atomic.Uint64
+	f := func() {
+		time.Sleep(100 * time.Millisecond)
+		called.Add(1)
+	}
+	disp := New(mockDispatcher{f: f}, &keys.DirectKeyHandler{})
+
+	req := &v1.DispatchCheckRequest{
+		ResourceRelation: tuple.RelationReference("document", "view"),
+		ResourceIds:      []string{"foo", "bar"},
+		Subject:          tuple.ObjectAndRelation("user", "tom", "..."),
+		Metadata: &v1.ResolverMeta{
+			AtRevision:     "1234",
+			TraversalBloom: v1.MustNewTraversalBloomFilter(defaultBloomFilterSize),
+		},
+	}
+
+	// Identical requests limited by a dispatch budget are each dispatched, so that each is charged
+	// to its own budget.
+	wg := sync.WaitGroup{}
+	for _, limit := range []uint32{10, 1000} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = disp.DispatchCheck(dispatch.ContextWithDispatchBudget(context.Background(), limit), req.CloneVT())
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, uint64(2), called.Load())
+}
+
 func TestSingleFlightDispatcherDetectsLoop(t *testing.T) {
 	singleFlightCount = prometheus.NewCounterVec(singleFlightCountConfig, []string{"method", "shared"})
 	reg := registerMetricInGatherer(singleFlightCount)
@@ -0,0 +1,353 @@
+package singleflight
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+	log "github.com/zapravila/spicedb/internal/logging"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+)
+
+const (
+	// maxReplayedResponses is the number of responses published by a shared stream after which new
+	// identical requests no longer join it, as they would first have to be replayed every response.
+	// Once reached, responses consumed by all of the waiters of the stream are no longer retained.
+	maxReplayedResponses = 1000
+
+	// maxLaggingResponses is the number of responses by which the slowest waiter of a shared stream
+	// may lag behind, after which publishing blocks until it catches up.
+	maxLaggingResponses = 100
+)
+
+// errFlightAbandoned is returned to the waiters of a shared stream whose dispatch was abandoned by
+// the caller which started it, either because its context was canceled or because its own stream
+// failed.
+var errFlightAbandoned = errors.New("shared stream abandoned")
+
+type streamingRequest interface {
+	zerolog.LogObjectMarshaler
+
+	GetMetadata() *v1.ResolverMeta
+}
+
+// streamingResponse is a response which can be published to the streams of multiple waiters.
+type streamingResponse[R any] interface {
+	CloneVT() R
+}
+
+// dispatchStream single flights a streaming dispatch, publishing the responses of a single
+// invocation of the delegate to the streams of all identical in-flight requests.
+//
+// As the key of a streaming request includes its cursor and limit, the responses of a shared
+// stream, and their cursors, are valid for all of its waiters.
+func dispatchStream[Q streamingRequest, R streamingResponse[R]](
+	method string,
+	group *streamGroup[R],
+	key []byte,
+	req Q,
+	stream dispatch.Stream[R],
+	delegate func(Q, dispatch.Stream[R]) error,
+) error {
+	// As with checks, requests limited by a dispatch budget are not single flighted.
+	if dispatch.DispatchBudgetFromContext(stream.Context()) != nil {
+		singleFlightCount.WithLabelValues(method, "budget").Inc()
+		return delegate(req, stream)
+	}
+
+	keyString := hex.EncodeToString(key)
+
+	// As with checks, requests without a bloom filter could be recursive, so they are not single
+	// flighted.
+	if len(req.GetMetadata().TraversalBloom) == 0 {
+		tb, err := v1.NewTraversalBloomFilter(50)
+		if err != nil {
+			return status.Error(codes.Internal, fmt.Errorf("unable to create traversal bloom filter: %w", err).Error())
+		}
+
+		singleFlightCount.WithLabelValues(method, "missing").Inc()
+		req.GetMetadata().TraversalBloom = tb
+		return delegate(req, stream)
+	}
+
+	possiblyLoop, err := req.GetMetadata().RecordTraversal(keyString)
+	if err != nil {
+		return err
+	} else if possiblyLoop {
+		log.Debug().Object(method, req).Str("key", keyString).Msg("potential " + method + " loop detected")
+		singleFlightCount.WithLabelValues(method, "loop").Inc()
+		return delegate(req, stream)
+	}
+
+	isShared, err := group.do(stream.Context(), keyString, stream, func(sharedStream dispatch.Stream[R]) error {
+		return delegate(req, sharedStream)
+	})
+	singleFlightCount.WithLabelValues(method, strconv.FormatBool(isShared)).Inc()
+	return err
+}
+
+// streamGroup is the set of in-flight streaming dispatches of a single kind, by key.
+type streamGroup[R streamingResponse[R]] struct {
+	lock    sync.Mutex
+	flights map[string]*streamFlight[R]
+}
+
+// do invokes fn with a stream publishing to the given stream, unless an identical call is in
+// flight, in which case the responses of the latter are published to the given stream instead.
+// Returns whether the invocation of fn was shared with another caller.
+//
+// fn is invoked directly by the first caller, with its own context, so the dispatch is abandoned
+// if that caller goes away. Waiters which had yet to receive a response then invoke fn themselves;
+// others fail, as the responses they would still need cannot be told apart from those they have
+// already received.
+func (g *streamGroup[R]) do(ctx context.Context, key string, stream dispatch.Stream[R], fn func(dispatch.Stream[R]) error) (bool, error) {
+	g.lock.Lock()
+	if flight, ok := g.flights[key]; ok {
+		if waiterID, joined := flight.join(); joined {
+			g.lock.Unlock()
+
+			received, err := flight.wait(ctx, waiterID, stream)
+			if !errors.Is(err, errFlightAbandoned) {
+				return true, err
+			}
+			if received > 0 {
+				return true, status.Error(codes.Unavailable, "the shared dispatch stream was abandoned by the request which started it")
+			}
+			return false, fn(stream)
+		}
+	}
+
+	flight := &streamFlight[R]{
+		group:     g,
+		key:       key,
+		updated:   make(chan struct{}),
+		consumed:  make(chan struct{}),
+		positions: map[int]int{},
+		joinable:  true,
+	}
+	if g.flights == nil {
+		g.flights = map[string]*streamFlight[R]{}
+	}
+	g.flights[key] = flight
+	g.lock.Unlock()
+
+	return false, flight.run(ctx, stream, fn)
+}
+
+// forget removes the flight from the group, if it is still the flight for its key, so that new
+// callers start a new flight.
+func (g *streamGroup[R]) forget(flight *streamFlight[R]) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.flights[flight.key] == flight {
+		delete(g.flights, flight.key)
+	}
+}
+
+// streamFlight is a single invocation of a streaming dispatch, whose responses are buffered for
+// the waiters which joined it until consumed by all of them.
+type streamFlight[R streamingResponse[R]] struct {
+	group *streamGroup[R]
+	key   string
+
+	lock      sync.Mutex
+	responses []R
+	offset    int           // the index in the stream of responses[0]
+	updated   chan struct{} // closed once a response is published or the flight is done
+	consumed  chan struct{} // closed once a waiter consumes responses or leaves
+	done      bool
+	abandoned bool
+	err       error
+
+	nextWaiterID int
+	positions    map[int]int // the index in the stream of the next response of each waiter
+	joinable     bool
+}
+
+// join adds a waiter to the flight, returning false if the flight can no longer be joined.
+func (f *streamFlight[R]) join() (int, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.joinable {
+		return 0, false
+	}
+
+	id := f.nextWaiterID
+	f.nextWaiterID++
+	f.positions[id] = 0
+	return id, true
+}
+
+// leave removes a waiter from the flight.
+func (f *streamFlight[R]) leave(id int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.positions, id)
+	f.consumedLocked()
+}
+
+// run invokes fn on behalf of the caller which started the flight, publishing its responses to
+// the stream of the caller along with those of the waiters.
+func (f *streamFlight[R]) run(ctx context.Context, stream dispatch.Stream[R], fn func(dispatch.Stream[R]) error) error {
+	var publishFailed atomic.Bool
+	err := fn(dispatch.NewHandlingDispatchStream(ctx, func(resp R) error {
+		f.buffer(resp)
+
+		if err := stream.Publish(resp); err != nil {
+			publishFailed.Store(true)
+			return err
+		}
+		return f.awaitWaiters(ctx)
+	}))
+
+	f.lock.Lock()
+	f.done = true
+	f.err = err
+	f.abandoned = err != nil && (ctx.Err() != nil || publishFailed.Load())
+	f.joinable = false
+	close(f.updated)
+	f.lock.Unlock()
+
+	f.group.forget(f)
+	return err
+}
+
+// buffer retains a copy of the response for the waiters of the flight, if any can still join or
+// are waiting.
+func (f *streamFlight[R]) buffer(resp R) {
+	f.lock.Lock()
+	if !f.joinable && len(f.positions) == 0 {
+		f.lock.Unlock()
+		return
+	}
+
+	// Responses are cloned, as the caller which started the flight may modify those it receives.
+	f.responses = append(f.responses, resp.CloneVT())
+	close(f.updated)
+	f.updated = make(chan struct{})
+
+	stopJoins := f.joinable && f.offset+len(f.responses) >= maxReplayedResponses
+	if stopJoins {
+		f.joinable = false
+		f.trimLocked()
+	}
+	f.lock.Unlock()
+
+	if stopJoins {
+		f.group.forget(f)
+	}
+}
+
+// awaitWaiters blocks while the slowest waiter lags too far behind the published responses.
+func (f *streamFlight[R]) awaitWaiters(ctx context.Context) error {
+	f.lock.Lock()
+	for f.laggingLocked() {
+		consumed := f.consumed
+		f.lock.Unlock()
+
+		select {
+		case <-consumed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		f.lock.Lock()
+	}
+	f.lock.Unlock()
+	return nil
+}
+
+func (f *streamFlight[R]) laggingLocked() bool {
+	published := f.offset + len(f.responses)
+	for _, position := range f.positions {
+		if published-position > maxLaggingResponses {
+			return true
+		}
+	}
+	return false
+}
+
+// consumedLocked drops the responses consumed by every waiter, and wakes up the publisher.
+func (f *streamFlight[R]) consumedLocked() {
+	f.trimLocked()
+	close(f.consumed)
+	f.consumed = make(chan struct{})
+}
+
+// trimLocked drops the buffered responses consumed by every waiter, once no new waiter can join.
+func (f *streamFlight[R]) trimLocked() {
+	if f.joinable {
+		return
+	}
+
+	consumed := f.offset + len(f.responses)
+	for _, position := range f.positions {
+		consumed = min(consumed, position)
+	}
+
+	trimmed := consumed - f.offset
+	if trimmed <= 0 {
+		return
+	}
+
+	clear(f.responses[:trimmed])
+	f.responses = f.responses[trimmed:]
+	f.offset = consumed
+}
+
+// wait publishes the responses of the flight to the stream of the waiter, until the flight
+// completes or the waiter's context is canceled. Returns the number of responses published.
+func (f *streamFlight[R]) wait(ctx context.Context, id int, stream dispatch.Stream[R]) (int, error) {
+	defer f.leave(id)
+
+	position := 0
+	for {
+		f.lock.Lock()
+		pending := f.responses[position-f.offset:]
+		done, abandoned, err, updated := f.done, f.abandoned, f.err, f.updated
+		f.lock.Unlock()
+
+		for _, resp := range pending {
+			// Responses are cloned, as each waiter may modify those it receives.
+			if perr := stream.Publish(resp.CloneVT()); perr != nil {
+				return position, perr
+			}
+			position++
+		}
+
+		if len(pending) > 0 {
+			f.lock.Lock()
+			f.positions[id] = position
+			f.consumedLocked()
+			f.lock.Unlock()
+			continue
+		}
+
+		if done {
+			if abandoned {
+				if ctx.Err() != nil {
+					return position, ctx.Err()
+				}
+				return position, errFlightAbandoned
+			}
+			return position, err
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return position, ctx.Err()
+		}
+	}
+}
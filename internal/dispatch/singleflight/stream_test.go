@@ -0,0 +1,444 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/authzed/grpcutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+// streamingMockDispatcher publishes a response for each resource ID of a LookupResources2 request,
+// once released, with a cursor per response.
+type streamingMockDispatcher struct {
+	mockDispatcher
+
+	called   atomic.Uint64
+	started  chan struct{}
+	release  chan struct{}
+	canceled chan struct{}
+	err      error
+}
+
+func newStreamingMockDispatcher() *streamingMockDispatcher {
+	return &streamingMockDispatcher{
+		started:  make(chan struct{}, 10),
+		release:  make(chan struct{}),
+		canceled: make(chan struct{}, 10),
+	}
+}
+
+func (m *streamingMockDispatcher) DispatchLookupResources2(req *v1.DispatchLookupResources2Request, stream dispatch.LookupResources2Stream) error {
+	m.called.Add(1)
+	m.started <- struct{}{}
+
+	for index, resourceID := range req.SubjectIds {
+		if index == 1 {
+			select {
+			case <-m.release:
+			case <-stream.Context().Done():
+				m.canceled <- struct{}{}
+				return stream.Context().Err()
+			}
+		}
+
+		if err := stream.Publish(&v1.DispatchLookupResources2Response{
+			Resource:            &v1.PossibleResource{ResourceId: resourceID},
+			AfterResponseCursor: &v1.Cursor{Sections: []string{fmt.Sprintf("%d", index)}},
+			Metadata:            &v1.ResponseMeta{DispatchCount: 1},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return m.err
+}
+
+func lookupResources2Request(subjectIDs ...string) *v1.DispatchLookupResources2Request {
+	return &v1.DispatchLookupResources2Request{
+		ResourceRelation: tuple.RelationReference("document", "view"),
+		SubjectRelation:  tuple.RelationReference("user", "..."),
+		SubjectIds:       subjectIDs,
+		TerminalSubject:  tuple.ObjectAndRelation("user", "tom", "..."),
+		Metadata: &v1.ResolverMeta{
+			AtRevision:     "1234",
+			TraversalBloom: v1.MustNewTraversalBloomFilter(defaultBloomFilterSize),
+		},
+	}
+}
+
+func requireStreamResults(t *testing.T, results []*v1.DispatchLookupResources2Response, resourceIDs ...string) {
+	t.Helper()
+
+	require.Len(t, results, len(resourceIDs))
+	for index, resourceID := range resourceIDs {
+		require.Equal(t, resourceID, results[index].Resource.ResourceId)
+		require.Equal(t, []string{fmt.Sprintf("%d", index)}, results[index].AfterResponseCursor.Sections)
+	}
+}
+
+func TestSingleFlightStreamingDispatcher(t *testing.T) {
+	mock := newStreamingMockDispatcher()
+	disp := New(mock, &keys.DirectKeyHandler{})
+
+	req := lookupResources2Request("a", "b", "c")
+
+	// The first request starts the stream and receives its first response.
+	streams := make([]*dispatch.CollectingDispatchStream[*v1.DispatchLookupResources2Response], 3)
+	errs := make([]error, 3)
+	wg := sync.WaitGroup{}
+
+	streams[0] = dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs[0] = disp.DispatchLookupResources2(req.CloneVT(), streams[0])
+	}()
+	<-mock.started
+
+	// Identical requests joining later have the responses already published replayed to them.
+	for index := 1; index < 3; index++ {
+		streams[index] = dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[index] = disp.DispatchLookupResources2(req.CloneVT(), streams[index])
+		}()
+	}
+
+	// A request with different subjects is not shared.
+	other := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		require.NoError(t, disp.DispatchLookupResources2(lookupResources2Request("d", "e"), other))
+	}()
+	<-mock.started
+
+	require.Eventually(t, func() bool {
+		disp := disp.(*Dispatcher)
+		disp.lookupResources2Group.lock.Lock()
+		defer disp.lookupResources2Group.lock.Unlock()
+
+		for _, flight := range disp.lookupResources2Group.flights {
+			flight.lock.Lock()
+			waiters := len(flight.positions)
+			flight.lock.Unlock()
+			if waiters == 2 {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+
+	close(mock.release)
+	wg.Wait()
+
+	require.Equal(t, uint64(2), mock.called.Load())
+	for index, stream := range streams {
+		require.NoError(t, errs[index])
+		requireStreamResults(t, stream.Results(), "a", "b", "c")
+	}
+	requireStreamResults(t, other.Results(), "d", "e")
+
+	// Each waiter receives its own copy of the responses.
+	require.NotSame(t, streams[0].Results()[0], streams[1].Results()[0])
+
+	// Completed streams are not shared.
+	stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background())
+	require.NoError(t, disp.DispatchLookupResources2(req.CloneVT(), stream))
+	require.Equal(t, uint64(3), mock.called.Load())
+}
+
+func TestSingleFlightStreamingDispatcherErrors(t *testing.T) {
+	mock := newStreamingMockDispatcher()
+	mock.err = errors.New("some error")
+	disp := New(mock, &keys.DirectKeyHandler{})
+
+	req := lookupResources2Request("a", "b")
+	wg := sync.WaitGroup{}
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background())
+			require.ErrorIs(t, disp.DispatchLookupResources2(req.CloneVT(), stream), mock.err)
+			requireStreamResults(t, stream.Results(), "a", "b")
+		}()
+	}
+
+	<-mock.started
+	close(mock.release)
+	wg.Wait()
+}
+
+func TestSingleFlightStreamingDispatcherCancellation(t *testing.T) {
+	mock := newStreamingMockDispatcher()
+	disp := New(mock, &keys.DirectKeyHandler{})
+
+	req := lookupResources2Request("a", "b")
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+
+	first := make(chan error, 1)
+	go func() {
+		stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](firstCtx)
+		first <- disp.DispatchLookupResources2(req.CloneVT(), stream)
+	}()
+	<-mock.started
+
+	second := make(chan error, 1)
+	go func() {
+		stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](secondCtx)
+		second <- disp.DispatchLookupResources2(req.CloneVT(), stream)
+	}()
+	requireWaiters(t, disp.(*Dispatcher), 1)
+
+	// Canceling a waiter which joined the stream does not cancel it.
+	cancelSecond()
+	require.ErrorIs(t, <-second, context.Canceled)
+	require.Empty(t, mock.canceled)
+
+	// Canceling the request which started it does.
+	cancelFirst()
+	require.ErrorIs(t, <-first, context.Canceled)
+	<-mock.canceled
+	require.Equal(t, uint64(1), mock.called.Load())
+}
+
+func requireWaiters(t *testing.T, disp *Dispatcher, expected int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		disp.lookupResources2Group.lock.Lock()
+		defer disp.lookupResources2Group.lock.Unlock()
+
+		for _, flight := range disp.lookupResources2Group.flights {
+			flight.lock.Lock()
+			waiters := len(flight.positions)
+			flight.lock.Unlock()
+			if waiters == expected {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+}
+
+func TestSingleFlightStreamingDispatcherAbandoned(t *testing.T) {
+	mock := newStreamingMockDispatcher()
+	disp := New(mock, &keys.DirectKeyHandler{})
+
+	req := lookupResources2Request("a", "b")
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		stream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](firstCtx)
+		first <- disp.DispatchLookupResources2(req.CloneVT(), stream)
+	}()
+	<-mock.started
+
+	second := make(chan error, 1)
+	secondStream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background())
+	go func() {
+		second <- disp.DispatchLookupResources2(req.CloneVT(), secondStream)
+	}()
+	requireWaiters(t, disp.(*Dispatcher), 1)
+
+	// A waiter which already received responses of a stream abandoned by the request which started
+	// it cannot resume it.
+	cancelFirst()
+	require.ErrorIs(t, <-first, context.Canceled)
+	grpcutil.RequireStatus(t, codes.Unavailable, <-second)
+	requireStreamResults(t, secondStream.Results(), "a")
+	require.Equal(t, uint64(1), mock.called.Load())
+}
+
+func TestSingleFlightStreamingDispatcherAbandonedBeforeResponses(t *testing.T) {
+	group := &streamGroup[*v1.DispatchLookupResources2Response]{}
+
+	started := make(chan struct{})
+	var calls atomic.Uint64
+	fn := func(stream dispatch.Stream[*v1.DispatchLookupResources2Response]) error {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		}
+		return stream.Publish(&v1.DispatchLookupResources2Response{})
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := group.do(firstCtx, "key", dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](firstCtx), fn)
+		first <- err
+	}()
+	<-started
+
+	type result struct {
+		shared bool
+		err    error
+	}
+	second := make(chan result, 1)
+	secondStream := dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background())
+	go func() {
+		shared, err := group.do(context.Background(), "key", secondStream, fn)
+		second <- result{shared, err}
+	}()
+	require.Eventually(t, func() bool {
+		group.lock.Lock()
+		defer group.lock.Unlock()
+
+		flight := group.flights["key"]
+		flight.lock.Lock()
+		defer flight.lock.Unlock()
+		return len(flight.positions) == 1
+	}, time.Second, time.Millisecond)
+
+	// A waiter which received no response of an abandoned stream invokes the dispatch itself.
+	cancelFirst()
+	require.ErrorIs(t, <-first, context.Canceled)
+
+	res := <-second
+	require.NoError(t, res.err)
+	require.False(t, res.shared)
+	require.Len(t, secondStream.Results(), 1)
+	require.Equal(t, uint64(2), calls.Load())
+}
+
+// blockingStream is a stream whose Publish blocks until released.
+type blockingStream struct {
+	dispatch.Stream[*v1.DispatchLookupResources2Response]
+
+	release   chan struct{}
+	published atomic.Uint64
+}
+
+func (bs *blockingStream) Publish(*v1.DispatchLookupResources2Response) error {
+	<-bs.release
+	bs.published.Add(1)
+	return nil
+}
+
+func TestSingleFlightStreamingDispatcherBackpressure(t *testing.T) {
+	group := &streamGroup[*v1.DispatchLookupResources2Response]{}
+
+	const responses = maxLaggingResponses * 3
+	started := make(chan struct{})
+	var published atomic.Uint64
+	fn := func(stream dispatch.Stream[*v1.DispatchLookupResources2Response]) error {
+		close(started)
+		for range responses {
+			if err := stream.Publish(&v1.DispatchLookupResources2Response{}); err != nil {
+				return err
+			}
+			published.Add(1)
+		}
+		return nil
+	}
+
+	slow := &blockingStream{
+		Stream:  dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background()),
+		release: make(chan struct{}),
+	}
+
+	first := make(chan error, 1)
+	release := make(chan struct{})
+	go func() {
+		_, err := group.do(context.Background(), "key", dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background()),
+			func(stream dispatch.Stream[*v1.DispatchLookupResources2Response]) error {
+				<-release
+				return fn(stream)
+			})
+		first <- err
+	}()
+	require.Eventually(t, func() bool {
+		group.lock.Lock()
+		defer group.lock.Unlock()
+		return group.flights["key"] != nil
+	}, time.Second, time.Millisecond)
+
+	// The slow waiter joins the stream before it publishes any response.
+	second := make(chan error, 1)
+	go func() {
+		_, err := group.do(context.Background(), "key", slow, fn)
+		second <- err
+	}()
+	require.Eventually(t, func() bool {
+		group.lock.Lock()
+		defer group.lock.Unlock()
+
+		flight := group.flights["key"]
+		flight.lock.Lock()
+		defer flight.lock.Unlock()
+		return len(flight.positions) == 1
+	}, time.Second, time.Millisecond)
+	close(release)
+	<-started
+
+	// The stream stops publishing while the slow waiter lags too far behind.
+	require.Eventually(t, func() bool {
+		return published.Load() == maxLaggingResponses
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, uint64(maxLaggingResponses), published.Load())
+
+	close(slow.release)
+	require.NoError(t, <-first)
+	require.NoError(t, <-second)
+	require.Equal(t, uint64(responses), published.Load())
+	require.Equal(t, uint64(responses), slow.published.Load())
+}
+
+func TestSingleFlightStreamingDispatcherStopsReplaying(t *testing.T) {
+	group := &streamGroup[*v1.DispatchLookupResources2Response]{}
+
+	published := make(chan struct{})
+	release := make(chan struct{})
+	var calls atomic.Uint64
+	fn := func(stream dispatch.Stream[*v1.DispatchLookupResources2Response]) error {
+		calls.Add(1)
+		for range maxReplayedResponses {
+			if err := stream.Publish(&v1.DispatchLookupResources2Response{}); err != nil {
+				return err
+			}
+		}
+		close(published)
+		<-release
+		return nil
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := group.do(context.Background(), "key", dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background()), fn)
+		done <- err
+	}()
+	<-published
+
+	// Once too many responses were published, identical requests start a new stream.
+	shared, err := group.do(context.Background(), "key", dispatch.NewCollectingDispatchStream[*v1.DispatchLookupResources2Response](context.Background()),
+		func(stream dispatch.Stream[*v1.DispatchLookupResources2Response]) error {
+			calls.Add(1)
+			return nil
+		})
+	require.NoError(t, err)
+	require.False(t, shared)
+	require.Equal(t, uint64(2), calls.Load())
+
+	close(release)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,481 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// eventsPathPrefix is the prefix of the paths of the Server-Sent Events endpoints, followed by
+	// the path of the streaming method served.
+	eventsPathPrefix = "/v1/events"
+
+	// defaultHeartbeatInterval is the interval at which heartbeat events are sent while no other
+	// event is, so that proxies do not close idle streams.
+	defaultHeartbeatInterval = 15 * time.Second
+
+	// lastEventIDParameter is the query parameter which may be used in place of the Last-Event-ID
+	// header, to resume a stream from a new EventSource.
+	lastEventIDParameter = "lastEventId"
+
+	// requestParameter is the query parameter holding the JSON request of GET requests, as an
+	// EventSource cannot send a body.
+	requestParameter = "request"
+
+	// maximumStartDelay is the maximum duration the stream waits for its first response before the
+	// event stream is started, so that failures of the method reported on its first response, such
+	// as invalid requests, are returned as the usual error responses of the gateway.
+	maximumStartDelay = time.Second
+
+	// authenticationNote documents the authentication of the endpoints.
+	authenticationNote = "The endpoints are authenticated by the Authorization header, like every other endpoint. " +
+		"As the EventSource of browsers cannot send headers, browser clients must use an EventSource " +
+		"implementation built on fetch, or a proxy adding the header."
+)
+
+// eventStream is a server streaming method served as Server-Sent Events.
+type eventStream struct {
+	// path is the path of the method on the gateway, which is served as events under
+	// eventsPathPrefix.
+	path string
+
+	// fullMethod is the gRPC method, as used to annotate the outgoing context.
+	fullMethod string
+
+	// requestDefinition and responseDefinition are the OpenAPI definitions of the messages.
+	requestDefinition  string
+	responseDefinition string
+
+	// resumption documents how the Last-Event-ID of the events is used to resume the stream.
+	resumption string
+
+	// open decodes the request, resumed from the last event ID if any, and opens the stream,
+	// returning the function forwarding its responses as events.
+	open func(ctx context.Context, decode func(proto.Message) error, lastEventID string) (forward func(*eventWriter) error, err error)
+}
+
+// newEventStreams returns the streaming methods served as events, invoked over the connections.
+func newEventStreams(permissionsConn, watchConn *grpc.ClientConn) []eventStream {
+	permissions := v1.NewPermissionsServiceClient(permissionsConn)
+	watch := v1.NewWatchServiceClient(watchConn)
+
+	return []eventStream{
+		{
+			path:               "/v1/watch",
+			fullMethod:         v1.WatchService_Watch_FullMethodName,
+			requestDefinition:  "v1WatchRequest",
+			responseDefinition: "v1WatchResponse",
+			resumption:         "The ID of each event is the ZedToken of its changesThrough, from which the watch is resumed as its optionalStartCursor.",
+			open: func(ctx context.Context, decode func(proto.Message) error, lastEventID string) (func(*eventWriter) error, error) {
+				req := &v1.WatchRequest{}
+				if err := decode(req); err != nil {
+					return nil, err
+				}
+				if lastEventID != "" {
+					req.OptionalStartCursor = &v1.ZedToken{Token: lastEventID}
+				}
+
+				stream, err := watch.Watch(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				return func(events *eventWriter) error {
+					return forwardEvents(ctx, stream, events, func(resp *v1.WatchResponse) string {
+						return resp.GetChangesThrough().GetToken()
+					})
+				}, nil
+			},
+		},
+		{
+			path:               "/v1/permissions/resources",
+			fullMethod:         v1.PermissionsService_LookupResources_FullMethodName,
+			requestDefinition:  "v1LookupResourcesRequest",
+			responseDefinition: "v1LookupResourcesResponse",
+			resumption:         "The ID of each event is its afterResultCursor, from which the lookup is resumed as its optionalCursor.",
+			open: func(ctx context.Context, decode func(proto.Message) error, lastEventID string) (func(*eventWriter) error, error) {
+				req := &v1.LookupResourcesRequest{}
+				if err := decode(req); err != nil {
+					return nil, err
+				}
+				if lastEventID != "" {
+					req.OptionalCursor = &v1.Cursor{Token: lastEventID}
+				}
+
+				stream, err := permissions.LookupResources(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				return func(events *eventWriter) error {
+					return forwardEvents(ctx, stream, events, func(resp *v1.LookupResourcesResponse) string {
+						return resp.GetAfterResultCursor().GetToken()
+					})
+				}, nil
+			},
+		},
+		{
+			path:               "/v1/permissions/subjects",
+			fullMethod:         v1.PermissionsService_LookupSubjects_FullMethodName,
+			requestDefinition:  "v1LookupSubjectsRequest",
+			responseDefinition: "v1LookupSubjectsResponse",
+			resumption:         "LookupSubjects has no cursor: the ID of each event is the ZedToken of its lookedUpAt, and resuming restarts the lookup at exactly that snapshot, so subjects already received may be received again.",
+			open: func(ctx context.Context, decode func(proto.Message) error, lastEventID string) (func(*eventWriter) error, error) {
+				req := &v1.LookupSubjectsRequest{}
+				if err := decode(req); err != nil {
+					return nil, err
+				}
+				if lastEventID != "" {
+					req.Consistency = &v1.Consistency{
+						Requirement: &v1.Consistency_AtExactSnapshot{AtExactSnapshot: &v1.ZedToken{Token: lastEventID}},
+					}
+				}
+
+				stream, err := permissions.LookupSubjects(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				return func(events *eventWriter) error {
+					return forwardEvents(ctx, stream, events, func(resp *v1.LookupSubjectsResponse) string {
+						return resp.GetLookedUpAt().GetToken()
+					})
+				}, nil
+			},
+		},
+		{
+			path:               "/v1/experimental/relationships/bulkexport",
+			fullMethod:         v1.PermissionsService_ExportBulkRelationships_FullMethodName,
+			requestDefinition:  "v1ExportBulkRelationshipsRequest",
+			responseDefinition: "v1ExportBulkRelationshipsResponse",
+			resumption:         "The ID of each event is its afterResultCursor, from which the export is resumed as its optionalCursor.",
+			open: func(ctx context.Context, decode func(proto.Message) error, lastEventID string) (func(*eventWriter) error, error) {
+				req := &v1.ExportBulkRelationshipsRequest{}
+				if err := decode(req); err != nil {
+					return nil, err
+				}
+				if lastEventID != "" {
+					req.OptionalCursor = &v1.Cursor{Token: lastEventID}
+				}
+
+				stream, err := permissions.ExportBulkRelationships(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				return func(events *eventWriter) error {
+					return forwardEvents(ctx, stream, events, func(resp *v1.ExportBulkRelationshipsResponse) string {
+						return resp.GetAfterResultCursor().GetToken()
+					})
+				}, nil
+			},
+		},
+	}
+}
+
+// newEventsHandler returns a handler serving each streaming method as Server-Sent Events under
+// eventsPathPrefix, accepting the request either as the body of a POST or as the request query
+// parameter of a GET.
+//
+// Each response is sent as a `message` event whose data is the response in its JSON form, and
+// whose ID resumes the stream when sent back as the Last-Event-ID header (or the lastEventId query
+// parameter). A `heartbeat` event is sent after each interval without any other event. The stream
+// is ended by an `end` event once the method completes, or by an `error` event holding the
+// google.rpc.Status of its failure; clients should close their EventSource on either, as it
+// otherwise reconnects.
+//
+// The event stream is only started once the method sends its first response, or after
+// maximumStartDelay, whichever is first: requests which cannot be decoded, and requests failing
+// before then (as invalid requests do on their first response), are rejected with the usual error
+// responses of the gateway. Failures after the stream has started are sent as `error` events.
+//
+// The endpoints are authenticated by the Authorization header. As the EventSource of browsers
+// cannot send headers, browser clients must use an EventSource implementation built on fetch, or
+// a proxy adding the header.
+func newEventsHandler(gwMux *runtime.ServeMux, streams []eventStream, heartbeatInterval time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	for _, stream := range streams {
+		stream := stream
+		mux.Handle(eventsPathPrefix+stream.path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodPost {
+				w.Header().Set("Allow", "GET, POST")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+				return
+			}
+
+			inbound, outbound := runtime.MarshalerForRequest(gwMux, r)
+			ctx, err := runtime.AnnotateContext(r.Context(), gwMux, r, stream.fullMethod, runtime.WithHTTPPathPattern(eventsPathPrefix+stream.path))
+			if err != nil {
+				runtime.HTTPError(runtime.NewServerMetadataContext(r.Context(), runtime.ServerMetadata{}), gwMux, outbound, w, r, err)
+				return
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			// The request is read before writing the response, which closes its body.
+			forward, err := stream.open(ctx, func(req proto.Message) error {
+				return decodeRequest(inbound, r, req)
+			}, lastEventID(r))
+			if err != nil {
+				runtime.HTTPError(runtime.NewServerMetadataContext(ctx, runtime.ServerMetadata{}), gwMux, outbound, w, r, err)
+				return
+			}
+
+			events := &eventWriter{w: w, flusher: flusher, marshaler: outbound, heartbeatInterval: heartbeatInterval}
+			if err := forward(events); err != nil {
+				switch {
+				case !events.started:
+					runtime.HTTPError(runtime.NewServerMetadataContext(ctx, runtime.ServerMetadata{}), gwMux, outbound, w, r, err)
+				case ctx.Err() == nil:
+					_ = events.writeError(err)
+				}
+				return
+			}
+			_ = events.write("end", "", []byte("{}"))
+		}))
+	}
+	return mux
+}
+
+// lastEventID returns the ID of the last event received by the client, if resuming.
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get(lastEventIDParameter)
+}
+
+// decodeRequest decodes the JSON request from the body of a POST, or from the request query
+// parameter of a GET. A missing request is left empty.
+func decodeRequest(inbound runtime.Marshaler, r *http.Request, req proto.Message) error {
+	var contents []byte
+	if r.Method == http.MethodGet {
+		contents = []byte(r.URL.Query().Get(requestParameter))
+	} else {
+		read, err := io.ReadAll(r.Body)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "failed to read request: %s", err)
+		}
+		contents = read
+	}
+
+	if len(strings.TrimSpace(string(contents))) == 0 {
+		return nil
+	}
+
+	if err := inbound.Unmarshal(contents, req); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request: %s", err)
+	}
+	return nil
+}
+
+// forwardEvents sends each response of the stream as an event until it completes, sending
+// heartbeats while waiting for responses. The event stream is started by the first response, or
+// after maximumStartDelay; an error returned before then has not been written.
+func forwardEvents[T any](ctx context.Context, stream grpc.ServerStreamingClient[T], events *eventWriter, eventID func(*T) string) error {
+	type received struct {
+		resp *T
+		err  error
+	}
+
+	responses := make(chan received)
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			select {
+			case responses <- received{resp, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	startDelay := time.NewTimer(min(maximumStartDelay, events.heartbeatInterval))
+	defer startDelay.Stop()
+
+	heartbeat := time.NewTicker(events.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-startDelay.C:
+			events.start()
+
+		case <-heartbeat.C:
+			if err := events.write("heartbeat", "", []byte("{}")); err != nil {
+				return err
+			}
+
+		case received := <-responses:
+			if errors.Is(received.err, io.EOF) {
+				events.start()
+				return nil
+			}
+			if received.err != nil {
+				return received.err
+			}
+
+			data, err := events.marshaler.Marshal(received.resp)
+			if err != nil {
+				return err
+			}
+			if err := events.write("message", eventID(received.resp), data); err != nil {
+				return err
+			}
+			heartbeat.Reset(events.heartbeatInterval)
+		}
+	}
+}
+
+// eventWriter writes Server-Sent Events to a response.
+type eventWriter struct {
+	w                 http.ResponseWriter
+	flusher           http.Flusher
+	marshaler         runtime.Marshaler
+	heartbeatInterval time.Duration
+	started           bool
+}
+
+// start writes the headers of the event stream, if not already written.
+func (ew *eventWriter) start() {
+	if ew.started {
+		return
+	}
+	ew.started = true
+
+	ew.w.Header().Set("Content-Type", "text/event-stream")
+	ew.w.Header().Set("Cache-Control", "no-cache")
+	ew.w.Header().Set("X-Accel-Buffering", "no")
+	ew.w.WriteHeader(http.StatusOK)
+	ew.flusher.Flush()
+}
+
+// write starts the event stream if needed, and writes and flushes an event. The data must not
+// contain newlines, which holds for JSON marshaled without indentation.
+func (ew *eventWriter) write(event string, id string, data []byte) error {
+	ew.start()
+
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event, data)
+
+	if _, err := io.WriteString(ew.w, b.String()); err != nil {
+		return err
+	}
+	ew.flusher.Flush()
+	return nil
+}
+
+// writeError writes an `error` event holding the google.rpc.Status of the error.
+func (ew *eventWriter) writeError(err error) error {
+	data, marshalErr := ew.marshaler.Marshal(status.Convert(err).Proto())
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return ew.write("error", "", data)
+}
+
+// withEventStreamOperations adds the Server-Sent Events endpoints of the streams to the OpenAPI
+// schema, documented with the `x-spicedb-server-sent-events` extension, and links each streaming
+// operation to its endpoint with the `x-spicedb-server-sent-events-path` extension.
+func withEventStreamOperations(schema string, streams []eventStream, heartbeatInterval time.Duration) (string, error) {
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(schema), &decoded); err != nil {
+		return "", fmt.Errorf("failed to decode OpenAPI schema: %w", err)
+	}
+
+	paths, ok := decoded["paths"].(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("OpenAPI schema is missing its paths")
+	}
+
+	for _, stream := range streams {
+		eventsPath := eventsPathPrefix + stream.path
+		if operation, ok := paths[stream.path].(map[string]any)["post"].(map[string]any); ok {
+			operation["x-spicedb-server-sent-events-path"] = eventsPath
+		}
+
+		service, method, _ := strings.Cut(strings.TrimPrefix(stream.fullMethod, "/"), "/")
+		service = service[strings.LastIndex(service, ".")+1:]
+
+		extension := map[string]any{
+			"events": map[string]any{
+				"message":   "a response of the stream, as the JSON of " + stream.responseDefinition,
+				"heartbeat": "sent after " + heartbeatInterval.String() + " without any other event",
+				"end":       "the stream completed",
+				"error":     "the stream failed, as the JSON of rpcStatus",
+			},
+			"resumption":     stream.resumption + " The ID is sent back as the Last-Event-ID header or the " + lastEventIDParameter + " query parameter.",
+			"authentication": authenticationNote,
+		}
+		responses := map[string]any{
+			"200": map[string]any{
+				"description": "A stream of Server-Sent Events, whose `message` events hold the responses.",
+				"schema":      map[string]any{"$ref": "#/definitions/" + stream.responseDefinition},
+			},
+			"default": map[string]any{
+				"description": "An unexpected error response.",
+				"schema":      map[string]any{"$ref": "#/definitions/rpcStatus"},
+			},
+		}
+		resumeParameters := []any{
+			map[string]any{"name": "Last-Event-ID", "in": "header", "type": "string", "required": false, "description": "ID of the last event received, to resume the stream."},
+			map[string]any{"name": lastEventIDParameter, "in": "query", "type": "string", "required": false, "description": "ID of the last event received, to resume the stream from a new EventSource."},
+		}
+
+		paths[eventsPath] = map[string]any{
+			"get": map[string]any{
+				"operationId": service + "_" + method + "Events",
+				"summary":     method + " as Server-Sent Events, for EventSource clients.",
+				"produces":    []any{"text/event-stream"},
+				"parameters": append([]any{
+					map[string]any{"name": requestParameter, "in": "query", "type": "string", "required": false, "description": "The " + stream.requestDefinition + " as JSON."},
+				}, resumeParameters...),
+				"responses":                    responses,
+				"tags":                         []any{service},
+				"x-spicedb-server-sent-events": extension,
+			},
+			"post": map[string]any{
+				"operationId": service + "_" + method + "EventsPost",
+				"summary":     method + " as Server-Sent Events.",
+				"produces":    []any{"text/event-stream"},
+				"parameters": append([]any{
+					map[string]any{"name": "body", "in": "body", "required": true, "schema": map[string]any{"$ref": "#/definitions/" + stream.requestDefinition}},
+				}, resumeParameters...),
+				"responses":                    responses,
+				"tags":                         []any{service},
+				"x-spicedb-server-sent-events": extension,
+			},
+		}
+	}
+
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode OpenAPI schema: %w", err)
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,289 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/proto"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeUpstream serves canned responses for the streaming methods.
+type fakeUpstream struct {
+	v1.UnimplementedPermissionsServiceServer
+	v1.UnimplementedWatchServiceServer
+
+	authorizations chan string
+}
+
+func (fu *fakeUpstream) LookupResources(req *v1.LookupResourcesRequest, stream v1.PermissionsService_LookupResourcesServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	fu.authorizations <- strings.Join(md.Get("authorization"), ",")
+
+	for _, resourceID := range []string{"first", "second", "third"} {
+		if req.OptionalCursor != nil && resourceID <= req.OptionalCursor.Token {
+			continue
+		}
+
+		if err := stream.Send(&v1.LookupResourcesResponse{
+			ResourceObjectId:  resourceID,
+			Permissionship:    v1.LookupPermissionship_LOOKUP_PERMISSIONSHIP_HAS_PERMISSION,
+			AfterResultCursor: &v1.Cursor{Token: resourceID},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fu *fakeUpstream) LookupSubjects(req *v1.LookupSubjectsRequest, _ v1.PermissionsService_LookupSubjectsServer) error {
+	return status.Errorf(codes.InvalidArgument, "invalid permission `%s`", req.Permission)
+}
+
+func (fu *fakeUpstream) Watch(req *v1.WatchRequest, stream v1.WatchService_WatchServer) error {
+	if err := stream.Send(&v1.WatchResponse{
+		ChangesThrough: &v1.ZedToken{Token: "after-" + req.GetOptionalStartCursor().GetToken()},
+	}); err != nil {
+		return err
+	}
+
+	if req.GetOptionalStartCursor().GetToken() == "fail" {
+		return status.Error(codes.Unavailable, "watch disconnected")
+	}
+
+	<-stream.Context().Done()
+	return nil
+}
+
+type event struct {
+	id    string
+	event string
+	data  string
+}
+
+func TestEventsHandler(t *testing.T) {
+	upstream := &fakeUpstream{authorizations: make(chan string, 10)}
+	conn := newFakeUpstreamConn(t, upstream)
+
+	server := httptest.NewServer(newEventsHandler(runtime.NewServeMux(), newEventStreams(conn, conn), 50*time.Millisecond))
+	t.Cleanup(server.Close)
+
+	t.Run("get", func(t *testing.T) {
+		resp := get(t, server.URL+"/v1/events/v1/permissions/resources?request="+url.QueryEscape(`{"resourceObjectType":"document","permission":"view"}`), nil)
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		require.Equal(t, "Bearer somekey", <-upstream.authorizations)
+
+		events := readEvents(t, resp.Body, 4)
+		require.Equal(t, []string{"first", "second", "third", ""}, eventIDs(events))
+		require.Equal(t, "end", events[3].event)
+
+		var result v1.LookupResourcesResponse
+		require.NoError(t, (&runtime.JSONPb{}).Unmarshal([]byte(events[0].data), &result))
+		require.Equal(t, "first", result.ResourceObjectId)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		resp := get(t, server.URL+"/v1/events/v1/permissions/resources", map[string]string{"Last-Event-ID": "first"})
+		<-upstream.authorizations
+
+		events := readEvents(t, resp.Body, 3)
+		require.Equal(t, []string{"second", "third", ""}, eventIDs(events))
+	})
+
+	t.Run("post", func(t *testing.T) {
+		resp, err := http.Post(server.URL+"/v1/events/v1/permissions/resources?lastEventId=second", "application/json", strings.NewReader(`{"resourceObjectType":"document"}`))
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		<-upstream.authorizations
+
+		events := readEvents(t, resp.Body, 2)
+		require.Equal(t, []string{"third", ""}, eventIDs(events))
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		resp := get(t, server.URL+"/v1/events/v1/watch", map[string]string{"Last-Event-ID": "token"})
+
+		events := readEvents(t, resp.Body, 3)
+		require.Equal(t, "after-token", events[0].id)
+		require.Equal(t, "message", events[0].event)
+		require.JSONEq(t, `{"updates":[],"changesThrough":{"token":"after-token"},"optionalTransactionMetadata":null}`, events[0].data)
+		require.Equal(t, "heartbeat", events[1].event)
+		require.Equal(t, "heartbeat", events[2].event)
+	})
+
+	t.Run("error before start", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/v1/events/v1/permissions/subjects?request=" + url.QueryEscape(`{"permission":"view"}`))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var decoded map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+		require.Equal(t, float64(codes.InvalidArgument), decoded["code"])
+		require.Equal(t, "invalid permission `view`", decoded["message"])
+	})
+
+	t.Run("error after start", func(t *testing.T) {
+		resp := get(t, server.URL+"/v1/events/v1/watch", map[string]string{"Last-Event-ID": "fail"})
+
+		events := readEvents(t, resp.Body, 2)
+		require.Equal(t, "message", events[0].event)
+		require.Equal(t, "error", events[1].event)
+
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal([]byte(events[1].data), &decoded))
+		require.Equal(t, float64(codes.Unavailable), decoded["code"])
+		require.Equal(t, "watch disconnected", decoded["message"])
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/v1/events/v1/watch?request=notjson")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), "invalid request")
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, server.URL+"/v1/events/v1/watch", nil)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+}
+
+func TestGatewayServesEvents(t *testing.T) {
+	upstream := &fakeUpstream{authorizations: make(chan string, 10)}
+	conn := newFakeUpstreamConn(t, upstream)
+
+	gatewayHandler, err := NewHandler(context.Background(), conn.Target(), "")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, gatewayHandler.Close()) })
+
+	server := httptest.NewServer(gatewayHandler)
+	t.Cleanup(server.Close)
+
+	resp := get(t, server.URL+"/v1/events/v1/permissions/resources", nil)
+	require.Equal(t, "Bearer somekey", <-upstream.authorizations)
+	require.Equal(t, []string{"first", "second", "third", ""}, eventIDs(readEvents(t, resp.Body, 4)))
+
+	openAPI := get(t, server.URL+"/openapi.json", nil)
+	body, err := io.ReadAll(openAPI.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `"/v1/events/v1/watch"`)
+}
+
+func TestWithEventStreamOperations(t *testing.T) {
+	schema, err := withEventStreamOperations(proto.OpenAPISchema, newEventStreams(nil, nil), defaultHeartbeatInterval)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Paths map[string]map[string]map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(schema), &decoded))
+
+	for _, path := range []string{"/v1/watch", "/v1/permissions/resources", "/v1/permissions/subjects", "/v1/experimental/relationships/bulkexport"} {
+		require.Equal(t, "/v1/events"+path, decoded.Paths[path]["post"]["x-spicedb-server-sent-events-path"])
+
+		for _, method := range []string{"get", "post"} {
+			operation := decoded.Paths["/v1/events"+path][method]
+			require.NotNil(t, operation, path)
+			require.Equal(t, []any{"text/event-stream"}, operation["produces"])
+			require.Contains(t, operation, "x-spicedb-server-sent-events")
+		}
+	}
+	require.Equal(t, "WatchService_WatchEvents", decoded.Paths["/v1/events/v1/watch"]["get"]["operationId"])
+}
+
+func newFakeUpstreamConn(t *testing.T, upstream *fakeUpstream) *grpc.ClientConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	v1.RegisterPermissionsServiceServer(server, upstream)
+	v1.RegisterWatchServiceServer(server, upstream)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func get(t *testing.T, url string, headers map[string]string) *http.Response {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer somekey")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// readEvents reads the given number of events from the stream.
+func readEvents(t *testing.T, body io.Reader, count int) []event {
+	scanner := bufio.NewScanner(body)
+	events := make([]event, 0, count)
+
+	var current event
+	for len(events) < count && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			events = append(events, current)
+			current = event{}
+		case strings.HasPrefix(line, "id: "):
+			current.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			current.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, events, count)
+	return events
+}
+
+func eventIDs(events []event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.id)
+	}
+	return ids
+}
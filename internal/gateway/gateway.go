@@ -80,10 +80,17 @@ func NewHandler(ctx context.Context, upstreamAddr, upstreamTLSCertPath string) (
 		return nil, err
 	}
 
+	streams := newEventStreams(permissionsConn, watchConn)
+	openAPISchema, err := withEventStreamOperations(proto.OpenAPISchema, streams, defaultHeartbeatInterval)
+	if err != nil {
+		return nil, err
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/openapi.json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _ = io.WriteString(w, proto.OpenAPISchema)
+		_, _ = io.WriteString(w, openAPISchema)
 	}))
+	mux.Handle(eventsPathPrefix+"/", newEventsHandler(gwMux, streams, defaultHeartbeatInterval))
 	mux.Handle("/", gwMux)
 
 	finalHandler := promhttp.InstrumentHandlerDuration(histogram, otelhttp.NewHandler(mux, "gateway"))
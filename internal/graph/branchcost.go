@@ -0,0 +1,243 @@
+package graph
+
+import (
+	"hash/fnv"
+	"hash/maphash"
+	"slices"
+
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	// branchCostSmoothingFactor is the alpha of the exponentially weighted moving average of the
+	// cost of a branch or relation: the weight given to each new observation.
+	branchCostSmoothingFactor = 0.2
+
+	// maximumBranchCostEntries is the maximum number of distinct branches and relations for which
+	// costs are retained, after which the least recently used are evicted.
+	maximumBranchCostEntries = 10_000
+
+	// maximumDefinitionHashEntries is the maximum number of namespaces for which the hash of the
+	// definition is retained.
+	maximumDefinitionHashEntries = 1_000
+
+	// Prior costs, used until a branch has been observed. Computed usersets are expected to be
+	// cheapest, as they are often a single relationship lookup, followed by arrows, which walk
+	// every relationship of their tupleset, followed by intersection arrows, which cannot
+	// short-circuit on the first found member.
+	computedUsersetPriorCost    = 1.0
+	arrowPriorCost              = 10.0
+	intersectionArrowPriorCost  = 20.0
+	unobservedRelationPriorCost = 1.0
+)
+
+// branchCostKey is the key of a branch or relation, scoped to the hash of the definition of the
+// namespace in which it is evaluated, so that costs learned for a definition are not applied once
+// it has changed. Relations dispatched to are scoped to the definition of the namespace which
+// dispatches to them, as the definition of their own namespace is not loaded until dispatched.
+type branchCostKey struct {
+	definitionHash uint64
+	key            string
+}
+
+// definitionHashEntry is the hash of the last definition seen for a namespace.
+type definitionHashEntry struct {
+	definition *core.NamespaceDefinition
+	hash       uint64
+}
+
+// branchCostEstimator estimates the relative cost of evaluating the branches of a rewrite and of
+// dispatching to a relation, learned from the number of dispatches observed for each. It is used
+// to order work within Check so that the cheapest branches run first, allowing unions and
+// intersections to short-circuit sooner.
+type branchCostEstimator struct {
+	// costs is a map from the key of a branch or relation to its cost.
+	costs *shardedLRUCache[branchCostKey, float64]
+
+	// definitionHashes is a map from the name of a namespace to the hash of its definition. As
+	// definitions are shared by the schema cache, the hash is only recomputed once the definition
+	// has changed.
+	definitionHashes *shardedLRUCache[string, definitionHashEntry]
+}
+
+func newBranchCostEstimator() *branchCostEstimator {
+	return &branchCostEstimator{
+		costs: newShardedLRUCache[branchCostKey, float64](maximumBranchCostEntries, func(seed maphash.Seed, key branchCostKey) uint64 {
+			return maphash.String(seed, key.key) ^ key.definitionHash
+		}),
+		definitionHashes: newShardedLRUCache[string, definitionHashEntry](maximumDefinitionHashEntries, maphash.String),
+	}
+}
+
+// definitionHash returns the hash of the given namespace definition, under which the costs of its
+// branches and of the relations it dispatches to are learned.
+func (bce *branchCostEstimator) definitionHash(nsDef *core.NamespaceDefinition) uint64 {
+	if nsDef == nil {
+		return 0
+	}
+
+	if found, ok := bce.definitionHashes.get(nsDef.Name); ok && found.definition == nsDef {
+		return found.hash
+	}
+
+	serialized, err := nsDef.MarshalVT()
+	if err != nil {
+		return 0
+	}
+
+	hasher := fnv.New64a()
+	_, _ = hasher.Write(serialized)
+	entry := definitionHashEntry{nsDef, hasher.Sum64()}
+
+	bce.definitionHashes.update(nsDef.Name, func(definitionHashEntry, bool) definitionHashEntry {
+		return entry
+	})
+	return entry.hash
+}
+
+func (bce *branchCostEstimator) observed(definitionHash uint64, key string) (float64, bool) {
+	return bce.costs.get(branchCostKey{definitionHash, key})
+}
+
+func (bce *branchCostEstimator) observe(definitionHash uint64, key string, dispatchCount uint32) {
+	// NOTE: the +1 accounts for the evaluation itself, so that branches resolved without any
+	// further dispatches are still ordered by how often they are found to be non-free.
+	cost := float64(dispatchCount) + 1
+
+	bce.costs.update(branchCostKey{definitionHash, key}, func(existing float64, found bool) float64 {
+		if !found {
+			return cost
+		}
+		return existing + branchCostSmoothingFactor*(cost-existing)
+	})
+}
+
+// observeBranch records the number of dispatches used to evaluate the given branch of a permission,
+// in the namespace definition with the given hash.
+func (bce *branchCostEstimator) observeBranch(definitionHash uint64, rr *core.RelationReference, branchKey string, dispatchCount uint32) {
+	bce.observe(definitionHash, recordedBranchKey(rr.Namespace, rr.Relation, branchKey), dispatchCount)
+}
+
+// observeRelation records the number of dispatches used to check the given relation, when
+// dispatched from the namespace definition with the given hash.
+func (bce *branchCostEstimator) observeRelation(definitionHash uint64, rr *core.RelationReference, dispatchCount uint32) {
+	bce.observe(definitionHash, tuple.StringRR(rr), dispatchCount)
+}
+
+// relationCost returns the estimated cost of dispatching a check to the given relation.
+func (bce *branchCostEstimator) relationCost(definitionHash uint64, namespaceName string, relationName string) float64 {
+	if cost, ok := bce.observed(definitionHash, tuple.JoinRelRef(namespaceName, relationName)); ok {
+		return cost
+	}
+	return unobservedRelationPriorCost
+}
+
+// branchCost returns the estimated cost of evaluating the given child of a rewrite for the permission.
+func (bce *branchCostEstimator) branchCost(definitionHash uint64, rr *core.RelationReference, child *core.SetOperation_Child) float64 {
+	if key := BranchKey(child); key != "" {
+		if cost, ok := bce.observed(definitionHash, recordedBranchKey(rr.Namespace, rr.Relation, key)); ok {
+			return cost
+		}
+	}
+
+	switch c := child.ChildType.(type) {
+	case *core.SetOperation_Child_ComputedUserset:
+		if cost, ok := bce.observed(definitionHash, tuple.JoinRelRef(rr.Namespace, c.ComputedUserset.Relation)); ok {
+			return cost
+		}
+		return computedUsersetPriorCost
+
+	case *core.SetOperation_Child_TupleToUserset:
+		return arrowPriorCost
+
+	case *core.SetOperation_Child_FunctionedTupleToUserset:
+		if c.FunctionedTupleToUserset.Function == core.FunctionedTupleToUserset_FUNCTION_ALL {
+			return intersectionArrowPriorCost
+		}
+		return arrowPriorCost
+
+	case *core.SetOperation_Child_UsersetRewrite:
+		// A nested rewrite may need to evaluate all of its children.
+		var cost float64
+		for _, nested := range rewriteChildren(c.UsersetRewrite) {
+			cost += bce.branchCost(definitionHash, rr, nested)
+		}
+		return cost
+
+	default:
+		return 0
+	}
+}
+
+// orderChildren returns the children of a rewrite ordered from cheapest to most expensive. Children
+// with the same cost retain their schema order. If keepFirst is true, the first child is kept in place,
+// as is required for the base set of an exclusion.
+func (bce *branchCostEstimator) orderChildren(definitionHash uint64, rr *core.RelationReference, children []*core.SetOperation_Child, keepFirst bool) []*core.SetOperation_Child {
+	start := 0
+	if keepFirst {
+		start = 1
+	}
+
+	if len(children)-start < 2 {
+		return children
+	}
+
+	costs := make(map[*core.SetOperation_Child]float64, len(children))
+	for _, child := range children[start:] {
+		costs[child] = bce.branchCost(definitionHash, rr, child)
+	}
+
+	ordered := slices.Clone(children)
+	slices.SortStableFunc(ordered[start:], func(a, b *core.SetOperation_Child) int {
+		switch {
+		case costs[a] < costs[b]:
+			return -1
+		case costs[a] > costs[b]:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return ordered
+}
+
+// orderChunks orders the chunks to be dispatched from cheapest to most expensive, based on the cost
+// of the relation to which each is dispatched from the namespace definition with the given hash.
+func (bce *branchCostEstimator) orderChunks(definitionHash uint64, chunks []checkDispatchChunk) []checkDispatchChunk {
+	if len(chunks) < 2 {
+		return chunks
+	}
+
+	costs := make(map[relationRef]float64, len(chunks))
+	for _, chunk := range chunks {
+		if _, ok := costs[chunk.resourceType]; !ok {
+			costs[chunk.resourceType] = bce.relationCost(definitionHash, chunk.resourceType.namespace, chunk.resourceType.relation)
+		}
+	}
+
+	slices.SortStableFunc(chunks, func(a, b checkDispatchChunk) int {
+		switch {
+		case costs[a.resourceType] < costs[b.resourceType]:
+			return -1
+		case costs[a.resourceType] > costs[b.resourceType]:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return chunks
+}
+
+func rewriteChildren(rewrite *core.UsersetRewrite) []*core.SetOperation_Child {
+	switch rw := rewrite.RewriteOperation.(type) {
+	case *core.UsersetRewrite_Union:
+		return rw.Union.Child
+	case *core.UsersetRewrite_Intersection:
+		return rw.Intersection.Child
+	case *core.UsersetRewrite_Exclusion:
+		return rw.Exclusion.Child
+	default:
+		return nil
+	}
+}
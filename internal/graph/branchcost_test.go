@@ -0,0 +1,169 @@
+package graph
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+)
+
+func computedUsersetChild(relation string) *core.SetOperation_Child {
+	return &core.SetOperation_Child{
+		ChildType: &core.SetOperation_Child_ComputedUserset{
+			ComputedUserset: &core.ComputedUserset{Relation: relation},
+		},
+	}
+}
+
+func arrowChild(tupleset string, relation string) *core.SetOperation_Child {
+	return &core.SetOperation_Child{
+		ChildType: &core.SetOperation_Child_TupleToUserset{
+			TupleToUserset: &core.TupleToUserset{
+				Tupleset:        &core.TupleToUserset_Tupleset{Relation: tupleset},
+				ComputedUserset: &core.ComputedUserset{Relation: relation},
+			},
+		},
+	}
+}
+
+func branchKeys(children []*core.SetOperation_Child) []string {
+	keys := make([]string, 0, len(children))
+	for _, child := range children {
+		keys = append(keys, BranchKey(child))
+	}
+	return keys
+}
+
+func TestBranchCostOrdering(t *testing.T) {
+	rr := &core.RelationReference{Namespace: "document", Relation: "view"}
+	children := []*core.SetOperation_Child{
+		arrowChild("parent", "view"),
+		computedUsersetChild("viewer"),
+		computedUsersetChild("editor"),
+	}
+
+	t.Run("priors", func(t *testing.T) {
+		bce := newBranchCostEstimator()
+		ordered := bce.orderChildren(0, rr, children, false)
+		require.Equal(t, []string{"viewer", "editor", "parent->view"}, branchKeys(ordered))
+
+		// The original children must not be reordered.
+		require.Equal(t, []string{"parent->view", "viewer", "editor"}, branchKeys(children))
+	})
+
+	t.Run("keep first", func(t *testing.T) {
+		bce := newBranchCostEstimator()
+		ordered := bce.orderChildren(0, rr, children, true)
+		require.Equal(t, []string{"parent->view", "viewer", "editor"}, branchKeys(ordered))
+	})
+
+	t.Run("learned", func(t *testing.T) {
+		bce := newBranchCostEstimator()
+		bce.observeBranch(0, rr, "viewer", 100)
+		bce.observeBranch(0, rr, "parent->view", 2)
+
+		ordered := bce.orderChildren(0, rr, children, false)
+		require.Equal(t, []string{"editor", "parent->view", "viewer"}, branchKeys(ordered))
+	})
+
+	t.Run("learned from relation", func(t *testing.T) {
+		bce := newBranchCostEstimator()
+		bce.observeRelation(0, &core.RelationReference{Namespace: "document", Relation: "viewer"}, 50)
+
+		ordered := bce.orderChildren(0, rr, children, false)
+		require.Equal(t, []string{"editor", "parent->view", "viewer"}, branchKeys(ordered))
+	})
+}
+
+func TestBranchCostMovingAverage(t *testing.T) {
+	bce := newBranchCostEstimator()
+	bce.observe(0, "somekey", 9)
+
+	cost, ok := bce.observed(0, "somekey")
+	require.True(t, ok)
+	require.Equal(t, 10.0, cost)
+
+	bce.observe(0, "somekey", 0)
+	cost, _ = bce.observed(0, "somekey")
+	require.InDelta(t, 8.2, cost, 0.0001)
+}
+
+func TestBranchCostOrderChunks(t *testing.T) {
+	bce := newBranchCostEstimator()
+	bce.observeRelation(1, &core.RelationReference{Namespace: "group", Relation: "member"}, 20)
+
+	chunks := bce.orderChunks(1, []checkDispatchChunk{
+		{resourceType: relationRef{"group", "member"}, resourceIds: []string{"first"}},
+		{resourceType: relationRef{"team", "member"}, resourceIds: []string{"second"}},
+		{resourceType: relationRef{"group", "member"}, resourceIds: []string{"third"}},
+	})
+
+	require.Equal(t, "second", chunks[0].resourceIds[0])
+	require.Equal(t, "first", chunks[1].resourceIds[0])
+	require.Equal(t, "third", chunks[2].resourceIds[0])
+}
+
+func TestBranchCostDefinitionHash(t *testing.T) {
+	bce := newBranchCostEstimator()
+	require.Zero(t, bce.definitionHash(nil))
+
+	group := &core.NamespaceDefinition{Name: "group", Relation: []*core.Relation{{Name: "member"}}}
+	groupHash := bce.definitionHash(group)
+	require.NotZero(t, groupHash)
+	require.Equal(t, groupHash, bce.definitionHash(group))
+	require.Equal(t, groupHash, bce.definitionHash(group.CloneVT()))
+
+	changed := &core.NamespaceDefinition{Name: "group", Relation: []*core.Relation{{Name: "admin"}}}
+	require.NotEqual(t, groupHash, bce.definitionHash(changed))
+}
+
+func TestBranchCostScopedToDefinition(t *testing.T) {
+	rr := &core.RelationReference{Namespace: "document", Relation: "view"}
+	children := []*core.SetOperation_Child{
+		computedUsersetChild("viewer"),
+		computedUsersetChild("editor"),
+	}
+
+	bce := newBranchCostEstimator()
+	bce.observeBranch(1, rr, "viewer", 100)
+
+	require.Equal(t, []string{"editor", "viewer"}, branchKeys(bce.orderChildren(1, rr, children, false)))
+
+	// Costs learned for a previous definition of the namespace do not apply.
+	require.Equal(t, []string{"viewer", "editor"}, branchKeys(bce.orderChildren(2, rr, children, false)))
+}
+
+func TestBranchCostEviction(t *testing.T) {
+	bce := newBranchCostEstimator()
+	for i := 0; i < 2*maximumBranchCostEntries; i++ {
+		bce.observe(0, strconv.Itoa(i), 4)
+	}
+	require.LessOrEqual(t, bce.costs.len(), maximumBranchCostEntries)
+
+	_, ok := bce.observed(0, strconv.Itoa(2*maximumBranchCostEntries-1))
+	require.True(t, ok)
+}
+
+func TestBranchCostConcurrentObservations(t *testing.T) {
+	bce := newBranchCostEstimator()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				bce.observe(0, "somekey", 4)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cost, ok := bce.observed(0, "somekey")
+	require.True(t, ok)
+	require.InDelta(t, 5.0, cost, 0.0001)
+	require.Equal(t, 1, bce.costs.len())
+}
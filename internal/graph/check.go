@@ -49,16 +49,21 @@ func init() {
 }
 
 // NewConcurrentChecker creates an instance of ConcurrentChecker.
-func NewConcurrentChecker(d dispatch.Check, concurrencyLimit uint16, dispatchChunkSize uint16) *ConcurrentChecker {
-	return &ConcurrentChecker{d, concurrencyLimit, dispatchChunkSize}
+func NewConcurrentChecker(d dispatch.Check, concurrencyLimit ConcurrencyLimiter, dispatchChunkSize uint16) *ConcurrentChecker {
+	return &ConcurrentChecker{d, concurrencyLimit, dispatchChunkSize, newBranchCostEstimator()}
 }
 
 // ConcurrentChecker exposes a method to perform Check requests, and delegates subproblems to the
 // provided dispatch.Check instance.
 type ConcurrentChecker struct {
 	d                 dispatch.Check
-	concurrencyLimit  uint16
+	concurrencyLimit  ConcurrencyLimiter
 	dispatchChunkSize uint16
+
+	// branchCosts holds the costs learned for branches and relations, used to order the
+	// evaluation of the children of rewrites and of dispatched chunks from cheapest to most
+	// expensive.
+	branchCosts *branchCostEstimator
 }
 
 // ValidatedCheckRequest represents a request after it has been validated and parsed for internal
@@ -96,18 +101,30 @@ type currentRequestContext struct {
 
 	// dispatchChunkSize is the maximum number of resource IDs that can be specified in each dispatch.
 	dispatchChunkSize uint16
+
+	// definitionHash is the hash of the definition of the namespace of the parent request, under
+	// which the costs of its branches and of the relations it dispatches to are learned.
+	definitionHash uint64
 }
 
-// Check performs a check request with the provided request and context
-func (cc *ConcurrentChecker) Check(ctx context.Context, req ValidatedCheckRequest, relation *core.Relation) (*v1.DispatchCheckResponse, error) {
+// Check performs a check request with the provided request and context, for the given relation of
+// the namespace definition.
+func (cc *ConcurrentChecker) Check(ctx context.Context, req ValidatedCheckRequest, nsDef *core.NamespaceDefinition, relation *core.Relation) (*v1.DispatchCheckResponse, error) {
 	var startTime *time.Time
 	if req.Debug != v1.DispatchCheckRequest_NO_DEBUG {
 		now := time.Now()
 		startTime = &now
 	}
 
-	resolved := cc.checkInternal(ctx, req, relation)
+	resolved := cc.checkInternal(ctx, req, nsDef, relation)
 	resolved.Resp.Metadata = addCallToResponseMetadata(resolved.Resp.Metadata)
+	if collector := checkHintCollectorFromContext(ctx); collector != nil && resolved.Err == nil && ctx.Err() == nil {
+		collector.collectRelationResults(req, resolved.Resp)
+	}
+	if recorder := proofRecorderFromContext(ctx); recorder != nil && resolved.Err == nil {
+		recorder.recordResults(req, resolved.Resp)
+	}
+
 	if req.Debug == v1.DispatchCheckRequest_NO_DEBUG {
 		return resolved.Resp, resolved.Err
 	}
@@ -158,7 +175,7 @@ func (cc *ConcurrentChecker) Check(ctx context.Context, req ValidatedCheckReques
 	return resolved.Resp, updatedErr
 }
 
-func (cc *ConcurrentChecker) checkInternal(ctx context.Context, req ValidatedCheckRequest, relation *core.Relation) CheckResult {
+func (cc *ConcurrentChecker) checkInternal(ctx context.Context, req ValidatedCheckRequest, nsDef *core.NamespaceDefinition, relation *core.Relation) CheckResult {
 	spiceerrors.DebugAssert(func() bool {
 		return relation.GetUsersetRewrite() != nil || relation.GetTypeInformation() != nil
 	}, "found relation without type information")
@@ -226,12 +243,17 @@ func (cc *ConcurrentChecker) checkInternal(ctx context.Context, req ValidatedChe
 		filteredResourceIDs: filteredResourcesIds,
 		resultsSetting:      resultsSetting,
 		dispatchChunkSize:   cc.dispatchChunkSize,
+		definitionHash:      cc.branchCosts.definitionHash(nsDef),
 	}
 
 	if req.Debug == v1.DispatchCheckRequest_ENABLE_TRACE_DEBUGGING {
 		crc.dispatchChunkSize = 1
 	}
 
+	if recorder := checkRecorderFromContext(ctx); recorder != nil {
+		recorder.recordRelation(req)
+	}
+
 	if relation.UsersetRewrite == nil {
 		return combineWithCheckHints(combineResultWithFoundResources(cc.checkDirect(ctx, crc, relation), membershipSet), req)
 	}
@@ -255,6 +277,11 @@ func combineWithComputedHints(result CheckResult, hints map[string]*v1.ResourceC
 		if result.Resp.ResultsByResourceId == nil {
 			result.Resp.ResultsByResourceId = make(map[string]*v1.ResourceCheckResult)
 		}
+		// NOTE: non-members are represented by their absence from the results.
+		if hint.Membership == v1.ResourceCheckResult_NOT_MEMBER {
+			continue
+		}
+
 		result.Resp.ResultsByResourceId[resourceID] = hint
 	}
 
@@ -289,6 +316,11 @@ func combineWithCheckHints(result CheckResult, req ValidatedCheckRequest) CheckR
 			)
 		}
 
+		// NOTE: non-members are represented by their absence from the results.
+		if checkHint.Result.Membership == v1.ResourceCheckResult_NOT_MEMBER {
+			continue
+		}
+
 		result.Resp.ResultsByResourceId[resourceID] = checkHint.Result
 	}
 
@@ -320,6 +352,7 @@ func (cc *ConcurrentChecker) checkDirect(ctx context.Context, crc currentRequest
 	}()
 	log.Ctx(ctx).Trace().Object("direct", crc.parentReq).Send()
 	ds := datastoremw.MustFromContext(ctx).SnapshotReader(crc.parentReq.Revision)
+	recorder := proofRecorderFromContext(ctx)
 
 	for _, allowedDirectRelation := range relation.GetTypeInformation().GetAllowedDirectRelations() {
 		// If the namespace of the allowed direct relation matches the subject type, there are two
@@ -398,6 +431,9 @@ func (cc *ConcurrentChecker) checkDirect(ctx context.Context, crc currentRequest
 
 			// If the subject of the relationship matches the target subject, then we've found
 			// a result.
+			if recorder != nil {
+				recorder.recordRelationship(tpl)
+			}
 			foundResources.AddDirectMember(tpl.ResourceAndRelation.ObjectId, tpl.Caveat)
 			if crc.resultsSetting == v1.DispatchCheckRequest_ALLOW_SINGLE_RESULT && foundResources.HasDeterminedMember() {
 				return checkResultsForMembership(foundResources, emptyMetadata)
@@ -448,12 +484,15 @@ func (cc *ConcurrentChecker) checkDirect(ctx context.Context, crc currentRequest
 		if it.Err() != nil {
 			return checkResultError(NewCheckFailureErr(it.Err()), emptyMetadata)
 		}
+		if recorder != nil {
+			recorder.recordRelationship(tpl)
+		}
 		checksToDispatch.addForRelationship(tpl)
 	}
 	it.Close()
 
-	// Dispatch and map to the associated resource ID(s).
-	toDispatch := checksToDispatch.dispatchChunks(crc.dispatchChunkSize)
+	// Dispatch and map to the associated resource ID(s), cheapest relations first.
+	toDispatch := cc.branchCosts.orderChunks(crc.definitionHash, checksToDispatch.dispatchChunks(crc.dispatchChunkSize))
 	result := union(ctx, crc, toDispatch, func(ctx context.Context, crc currentRequestContext, dd checkDispatchChunk) CheckResult {
 		// If there are caveats on any of the incoming relationships for the subjects to dispatch, then we must require all
 		// results to be found, as we need to ensure that all caveats are used for building the final expression.
@@ -482,7 +521,7 @@ func (cc *ConcurrentChecker) checkDirect(ctx context.Context, crc currentRequest
 		}
 
 		return mapFoundResources(childResult, dd.resourceType, checksToDispatch)
-	}, cc.concurrencyLimit)
+	}, cc.concurrencyLimit.Limit())
 
 	return combineResultWithFoundResources(result, foundResources)
 }
@@ -517,27 +556,81 @@ func (cc *ConcurrentChecker) checkUsersetRewrite(ctx context.Context, crc curren
 			ctx, span = tracer.Start(ctx, "+")
 			defer span.End()
 		}
-		return union(ctx, crc, rw.Union.Child, cc.runSetOperation, cc.concurrencyLimit)
+		children := cc.branchCosts.orderChildren(crc.definitionHash, crc.parentReq.ResourceRelation, rw.Union.Child, false)
+		return union(ctx, crc, children, cc.runSetOperation, cc.concurrencyLimit.Limit())
 	case *core.UsersetRewrite_Intersection:
 		ctx, span := tracer.Start(ctx, "&")
 		defer span.End()
-		return all(ctx, crc, rw.Intersection.Child, cc.runSetOperation, cc.concurrencyLimit)
+		children := cc.branchCosts.orderChildren(crc.definitionHash, crc.parentReq.ResourceRelation, rw.Intersection.Child, false)
+		return all(ctx, crc, children, cc.runSetOperation, cc.concurrencyLimit.Limit())
 	case *core.UsersetRewrite_Exclusion:
 		ctx, span := tracer.Start(ctx, "-")
 		defer span.End()
-		return difference(ctx, crc, rw.Exclusion.Child, cc.runSetOperation, cc.concurrencyLimit)
+		children := cc.branchCosts.orderChildren(crc.definitionHash, crc.parentReq.ResourceRelation, rw.Exclusion.Child, true)
+		return difference(ctx, crc, children, cc.runSetOperation, cc.concurrencyLimit.Limit())
 	default:
 		return checkResultError(spiceerrors.MustBugf("unknown userset rewrite operator"), emptyMetadata)
 	}
 }
 
-func (cc *ConcurrentChecker) dispatch(ctx context.Context, _ currentRequestContext, req ValidatedCheckRequest) CheckResult {
+func (cc *ConcurrentChecker) dispatch(ctx context.Context, crc currentRequestContext, req ValidatedCheckRequest) CheckResult {
 	log.Ctx(ctx).Trace().Object("dispatch", req).Send()
+	recorder := checkRecorderFromContext(ctx)
+	if recorder == nil {
+		result, err := cc.d.DispatchCheck(ctx, req.DispatchCheckRequest)
+		cc.observeDispatch(ctx, crc, req, result, err)
+		return CheckResult{result, err}
+	}
+
+	startTime := time.Now()
 	result, err := cc.d.DispatchCheck(ctx, req.DispatchCheckRequest)
+	recorder.recordDispatch(req.DispatchCheckRequest, result, err, time.Since(startTime))
+	cc.observeDispatch(ctx, crc, req, result, err)
 	return CheckResult{result, err}
 }
 
+func (cc *ConcurrentChecker) observeDispatch(ctx context.Context, crc currentRequestContext, req ValidatedCheckRequest, result *v1.DispatchCheckResponse, err error) {
+	if err != nil || result == nil {
+		return
+	}
+
+	// NOTE: dispatches answered remotely or from the cache are not seen by Check in this process,
+	// so their results are collected here.
+	if collector := checkHintCollectorFromContext(ctx); collector != nil && ctx.Err() == nil {
+		collector.collectRelationResults(req, result)
+	}
+	if recorder := proofRecorderFromContext(ctx); recorder != nil {
+		recorder.recordResults(req, result)
+	}
+
+	if result.Metadata == nil {
+		return
+	}
+
+	cc.branchCosts.observeRelation(crc.definitionHash, req.ResourceRelation, result.Metadata.DispatchCount)
+}
+
 func (cc *ConcurrentChecker) runSetOperation(ctx context.Context, crc currentRequestContext, childOneof *core.SetOperation_Child) CheckResult {
+	result := cc.runSetOperationChild(ctx, crc, childOneof)
+
+	key := BranchKey(childOneof)
+	if key == "" {
+		return result
+	}
+
+	// NOTE: canceled branches are not observed, as their dispatch counts are partial.
+	canceled := ctx.Err() != nil
+	if !canceled && result.Err == nil && result.Resp != nil && result.Resp.Metadata != nil {
+		cc.branchCosts.observeBranch(crc.definitionHash, crc.parentReq.ResourceRelation, key, result.Resp.Metadata.DispatchCount)
+	}
+
+	if recorder := checkRecorderFromContext(ctx); recorder != nil {
+		recorder.recordBranch(crc.parentReq.ResourceRelation, key, canceled)
+	}
+	return result
+}
+
+func (cc *ConcurrentChecker) runSetOperationChild(ctx context.Context, crc currentRequestContext, childOneof *core.SetOperation_Child) CheckResult {
 	switch child := childOneof.ChildType.(type) {
 	case *core.SetOperation_Child_XThis:
 		return checkResultError(spiceerrors.MustBugf("use of _this is unsupported; please rewrite your schema"), emptyMetadata)
@@ -690,12 +783,16 @@ func checkIntersectionTupleToUserset(
 	}
 	defer it.Close()
 
+	recorder := proofRecorderFromContext(ctx)
 	checksToDispatch := newCheckDispatchSet()
 	subjectsByResourceID := mapz.NewMultiMap[string, *core.ObjectAndRelation]()
 	for tpl := it.Next(); tpl != nil; tpl = it.Next() {
 		if it.Err() != nil {
 			return checkResultError(NewCheckFailureErr(it.Err()), emptyMetadata)
 		}
+		if recorder != nil {
+			recorder.recordRelationship(tpl)
+		}
 
 		checksToDispatch.addForRelationship(tpl)
 		subjectsByResourceID.Add(tpl.ResourceAndRelation.ObjectId, tpl.Subject)
@@ -718,6 +815,7 @@ func checkIntersectionTupleToUserset(
 			filteredResourceIDs: crc.filteredResourceIDs,
 			resultsSetting:      v1.DispatchCheckRequest_REQUIRE_ALL_RESULTS,
 			dispatchChunkSize:   crc.dispatchChunkSize,
+			definitionHash:      crc.definitionHash,
 		},
 		toDispatch,
 		func(ctx context.Context, crc currentRequestContext, dd checkDispatchChunk) checkResultWithType {
@@ -728,7 +826,7 @@ func checkIntersectionTupleToUserset(
 				relationType: dd.resourceType,
 			}
 		},
-		cc.concurrencyLimit,
+		cc.concurrencyLimit.Limit(),
 	)
 	if err != nil {
 		return checkResultError(err, emptyMetadata)
@@ -853,17 +951,21 @@ func checkTupleToUserset[T relation](
 	}
 	defer it.Close()
 
+	recorder := proofRecorderFromContext(ctx)
 	checksToDispatch := newCheckDispatchSet()
 	for tpl := it.Next(); tpl != nil; tpl = it.Next() {
 		if it.Err() != nil {
 			return checkResultError(NewCheckFailureErr(it.Err()), emptyMetadata)
 		}
+		if recorder != nil {
+			recorder.recordRelationship(tpl)
+		}
 		checksToDispatch.addForRelationship(tpl)
 	}
 	it.Close()
 
-	toDispatch := checksToDispatch.dispatchChunks(crc.dispatchChunkSize)
-	return combineWithComputedHints(union(
+	toDispatch := cc.branchCosts.orderChunks(crc.definitionHash, checksToDispatch.dispatchChunks(crc.dispatchChunkSize))
+	result := union(
 		ctx,
 		crc,
 		toDispatch,
@@ -876,8 +978,14 @@ func checkTupleToUserset[T relation](
 
 			return mapFoundResources(childResult, dd.resourceType, checksToDispatch)
 		},
-		cc.concurrencyLimit,
-	), hintsToReturn)
+		cc.concurrencyLimit.Limit(),
+	)
+
+	if collector := checkHintCollectorFromContext(ctx); collector != nil && result.Err == nil && ctx.Err() == nil {
+		collector.collectArrowResults(crc, filteredResourceIDs, ttu.GetTupleset().GetRelation(), ttu.GetComputedUserset().Relation, result.Resp)
+	}
+
+	return combineWithComputedHints(result, hintsToReturn)
 }
 
 func withDistinctMetadata(result CheckResult) CheckResult {
@@ -999,6 +1107,7 @@ func all[T any](
 		filteredResourceIDs: crc.filteredResourceIDs,
 		resultsSetting:      v1.DispatchCheckRequest_REQUIRE_ALL_RESULTS,
 		dispatchChunkSize:   crc.dispatchChunkSize,
+		definitionHash:      crc.definitionHash,
 	}, children, handler, resultChan, concurrencyLimit)
 	defer cancelFn()
 
@@ -1055,6 +1164,7 @@ func difference[T any](
 			filteredResourceIDs: crc.filteredResourceIDs,
 			resultsSetting:      v1.DispatchCheckRequest_REQUIRE_ALL_RESULTS,
 			dispatchChunkSize:   crc.dispatchChunkSize,
+			definitionHash:      crc.definitionHash,
 		}, children[0])
 		baseChan <- result
 	}()
@@ -1064,6 +1174,7 @@ func difference[T any](
 		filteredResourceIDs: crc.filteredResourceIDs,
 		resultsSetting:      v1.DispatchCheckRequest_REQUIRE_ALL_RESULTS,
 		dispatchChunkSize:   crc.dispatchChunkSize,
+		definitionHash:      crc.definitionHash,
 	}, children[1:], handler, othersChan, concurrencyLimit-1)
 	defer cancelFn()
 
@@ -0,0 +1,141 @@
+package graph
+
+import (
+	"context"
+	"sync"
+
+	"github.com/samber/lo"
+
+	"github.com/zapravila/spicedb/internal/graph/hints"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+// DefaultMaximumCollectedCheckHints is the default maximum number of hints retained by a
+// CheckHintCollector.
+const DefaultMaximumCollectedCheckHints = 100
+
+type checkHintCollectorKey struct{}
+
+// ContextWithCheckHintCollector returns a new context which collects the results determined by the
+// check(s) run under it as check hints into the given collector. The hints collected can be
+// supplied to later checks for the same subject at the same revision, to skip recomputing them.
+func ContextWithCheckHintCollector(ctx context.Context, collector *CheckHintCollector) context.Context {
+	return context.WithValue(ctx, checkHintCollectorKey{}, collector)
+}
+
+func checkHintCollectorFromContext(ctx context.Context) *CheckHintCollector {
+	collector, _ := ctx.Value(checkHintCollectorKey{}).(*CheckHintCollector)
+	return collector
+}
+
+// CheckHintCollector collects check hints for the results of the relations and arrows computed
+// while performing a check. Both positive and negative results are collected, but only for those
+// resources whose result was fully determined. It is safe for concurrent use.
+type CheckHintCollector struct {
+	maximumHints int
+
+	lock  sync.Mutex
+	hints map[string]*v1.CheckHint
+	order []string
+}
+
+// NewCheckHintCollector creates a new CheckHintCollector, which retains up to the given number of hints.
+func NewCheckHintCollector(maximumHints int) *CheckHintCollector {
+	return &CheckHintCollector{
+		maximumHints: maximumHints,
+		hints:        map[string]*v1.CheckHint{},
+	}
+}
+
+// Hints returns the hints collected, in the order they were first collected.
+func (chc *CheckHintCollector) Hints() []*v1.CheckHint {
+	chc.lock.Lock()
+	defer chc.lock.Unlock()
+
+	collected := make([]*v1.CheckHint, 0, len(chc.order))
+	for _, key := range chc.order {
+		collected = append(collected, chc.hints[key])
+	}
+	return collected
+}
+
+func (chc *CheckHintCollector) add(hint *v1.CheckHint) {
+	if isSelfCheckHint(hint) {
+		return
+	}
+
+	key := tuple.StringONR(hint.Resource) + "/" + hint.TtuComputedUsersetRelation
+
+	chc.lock.Lock()
+	defer chc.lock.Unlock()
+
+	if _, ok := chc.hints[key]; ok {
+		return
+	}
+
+	if len(chc.order) >= chc.maximumHints {
+		return
+	}
+
+	chc.hints[key] = hint
+	chc.order = append(chc.order, key)
+}
+
+// collectRelationResults collects the results of a check of the given request.
+func (chc *CheckHintCollector) collectRelationResults(req ValidatedCheckRequest, resp *v1.DispatchCheckResponse) {
+	chc.collectResults(req.ResourceIds, req.ResultsSetting, resp, func(resourceID string, result *v1.ResourceCheckResult) *v1.CheckHint {
+		return hints.CheckHintForComputedUserset(req.ResourceRelation.Namespace, resourceID, req.ResourceRelation.Relation, req.Subject, result)
+	})
+
+	if req.OriginalRelationName != "" {
+		chc.collectResults(req.ResourceIds, req.ResultsSetting, resp, func(resourceID string, result *v1.ResourceCheckResult) *v1.CheckHint {
+			return hints.CheckHintForComputedUserset(req.ResourceRelation.Namespace, resourceID, req.OriginalRelationName, req.Subject, result)
+		})
+	}
+}
+
+// collectArrowResults collects the results of walking the given arrow from the resources of the request.
+func (chc *CheckHintCollector) collectArrowResults(crc currentRequestContext, resourceIDs []string, tuplesetRelation string, computedUsersetRelation string, resp *v1.DispatchCheckResponse) {
+	chc.collectResults(resourceIDs, crc.resultsSetting, resp, func(resourceID string, result *v1.ResourceCheckResult) *v1.CheckHint {
+		return hints.CheckHintForArrow(crc.parentReq.ResourceRelation.Namespace, resourceID, tuplesetRelation, computedUsersetRelation, crc.parentReq.Subject, result)
+	})
+}
+
+func (chc *CheckHintCollector) collectResults(resourceIDs []string, resultsSetting v1.DispatchCheckRequest_ResultsSetting, resp *v1.DispatchCheckResponse, toHint func(resourceID string, result *v1.ResourceCheckResult) *v1.CheckHint) {
+	if resp == nil {
+		return
+	}
+
+	// NOTE: if only a single result was requested for multiple resources, those resources missing
+	// from the response are undetermined rather than known to not be members.
+	resourceIDs = lo.Uniq(resourceIDs)
+	complete := resultsSetting == v1.DispatchCheckRequest_REQUIRE_ALL_RESULTS || len(resourceIDs) == 1
+
+	for _, resourceID := range resourceIDs {
+		result, ok := resp.ResultsByResourceId[resourceID]
+		if !ok {
+			if !complete {
+				continue
+			}
+
+			result = &v1.ResourceCheckResult{Membership: v1.ResourceCheckResult_NOT_MEMBER}
+		}
+
+		chc.add(toHint(resourceID, result.CloneVT()))
+	}
+}
+
+// isSelfCheckHint returns whether the hint is for the subject itself, which is always determined
+// without the need for a hint.
+func isSelfCheckHint(hint *v1.CheckHint) bool {
+	return hint.TtuComputedUsersetRelation == "" && hint.Resource.EqualVT(hint.Subject)
+}
+
+// FilterCheckHints returns those hints which can be applied to checks for the given subject.
+func FilterCheckHints(checkHints []*v1.CheckHint, subject *core.ObjectAndRelation) []*v1.CheckHint {
+	return lo.Filter(checkHints, func(hint *v1.CheckHint, _ int) bool {
+		return hint.Resource != nil && hint.Result != nil && hint.Subject.EqualVT(subject) && !isSelfCheckHint(hint)
+	})
+}
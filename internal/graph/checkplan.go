@@ -0,0 +1,480 @@
+package graph
+
+import (
+	"context"
+	"slices"
+
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore/options"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/spiceerrors"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/typesystem"
+)
+
+// CheckPlanNodeKind is the kind of a node in a check plan.
+type CheckPlanNodeKind string
+
+const (
+	// CheckPlanRelation is a relation holding relationships directly. It is always a leaf.
+	CheckPlanRelation CheckPlanNodeKind = "relation"
+
+	// CheckPlanPermission is a permission, whose single child is its rewrite.
+	CheckPlanPermission CheckPlanNodeKind = "permission"
+
+	// CheckPlanUnion is a union (`+`) of its children.
+	CheckPlanUnion CheckPlanNodeKind = "union"
+
+	// CheckPlanIntersection is an intersection (`&`) of its children.
+	CheckPlanIntersection CheckPlanNodeKind = "intersection"
+
+	// CheckPlanExclusion is an exclusion (`-`) of its children from its first child.
+	CheckPlanExclusion CheckPlanNodeKind = "exclusion"
+
+	// CheckPlanArrow is an arrow (`->` or `.any()`) walked for each relationship of the tupleset.
+	CheckPlanArrow CheckPlanNodeKind = "arrow"
+
+	// CheckPlanIntersectionArrow is an intersection arrow (`.all()`), which requires all
+	// relationships of the tupleset to be walked.
+	CheckPlanIntersectionArrow CheckPlanNodeKind = "intersection_arrow"
+
+	// CheckPlanNil is the `nil` expression, which never contains any members.
+	CheckPlanNil CheckPlanNodeKind = "nil"
+)
+
+// CheckPlanStatus is the status of a node in a check plan after the check was run.
+type CheckPlanStatus string
+
+const (
+	// CheckPlanEvaluated indicates the node was evaluated.
+	CheckPlanEvaluated CheckPlanStatus = "evaluated"
+
+	// CheckPlanShortCircuited indicates the node was skipped or canceled because the result
+	// of its parent had already been determined by a sibling.
+	CheckPlanShortCircuited CheckPlanStatus = "short_circuited"
+
+	// CheckPlanSkipped indicates the node was never reached, either because its parent was
+	// not evaluated or because no relationships led to it.
+	CheckPlanSkipped CheckPlanStatus = "skipped"
+
+	// CheckPlanNotObserved indicates the node was resolved outside of what could be observed,
+	// such as from the dispatch cache or on another node of the cluster.
+	CheckPlanNotObserved CheckPlanStatus = "not_observed"
+)
+
+// CheckPlanNode is a single node of the planned evaluation tree for a check.
+type CheckPlanNode struct {
+	Kind             CheckPlanNodeKind `json:"kind"`
+	ResourceType     string            `json:"resourceType"`
+	Relation         string            `json:"relation,omitempty"`
+	TuplesetRelation string            `json:"tuplesetRelation,omitempty"`
+
+	// AllowedSubjectTypes are the subject types allowed on a relation, including any caveats
+	// required on them.
+	AllowedSubjectTypes []string `json:"allowedSubjectTypes,omitempty"`
+
+	// EstimatedFanOut is the number of relationships found for the checked resource on the relation
+	// or tupleset, capped to the maximum estimate. Only set for nodes over the checked resource.
+	EstimatedFanOut *uint64 `json:"estimatedFanOut,omitempty"`
+
+	// FanOutCapped indicates that the EstimatedFanOut was capped.
+	FanOutCapped bool `json:"fanOutCapped,omitempty"`
+
+	// CountedRelationships is the count of all relationships for the relation or tupleset, if a
+	// relationship counter has been registered for it.
+	CountedRelationships *int `json:"countedRelationships,omitempty"`
+
+	// Status is the status of the node after the check ran, if the check was recorded.
+	Status CheckPlanStatus `json:"status,omitempty"`
+
+	// Truncated indicates that the node was not expanded further, due to a cycle or the maximum
+	// plan depth being reached.
+	Truncated bool `json:"truncated,omitempty"`
+
+	Children []*CheckPlanNode `json:"children,omitempty"`
+}
+
+// CheckPlanParameters are the parameters for building a check plan.
+type CheckPlanParameters struct {
+	// ResourceType is the type of the resource being checked.
+	ResourceType string
+
+	// ResourceID is the ID of the resource being checked.
+	ResourceID string
+
+	// Permission is the permission or relation being checked.
+	Permission string
+
+	// MaximumDepth is the maximum depth to which permissions are expanded in the plan.
+	MaximumDepth uint32
+
+	// MaximumFanOutEstimate is the maximum number of relationships read when estimating fan-out.
+	MaximumFanOutEstimate uint64
+}
+
+// BuildCheckPlan builds the planned evaluation tree for a check from the rewrites of the schema,
+// estimating fan-out from the relationships of the checked resource and any registered relationship
+// counters. If a recorder is given, the status of each node is filled in from the recorded check.
+func BuildCheckPlan(ctx context.Context, reader datastore.Reader, params CheckPlanParameters, recorder *CheckRecorder) (*CheckPlanNode, error) {
+	counters, err := reader.LookupCounters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	countsByRelation := make(map[string]int, len(counters))
+	for _, counter := range counters {
+		filter := counter.Filter
+		if filter.OptionalResourceId != "" || filter.OptionalResourceIdPrefix != "" || filter.OptionalSubjectFilter != nil || filter.OptionalRelation == "" {
+			continue
+		}
+
+		if counter.ComputedAtRevision == datastore.NoRevision {
+			continue
+		}
+
+		countsByRelation[tuple.JoinRelRef(filter.ResourceType, filter.OptionalRelation)] = counter.Count
+	}
+
+	pb := &checkPlanBuilder{
+		reader:           reader,
+		params:           params,
+		recorder:         recorder,
+		countsByRelation: countsByRelation,
+		typeSystems:      map[string]*typesystem.TypeSystem{},
+	}
+
+	rootStatus := CheckPlanStatus("")
+	if recorder != nil {
+		rootStatus = CheckPlanNotObserved
+		if recorder.RelationEvaluated(params.ResourceType, params.Permission) {
+			rootStatus = CheckPlanEvaluated
+		}
+	}
+
+	return pb.relationNode(ctx, params.ResourceType, params.Permission, true, rootStatus, 0, nil)
+}
+
+type checkPlanBuilder struct {
+	reader           datastore.Reader
+	params           CheckPlanParameters
+	recorder         *CheckRecorder
+	countsByRelation map[string]int
+	typeSystems      map[string]*typesystem.TypeSystem
+}
+
+func (pb *checkPlanBuilder) typeSystem(ctx context.Context, namespaceName string) (*typesystem.TypeSystem, error) {
+	if ts, ok := pb.typeSystems[namespaceName]; ok {
+		return ts, nil
+	}
+
+	_, vts, err := typesystem.ReadNamespaceAndTypes(ctx, namespaceName, pb.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	pb.typeSystems[namespaceName] = vts.TypeSystem
+	return vts.TypeSystem, nil
+}
+
+// relationNode builds the node for the given relation or permission. onResource indicates whether
+// the node is over the checked resource itself, in which case fan-out can be estimated.
+func (pb *checkPlanBuilder) relationNode(
+	ctx context.Context,
+	namespaceName string,
+	relationName string,
+	onResource bool,
+	status CheckPlanStatus,
+	depth uint32,
+	encountered []string,
+) (*CheckPlanNode, error) {
+	ts, err := pb.typeSystem(ctx, namespaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	relation, ok := ts.GetRelation(relationName)
+	if !ok {
+		return nil, spiceerrors.MustBugf("relation `%s` not found under `%s` when building check plan", relationName, namespaceName)
+	}
+
+	relationKey := tuple.JoinRelRef(namespaceName, relationName)
+	if relation.UsersetRewrite == nil {
+		node := &CheckPlanNode{
+			Kind:         CheckPlanRelation,
+			ResourceType: namespaceName,
+			Relation:     relationName,
+			Status:       status,
+		}
+
+		for _, allowed := range relation.GetTypeInformation().GetAllowedDirectRelations() {
+			node.AllowedSubjectTypes = append(node.AllowedSubjectTypes, typesystem.SourceForAllowedRelation(allowed))
+		}
+
+		if err := pb.annotateCounts(ctx, node, namespaceName, relationName, onResource); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	node := &CheckPlanNode{
+		Kind:         CheckPlanPermission,
+		ResourceType: namespaceName,
+		Relation:     relationName,
+		Status:       status,
+	}
+
+	if depth >= pb.params.MaximumDepth || slices.Contains(encountered, relationKey) {
+		node.Truncated = true
+		return node, nil
+	}
+
+	// The children of a permission can only be observed if the permission itself was evaluated
+	// locally; otherwise, it was resolved from the cache or remotely.
+	childStatus := status
+	if status == CheckPlanEvaluated && !pb.recorder.RelationEvaluated(namespaceName, relationName) {
+		childStatus = CheckPlanNotObserved
+	}
+
+	rewriteNode, err := pb.rewriteNode(ctx, ts, relationName, relation.UsersetRewrite, onResource, childStatus, depth+1, append(encountered, relationKey))
+	if err != nil {
+		return nil, err
+	}
+
+	node.Children = []*CheckPlanNode{rewriteNode}
+	return node, nil
+}
+
+func (pb *checkPlanBuilder) rewriteNode(
+	ctx context.Context,
+	ts *typesystem.TypeSystem,
+	permissionName string,
+	rewrite *core.UsersetRewrite,
+	onResource bool,
+	status CheckPlanStatus,
+	depth uint32,
+	encountered []string,
+) (*CheckPlanNode, error) {
+	var kind CheckPlanNodeKind
+	var children []*core.SetOperation_Child
+	switch rw := rewrite.RewriteOperation.(type) {
+	case *core.UsersetRewrite_Union:
+		kind = CheckPlanUnion
+		children = rw.Union.Child
+	case *core.UsersetRewrite_Intersection:
+		kind = CheckPlanIntersection
+		children = rw.Intersection.Child
+	case *core.UsersetRewrite_Exclusion:
+		kind = CheckPlanExclusion
+		children = rw.Exclusion.Child
+	default:
+		return nil, spiceerrors.MustBugf("unknown userset rewrite operator")
+	}
+
+	node := &CheckPlanNode{
+		Kind:         kind,
+		ResourceType: ts.Namespace().Name,
+		Relation:     permissionName,
+		Children:     make([]*CheckPlanNode, 0, len(children)),
+	}
+
+	anyEvaluated := false
+	for _, child := range children {
+		childNode, err := pb.setOperationChildNode(ctx, ts, permissionName, child, onResource, status, depth, encountered)
+		if err != nil {
+			return nil, err
+		}
+
+		if childNode.Status == CheckPlanEvaluated {
+			anyEvaluated = true
+		}
+		node.Children = append(node.Children, childNode)
+	}
+
+	node.Status = status
+	if status == CheckPlanEvaluated && !anyEvaluated {
+		node.Status = CheckPlanShortCircuited
+	}
+	return node, nil
+}
+
+func (pb *checkPlanBuilder) setOperationChildNode(
+	ctx context.Context,
+	ts *typesystem.TypeSystem,
+	permissionName string,
+	child *core.SetOperation_Child,
+	onResource bool,
+	parentStatus CheckPlanStatus,
+	depth uint32,
+	encountered []string,
+) (*CheckPlanNode, error) {
+	namespaceName := ts.Namespace().Name
+	status := pb.childStatus(namespaceName, permissionName, child, parentStatus)
+
+	switch c := child.ChildType.(type) {
+	case *core.SetOperation_Child_UsersetRewrite:
+		return pb.rewriteNode(ctx, ts, permissionName, c.UsersetRewrite, onResource, parentStatus, depth, encountered)
+
+	case *core.SetOperation_Child_ComputedUserset:
+		return pb.relationNode(ctx, namespaceName, c.ComputedUserset.Relation, onResource, status, depth, encountered)
+
+	case *core.SetOperation_Child_TupleToUserset:
+		return pb.arrowNode(ctx, ts, CheckPlanArrow, c.TupleToUserset.Tupleset.Relation, c.TupleToUserset.ComputedUserset.Relation, onResource, status, depth, encountered)
+
+	case *core.SetOperation_Child_FunctionedTupleToUserset:
+		kind := CheckPlanArrow
+		if c.FunctionedTupleToUserset.Function == core.FunctionedTupleToUserset_FUNCTION_ALL {
+			kind = CheckPlanIntersectionArrow
+		}
+		return pb.arrowNode(ctx, ts, kind, c.FunctionedTupleToUserset.Tupleset.Relation, c.FunctionedTupleToUserset.ComputedUserset.Relation, onResource, status, depth, encountered)
+
+	case *core.SetOperation_Child_XNil:
+		return &CheckPlanNode{
+			Kind:         CheckPlanNil,
+			ResourceType: namespaceName,
+			Status:       status,
+		}, nil
+
+	default:
+		return nil, spiceerrors.MustBugf("unknown set operation child `%T` in check plan", child)
+	}
+}
+
+func (pb *checkPlanBuilder) childStatus(namespaceName string, permissionName string, child *core.SetOperation_Child, parentStatus CheckPlanStatus) CheckPlanStatus {
+	switch parentStatus {
+	case CheckPlanEvaluated:
+		// Handled below.
+
+	case CheckPlanShortCircuited:
+		return CheckPlanSkipped
+
+	default:
+		return parentStatus
+	}
+
+	switch pb.recorder.BranchStatus(namespaceName, permissionName, BranchKey(child)) {
+	case BranchCompleted:
+		return CheckPlanEvaluated
+
+	case BranchCanceled:
+		return CheckPlanShortCircuited
+
+	default:
+		// NOTE: aliased permissions are dispatched directly to the relation they alias, in which
+		// case the branch itself is never recorded.
+		if cu := child.GetComputedUserset(); cu != nil && pb.recorder.RelationEvaluated(namespaceName, cu.Relation) {
+			return CheckPlanEvaluated
+		}
+
+		return CheckPlanShortCircuited
+	}
+}
+
+func (pb *checkPlanBuilder) arrowNode(
+	ctx context.Context,
+	ts *typesystem.TypeSystem,
+	kind CheckPlanNodeKind,
+	tuplesetRelation string,
+	computedRelation string,
+	onResource bool,
+	status CheckPlanStatus,
+	depth uint32,
+	encountered []string,
+) (*CheckPlanNode, error) {
+	namespaceName := ts.Namespace().Name
+	node := &CheckPlanNode{
+		Kind:             kind,
+		ResourceType:     namespaceName,
+		Relation:         computedRelation,
+		TuplesetRelation: tuplesetRelation,
+		Status:           status,
+	}
+
+	if err := pb.annotateCounts(ctx, node, namespaceName, tuplesetRelation, onResource); err != nil {
+		return nil, err
+	}
+
+	allowedRelations, err := ts.AllowedDirectRelationsAndWildcards(tuplesetRelation)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]struct{}{}
+	for _, allowed := range allowedRelations {
+		if _, ok := seen[allowed.Namespace]; ok {
+			continue
+		}
+		seen[allowed.Namespace] = struct{}{}
+
+		targetTS, err := pb.typeSystem(ctx, allowed.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		// Arrows skip subject types without the computed relation.
+		if !targetTS.HasRelation(computedRelation) {
+			continue
+		}
+
+		childStatus := status
+		switch status {
+		case CheckPlanEvaluated:
+			if !pb.recorder.RelationEvaluated(allowed.Namespace, computedRelation) {
+				childStatus = CheckPlanSkipped
+				if pb.recorder.hasDispatchTo(allowed.Namespace, computedRelation) {
+					childStatus = CheckPlanNotObserved
+				}
+			}
+
+		case CheckPlanShortCircuited:
+			childStatus = CheckPlanSkipped
+		}
+
+		childNode, err := pb.relationNode(ctx, allowed.Namespace, computedRelation, false, childStatus, depth, encountered)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, childNode)
+	}
+
+	return node, nil
+}
+
+func (pb *checkPlanBuilder) annotateCounts(ctx context.Context, node *CheckPlanNode, namespaceName string, relationName string, onResource bool) error {
+	if count, ok := pb.countsByRelation[tuple.JoinRelRef(namespaceName, relationName)]; ok {
+		node.CountedRelationships = &count
+	}
+
+	if !onResource || pb.params.MaximumFanOutEstimate == 0 {
+		return nil
+	}
+
+	limit := pb.params.MaximumFanOutEstimate + 1
+	it, err := pb.reader.QueryRelationships(ctx, datastore.RelationshipsFilter{
+		OptionalResourceType:     namespaceName,
+		OptionalResourceIds:      []string{pb.params.ResourceID},
+		OptionalResourceRelation: relationName,
+	}, options.WithLimit(&limit))
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	var count uint64
+	for tpl := it.Next(); tpl != nil; tpl = it.Next() {
+		if it.Err() != nil {
+			return it.Err()
+		}
+		count++
+	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+
+	if count > pb.params.MaximumFanOutEstimate {
+		count = pb.params.MaximumFanOutEstimate
+		node.FanOutCapped = true
+	}
+
+	node.EstimatedFanOut = &count
+	return nil
+}
@@ -0,0 +1,167 @@
+package graph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/testfixtures"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const checkPlanTestSchema = `
+	definition user {}
+
+	caveat somecaveat(somecondition int) {
+		somecondition == 42
+	}
+
+	definition folder {
+		relation viewer: user
+		permission view = viewer
+	}
+
+	definition document {
+		relation parent: folder
+		relation viewer: user | user with somecaveat
+		relation banned: user
+		permission view = (viewer + parent->view) - banned
+	}
+`
+
+func TestBuildCheckPlan(t *testing.T) {
+	require := require.New(t)
+
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(err)
+
+	ds, revision := testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, checkPlanTestSchema, []*core.RelationTuple{
+		tuple.MustParse("document:first#viewer@user:tom"),
+		tuple.MustParse("document:first#viewer@user:fred"),
+		tuple.MustParse("document:first#parent@folder:somefolder"),
+		tuple.MustParse("document:first#parent@folder:anotherfolder"),
+		tuple.MustParse("document:first#parent@folder:thirdfolder"),
+	}, require)
+
+	reader := ds.SnapshotReader(revision)
+	plan, err := BuildCheckPlan(context.Background(), reader, CheckPlanParameters{
+		ResourceType:          "document",
+		ResourceID:            "first",
+		Permission:            "view",
+		MaximumDepth:          10,
+		MaximumFanOutEstimate: 2,
+	}, nil)
+	require.NoError(err)
+
+	require.Equal(CheckPlanPermission, plan.Kind)
+	require.Empty(plan.Status)
+	require.Len(plan.Children, 1)
+
+	exclusion := plan.Children[0]
+	require.Equal(CheckPlanExclusion, exclusion.Kind)
+	require.Len(exclusion.Children, 2)
+
+	union := exclusion.Children[0]
+	require.Equal(CheckPlanUnion, union.Kind)
+	require.Len(union.Children, 2)
+
+	viewer := union.Children[0]
+	require.Equal(CheckPlanRelation, viewer.Kind)
+	require.Equal("viewer", viewer.Relation)
+	require.Equal([]string{"user", "user with somecaveat"}, viewer.AllowedSubjectTypes)
+	require.Equal(uint64(2), *viewer.EstimatedFanOut)
+	require.False(viewer.FanOutCapped)
+
+	arrow := union.Children[1]
+	require.Equal(CheckPlanArrow, arrow.Kind)
+	require.Equal("parent", arrow.TuplesetRelation)
+	require.Equal("view", arrow.Relation)
+	require.Equal(uint64(2), *arrow.EstimatedFanOut)
+	require.True(arrow.FanOutCapped)
+
+	require.Len(arrow.Children, 1)
+	folderView := arrow.Children[0]
+	require.Equal(CheckPlanPermission, folderView.Kind)
+	require.Equal("folder", folderView.ResourceType)
+	require.Nil(folderView.Children[0].Children[0].EstimatedFanOut)
+
+	banned := exclusion.Children[1]
+	require.Equal(CheckPlanRelation, banned.Kind)
+	require.Equal(uint64(0), *banned.EstimatedFanOut)
+}
+
+func TestBuildCheckPlanWithRecorder(t *testing.T) {
+	require := require.New(t)
+
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(err)
+
+	ds, revision := testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, `
+		definition user {}
+
+		definition document {
+			relation viewer: user
+			relation editor: user
+			relation owner: user
+			permission view = viewer + editor + owner
+		}
+	`, nil, require)
+
+	recorder := NewCheckRecorder(DefaultMaximumRecordedDispatches)
+	rr := &core.RelationReference{Namespace: "document", Relation: "view"}
+	recorder.recordRelation(ValidatedCheckRequest{DispatchCheckRequest: &v1.DispatchCheckRequest{ResourceRelation: rr}})
+	recorder.recordBranch(rr, "viewer", false)
+	recorder.recordBranch(rr, "editor", true)
+
+	reader := ds.SnapshotReader(revision)
+	plan, err := BuildCheckPlan(context.Background(), reader, CheckPlanParameters{
+		ResourceType: "document",
+		ResourceID:   "first",
+		Permission:   "view",
+		MaximumDepth: 10,
+	}, recorder)
+	require.NoError(err)
+
+	require.Equal(CheckPlanEvaluated, plan.Status)
+	union := plan.Children[0]
+	require.Equal(CheckPlanEvaluated, union.Status)
+	require.Equal(CheckPlanEvaluated, union.Children[0].Status)
+	require.Equal(CheckPlanShortCircuited, union.Children[1].Status)
+	require.Equal(CheckPlanShortCircuited, union.Children[2].Status)
+}
+
+func TestBuildCheckPlanTruncatesRecursion(t *testing.T) {
+	require := require.New(t)
+
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(err)
+
+	ds, revision := testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, `
+		definition user {}
+
+		definition folder {
+			relation parent: folder
+			relation viewer: user
+			permission view = viewer + parent->view
+		}
+	`, nil, require)
+
+	reader := ds.SnapshotReader(revision)
+	plan, err := BuildCheckPlan(context.Background(), reader, CheckPlanParameters{
+		ResourceType: "folder",
+		ResourceID:   "first",
+		Permission:   "view",
+		MaximumDepth: 10,
+	}, nil)
+	require.NoError(err)
+
+	arrow := plan.Children[0].Children[1]
+	require.Equal(CheckPlanArrow, arrow.Kind)
+	require.Len(arrow.Children, 1)
+	require.True(arrow.Children[0].Truncated)
+	require.Empty(arrow.Children[0].Children)
+}
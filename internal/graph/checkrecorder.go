@@ -0,0 +1,215 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+// DefaultMaximumRecordedDispatches is the default maximum number of dispatches retained by a
+// CheckRecorder.
+const DefaultMaximumRecordedDispatches = 1000
+
+type checkRecorderKey struct{}
+
+// ContextWithCheckRecorder returns a new context which records the branches and dispatches
+// performed by the check(s) run under it into the given recorder.
+//
+// Unlike debug tracing, recording does not change how the check is executed (no batching is
+// disabled and results are still cached), which makes it suitable for explaining checks
+// as they would normally run. Only work performed within this process is recorded: remote
+// dispatches are recorded as a single dispatch, with their internals unobserved.
+func ContextWithCheckRecorder(ctx context.Context, recorder *CheckRecorder) context.Context {
+	return context.WithValue(ctx, checkRecorderKey{}, recorder)
+}
+
+func checkRecorderFromContext(ctx context.Context) *CheckRecorder {
+	recorder, _ := ctx.Value(checkRecorderKey{}).(*CheckRecorder)
+	return recorder
+}
+
+// BranchStatus is the recorded status of a branch of a check.
+type BranchStatus int
+
+const (
+	// BranchNotRecorded indicates that no evaluation of the branch was recorded.
+	BranchNotRecorded BranchStatus = iota
+
+	// BranchCanceled indicates that the branch was started, but canceled before completion,
+	// because a sibling branch determined the result first.
+	BranchCanceled
+
+	// BranchCompleted indicates that the branch was evaluated to completion at least once.
+	BranchCompleted
+)
+
+// RecordedDispatch is a single check dispatch recorded by a CheckRecorder.
+type RecordedDispatch struct {
+	// ResourceRelation is the resource type and relation of the dispatch.
+	ResourceRelation *core.RelationReference
+
+	// ResourceCount is the number of resource IDs in the dispatch.
+	ResourceCount int
+
+	// ResultCount is the number of resources found in the dispatch's response.
+	ResultCount int
+
+	// DispatchCount is the number of dispatches reported by the response.
+	DispatchCount uint32
+
+	// CachedDispatchCount is the number of cached dispatches reported by the response.
+	CachedDispatchCount uint32
+
+	// Duration is the time spent waiting on the dispatch.
+	Duration time.Duration
+
+	// Err is the error returned by the dispatch, if any.
+	Err error
+}
+
+// CheckRecorder records which branches of the rewrites were evaluated and which dispatches
+// were made while performing a check. It is safe for concurrent use.
+type CheckRecorder struct {
+	maximumDispatches int
+
+	lock               sync.Mutex
+	evaluatedRelations map[string]struct{}
+	branches           map[string]BranchStatus
+	dispatches         []RecordedDispatch
+	droppedDispatches  int
+}
+
+// NewCheckRecorder creates a new CheckRecorder, which retains up to the given number of dispatches.
+func NewCheckRecorder(maximumDispatches int) *CheckRecorder {
+	return &CheckRecorder{
+		maximumDispatches:  maximumDispatches,
+		evaluatedRelations: map[string]struct{}{},
+		branches:           map[string]BranchStatus{},
+	}
+}
+
+// RelationEvaluated returns whether the given relation was evaluated locally.
+func (cr *CheckRecorder) RelationEvaluated(namespaceName string, relationName string) bool {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	_, ok := cr.evaluatedRelations[tuple.JoinRelRef(namespaceName, relationName)]
+	return ok
+}
+
+// BranchStatus returns the status of the branch with the given key under the given relation.
+func (cr *CheckRecorder) BranchStatus(namespaceName string, relationName string, branchKey string) BranchStatus {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	return cr.branches[recordedBranchKey(namespaceName, relationName, branchKey)]
+}
+
+// Dispatches returns the dispatches recorded, in the order they completed, as well as the
+// number of dispatches which were dropped due to the maximum being reached.
+func (cr *CheckRecorder) Dispatches() ([]RecordedDispatch, int) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	dispatches := make([]RecordedDispatch, len(cr.dispatches))
+	copy(dispatches, cr.dispatches)
+	return dispatches, cr.droppedDispatches
+}
+
+func (cr *CheckRecorder) hasDispatchTo(namespaceName string, relationName string) bool {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	for _, dispatch := range cr.dispatches {
+		if dispatch.ResourceRelation.Namespace == namespaceName && dispatch.ResourceRelation.Relation == relationName {
+			return true
+		}
+	}
+	return false
+}
+
+func (cr *CheckRecorder) recordRelation(req ValidatedCheckRequest) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	cr.evaluatedRelations[tuple.StringRR(req.ResourceRelation)] = struct{}{}
+	if req.OriginalRelationName != "" {
+		cr.evaluatedRelations[tuple.JoinRelRef(req.ResourceRelation.Namespace, req.OriginalRelationName)] = struct{}{}
+	}
+}
+
+func (cr *CheckRecorder) recordBranch(rr *core.RelationReference, branchKey string, canceled bool) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	key := recordedBranchKey(rr.Namespace, rr.Relation, branchKey)
+	if canceled {
+		if cr.branches[key] == BranchNotRecorded {
+			cr.branches[key] = BranchCanceled
+		}
+		return
+	}
+
+	cr.branches[key] = BranchCompleted
+}
+
+func (cr *CheckRecorder) recordDispatch(req *v1.DispatchCheckRequest, resp *v1.DispatchCheckResponse, err error, duration time.Duration) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	if len(cr.dispatches) >= cr.maximumDispatches {
+		cr.droppedDispatches++
+		return
+	}
+
+	recorded := RecordedDispatch{
+		ResourceRelation: req.ResourceRelation,
+		ResourceCount:    len(req.ResourceIds),
+		Duration:         duration,
+		Err:              err,
+	}
+
+	if resp != nil {
+		recorded.ResultCount = len(resp.ResultsByResourceId)
+		if resp.Metadata != nil {
+			recorded.DispatchCount = resp.Metadata.DispatchCount
+			recorded.CachedDispatchCount = resp.Metadata.CachedDispatchCount
+		}
+	}
+
+	cr.dispatches = append(cr.dispatches, recorded)
+}
+
+func recordedBranchKey(namespaceName string, relationName string, branchKey string) string {
+	return tuple.JoinRelRef(namespaceName, relationName) + "/" + branchKey
+}
+
+// BranchKey returns a key identifying the given child of a set operation within its permission.
+// Nested rewrites do not have a key of their own, as their children are recorded directly.
+func BranchKey(child *core.SetOperation_Child) string {
+	switch c := child.ChildType.(type) {
+	case *core.SetOperation_Child_ComputedUserset:
+		return c.ComputedUserset.Relation
+
+	case *core.SetOperation_Child_TupleToUserset:
+		return c.TupleToUserset.Tupleset.Relation + "->" + c.TupleToUserset.ComputedUserset.Relation
+
+	case *core.SetOperation_Child_FunctionedTupleToUserset:
+		switch c.FunctionedTupleToUserset.Function {
+		case core.FunctionedTupleToUserset_FUNCTION_ALL:
+			return c.FunctionedTupleToUserset.Tupleset.Relation + ".all(" + c.FunctionedTupleToUserset.ComputedUserset.Relation + ")"
+		default:
+			return c.FunctionedTupleToUserset.Tupleset.Relation + ".any(" + c.FunctionedTupleToUserset.ComputedUserset.Relation + ")"
+		}
+
+	case *core.SetOperation_Child_XNil:
+		return "nil"
+
+	default:
+		return ""
+	}
+}
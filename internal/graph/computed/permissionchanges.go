@@ -0,0 +1,222 @@
+package computed
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/typesystem"
+)
+
+// Permissionship is the permissionship of a subject, ordered from none to unconditional.
+type Permissionship int
+
+const (
+	// NoPermission indicates that the subject does not have the permission.
+	NoPermission Permissionship = iota
+
+	// ConditionalPermission indicates that the subject has the permission depending on caveats.
+	ConditionalPermission
+
+	// HasPermission indicates that the subject has the permission.
+	HasPermission
+)
+
+// PermissionRelations are the relations and permissions from which a permission is computed.
+type PermissionRelations struct {
+	// ResourceRelation is the permission.
+	ResourceRelation *core.RelationReference
+
+	// relationsByDefinition holds the relations and permissions encountered when computing the
+	// permission, by definition. Changes to the relationships of other relations cannot change the
+	// permission.
+	relationsByDefinition map[string][]string
+}
+
+// RelationsForPermission finds the relations from which the permission is computed via the
+// reachability graph. The permission is expected to have been validated against the schema.
+func RelationsForPermission(ctx context.Context, reader datastore.Reader, resourceRelation *core.RelationReference) (*PermissionRelations, error) {
+	_, vts, err := typesystem.ReadNamespaceAndTypes(ctx, resourceRelation.Namespace, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	encountered, err := typesystem.ReachabilityGraphFor(vts).RelationsEncounteredForResource(ctx, resourceRelation)
+	if err != nil {
+		return nil, err
+	}
+
+	relationsByDefinition := map[string][]string{
+		resourceRelation.Namespace: {resourceRelation.Relation},
+	}
+	for _, rr := range encountered {
+		if !slices.Contains(relationsByDefinition[rr.Namespace], rr.Relation) {
+			relationsByDefinition[rr.Namespace] = append(relationsByDefinition[rr.Namespace], rr.Relation)
+		}
+	}
+
+	return &PermissionRelations{
+		ResourceRelation:      resourceRelation,
+		relationsByDefinition: relationsByDefinition,
+	}, nil
+}
+
+// IsAffectedBy returns whether a change to the relationships of the relation could change the
+// permission.
+func (pr *PermissionRelations) IsAffectedBy(namespace string, relation string) bool {
+	return slices.Contains(pr.relationsByDefinition[namespace], relation)
+}
+
+// DependsOnDefinition returns whether a change to the definition could change the relations from
+// which the permission is computed.
+func (pr *PermissionRelations) DependsOnDefinition(name string) bool {
+	_, ok := pr.relationsByDefinition[name]
+	return ok
+}
+
+// ErrTooManyAffectedResources is returned by AffectedResources if more resources than the maximum
+// given may have been affected.
+var ErrTooManyAffectedResources = errors.New("too many affected resources")
+
+// AffectedResources returns the sorted IDs of the resources whose permission may have been changed
+// by the relationship changes, as of any of the revisions given. If maximumResources is non-zero
+// and more resources may have been affected, the lookup stops and ErrTooManyAffectedResources is
+// returned.
+//
+// The resources are found by looking up the resources reachable from the resource of each changed
+// relationship. As the changed relation may be used by an arrow, the resources reached are looked
+// up from each relation and permission of the definition from which the permission is computed,
+// rather than only from the changed relation.
+func (pr *PermissionRelations) AffectedResources(
+	ctx context.Context,
+	d dispatch.LookupResources2,
+	relationshipChanges []*core.RelationTupleUpdate,
+	revisions []datastore.Revision,
+	maximumDepth uint32,
+	maximumResources uint32,
+) ([]string, error) {
+	affectedResources := mapz.NewSet[string]()
+	looked := mapz.NewSet[string]()
+	for _, update := range relationshipChanges {
+		changed := update.Tuple.ResourceAndRelation
+		relations := pr.relationsByDefinition[changed.Namespace]
+		if !slices.Contains(relations, changed.Relation) {
+			continue
+		}
+
+		for _, relation := range relations {
+			subject := &core.ObjectAndRelation{Namespace: changed.Namespace, ObjectId: changed.ObjectId, Relation: relation}
+			if !looked.Add(tuple.StringONR(subject)) {
+				continue
+			}
+
+			for _, revision := range revisions {
+				if err := lookupResources(ctx, d, revision, pr.ResourceRelation, subject, maximumDepth, maximumResources, affectedResources); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	resourceIDs := affectedResources.AsSlice()
+	slices.Sort(resourceIDs)
+	return resourceIDs, nil
+}
+
+// lookupResources adds the IDs of the resources reachable from the subject at the revision to the
+// set given, failing if it grows beyond the maximum, if non-zero.
+func lookupResources(
+	ctx context.Context,
+	d dispatch.LookupResources2,
+	revision datastore.Revision,
+	resourceRelation *core.RelationReference,
+	subject *core.ObjectAndRelation,
+	maximumDepth uint32,
+	maximumResources uint32,
+	found *mapz.Set[string],
+) error {
+	bf, err := v1.NewTraversalBloomFilter(uint(maximumDepth))
+	if err != nil {
+		return err
+	}
+
+	stream := dispatch.NewHandlingDispatchStream(ctx, func(result *v1.DispatchLookupResources2Response) error {
+		found.Add(result.Resource.ResourceId)
+		if maximumResources > 0 && found.Len() > int(maximumResources) {
+			return ErrTooManyAffectedResources
+		}
+		return nil
+	})
+
+	return d.DispatchLookupResources2(&v1.DispatchLookupResources2Request{
+		Metadata: &v1.ResolverMeta{
+			AtRevision:     revision.String(),
+			DepthRemaining: maximumDepth,
+			TraversalBloom: bf,
+		},
+		ResourceRelation: resourceRelation,
+		SubjectRelation:  &core.RelationReference{Namespace: subject.Namespace, Relation: subject.Relation},
+		SubjectIds:       []string{subject.ObjectId},
+		TerminalSubject:  subject,
+	}, stream)
+}
+
+// LookupSubjectPermissionships returns the permissionship of each subject of the type with the
+// permission on each of the resources at the revision, with an entry for every resource given. A
+// wildcard subject is returned under its `*` ID.
+func LookupSubjectPermissionships(
+	ctx context.Context,
+	d dispatch.LookupSubjects,
+	revision datastore.Revision,
+	resourceRelation *core.RelationReference,
+	resourceIDs []string,
+	subjectType *core.RelationReference,
+	maximumDepth uint32,
+) (map[string]map[string]Permissionship, error) {
+	bf, err := v1.NewTraversalBloomFilter(uint(maximumDepth))
+	if err != nil {
+		return nil, err
+	}
+
+	subjectsByResource := make(map[string]map[string]Permissionship, len(resourceIDs))
+	for _, resourceID := range resourceIDs {
+		subjectsByResource[resourceID] = make(map[string]Permissionship)
+	}
+
+	stream := dispatch.NewHandlingDispatchStream(ctx, func(result *v1.DispatchLookupSubjectsResponse) error {
+		for resourceID, foundSubjects := range result.FoundSubjectsByResourceId {
+			subjects, ok := subjectsByResource[resourceID]
+			if !ok {
+				continue
+			}
+
+			for _, foundSubject := range foundSubjects.FoundSubjects {
+				found := HasPermission
+				if foundSubject.CaveatExpression != nil {
+					found = ConditionalPermission
+				}
+
+				subjects[foundSubject.SubjectId] = max(subjects[foundSubject.SubjectId], found)
+			}
+		}
+		return nil
+	})
+
+	err = d.DispatchLookupSubjects(&v1.DispatchLookupSubjectsRequest{
+		Metadata: &v1.ResolverMeta{
+			AtRevision:     revision.String(),
+			DepthRemaining: maximumDepth,
+			TraversalBloom: bf,
+		},
+		ResourceRelation: resourceRelation,
+		ResourceIds:      resourceIDs,
+		SubjectRelation:  subjectType,
+	}, stream)
+	return subjectsByResource, err
+}
@@ -87,3 +87,17 @@ func addAdditionalDepthRequired(metadata *v1.ResponseMeta) *v1.ResponseMeta {
 		DebugInfo:           metadata.DebugInfo,
 	}
 }
+
+// ConcurrencyLimiter provides the maximum number of subproblems dispatched concurrently by a
+// request. The limit is read as each request is processed, so it may change over time.
+type ConcurrencyLimiter interface {
+	Limit() uint16
+}
+
+// StaticConcurrencyLimit is a ConcurrencyLimiter with a fixed limit.
+type StaticConcurrencyLimit uint16
+
+// Limit returns the fixed limit.
+func (l StaticConcurrencyLimit) Limit() uint16 {
+	return uint16(l)
+}
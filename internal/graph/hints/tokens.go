@@ -0,0 +1,60 @@
+package hints
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+)
+
+// ErrInvalidHintToken is returned when a hint token cannot be decoded or was not signed with the
+// expected key.
+var ErrInvalidHintToken = errors.New("invalid check hints token")
+
+// EncodeHintToken encodes the given check hints, computed at the given revision, into an opaque
+// token signed with the given key. The token can be returned to clients and later decoded via
+// DecodeHintToken to reuse the hints in checks at the same revision.
+func EncodeHintToken(key []byte, revision string, checkHints []*v1.CheckHint) (string, error) {
+	// NOTE: a dispatch request is used as the envelope for the token, as it already carries both
+	// a revision and a set of check hints.
+	payload, err := (&v1.DispatchCheckRequest{
+		Metadata:   &v1.ResolverMeta{AtRevision: revision},
+		CheckHints: checkHints,
+	}).MarshalVT()
+	if err != nil {
+		return "", fmt.Errorf("could not encode check hints: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(append(signHintPayload(key, payload), payload...)), nil
+}
+
+// DecodeHintToken decodes a token produced by EncodeHintToken, returning the revision at which the
+// hints were computed and the hints themselves. Returns ErrInvalidHintToken if the token is malformed
+// or its signature does not match the given key.
+func DecodeHintToken(key []byte, token string) (string, []*v1.CheckHint, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) < sha256.Size {
+		return "", nil, ErrInvalidHintToken
+	}
+
+	signature, payload := decoded[:sha256.Size], decoded[sha256.Size:]
+	if !hmac.Equal(signature, signHintPayload(key, payload)) {
+		return "", nil, ErrInvalidHintToken
+	}
+
+	envelope := &v1.DispatchCheckRequest{}
+	if err := envelope.UnmarshalVT(payload); err != nil {
+		return "", nil, ErrInvalidHintToken
+	}
+
+	return envelope.GetMetadata().GetAtRevision(), envelope.CheckHints, nil
+}
+
+func signHintPayload(key []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,47 @@
+package hints
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+func TestHintTokenRoundTrip(t *testing.T) {
+	key := []byte("somekey")
+	checkHints := []*v1.CheckHint{
+		CheckHintForComputedUserset("document", "somedoc", "viewer", tuple.ParseSubjectONR("user:tom"), &v1.ResourceCheckResult{
+			Membership: v1.ResourceCheckResult_MEMBER,
+		}),
+		CheckHintForArrow("document", "somedoc", "parent", "view", tuple.ParseSubjectONR("user:tom"), &v1.ResourceCheckResult{
+			Membership: v1.ResourceCheckResult_NOT_MEMBER,
+		}),
+	}
+
+	token, err := EncodeHintToken(key, "12345", checkHints)
+	require.NoError(t, err)
+
+	revision, decoded, err := DecodeHintToken(key, token)
+	require.NoError(t, err)
+	require.Equal(t, "12345", revision)
+	require.Len(t, decoded, 2)
+	for index, hint := range checkHints {
+		require.True(t, hint.EqualVT(decoded[index]))
+	}
+}
+
+func TestHintTokenInvalid(t *testing.T) {
+	token, err := EncodeHintToken([]byte("somekey"), "12345", nil)
+	require.NoError(t, err)
+
+	_, _, err = DecodeHintToken([]byte("anotherkey"), token)
+	require.ErrorIs(t, err, ErrInvalidHintToken)
+
+	_, _, err = DecodeHintToken([]byte("somekey"), "not a token")
+	require.ErrorIs(t, err, ErrInvalidHintToken)
+
+	_, _, err = DecodeHintToken([]byte("somekey"), token[:10])
+	require.ErrorIs(t, err, ErrInvalidHintToken)
+}
@@ -12,7 +12,7 @@ import (
 )
 
 // NewCursoredLookupResources creates and instance of CursoredLookupResources.
-func NewCursoredLookupResources(c dispatch.Check, r dispatch.ReachableResources, concurrencyLimit uint16, dispatchChunkSize uint16) *CursoredLookupResources {
+func NewCursoredLookupResources(c dispatch.Check, r dispatch.ReachableResources, concurrencyLimit ConcurrencyLimiter, dispatchChunkSize uint16) *CursoredLookupResources {
 	return &CursoredLookupResources{c, r, concurrencyLimit, dispatchChunkSize}
 }
 
@@ -21,7 +21,7 @@ func NewCursoredLookupResources(c dispatch.Check, r dispatch.ReachableResources,
 type CursoredLookupResources struct {
 	c                 dispatch.Check
 	r                 dispatch.ReachableResources
-	concurrencyLimit  uint16
+	concurrencyLimit  ConcurrencyLimiter
 	dispatchChunkSize uint16
 }
 
@@ -57,7 +57,7 @@ func (cl *CursoredLookupResources) LookupResources(
 		// to the parent stream, as found resources if they are properly checked.
 		checkingStream := newCheckingResourceStream(lookupContext, reachableContext, func() {
 			cancelReachable(errCanceledBecauseNoAdditionalResourcesNeeded)
-		}, req, cl.c, parentStream, limits, cl.concurrencyLimit, cl.dispatchChunkSize)
+		}, req, cl.c, parentStream, limits, cl.concurrencyLimit.Limit(), cl.dispatchChunkSize)
 
 		err := cl.r.DispatchReachableResources(&v1.DispatchReachableResourcesRequest{
 			ResourceRelation: req.ObjectRelation,
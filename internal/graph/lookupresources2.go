@@ -20,14 +20,14 @@ import (
 	"github.com/zapravila/spicedb/pkg/typesystem"
 )
 
-func NewCursoredLookupResources2(dl dispatch.LookupResources2, dc dispatch.Check, concurrencyLimit uint16, dispatchChunkSize uint16) *CursoredLookupResources2 {
+func NewCursoredLookupResources2(dl dispatch.LookupResources2, dc dispatch.Check, concurrencyLimit ConcurrencyLimiter, dispatchChunkSize uint16) *CursoredLookupResources2 {
 	return &CursoredLookupResources2{dl, dc, concurrencyLimit, dispatchChunkSize}
 }
 
 type CursoredLookupResources2 struct {
 	dl                dispatch.LookupResources2
 	dc                dispatch.Check
-	concurrencyLimit  uint16
+	concurrencyLimit  ConcurrencyLimiter
 	dispatchChunkSize uint16
 }
 
@@ -125,7 +125,7 @@ func (crr *CursoredLookupResources2) afterSameType(
 	}
 
 	// For each entrypoint, load the necessary data and re-dispatch if a subproblem was found.
-	return withParallelizedStreamingIterableInCursor(ctx, ci, entrypoints, parentStream, crr.concurrencyLimit,
+	return withParallelizedStreamingIterableInCursor(ctx, ci, entrypoints, parentStream, crr.concurrencyLimit.Limit(),
 		func(ctx context.Context, ci cursorInformation, entrypoint typesystem.ReachabilityEntrypoint, stream dispatch.LookupResources2Stream) error {
 			switch entrypoint.EntrypointKind() {
 			case core.ReachabilityEntrypoint_RELATION_ENTRYPOINT:
@@ -235,7 +235,7 @@ func (crr *CursoredLookupResources2) lookupRelationEntrypoint(
 			foundResourceType:  relationReference,
 			entrypoint:         entrypoint,
 			rg:                 rg,
-			concurrencyLimit:   crr.concurrencyLimit,
+			concurrencyLimit:   crr.concurrencyLimit.Limit(),
 			parentStream:       stream,
 			parentRequest:      req,
 			dispatched:         dispatched,
@@ -609,6 +609,7 @@ func (crr *CursoredLookupResources2) redispatchOrReport(
 					Metadata: &v1.ResolverMeta{
 						AtRevision:     parentRequest.Revision.String(),
 						DepthRemaining: parentRequest.Metadata.DepthRemaining - 1,
+						TraversalBloom: parentRequest.Metadata.TraversalBloom,
 					},
 					OptionalCursor: ci.currentCursor,
 					OptionalLimit:  parentRequest.OptionalLimit,
@@ -628,7 +629,7 @@ func (crr *CursoredLookupResources2) redispatchOrReport(
 				entrypoint,
 				crr.dl,
 				crr.dc,
-				crr.concurrencyLimit,
+				crr.concurrencyLimit.Limit(),
 				crr.dispatchChunkSize,
 			)
 		})
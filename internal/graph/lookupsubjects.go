@@ -33,13 +33,13 @@ type ValidatedLookupSubjectsRequest struct {
 }
 
 // NewConcurrentLookupSubjects creates an instance of ConcurrentLookupSubjects.
-func NewConcurrentLookupSubjects(d dispatch.LookupSubjects, concurrencyLimit uint16, dispatchChunkSize uint16) *ConcurrentLookupSubjects {
+func NewConcurrentLookupSubjects(d dispatch.LookupSubjects, concurrencyLimit ConcurrencyLimiter, dispatchChunkSize uint16) *ConcurrentLookupSubjects {
 	return &ConcurrentLookupSubjects{d, concurrencyLimit, dispatchChunkSize}
 }
 
 type ConcurrentLookupSubjects struct {
 	d                 dispatch.LookupSubjects
-	concurrencyLimit  uint16
+	concurrencyLimit  ConcurrencyLimiter
 	dispatchChunkSize uint16
 }
 
@@ -53,6 +53,10 @@ func (cl *ConcurrentLookupSubjects) LookupSubjects(
 		return fmt.Errorf("no resources ids given to lookupsubjects dispatch")
 	}
 
+	if len(req.AdditionalSubjectRelations) > 0 {
+		return cl.lookupSubjectsOfRelations(req, stream)
+	}
+
 	// If the resource type matches the subject type, yield directly.
 	if req.SubjectRelation.Namespace == req.ResourceRelation.Namespace &&
 		req.SubjectRelation.Relation == req.ResourceRelation.Relation {
@@ -83,6 +87,45 @@ func (cl *ConcurrentLookupSubjects) LookupSubjects(
 	return cl.lookupViaRewrite(ctx, req, stream, relation.UsersetRewrite)
 }
 
+// lookupSubjectsOfRelations looks up the subjects of the subject relation of the request and of
+// each of its additional subject relations concurrently, publishing each response with the relation
+// of its subjects.
+func (cl *ConcurrentLookupSubjects) lookupSubjectsOfRelations(
+	req ValidatedLookupSubjectsRequest,
+	stream dispatch.LookupSubjectsStream,
+) error {
+	subjectRelations := append([]*core.RelationReference{req.SubjectRelation}, req.AdditionalSubjectRelations...)
+
+	var publishMu sync.Mutex
+	g, subCtx := errgroup.WithContext(stream.Context())
+	g.SetLimit(int(cl.concurrencyLimit.Limit()))
+	for _, subjectRelation := range subjectRelations {
+		subjectRelation := subjectRelation
+		g.Go(func() error {
+			relationStream := dispatch.NewHandlingDispatchStream(subCtx, func(result *v1.DispatchLookupSubjectsResponse) error {
+				publishMu.Lock()
+				defer publishMu.Unlock()
+				return stream.Publish(&v1.DispatchLookupSubjectsResponse{
+					FoundSubjectsByResourceId: result.FoundSubjectsByResourceId,
+					Metadata:                  result.Metadata,
+					SubjectRelation:           subjectRelation,
+				})
+			})
+
+			return cl.LookupSubjects(ValidatedLookupSubjectsRequest{
+				DispatchLookupSubjectsRequest: &v1.DispatchLookupSubjectsRequest{
+					Metadata:         req.Metadata,
+					ResourceRelation: req.ResourceRelation,
+					ResourceIds:      req.ResourceIds,
+					SubjectRelation:  subjectRelation,
+				},
+				Revision: req.Revision,
+			}, relationStream)
+		})
+	}
+	return g.Wait()
+}
+
 func subjectsForConcreteIds(subjectIds []string) map[string]*v1.FoundSubjects {
 	foundSubjects := make(map[string]*v1.FoundSubjects, len(subjectIds))
 	for _, subjectID := range subjectIds {
@@ -190,6 +233,7 @@ func (cl *ConcurrentLookupSubjects) lookupViaComputed(
 		Metadata: &v1.ResolverMeta{
 			AtRevision:     parentRequest.Revision.String(),
 			DepthRemaining: parentRequest.Metadata.DepthRemaining - 1,
+			TraversalBloom: parentRequest.Metadata.TraversalBloom,
 		},
 	}, stream)
 }
@@ -237,7 +281,7 @@ func lookupViaIntersectionTupleToUserset(
 	// For each found tuple, dispatch a lookup subjects request and collect its results.
 	// We need to intersect between *all* the found subjects for each resource ID.
 	var ttuCaveat *core.CaveatExpression
-	taskrunner := taskrunner.NewPreloadedTaskRunner(cancelCtx, cl.concurrencyLimit, 1)
+	taskrunner := taskrunner.NewPreloadedTaskRunner(cancelCtx, cl.concurrencyLimit.Limit(), 1)
 	for tpl := it.Next(); tpl != nil; tpl = it.Next() {
 		if it.Err() != nil {
 			return it.Err()
@@ -291,6 +335,7 @@ func lookupViaIntersectionTupleToUserset(
 				Metadata: &v1.ResolverMeta{
 					AtRevision:     parentRequest.Revision.String(),
 					DepthRemaining: parentRequest.Metadata.DepthRemaining - 1,
+					TraversalBloom: parentRequest.Metadata.TraversalBloom,
 				},
 			}, collectingStream)
 			if err != nil {
@@ -470,7 +515,7 @@ func (cl *ConcurrentLookupSubjects) lookupSetOperation(
 	defer checkCancel()
 
 	g, subCtx := errgroup.WithContext(cancelCtx)
-	g.SetLimit(int(cl.concurrencyLimit))
+	g.SetLimit(int(cl.concurrencyLimit.Limit()))
 
 	for index, childOneof := range so.Child {
 		stream := reducer.ForIndex(subCtx, index)
@@ -542,7 +587,7 @@ func (cl *ConcurrentLookupSubjects) dispatchTo(
 	defer checkCancel()
 
 	g, subCtx := errgroup.WithContext(cancelCtx)
-	g.SetLimit(int(cl.concurrencyLimit))
+	g.SetLimit(int(cl.concurrencyLimit.Limit()))
 
 	toDispatchByType.ForEachType(func(resourceType *core.RelationReference, foundSubjects datasets.SubjectSet) {
 		slice := foundSubjects.AsSlice()
@@ -631,6 +676,7 @@ func (cl *ConcurrentLookupSubjects) dispatchTo(
 					Metadata: &v1.ResolverMeta{
 						AtRevision:     parentRequest.Revision.String(),
 						DepthRemaining: parentRequest.Metadata.DepthRemaining - 1,
+						TraversalBloom: parentRequest.Metadata.TraversalBloom,
 					},
 				}, stream)
 			})
@@ -0,0 +1,127 @@
+package graph
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+)
+
+// lruCache is a map of bounded size, which evicts the least recently used entry once full. It is
+// not safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	maximumEntries int
+	entries        map[K]*list.Element
+	recency        *list.List
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](maximumEntries int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		maximumEntries: maximumEntries,
+		entries:        make(map[K]*list.Element, maximumEntries),
+		recency:        list.New(),
+	}
+}
+
+// get returns the value for the key, marking it as the most recently used.
+func (lc *lruCache[K, V]) get(key K) (V, bool) {
+	found, ok := lc.entries[key]
+	if !ok {
+		var empty V
+		return empty, false
+	}
+
+	lc.recency.MoveToFront(found)
+	return found.Value.(*lruEntry[K, V]).value, true
+}
+
+// set sets the value for the key, marking it as the most recently used and evicting the least
+// recently used entry if the cache is full.
+func (lc *lruCache[K, V]) set(key K, value V) {
+	if found, ok := lc.entries[key]; ok {
+		found.Value.(*lruEntry[K, V]).value = value
+		lc.recency.MoveToFront(found)
+		return
+	}
+
+	if lc.recency.Len() >= lc.maximumEntries {
+		oldest := lc.recency.Back()
+		lc.recency.Remove(oldest)
+		delete(lc.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+
+	lc.entries[key] = lc.recency.PushFront(&lruEntry[K, V]{key: key, value: value})
+}
+
+func (lc *lruCache[K, V]) len() int {
+	return lc.recency.Len()
+}
+
+// lruShardCount is the number of shards of a shardedLRUCache, each with its own lock, so that
+// concurrent accesses to different keys rarely contend.
+const lruShardCount = 16
+
+// shardedLRUCache is a map of bounded size, safe for concurrent use, which evicts the least
+// recently used entries of each of its shards once full.
+type shardedLRUCache[K comparable, V any] struct {
+	seed    maphash.Seed
+	shardOf func(seed maphash.Seed, key K) uint64
+	shards  [lruShardCount]lruShard[K, V]
+}
+
+type lruShard[K comparable, V any] struct {
+	lock  sync.Mutex
+	cache *lruCache[K, V]
+}
+
+// newShardedLRUCache creates a cache of at most the given number of entries, spread across shards
+// using the given hash of the keys.
+func newShardedLRUCache[K comparable, V any](maximumEntries int, shardOf func(seed maphash.Seed, key K) uint64) *shardedLRUCache[K, V] {
+	slc := &shardedLRUCache[K, V]{
+		seed:    maphash.MakeSeed(),
+		shardOf: shardOf,
+	}
+
+	shardEntries := max(1, maximumEntries/lruShardCount)
+	for i := range slc.shards {
+		slc.shards[i].cache = newLRUCache[K, V](shardEntries)
+	}
+	return slc
+}
+
+func (slc *shardedLRUCache[K, V]) shard(key K) *lruShard[K, V] {
+	return &slc.shards[slc.shardOf(slc.seed, key)%lruShardCount]
+}
+
+// get returns the value for the key, marking it as the most recently used.
+func (slc *shardedLRUCache[K, V]) get(key K) (V, bool) {
+	shard := slc.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	return shard.cache.get(key)
+}
+
+// update sets the value for the key to that returned by the given function, which is called with
+// the existing value, if any, while the shard of the key is locked.
+func (slc *shardedLRUCache[K, V]) update(key K, updater func(existing V, found bool) V) {
+	shard := slc.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	existing, found := shard.cache.get(key)
+	shard.cache.set(key, updater(existing, found))
+}
+
+func (slc *shardedLRUCache[K, V]) len() int {
+	count := 0
+	for i := range slc.shards {
+		slc.shards[i].lock.Lock()
+		count += slc.shards[i].cache.len()
+		slc.shards[i].lock.Unlock()
+	}
+	return count
+}
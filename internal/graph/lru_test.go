@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	lc := newLRUCache[string, int](3)
+	for i := 0; i < 3; i++ {
+		lc.set(strconv.Itoa(i), i)
+	}
+
+	// Using the oldest entry keeps it from being evicted in place of the next oldest.
+	found, ok := lc.get("0")
+	require.True(t, ok)
+	require.Equal(t, 0, found)
+
+	lc.set("3", 3)
+	require.Equal(t, 3, lc.len())
+
+	_, ok = lc.get("1")
+	require.False(t, ok)
+
+	for _, key := range []string{"0", "2", "3"} {
+		_, ok := lc.get(key)
+		require.True(t, ok, key)
+	}
+
+	lc.set("2", 20)
+	found, _ = lc.get("2")
+	require.Equal(t, 20, found)
+	require.Equal(t, 3, lc.len())
+}
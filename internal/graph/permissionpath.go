@@ -0,0 +1,666 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+
+	cexpr "github.com/zapravila/spicedb/internal/caveats"
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/graph/computed"
+	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/internal/namespace"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore/options"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/spiceerrors"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	// maximumPathRelationshipsPerStep is the maximum number of relationships considered at each
+	// step of a path when searching for one.
+	maximumPathRelationshipsPerStep = 1000
+
+	// maximumPathOperations is the maximum number of datastore queries and checks performed when
+	// searching for the paths of a single subject. If exceeded, ErrPermissionPathLimitsReached is returned.
+	maximumPathOperations = 1000
+)
+
+// PathOperation is the operation of a rewrite under which a branch was taken in a PermissionPath.
+type PathOperation string
+
+const (
+	PathOperationUnion        PathOperation = "union"
+	PathOperationIntersection PathOperation = "intersection"
+	PathOperationExclusion    PathOperation = "exclusion"
+)
+
+// PermissionPathStep is a single step of a PermissionPath: either a branch taken in the rewrite of
+// a permission or a relationship followed.
+type PermissionPathStep struct {
+	// Resource is the resource and relation or permission at which the step is taken.
+	Resource *core.ObjectAndRelation
+
+	// Operation is the operation of the rewrite in which the branch was taken, for rewrite steps.
+	Operation PathOperation
+
+	// Branch is the branch taken, as returned by BranchKey, for rewrite steps.
+	Branch string
+
+	// Relationship is the relationship followed, for relationship steps.
+	Relationship *core.RelationTuple
+
+	// Caveat is the caveat on the relationship followed, if any, as evaluated.
+	Caveat *PermissionPathCaveat
+}
+
+// PermissionPathCaveat is a caveat on a relationship of a PermissionPath, as evaluated with the
+// caveat context given.
+type PermissionPathCaveat struct {
+	// Name is the name of the caveat.
+	Name string
+
+	// Expression is the expression of the caveat.
+	Expression string
+
+	// Context holds the context values used when evaluating the expression.
+	Context map[string]any
+
+	// Partial indicates the expression could not be fully evaluated due to missing context, and so
+	// the path only holds conditionally.
+	Partial bool
+}
+
+// PermissionPath is a chain of steps, starting at a resource and ending at a subject, by which the
+// subject was found to have a permission on the resource.
+type PermissionPath []PermissionPathStep
+
+// Relationships returns the relationships followed by the path, in order.
+func (pp PermissionPath) Relationships() []*core.RelationTuple {
+	rels := make([]*core.RelationTuple, 0, len(pp))
+	for _, step := range pp {
+		if step.Relationship != nil {
+			rels = append(rels, step.Relationship)
+		}
+	}
+	return rels
+}
+
+// String returns the human-readable form of the path.
+func (pp PermissionPath) String() string {
+	formatted := ""
+	for index, step := range pp {
+		if index > 0 {
+			formatted += " -> "
+		}
+
+		if step.Relationship != nil {
+			formatted += tuple.MustString(step.Relationship)
+			continue
+		}
+		formatted += tuple.StringONR(step.Resource) + "(" + string(step.Operation) + ": " + step.Branch + ")"
+	}
+	return formatted
+}
+
+// PermissionPathParameters are the parameters for FindPermissionPaths.
+type PermissionPathParameters struct {
+	ResourceType      string
+	ResourceID        string
+	Permission        string
+	Subject           *core.ObjectAndRelation
+	CaveatContext     map[string]any
+	AtRevision        datastore.Revision
+	MaximumDepth      uint32
+	DispatchChunkSize uint16
+
+	// Recorder, if given, holds the results and relationships recorded while checking the permission,
+	// which are used in preference to querying and checking again.
+	Recorder *ProofRecorder
+
+	// Minimal, if true, has every branch searched in order to return the paths with the fewest
+	// relationships. Otherwise, the first paths found are returned.
+	Minimal bool
+}
+
+// ErrPermissionPathLimitsReached is returned by FindPermissionPaths if the maximum number of
+// operations was reached before the paths could be found.
+var ErrPermissionPathLimitsReached = errors.New("maximum permission path operations reached")
+
+// FindPermissionPaths returns the path(s) by which the subject has the permission on the resource.
+// If the permission requires more than one path to hold, such as due to an intersection, each
+// required path is returned. Returns nil if the subject does not have the permission, or no path
+// could be found within the maximum depth.
+//
+// NOTE: this is a separate, best-effort search, run after the permission has been computed. It
+// performs up to maximumPathOperations datastore queries and checks of its own, and returns
+// ErrPermissionPathLimitsReached if more are needed. If a Recorder is given, the results and
+// relationships recorded by the check are used in preference, so that only the sub-problems which
+// were served from the cache or by another node need to be searched again.
+//
+// Wildcard subjects are supported, in which case the paths returned end at a wildcard relationship.
+func FindPermissionPaths(ctx context.Context, d dispatch.Check, params PermissionPathParameters) ([]PermissionPath, error) {
+	finder := &pathFinder{
+		d:          d,
+		params:     params,
+		reader:     datastoremw.MustFromContext(ctx).SnapshotReader(params.AtRevision),
+		found:      map[string][]PermissionPath{},
+		inProgress: map[string]struct{}{},
+	}
+
+	resource := &core.ObjectAndRelation{
+		Namespace: params.ResourceType,
+		ObjectId:  params.ResourceID,
+		Relation:  params.Permission,
+	}
+
+	holds, err := finder.holds(ctx, resource)
+	if err != nil || !holds {
+		return nil, err
+	}
+
+	paths, _, err := finder.find(ctx, resource, params.MaximumDepth)
+	return paths, err
+}
+
+type pathFinder struct {
+	d          dispatch.Check
+	params     PermissionPathParameters
+	reader     datastore.Reader
+	operations int
+
+	// found holds the paths found from each resource, keyed by its string form.
+	found map[string][]PermissionPath
+
+	// inProgress holds the resources being searched, to stop the search from cycling.
+	inProgress map[string]struct{}
+}
+
+func (pf *pathFinder) operation() error {
+	pf.operations++
+	if pf.operations > maximumPathOperations {
+		return ErrPermissionPathLimitsReached
+	}
+	return nil
+}
+
+func (pf *pathFinder) isWildcardSubject() bool {
+	return pf.params.Subject.ObjectId == tuple.PublicWildcard
+}
+
+// isRecordedMember returns whether the resource was recorded as a member during the check.
+func (pf *pathFinder) isRecordedMember(resource *core.ObjectAndRelation) bool {
+	return pf.params.Recorder != nil && pf.params.Recorder.isMember(resource)
+}
+
+// holds returns whether the subject is, perhaps conditionally, found for the given resource.
+func (pf *pathFinder) holds(ctx context.Context, resource *core.ObjectAndRelation) (bool, error) {
+	// NOTE: checks cannot be performed for wildcards, so every branch is searched instead.
+	if pf.isWildcardSubject() || pf.isRecordedMember(resource) {
+		return true, nil
+	}
+
+	if err := pf.operation(); err != nil {
+		return false, err
+	}
+
+	result, _, err := computed.ComputeCheck(ctx, pf.d, computed.CheckParameters{
+		ResourceType:  &core.RelationReference{Namespace: resource.Namespace, Relation: resource.Relation},
+		Subject:       pf.params.Subject,
+		CaveatContext: pf.params.CaveatContext,
+		AtRevision:    pf.params.AtRevision,
+		MaximumDepth:  pf.params.MaximumDepth,
+		DebugOption:   computed.NoDebugging,
+	}, resource.ObjectId, pf.params.DispatchChunkSize)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Membership != v1.ResourceCheckResult_NOT_MEMBER, nil
+}
+
+// relationshipStep returns the step for following the given relationship, if its caveat, if any,
+// is satisfied or partially satisfied with the caveat context.
+func (pf *pathFinder) relationshipStep(ctx context.Context, rel *core.RelationTuple) (PermissionPathStep, bool, error) {
+	step := PermissionPathStep{Resource: rel.ResourceAndRelation, Relationship: rel}
+	if rel.Caveat == nil {
+		return step, true, nil
+	}
+
+	result, err := cexpr.RunCaveatExpression(ctx, cexpr.CaveatAsExpr(rel.Caveat), pf.params.CaveatContext, pf.reader, cexpr.RunCaveatExpressionWithDebugInformation)
+	if err != nil {
+		return step, false, err
+	}
+
+	if !result.Value() && !result.IsPartial() {
+		return step, false, nil
+	}
+
+	expression, contextStruct, err := cexpr.BuildDebugInformation(result)
+	if err != nil {
+		return step, false, err
+	}
+
+	step.Caveat = &PermissionPathCaveat{
+		Name:       rel.Caveat.CaveatName,
+		Expression: expression,
+		Context:    contextStruct.AsMap(),
+		Partial:    result.IsPartial(),
+	}
+	return step, true, nil
+}
+
+func (pf *pathFinder) queryRelationships(ctx context.Context, filter datastore.RelationshipsFilter) ([]*core.RelationTuple, error) {
+	if err := pf.operation(); err != nil {
+		return nil, err
+	}
+
+	limit := uint64(maximumPathRelationshipsPerStep)
+	it, err := pf.reader.QueryRelationships(ctx, filter, options.WithLimit(&limit))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var rels []*core.RelationTuple
+	for rel := it.Next(); rel != nil; rel = it.Next() {
+		rels = append(rels, rel)
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	return rels, nil
+}
+
+// find returns the paths from the given resource to the subject, if any.
+func (pf *pathFinder) find(ctx context.Context, resource *core.ObjectAndRelation, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	if tuple.OnrEqual(resource, pf.params.Subject) {
+		return []PermissionPath{nil}, true, nil
+	}
+
+	if depthRemaining == 0 {
+		return nil, false, nil
+	}
+
+	key := tuple.StringONR(resource)
+	if paths, ok := pf.found[key]; ok {
+		return paths, true, nil
+	}
+
+	if _, ok := pf.inProgress[key]; ok {
+		return nil, false, nil
+	}
+	pf.inProgress[key] = struct{}{}
+	defer delete(pf.inProgress, key)
+
+	_, relation, err := namespace.ReadNamespaceAndRelation(ctx, resource.Namespace, resource.Relation, pf.reader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var paths []PermissionPath
+	var found bool
+	if relation.UsersetRewrite == nil {
+		paths, found, err = pf.findDirect(ctx, resource, depthRemaining)
+	} else {
+		paths, found, err = pf.findRewrite(ctx, resource, relation.UsersetRewrite, depthRemaining)
+	}
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	pf.found[key] = paths
+	return paths, true, nil
+}
+
+func (pf *pathFinder) findDirect(ctx context.Context, resource *core.ObjectAndRelation, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	// Start with the relationships walked by the check, if recorded.
+	if pf.params.Recorder != nil {
+		var recorded []*core.RelationTuple
+		for _, rel := range pf.params.Recorder.relationshipsFor(resource) {
+			if pf.isTerminal(rel) || pf.isRecordedMember(rel.Subject) {
+				recorded = append(recorded, rel)
+			}
+		}
+
+		paths, found, err := pf.findVia(ctx, recorded, depthRemaining)
+		if err != nil || found {
+			return paths, found, err
+		}
+	}
+
+	// Otherwise, look for the subject itself or a wildcard of its type.
+	subject := pf.params.Subject
+	subjectsSelectors := []datastore.SubjectsSelector{
+		{
+			OptionalSubjectType: subject.Namespace,
+			OptionalSubjectIds:  []string{subject.ObjectId},
+			RelationFilter:      datastore.SubjectRelationFilter{}.WithRelation(subject.Relation),
+		},
+	}
+	if subject.Relation == tuple.Ellipsis && !pf.isWildcardSubject() {
+		subjectsSelectors = append(subjectsSelectors, datastore.SubjectsSelector{
+			OptionalSubjectType: subject.Namespace,
+			OptionalSubjectIds:  []string{tuple.PublicWildcard},
+			RelationFilter:      datastore.SubjectRelationFilter{}.WithEllipsisRelation(),
+		})
+	}
+
+	direct, err := pf.queryRelationships(ctx, datastore.RelationshipsFilter{
+		OptionalResourceType:      resource.Namespace,
+		OptionalResourceIds:       []string{resource.ObjectId},
+		OptionalResourceRelation:  resource.Relation,
+		OptionalSubjectsSelectors: subjectsSelectors,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	paths, found, err := pf.findVia(ctx, direct, depthRemaining)
+	if err != nil || found {
+		return paths, found, err
+	}
+
+	// Otherwise, walk through any subject sets.
+	subjectSets, err := pf.queryRelationships(ctx, datastore.RelationshipsFilter{
+		OptionalResourceType:     resource.Namespace,
+		OptionalResourceIds:      []string{resource.ObjectId},
+		OptionalResourceRelation: resource.Relation,
+		OptionalSubjectsSelectors: []datastore.SubjectsSelector{
+			{RelationFilter: datastore.SubjectRelationFilter{}.WithOnlyNonEllipsisRelations()},
+		},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return pf.findVia(ctx, subjectSets, depthRemaining)
+}
+
+// isTerminal returns whether the relationship ends at the subject itself or a wildcard of its type.
+func (pf *pathFinder) isTerminal(rel *core.RelationTuple) bool {
+	return tuple.OnrEqual(rel.Subject, pf.params.Subject) ||
+		(pf.params.Subject.Relation == tuple.Ellipsis && tuple.OnrEqualOrWildcard(rel.Subject, pf.params.Subject))
+}
+
+// findVia returns the paths to the subject via any of the given relationships of a relation,
+// preferring relationships to the subject itself, then those to a wildcard, then subject sets.
+func (pf *pathFinder) findVia(ctx context.Context, rels []*core.RelationTuple, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	rank := func(rel *core.RelationTuple) int {
+		switch {
+		case tuple.OnrEqual(rel.Subject, pf.params.Subject):
+			return 0
+		case pf.isTerminal(rel):
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	sorted := slices.Clone(rels)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+
+	var best []PermissionPath
+	found := false
+	for _, rel := range sorted {
+		if pf.isTerminal(rel) {
+			step, holds, err := pf.relationshipStep(ctx, rel)
+			if err != nil {
+				return nil, false, err
+			}
+
+			// NOTE: a single relationship is always the shortest path.
+			if holds {
+				return []PermissionPath{{step}}, true, nil
+			}
+			continue
+		}
+
+		if rel.Subject.Relation == tuple.Ellipsis {
+			continue
+		}
+
+		paths, ok, err := pf.findThrough(ctx, rel, rel.Subject, depthRemaining)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if ok {
+			if !pf.params.Minimal {
+				return paths, true, nil
+			}
+
+			if !found || pathsLength(paths) < pathsLength(best) {
+				best = paths
+				found = true
+			}
+		}
+	}
+
+	return best, found, nil
+}
+
+// findThrough returns the paths to the subject via the given relationship, which leads to the target.
+func (pf *pathFinder) findThrough(ctx context.Context, rel *core.RelationTuple, target *core.ObjectAndRelation, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	step, holds, err := pf.relationshipStep(ctx, rel)
+	if err != nil || !holds {
+		return nil, false, err
+	}
+
+	holds, err = pf.holds(ctx, target)
+	if err != nil || !holds {
+		return nil, false, err
+	}
+
+	paths, found, err := pf.find(ctx, target, depthRemaining-1)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	return prefixPaths(step, paths), true, nil
+}
+
+func (pf *pathFinder) findRewrite(ctx context.Context, resource *core.ObjectAndRelation, rewrite *core.UsersetRewrite, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	switch rw := rewrite.RewriteOperation.(type) {
+	case *core.UsersetRewrite_Union:
+		var best []PermissionPath
+		found := false
+		for _, child := range rw.Union.Child {
+			paths, ok, err := pf.findChild(ctx, resource, PathOperationUnion, child, depthRemaining)
+			if err != nil {
+				return nil, false, err
+			}
+
+			if ok {
+				if !pf.params.Minimal {
+					return paths, true, nil
+				}
+
+				if !found || pathsLength(paths) < pathsLength(best) {
+					best = paths
+					found = true
+				}
+			}
+		}
+		return best, found, nil
+
+	case *core.UsersetRewrite_Intersection:
+		var all []PermissionPath
+		for _, child := range rw.Intersection.Child {
+			paths, found, err := pf.findChild(ctx, resource, PathOperationIntersection, child, depthRemaining)
+			if err != nil || !found {
+				return nil, false, err
+			}
+			all = append(all, paths...)
+		}
+		return all, true, nil
+
+	case *core.UsersetRewrite_Exclusion:
+		// NOTE: the subject is known to not be found in the excluded children, as the resource was
+		// checked before being walked, so only the base child leads to the subject.
+		return pf.findChild(ctx, resource, PathOperationExclusion, rw.Exclusion.Child[0], depthRemaining)
+
+	default:
+		return nil, false, spiceerrors.MustBugf("unknown userset rewrite operator")
+	}
+}
+
+func (pf *pathFinder) findChild(ctx context.Context, resource *core.ObjectAndRelation, operation PathOperation, child *core.SetOperation_Child, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	var paths []PermissionPath
+	var found bool
+	var err error
+
+	switch c := child.ChildType.(type) {
+	case *core.SetOperation_Child_ComputedUserset:
+		target := &core.ObjectAndRelation{
+			Namespace: resource.Namespace,
+			ObjectId:  resource.ObjectId,
+			Relation:  c.ComputedUserset.Relation,
+		}
+
+		holds, err := pf.holds(ctx, target)
+		if err != nil || !holds {
+			return nil, false, err
+		}
+		paths, found, err = pf.find(ctx, target, depthRemaining-1)
+
+	case *core.SetOperation_Child_UsersetRewrite:
+		// NOTE: nested rewrites have no branch of their own, as their children are steps themselves.
+		return pf.findRewrite(ctx, resource, c.UsersetRewrite, depthRemaining)
+
+	case *core.SetOperation_Child_TupleToUserset:
+		paths, found, err = pf.findArrow(ctx, resource, c.TupleToUserset.Tupleset.Relation, c.TupleToUserset.ComputedUserset.Relation, false, depthRemaining)
+
+	case *core.SetOperation_Child_FunctionedTupleToUserset:
+		requireAll := c.FunctionedTupleToUserset.Function == core.FunctionedTupleToUserset_FUNCTION_ALL
+		paths, found, err = pf.findArrow(ctx, resource, c.FunctionedTupleToUserset.Tupleset.Relation, c.FunctionedTupleToUserset.ComputedUserset.Relation, requireAll, depthRemaining)
+
+	case *core.SetOperation_Child_XNil:
+		return nil, false, nil
+
+	default:
+		return nil, false, spiceerrors.MustBugf("unknown set operation child `%T` in path search", c)
+	}
+
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	return prefixPaths(PermissionPathStep{
+		Resource:  resource,
+		Operation: operation,
+		Branch:    BranchKey(child),
+	}, paths), true, nil
+}
+
+func (pf *pathFinder) findArrow(ctx context.Context, resource *core.ObjectAndRelation, tuplesetRelation string, computedUsersetRelation string, requireAll bool, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	tupleset := &core.ObjectAndRelation{
+		Namespace: resource.Namespace,
+		ObjectId:  resource.ObjectId,
+		Relation:  tuplesetRelation,
+	}
+	targetOf := func(rel *core.RelationTuple) *core.ObjectAndRelation {
+		return &core.ObjectAndRelation{
+			Namespace: rel.Subject.Namespace,
+			ObjectId:  rel.Subject.ObjectId,
+			Relation:  computedUsersetRelation,
+		}
+	}
+
+	// Start with the relationships walked by the check, if recorded. As every relationship must be
+	// walked for an intersection arrow, they are always read in that case.
+	if pf.params.Recorder != nil && !requireAll {
+		var recorded []*core.RelationTuple
+		for _, rel := range pf.params.Recorder.relationshipsFor(tupleset) {
+			if pf.isRecordedMember(targetOf(rel)) {
+				recorded = append(recorded, rel)
+			}
+		}
+
+		paths, found, err := pf.findArrowVia(ctx, recorded, targetOf, false, depthRemaining)
+		if err != nil || found {
+			return paths, found, err
+		}
+	}
+
+	rels, err := pf.queryRelationships(ctx, datastore.RelationshipsFilter{
+		OptionalResourceType:     tupleset.Namespace,
+		OptionalResourceIds:      []string{tupleset.ObjectId},
+		OptionalResourceRelation: tupleset.Relation,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return pf.findArrowVia(ctx, rels, targetOf, requireAll, depthRemaining)
+}
+
+func (pf *pathFinder) findArrowVia(ctx context.Context, rels []*core.RelationTuple, targetOf func(*core.RelationTuple) *core.ObjectAndRelation, requireAll bool, depthRemaining uint32) ([]PermissionPath, bool, error) {
+	if len(rels) == 0 {
+		return nil, false, nil
+	}
+
+	var all []PermissionPath
+	var best []PermissionPath
+	foundAny := false
+	for _, rel := range rels {
+		target := targetOf(rel)
+
+		var paths []PermissionPath
+		found := false
+		if err := namespace.CheckNamespaceAndRelation(ctx, target.Namespace, target.Relation, false, pf.reader); err != nil {
+			if !errors.As(err, &namespace.ErrRelationNotFound{}) {
+				return nil, false, err
+			}
+		} else {
+			paths, found, err = pf.findThrough(ctx, rel, target, depthRemaining)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+
+		switch {
+		case !found && requireAll:
+			return nil, false, nil
+		case found && requireAll:
+			all = append(all, paths...)
+		case found && !pf.params.Minimal:
+			return paths, true, nil
+		case found && (!foundAny || pathsLength(paths) < pathsLength(best)):
+			best = paths
+			foundAny = true
+		}
+	}
+
+	if requireAll {
+		return all, true, nil
+	}
+	return best, foundAny, nil
+}
+
+// prefixPaths returns the paths, each prefixed with the given step.
+func prefixPaths(step PermissionPathStep, paths []PermissionPath) []PermissionPath {
+	prefixed := make([]PermissionPath, 0, len(paths))
+	for _, path := range paths {
+		prefixed = append(prefixed, append(PermissionPath{step}, path...))
+	}
+	return prefixed
+}
+
+// pathsLength returns the total number of relationships in the paths.
+func pathsLength(paths []PermissionPath) int {
+	length := 0
+	for _, path := range paths {
+		for _, step := range path {
+			if step.Relationship != nil {
+				length++
+			}
+		}
+	}
+	return length
+}
@@ -0,0 +1,104 @@
+package graph
+
+import (
+	"context"
+	"sync"
+
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	v1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+// DefaultMaximumRecordedProofRelationships is the default maximum number of relationships retained
+// by a ProofRecorder.
+const DefaultMaximumRecordedProofRelationships = 10_000
+
+type proofRecorderKey struct{}
+
+// ContextWithProofRecorder returns a new context which records the results and relationships
+// encountered by the check(s) run under it into the given recorder, for use in building the
+// proof of a check via FindPermissionPaths.
+//
+// Only work performed within this process is recorded: results of dispatches served remotely or
+// from the cache are recorded, but not the relationships which led to them.
+func ContextWithProofRecorder(ctx context.Context, recorder *ProofRecorder) context.Context {
+	return context.WithValue(ctx, proofRecorderKey{}, recorder)
+}
+
+func proofRecorderFromContext(ctx context.Context) *ProofRecorder {
+	recorder, _ := ctx.Value(proofRecorderKey{}).(*ProofRecorder)
+	return recorder
+}
+
+// ProofRecorder records the resources found to be (perhaps conditionally) members for the subject of
+// a check, and the relationships walked to find them. It is safe for concurrent use.
+type ProofRecorder struct {
+	maximumRelationships int
+
+	lock             sync.Mutex
+	members          map[string]struct{}
+	relationships    map[string][]*core.RelationTuple
+	relationshipKeys map[string]struct{}
+}
+
+// NewProofRecorder creates a new ProofRecorder, which retains up to the given number of relationships.
+func NewProofRecorder(maximumRelationships int) *ProofRecorder {
+	return &ProofRecorder{
+		maximumRelationships: maximumRelationships,
+		members:              map[string]struct{}{},
+		relationships:        map[string][]*core.RelationTuple{},
+		relationshipKeys:     map[string]struct{}{},
+	}
+}
+
+// isMember returns whether the resource was recorded as a member, perhaps conditionally.
+func (pr *ProofRecorder) isMember(resource *core.ObjectAndRelation) bool {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	_, ok := pr.members[tuple.StringONR(resource)]
+	return ok
+}
+
+// relationshipsFor returns the relationships recorded with the given resource and relation.
+func (pr *ProofRecorder) relationshipsFor(resource *core.ObjectAndRelation) []*core.RelationTuple {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	return pr.relationships[tuple.StringONR(resource)]
+}
+
+func (pr *ProofRecorder) recordResults(req ValidatedCheckRequest, resp *v1.DispatchCheckResponse) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	for resourceID, result := range resp.ResultsByResourceId {
+		if result.Membership == v1.ResourceCheckResult_NOT_MEMBER {
+			continue
+		}
+
+		pr.members[tuple.StringONRStrings(req.ResourceRelation.Namespace, resourceID, req.ResourceRelation.Relation)] = struct{}{}
+		if req.OriginalRelationName != "" {
+			pr.members[tuple.StringONRStrings(req.ResourceRelation.Namespace, resourceID, req.OriginalRelationName)] = struct{}{}
+		}
+	}
+}
+
+func (pr *ProofRecorder) recordRelationship(rel *core.RelationTuple) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	if len(pr.relationshipKeys) >= pr.maximumRelationships {
+		return
+	}
+
+	// NOTE: the same relationship can be walked by more than one dispatch.
+	relationshipKey := tuple.StringWithoutCaveat(rel)
+	if _, ok := pr.relationshipKeys[relationshipKey]; ok {
+		return
+	}
+	pr.relationshipKeys[relationshipKey] = struct{}{}
+
+	key := tuple.StringONR(rel.ResourceAndRelation)
+	pr.relationships[key] = append(pr.relationships[key], rel)
+}
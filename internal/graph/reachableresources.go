@@ -23,7 +23,7 @@ import (
 const dispatchVersion = 1
 
 // NewCursoredReachableResources creates an instance of CursoredReachableResources.
-func NewCursoredReachableResources(d dispatch.ReachableResources, concurrencyLimit uint16, dispatchChunkSize uint16) *CursoredReachableResources {
+func NewCursoredReachableResources(d dispatch.ReachableResources, concurrencyLimit ConcurrencyLimiter, dispatchChunkSize uint16) *CursoredReachableResources {
 	return &CursoredReachableResources{d, concurrencyLimit, dispatchChunkSize}
 }
 
@@ -31,7 +31,7 @@ func NewCursoredReachableResources(d dispatch.ReachableResources, concurrencyLim
 // delegates subproblems to the provided dispatch.ReachableResources instance.
 type CursoredReachableResources struct {
 	d                 dispatch.ReachableResources
-	concurrencyLimit  uint16
+	concurrencyLimit  ConcurrencyLimiter
 	dispatchChunkSize uint16
 }
 
@@ -122,7 +122,7 @@ func (crr *CursoredReachableResources) afterSameType(
 	}
 
 	// For each entrypoint, load the necessary data and re-dispatch if a subproblem was found.
-	return withParallelizedStreamingIterableInCursor(ctx, ci, entrypoints, parentStream, crr.concurrencyLimit,
+	return withParallelizedStreamingIterableInCursor(ctx, ci, entrypoints, parentStream, crr.concurrencyLimit.Limit(),
 		func(ctx context.Context, ci cursorInformation, entrypoint typesystem.ReachabilityEntrypoint, stream dispatch.ReachableResourcesStream) error {
 			switch entrypoint.EntrypointKind() {
 			case core.ReachabilityEntrypoint_RELATION_ENTRYPOINT:
@@ -232,7 +232,7 @@ func (crr *CursoredReachableResources) lookupRelationEntrypoint(
 			foundResourceType:  relationReference,
 			entrypoint:         entrypoint,
 			rg:                 rg,
-			concurrencyLimit:   crr.concurrencyLimit,
+			concurrencyLimit:   crr.concurrencyLimit.Limit(),
 			parentStream:       stream,
 			parentRequest:      req,
 			dispatched:         dispatched,
@@ -556,6 +556,7 @@ func (crr *CursoredReachableResources) redispatchOrReport(
 				Metadata: &v1.ResolverMeta{
 					AtRevision:     parentRequest.Revision.String(),
 					DepthRemaining: parentRequest.Metadata.DepthRemaining - 1,
+					TraversalBloom: parentRequest.Metadata.TraversalBloom,
 				},
 				OptionalCursor: ci.currentCursor,
 				OptionalLimit:  ci.limits.currentLimit,
@@ -0,0 +1,20 @@
+package grpchelpers
+
+import "strings"
+
+// LookupByMethod returns the value configured for the full gRPC method name (e.g.
+// `/authzed.api.v1.PermissionsService/CheckPermission`), looking it up by the full method name,
+// then by its service name (e.g. `authzed.api.v1.PermissionsService`) and then by its method name
+// (e.g. `CheckPermission`).
+func LookupByMethod[V any](values map[string]V, fullMethod string) (V, bool) {
+	if value, ok := values[fullMethod]; ok {
+		return value, true
+	}
+
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if value, ok := values[service]; ok {
+		return value, true
+	}
+	value, ok := values[method]
+	return value, ok
+}
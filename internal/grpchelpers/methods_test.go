@@ -0,0 +1,36 @@
+package grpchelpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupByMethod(t *testing.T) {
+	values := map[string]int{
+		"/authzed.api.v1.PermissionsService/CheckPermission": 1,
+		"authzed.api.v1.PermissionsService":                  2,
+		"LookupResources":                                    3,
+		"CheckPermission":                                    4,
+	}
+
+	tcs := []struct {
+		fullMethod string
+		expected   int
+		found      bool
+	}{
+		{"/authzed.api.v1.PermissionsService/CheckPermission", 1, true},
+		{"/authzed.api.v1.PermissionsService/WriteRelationships", 2, true},
+		{"/authzed.api.v1.PermissionsService/LookupResources", 2, true},
+		{"/authzed.api.v1.ExperimentalService/LookupResources", 3, true},
+		{"/authzed.api.v1.ExperimentalService/CheckPermission", 4, true},
+		{"/authzed.api.v1.SchemaService/ReadSchema", 0, false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.fullMethod, func(t *testing.T) {
+			value, ok := LookupByMethod(values, tc.fullMethod)
+			require.Equal(t, tc.found, ok)
+			require.Equal(t, tc.expected, value)
+		})
+	}
+}
@@ -0,0 +1,471 @@
+// Package admission implements an admission controller for API requests, which assigns each request
+// a priority by its gRPC method and, while the resources shared by requests are saturated, queues
+// and then sheds lower priority requests so that higher priority requests are not starved.
+package admission
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/zapravila/spicedb/internal/grpchelpers"
+	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/pkg/datastore"
+)
+
+const (
+	// DefaultLowPriorityThreshold is the default saturation at or above which low priority requests
+	// are queued.
+	DefaultLowPriorityThreshold = 0.75
+
+	// DefaultNormalPriorityThreshold is the default saturation at or above which normal priority
+	// requests are queued.
+	DefaultNormalPriorityThreshold = 0.95
+
+	// DefaultMaxQueueWait is the default maximum duration a request is queued before it is shed.
+	DefaultMaxQueueWait = time.Second
+
+	// DefaultMaxQueueLength is the default maximum number of queued requests of each priority,
+	// beyond which requests are shed without being queued.
+	DefaultMaxQueueLength = 1000
+
+	// DefaultRetryAfter is the default duration after which shed requests are told to retry.
+	DefaultRetryAfter = time.Second
+
+	// RetryAfterHeader is the response header carrying the number of seconds after which a shed
+	// request may be retried.
+	RetryAfterHeader = "retry-after"
+
+	// queuePollInterval is the interval at which, while requests are queued, the saturation is
+	// checked to admit the oldest queued request, in case it drops without a request completing.
+	queuePollInterval = 10 * time.Millisecond
+)
+
+// ErrNoSignals is returned when creating a controller without any saturation signal, as no
+// request would ever be queued or shed.
+var ErrNoSignals = errors.New("no saturation signal is available")
+
+var (
+	admissionCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "admission",
+		Name:      "requests_total",
+		Help:      "number of API requests handled by the admission controller, by priority and result",
+	}, []string{"priority", "result"})
+
+	queuedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "spicedb",
+		Subsystem: "admission",
+		Name:      "queued_requests",
+		Help:      "number of API requests currently queued by the admission controller, by priority",
+	}, []string{"priority"})
+
+	saturationGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "spicedb",
+		Subsystem: "admission",
+		Name:      "saturation",
+		Help:      "last observed saturation of the resources shared by API requests, by signal",
+	}, []string{"signal"})
+)
+
+func init() {
+	prometheus.MustRegister(admissionCounter, queuedGauge, saturationGauge)
+}
+
+// Priority is the priority class of an API request.
+type Priority int
+
+const (
+	// PriorityLow is for expensive requests, such as lookups and bulk exports, which are queued
+	// and shed first.
+	PriorityLow Priority = iota
+
+	// PriorityNormal is the priority of requests not otherwise classified.
+	PriorityNormal
+
+	// PriorityCritical is for requests which are never queued or shed.
+	PriorityCritical
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:      "low",
+	PriorityNormal:   "normal",
+	PriorityCritical: "critical",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+// ParsePriority parses the name of a priority class.
+func ParsePriority(name string) (Priority, error) {
+	for priority, priorityName := range priorityNames {
+		if strings.EqualFold(name, priorityName) {
+			return priority, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority class `%s`: must be one of low, normal or critical", name)
+}
+
+// DefaultMethodPriorities are the priorities of the API methods, by method name, unless configured
+// otherwise.
+var DefaultMethodPriorities = map[string]Priority{
+	"CheckPermission":       PriorityCritical,
+	"CheckBulkPermissions":  PriorityCritical,
+	"BulkCheckPermission":   PriorityCritical,
+	"grpc.health.v1.Health": PriorityCritical,
+
+	"LookupResources":         PriorityLow,
+	"LookupSubjects":          PriorityLow,
+	"ExportBulkRelationships": PriorityLow,
+	"BulkExportRelationships": PriorityLow,
+	"ImportBulkRelationships": PriorityLow,
+	"BulkImportRelationships": PriorityLow,
+}
+
+// Config configures the admission controller.
+type Config struct {
+	// Enabled enables the admission controller.
+	Enabled bool
+
+	// MethodPriorities overrides the priority class of methods, by full method name (e.g.
+	// `/authzed.api.v1.PermissionsService/CheckPermission`), service name (e.g.
+	// `authzed.api.v1.PermissionsService`) or method name (e.g. `CheckPermission`).
+	MethodPriorities map[string]string
+
+	// LowPriorityThreshold is the saturation at or above which low priority requests are queued.
+	LowPriorityThreshold float64
+
+	// NormalPriorityThreshold is the saturation at or above which normal priority requests are
+	// queued.
+	NormalPriorityThreshold float64
+
+	// MaxQueueWait is the maximum duration a request is queued before it is shed.
+	MaxQueueWait time.Duration
+
+	// MaxQueueLength is the maximum number of queued requests of each priority.
+	MaxQueueLength uint32
+
+	// RetryAfter is the duration after which shed requests are told to retry.
+	RetryAfter time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.LowPriorityThreshold <= 0 {
+		c.LowPriorityThreshold = DefaultLowPriorityThreshold
+	}
+	if c.NormalPriorityThreshold <= 0 {
+		c.NormalPriorityThreshold = DefaultNormalPriorityThreshold
+	}
+	if c.MaxQueueWait <= 0 {
+		c.MaxQueueWait = DefaultMaxQueueWait
+	}
+	if c.MaxQueueLength == 0 {
+		c.MaxQueueLength = DefaultMaxQueueLength
+	}
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = DefaultRetryAfter
+	}
+	return c
+}
+
+// Signal reports the saturation of a resource shared by API requests, from 0 when it is idle to
+// 1 when it is saturated.
+type Signal interface {
+	Saturation() float64
+}
+
+// SignalFunc adapts a function to a Signal.
+type SignalFunc func() float64
+
+func (f SignalFunc) Saturation() float64 { return f() }
+
+// DatastorePoolSignal returns a Signal reporting the utilization of the most utilized connection
+// pool of the datastore, or nil if the datastore does not report the stats of its pools.
+func DatastorePoolSignal(ds datastore.Datastore) Signal {
+	reporter := datastore.UnwrapAs[datastore.ConnPoolStatsReporter](ds)
+	if reporter == nil {
+		return nil
+	}
+
+	return SignalFunc(func() float64 {
+		saturation := 0.0
+		for _, stats := range reporter.ConnPoolStats() {
+			if stats.MaxConns > 0 {
+				saturation = math.Max(saturation, float64(stats.AcquiredConns)/float64(stats.MaxConns))
+			}
+		}
+		return saturation
+	})
+}
+
+// Controller admits, queues or sheds API requests by their priority and the saturation of the
+// resources they share.
+//
+// Requests of each priority are queued in FIFO order. Whenever an admitted request completes, and
+// periodically while requests are queued, the oldest queued request of the highest priority is
+// admitted if the saturation is below its threshold; queued requests are therefore admitted one at
+// a time rather than all at once when the saturation drops.
+type Controller struct {
+	config           Config
+	methodPriorities map[string]Priority
+	signals          map[string]Signal
+
+	lock    sync.Mutex
+	queues  map[Priority]*list.List
+	polling bool
+}
+
+// queuedRequest is a request waiting in the queue of its priority.
+type queuedRequest struct {
+	admitted chan struct{}
+}
+
+// NewController creates an admission controller queueing requests based on the saturation of the
+// given signals, by name. Nil signals are ignored; if no signal remains, ErrNoSignals is returned.
+func NewController(config Config, signals map[string]Signal) (*Controller, error) {
+	methodPriorities := make(map[string]Priority, len(DefaultMethodPriorities)+len(config.MethodPriorities))
+	for method, priority := range DefaultMethodPriorities {
+		methodPriorities[method] = priority
+	}
+	for method, name := range config.MethodPriorities {
+		priority, err := ParsePriority(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority for method `%s`: %w", method, err)
+		}
+		methodPriorities[method] = priority
+	}
+
+	nonNilSignals := make(map[string]Signal, len(signals))
+	for name, signal := range signals {
+		if signal != nil {
+			nonNilSignals[name] = signal
+		}
+	}
+	if len(nonNilSignals) == 0 {
+		return nil, ErrNoSignals
+	}
+
+	return &Controller{
+		config:           config.withDefaults(),
+		methodPriorities: methodPriorities,
+		signals:          nonNilSignals,
+		queues: map[Priority]*list.List{
+			PriorityLow:    list.New(),
+			PriorityNormal: list.New(),
+		},
+	}, nil
+}
+
+// Priority returns the priority class of the given full gRPC method name.
+func (c *Controller) Priority(fullMethod string) Priority {
+	if priority, ok := grpchelpers.LookupByMethod(c.methodPriorities, fullMethod); ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// saturation returns the saturation of the most saturated signal.
+func (c *Controller) saturation() float64 {
+	saturation := 0.0
+	for name, signal := range c.signals {
+		signalSaturation := signal.Saturation()
+		saturationGauge.WithLabelValues(name).Set(signalSaturation)
+		saturation = math.Max(saturation, signalSaturation)
+	}
+	return saturation
+}
+
+func (c *Controller) threshold(priority Priority) float64 {
+	switch priority {
+	case PriorityLow:
+		return c.config.LowPriorityThreshold
+	case PriorityNormal:
+		return c.config.NormalPriorityThreshold
+	default:
+		return math.Inf(1)
+	}
+}
+
+// admit returns nil once a request of the given method may proceed, or a RESOURCE_EXHAUSTED error
+// if it was shed. Requests are only admitted immediately if no request of the same priority is
+// queued ahead of them.
+func (c *Controller) admit(ctx context.Context, fullMethod string) error {
+	priority := c.Priority(fullMethod)
+	threshold := c.threshold(priority)
+	if math.IsInf(threshold, 1) {
+		admissionCounter.WithLabelValues(priority.String(), "admitted").Inc()
+		return nil
+	}
+
+	c.lock.Lock()
+	queue := c.queues[priority]
+	if queue.Len() == 0 && c.saturation() < threshold {
+		c.lock.Unlock()
+		admissionCounter.WithLabelValues(priority.String(), "admitted").Inc()
+		return nil
+	}
+
+	if queue.Len() >= int(c.config.MaxQueueLength) {
+		c.lock.Unlock()
+		return c.shed(ctx, fullMethod, priority, "queue full")
+	}
+
+	request := &queuedRequest{admitted: make(chan struct{})}
+	element := queue.PushBack(request)
+	queuedGauge.WithLabelValues(priority.String()).Inc()
+	if !c.polling {
+		c.polling = true
+		go c.poll()
+	}
+	c.lock.Unlock()
+
+	timeout := time.NewTimer(c.config.MaxQueueWait)
+	defer timeout.Stop()
+
+	select {
+	case <-request.admitted:
+		admissionCounter.WithLabelValues(priority.String(), "queued").Inc()
+		return nil
+
+	case <-ctx.Done():
+		if c.dequeue(priority, element) {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+
+	case <-timeout.C:
+		if c.dequeue(priority, element) {
+			return c.shed(ctx, fullMethod, priority, "queue timeout")
+		}
+	}
+
+	// The request was admitted while giving up on it, so it proceeds.
+	admissionCounter.WithLabelValues(priority.String(), "queued").Inc()
+	return nil
+}
+
+// dequeue removes the queued request from the queue of its priority, returning false if it was
+// already admitted.
+func (c *Controller) dequeue(priority Priority, element *list.Element) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	request := element.Value.(*queuedRequest)
+	select {
+	case <-request.admitted:
+		return false
+	default:
+	}
+
+	c.queues[priority].Remove(element)
+	queuedGauge.WithLabelValues(priority.String()).Dec()
+	return true
+}
+
+// release is called when an admitted request completes, admitting the oldest queued request if
+// the saturation allows.
+func (c *Controller) release() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.admitNextLocked()
+}
+
+// admitNextLocked admits the oldest queued request of the highest priority whose threshold is
+// above the saturation. The lock must be held.
+func (c *Controller) admitNextLocked() {
+	if c.queues[PriorityNormal].Len() == 0 && c.queues[PriorityLow].Len() == 0 {
+		return
+	}
+
+	saturation := c.saturation()
+	for _, priority := range []Priority{PriorityNormal, PriorityLow} {
+		queue := c.queues[priority]
+		if queue.Len() == 0 || saturation >= c.threshold(priority) {
+			continue
+		}
+
+		request := queue.Remove(queue.Front()).(*queuedRequest)
+		queuedGauge.WithLabelValues(priority.String()).Dec()
+		close(request.admitted)
+		return
+	}
+}
+
+// poll periodically admits queued requests, until no request is queued.
+func (c *Controller) poll() {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.lock.Lock()
+		c.admitNextLocked()
+		if c.queues[PriorityNormal].Len() == 0 && c.queues[PriorityLow].Len() == 0 {
+			c.polling = false
+			c.lock.Unlock()
+			return
+		}
+		c.lock.Unlock()
+	}
+}
+
+func (c *Controller) shed(ctx context.Context, fullMethod string, priority Priority, reason string) error {
+	admissionCounter.WithLabelValues(priority.String(), "shed").Inc()
+	log.Ctx(ctx).Debug().Str("method", fullMethod).Stringer("priority", priority).Str("reason", reason).Msg("shed request due to saturation")
+
+	retryAfterSeconds := int64(math.Ceil(c.config.RetryAfter.Seconds()))
+	if err := grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, strconv.FormatInt(retryAfterSeconds, 10))); err != nil {
+		log.Ctx(ctx).Debug().Err(err).Msg("unable to set retry-after header")
+	}
+
+	st, err := status.New(codes.ResourceExhausted, fmt.Sprintf("server is overloaded; %s priority request was shed, retry after %s", priority, c.config.RetryAfter)).
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(c.config.RetryAfter)})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "server is overloaded")
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor which admits requests through the
+// given controller. A nil controller admits every request.
+func UnaryServerInterceptor(controller *Controller) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if controller != nil {
+			if err := controller.admit(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			defer controller.release()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new stream server interceptor which admits requests through the
+// given controller. A nil controller admits every request.
+func StreamServerInterceptor(controller *Controller) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if controller != nil {
+			if err := controller.admit(stream.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			defer controller.release()
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,207 @@
+package admission
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	checkMethod  = "/authzed.api.v1.PermissionsService/CheckPermission"
+	lookupMethod = "/authzed.api.v1.PermissionsService/LookupResources"
+	writeMethod  = "/authzed.api.v1.PermissionsService/WriteRelationships"
+)
+
+type fixedSignal struct {
+	saturation atomic.Value
+}
+
+func newFixedSignal(saturation float64) *fixedSignal {
+	s := &fixedSignal{}
+	s.set(saturation)
+	return s
+}
+
+func (s *fixedSignal) set(saturation float64) { s.saturation.Store(saturation) }
+
+func (s *fixedSignal) Saturation() float64 { return s.saturation.Load().(float64) }
+
+func TestPriority(t *testing.T) {
+	controller, err := NewController(Config{
+		MethodPriorities: map[string]string{
+			"WriteRelationships":                                "low",
+			"authzed.api.v1.ExperimentalService":                "Critical",
+			"/authzed.api.v1.PermissionsService/LookupSubjects": "normal",
+		},
+	}, map[string]Signal{"test": newFixedSignal(0)})
+	require.NoError(t, err)
+
+	tcs := []struct {
+		method   string
+		expected Priority
+	}{
+		{checkMethod, PriorityCritical},
+		{"/grpc.health.v1.Health/Check", PriorityCritical},
+		{lookupMethod, PriorityLow},
+		{"/authzed.api.v1.PermissionsService/LookupSubjects", PriorityNormal},
+		{writeMethod, PriorityLow},
+		{"/authzed.api.v1.ExperimentalService/BulkExportRelationships", PriorityCritical},
+		{"/authzed.api.v1.SchemaService/ReadSchema", PriorityNormal},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.method, func(t *testing.T) {
+			require.Equal(t, tc.expected, controller.Priority(tc.method))
+		})
+	}
+}
+
+func TestInvalidPriority(t *testing.T) {
+	_, err := NewController(Config{MethodPriorities: map[string]string{"CheckPermission": "urgent"}}, nil)
+	require.ErrorContains(t, err, "unknown priority class `urgent`")
+}
+
+func TestAdmit(t *testing.T) {
+	signal := newFixedSignal(0.8)
+	controller, err := NewController(Config{MaxQueueWait: 50 * time.Millisecond, RetryAfter: 2 * time.Second}, map[string]Signal{
+		"test": signal,
+		"nil":  nil,
+	})
+	require.NoError(t, err)
+
+	// Below their thresholds, critical and normal requests are admitted.
+	require.NoError(t, controller.admit(context.Background(), checkMethod))
+	require.NoError(t, controller.admit(context.Background(), writeMethod))
+
+	// Low priority requests are queued and then shed.
+	err = controller.admit(context.Background(), lookupMethod)
+	requireShed(t, err, 2*time.Second)
+
+	// Critical requests are admitted regardless of saturation.
+	signal.set(1)
+	require.NoError(t, controller.admit(context.Background(), checkMethod))
+	requireShed(t, controller.admit(context.Background(), writeMethod), 2*time.Second)
+}
+
+func TestAdmitAfterQueueing(t *testing.T) {
+	signal := newFixedSignal(1)
+	controller, err := NewController(Config{MaxQueueWait: 10 * time.Second}, map[string]Signal{"test": signal})
+	require.NoError(t, err)
+
+	result := make(chan error)
+	go func() {
+		result <- controller.admit(context.Background(), lookupMethod)
+	}()
+
+	require.Eventually(t, func() bool {
+		return queuedCount(controller, PriorityLow) == 1
+	}, time.Second, time.Millisecond)
+
+	signal.set(0.5)
+	require.NoError(t, <-result)
+	require.Zero(t, queuedCount(controller, PriorityLow))
+}
+
+func TestAdmitInOrder(t *testing.T) {
+	signal := newFixedSignal(1)
+	controller, err := NewController(Config{MaxQueueWait: 10 * time.Second}, map[string]Signal{"test": signal})
+	require.NoError(t, err)
+
+	admitted := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			require.NoError(t, controller.admit(context.Background(), lookupMethod))
+			admitted <- i
+		}()
+
+		require.Eventually(t, func() bool {
+			return queuedCount(controller, PriorityLow) == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	// Queued requests are admitted one at a time, oldest first, as admitted requests complete or
+	// the saturation is polled.
+	signal.set(0.5)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, i, <-admitted)
+	}
+}
+
+func TestNoSignals(t *testing.T) {
+	_, err := NewController(Config{}, map[string]Signal{"nil": nil})
+	require.ErrorIs(t, err, ErrNoSignals)
+}
+
+func TestAdmitQueueFull(t *testing.T) {
+	signal := newFixedSignal(1)
+	controller, err := NewController(Config{MaxQueueWait: 10 * time.Second, MaxQueueLength: 1}, map[string]Signal{"test": signal})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- controller.admit(ctx, lookupMethod)
+	}()
+
+	require.Eventually(t, func() bool {
+		return queuedCount(controller, PriorityLow) == 1
+	}, time.Second, time.Millisecond)
+
+	// The queue is full, so the request is shed immediately.
+	requireShed(t, controller.admit(context.Background(), lookupMethod), DefaultRetryAfter)
+
+	// Queued requests whose context is canceled return the context's error.
+	cancel()
+	require.Equal(t, codes.Canceled, status.Code(<-result))
+}
+
+func TestInterceptors(t *testing.T) {
+	controller, err := NewController(Config{MaxQueueWait: time.Millisecond}, map[string]Signal{"test": newFixedSignal(1)})
+	require.NoError(t, err)
+
+	handled := false
+	unaryHandler := func(ctx context.Context, req any) (any, error) {
+		handled = true
+		return req, nil
+	}
+
+	_, err = UnaryServerInterceptor(controller)(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: writeMethod}, unaryHandler)
+	requireShed(t, err, DefaultRetryAfter)
+	require.False(t, handled)
+
+	resp, err := UnaryServerInterceptor(controller)(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: checkMethod}, unaryHandler)
+	require.NoError(t, err)
+	require.Equal(t, "req", resp)
+	require.True(t, handled)
+
+	// Without a controller, every request is admitted.
+	handled = false
+	_, err = UnaryServerInterceptor(nil)(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: writeMethod}, unaryHandler)
+	require.NoError(t, err)
+	require.True(t, handled)
+}
+
+func queuedCount(controller *Controller, priority Priority) int {
+	controller.lock.Lock()
+	defer controller.lock.Unlock()
+	return controller.queues[priority].Len()
+}
+
+func requireShed(t *testing.T, err error, retryAfter time.Duration) {
+	t.Helper()
+
+	s, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.ResourceExhausted, s.Code())
+
+	require.Len(t, s.Details(), 1)
+	retryInfo, ok := s.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	require.Equal(t, retryAfter, retryInfo.RetryDelay.AsDuration())
+}
@@ -0,0 +1,157 @@
+// Package dispatchbudget limits the number of dispatches each API request may perform, so that a
+// single pathological request cannot exhaust the resources of a node. The limit is exact for the
+// dispatches performed on the node receiving the request, and approximate for those performed
+// concurrently on other nodes of the cluster, which each receive the remaining budget.
+package dispatchbudget
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	grpcauth "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/grpchelpers"
+)
+
+// OverrideMetadataKey is the request metadata key with which clients may set the dispatch budget
+// of a request. Any client may lower its budget, but only trusted clients may raise it.
+const OverrideMetadataKey = "x-spicedb-dispatch-budget"
+
+// Config configures the dispatch budgets of API requests.
+type Config struct {
+	// DefaultBudget is the maximum number of dispatches of requests whose method has no budget of
+	// its own. Zero does not limit dispatches.
+	DefaultBudget uint32
+
+	// MethodBudgets are the budgets of methods, by full method name (e.g.
+	// `/authzed.api.v1.PermissionsService/LookupResources`), service name or method name. Zero
+	// does not limit the dispatches of the method.
+	MethodBudgets map[string]int
+
+	// TrustedPresharedKeys are the preshared keys of the clients allowed to raise the budget of
+	// their requests.
+	TrustedPresharedKeys []string
+}
+
+// Budgets assigns a dispatch budget to each API request.
+type Budgets struct {
+	defaultBudget        uint32
+	methodBudgets        map[string]uint32
+	trustedPresharedKeys [][]byte
+}
+
+// New returns the Budgets for the given configuration.
+func New(config Config) (*Budgets, error) {
+	methodBudgets := make(map[string]uint32, len(config.MethodBudgets))
+	for method, budget := range config.MethodBudgets {
+		if budget < 0 || uint64(budget) > uint64(^uint32(0)) {
+			return nil, fmt.Errorf("invalid dispatch budget %d for method `%s`", budget, method)
+		}
+		methodBudgets[method] = uint32(budget)
+	}
+
+	trustedPresharedKeys := make([][]byte, 0, len(config.TrustedPresharedKeys))
+	for _, key := range config.TrustedPresharedKeys {
+		if key == "" {
+			return nil, fmt.Errorf("trusted preshared keys must not be empty")
+		}
+		trustedPresharedKeys = append(trustedPresharedKeys, []byte(key))
+	}
+
+	return &Budgets{
+		defaultBudget:        config.DefaultBudget,
+		methodBudgets:        methodBudgets,
+		trustedPresharedKeys: trustedPresharedKeys,
+	}, nil
+}
+
+// methodBudget returns the configured budget of the given full gRPC method name.
+func (b *Budgets) methodBudget(fullMethod string) uint32 {
+	if budget, ok := grpchelpers.LookupByMethod(b.methodBudgets, fullMethod); ok {
+		return budget
+	}
+	return b.defaultBudget
+}
+
+// budget returns the budget of the request, taking into account the override requested by the
+// client, if any.
+func (b *Budgets) budget(ctx context.Context, fullMethod string) (uint32, error) {
+	budget := b.methodBudget(fullMethod)
+
+	values := metadata.ValueFromIncomingContext(ctx, OverrideMetadataKey)
+	if len(values) == 0 {
+		return budget, nil
+	}
+
+	override, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s metadata `%s`: must be a number of dispatches", OverrideMetadataKey, values[0])
+	}
+
+	// Lowering the budget is always allowed; an override of zero removes the budget, and so raises it.
+	lowers := override != 0 && (budget == 0 || uint32(override) <= budget)
+	if !lowers && !b.isTrusted(ctx) {
+		return 0, status.Errorf(codes.PermissionDenied, "only trusted clients may raise the dispatch budget of their requests")
+	}
+	return uint32(override), nil
+}
+
+func (b *Budgets) isTrusted(ctx context.Context) bool {
+	token, err := grpcauth.AuthFromMD(ctx, "bearer")
+	if err != nil || token == "" {
+		return false
+	}
+
+	for _, key := range b.trustedPresharedKeys {
+		if subtle.ConstantTimeCompare(key, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor which attaches the dispatch budget
+// of each request to its context. Nil budgets do not limit dispatches.
+func UnaryServerInterceptor(budgets *Budgets) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if budgets == nil {
+			return handler(ctx, req)
+		}
+
+		budget, err := budgets.budget(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(dispatch.ContextWithDispatchBudget(ctx, budget), req)
+	}
+}
+
+// StreamServerInterceptor returns a new stream server interceptor which attaches the dispatch
+// budget of each request to its context. Nil budgets do not limit dispatches.
+func StreamServerInterceptor(budgets *Budgets) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if budgets == nil {
+			return handler(srv, stream)
+		}
+
+		budget, err := budgets.budget(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if budget == 0 {
+			return handler(srv, stream)
+		}
+
+		wrapped := middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = dispatch.ContextWithDispatchBudget(stream.Context(), budget)
+		return handler(srv, wrapped)
+	}
+}
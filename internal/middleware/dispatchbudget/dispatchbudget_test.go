@@ -0,0 +1,107 @@
+package dispatchbudget
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+)
+
+const (
+	checkMethod  = "/authzed.api.v1.PermissionsService/CheckPermission"
+	lookupMethod = "/authzed.api.v1.PermissionsService/LookupResources"
+	watchMethod  = "/authzed.api.v1.WatchService/Watch"
+)
+
+func TestNewInvalidConfig(t *testing.T) {
+	_, err := New(Config{MethodBudgets: map[string]int{"LookupResources": -1}})
+	require.ErrorContains(t, err, "invalid dispatch budget -1 for method `LookupResources`")
+
+	_, err = New(Config{TrustedPresharedKeys: []string{""}})
+	require.Error(t, err)
+}
+
+func TestBudgets(t *testing.T) {
+	budgets, err := New(Config{
+		DefaultBudget: 100,
+		MethodBudgets: map[string]int{
+			lookupMethod:                   1000,
+			"authzed.api.v1.WatchService":  0,
+			"authzed.api.v1.SchemaService": 10,
+			"ReadSchema":                   20,
+		},
+		TrustedPresharedKeys: []string{"trusted"},
+	})
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name           string
+		method         string
+		md             metadata.MD
+		expectedBudget uint32
+		expectedCode   codes.Code
+	}{
+		{"default", checkMethod, nil, 100, codes.OK},
+		{"full method", lookupMethod, nil, 1000, codes.OK},
+		{"service", watchMethod, nil, 0, codes.OK},
+		{"service before method", "/authzed.api.v1.SchemaService/ReadSchema", nil, 10, codes.OK},
+		{"method", "/other.Service/ReadSchema", nil, 20, codes.OK},
+		{"lowered", checkMethod, metadata.Pairs(OverrideMetadataKey, "10"), 10, codes.OK},
+		{"raised", checkMethod, metadata.Pairs(OverrideMetadataKey, "1000"), 0, codes.PermissionDenied},
+		{"removed", checkMethod, metadata.Pairs(OverrideMetadataKey, "0"), 0, codes.PermissionDenied},
+		{"raised by untrusted client", checkMethod, metadata.Pairs(OverrideMetadataKey, "1000", "authorization", "bearer other"), 0, codes.PermissionDenied},
+		{"raised by trusted client", checkMethod, metadata.Pairs(OverrideMetadataKey, "1000", "authorization", "bearer trusted"), 1000, codes.OK},
+		{"removed by trusted client", checkMethod, metadata.Pairs(OverrideMetadataKey, "0", "authorization", "bearer trusted"), 0, codes.OK},
+		{"invalid", checkMethod, metadata.Pairs(OverrideMetadataKey, "many"), 0, codes.InvalidArgument},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+
+			var budget uint32
+			_, err := UnaryServerInterceptor(budgets)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, func(ctx context.Context, _ any) (any, error) {
+				if b := dispatch.DispatchBudgetFromContext(ctx); b != nil {
+					budget = b.Limit()
+				}
+				return nil, nil
+			})
+			require.Equal(t, tc.expectedCode, status.Code(err))
+			require.Equal(t, tc.expectedBudget, budget)
+		})
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	budgets, err := New(Config{DefaultBudget: 50})
+	require.NoError(t, err)
+
+	stream := &testServerStream{ctx: context.Background()}
+	err = StreamServerInterceptor(budgets)(nil, stream, &grpc.StreamServerInfo{FullMethod: lookupMethod}, func(_ any, stream grpc.ServerStream) error {
+		require.Equal(t, uint32(50), dispatch.DispatchBudgetFromContext(stream.Context()).Limit())
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestNilBudgets(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(OverrideMetadataKey, "1000"))
+	_, err := UnaryServerInterceptor(nil)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: checkMethod}, func(ctx context.Context, _ any) (any, error) {
+		require.Nil(t, dispatch.DispatchBudgetFromContext(ctx))
+		return nil, nil
+	})
+	require.NoError(t, err)
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context { return s.ctx }
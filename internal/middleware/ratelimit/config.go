@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// KeyPresharedKey keys a rule by the preshared key with which requests are authenticated.
+	KeyPresharedKey = "preshared-key"
+
+	// KeyClientIdentity keys a rule by the common name of the TLS client certificate of requests,
+	// or by the IP address of the client when it did not present a certificate.
+	KeyClientIdentity = "client-identity"
+
+	// KeyMetadataPrefix keys a rule by the value of the request metadata (header) following the
+	// prefix, e.g. `metadata:x-tenant-id`.
+	KeyMetadataPrefix = "metadata:"
+)
+
+// Config is the contents of a rate limit configuration file, e.g.:
+//
+//	rules:
+//	  - name: lookups-per-key
+//	    key: preshared-key
+//	    methods: ["LookupResources", "LookupSubjects"]
+//	    requestsPerSecond: 10
+//	    requestBurst: 20
+//	  - name: dispatches-per-tenant
+//	    key: metadata:x-tenant-id
+//	    dispatchesPerSecond: 5000
+//	    dispatchBurst: 50000
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule limits the rate of the requests of each client, as identified by the key of the rule. A
+// request must be allowed by every rule applying to it.
+type Rule struct {
+	// Name identifies the rule in metrics and logs, and must be unique.
+	Name string `yaml:"name"`
+
+	// Key is how the clients of the rule are identified: `preshared-key`, `client-identity` or
+	// `metadata:<key>`. Requests without a value for the key are not limited by the rule.
+	Key string `yaml:"key"`
+
+	// Methods are the methods to which the rule applies, by full method name (e.g.
+	// `/authzed.api.v1.PermissionsService/CheckPermission`), service name or method name. The rule
+	// applies to every method if empty.
+	Methods []string `yaml:"methods"`
+
+	// Clients are the values of the key of the clients to which the rule applies. The rule applies
+	// to every client if empty.
+	Clients []string `yaml:"clients"`
+
+	// RequestsPerSecond is the rate at which each client may make requests. Zero disables the
+	// request limit of the rule.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+
+	// RequestBurst is the number of requests a client may make at once. Defaults to
+	// RequestsPerSecond, and at least 1.
+	RequestBurst float64 `yaml:"requestBurst"`
+
+	// DispatchesPerSecond is the rate at which the requests of each client may dispatch. As the
+	// number of dispatches of a request is known only once it completes, it is deducted from the
+	// budget of the client afterwards, and requests are refused while the budget is exhausted.
+	// Zero disables the dispatch budget of the rule.
+	DispatchesPerSecond float64 `yaml:"dispatchesPerSecond"`
+
+	// DispatchBurst is the number of dispatches the budget of a client may accumulate. Defaults to
+	// DispatchesPerSecond.
+	DispatchBurst float64 `yaml:"dispatchBurst"`
+}
+
+// ParseConfig parses and validates the contents of a rate limit configuration file.
+func ParseConfig(contents []byte) (*Config, error) {
+	config := &Config{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(config.Rules))
+	for index, rule := range config.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule #%d is missing a name", index+1)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate rule `%s`", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rule `%s`: %w", rule.Name, err)
+		}
+	}
+
+	return config, nil
+}
+
+func (r Rule) validate() error {
+	switch {
+	case r.Key == KeyPresharedKey, r.Key == KeyClientIdentity:
+	case strings.HasPrefix(r.Key, KeyMetadataPrefix) && len(r.Key) > len(KeyMetadataPrefix):
+	default:
+		return fmt.Errorf("unknown key `%s`: must be one of %s, %s or %s<key>", r.Key, KeyPresharedKey, KeyClientIdentity, KeyMetadataPrefix)
+	}
+
+	if r.RequestsPerSecond < 0 || r.RequestBurst < 0 || r.DispatchesPerSecond < 0 || r.DispatchBurst < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if r.RequestsPerSecond == 0 && r.DispatchesPerSecond == 0 {
+		return fmt.Errorf("one of requestsPerSecond or dispatchesPerSecond is required")
+	}
+	return nil
+}
+
+func (r Rule) requestBurst() float64 {
+	if r.RequestBurst > 0 {
+		return r.RequestBurst
+	}
+	return max(r.RequestsPerSecond, 1)
+}
+
+func (r Rule) dispatchBurst() float64 {
+	if r.DispatchBurst > 0 {
+		return r.DispatchBurst
+	}
+	return r.DispatchesPerSecond
+}
+
+// clientKeyValue returns the value of the key of a client, as it is tracked by the limiter.
+// Preshared keys are hashed, so that they are not retained.
+func (r Rule) clientKeyValue(value string) string {
+	if r.Key != KeyPresharedKey {
+		return value
+	}
+
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
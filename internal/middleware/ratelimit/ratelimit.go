@@ -0,0 +1,457 @@
+// Package ratelimit implements per-client rate limits and dispatch budgets for API requests,
+// configured from a file which is reloaded when it changes.
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	grpcauth "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/zapravila/spicedb/internal/grpchelpers"
+	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/internal/middleware/admission"
+	"github.com/zapravila/spicedb/internal/middleware/usagemetrics"
+)
+
+// DefaultReloadInterval is the default interval at which the rate limit configuration file is
+// checked for changes.
+const DefaultReloadInterval = 5 * time.Second
+
+// healthCheckService is never rate limited.
+const healthCheckService = "grpc.health.v1.Health"
+
+var limitedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spicedb",
+	Subsystem: "ratelimit",
+	Name:      "limited_requests_total",
+	Help:      "number of API requests refused by a rate limit rule, by rule and limit",
+}, []string{"rule", "limit"})
+
+func init() {
+	prometheus.MustRegister(limitedCounter)
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens accumulated since the bucket was last updated, up to the burst.
+func (b *bucket) refill(now time.Time, rate, burst float64) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+}
+
+// rule is a compiled Rule, along with the buckets of its clients.
+type rule struct {
+	Rule
+	methods map[string]struct{}
+	clients map[string]struct{}
+
+	lock       sync.Mutex
+	requests   map[string]*bucket
+	dispatches map[string]*bucket
+}
+
+func newRule(r Rule) *rule {
+	compiled := &rule{
+		Rule:       r,
+		requests:   map[string]*bucket{},
+		dispatches: map[string]*bucket{},
+	}
+
+	if len(r.Methods) > 0 {
+		compiled.methods = make(map[string]struct{}, len(r.Methods))
+		for _, method := range r.Methods {
+			compiled.methods[method] = struct{}{}
+		}
+	}
+
+	if len(r.Clients) > 0 {
+		compiled.clients = make(map[string]struct{}, len(r.Clients))
+		for _, client := range r.Clients {
+			compiled.clients[r.clientKeyValue(client)] = struct{}{}
+		}
+	}
+
+	return compiled
+}
+
+func (r *rule) appliesTo(fullMethod string) bool {
+	if r.methods == nil {
+		return true
+	}
+
+	_, ok := grpchelpers.LookupByMethod(r.methods, fullMethod)
+	return ok
+}
+
+// client returns the value of the key of the rule for the client making the request, and whether
+// the rule applies to the client.
+func (r *rule) client(ctx context.Context) (string, bool) {
+	value := ""
+	switch {
+	case r.Key == KeyPresharedKey:
+		value, _ = grpcauth.AuthFromMD(ctx, "bearer")
+
+	case r.Key == KeyClientIdentity:
+		value = clientIdentity(ctx)
+
+	case strings.HasPrefix(r.Key, KeyMetadataPrefix):
+		if values := metadata.ValueFromIncomingContext(ctx, strings.TrimPrefix(r.Key, KeyMetadataPrefix)); len(values) > 0 {
+			value = values[0]
+		}
+	}
+
+	if value == "" {
+		return "", false
+	}
+
+	value = r.clientKeyValue(value)
+	if r.clients != nil {
+		if _, ok := r.clients[value]; !ok {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// take takes a request token from the buckets of the client, returning how long the client must
+// wait before retrying if the request is refused.
+func (r *rule) take(client string, now time.Time) (string, time.Duration, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.DispatchesPerSecond > 0 {
+		b := r.bucket(r.dispatches, client, now, r.dispatchBurst())
+		b.refill(now, r.DispatchesPerSecond, r.dispatchBurst())
+		if b.tokens <= 0 {
+			return "dispatches", secondsToDuration((1 - b.tokens) / r.DispatchesPerSecond), false
+		}
+	}
+
+	if r.RequestsPerSecond > 0 {
+		b := r.bucket(r.requests, client, now, r.requestBurst())
+		b.refill(now, r.RequestsPerSecond, r.requestBurst())
+		if b.tokens < 1 {
+			return "requests", secondsToDuration((1 - b.tokens) / r.RequestsPerSecond), false
+		}
+		b.tokens--
+	}
+
+	return "", 0, true
+}
+
+// chargeDispatches deducts the dispatches of a completed request from the budget of the client.
+// The budget may become negative, refusing further requests until it is refilled.
+func (r *rule) chargeDispatches(client string, dispatchCount uint32, now time.Time) {
+	if r.DispatchesPerSecond <= 0 || dispatchCount == 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	b := r.bucket(r.dispatches, client, now, r.dispatchBurst())
+	b.refill(now, r.DispatchesPerSecond, r.dispatchBurst())
+	b.tokens -= float64(dispatchCount)
+}
+
+func (r *rule) bucket(buckets map[string]*bucket, client string, now time.Time, burst float64) *bucket {
+	b, ok := buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		buckets[client] = b
+	}
+	return b
+}
+
+// sweep forgets the buckets which have refilled, as they are identical to new buckets.
+func (r *rule) sweep(now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for client, b := range r.requests {
+		if b.tokens+now.Sub(b.updated).Seconds()*r.RequestsPerSecond >= r.requestBurst() {
+			delete(r.requests, client)
+		}
+	}
+	for client, b := range r.dispatches {
+		if b.tokens+now.Sub(b.updated).Seconds()*r.DispatchesPerSecond >= r.dispatchBurst() {
+			delete(r.dispatches, client)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// clientIdentity returns the common name of the TLS client certificate of the request, or the IP
+// address of the client if it did not present one.
+func clientIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) > 0 {
+		if cn := tlsInfo.State.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return cn
+		}
+	}
+
+	if p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// Limiter applies the rules of a rate limit configuration file to API requests.
+type Limiter struct {
+	path     string
+	contents []byte
+	rules    atomic.Pointer[[]*rule]
+	now      func() time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewLimiter returns a Limiter applying the rules of the configuration file at the given path,
+// which is checked for changes at the given interval. Returns an error if the file cannot be read
+// initially; later errors are logged and the last rules read are kept.
+func NewLimiter(path string, reloadInterval time.Duration) (*Limiter, error) {
+	l := newLimiter()
+	l.path = path
+	if _, err := l.reload(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go l.watch(ctx, reloadInterval)
+	return l, nil
+}
+
+func newLimiter() *Limiter {
+	l := &Limiter{now: time.Now}
+	l.rules.Store(&[]*rule{})
+	return l
+}
+
+func (l *Limiter) watch(ctx context.Context, reloadInterval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			changed, err := l.reload()
+			if err != nil {
+				log.Warn().Err(err).Str("path", l.path).Msg("failed to reload rate limit configuration file; keeping the existing rules")
+			} else if changed {
+				log.Info().Str("path", l.path).Int("rules", len(*l.rules.Load())).Msg("reloaded rate limit configuration file")
+			}
+
+			now := l.now()
+			for _, r := range *l.rules.Load() {
+				r.sweep(now)
+			}
+		}
+	}
+}
+
+// reload reads the configuration file, updating the rules if its contents changed. Returns
+// whether the rules changed.
+func (l *Limiter) reload() (bool, error) {
+	contents, err := os.ReadFile(l.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read rate limit configuration file: %w", err)
+	}
+
+	if l.contents != nil && bytes.Equal(contents, l.contents) {
+		return false, nil
+	}
+
+	config, err := ParseConfig(contents)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse rate limit configuration file `%s`: %w", l.path, err)
+	}
+
+	l.contents = contents
+	l.setConfig(config)
+	return true, nil
+}
+
+// setConfig replaces the rules of the limiter. The buckets of unchanged rules are kept, so that
+// reloading the configuration does not reset the limits of clients.
+func (l *Limiter) setConfig(config *Config) {
+	existing := make(map[string]*rule)
+	for _, r := range *l.rules.Load() {
+		existing[r.Name] = r
+	}
+
+	rules := make([]*rule, 0, len(config.Rules))
+	for _, r := range config.Rules {
+		if current, ok := existing[r.Name]; ok && reflect.DeepEqual(current.Rule, r) {
+			rules = append(rules, current)
+			continue
+		}
+		rules = append(rules, newRule(r))
+	}
+	l.rules.Store(&rules)
+}
+
+// Close stops watching the configuration file.
+func (l *Limiter) Close() error {
+	if l.cancel != nil {
+		l.cancel()
+		<-l.done
+	}
+	return nil
+}
+
+// charge is a dispatch budget to charge once a request completes.
+type charge struct {
+	rule   *rule
+	client string
+}
+
+// allow returns the dispatch budgets to charge for the request, or a RESOURCE_EXHAUSTED error if
+// the request is refused by one of the rules.
+func (l *Limiter) allow(ctx context.Context, fullMethod string) ([]charge, error) {
+	if strings.HasPrefix(fullMethod, "/"+healthCheckService+"/") {
+		return nil, nil
+	}
+
+	now := l.now()
+	var charges []charge
+	for _, r := range *l.rules.Load() {
+		if !r.appliesTo(fullMethod) {
+			continue
+		}
+
+		client, ok := r.client(ctx)
+		if !ok {
+			continue
+		}
+
+		if limit, retryAfter, ok := r.take(client, now); !ok {
+			limitedCounter.WithLabelValues(r.Name, limit).Inc()
+			log.Ctx(ctx).Debug().Str("method", fullMethod).Str("rule", r.Name).Str("limit", limit).Msg("rate limited request")
+			return nil, limitedError(ctx, r.Name, limit, retryAfter)
+		}
+
+		if r.DispatchesPerSecond > 0 {
+			charges = append(charges, charge{rule: r, client: client})
+		}
+	}
+	return charges, nil
+}
+
+// complete charges the dispatches of a completed request to the budgets of its client.
+func (l *Limiter) complete(ctx context.Context, charges []charge) {
+	if len(charges) == 0 {
+		return
+	}
+
+	responseMeta := usagemetrics.FromContext(ctx)
+	if responseMeta == nil {
+		return
+	}
+
+	now := l.now()
+	for _, c := range charges {
+		c.rule.chargeDispatches(c.client, responseMeta.DispatchCount, now)
+	}
+}
+
+func limitedError(ctx context.Context, ruleName, limit string, retryAfter time.Duration) error {
+	retryAfter = max(retryAfter, time.Second)
+	retryAfterSeconds := int64(math.Ceil(retryAfter.Seconds()))
+	if err := grpc.SetHeader(ctx, metadata.Pairs(admission.RetryAfterHeader, strconv.FormatInt(retryAfterSeconds, 10))); err != nil {
+		log.Ctx(ctx).Debug().Err(err).Msg("unable to set retry-after header")
+	}
+
+	st, err := status.New(codes.ResourceExhausted, fmt.Sprintf("rate limit exceeded: %s limit of rule `%s`", limit, ruleName)).
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(retryAfterSeconds) * time.Second)})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor which rate limits requests through
+// the given limiter. A nil limiter allows every request.
+func UnaryServerInterceptor(limiter *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if limiter == nil {
+			return handler(ctx, req)
+		}
+
+		charges, err := limiter.allow(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if len(charges) > 0 {
+			ctx = usagemetrics.ContextWithHandle(ctx)
+			defer limiter.complete(ctx, charges)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a new stream server interceptor which rate limits requests
+// through the given limiter. A nil limiter allows every request.
+func StreamServerInterceptor(limiter *Limiter) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if limiter == nil {
+			return handler(srv, stream)
+		}
+
+		charges, err := limiter.allow(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if len(charges) > 0 {
+			wrapped := middleware.WrapServerStream(stream)
+			wrapped.WrappedContext = usagemetrics.ContextWithHandle(stream.Context())
+			defer limiter.complete(wrapped.WrappedContext, charges)
+			stream = wrapped
+		}
+		return handler(srv, stream)
+	}
+}
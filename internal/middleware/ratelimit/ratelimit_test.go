@@ -0,0 +1,313 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/middleware/usagemetrics"
+	dispatch "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+)
+
+const (
+	checkMethod  = "/authzed.api.v1.PermissionsService/CheckPermission"
+	lookupMethod = "/authzed.api.v1.PermissionsService/LookupResources"
+)
+
+func TestParseConfig(t *testing.T) {
+	tcs := []struct {
+		name          string
+		contents      string
+		expectedError string
+	}{
+		{"empty", "", ""},
+		{"valid", `
+rules:
+  - name: lookups
+    key: preshared-key
+    methods: [LookupResources]
+    requestsPerSecond: 10
+  - name: tenants
+    key: metadata:x-tenant-id
+    dispatchesPerSecond: 100
+`, ""},
+		{"missing name", `
+rules:
+  - key: preshared-key
+    requestsPerSecond: 10
+`, "rule #1 is missing a name"},
+		{"duplicate name", `
+rules:
+  - name: a
+    key: preshared-key
+    requestsPerSecond: 10
+  - name: a
+    key: client-identity
+    requestsPerSecond: 10
+`, "duplicate rule `a`"},
+		{"unknown key", `
+rules:
+  - name: a
+    key: token
+    requestsPerSecond: 10
+`, "unknown key `token`"},
+		{"empty metadata key", `
+rules:
+  - name: a
+    key: "metadata:"
+    requestsPerSecond: 10
+`, "unknown key `metadata:`"},
+		{"no limits", `
+rules:
+  - name: a
+    key: preshared-key
+`, "one of requestsPerSecond or dispatchesPerSecond is required"},
+		{"negative limit", `
+rules:
+  - name: a
+    key: preshared-key
+    requestsPerSecond: -1
+`, "limits must not be negative"},
+		{"unknown field", `
+rules:
+  - name: a
+    key: preshared-key
+    requestsPerMinute: 1
+`, "field requestsPerMinute not found"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tc.contents))
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.expectedError)
+			}
+		})
+	}
+}
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestLimiter(t *testing.T, contents string) (*Limiter, *fakeClock) {
+	t.Helper()
+
+	config, err := ParseConfig([]byte(contents))
+	require.NoError(t, err)
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l := newLimiter()
+	l.now = clock.Now
+	l.setConfig(config)
+	return l, clock
+}
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "bearer "+token))
+}
+
+func TestRequestLimit(t *testing.T) {
+	l, clock := newTestLimiter(t, `
+rules:
+  - name: lookups
+    key: preshared-key
+    methods: [LookupResources]
+    requestsPerSecond: 2
+    requestBurst: 2
+`)
+
+	interceptor := UnaryServerInterceptor(l)
+	call := func(ctx context.Context, method string) error {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(context.Context, any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	// The burst is allowed, after which the client is limited.
+	require.NoError(t, call(withToken("first"), lookupMethod))
+	require.NoError(t, call(withToken("first"), lookupMethod))
+	requireLimited(t, call(withToken("first"), lookupMethod), time.Second)
+
+	// Other clients, and other methods, are not limited.
+	require.NoError(t, call(withToken("second"), lookupMethod))
+	require.NoError(t, call(withToken("first"), checkMethod))
+
+	// Requests without the key of the rule are not limited.
+	require.NoError(t, call(context.Background(), lookupMethod))
+
+	// The bucket refills over time.
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	require.NoError(t, call(withToken("first"), lookupMethod))
+	requireLimited(t, call(withToken("first"), lookupMethod), time.Second)
+}
+
+func TestDispatchBudget(t *testing.T) {
+	l, clock := newTestLimiter(t, `
+rules:
+  - name: tenants
+    key: metadata:x-tenant-id
+    clients: [expensive]
+    dispatchesPerSecond: 10
+`)
+
+	interceptor := UnaryServerInterceptor(l)
+	call := func(tenant string, dispatchCount uint32) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant-id", tenant))
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: checkMethod}, func(ctx context.Context, _ any) (any, error) {
+			usagemetrics.SetInContext(ctx, &dispatch.ResponseMeta{DispatchCount: dispatchCount})
+			return nil, nil
+		})
+		return err
+	}
+
+	// Requests are allowed while the budget remains, and the budget may be overdrawn.
+	require.NoError(t, call("expensive", 8))
+	require.NoError(t, call("expensive", 25))
+	requireLimited(t, call("expensive", 1), 3*time.Second)
+
+	// Clients not listed in the rule are not limited.
+	require.NoError(t, call("cheap", 100))
+	require.NoError(t, call("cheap", 100))
+
+	// The budget is refilled over time.
+	clock.now = clock.now.Add(2400 * time.Millisecond)
+	require.NoError(t, call("expensive", 1))
+}
+
+func TestDispatchBudgetStreaming(t *testing.T) {
+	l, _ := newTestLimiter(t, `
+rules:
+  - name: keys
+    key: preshared-key
+    dispatchesPerSecond: 10
+`)
+
+	interceptor := StreamServerInterceptor(l)
+	call := func() error {
+		stream := &testServerStream{ctx: withToken("key")}
+		return interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: lookupMethod}, func(_ any, stream grpc.ServerStream) error {
+			usagemetrics.SetInContext(stream.Context(), &dispatch.ResponseMeta{DispatchCount: 20})
+			return nil
+		})
+	}
+
+	require.NoError(t, call())
+	requireLimited(t, call(), 2*time.Second)
+}
+
+func TestHealthChecksNotLimited(t *testing.T) {
+	l, _ := newTestLimiter(t, `
+rules:
+  - name: all
+    key: preshared-key
+    requestsPerSecond: 1
+`)
+
+	for range 5 {
+		_, err := l.allow(withToken("key"), "/grpc.health.v1.Health/Check")
+		require.NoError(t, err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimits.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: a
+    key: preshared-key
+    requestsPerSecond: 1
+`), 0o600))
+
+	_, err := NewLimiter(filepath.Join(t.TempDir(), "missing.yaml"), time.Hour)
+	require.Error(t, err)
+
+	l, err := NewLimiter(path, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, l.Close()) })
+
+	_, err = l.allow(withToken("key"), checkMethod)
+	require.NoError(t, err)
+	_, err = l.allow(withToken("key"), checkMethod)
+	require.Error(t, err)
+
+	// Unchanged files, and unchanged rules, keep the state of the limits.
+	changed, err := l.reload()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: a
+    key: preshared-key
+    requestsPerSecond: 1
+  - name: b
+    key: client-identity
+    requestsPerSecond: 1
+`), 0o600))
+	changed, err = l.reload()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Len(t, *l.rules.Load(), 2)
+
+	_, err = l.allow(withToken("key"), checkMethod)
+	require.Error(t, err)
+
+	// Invalid files keep the existing rules.
+	require.NoError(t, os.WriteFile(path, []byte("rules: [{name: a}]"), 0o600))
+	_, err = l.reload()
+	require.Error(t, err)
+	require.Len(t, *l.rules.Load(), 2)
+}
+
+func TestSweep(t *testing.T) {
+	l, clock := newTestLimiter(t, `
+rules:
+  - name: a
+    key: preshared-key
+    requestsPerSecond: 1
+`)
+
+	_, err := l.allow(withToken("key"), checkMethod)
+	require.NoError(t, err)
+
+	r := (*l.rules.Load())[0]
+	r.sweep(clock.now)
+	require.Len(t, r.requests, 1)
+
+	clock.now = clock.now.Add(time.Second)
+	r.sweep(clock.now)
+	require.Empty(t, r.requests)
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context { return s.ctx }
+
+func requireLimited(t *testing.T, err error, retryAfter time.Duration) {
+	t.Helper()
+
+	s, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.ResourceExhausted, s.Code())
+
+	require.Len(t, s.Details(), 1)
+	retryInfo, ok := s.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	require.Equal(t, retryAfter, retryInfo.RetryDelay.AsDuration())
+}
@@ -37,7 +37,11 @@ type reporter struct{}
 
 func (r *reporter) ServerReporter(ctx context.Context, callMeta interceptors.CallMeta) (interceptors.Reporter, context.Context) {
 	_, methodName := grpcutil.SplitMethodName(callMeta.FullMethod())
-	ctx = ContextWithHandle(ctx)
+	if ctx.Value(metadataCtxKey) == nil {
+		// An outer middleware may have already added a handle, to read the metadata once the
+		// request completes.
+		ctx = ContextWithHandle(ctx)
+	}
 	return &serverReporter{ctx: ctx, methodName: methodName}, ctx
 }
 
@@ -0,0 +1,724 @@
+// Package schemamigration implements schema migrations which rewrite existing relationships, such
+// as renaming definitions and relations, alongside the change of schema.
+package schemamigration
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/internal/relationships"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore/options"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	"github.com/zapravila/spicedb/pkg/genutil/slicez"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/schemadsl/compiler"
+	"github.com/zapravila/spicedb/pkg/schemadsl/input"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/typesystem"
+)
+
+// DefaultBatchSize is the number of relationships read or written in each batch of a migration, if
+// none is specified.
+const DefaultBatchSize = 1000
+
+// maximumCatchUpPasses is the maximum number of passes catching up the rewritten relationships
+// with the existing relationships before the swap. Passes are repeated while more than a batch of
+// relationships is written, so that the swap itself only writes the changes made since the last.
+const maximumCatchUpPasses = 5
+
+// lookupChunkSize is the maximum number of resource or subject IDs in each query looking up
+// relationships, as datastores limit the number of IDs in a filter.
+const lookupChunkSize = 100
+
+// Phase is the phase of a running migration.
+type Phase string
+
+const (
+	// PhaseCopy is the phase in which the rewritten relationships are written alongside the
+	// existing relationships, in batches. Relationships rewritten by an earlier run of the
+	// migration are kept if unchanged.
+	PhaseCopy Phase = "copy"
+
+	// PhaseCatchUp is the phase in which the relationships written or deleted since they were
+	// copied are rewritten, in batches.
+	PhaseCatchUp Phase = "catch-up"
+
+	// PhaseSwap is the phase in which the relationships changed since the last catch up are
+	// rewritten and the schema is written, in a single transaction.
+	PhaseSwap Phase = "swap"
+
+	// PhaseDelete is the phase in which the relationships replaced by the rewritten relationships
+	// are deleted, in batches, once the schema is swapped.
+	PhaseDelete Phase = "delete"
+
+	// PhaseRollback is the phase in which the rewritten relationships of a migration whose schema
+	// was not swapped are deleted by Cleanup, in batches.
+	PhaseRollback Phase = "rollback"
+)
+
+// Progress is reported by a migration after each batch of relationships.
+type Progress struct {
+	Phase Phase
+
+	// Operation is the operation whose relationships are being rewritten or deleted.
+	Operation Operation
+
+	// Rewritten is the total number of rewritten relationships written or deleted so far.
+	Rewritten uint64
+}
+
+// Result is the result of a completed migration.
+type Result struct {
+	// Rewritten is the number of rewritten relationships written or deleted, including those
+	// rewritten again as the existing relationships changed during the migration.
+	Rewritten uint64
+
+	// Revision is the revision at which the schema was swapped.
+	Revision datastore.Revision
+
+	// PreviousSchemaHash and SchemaHash are the hashes of the schema before and after the
+	// migration, as returned by shared.SchemaHash.
+	PreviousSchemaHash string
+	SchemaHash         string
+}
+
+// Migration changes the schema to Schema, rewriting the existing relationships by the Operations.
+//
+// The relationships to which the operations rewrite, such as those of a renamed relation, must not
+// be in the current schema: the rewritten relationships are first written in batches of BatchSize
+// alongside the existing relationships, while not visible to queries. They are then caught up with
+// the relationships written or deleted since, in batches, and once more in the transaction which
+// writes the schema. The schema is only written if it has not been changed since the migration
+// began. The existing relationships, which are not allowed by the migrated schema, are deleted in
+// batches once it is written.
+//
+// A migration which did not complete can be run again, keeping the relationships already
+// rewritten, or be cleaned up by Cleanup.
+type Migration struct {
+	Schema     string
+	Operations []Operation
+	BatchSize  uint64
+
+	// Progress, if not nil, is called after each batch of relationships.
+	Progress func(Progress)
+}
+
+type migrationState struct {
+	*Migration
+
+	validated   *shared.ValidatedSchemaChanges
+	typeSystems map[string]*typesystem.TypeSystem
+	caveats     map[string]*core.CaveatDefinition
+	batchSize   uint64
+
+	// current holds the object definitions of the schema when the migration began, by name.
+	current map[string]*core.NamespaceDefinition
+
+	// previousHash and targetHash are the hashes of the schema when the migration began and of
+	// the migrated schema.
+	previousHash string
+	targetHash   string
+
+	// rewritten is the number of rewritten relationships written or deleted so far.
+	rewritten uint64
+}
+
+// Run runs the migration against the datastore.
+func (m *Migration) Run(ctx context.Context, ds datastore.Datastore) (*Result, error) {
+	state, err := m.prepare(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := state.ensureTargetsNotInCurrentSchema(); err != nil {
+		return nil, err
+	}
+
+	if err := state.ensureSourcesNotAllowed(); err != nil {
+		return nil, err
+	}
+
+	if err := state.catchUp(ctx, ds); err != nil {
+		return nil, err
+	}
+
+	// The swap is retried by the datastore on conflicts, so the number of relationships it wrote
+	// is only recorded once the transaction has committed.
+	var applied *shared.AppliedSchemaChanges
+	var swapped uint64
+	revision, err := ds.ReadWriteTx(ctx, func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		var err error
+		applied, swapped, err = state.swap(ctx, rwt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	state.rewritten += swapped
+
+	if err := state.deleteAll(ctx, ds, PhaseDelete, sourcesOf); err != nil {
+		return nil, fmt.Errorf("the schema was migrated at revision %s, but deleting the replaced relationships failed and must be completed by cleaning up the migration: %w", revision, err)
+	}
+
+	return &Result{
+		Rewritten:          state.rewritten,
+		Revision:           revision,
+		PreviousSchemaHash: applied.PreviousSchemaHash,
+		SchemaHash:         applied.SchemaHash,
+	}, nil
+}
+
+// Cleanup deletes the relationships left by a run of the migration which did not complete: the
+// rewritten relationships, if the schema was not swapped, or the existing relationships they
+// replace, if it was. It returns the phase run, either PhaseRollback or PhaseDelete.
+func (m *Migration) Cleanup(ctx context.Context, ds datastore.Datastore) (Phase, error) {
+	state, err := m.prepare(ctx, ds)
+	if err != nil {
+		return "", err
+	}
+
+	if state.previousHash == state.targetHash {
+		if err := state.ensureSourcesNotAllowed(); err != nil {
+			return "", err
+		}
+		return PhaseDelete, state.deleteAll(ctx, ds, PhaseDelete, sourcesOf)
+	}
+
+	if err := state.ensureTargetsNotInCurrentSchema(); err != nil {
+		return "", fmt.Errorf("the schema is neither that migrated to nor one from which it can be migrated: %w", err)
+	}
+	return PhaseRollback, state.deleteAll(ctx, ds, PhaseRollback, copiesOf)
+}
+
+// prepare compiles and validates the target schema, checks the operations against it, and reads
+// the current schema.
+func (m *Migration) prepare(ctx context.Context, ds datastore.Datastore) (*migrationState, error) {
+	if len(m.Operations) == 0 {
+		return nil, fmt.Errorf("no migration operations were given")
+	}
+
+	compiled, err := compiler.Compile(compiler.InputSchema{
+		Source:       input.Source("schema"),
+		SchemaString: m.Schema,
+	}, compiler.AllowUnprefixedObjectType())
+	if err != nil {
+		return nil, err
+	}
+
+	validated, err := shared.ValidateSchemaChanges(ctx, compiled, false)
+	if err != nil {
+		return nil, err
+	}
+
+	targetHash, err := shared.SchemaHash(compiled.CaveatDefinitions, compiled.ObjectDefinitions)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := typesystem.ResolverForSchema(*compiled)
+	typeSystems := make(map[string]*typesystem.TypeSystem, len(compiled.ObjectDefinitions))
+	for _, nsDef := range compiled.ObjectDefinitions {
+		ts, err := typesystem.NewNamespaceTypeSystem(nsDef, resolver)
+		if err != nil {
+			return nil, err
+		}
+		typeSystems[nsDef.Name] = ts
+	}
+
+	caveats := make(map[string]*core.CaveatDefinition, len(compiled.CaveatDefinitions))
+	for _, caveatDef := range compiled.CaveatDefinitions {
+		caveats[caveatDef.Name] = caveatDef
+	}
+
+	for _, op := range m.Operations {
+		for _, target := range op.targets() {
+			definition, relation, hasRelation := strings.Cut(target, "#")
+			ts, ok := typeSystems[definition]
+			if !ok {
+				return nil, fmt.Errorf("cannot %s: object definition `%s` is not found in the schema", op, definition)
+			}
+
+			if hasRelation && !ts.HasRelation(relation) {
+				return nil, fmt.Errorf("cannot %s: relation `%s` is not found in object definition `%s`", op, relation, definition)
+			}
+		}
+	}
+
+	headRevision, err := ds.HeadRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := ds.SnapshotReader(headRevision)
+	caveatDefs, err := reader.ListAllCaveats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nsDefs, err := reader.ListAllNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	previousHash, err := shared.SchemaHash(datastore.DefinitionsOf(caveatDefs), datastore.DefinitionsOf(nsDefs))
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*core.NamespaceDefinition, len(nsDefs))
+	for _, nsDef := range nsDefs {
+		current[nsDef.Definition.Name] = nsDef.Definition
+	}
+
+	// The existing relationships are deleted once the schema is swapped.
+	var deleted []datastore.RelationshipsFilter
+	for _, source := range sourcesOf(m.Operations) {
+		filter, err := datastore.RelationshipsFilterFromPublicFilter(source)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, filter)
+	}
+
+	batchSize := m.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	return &migrationState{
+		Migration:    m,
+		validated:    validated.WithExpectedSchemaHash(previousHash).WithRelationshipsDeletedAfter(deleted...),
+		typeSystems:  typeSystems,
+		caveats:      caveats,
+		batchSize:    batchSize,
+		current:      current,
+		previousHash: previousHash,
+		targetHash:   targetHash,
+	}, nil
+}
+
+// ensureTargetsNotInCurrentSchema ensures that the targets of the operations are not in the
+// current schema, so that the rewritten relationships are not visible until the schema is swapped.
+func (ms *migrationState) ensureTargetsNotInCurrentSchema() error {
+	for _, op := range ms.Operations {
+		for _, target := range op.targets() {
+			definition, relation, hasRelation := strings.Cut(target, "#")
+			nsDef, ok := ms.current[definition]
+			if !ok {
+				continue
+			}
+
+			if !hasRelation {
+				return fmt.Errorf("cannot %s: object definition `%s` already exists in the current schema", op, definition)
+			}
+
+			if slices.ContainsFunc(nsDef.Relation, func(rel *core.Relation) bool { return rel.Name == relation }) {
+				return fmt.Errorf("cannot %s: relation `%s` already exists in object definition `%s` of the current schema", op, relation, definition)
+			}
+		}
+	}
+	return nil
+}
+
+// ensureSourcesNotAllowed ensures that the relationships rewritten by the operations are not
+// allowed by the migrated schema, so that they are neither visible nor written once the schema is
+// swapped, and can be deleted in batches.
+func (ms *migrationState) ensureSourcesNotAllowed() error {
+	for _, op := range ms.Operations {
+		for _, source := range op.sources() {
+			if relation, ok := ms.allowedBy(source); ok {
+				return fmt.Errorf("cannot %s: relationships rewritten by the operation are still allowed by relation `%s` of the schema", op, relation)
+			}
+		}
+	}
+	return nil
+}
+
+// allowedBy returns a relation of the migrated schema which allows relationships matching the
+// filter, if any.
+func (ms *migrationState) allowedBy(filter *v1.RelationshipFilter) (string, bool) {
+	for name, ts := range ms.typeSystems {
+		if filter.ResourceType != "" && filter.ResourceType != name {
+			continue
+		}
+
+		for _, relation := range ts.Namespace().Relation {
+			if ts.IsPermission(relation.Name) || (filter.OptionalRelation != "" && filter.OptionalRelation != relation.Name) {
+				continue
+			}
+
+			if filter.OptionalSubjectFilter == nil ||
+				slices.ContainsFunc(relation.GetTypeInformation().GetAllowedDirectRelations(), func(allowed *core.AllowedRelation) bool {
+					return allowsSubjects(allowed, filter.OptionalSubjectFilter)
+				}) {
+				return tuple.JoinRelRef(name, relation.Name), true
+			}
+		}
+	}
+	return "", false
+}
+
+// allowsSubjects returns whether the allowed relation allows subjects matching the filter.
+func allowsSubjects(allowed *core.AllowedRelation, filter *v1.SubjectFilter) bool {
+	if allowed.Namespace != filter.SubjectType {
+		return false
+	}
+
+	if filter.OptionalRelation == nil {
+		return true
+	}
+
+	relation := allowed.GetRelation()
+	if allowed.GetPublicWildcard() != nil || relation == tuple.Ellipsis {
+		relation = ""
+	}
+	return relation == filter.OptionalRelation.Relation
+}
+
+// rewrite returns the relationship rewritten by all the operations of the migration, validated
+// against the target schema.
+func (ms *migrationState) rewrite(rel *core.RelationTuple) (*core.RelationTuple, error) {
+	rewritten := rel.CloneVT()
+	for _, op := range ms.Operations {
+		op.rewrite(rewritten)
+	}
+
+	// The rewritten relationship must not itself be rewritten, as it would otherwise be deleted
+	// alongside the relationships it replaces.
+	for _, op := range ms.Operations {
+		if op.rewrite(rewritten.CloneVT()) {
+			return nil, fmt.Errorf("relationship `%s` rewritten to `%s` would be rewritten again by the operation to %s; operations cannot be chained",
+				tuple.MustString(rel), tuple.MustString(rewritten), op)
+		}
+	}
+
+	if err := relationships.ValidateOneRelationship(ms.typeSystems, ms.caveats, rewritten, relationships.ValidateRelationshipForCreateOrTouch); err != nil {
+		return nil, fmt.Errorf("relationship `%s` rewritten to `%s` is invalid under the schema: %w",
+			tuple.MustString(rel), tuple.MustString(rewritten), err)
+	}
+
+	return rewritten, nil
+}
+
+// restore returns the relationship from which the rewritten relationship was rewritten, or nil if
+// it was not rewritten by any operation.
+func (ms *migrationState) restore(rewritten *core.RelationTuple) *core.RelationTuple {
+	rel := rewritten.CloneVT()
+	matched := false
+	for _, op := range ms.Operations {
+		if op.restore(rel) {
+			matched = true
+		}
+	}
+
+	if !matched {
+		return nil
+	}
+	return rel
+}
+
+// catchUp writes the rewritten relationships in passes of batches, each in its own transaction,
+// until a pass writes no more than a batch.
+func (ms *migrationState) catchUp(ctx context.Context, ds datastore.Datastore) error {
+	write := func(updates []*core.RelationTupleUpdate) error {
+		_, err := ds.ReadWriteTx(ctx, func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+			return rwt.WriteRelationships(ctx, updates)
+		})
+		return err
+	}
+
+	phase := PhaseCopy
+	for pass := 1; ; pass++ {
+		headRevision, err := ds.HeadRevision(ctx)
+		if err != nil {
+			return err
+		}
+
+		written, err := ms.reconcile(ctx, ds.SnapshotReader(headRevision), phase, write)
+		if err != nil {
+			return err
+		}
+		ms.rewritten += written
+
+		log.Ctx(ctx).Debug().Int("pass", pass).Uint64("written", written).Msg("caught up rewritten relationships")
+		if written <= ms.batchSize || pass >= maximumCatchUpPasses {
+			return nil
+		}
+		phase = PhaseCatchUp
+	}
+}
+
+// swap catches up the rewritten relationships with the existing relationships and writes the
+// schema, returning the number of relationships written. It does not change the state of the
+// migration, as it may be retried.
+func (ms *migrationState) swap(ctx context.Context, rwt datastore.ReadWriteTransaction) (*shared.AppliedSchemaChanges, uint64, error) {
+	written, err := ms.reconcile(ctx, rwt, PhaseSwap, func(updates []*core.RelationTupleUpdate) error {
+		return rwt.WriteRelationships(ctx, updates)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	log.Ctx(ctx).Debug().Uint64("written", written).Msg("caught up rewritten relationships")
+	applied, err := shared.ApplySchemaChanges(ctx, rwt, ms.validated)
+	if err != nil {
+		return nil, 0, err
+	}
+	return applied, written, nil
+}
+
+// reconcile writes the updates catching up the rewritten relationships with the existing
+// relationships read from the reader, by batches: rewritten relationships missing or changed are
+// touched, and those whose existing relationship no longer exists are deleted. It returns the
+// number of relationships written.
+func (ms *migrationState) reconcile(ctx context.Context, reader datastore.Reader, phase Phase, write func([]*core.RelationTupleUpdate) error) (uint64, error) {
+	var written uint64
+	writeBatch := func(op Operation, updates []*core.RelationTupleUpdate) error {
+		if len(updates) > 0 {
+			if err := write(updates); err != nil {
+				return err
+			}
+			written += uint64(len(updates))
+		}
+
+		ms.report(phase, op, ms.rewritten+written)
+		return nil
+	}
+
+	for _, op := range ms.Operations {
+		for _, source := range op.sources() {
+			err := ms.forEachBatch(ctx, reader, source, func(rels []*core.RelationTuple) error {
+				rewritten := make([]*core.RelationTuple, 0, len(rels))
+				for _, rel := range rels {
+					rewrittenRel, err := ms.rewrite(rel)
+					if err != nil {
+						return err
+					}
+					rewritten = append(rewritten, rewrittenRel)
+				}
+
+				copied, err := ms.lookup(ctx, reader, rewritten)
+				if err != nil {
+					return err
+				}
+
+				updates := make([]*core.RelationTupleUpdate, 0, len(rewritten))
+				for _, rel := range rewritten {
+					if existing, ok := copied[tuple.StringWithoutCaveat(rel)]; ok && tuple.Equal(existing, rel) {
+						continue
+					}
+					updates = append(updates, tuple.Touch(rel))
+				}
+				return writeBatch(op, updates)
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		for _, copies := range op.copies() {
+			err := ms.forEachBatch(ctx, reader, copies, func(rels []*core.RelationTuple) error {
+				restored := make([]*core.RelationTuple, len(rels))
+				for i, rel := range rels {
+					restored[i] = ms.restore(rel)
+				}
+
+				existing, err := ms.lookup(ctx, reader, restored)
+				if err != nil {
+					return err
+				}
+
+				updates := make([]*core.RelationTupleUpdate, 0, len(rels))
+				for i, rel := range rels {
+					if restored[i] != nil {
+						if _, ok := existing[tuple.StringWithoutCaveat(restored[i])]; ok {
+							continue
+						}
+					}
+					updates = append(updates, tuple.Delete(rel))
+				}
+				return writeBatch(op, updates)
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// lookupGroup is a group of relationships looked up by the same queries.
+type lookupGroup struct {
+	resourceType    string
+	relation        string
+	subjectType     string
+	subjectRelation string
+}
+
+// lookup returns those of the given relationships found in the reader, by their string without
+// caveat. Nil relationships are skipped.
+func (ms *migrationState) lookup(ctx context.Context, reader datastore.Reader, rels []*core.RelationTuple) (map[string]*core.RelationTuple, error) {
+	resourceIDs := make(map[lookupGroup]*mapz.Set[string])
+	subjectIDs := make(map[lookupGroup]*mapz.Set[string])
+	for _, rel := range rels {
+		if rel == nil {
+			continue
+		}
+
+		group := lookupGroup{
+			resourceType:    rel.ResourceAndRelation.Namespace,
+			relation:        rel.ResourceAndRelation.Relation,
+			subjectType:     rel.Subject.Namespace,
+			subjectRelation: rel.Subject.Relation,
+		}
+		if _, ok := resourceIDs[group]; !ok {
+			resourceIDs[group] = mapz.NewSet[string]()
+			subjectIDs[group] = mapz.NewSet[string]()
+		}
+		resourceIDs[group].Add(rel.ResourceAndRelation.ObjectId)
+		subjectIDs[group].Add(rel.Subject.ObjectId)
+	}
+
+	found := make(map[string]*core.RelationTuple, len(rels))
+	for group, groupResourceIDs := range resourceIDs {
+		_, err := slicez.ForEachChunkUntil(groupResourceIDs.AsSlice(), lookupChunkSize, func(resourceIDChunk []string) (bool, error) {
+			return slicez.ForEachChunkUntil(subjectIDs[group].AsSlice(), lookupChunkSize, func(subjectIDChunk []string) (bool, error) {
+				it, err := reader.QueryRelationships(ctx, datastore.RelationshipsFilter{
+					OptionalResourceType:     group.resourceType,
+					OptionalResourceIds:      resourceIDChunk,
+					OptionalResourceRelation: group.relation,
+					OptionalSubjectsSelectors: []datastore.SubjectsSelector{{
+						OptionalSubjectType: group.subjectType,
+						OptionalSubjectIds:  subjectIDChunk,
+						RelationFilter:      datastore.SubjectRelationFilter{}.WithRelation(group.subjectRelation),
+					}},
+				})
+				if err != nil {
+					return false, err
+				}
+				defer it.Close()
+
+				for rel := it.Next(); rel != nil; rel = it.Next() {
+					found[tuple.StringWithoutCaveat(rel)] = rel
+				}
+				return true, it.Err()
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// sourcesOf returns the filters of the relationships rewritten by the operations.
+func sourcesOf(operations []Operation) []*v1.RelationshipFilter {
+	var filters []*v1.RelationshipFilter
+	for _, op := range operations {
+		filters = append(filters, op.sources()...)
+	}
+	return filters
+}
+
+// copiesOf returns the filters of the relationships rewritten to by the operations.
+func copiesOf(operations []Operation) []*v1.RelationshipFilter {
+	var filters []*v1.RelationshipFilter
+	for _, op := range operations {
+		filters = append(filters, op.copies()...)
+	}
+	return filters
+}
+
+// deleteAll deletes the relationships matching the filters returned for each operation, in
+// batches, each in its own transaction.
+func (ms *migrationState) deleteAll(ctx context.Context, ds datastore.Datastore, phase Phase, filtersOf func([]Operation) []*v1.RelationshipFilter) error {
+	for _, op := range ms.Operations {
+		for _, filter := range filtersOf([]Operation{op}) {
+			for {
+				var limitReached bool
+				_, err := ds.ReadWriteTx(ctx, func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+					var err error
+					limitReached, err = rwt.DeleteRelationships(ctx, filter, options.WithDeleteLimit(&ms.batchSize))
+					return err
+				})
+				if err != nil {
+					return err
+				}
+
+				ms.report(phase, op, ms.rewritten)
+				if !limitReached {
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// forEachBatch calls fn with each batch of relationships matching the filter.
+func (ms *migrationState) forEachBatch(ctx context.Context, reader datastore.Reader, filter *v1.RelationshipFilter, fn func([]*core.RelationTuple) error) error {
+	dsFilter, err := datastore.RelationshipsFilterFromPublicFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	var cursor options.Cursor
+	for {
+		it, err := reader.QueryRelationships(ctx, dsFilter,
+			options.WithSort(options.ByResource),
+			options.WithLimit(&ms.batchSize),
+			options.WithAfter(cursor),
+		)
+		if err != nil {
+			return err
+		}
+
+		rels := make([]*core.RelationTuple, 0, ms.batchSize)
+		for rel := it.Next(); rel != nil; rel = it.Next() {
+			rels = append(rels, rel)
+		}
+		if it.Err() != nil {
+			it.Close()
+			return it.Err()
+		}
+
+		if len(rels) > 0 {
+			cursor, err = it.Cursor()
+			if err != nil {
+				it.Close()
+				return err
+			}
+		}
+		it.Close()
+
+		if len(rels) == 0 {
+			return nil
+		}
+
+		if err := fn(rels); err != nil {
+			return err
+		}
+
+		if uint64(len(rels)) < ms.batchSize {
+			return nil
+		}
+	}
+}
+
+func (ms *migrationState) report(phase Phase, op Operation, rewritten uint64) {
+	if ms.Progress != nil {
+		ms.Progress(Progress{
+			Phase:     phase,
+			Operation: op,
+			Rewritten: rewritten,
+		})
+	}
+}
@@ -0,0 +1,581 @@
+package schemamigration
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	"github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore/options"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const initialSchema = `
+	definition user {}
+
+	definition team {
+		relation member: user
+	}
+
+	definition document {
+		relation reader: user | team#member
+		permission view = reader
+	}
+`
+
+var initialRelationships = []*core.RelationTuple{
+	tuple.MustParse("document:first#reader@user:tom"),
+	tuple.MustParse("document:first#reader@team:eng#member"),
+	tuple.MustParse("document:second#reader@user:fred"),
+	tuple.MustParse("team:eng#member@user:jill"),
+	tuple.MustParse("team:eng#member@user:sarah"),
+}
+
+func TestMigrationRun(t *testing.T) {
+	tcs := []struct {
+		name       string
+		schema     string
+		operations []Operation
+		expected   []string
+	}{
+		{
+			name: "rename relation",
+			schema: `
+				definition user {}
+
+				definition team {
+					relation member: user
+				}
+
+				definition document {
+					relation viewer: user | team#member
+					permission view = viewer
+				}
+			`,
+			operations: []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+			expected: []string{
+				"document:first#viewer@user:tom",
+				"document:first#viewer@team:eng#member",
+				"document:second#viewer@user:fred",
+				"team:eng#member@user:jill",
+				"team:eng#member@user:sarah",
+			},
+		},
+		{
+			name: "rename definition referenced by subjects",
+			schema: `
+				definition user {}
+
+				definition group {
+					relation member: user
+				}
+
+				definition document {
+					relation reader: user | group#member
+					permission view = reader
+				}
+			`,
+			operations: []Operation{RenameDefinition{From: "team", To: "group"}},
+			expected: []string{
+				"document:first#reader@user:tom",
+				"document:first#reader@group:eng#member",
+				"document:second#reader@user:fred",
+				"group:eng#member@user:jill",
+				"group:eng#member@user:sarah",
+			},
+		},
+		{
+			name: "split relation by subject type",
+			schema: `
+				definition user {}
+
+				definition team {
+					relation member: user
+				}
+
+				definition document {
+					relation reader: user
+					relation team_reader: team#member
+					permission view = reader + team_reader
+				}
+			`,
+			operations: []Operation{SplitRelation{Definition: "document", Relation: "reader", SubjectType: "team", SubjectRelation: "member", To: "team_reader"}},
+			expected: []string{
+				"document:first#reader@user:tom",
+				"document:first#team_reader@team:eng#member",
+				"document:second#reader@user:fred",
+				"team:eng#member@user:jill",
+				"team:eng#member@user:sarah",
+			},
+		},
+		{
+			name: "rename subject type",
+			schema: `
+				definition user {}
+				definition person {}
+
+				definition team {
+					relation member: person
+				}
+
+				definition document {
+					relation reader: person | team#member
+					permission view = reader
+				}
+			`,
+			operations: []Operation{RenameSubjectType{From: "user", To: "person"}},
+			expected: []string{
+				"document:first#reader@person:tom",
+				"document:first#reader@team:eng#member",
+				"document:second#reader@person:fred",
+				"team:eng#member@person:jill",
+				"team:eng#member@person:sarah",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require := require.New(t)
+			ds := newDatastore(t)
+
+			var reported []Progress
+			migration := &Migration{
+				Schema:     tc.schema,
+				Operations: tc.operations,
+				BatchSize:  1,
+				Progress: func(p Progress) {
+					reported = append(reported, p)
+				},
+			}
+
+			result, err := migration.Run(context.Background(), ds)
+			require.NoError(err)
+			require.NotEmpty(reported)
+			require.Equal(PhaseCopy, reported[0].Phase)
+			require.Equal(PhaseDelete, reported[len(reported)-1].Phase)
+			require.Equal(result.Rewritten, reported[len(reported)-1].Rewritten)
+			require.NotEqual(result.PreviousSchemaHash, result.SchemaHash)
+
+			require.ElementsMatch(tc.expected, readRelationships(t, ds, headRevision(t, ds)))
+
+			// Once completed, cleaning up the migration leaves the relationships unchanged.
+			phase, err := migration.Cleanup(context.Background(), ds)
+			require.NoError(err)
+			require.Equal(PhaseDelete, phase)
+			require.ElementsMatch(tc.expected, readRelationships(t, ds, headRevision(t, ds)))
+		})
+	}
+}
+
+func TestMigrationRunInvalid(t *testing.T) {
+	tcs := []struct {
+		name          string
+		schema        string
+		operations    []Operation
+		expectedError string
+	}{
+		{
+			name:          "missing target relation",
+			schema:        initialSchema,
+			operations:    []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+			expectedError: "relation `viewer` is not found in object definition `document`",
+		},
+		{
+			name: "target in current schema",
+			schema: `
+				definition user {}
+
+				definition document {
+					relation reader: user
+					permission view = reader
+				}
+			`,
+			operations:    []Operation{RenameDefinition{From: "team", To: "user"}},
+			expectedError: "object definition `user` already exists in the current schema",
+		},
+		{
+			name: "target relation in current schema",
+			schema: `
+				definition user {}
+
+				definition team {
+					relation member: user
+				}
+
+				definition document {
+					relation reader: user | team#member
+					permission view = reader
+				}
+			`,
+			operations:    []Operation{SplitRelation{Definition: "document", Relation: "reader", SubjectType: "user", To: "reader"}},
+			expectedError: "relation `reader` already exists in object definition `document` of the current schema",
+		},
+		{
+			name: "source still allowed",
+			schema: `
+				definition user {}
+
+				definition team {
+					relation member: user
+				}
+
+				definition document {
+					relation reader: user | team#member
+					relation team_reader: team#member
+					permission view = reader + team_reader
+				}
+			`,
+			operations:    []Operation{SplitRelation{Definition: "document", Relation: "reader", SubjectType: "team", SubjectRelation: "member", To: "team_reader"}},
+			expectedError: "still allowed by relation `document#reader`",
+		},
+		{
+			name: "rewritten relationship not allowed",
+			schema: `
+				definition user {}
+
+				definition team {
+					relation member: user
+				}
+
+				definition document {
+					relation viewer: user
+					permission view = viewer
+				}
+			`,
+			operations:    []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+			expectedError: "is invalid under the schema",
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			require := require.New(t)
+			ds := newDatastore(t)
+
+			_, err := (&Migration{Schema: tc.schema, Operations: tc.operations}).Run(context.Background(), ds)
+			require.ErrorContains(err, tc.expectedError)
+
+			// The schema and relationships are unchanged.
+			expected := make([]string, 0, len(initialRelationships))
+			for _, rel := range initialRelationships {
+				expected = append(expected, tuple.MustString(rel))
+			}
+			updatedRevision, err := ds.HeadRevision(context.Background())
+			require.NoError(err)
+			require.ElementsMatch(expected, readRelationships(t, ds, updatedRevision))
+
+			_, _, err = ds.SnapshotReader(updatedRevision).ReadNamespaceByName(context.Background(), "document")
+			require.NoError(err)
+		})
+	}
+}
+
+func TestMigrationCatchesUpChangesDuringCopy(t *testing.T) {
+	require := require.New(t)
+	ds := newDatastore(t)
+
+	schema := `
+		definition user {}
+
+		definition team {
+			relation member: user
+		}
+
+		definition document {
+			relation viewer: user | team#member
+			permission view = viewer
+		}
+	`
+
+	written := false
+	migration := &Migration{
+		Schema:     schema,
+		Operations: []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+		BatchSize:  1,
+		Progress: func(p Progress) {
+			if p.Phase != PhaseCopy || written {
+				return
+			}
+			written = true
+
+			// Change the relationships once the copy has begun.
+			_, err := ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+				return rwt.WriteRelationships(ctx, []*core.RelationTupleUpdate{
+					tuple.Create(tuple.MustParse("document:third#reader@user:tom")),
+					tuple.Delete(tuple.MustParse("document:first#reader@user:tom")),
+					tuple.Delete(tuple.MustParse("document:second#reader@user:fred")),
+				})
+			})
+			require.NoError(err)
+		},
+	}
+
+	_, err := migration.Run(context.Background(), ds)
+	require.NoError(err)
+	require.ElementsMatch([]string{
+		"document:first#viewer@team:eng#member",
+		"document:third#viewer@user:tom",
+		"team:eng#member@user:jill",
+		"team:eng#member@user:sarah",
+	}, readRelationships(t, ds, headRevision(t, ds)))
+}
+
+const renamedRelationSchema = `
+	definition user {}
+
+	definition team {
+		relation member: user
+	}
+
+	definition document {
+		relation viewer: user | team#member
+		permission view = viewer
+	}
+`
+
+func TestMigrationRunKeepsEarlierCopies(t *testing.T) {
+	require := require.New(t)
+	ds := newDatastore(t)
+
+	// Copies left by an earlier run: one unchanged, and one whose relationship has since been
+	// deleted.
+	_, err := ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		return rwt.WriteRelationships(ctx, []*core.RelationTupleUpdate{
+			tuple.Create(tuple.MustParse("document:first#viewer@user:tom")),
+			tuple.Create(tuple.MustParse("document:first#viewer@user:deleted")),
+		})
+	})
+	require.NoError(err)
+
+	result, err := (&Migration{
+		Schema:     renamedRelationSchema,
+		Operations: []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+	}).Run(context.Background(), ds)
+	require.NoError(err)
+
+	// The unchanged copy is kept, the two missing are written, and the stale copy deleted.
+	require.Equal(uint64(3), result.Rewritten)
+	require.ElementsMatch([]string{
+		"document:first#viewer@user:tom",
+		"document:first#viewer@team:eng#member",
+		"document:second#viewer@user:fred",
+		"team:eng#member@user:jill",
+		"team:eng#member@user:sarah",
+	}, readRelationships(t, ds, headRevision(t, ds)))
+}
+
+func TestMigrationCleanup(t *testing.T) {
+	expected := []string{
+		"document:first#viewer@user:tom",
+		"document:first#viewer@team:eng#member",
+		"document:second#viewer@user:fred",
+		"team:eng#member@user:jill",
+		"team:eng#member@user:sarah",
+	}
+
+	t.Run("rollback", func(t *testing.T) {
+		require := require.New(t)
+		ds := &failingDatastore{Datastore: newDatastore(t)}
+
+		migration := &Migration{
+			Schema:     renamedRelationSchema,
+			Operations: []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+			BatchSize:  1,
+		}
+		migration.Progress = func(p Progress) {
+			if p.Phase == PhaseCopy && p.Rewritten > 0 {
+				ds.failing.Store(true)
+			}
+		}
+
+		_, err := migration.Run(context.Background(), ds)
+		require.ErrorIs(err, errFailing)
+		ds.failing.Store(false)
+
+		migration.Progress = nil
+		phase, err := migration.Cleanup(context.Background(), ds)
+		require.NoError(err)
+		require.Equal(PhaseRollback, phase)
+
+		initial := make([]string, 0, len(initialRelationships))
+		for _, rel := range initialRelationships {
+			initial = append(initial, tuple.MustString(rel))
+		}
+		require.ElementsMatch(initial, readRelationships(t, ds, headRevision(t, ds)))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require := require.New(t)
+		ds := &failingDatastore{Datastore: newDatastore(t)}
+
+		migration := &Migration{
+			Schema:     renamedRelationSchema,
+			Operations: []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+			BatchSize:  1,
+		}
+		migration.Progress = func(p Progress) {
+			if p.Phase == PhaseDelete {
+				ds.failing.Store(true)
+			}
+		}
+
+		_, err := migration.Run(context.Background(), ds)
+		require.ErrorIs(err, errFailing)
+		require.ErrorContains(err, "the schema was migrated at revision")
+		ds.failing.Store(false)
+
+		// The replaced relationships are left, but not allowed by the migrated schema.
+		require.Contains(readRelationships(t, ds, headRevision(t, ds)), "document:second#reader@user:fred")
+
+		migration.Progress = nil
+		phase, err := migration.Cleanup(context.Background(), ds)
+		require.NoError(err)
+		require.Equal(PhaseDelete, phase)
+		require.ElementsMatch(expected, readRelationships(t, ds, headRevision(t, ds)))
+	})
+
+	t.Run("other schema", func(t *testing.T) {
+		ds := newDatastore(t)
+
+		_, err := (&Migration{
+			Schema:     renamedRelationSchema,
+			Operations: []Operation{RenameDefinition{From: "team", To: "user"}},
+		}).Cleanup(context.Background(), ds)
+		require.ErrorContains(t, err, "the schema is neither that migrated to nor one from which it can be migrated")
+	})
+}
+
+func TestMigrationFailsIfSchemaChanged(t *testing.T) {
+	require := require.New(t)
+	ds := newDatastore(t)
+
+	migration := &Migration{
+		Schema: `
+			definition user {}
+
+			definition team {
+				relation member: user
+			}
+
+			definition document {
+				relation viewer: user | team#member
+				permission view = viewer
+			}
+		`,
+		Operations: []Operation{RenameRelation{Definition: "document", From: "reader", To: "viewer"}},
+	}
+	migration.Progress = func(p Progress) {
+		if p.Phase != PhaseCopy {
+			return
+		}
+		migration.Progress = nil
+
+		_, err := ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+			return rwt.WriteNamespaces(ctx, &core.NamespaceDefinition{Name: "folder"})
+		})
+		require.NoError(err)
+	}
+
+	_, err := migration.Run(context.Background(), ds)
+	require.ErrorAs(err, &shared.ErrSchemaChanged{})
+}
+
+func TestParseOperations(t *testing.T) {
+	rd, err := ParseRenameDefinition("team=group")
+	require.NoError(t, err)
+	require.Equal(t, RenameDefinition{From: "team", To: "group"}, rd)
+
+	rr, err := ParseRenameRelation("document#reader=viewer")
+	require.NoError(t, err)
+	require.Equal(t, RenameRelation{Definition: "document", From: "reader", To: "viewer"}, rr)
+
+	sr, err := ParseSplitRelation("document#reader@team#member=team_reader")
+	require.NoError(t, err)
+	require.Equal(t, SplitRelation{Definition: "document", Relation: "reader", SubjectType: "team", SubjectRelation: "member", To: "team_reader"}, sr)
+
+	sr, err = ParseSplitRelation("document#reader@user#...=user_reader")
+	require.NoError(t, err)
+	require.Equal(t, SplitRelation{Definition: "document", Relation: "reader", SubjectType: "user", To: "user_reader"}, sr)
+
+	rst, err := ParseRenameSubjectType("user=person")
+	require.NoError(t, err)
+	require.Equal(t, RenameSubjectType{From: "user", To: "person"}, rst)
+
+	for _, invalid := range []string{"", "team", "=group", "team="} {
+		_, err := ParseRenameDefinition(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	for _, invalid := range []string{"document=viewer", "document#reader", "#reader=viewer"} {
+		_, err := ParseRenameRelation(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	for _, invalid := range []string{"document#reader=team_reader", "document@team=team_reader", "document#reader@=x"} {
+		_, err := ParseSplitRelation(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func newDatastore(t *testing.T) datastore.Datastore {
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(t, err)
+	t.Cleanup(func() { rawDS.Close() })
+
+	ds, _ := testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, initialSchema, initialRelationships, require.New(t))
+	return ds
+}
+
+var errFailing = errors.New("failing")
+
+// failingDatastore fails its read-write transactions while failing is set.
+type failingDatastore struct {
+	datastore.Datastore
+
+	failing atomic.Bool
+}
+
+func (fd *failingDatastore) ReadWriteTx(ctx context.Context, fn datastore.TxUserFunc, opts ...options.RWTOptionsOption) (datastore.Revision, error) {
+	if fd.failing.Load() {
+		return datastore.NoRevision, errFailing
+	}
+	return fd.Datastore.ReadWriteTx(ctx, fn, opts...)
+}
+
+func headRevision(t *testing.T, ds datastore.Datastore) datastore.Revision {
+	revision, err := ds.HeadRevision(context.Background())
+	require.NoError(t, err)
+	return revision
+}
+
+func readRelationships(t *testing.T, ds datastore.Datastore, revision datastore.Revision) []string {
+	reader := ds.SnapshotReader(revision)
+	nsDefs, err := reader.ListAllNamespaces(context.Background())
+	require.NoError(t, err)
+
+	var rels []string
+	for _, nsDef := range nsDefs {
+		filter, err := datastore.RelationshipsFilterFromPublicFilter(&v1.RelationshipFilter{ResourceType: nsDef.Definition.Name})
+		require.NoError(t, err)
+
+		it, err := reader.QueryRelationships(context.Background(), filter)
+		require.NoError(t, err)
+		for rel := it.Next(); rel != nil; rel = it.Next() {
+			rels = append(rels, tuple.MustString(rel))
+		}
+		require.NoError(t, it.Err())
+		it.Close()
+	}
+	return rels
+}
@@ -0,0 +1,282 @@
+package schemamigration
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+// Operation is a rewrite of existing relationships performed by a schema migration.
+type Operation interface {
+	fmt.Stringer
+
+	// sources returns the filters matching the relationships rewritten by the operation.
+	sources() []*v1.RelationshipFilter
+
+	// rewrite rewrites the relationship in place, returning whether it was matched by the operation.
+	rewrite(rel *core.RelationTuple) bool
+
+	// targets returns the relations, as `definition#relation`, or the definitions, to which the
+	// operation rewrites relationships, and which must exist in the migrated schema but not in the
+	// current schema.
+	targets() []string
+
+	// copies returns the filters matching the relationships rewritten by the operation. As its
+	// targets are not in the current schema, any relationship matched is a copy written by the
+	// migration.
+	copies() []*v1.RelationshipFilter
+
+	// restore reverts the rewrite of the relationship in place, returning whether it was matched.
+	restore(rel *core.RelationTuple) bool
+}
+
+// RenameDefinition renames a definition, rewriting the relationships whose resource or subject is
+// of the definition.
+type RenameDefinition struct {
+	From string
+	To   string
+}
+
+func (rd RenameDefinition) String() string {
+	return fmt.Sprintf("rename definition `%s` to `%s`", rd.From, rd.To)
+}
+
+func (rd RenameDefinition) sources() []*v1.RelationshipFilter {
+	return []*v1.RelationshipFilter{
+		{ResourceType: rd.From},
+		{OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: rd.From}},
+	}
+}
+
+func (rd RenameDefinition) rewrite(rel *core.RelationTuple) bool {
+	matched := false
+	if rel.ResourceAndRelation.Namespace == rd.From {
+		rel.ResourceAndRelation.Namespace = rd.To
+		matched = true
+	}
+	if rel.Subject.Namespace == rd.From {
+		rel.Subject.Namespace = rd.To
+		matched = true
+	}
+	return matched
+}
+
+func (rd RenameDefinition) targets() []string {
+	return []string{rd.To}
+}
+
+func (rd RenameDefinition) copies() []*v1.RelationshipFilter {
+	return RenameDefinition{From: rd.To, To: rd.From}.sources()
+}
+
+func (rd RenameDefinition) restore(rel *core.RelationTuple) bool {
+	return RenameDefinition{From: rd.To, To: rd.From}.rewrite(rel)
+}
+
+// RenameRelation renames a relation of a definition, rewriting the relationships under the
+// relation, as well as those whose subject references it.
+type RenameRelation struct {
+	Definition string
+	From       string
+	To         string
+}
+
+func (rr RenameRelation) String() string {
+	return fmt.Sprintf("rename relation `%s` to `%s`", tuple.JoinRelRef(rr.Definition, rr.From), rr.To)
+}
+
+func (rr RenameRelation) sources() []*v1.RelationshipFilter {
+	return []*v1.RelationshipFilter{
+		{ResourceType: rr.Definition, OptionalRelation: rr.From},
+		{OptionalSubjectFilter: &v1.SubjectFilter{
+			SubjectType:      rr.Definition,
+			OptionalRelation: &v1.SubjectFilter_RelationFilter{Relation: rr.From},
+		}},
+	}
+}
+
+func (rr RenameRelation) rewrite(rel *core.RelationTuple) bool {
+	matched := false
+	if rel.ResourceAndRelation.Namespace == rr.Definition && rel.ResourceAndRelation.Relation == rr.From {
+		rel.ResourceAndRelation.Relation = rr.To
+		matched = true
+	}
+	if rel.Subject.Namespace == rr.Definition && rel.Subject.Relation == rr.From {
+		rel.Subject.Relation = rr.To
+		matched = true
+	}
+	return matched
+}
+
+func (rr RenameRelation) targets() []string {
+	return []string{tuple.JoinRelRef(rr.Definition, rr.To)}
+}
+
+func (rr RenameRelation) copies() []*v1.RelationshipFilter {
+	return RenameRelation{Definition: rr.Definition, From: rr.To, To: rr.From}.sources()
+}
+
+func (rr RenameRelation) restore(rel *core.RelationTuple) bool {
+	return RenameRelation{Definition: rr.Definition, From: rr.To, To: rr.From}.rewrite(rel)
+}
+
+// SplitRelation moves the relationships of a relation whose subjects are of a type into another
+// relation of the definition.
+type SplitRelation struct {
+	Definition string
+	Relation   string
+
+	// SubjectType and SubjectRelation are the type of the subjects of the relationships moved. An
+	// empty SubjectRelation matches subjects without a relation.
+	SubjectType     string
+	SubjectRelation string
+
+	To string
+}
+
+func (sr SplitRelation) String() string {
+	subjectType := sr.SubjectType
+	if sr.SubjectRelation != "" {
+		subjectType = tuple.JoinRelRef(sr.SubjectType, sr.SubjectRelation)
+	}
+	return fmt.Sprintf("move subjects of type `%s` of relation `%s` to `%s`", subjectType, tuple.JoinRelRef(sr.Definition, sr.Relation), sr.To)
+}
+
+func (sr SplitRelation) sources() []*v1.RelationshipFilter {
+	return []*v1.RelationshipFilter{
+		{
+			ResourceType:     sr.Definition,
+			OptionalRelation: sr.Relation,
+			OptionalSubjectFilter: &v1.SubjectFilter{
+				SubjectType:      sr.SubjectType,
+				OptionalRelation: &v1.SubjectFilter_RelationFilter{Relation: sr.SubjectRelation},
+			},
+		},
+	}
+}
+
+func (sr SplitRelation) rewrite(rel *core.RelationTuple) bool {
+	subjectRelation := rel.Subject.Relation
+	if subjectRelation == tuple.Ellipsis {
+		subjectRelation = ""
+	}
+
+	if rel.ResourceAndRelation.Namespace != sr.Definition || rel.ResourceAndRelation.Relation != sr.Relation ||
+		rel.Subject.Namespace != sr.SubjectType || subjectRelation != sr.SubjectRelation {
+		return false
+	}
+
+	rel.ResourceAndRelation.Relation = sr.To
+	return true
+}
+
+func (sr SplitRelation) targets() []string {
+	return []string{tuple.JoinRelRef(sr.Definition, sr.To)}
+}
+
+func (sr SplitRelation) copies() []*v1.RelationshipFilter {
+	return sr.inverse().sources()
+}
+
+func (sr SplitRelation) restore(rel *core.RelationTuple) bool {
+	return sr.inverse().rewrite(rel)
+}
+
+func (sr SplitRelation) inverse() SplitRelation {
+	inverse := sr
+	inverse.Relation, inverse.To = sr.To, sr.Relation
+	return inverse
+}
+
+// RenameSubjectType changes the type of the subjects of a definition, rewriting the relationships
+// whose subject is of the type. Unlike RenameDefinition, resources of the type are left unchanged.
+type RenameSubjectType struct {
+	From string
+	To   string
+}
+
+func (rst RenameSubjectType) String() string {
+	return fmt.Sprintf("rename subject type `%s` to `%s`", rst.From, rst.To)
+}
+
+func (rst RenameSubjectType) sources() []*v1.RelationshipFilter {
+	return []*v1.RelationshipFilter{
+		{OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: rst.From}},
+	}
+}
+
+func (rst RenameSubjectType) rewrite(rel *core.RelationTuple) bool {
+	if rel.Subject.Namespace != rst.From {
+		return false
+	}
+
+	rel.Subject.Namespace = rst.To
+	return true
+}
+
+func (rst RenameSubjectType) targets() []string {
+	return []string{rst.To}
+}
+
+func (rst RenameSubjectType) copies() []*v1.RelationshipFilter {
+	return RenameSubjectType{From: rst.To, To: rst.From}.sources()
+}
+
+func (rst RenameSubjectType) restore(rel *core.RelationTuple) bool {
+	return RenameSubjectType{From: rst.To, To: rst.From}.rewrite(rel)
+}
+
+// ParseRenameDefinition parses a RenameDefinition of the form `from=to`.
+func ParseRenameDefinition(spec string) (RenameDefinition, error) {
+	from, to, ok := strings.Cut(spec, "=")
+	if !ok || from == "" || to == "" {
+		return RenameDefinition{}, fmt.Errorf("invalid definition rename `%s`: expected `from=to`", spec)
+	}
+	return RenameDefinition{From: from, To: to}, nil
+}
+
+// ParseRenameRelation parses a RenameRelation of the form `definition#from=to`.
+func ParseRenameRelation(spec string) (RenameRelation, error) {
+	relRef, to, ok := strings.Cut(spec, "=")
+	definition, from, hasRelation := strings.Cut(relRef, "#")
+	if !ok || !hasRelation || definition == "" || from == "" || to == "" {
+		return RenameRelation{}, fmt.Errorf("invalid relation rename `%s`: expected `definition#from=to`", spec)
+	}
+	return RenameRelation{Definition: definition, From: from, To: to}, nil
+}
+
+// ParseSplitRelation parses a SplitRelation of the form `definition#relation@subjecttype=to`
+// or `definition#relation@subjecttype#subjectrelation=to`.
+func ParseSplitRelation(spec string) (SplitRelation, error) {
+	source, to, ok := strings.Cut(spec, "=")
+	relRef, subjectType, hasSubject := strings.Cut(source, "@")
+	definition, relation, hasRelation := strings.Cut(relRef, "#")
+	subjectType, subjectRelation, _ := strings.Cut(subjectType, "#")
+	if !ok || !hasSubject || !hasRelation || definition == "" || relation == "" || subjectType == "" || to == "" {
+		return SplitRelation{}, fmt.Errorf("invalid relation split `%s`: expected `definition#relation@subjecttype=to`", spec)
+	}
+
+	if subjectRelation == tuple.Ellipsis {
+		subjectRelation = ""
+	}
+	return SplitRelation{
+		Definition:      definition,
+		Relation:        relation,
+		SubjectType:     subjectType,
+		SubjectRelation: subjectRelation,
+		To:              to,
+	}, nil
+}
+
+// ParseRenameSubjectType parses a RenameSubjectType of the form `from=to`.
+func ParseRenameSubjectType(spec string) (RenameSubjectType, error) {
+	from, to, ok := strings.Cut(spec, "=")
+	if !ok || from == "" || to == "" {
+		return RenameSubjectType{}, fmt.Errorf("invalid subject type rename `%s`: expected `from=to`", spec)
+	}
+	return RenameSubjectType{From: from, To: to}, nil
+}
@@ -9,8 +9,11 @@ import (
 
 	"github.com/zapravila/spicedb/internal/middleware"
 
+	grpcmiddleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
 	grpcvalidate "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/validator"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/zapravila/spicedb/internal/dispatch"
@@ -34,10 +37,14 @@ func NewDispatchServer(localDispatch dispatch.Dispatcher) dispatchv1.DispatchSer
 	return &dispatchServer{
 		localDispatch: localDispatch,
 		WithServiceSpecificInterceptors: shared.WithServiceSpecificInterceptors{
-			Unary: grpcvalidate.UnaryServerInterceptor(),
+			Unary: middleware.ChainUnaryServer(
+				grpcvalidate.UnaryServerInterceptor(),
+				dispatchBudgetUnaryServerInterceptor,
+			),
 			Stream: middleware.ChainStreamServer(
 				grpcvalidate.StreamServerInterceptor(),
 				streamtimeout.MustStreamServerInterceptor(streamAPITimeout),
+				dispatchBudgetStreamServerInterceptor,
 			),
 		},
 	}
@@ -81,6 +88,12 @@ func (ds *dispatchServer) DispatchLookupSubjects(
 	req *dispatchv1.DispatchLookupSubjectsRequest,
 	resp dispatchv1.DispatchService_DispatchLookupSubjectsServer,
 ) error {
+	if len(req.AdditionalSubjectRelations) > 0 {
+		if err := resp.SetHeader(metadata.Pairs(dispatch.SubjectRelationsSupportedMetadataKey, "true")); err != nil {
+			return err
+		}
+	}
+
 	return ds.localDispatch.DispatchLookupSubjects(req,
 		dispatch.WrapGRPCStream[*dispatchv1.DispatchLookupSubjectsResponse](resp))
 }
@@ -89,6 +102,29 @@ func (ds *dispatchServer) Close() error {
 	return nil
 }
 
+// dispatchBudgetUnaryServerInterceptor limits the dispatches of a request to the remaining dispatch
+// budget of the request from which it was dispatched, if any.
+func dispatchBudgetUnaryServerInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := dispatch.ContextWithIncomingDispatchBudget(ctx)
+	if err != nil {
+		return nil, rewriteGraphError(ctx, err)
+	}
+	return handler(ctx, req)
+}
+
+// dispatchBudgetStreamServerInterceptor limits the dispatches of a request to the remaining
+// dispatch budget of the request from which it was dispatched, if any.
+func dispatchBudgetStreamServerInterceptor(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := dispatch.ContextWithIncomingDispatchBudget(stream.Context())
+	if err != nil {
+		return rewriteGraphError(ctx, err)
+	}
+
+	wrapped := grpcmiddleware.WrapServerStream(stream)
+	wrapped.WrappedContext = ctx
+	return handler(srv, wrapped)
+}
+
 func rewriteGraphError(ctx context.Context, err error) error {
 	// Check if the error can be directly used.
 	if st, ok := status.FromError(err); ok {
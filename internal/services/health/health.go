@@ -16,11 +16,11 @@ import (
 const datastoreReadyTimeout = time.Millisecond * 500
 
 // NewHealthManager creates and returns a new health manager that checks the IsReady
-// status of the given dispatcher and datastore checker and sets the health check to
-// return healthy once both have gone to true.
-func NewHealthManager(dispatcher dispatch.Dispatcher, dsc DatastoreChecker) Manager {
+// status of the given dispatcher, datastore checker and any additional components and
+// sets the health check to return healthy once all have gone to true.
+func NewHealthManager(dispatcher dispatch.Dispatcher, dsc DatastoreChecker, components ...ComponentChecker) Manager {
 	healthSvc := grpcutil.NewAuthlessHealthServer()
-	return &healthManager{healthSvc, dispatcher, dsc, map[string]struct{}{}}
+	return &healthManager{healthSvc, dispatcher, dsc, components, map[string]struct{}{}}
 }
 
 // DatastoreChecker is an interface for determining if the datastore is ready for
@@ -30,6 +30,14 @@ type DatastoreChecker interface {
 	ReadyState(ctx context.Context) (datastore.ReadyState, error)
 }
 
+// ComponentChecker is an interface for determining if an additional component, such
+// as a cache being restored from disk, is ready for traffic.
+type ComponentChecker interface {
+	// ReadyState returns whether the component is ready to be used, and a message
+	// describing why if not.
+	ReadyState() (bool, string)
+}
+
 // Manager is a system which manages the health service statuses.
 type Manager interface {
 	// RegisterReportedService registers the name of service under the same server
@@ -47,6 +55,7 @@ type healthManager struct {
 	healthSvc    *grpcutil.AuthlessHealthServer
 	dispatcher   dispatch.Dispatcher
 	dsc          DatastoreChecker
+	components   []ComponentChecker
 	serviceNames map[string]struct{}
 }
 
@@ -120,6 +129,13 @@ func (hm *healthManager) checkIsReady(ctx context.Context) bool {
 		return false
 	}
 
+	for _, component := range hm.components {
+		if isReady, message := component.ReadyState(); !isReady {
+			log.Ctx(ctx).Warn().Bool("componentReady", false).Msgf("component failed readiness checks: %s", message)
+			return false
+		}
+	}
+
 	log.Ctx(ctx).Debug().Bool("datastoreReady", true).Bool("dispatchReady", true).Msg("completed dispatcher and datastore readiness checks")
 	return true
 }
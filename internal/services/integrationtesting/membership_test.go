@@ -0,0 +1,61 @@
+//go:build !skipintegrationtests
+// +build !skipintegrationtests
+
+package integrationtesting_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+func TestGossipMembershipCluster(t *testing.T) {
+	require := require.New(t)
+
+	emptyDS, err := memdb.NewMemdbDatastore(0, 10, memdb.DisableGC)
+	require.NoError(err)
+	ds, revision := tf.StandardDatastoreWithData(emptyDS, require)
+
+	conns, memberships, cleanup := testserver.TestClusterWithGossipMembership(t, 3, ds, 10*time.Millisecond)
+	t.Cleanup(cleanup)
+
+	waitForMembers := func(count int) {
+		for _, m := range memberships[:count] {
+			require.Eventually(func() bool {
+				return len(m.Members()) == count
+			}, 10*time.Second, 10*time.Millisecond)
+		}
+	}
+
+	check := func(conn int) {
+		resp, err := v1.NewPermissionsServiceClient(conns[conn]).CheckPermission(context.Background(), &v1.CheckPermissionRequest{
+			Consistency: &v1.Consistency{
+				Requirement: &v1.Consistency_AtLeastAsFresh{AtLeastAsFresh: zedtoken.MustNewFromRevision(revision)},
+			},
+			Resource:   &v1.ObjectReference{ObjectType: "document", ObjectId: "masterplan"},
+			Permission: "view",
+			Subject:    &v1.SubjectReference{Object: &v1.ObjectReference{ObjectType: "user", ObjectId: "eng_lead"}},
+		})
+		require.NoError(err)
+		require.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, resp.Permissionship)
+	}
+
+	waitForMembers(3)
+	for conn := range conns {
+		check(conn)
+	}
+
+	// The last node leaves the cluster, and the others stop dispatching to it.
+	require.NoError(memberships[2].Close())
+	waitForMembers(2)
+	check(0)
+	check(1)
+}
@@ -20,6 +20,10 @@ type SchemaServiceOption int
 // WatchServiceOption defines the options for enabling or disabling the V1 Watch service.
 type WatchServiceOption int
 
+// PermissionWatchServiceOption defines the options for enabling or disabling the permission watch
+// service.
+type PermissionWatchServiceOption int
+
 // CaveatsOption defines the options for enabling or disabling caveats in the V1 services.
 type CaveatsOption int
 
@@ -39,6 +43,13 @@ const (
 
 	// WatchServiceEnabled indicates that the V1 watch service is enabled.
 	WatchServiceEnabled WatchServiceOption = 1
+
+	// PermissionWatchServiceDisabled indicates that the permission watch service is disabled.
+	PermissionWatchServiceDisabled PermissionWatchServiceOption = 0
+
+	// PermissionWatchServiceEnabled indicates that the permission watch service is enabled. It is
+	// only registered if the V1 watch service is also enabled.
+	PermissionWatchServiceEnabled PermissionWatchServiceOption = 1
 )
 
 const (
@@ -53,6 +64,7 @@ func RegisterGrpcServices(
 	dispatch dispatch.Dispatcher,
 	schemaServiceOption SchemaServiceOption,
 	watchServiceOption WatchServiceOption,
+	permissionWatchServiceOption PermissionWatchServiceOption,
 	permSysConfig v1svc.PermissionsServerConfig,
 	watchHeartbeatDuration time.Duration,
 ) {
@@ -67,6 +79,11 @@ func RegisterGrpcServices(
 		healthManager.RegisterReportedService(v1.WatchService_ServiceDesc.ServiceName)
 	}
 
+	if watchServiceOption == WatchServiceEnabled && permissionWatchServiceOption == PermissionWatchServiceEnabled {
+		v1svc.RegisterPermissionWatchServer(srv, v1svc.NewPermissionWatchServer(dispatch, permSysConfig, watchHeartbeatDuration))
+		healthManager.RegisterReportedService(v1svc.PermissionWatchServiceName)
+	}
+
 	if schemaServiceOption == V1SchemaServiceEnabled || schemaServiceOption == V1SchemaServiceAdditiveOnly {
 		v1.RegisterSchemaServiceServer(srv, v1svc.NewSchemaServer(schemaServiceOption == V1SchemaServiceAdditiveOnly))
 		healthManager.RegisterReportedService(v1.SchemaService_ServiceDesc.ServiceName)
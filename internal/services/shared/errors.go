@@ -69,6 +69,44 @@ func (err ErrSchemaWriteDataValidation) GRPCStatus() *status.Status {
 	)
 }
 
+// NewSchemaChangedError creates a new error representing that a schema write cannot be completed
+// as the existing schema differs from that expected by the writer.
+func NewSchemaChangedError(expectedSchemaHash string, currentSchemaHash string) ErrSchemaChanged {
+	return ErrSchemaChanged{
+		error:              fmt.Errorf("the schema has changed since it was read: expected schema hash `%s`, found `%s`", expectedSchemaHash, currentSchemaHash),
+		expectedSchemaHash: expectedSchemaHash,
+		currentSchemaHash:  currentSchemaHash,
+	}
+}
+
+// ErrSchemaChanged occurs when a schema cannot be applied as the existing schema has changed since
+// it was read by the writer.
+type ErrSchemaChanged struct {
+	error
+	expectedSchemaHash string
+	currentSchemaHash  string
+}
+
+// MarshalZerologObject implements zerolog object marshalling.
+func (err ErrSchemaChanged) MarshalZerologObject(e *zerolog.Event) {
+	e.Err(err.error).Str("expectedSchemaHash", err.expectedSchemaHash).Str("currentSchemaHash", err.currentSchemaHash)
+}
+
+// GRPCStatus implements retrieving the gRPC status for the error.
+func (err ErrSchemaChanged) GRPCStatus() *status.Status {
+	return spiceerrors.WithCodeAndDetails(
+		err,
+		codes.FailedPrecondition,
+		spiceerrors.ForReason(
+			v1.ErrorReason_ERROR_REASON_WRITE_OR_DELETE_PRECONDITION_FAILURE,
+			map[string]string{
+				"expected_schema_hash": err.expectedSchemaHash,
+				"current_schema_hash":  err.currentSchemaHash,
+			},
+		),
+	)
+}
+
 // MaxDepthExceededError is an error returned when the maximum depth for dispatching has been exceeded.
 type MaxDepthExceededError struct {
 	*spiceerrors.ErrorWithAdditionalDetails
@@ -2,6 +2,11 @@ package shared
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
 
 	log "github.com/zapravila/spicedb/internal/logging"
 	"github.com/zapravila/spicedb/internal/namespace"
@@ -12,6 +17,7 @@ import (
 	"github.com/zapravila/spicedb/pkg/genutil/mapz"
 	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
 	"github.com/zapravila/spicedb/pkg/schemadsl/compiler"
+	"github.com/zapravila/spicedb/pkg/schemadsl/generator"
 	"github.com/zapravila/spicedb/pkg/spiceerrors"
 	"github.com/zapravila/spicedb/pkg/tuple"
 	"github.com/zapravila/spicedb/pkg/typesystem"
@@ -24,6 +30,59 @@ type ValidatedSchemaChanges struct {
 	newCaveatDefNames    *mapz.Set[string]
 	newObjectDefNames    *mapz.Set[string]
 	additiveOnly         bool
+	expectedSchemaHash   string
+
+	// deletedRelationships are the filters of the relationships deleted by the writer once the
+	// changes are applied, and thus ignored by the checks for relationships left without schema.
+	deletedRelationships []datastore.RelationshipsFilter
+}
+
+// WithExpectedSchemaHash returns the validated changes, to be applied only if the hash of the
+// existing schema, as returned by SchemaHash, matches that given.
+func (vsc *ValidatedSchemaChanges) WithExpectedSchemaHash(schemaHash string) *ValidatedSchemaChanges {
+	withHash := *vsc
+	withHash.expectedSchemaHash = schemaHash
+	return &withHash
+}
+
+// WithRelationshipsDeletedAfter returns the validated changes, to be applied even if relationships
+// matching the given filters would be left without associated schema, as the writer deletes them
+// once the changes are applied. Other relationships are still checked, by reading all of those
+// matched by each check.
+func (vsc *ValidatedSchemaChanges) WithRelationshipsDeletedAfter(filters ...datastore.RelationshipsFilter) *ValidatedSchemaChanges {
+	withDeleted := *vsc
+	withDeleted.deletedRelationships = filters
+	return &withDeleted
+}
+
+// SchemaHash returns a hash identifying the schema formed by the given caveat and object
+// definitions, regardless of their order.
+func SchemaHash(caveatDefs []*core.CaveatDefinition, objectDefs []*core.NamespaceDefinition) (string, error) {
+	caveatDefs = slices.Clone(caveatDefs)
+	slices.SortFunc(caveatDefs, func(a, b *core.CaveatDefinition) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	objectDefs = slices.Clone(objectDefs)
+	slices.SortFunc(objectDefs, func(a, b *core.NamespaceDefinition) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	schemaDefinitions := make([]compiler.SchemaDefinition, 0, len(caveatDefs)+len(objectDefs))
+	for _, caveatDef := range caveatDefs {
+		schemaDefinitions = append(schemaDefinitions, caveatDef)
+	}
+	for _, objectDef := range objectDefs {
+		schemaDefinitions = append(schemaDefinitions, objectDef)
+	}
+
+	schemaText, _, err := generator.GenerateSchema(schemaDefinitions)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256([]byte(schemaText))
+	return hex.EncodeToString(hash[:]), nil
 }
 
 // ValidateSchemaChanges validates the schema found in the compiled schema and returns a
@@ -88,6 +147,12 @@ type AppliedSchemaChanges struct {
 
 	// RemovedCaveatDefNames contains the names of the removed caveat definitions.
 	RemovedCaveatDefNames []string
+
+	// PreviousSchemaHash is the hash of the schema before the changes were applied.
+	PreviousSchemaHash string
+
+	// SchemaHash is the hash of the schema after the changes were applied.
+	SchemaHash string
 }
 
 // ApplySchemaChanges applies schema changes found in the validated changes struct, via the specified
@@ -115,6 +180,17 @@ func ApplySchemaChangesOverExisting(
 	existingCaveats []*core.CaveatDefinition,
 	existingObjectDefs []*core.NamespaceDefinition,
 ) (*AppliedSchemaChanges, error) {
+	// Ensure the existing schema is that expected by the writer, if any. As the existing definitions
+	// were read in this transaction, the check holds until the changes are committed.
+	previousSchemaHash, err := SchemaHash(existingCaveats, existingObjectDefs)
+	if err != nil {
+		return nil, err
+	}
+
+	if validated.expectedSchemaHash != "" && validated.expectedSchemaHash != previousSchemaHash {
+		return nil, NewSchemaChangedError(validated.expectedSchemaHash, previousSchemaHash)
+	}
+
 	// Build a map of existing caveats to determine those being removed, if any.
 	existingCaveatDefMap := make(map[string]*core.CaveatDefinition, len(existingCaveats))
 	existingCaveatDefNames := mapz.NewSet[string]()
@@ -151,7 +227,7 @@ func ApplySchemaChangesOverExisting(
 	// breaking changes.
 	objectDefsWithChanges := make([]*core.NamespaceDefinition, 0, len(validated.compiled.ObjectDefinitions))
 	for _, nsdef := range validated.compiled.ObjectDefinitions {
-		diff, err := sanityCheckNamespaceChanges(ctx, rwt, nsdef, existingObjectDefMap)
+		diff, err := sanityCheckNamespaceChanges(ctx, rwt, nsdef, existingObjectDefMap, validated.deletedRelationships)
 		if err != nil {
 			return nil, err
 		}
@@ -183,7 +259,7 @@ func ApplySchemaChangesOverExisting(
 	removedObjectDefNames := existingObjectDefNames.Subtract(validated.newObjectDefNames)
 	if !validated.additiveOnly {
 		if err := removedObjectDefNames.ForEach(func(nsdefName string) error {
-			return ensureNoRelationshipsExist(ctx, rwt, nsdefName)
+			return ensureNoRelationshipsExist(ctx, rwt, nsdefName, validated.deletedRelationships)
 		}); err != nil {
 			return nil, err
 		}
@@ -219,6 +295,23 @@ func ApplySchemaChangesOverExisting(
 		}
 	}
 
+	// Definitions being removed are retained in additive-only mode.
+	resultingCaveats := slices.Clone(validated.compiled.CaveatDefinitions)
+	resultingObjectDefs := slices.Clone(validated.compiled.ObjectDefinitions)
+	if validated.additiveOnly {
+		for _, name := range removedCaveatDefNames.AsSlice() {
+			resultingCaveats = append(resultingCaveats, existingCaveatDefMap[name])
+		}
+		for _, name := range removedObjectDefNames.AsSlice() {
+			resultingObjectDefs = append(resultingObjectDefs, existingObjectDefMap[name])
+		}
+	}
+
+	schemaHash, err := SchemaHash(resultingCaveats, resultingObjectDefs)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Ctx(ctx).Trace().
 		Interface("objectDefinitions", validated.compiled.ObjectDefinitions).
 		Interface("caveatDefinitions", validated.compiled.CaveatDefinitions).
@@ -234,6 +327,8 @@ func ApplySchemaChangesOverExisting(
 		RemovedObjectDefNames: removedObjectDefNames.AsSlice(),
 		NewCaveatDefNames:     validated.newCaveatDefNames.Subtract(existingCaveatDefNames).AsSlice(),
 		RemovedCaveatDefNames: removedCaveatDefNames.AsSlice(),
+		PreviousSchemaHash:    previousSchemaHash,
+		SchemaHash:            schemaHash,
 	}, nil
 }
 
@@ -252,54 +347,109 @@ func sanityCheckCaveatChanges(
 		return nil, err
 	}
 
+	if issues := caveatChangeIssues(caveatDef.Name, diff); len(issues) > 0 {
+		return diff, issues[0]
+	}
+
+	return diff, nil
+}
+
+// caveatChangeIssues returns the changes of a caveat which would break the types of the
+// parameters that may already exist on relationships.
+func caveatChangeIssues(caveatName string, diff *caveatdiff.Diff) []error {
+	var issues []error
 	for _, delta := range diff.Deltas() {
 		switch delta.Type {
 		case caveatdiff.RemovedParameter:
-			return diff, NewSchemaWriteDataValidationError("cannot remove parameter `%s` on caveat `%s`", delta.ParameterName, caveatDef.Name)
+			issues = append(issues, NewSchemaWriteDataValidationError("cannot remove parameter `%s` on caveat `%s`", delta.ParameterName, caveatName))
 
 		case caveatdiff.ParameterTypeChanged:
-			return diff, NewSchemaWriteDataValidationError("cannot change the type of parameter `%s` on caveat `%s`", delta.ParameterName, caveatDef.Name)
+			issues = append(issues, NewSchemaWriteDataValidationError("cannot change the type of parameter `%s` on caveat `%s`", delta.ParameterName, caveatName))
 		}
 	}
+	return issues
+}
 
-	return diff, nil
+// relationshipCheck is a check that a schema change does not leave relationships without
+// associated schema: the check fails if any relationship matches its filter.
+type relationshipCheck struct {
+	// issue describes the failure of the check.
+	issue string
+
+	// affected describes the relationships matched by the check.
+	affected AffectedRelationships
+
+	filter         datastore.RelationshipsFilter
+	subjectsFilter *datastore.SubjectsFilter
 }
 
-// ensureNoRelationshipsExist ensures that no relationships exist within the namespace with the given name.
-func ensureNoRelationshipsExist(ctx context.Context, rwt datastore.ReadWriteTransaction, namespaceName string) error {
-	qy, qyErr := rwt.QueryRelationships(
-		ctx,
-		datastore.RelationshipsFilter{OptionalResourceType: namespaceName},
-		options.WithLimit(options.LimitOne),
-	)
-	if err := errorIfTupleIteratorReturnsTuples(
-		ctx,
-		qy,
-		qyErr,
-		"cannot delete object definition `%s`, as a relationship exists under it",
-		namespaceName,
-	); err != nil {
-		return err
-	}
-
-	qy, qyErr = rwt.ReverseQueryRelationships(ctx, datastore.SubjectsFilter{
-		SubjectType: namespaceName,
-	}, options.WithLimitForReverse(options.LimitOne))
-	err := errorIfTupleIteratorReturnsTuples(
-		ctx,
-		qy,
-		qyErr,
-		"cannot delete object definition `%s`, as a relationship references it",
-		namespaceName,
-	)
-	qy.Close()
-	if err != nil {
-		return err
+// query returns the relationships matched by the check, up to the given limit. A limit of zero
+// returns all of the relationships matched.
+func (rc relationshipCheck) query(ctx context.Context, reader datastore.Reader, limit uint64) (datastore.RelationshipIterator, error) {
+	if rc.subjectsFilter != nil {
+		if limit == 0 {
+			return reader.ReverseQueryRelationships(ctx, *rc.subjectsFilter)
+		}
+		return reader.ReverseQueryRelationships(ctx, *rc.subjectsFilter, options.WithLimitForReverse(&limit))
 	}
 
+	if limit == 0 {
+		return reader.QueryRelationships(ctx, rc.filter)
+	}
+	return reader.QueryRelationships(ctx, rc.filter, options.WithLimit(&limit))
+}
+
+// ensureNoRelationshipsMatch runs the checks, returning an error for the first which fails.
+// Relationships matching any of the deleted filters are ignored.
+func ensureNoRelationshipsMatch(ctx context.Context, reader datastore.Reader, checks []relationshipCheck, deleted []datastore.RelationshipsFilter) error {
+	for _, check := range checks {
+		if len(deleted) == 0 {
+			qy, qyErr := check.query(ctx, reader, 1)
+			if err := errorIfTupleIteratorReturnsTuples(ctx, qy, qyErr, "%s", check.issue); err != nil {
+				return err
+			}
+			continue
+		}
+
+		qy, qyErr := check.query(ctx, reader, 0)
+		if err := errorIfTupleIteratorReturnsTuplesNotDeleted(qy, qyErr, deleted, check.issue); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// ensureNoRelationshipsExist ensures that no relationships exist within the namespace with the given name,
+// other than those matching the deleted filters.
+func ensureNoRelationshipsExist(ctx context.Context, rwt datastore.ReadWriteTransaction, namespaceName string, deleted []datastore.RelationshipsFilter) error {
+	return ensureNoRelationshipsMatch(ctx, rwt, removedNamespaceChecks(namespaceName), deleted)
+}
+
+// removedNamespaceChecks returns the checks that no relationships exist within the namespace with
+// the given name.
+func removedNamespaceChecks(namespaceName string) []relationshipCheck {
+	return []relationshipCheck{
+		{
+			issue: fmt.Sprintf("cannot delete object definition `%s`, as a relationship exists under it", namespaceName),
+			affected: AffectedRelationships{
+				Change:     string(nsdiff.NamespaceRemoved),
+				Definition: namespaceName,
+				Direction:  AffectedAsResource,
+			},
+			filter: datastore.RelationshipsFilter{OptionalResourceType: namespaceName},
+		},
+		{
+			issue: fmt.Sprintf("cannot delete object definition `%s`, as a relationship references it", namespaceName),
+			affected: AffectedRelationships{
+				Change:     string(nsdiff.NamespaceRemoved),
+				Definition: namespaceName,
+				Direction:  AffectedAsSubject,
+			},
+			subjectsFilter: &datastore.SubjectsFilter{SubjectType: namespaceName},
+		},
+	}
+}
+
 // sanityCheckNamespaceChanges ensures that a namespace definition being written does not result
 // in breaking changes, such as relationships without associated defined schema object definitions
 // and relations.
@@ -308,6 +458,7 @@ func sanityCheckNamespaceChanges(
 	rwt datastore.ReadWriteTransaction,
 	nsdef *core.NamespaceDefinition,
 	existingDefs map[string]*core.NamespaceDefinition,
+	deleted []datastore.RelationshipsFilter,
 ) (*nsdiff.Diff, error) {
 	// Ensure that the updated namespace does not break the existing tuple data.
 	existing := existingDefs[nsdef.Name]
@@ -316,39 +467,46 @@ func sanityCheckNamespaceChanges(
 		return nil, err
 	}
 
+	return diff, ensureNoRelationshipsMatch(ctx, rwt, namespaceChangeChecks(nsdef.Name, diff), deleted)
+}
+
+// namespaceChangeChecks returns the checks that the changes to a namespace do not leave
+// relationships without associated relations or allowed types.
+func namespaceChangeChecks(namespaceName string, diff *nsdiff.Diff) []relationshipCheck {
+	var checks []relationshipCheck
 	for _, delta := range diff.Deltas() {
 		switch delta.Type {
 		case nsdiff.RemovedRelation:
-			qy, qyErr := rwt.QueryRelationships(ctx, datastore.RelationshipsFilter{
-				OptionalResourceType:     nsdef.Name,
-				OptionalResourceRelation: delta.RelationName,
+			checks = append(checks, relationshipCheck{
+				issue: fmt.Sprintf("cannot delete relation `%s` in object definition `%s`, as a relationship exists under it", delta.RelationName, namespaceName),
+				affected: AffectedRelationships{
+					Change:     string(delta.Type),
+					Definition: namespaceName,
+					Relation:   delta.RelationName,
+					Direction:  AffectedAsResource,
+				},
+				filter: datastore.RelationshipsFilter{
+					OptionalResourceType:     namespaceName,
+					OptionalResourceRelation: delta.RelationName,
+				},
 			})
 
-			err = errorIfTupleIteratorReturnsTuples(
-				ctx,
-				qy,
-				qyErr,
-				"cannot delete relation `%s` in object definition `%s`, as a relationship exists under it", delta.RelationName, nsdef.Name)
-			if err != nil {
-				return diff, err
-			}
-
 			// Also check for right sides of tuples.
-			qy, qyErr = rwt.ReverseQueryRelationships(ctx, datastore.SubjectsFilter{
-				SubjectType: nsdef.Name,
-				RelationFilter: datastore.SubjectRelationFilter{
-					NonEllipsisRelation: delta.RelationName,
+			checks = append(checks, relationshipCheck{
+				issue: fmt.Sprintf("cannot delete relation `%s` in object definition `%s`, as a relationship references it", delta.RelationName, namespaceName),
+				affected: AffectedRelationships{
+					Change:     string(delta.Type),
+					Definition: namespaceName,
+					Relation:   delta.RelationName,
+					Direction:  AffectedAsSubject,
 				},
-			}, options.WithLimitForReverse(options.LimitOne))
-			err = errorIfTupleIteratorReturnsTuples(
-				ctx,
-				qy,
-				qyErr,
-				"cannot delete relation `%s` in object definition `%s`, as a relationship references it", delta.RelationName, nsdef.Name)
-			qy.Close()
-			if err != nil {
-				return diff, err
-			}
+				subjectsFilter: &datastore.SubjectsFilter{
+					SubjectType: namespaceName,
+					RelationFilter: datastore.SubjectRelationFilter{
+						NonEllipsisRelation: delta.RelationName,
+					},
+				},
+			})
 
 		case nsdiff.RelationAllowedTypeRemoved:
 			var optionalSubjectIds []string
@@ -367,10 +525,19 @@ func sanityCheckNamespaceChanges(
 				optionalCaveatName = delta.AllowedType.GetRequiredCaveat().CaveatName
 			}
 
-			qyr, qyrErr := rwt.QueryRelationships(
-				ctx,
-				datastore.RelationshipsFilter{
-					OptionalResourceType:     nsdef.Name,
+			allowedType := typesystem.SourceForAllowedRelation(delta.AllowedType)
+			checks = append(checks, relationshipCheck{
+				issue: fmt.Sprintf("cannot remove allowed type `%s` from relation `%s` in object definition `%s`, as a relationship exists with it",
+					allowedType, delta.RelationName, namespaceName),
+				affected: AffectedRelationships{
+					Change:      string(delta.Type),
+					Definition:  namespaceName,
+					Relation:    delta.RelationName,
+					AllowedType: allowedType,
+					Direction:   AffectedAsResource,
+				},
+				filter: datastore.RelationshipsFilter{
+					OptionalResourceType:     namespaceName,
 					OptionalResourceRelation: delta.RelationName,
 					OptionalSubjectsSelectors: []datastore.SubjectsSelector{
 						{
@@ -381,21 +548,10 @@ func sanityCheckNamespaceChanges(
 					},
 					OptionalCaveatName: optionalCaveatName,
 				},
-				options.WithLimit(options.LimitOne),
-			)
-			err = errorIfTupleIteratorReturnsTuples(
-				ctx,
-				qyr,
-				qyrErr,
-				"cannot remove allowed type `%s` from relation `%s` in object definition `%s`, as a relationship exists with it",
-				typesystem.SourceForAllowedRelation(delta.AllowedType), delta.RelationName, nsdef.Name)
-			qyr.Close()
-			if err != nil {
-				return diff, err
-			}
+			})
 		}
 	}
-	return diff, nil
+	return checks
 }
 
 // errorIfTupleIteratorReturnsTuples takes a tuple iterator and any error that was generated
@@ -415,3 +571,23 @@ func errorIfTupleIteratorReturnsTuples(_ context.Context, qy datastore.Relations
 	}
 	return nil
 }
+
+// errorIfTupleIteratorReturnsTuplesNotDeleted returns an error if the iterator contains any tuple
+// not matched by the deleted filters, closing the iterator.
+func errorIfTupleIteratorReturnsTuplesNotDeleted(qy datastore.RelationshipIterator, qyErr error, deleted []datastore.RelationshipsFilter, message string) error {
+	if qyErr != nil {
+		return qyErr
+	}
+	defer qy.Close()
+
+	for rt := qy.Next(); rt != nil; rt = qy.Next() {
+		if qy.Err() != nil {
+			return qy.Err()
+		}
+
+		if !slices.ContainsFunc(deleted, func(filter datastore.RelationshipsFilter) bool { return filter.Test(rt) }) {
+			return NewSchemaWriteDataValidationError("%s", message)
+		}
+	}
+	return qy.Err()
+}
@@ -9,8 +9,10 @@ import (
 	"github.com/zapravila/spicedb/internal/datastore/memdb"
 	"github.com/zapravila/spicedb/internal/testfixtures"
 	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
 	"github.com/zapravila/spicedb/pkg/schemadsl/compiler"
 	"github.com/zapravila/spicedb/pkg/schemadsl/input"
+	"github.com/zapravila/spicedb/pkg/tuple"
 )
 
 func TestApplySchemaChanges(t *testing.T) {
@@ -72,3 +74,101 @@ func TestApplySchemaChanges(t *testing.T) {
 	})
 	require.NoError(err)
 }
+
+func TestApplySchemaChangesExpectedSchemaHash(t *testing.T) {
+	require := require.New(t)
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(err)
+
+	ds, _ := testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, `
+		definition user {}
+
+		definition document {
+			relation viewer: user
+		}
+	`, nil, require)
+
+	compiled, err := compiler.Compile(compiler.InputSchema{
+		Source: input.Source("schema"),
+		SchemaString: `
+			definition user {}
+
+			definition document {
+				relation viewer: user
+				relation editor: user
+			}
+		`,
+	}, compiler.AllowUnprefixedObjectType())
+	require.NoError(err)
+
+	// The hash does not depend on the order of the definitions.
+	expectedHash, err := SchemaHash(nil, []*core.NamespaceDefinition{compiled.ObjectDefinitions[1], compiled.ObjectDefinitions[0]})
+	require.NoError(err)
+
+	validated, err := ValidateSchemaChanges(context.Background(), compiled, false)
+	require.NoError(err)
+
+	_, err = ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		_, err := ApplySchemaChanges(ctx, rwt, validated.WithExpectedSchemaHash(expectedHash))
+		return err
+	})
+	require.ErrorAs(err, &ErrSchemaChanged{})
+
+	_, err = ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		applied, err := ApplySchemaChanges(ctx, rwt, validated)
+		require.NoError(err)
+		require.Equal(expectedHash, applied.SchemaHash)
+		require.NotEqual(expectedHash, applied.PreviousSchemaHash)
+
+		_, err = ApplySchemaChanges(ctx, rwt, validated.WithExpectedSchemaHash(expectedHash))
+		return err
+	})
+	require.NoError(err)
+}
+
+func TestApplySchemaChangesWithRelationshipsDeletedAfter(t *testing.T) {
+	require := require.New(t)
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(err)
+
+	ds, _ := testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, `
+		definition user {}
+
+		definition document {
+			relation viewer: user
+			relation editor: user
+		}
+	`, []*core.RelationTuple{
+		tuple.MustParse("document:first#viewer@user:tom"),
+		tuple.MustParse("document:first#editor@user:fred"),
+	}, require)
+
+	compiled, err := compiler.Compile(compiler.InputSchema{
+		Source: input.Source("schema"),
+		SchemaString: `
+			definition user {}
+
+			definition document {}
+		`,
+	}, compiler.AllowUnprefixedObjectType())
+	require.NoError(err)
+
+	validated, err := ValidateSchemaChanges(context.Background(), compiled, false)
+	require.NoError(err)
+
+	viewers := datastore.RelationshipsFilter{OptionalResourceType: "document", OptionalResourceRelation: "viewer"}
+	editors := datastore.RelationshipsFilter{OptionalResourceType: "document", OptionalResourceRelation: "editor"}
+
+	// Relationships not deleted after are still checked.
+	_, err = ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		_, err := ApplySchemaChanges(ctx, rwt, validated.WithRelationshipsDeletedAfter(viewers))
+		return err
+	})
+	require.ErrorContains(err, "cannot delete relation `editor`")
+
+	_, err = ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		_, err := ApplySchemaChanges(ctx, rwt, validated.WithRelationshipsDeletedAfter(viewers, editors))
+		return err
+	})
+	require.NoError(err)
+}
@@ -0,0 +1,350 @@
+package shared
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/zapravila/spicedb/internal/namespace"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	caveatdiff "github.com/zapravila/spicedb/pkg/diff/caveats"
+	nsdiff "github.com/zapravila/spicedb/pkg/diff/namespace"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	nspkg "github.com/zapravila/spicedb/pkg/namespace"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	iv1 "github.com/zapravila/spicedb/pkg/proto/impl/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/typesystem"
+)
+
+const (
+	// AffectedAsResource indicates that the affected relationships are those whose resource is
+	// under the removed definition or relation.
+	AffectedAsResource = "resource"
+
+	// AffectedAsSubject indicates that the affected relationships are those whose subject
+	// references the removed definition or relation.
+	AffectedAsSubject = "subject"
+)
+
+// SchemaChangeReport is the report of the impact of schema changes on the existing schema and
+// relationships, as determined by DryRunSchemaChanges.
+type SchemaChangeReport struct {
+	// BlockingIssues are the issues which would cause the schema changes to be rejected. Empty if
+	// the changes can be applied.
+	BlockingIssues []string `json:"blockingIssues"`
+
+	// AffectedRelationships are the counts of the relationships under or referencing each
+	// definition, relation and allowed type removed.
+	AffectedRelationships []AffectedRelationships `json:"affectedRelationships"`
+
+	// ChangedPermissions are the existing permissions, as `definition#permission`, whose computed
+	// results may change, including those removed.
+	ChangedPermissions []string `json:"changedPermissions"`
+
+	// Deltas are the changes made to the definitions and caveats of the schema.
+	Deltas []SchemaDelta `json:"deltas"`
+
+	// Truncated is true if entries were omitted from the report to limit its size.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// AffectedRelationships is the count of the relationships affected by the removal of a definition,
+// relation or allowed type.
+type AffectedRelationships struct {
+	// Change is the type of the delta removing the definition, relation or allowed type.
+	Change string `json:"change"`
+
+	Definition  string `json:"definition"`
+	Relation    string `json:"relation,omitempty"`
+	AllowedType string `json:"allowedType,omitempty"`
+
+	// Direction is AffectedAsResource or AffectedAsSubject.
+	Direction string `json:"direction"`
+
+	// Count is the number of relationships affected.
+	Count uint64 `json:"count"`
+
+	// CountLimited is true if counting stopped at the maximum count, in which case Count is a
+	// lower bound.
+	CountLimited bool `json:"countLimited,omitempty"`
+}
+
+// SchemaDelta is a single change made to a definition or caveat of the schema.
+type SchemaDelta struct {
+	// Definition is the name of the object definition changed, if any.
+	Definition string `json:"definition,omitempty"`
+
+	// Caveat is the name of the caveat changed, if any.
+	Caveat string `json:"caveat,omitempty"`
+
+	// Type is the type of the delta, as defined in pkg/diff.
+	Type string `json:"type"`
+
+	Relation    string `json:"relation,omitempty"`
+	AllowedType string `json:"allowedType,omitempty"`
+	Parameter   string `json:"parameter,omitempty"`
+}
+
+// DryRunSchemaChanges determines the impact of the validated schema changes on the schema and
+// relationships found via the reader, performing all the checks made when applying them, without
+// applying them. Relationships are counted up to maximumCount for each check.
+func DryRunSchemaChanges(ctx context.Context, reader datastore.Reader, validated *ValidatedSchemaChanges, maximumCount uint64) (*SchemaChangeReport, error) {
+	existingCaveats, err := reader.ListAllCaveats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	existingObjectDefs, err := reader.ListAllNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SchemaChangeReport{
+		BlockingIssues:        []string{},
+		AffectedRelationships: []AffectedRelationships{},
+		ChangedPermissions:    []string{},
+		Deltas:                []SchemaDelta{},
+	}
+
+	if validated.expectedSchemaHash != "" {
+		schemaHash, err := SchemaHash(datastore.DefinitionsOf(existingCaveats), datastore.DefinitionsOf(existingObjectDefs))
+		if err != nil {
+			return nil, err
+		}
+
+		if schemaHash != validated.expectedSchemaHash {
+			report.BlockingIssues = append(report.BlockingIssues, NewSchemaChangedError(validated.expectedSchemaHash, schemaHash).Error())
+		}
+	}
+
+	// Diff the caveats.
+	existingCaveatDefMap := make(map[string]*core.CaveatDefinition, len(existingCaveats))
+	for _, existingCaveat := range existingCaveats {
+		existingCaveatDefMap[existingCaveat.Definition.Name] = existingCaveat.Definition
+	}
+
+	changedCaveatNames := mapz.NewSet[string]()
+	caveatDiffs := make(map[string]*caveatdiff.Diff, len(existingCaveats))
+	for _, caveatDef := range validated.compiled.CaveatDefinitions {
+		diff, err := caveatdiff.DiffCaveats(existingCaveatDefMap[caveatDef.Name], caveatDef)
+		if err != nil {
+			return nil, err
+		}
+
+		caveatDiffs[caveatDef.Name] = diff
+		for _, issue := range caveatChangeIssues(caveatDef.Name, diff) {
+			report.BlockingIssues = append(report.BlockingIssues, issue.Error())
+		}
+	}
+
+	for _, existingCaveat := range existingCaveats {
+		name := existingCaveat.Definition.Name
+		if !validated.newCaveatDefNames.Has(name) && !validated.additiveOnly {
+			diff, err := caveatdiff.DiffCaveats(existingCaveat.Definition, nil)
+			if err != nil {
+				return nil, err
+			}
+			caveatDiffs[name] = diff
+		}
+	}
+
+	for name, diff := range caveatDiffs {
+		for _, delta := range diff.Deltas() {
+			report.Deltas = append(report.Deltas, SchemaDelta{
+				Caveat:    name,
+				Type:      string(delta.Type),
+				Parameter: delta.ParameterName,
+			})
+
+			if delta.Type != caveatdiff.CaveatAdded && delta.Type != caveatdiff.CaveatCommentsChanged {
+				changedCaveatNames.Add(name)
+			}
+		}
+	}
+
+	// Diff the object definitions, collecting the checks against the existing relationships.
+	existingObjectDefMap := make(map[string]*core.NamespaceDefinition, len(existingObjectDefs))
+	for _, existingDef := range existingObjectDefs {
+		existingObjectDefMap[existingDef.Definition.Name] = existingDef.Definition
+	}
+
+	var checks []relationshipCheck
+	changedRelations := mapz.NewSet[string]()
+	removedPermissions := mapz.NewSet[string]()
+	namespaceDiffs := make(map[string]*nsdiff.Diff, len(existingObjectDefs))
+	for _, nsdef := range validated.compiled.ObjectDefinitions {
+		diff, err := nsdiff.DiffNamespaces(existingObjectDefMap[nsdef.Name], nsdef)
+		if err != nil {
+			return nil, err
+		}
+
+		namespaceDiffs[nsdef.Name] = diff
+		checks = append(checks, namespaceChangeChecks(nsdef.Name, diff)...)
+
+		if len(diff.Deltas()) > 0 {
+			if err := namespace.AnnotateNamespace(validated.validatedTypeSystems[nsdef.Name]); err != nil {
+				report.BlockingIssues = append(report.BlockingIssues, err.Error())
+			}
+		}
+	}
+
+	for _, existingDef := range existingObjectDefs {
+		name := existingDef.Definition.Name
+		if !validated.newObjectDefNames.Has(name) && !validated.additiveOnly {
+			diff, err := nsdiff.DiffNamespaces(existingDef.Definition, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			namespaceDiffs[name] = diff
+			checks = append(checks, removedNamespaceChecks(name)...)
+			for _, relation := range existingDef.Definition.Relation {
+				if nspkg.GetRelationKind(relation) == iv1.RelationMetadata_PERMISSION {
+					removedPermissions.Add(tuple.JoinRelRef(name, relation.Name))
+				}
+			}
+		}
+	}
+
+	for name, diff := range namespaceDiffs {
+		for _, delta := range diff.Deltas() {
+			schemaDelta := SchemaDelta{
+				Definition: name,
+				Type:       string(delta.Type),
+				Relation:   delta.RelationName,
+			}
+			if delta.AllowedType != nil {
+				schemaDelta.AllowedType = typesystem.SourceForAllowedRelation(delta.AllowedType)
+			}
+			report.Deltas = append(report.Deltas, schemaDelta)
+
+			switch delta.Type {
+			case nsdiff.RemovedPermission:
+				removedPermissions.Add(tuple.JoinRelRef(name, delta.RelationName))
+
+			case nsdiff.AddedRelation, nsdiff.RemovedRelation, nsdiff.AddedPermission,
+				nsdiff.ChangedPermissionImpl, nsdiff.LegacyChangedRelationImpl,
+				nsdiff.RelationAllowedTypeAdded, nsdiff.RelationAllowedTypeRemoved:
+				changedRelations.Add(tuple.JoinRelRef(name, delta.RelationName))
+			}
+		}
+	}
+
+	// Count the relationships matched by each check: any relationship found blocks the changes.
+	for _, check := range checks {
+		affected := check.affected
+		affected.Count, affected.CountLimited, err = countMatchingRelationships(ctx, reader, check, maximumCount)
+		if err != nil {
+			return nil, err
+		}
+
+		report.AffectedRelationships = append(report.AffectedRelationships, affected)
+		if affected.Count > 0 {
+			report.BlockingIssues = append(report.BlockingIssues, check.issue)
+		}
+	}
+
+	changedPermissions, err := changedPermissions(ctx, validated, existingObjectDefMap, changedRelations, changedCaveatNames)
+	if err != nil {
+		return nil, err
+	}
+	report.ChangedPermissions = append(changedPermissions, removedPermissions.AsSlice()...)
+
+	slices.Sort(report.ChangedPermissions)
+	slices.SortStableFunc(report.Deltas, func(a, b SchemaDelta) int {
+		if a.Caveat != b.Caveat {
+			return cmp.Compare(a.Caveat, b.Caveat)
+		}
+		return cmp.Compare(a.Definition, b.Definition)
+	})
+	return report, nil
+}
+
+// countMatchingRelationships counts the relationships matched by the check, up to the maximum
+// count, returning whether counting stopped at the maximum.
+func countMatchingRelationships(ctx context.Context, reader datastore.Reader, check relationshipCheck, maximumCount uint64) (uint64, bool, error) {
+	qy, err := check.query(ctx, reader, maximumCount+1)
+	if err != nil {
+		return 0, false, err
+	}
+	defer qy.Close()
+
+	var count uint64
+	for rel := qy.Next(); rel != nil; rel = qy.Next() {
+		if count == maximumCount {
+			return count, true, nil
+		}
+		count++
+	}
+	return count, false, qy.Err()
+}
+
+// changedPermissions returns the permissions of the changed schema which existed before, and
+// whose computed results depend on any of the changed relations, or on relations allowing any of
+// the changed caveats.
+func changedPermissions(
+	ctx context.Context,
+	validated *ValidatedSchemaChanges,
+	existingObjectDefs map[string]*core.NamespaceDefinition,
+	changedRelations *mapz.Set[string],
+	changedCaveatNames *mapz.Set[string],
+) ([]string, error) {
+	for _, nsdef := range validated.compiled.ObjectDefinitions {
+		for _, relation := range nsdef.Relation {
+			for _, allowedType := range relation.GetTypeInformation().GetAllowedDirectRelations() {
+				if changedCaveatNames.Has(allowedType.GetRequiredCaveat().GetCaveatName()) {
+					changedRelations.Add(tuple.JoinRelRef(nsdef.Name, relation.Name))
+				}
+			}
+		}
+	}
+
+	changed := []string{}
+	if changedRelations.IsEmpty() {
+		return changed, nil
+	}
+
+	for _, nsdef := range validated.compiled.ObjectDefinitions {
+		existingDef, ok := existingObjectDefs[nsdef.Name]
+		if !ok {
+			continue
+		}
+
+		existingPermissions := mapz.NewSet[string]()
+		for _, relation := range existingDef.Relation {
+			if nspkg.GetRelationKind(relation) == iv1.RelationMetadata_PERMISSION {
+				existingPermissions.Add(relation.Name)
+			}
+		}
+
+		vts := validated.validatedTypeSystems[nsdef.Name]
+		rg := typesystem.ReachabilityGraphFor(vts)
+		for _, relation := range nsdef.Relation {
+			if !vts.IsPermission(relation.Name) || !existingPermissions.Has(relation.Name) {
+				continue
+			}
+
+			permission := tuple.JoinRelRef(nsdef.Name, relation.Name)
+			if changedRelations.Has(permission) {
+				changed = append(changed, permission)
+				continue
+			}
+
+			encountered, err := rg.RelationsEncounteredForResource(ctx, &core.RelationReference{
+				Namespace: nsdef.Name,
+				Relation:  relation.Name,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if slices.ContainsFunc(encountered, func(rr *core.RelationReference) bool {
+				return changedRelations.Has(tuple.JoinRelRef(rr.Namespace, rr.Relation))
+			}) {
+				changed = append(changed, permission)
+			}
+		}
+	}
+	return changed, nil
+}
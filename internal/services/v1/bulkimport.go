@@ -0,0 +1,414 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"strconv"
+
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/internal/middleware/usagemetrics"
+	"github.com/zapravila/spicedb/internal/relationships"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	dispatchv1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	// RequestBulkImportTouch, if specified in a request header on ImportBulkRelationships or
+	// BulkImportRelationships, imports the relationships with TOUCH semantics: relationships which
+	// already exist are updated or skipped, rather than failing the import. The relationships are
+	// committed in chunks, so that an interrupted import can be resumed with its token, given
+	// in the response trailer under BulkImportToken.
+	// Value: `1`
+	RequestBulkImportTouch requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestbulkimporttouch"
+
+	// BulkImportTokenHeader is the request header in which the token of an interrupted TOUCH import
+	// is given to resume it, on any node sharing the preshared key. The same relationships must be
+	// sent again, in the same order: those committed by the interrupted import are verified and
+	// skipped.
+	BulkImportTokenHeader = "io.spicedb.bulkimporttoken"
+
+	// BulkImportToken is the key in the response trailer metadata holding the token with which an
+	// interrupted TOUCH import can be resumed, if any of its relationships were committed. The
+	// token holds the progress of the import, signed by the server. If the trailer is not
+	// received, such as when the connection is lost, the import can instead be restarted, as the
+	// relationships already committed are then skipped as unchanged.
+	BulkImportToken responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.bulkimporttoken"
+
+	// BulkImportCreated is the key in the response trailer metadata holding the number of
+	// relationships created by a TOUCH import.
+	BulkImportCreated responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.bulkimportcreated"
+
+	// BulkImportUpdated is the key in the response trailer metadata holding the number of existing
+	// relationships whose caveat was updated by a TOUCH import.
+	BulkImportUpdated responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.bulkimportupdated"
+
+	// BulkImportSkipped is the key in the response trailer metadata holding the number of
+	// relationships skipped by a TOUCH import, as they already existed unchanged.
+	BulkImportSkipped responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.bulkimportskipped"
+)
+
+// bulkImportTokenVersion is the version of the encoding of bulk import tokens.
+const bulkImportTokenVersion = 1
+
+func isBulkImportTouchRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestBulkImportTouch)]
+	return found
+}
+
+// bulkImportCounts are the numbers of relationships created, updated and skipped by an import.
+type bulkImportCounts struct {
+	created uint64
+	updated uint64
+	skipped uint64
+}
+
+func (c bulkImportCounts) total() uint64 {
+	return c.created + c.updated + c.skipped
+}
+
+// bulkImportState is the progress of a TOUCH import, as held by its token.
+type bulkImportState struct {
+	// committed is the number of relationships of the import which have been committed.
+	committed uint64
+
+	// digest is the digest of the committed relationships, used to verify that a resumed import
+	// sends the same relationships.
+	digest []byte
+
+	counts bulkImportCounts
+}
+
+// newBulkImportTokensKey returns a random key for signing bulk import tokens, for use when none
+// was configured. Tokens signed with such a key are only accepted by this process.
+func newBulkImportTokensKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("could not generate bulk import tokens key: " + err.Error())
+	}
+	return key
+}
+
+// encodeBulkImportToken encodes the progress of an import into a token signed with the key, so
+// that the import can be resumed on any node sharing the key.
+func encodeBulkImportToken(key []byte, state bulkImportState) string {
+	payload := make([]byte, 0, 1+4*binary.MaxVarintLen64+sha256.Size)
+	payload = append(payload, bulkImportTokenVersion)
+	payload = binary.AppendUvarint(payload, state.committed)
+	payload = binary.AppendUvarint(payload, state.counts.created)
+	payload = binary.AppendUvarint(payload, state.counts.updated)
+	payload = binary.AppendUvarint(payload, state.counts.skipped)
+	payload = append(payload, state.digest...)
+
+	return base64.RawURLEncoding.EncodeToString(append(signBulkImportPayload(key, payload), payload...))
+}
+
+// decodeBulkImportToken decodes a token produced by encodeBulkImportToken, verifying that it was
+// signed with the key.
+func decodeBulkImportToken(key []byte, token string) (bulkImportState, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) < sha256.Size+1 {
+		return bulkImportState{}, NewInvalidBulkImportTokenErr()
+	}
+
+	signature, payload := decoded[:sha256.Size], decoded[sha256.Size:]
+	if !hmac.Equal(signature, signBulkImportPayload(key, payload)) || payload[0] != bulkImportTokenVersion {
+		return bulkImportState{}, NewInvalidBulkImportTokenErr()
+	}
+
+	var state bulkImportState
+	remaining := payload[1:]
+	for _, value := range []*uint64{&state.committed, &state.counts.created, &state.counts.updated, &state.counts.skipped} {
+		decodedValue, n := binary.Uvarint(remaining)
+		if n <= 0 {
+			return bulkImportState{}, NewInvalidBulkImportTokenErr()
+		}
+		*value = decodedValue
+		remaining = remaining[n:]
+	}
+
+	if len(remaining) != sha256.Size {
+		return bulkImportState{}, NewInvalidBulkImportTokenErr()
+	}
+	state.digest = remaining
+	return state, nil
+}
+
+func signBulkImportPayload(key []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// touchImporter imports a stream of relationships with TOUCH semantics, in chunks.
+type touchImporter struct {
+	ds        datastore.Datastore
+	tokensKey []byte
+	chunkSize int
+
+	recv func() ([]*v1.Relationship, error)
+}
+
+// run imports the relationships received, returning the counts of the whole import, including
+// the parts committed before it was interrupted, if it was resumed. If interrupted, the token
+// with which to resume the import is set in the response trailer.
+func (ti *touchImporter) run(ctx context.Context) (bulkImportCounts, error) {
+	var state bulkImportState
+	if tokens := metadata.ValueFromIncomingContext(ctx, BulkImportTokenHeader); len(tokens) > 0 {
+		resumed, err := decodeBulkImportToken(ti.tokensKey, tokens[0])
+		if err != nil {
+			return bulkImportCounts{}, err
+		}
+		state = resumed
+	}
+
+	counts, committed, err := ti.importRelationships(ctx, state)
+	if err != nil {
+		if committed.committed > 0 {
+			if trailerErr := responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+				BulkImportToken: encodeBulkImportToken(ti.tokensKey, committed),
+			}); trailerErr != nil {
+				log.Ctx(ctx).Warn().Err(trailerErr).Msg("failed to set the bulk import token in the response trailer")
+			}
+		}
+		return bulkImportCounts{}, err
+	}
+	return counts, nil
+}
+
+// importRelationships imports the relationships received, resuming from the given state. Along
+// with any error, it returns the state of the relationships committed so far, from which the
+// import can be resumed.
+func (ti *touchImporter) importRelationships(ctx context.Context, state bulkImportState) (bulkImportCounts, bulkImportState, error) {
+	digest := sha256.New()
+	committed := state
+	counts := state.counts
+	var received uint64
+	var chunks uint32
+	chunk := make([]*core.RelationTuple, 0, ti.chunkSize)
+
+	commitChunk := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		chunkCounts, err := ti.commitChunk(ctx, chunk)
+		if err != nil {
+			return err
+		}
+
+		counts.created += chunkCounts.created
+		counts.updated += chunkCounts.updated
+		counts.skipped += chunkCounts.skipped
+		chunks++
+		chunk = chunk[:0]
+		committed = bulkImportState{committed: received, digest: digest.Sum(nil), counts: counts}
+		return nil
+	}
+
+	for {
+		batch, err := ti.recv()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return bulkImportCounts{}, committed, err
+		}
+
+		for _, rel := range batch {
+			if err := writeRelationshipDigest(digest, rel); err != nil {
+				return bulkImportCounts{}, committed, err
+			}
+			received++
+
+			// Relationships committed before the import was interrupted are only verified.
+			if received <= state.committed {
+				if received == state.committed && !bytes.Equal(digest.Sum(nil), state.digest) {
+					return bulkImportCounts{}, bulkImportState{}, NewBulkImportMismatchErr(state.committed)
+				}
+				continue
+			}
+
+			chunk = append(chunk, tuple.FromRelationship(rel))
+			if len(chunk) == ti.chunkSize {
+				if err := commitChunk(); err != nil {
+					return bulkImportCounts{}, committed, err
+				}
+			}
+		}
+	}
+
+	if received < state.committed {
+		return bulkImportCounts{}, bulkImportState{}, NewBulkImportMismatchErr(state.committed)
+	}
+	if err := commitChunk(); err != nil {
+		return bulkImportCounts{}, committed, err
+	}
+
+	usagemetrics.SetInContext(ctx, &dispatchv1.ResponseMeta{
+		// One request per chunk committed.
+		DispatchCount: max(chunks, 1),
+	})
+
+	return counts, committed, responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		BulkImportCreated: strconv.FormatUint(counts.created, 10),
+		BulkImportUpdated: strconv.FormatUint(counts.updated, 10),
+		BulkImportSkipped: strconv.FormatUint(counts.skipped, 10),
+	})
+}
+
+// commitChunk touches the relationships of the chunk in a single transaction, skipping those which
+// already exist unchanged.
+func (ti *touchImporter) commitChunk(ctx context.Context, chunk []*core.RelationTuple) (bulkImportCounts, error) {
+	var counts bulkImportCounts
+	_, err := ti.ds.ReadWriteTx(ctx, func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		counts = bulkImportCounts{}
+
+		if err := relationships.ValidateRelationshipsForCreateOrTouch(ctx, rwt, chunk); err != nil {
+			return err
+		}
+
+		// Relationships given more than once in the chunk are touched once, with their last caveat.
+		latest := make(map[string]*core.RelationTuple, len(chunk))
+		order := make([]string, 0, len(chunk))
+		for _, rel := range chunk {
+			key := tuple.StringWithoutCaveat(rel)
+			if _, ok := latest[key]; ok {
+				counts.skipped++
+			} else {
+				order = append(order, key)
+			}
+			latest[key] = rel
+		}
+
+		existing, err := existingRelationships(ctx, rwt, latest)
+		if err != nil {
+			return err
+		}
+
+		updates := make([]*core.RelationTupleUpdate, 0, len(order))
+		for _, key := range order {
+			rel := latest[key]
+			existingRel, ok := existing[key]
+			switch {
+			case !ok:
+				counts.created++
+			case sameCaveat(existingRel.Caveat, rel.Caveat):
+				counts.skipped++
+				continue
+			default:
+				counts.updated++
+			}
+			updates = append(updates, tuple.Touch(rel))
+		}
+
+		if len(updates) == 0 {
+			return nil
+		}
+		return rwt.WriteRelationships(ctx, updates)
+	})
+	return counts, err
+}
+
+// existingRelationships returns those of the given relationships which already exist, by their
+// key without caveat.
+func existingRelationships(ctx context.Context, reader datastore.Reader, rels map[string]*core.RelationTuple) (map[string]*core.RelationTuple, error) {
+	type resourceRelation struct{ namespace, relation string }
+	resourceIDs := make(map[resourceRelation]*mapz.Set[string])
+	for _, rel := range rels {
+		rr := resourceRelation{rel.ResourceAndRelation.Namespace, rel.ResourceAndRelation.Relation}
+		if _, ok := resourceIDs[rr]; !ok {
+			resourceIDs[rr] = mapz.NewSet[string]()
+		}
+		resourceIDs[rr].Add(rel.ResourceAndRelation.ObjectId)
+	}
+
+	existing := make(map[string]*core.RelationTuple)
+	for rr, ids := range resourceIDs {
+		iter, err := reader.QueryRelationships(ctx, datastore.RelationshipsFilter{
+			OptionalResourceType:     rr.namespace,
+			OptionalResourceIds:      ids.AsSlice(),
+			OptionalResourceRelation: rr.relation,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for tpl := iter.Next(); tpl != nil; tpl = iter.Next() {
+			key := tuple.StringWithoutCaveat(tpl)
+			if _, ok := rels[key]; ok {
+				existing[key] = tpl
+			}
+		}
+		err = iter.Err()
+		iter.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
+
+func sameCaveat(lhs, rhs *core.ContextualizedCaveat) bool {
+	if lhs.GetCaveatName() != rhs.GetCaveatName() {
+		return false
+	}
+	if len(lhs.GetContext().GetFields()) == 0 && len(rhs.GetContext().GetFields()) == 0 {
+		return true
+	}
+	return proto.Equal(lhs.GetContext(), rhs.GetContext())
+}
+
+func writeRelationshipDigest(digest hash.Hash, rel *v1.Relationship) error {
+	relString, err := tuple.StringRelationship(rel)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(digest, relString+"\n")
+	return err
+}
+
+// importBulkTouch imports the relationships of a bulk import stream with TOUCH semantics,
+// returning the total number of relationships imported.
+func importBulkTouch(
+	ctx context.Context,
+	ds datastore.Datastore,
+	tokensKey []byte,
+	chunkSize uint16,
+	recv func() ([]*v1.Relationship, error),
+) (uint64, error) {
+	importer := &touchImporter{
+		ds:        ds,
+		tokensKey: tokensKey,
+		chunkSize: int(chunkSize),
+		recv:      recv,
+	}
+
+	counts, err := importer.run(ctx)
+	if err != nil {
+		return 0, shared.RewriteErrorWithoutConfig(ctx, err)
+	}
+	return counts.total(), nil
+}
@@ -0,0 +1,219 @@
+package v1_test
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+)
+
+type bulkImportResult struct {
+	numLoaded uint64
+	token     string
+	counts    [3]uint64
+}
+
+func touchImport(ctx context.Context, t *testing.T, client v1.PermissionsServiceClient, batches ...[]*v1.Relationship) (bulkImportResult, error) {
+	var trailer metadata.MD
+	ctx = requestmeta.AddRequestHeaders(ctx, v1svc.RequestBulkImportTouch)
+	writer, err := client.ImportBulkRelationships(ctx, grpc.Trailer(&trailer))
+	require.NoError(t, err)
+
+	for _, batch := range batches {
+		if err := writer.Send(&v1.ImportBulkRelationshipsRequest{Relationships: batch}); err != nil {
+			break
+		}
+	}
+
+	resp, err := writer.CloseAndRecv()
+
+	var result bulkImportResult
+	if tokens := trailer.Get(string(v1svc.BulkImportToken)); len(tokens) > 0 {
+		result.token = tokens[0]
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.numLoaded = resp.NumLoaded
+	for i, key := range []responsemeta.ResponseMetadataTrailerKey{v1svc.BulkImportCreated, v1svc.BulkImportUpdated, v1svc.BulkImportSkipped} {
+		values := trailer.Get(string(key))
+		require.Len(t, values, 1)
+		result.counts[i], err = strconv.ParseUint(values[0], 10, 64)
+		require.NoError(t, err)
+	}
+	return result, nil
+}
+
+func TestImportBulkRelationshipsTouch(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServer(require.New(t), 0, memdb.DisableGC, true, tf.StandardDatastoreWithSchema)
+	client := v1.NewPermissionsServiceClient(conn)
+	t.Cleanup(cleanup)
+
+	ctx := context.Background()
+	_, err := client.WriteRelationships(ctx, &v1.WriteRelationshipsRequest{
+		Updates: []*v1.RelationshipUpdate{
+			{Operation: v1.RelationshipUpdate_OPERATION_CREATE, Relationship: rel("document", "existing", "viewer", "user", "tom", "")},
+			{Operation: v1.RelationshipUpdate_OPERATION_CREATE, Relationship: relWithCaveat("document", "existing", "caveated_viewer", "user", "tom", "", "test")},
+		},
+	})
+	require.NoError(t, err)
+
+	updatedCaveat := relWithCaveat("document", "existing", "caveated_viewer", "user", "tom", "", "test")
+	updatedCaveat.OptionalCaveat.Context, err = structpb.NewStruct(map[string]any{"expectedSecret": "1234"})
+	require.NoError(t, err)
+
+	result, err := touchImport(ctx, t, client, []*v1.Relationship{
+		rel("document", "existing", "viewer", "user", "tom", ""),
+		rel("document", "new", "viewer", "user", "tom", ""),
+		rel("document", "new", "viewer", "user", "tom", ""),
+	}, []*v1.Relationship{
+		updatedCaveat,
+		rel("document", "new", "viewer", "user", "fred", ""),
+	})
+	require.NoError(t, err)
+	require.Empty(t, result.token)
+	require.Equal(t, uint64(5), result.numLoaded)
+	require.Equal(t, [3]uint64{2, 1, 2}, result.counts)
+
+	stream, err := client.ReadRelationships(ctx, &v1.ReadRelationshipsRequest{
+		Consistency: &v1.Consistency{Requirement: &v1.Consistency_FullyConsistent{FullyConsistent: true}},
+		RelationshipFilter: &v1.RelationshipFilter{
+			ResourceType:       "document",
+			OptionalResourceId: "existing",
+			OptionalRelation:   "caveated_viewer",
+		},
+	})
+	require.NoError(t, err)
+
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "1234", resp.Relationship.OptionalCaveat.Context.Fields["expectedSecret"].GetStringValue())
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Importing the same relationships again skips them all.
+	result, err = touchImport(ctx, t, client, []*v1.Relationship{
+		rel("document", "existing", "viewer", "user", "tom", ""),
+		updatedCaveat,
+	})
+	require.NoError(t, err)
+	require.Equal(t, [3]uint64{0, 0, 2}, result.counts)
+}
+
+func TestImportBulkRelationshipsTouchResume(t *testing.T) {
+	config := testserver.DefaultTestServerConfig
+	config.MaxUpdatesPerWrite = 2
+	conn, cleanup, _, _ := testserver.NewTestServerWithConfig(require.New(t), 0, memdb.DisableGC, true, config, tf.StandardDatastoreWithSchema)
+	client := v1.NewPermissionsServiceClient(conn)
+	t.Cleanup(cleanup)
+
+	ctx := context.Background()
+	committed := []*v1.Relationship{
+		rel("document", "1", "viewer", "user", "tom", ""),
+		rel("document", "2", "viewer", "user", "tom", ""),
+		rel("document", "3", "viewer", "user", "tom", ""),
+		rel("document", "4", "viewer", "user", "tom", ""),
+	}
+
+	// The last chunk fails, as it references an unknown definition.
+	interrupted, err := touchImport(ctx, t, client, committed, []*v1.Relationship{
+		rel("document", "5", "viewer", "unknown", "tom", ""),
+	})
+	require.Error(t, err)
+	require.NotEmpty(t, interrupted.token)
+
+	resumeCtx := metadata.AppendToOutgoingContext(ctx, v1svc.BulkImportTokenHeader, interrupted.token)
+
+	// Resuming with different relationships fails.
+	_, err = touchImport(resumeCtx, t, client, []*v1.Relationship{
+		rel("document", "1", "viewer", "user", "fred", ""),
+		rel("document", "2", "viewer", "user", "fred", ""),
+		rel("document", "3", "viewer", "user", "fred", ""),
+		rel("document", "4", "viewer", "user", "fred", ""),
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Resuming with the same relationships only imports the remainder.
+	resumed, err := touchImport(resumeCtx, t, client, committed, []*v1.Relationship{
+		rel("document", "5", "viewer", "user", "tom", ""),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), resumed.numLoaded)
+	require.Equal(t, [3]uint64{5, 0, 0}, resumed.counts)
+
+	// Tokens which were not signed by the server are rejected.
+	tampered := []byte(interrupted.token)
+	tampered[len(tampered)-1] ^= 1
+	_, err = touchImport(metadata.AppendToOutgoingContext(ctx, v1svc.BulkImportTokenHeader, string(tampered)), t, client, committed)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestImportBulkRelationshipsTouchResumeOnAnotherServer(t *testing.T) {
+	config := testserver.DefaultTestServerConfig
+	config.MaxUpdatesPerWrite = 1
+	config.PresharedSecureKeys = []string{"shared key"}
+
+	// Both servers share the key, but not the datastore, so that the relationships skipped by the
+	// resumed import are only found on the first.
+	conn, cleanup, _, _ := testserver.NewTestServerWithConfig(require.New(t), 0, memdb.DisableGC, true, config, tf.StandardDatastoreWithSchema)
+	t.Cleanup(cleanup)
+	otherConn, otherCleanup, _, _ := testserver.NewTestServerWithConfig(require.New(t), 0, memdb.DisableGC, true, config, tf.StandardDatastoreWithSchema)
+	t.Cleanup(otherCleanup)
+
+	ctx := context.Background()
+	interrupted, err := touchImport(ctx, t, v1.NewPermissionsServiceClient(conn), []*v1.Relationship{
+		rel("document", "1", "viewer", "user", "tom", ""),
+		rel("document", "2", "viewer", "unknown", "tom", ""),
+	})
+	require.Error(t, err)
+	require.NotEmpty(t, interrupted.token)
+
+	resumeCtx := metadata.AppendToOutgoingContext(ctx, v1svc.BulkImportTokenHeader, interrupted.token)
+	resumed, err := touchImport(resumeCtx, t, v1.NewPermissionsServiceClient(otherConn), []*v1.Relationship{
+		rel("document", "1", "viewer", "user", "tom", ""),
+		rel("document", "2", "viewer", "user", "tom", ""),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), resumed.numLoaded)
+	require.Equal(t, [3]uint64{2, 0, 0}, resumed.counts)
+}
+
+func TestBulkImportRelationshipsTouch(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServer(require.New(t), 0, memdb.DisableGC, true, tf.StandardDatastoreWithData)
+	client := v1.NewExperimentalServiceClient(conn)
+	t.Cleanup(cleanup)
+
+	var trailer metadata.MD
+	ctx := requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestBulkImportTouch)
+	writer, err := client.BulkImportRelationships(ctx, grpc.Trailer(&trailer))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Send(&v1.BulkImportRelationshipsRequest{
+		Relationships: []*v1.Relationship{
+			rel("document", "companyplan", "parent", "folder", "company", ""),
+			rel("document", "newplan", "parent", "folder", "company", ""),
+		},
+	}))
+
+	resp, err := writer.CloseAndRecv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), resp.NumLoaded)
+	require.Equal(t, []string{"1"}, trailer.Get(string(v1svc.BulkImportCreated)))
+	require.Equal(t, []string{"1"}, trailer.Get(string(v1svc.BulkImportSkipped)))
+}
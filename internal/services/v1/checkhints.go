@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"context"
+	"crypto/rand"
+
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/graph"
+	"github.com/zapravila/spicedb/internal/graph/hints"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	dispatch "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+)
+
+const (
+	// RequestCheckHints, if specified in a request header on CheckPermission, asks SpiceDB to return
+	// a token in the response trailer, under CheckHints, holding the results computed by the check.
+	// Value: `1`
+	RequestCheckHints requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestcheckhints"
+
+	// CheckHintsTokenHeader is the request header in which tokens previously returned under
+	// CheckHints can be given to CheckPermission. Results found in the tokens are reused rather
+	// than recomputed, but only if the check is for the same subject and at the exact revision
+	// at which the token was issued. The header may be specified multiple times.
+	CheckHintsTokenHeader = "io.spicedb.checkhints"
+
+	// CheckHints is the key in the response trailer metadata holding the check hints token, if
+	// requested via RequestCheckHints.
+	CheckHints responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.checkhints"
+)
+
+// maximumCheckHintsPerRequest is the maximum number of hints accepted on a single request, across
+// all tokens given. Any further hints are ignored.
+const maximumCheckHintsPerRequest = 1000
+
+// newCheckHintsKey returns a random key for signing check hints tokens, for use when none was
+// configured. Tokens signed with such a key are only accepted by this process.
+func newCheckHintsKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("could not generate check hints key: " + err.Error())
+	}
+	return key
+}
+
+func isCheckHintsRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestCheckHints)]
+	return found
+}
+
+// checkHintsFromRequest returns the check hints found in the tokens given in the request headers
+// that apply to a check of the given subject at the given revision.
+func checkHintsFromRequest(ctx context.Context, key []byte, revision datastore.Revision, subject *core.ObjectAndRelation) ([]*dispatch.CheckHint, error) {
+	tokens := metadata.ValueFromIncomingContext(ctx, CheckHintsTokenHeader)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	var checkHints []*dispatch.CheckHint
+	for _, token := range tokens {
+		tokenRevision, decoded, err := hints.DecodeHintToken(key, token)
+		if err != nil {
+			return nil, NewInvalidCheckHintsTokenErr(err)
+		}
+
+		// Hints computed at another revision may no longer hold, so they are skipped.
+		if tokenRevision != revision.String() {
+			continue
+		}
+
+		checkHints = append(checkHints, graph.FilterCheckHints(decoded, subject)...)
+	}
+
+	if len(checkHints) > maximumCheckHintsPerRequest {
+		checkHints = checkHints[:maximumCheckHintsPerRequest]
+	}
+	return checkHints, nil
+}
+
+// returnCheckHints encodes the hints collected by the collector into a token and places it into
+// the response trailer.
+func returnCheckHints(ctx context.Context, key []byte, revision datastore.Revision, collector *graph.CheckHintCollector) error {
+	token, err := hints.EncodeHintToken(key, revision.String(), collector.Hints())
+	if err != nil {
+		return err
+	}
+
+	return responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		CheckHints: token,
+	})
+}
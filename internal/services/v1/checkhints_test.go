@@ -0,0 +1,98 @@
+package v1_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/authzed/grpcutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+func TestCheckPermissionWithCheckHints(t *testing.T) {
+	req := require.New(t)
+
+	conn, cleanup, _, revision := testserver.NewTestServer(req, 5*time.Second, memdb.DisableGC, true,
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {}
+
+				definition folder {
+					relation viewer: user
+					permission view = viewer
+				}
+
+				definition document {
+					relation parent: folder
+					relation viewer: user
+					permission view = viewer + parent->view
+					permission edit = viewer
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#parent@folder:somefolder"),
+				tuple.MustParse("folder:somefolder#viewer@user:tom"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	client := v1.NewPermissionsServiceClient(conn)
+	check := func(ctx context.Context, consistency *v1.Consistency, permission string) (*v1.CheckPermissionResponse, metadata.MD, error) {
+		var trailer metadata.MD
+		resp, err := client.CheckPermission(ctx, &v1.CheckPermissionRequest{
+			Consistency: consistency,
+			Resource:    &v1.ObjectReference{ObjectType: "document", ObjectId: "first"},
+			Permission:  permission,
+			Subject:     &v1.SubjectReference{Object: &v1.ObjectReference{ObjectType: "user", ObjectId: "tom"}},
+		}, grpc.Trailer(&trailer))
+		return resp, trailer, err
+	}
+
+	resp, trailer, err := check(requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestCheckHints), &v1.Consistency{
+		Requirement: &v1.Consistency_AtLeastAsFresh{AtLeastAsFresh: zedtoken.MustNewFromRevision(revision)},
+	}, "view")
+	req.NoError(err)
+	req.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, resp.Permissionship)
+
+	token, err := responsemeta.GetResponseTrailerMetadata(trailer, v1svc.CheckHints)
+	req.NoError(err)
+	req.NotEmpty(token)
+
+	atCheckedRevision := &v1.Consistency{
+		Requirement: &v1.Consistency_AtExactSnapshot{AtExactSnapshot: resp.CheckedAt},
+	}
+
+	// Checking again with the token at the same revision reuses its results.
+	hintedCtx := metadata.AppendToOutgoingContext(context.Background(), v1svc.CheckHintsTokenHeader, token)
+	resp, trailer, err = check(hintedCtx, atCheckedRevision, "view")
+	req.NoError(err)
+	req.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, resp.Permissionship)
+
+	dispatchCount, err := responsemeta.GetIntResponseTrailerMetadata(trailer, responsemeta.DispatchedOperationsCount)
+	req.NoError(err)
+	req.Equal(1, dispatchCount)
+
+	// The negative result for the viewer relation is reused by other permissions.
+	resp, _, err = check(hintedCtx, atCheckedRevision, "edit")
+	req.NoError(err)
+	req.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION, resp.Permissionship)
+
+	// A token that has been tampered with is rejected.
+	tamperedCtx := metadata.AppendToOutgoingContext(context.Background(), v1svc.CheckHintsTokenHeader, token[:len(token)-2])
+	_, _, err = check(tamperedCtx, atCheckedRevision, "view")
+	grpcutil.RequireStatus(t, codes.InvalidArgument, err)
+}
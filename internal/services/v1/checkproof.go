@@ -0,0 +1,145 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/graph"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	// RequestCheckProof, if specified in a request header on CheckPermission, asks SpiceDB to return
+	// the minimal proof of the permission in the response trailer, under CheckProof.
+	//
+	// The proof is built from the relationships walked by the check itself; sub-problems which were
+	// served from the cache or by another node are found by a separate, best-effort search, which is
+	// limited in the work it performs.
+	// Value: `1`
+	RequestCheckProof requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestcheckproof"
+
+	// CheckProof is the key in the response trailer metadata holding the JSON-encoded
+	// CheckProofResult for a check, if requested via RequestCheckProof.
+	CheckProof responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.checkproof"
+)
+
+// CheckProofResult is the proof of a check, returned in the response trailer.
+type CheckProofResult struct {
+	// Paths are the paths from the resource to the subject which establish the permission. If more
+	// than one path is given, all were required, such as due to an intersection. Empty if the subject
+	// does not have the permission, or if no proof could be found.
+	Paths [][]CheckProofStep `json:"paths"`
+
+	// Incomplete indicates that the search for the proof reached its limits, so no paths are given
+	// even though the subject has the permission.
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// CheckProofStep is a single step of a path in a CheckProofResult: either a branch taken in the
+// rewrite of a permission, or a relationship followed.
+type CheckProofStep struct {
+	// Resource is the resource and relation or permission at which the step is taken.
+	Resource string `json:"resource"`
+
+	// Operation is the operation of the rewrite in which the branch was taken, for rewrite steps:
+	// one of `union`, `intersection` or `exclusion`.
+	Operation string `json:"operation,omitempty"`
+
+	// Branch is the branch of the rewrite taken, such as `viewer` or `parent->view`, for rewrite steps.
+	Branch string `json:"branch,omitempty"`
+
+	// Relationship is the relationship followed, for relationship steps.
+	Relationship string `json:"relationship,omitempty"`
+
+	// Caveat is the caveat on the relationship followed, if any.
+	Caveat *CheckProofCaveat `json:"caveat,omitempty"`
+}
+
+// CheckProofCaveat is a caveat on a relationship of a proof, which was satisfied (or, for conditional
+// permissions, could not be evaluated due to missing context).
+type CheckProofCaveat struct {
+	Name       string         `json:"name"`
+	Expression string         `json:"expression"`
+	Context    map[string]any `json:"context,omitempty"`
+
+	// Result is `satisfied`, or `partial` if required context was missing.
+	Result string `json:"result"`
+}
+
+func isCheckProofRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestCheckProof)]
+	return found
+}
+
+// returnCheckProof finds the proof for a check, from the relationships recorded while it ran, and
+// places it into the response trailer.
+func returnCheckProof(ctx context.Context, d dispatch.Check, params graph.PermissionPathParameters, hasPermission bool) error {
+	var paths []graph.PermissionPath
+	var incomplete bool
+	if hasPermission {
+		found, err := graph.FindPermissionPaths(ctx, d, params)
+		switch {
+		case errors.Is(err, graph.ErrPermissionPathLimitsReached):
+			incomplete = true
+		case err != nil:
+			return err
+		default:
+			paths = found
+		}
+	}
+
+	result := CheckProofResult{Paths: make([][]CheckProofStep, 0, len(paths)), Incomplete: incomplete}
+	for _, path := range paths {
+		steps := make([]CheckProofStep, 0, len(path))
+		for _, step := range path {
+			steps = append(steps, checkProofStep(step))
+		}
+		result.Paths = append(result.Paths, steps)
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		CheckProof: string(encoded),
+	})
+}
+
+func checkProofStep(step graph.PermissionPathStep) CheckProofStep {
+	converted := CheckProofStep{
+		Resource:  tuple.StringONR(step.Resource),
+		Operation: string(step.Operation),
+		Branch:    step.Branch,
+	}
+
+	if step.Relationship != nil {
+		converted.Relationship = tuple.StringWithoutCaveat(step.Relationship)
+	}
+
+	if step.Caveat != nil {
+		converted.Caveat = &CheckProofCaveat{
+			Name:       step.Caveat.Name,
+			Expression: step.Caveat.Expression,
+			Context:    step.Caveat.Context,
+			Result:     "satisfied",
+		}
+		if step.Caveat.Partial {
+			converted.Caveat.Result = "partial"
+		}
+	}
+
+	return converted
+}
@@ -0,0 +1,114 @@
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+func TestCheckPermissionWithProof(t *testing.T) {
+	req := require.New(t)
+
+	conn, cleanup, _, revision := testserver.NewTestServer(req, 5*time.Second, memdb.DisableGC, true,
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {}
+
+				caveat onweekday(day string) {
+					day != "saturday" && day != "sunday"
+				}
+
+				definition folder {
+					relation viewer: user
+					permission view = viewer
+				}
+
+				definition document {
+					relation parent: folder
+					relation approved: user with onweekday
+					permission view = parent->view
+					permission approved_view = view & approved
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#parent@folder:somefolder"),
+				tuple.MustParse("folder:somefolder#viewer@user:tom"),
+				tuple.MustParse("document:first#approved@user:tom[onweekday]"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	client := v1.NewPermissionsServiceClient(conn)
+	check := func(subject string, day string) (*v1.CheckPermissionResponse, *v1svc.CheckProofResult) {
+		var trailer metadata.MD
+		resp, err := client.CheckPermission(requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestCheckProof), &v1.CheckPermissionRequest{
+			Consistency: &v1.Consistency{
+				Requirement: &v1.Consistency_AtLeastAsFresh{AtLeastAsFresh: zedtoken.MustNewFromRevision(revision)},
+			},
+			Resource:   &v1.ObjectReference{ObjectType: "document", ObjectId: "first"},
+			Permission: "approved_view",
+			Subject:    &v1.SubjectReference{Object: &v1.ObjectReference{ObjectType: "user", ObjectId: subject}},
+			Context:    &structpb.Struct{Fields: map[string]*structpb.Value{"day": structpb.NewStringValue(day)}},
+		}, grpc.Trailer(&trailer))
+		req.NoError(err)
+
+		encoded, err := responsemeta.GetResponseTrailerMetadata(trailer, v1svc.CheckProof)
+		req.NoError(err)
+
+		var result v1svc.CheckProofResult
+		req.NoError(json.Unmarshal([]byte(encoded), &result))
+		return resp, &result
+	}
+
+	resp, proof := check("tom", "monday")
+	req.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, resp.Permissionship)
+	req.Equal([][]v1svc.CheckProofStep{
+		{
+			{Resource: "document:first#approved_view", Operation: "intersection", Branch: "view"},
+			{Resource: "document:first#view", Operation: "union", Branch: "parent->view"},
+			{Resource: "document:first#parent", Relationship: "document:first#parent@folder:somefolder"},
+			{Resource: "folder:somefolder#view", Operation: "union", Branch: "viewer"},
+			{Resource: "folder:somefolder#viewer", Relationship: "folder:somefolder#viewer@user:tom"},
+		},
+		{
+			{Resource: "document:first#approved_view", Operation: "intersection", Branch: "approved"},
+			{
+				Resource:     "document:first#approved",
+				Relationship: "document:first#approved@user:tom",
+				Caveat: &v1svc.CheckProofCaveat{
+					Name:       "onweekday",
+					Expression: `day != "saturday" && day != "sunday"`,
+					Context:    map[string]any{"day": "monday"},
+					Result:     "satisfied",
+				},
+			},
+		},
+	}, proof.Paths)
+	req.False(proof.Incomplete)
+
+	// No proof is returned without the permission.
+	resp, proof = check("tom", "sunday")
+	req.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION, resp.Permissionship)
+	req.Empty(proof.Paths)
+
+	resp, proof = check("sarah", "monday")
+	req.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_NO_PERMISSION, resp.Permissionship)
+	req.Empty(proof.Paths)
+}
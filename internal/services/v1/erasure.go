@@ -0,0 +1,326 @@
+package v1
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc/metadata"
+
+	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/internal/middleware/usagemetrics"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/datastore/options"
+	"github.com/zapravila/spicedb/pkg/genutil"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	dispatchv1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/typesystem"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+const (
+	// RequestSubjectErasure, if specified in a request header on DeleteRelationships, erases the
+	// subject given in the subject filter of the request: every relationship with the subject, or
+	// with the subject as its resource, is deleted, regardless of its resource type and relation.
+	//
+	// The relationships are deleted in batches, each in its own transaction carrying the
+	// transaction metadata of the request, and the JSON-encoded ErasureReport is returned in the
+	// response trailer under SubjectErasureReport. The filter must hold only the subject type and
+	// ID, and preconditions and limits are not supported.
+	// Value: `1`
+	RequestSubjectErasure requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestsubjecterasure"
+
+	// SubjectErasureReport is the key in the response trailer metadata holding the JSON-encoded
+	// ErasureReport of a subject erasure, if requested via RequestSubjectErasure.
+	SubjectErasureReport responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.subjecterasurereport"
+)
+
+// ErasureReport is the report of the relationships deleted by a subject erasure.
+type ErasureReport struct {
+	// Subject is the subject erased, as `type:id`.
+	Subject string `json:"subject"`
+
+	// Removed are the counts of the relationships deleted for each relation which could hold the
+	// subject, including those with none.
+	Removed []ErasedRelationships `json:"removed"`
+
+	// TotalRemoved is the total number of relationships deleted.
+	TotalRemoved uint64 `json:"totalRemoved"`
+}
+
+// ErasedRelationships is the count of the relationships of a relation deleted by a subject erasure.
+type ErasedRelationships struct {
+	Definition string `json:"definition"`
+	Relation   string `json:"relation"`
+
+	// Direction is shared.AffectedAsResource if the relationships deleted were those of the subject
+	// as a resource, or shared.AffectedAsSubject if they were those with the subject.
+	Direction string `json:"direction"`
+
+	// Count is the number of relationships deleted.
+	Count uint64 `json:"count"`
+}
+
+func isSubjectErasureRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestSubjectErasure)]
+	return found
+}
+
+// eraseSubject deletes every relationship mentioning the subject of the filter of the request.
+func (ps *permissionServer) eraseSubject(ctx context.Context, req *v1.DeleteRelationshipsRequest) (*v1.DeleteRelationshipsResponse, error) {
+	subjectFilter := req.RelationshipFilter.GetOptionalSubjectFilter()
+	switch {
+	case subjectFilter == nil || subjectFilter.OptionalSubjectId == "":
+		return nil, ps.rewriteError(ctx, NewInvalidSubjectErasureErr("a subject type and ID must be given in the subject filter"))
+
+	case subjectFilter.OptionalSubjectId == tuple.PublicWildcard:
+		return nil, ps.rewriteError(ctx, NewInvalidSubjectErasureErr("the wildcard subject cannot be erased"))
+
+	case subjectFilter.OptionalRelation != nil || req.RelationshipFilter.ResourceType != "" ||
+		req.RelationshipFilter.OptionalRelation != "" || req.RelationshipFilter.OptionalResourceId != "" ||
+		req.RelationshipFilter.OptionalResourceIdPrefix != "":
+		return nil, ps.rewriteError(ctx, NewInvalidSubjectErasureErr("only a subject type and ID may be given in the filter"))
+
+	case len(req.OptionalPreconditions) > 0 || req.OptionalLimit > 0 || req.OptionalAllowPartialDeletions:
+		return nil, ps.rewriteError(ctx, NewInvalidSubjectErasureErr("preconditions and limits are not supported"))
+	}
+
+	ds := datastoremw.MustFromContext(ctx)
+	headRevision, err := ds.HeadRevision(ctx)
+	if err != nil {
+		return nil, ps.rewriteError(ctx, err)
+	}
+
+	filters, err := erasureFilters(ctx, ds.SnapshotReader(headRevision), subjectFilter.SubjectType, subjectFilter.OptionalSubjectId)
+	if err != nil {
+		return nil, ps.rewriteError(ctx, err)
+	}
+
+	report := &ErasureReport{
+		Subject: tuple.JoinObjectRef(subjectFilter.SubjectType, subjectFilter.OptionalSubjectId),
+		Removed: make([]ErasedRelationships, 0, len(filters)),
+	}
+
+	batchSize := uint64(ps.config.MaxDeleteRelationshipsLimit)
+	revision := headRevision
+	transactionCount := 0
+	for _, ef := range filters {
+		dsFilter, err := datastore.RelationshipsFilterFromPublicFilter(ef.filter)
+		if err != nil {
+			return nil, ps.rewriteError(ctx, err)
+		}
+
+		erased := ef.erased
+		for {
+			// Avoid empty transactions for the relations without any relationships to delete.
+			found, err := hasMatchingRelationships(ctx, ds, dsFilter)
+			if err != nil {
+				return nil, ps.rewriteError(ctx, err)
+			}
+			if !found {
+				break
+			}
+
+			var deleted int
+			revision, err = ds.ReadWriteTx(ctx, func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+				it, err := rwt.QueryRelationships(ctx, dsFilter, options.WithLimit(&batchSize))
+				if err != nil {
+					return err
+				}
+
+				updates := make([]*core.RelationTupleUpdate, 0, batchSize)
+				for rel := it.Next(); rel != nil; rel = it.Next() {
+					updates = append(updates, tuple.Delete(rel))
+				}
+				if it.Err() != nil {
+					it.Close()
+					return it.Err()
+				}
+				it.Close()
+
+				deleted = len(updates)
+				if deleted == 0 {
+					return nil
+				}
+				return rwt.WriteRelationships(ctx, updates)
+			}, options.WithMetadata(req.OptionalTransactionMetadata))
+			if err != nil {
+				return nil, ps.rewriteError(ctx, err)
+			}
+
+			transactionCount++
+			erased.Count += uint64(deleted)
+			if uint64(deleted) < batchSize {
+				break
+			}
+		}
+
+		report.Removed = append(report.Removed, erased)
+		report.TotalRemoved += erased.Count
+	}
+
+	dispatchCount, err := genutil.EnsureUInt32(transactionCount + 1)
+	if err != nil {
+		return nil, ps.rewriteError(ctx, err)
+	}
+
+	usagemetrics.SetInContext(ctx, &dispatchv1.ResponseMeta{
+		// One request for the schema and one per transaction.
+		DispatchCount: dispatchCount,
+	})
+
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return nil, ps.rewriteError(ctx, err)
+	}
+
+	if err := responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		SubjectErasureReport: string(encoded),
+	}); err != nil {
+		return nil, ps.rewriteError(ctx, err)
+	}
+
+	return &v1.DeleteRelationshipsResponse{
+		DeletedAt:        zedtoken.MustNewFromRevision(revision),
+		DeletionProgress: v1.DeleteRelationshipsResponse_DELETION_PROGRESS_COMPLETE,
+	}, nil
+}
+
+func hasMatchingRelationships(ctx context.Context, ds datastore.Datastore, filter datastore.RelationshipsFilter) (bool, error) {
+	headRevision, err := ds.HeadRevision(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	limit := uint64(1)
+	it, err := ds.SnapshotReader(headRevision).QueryRelationships(ctx, filter, options.WithLimit(&limit))
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+
+	found := it.Next() != nil
+	return found, it.Err()
+}
+
+type erasureFilter struct {
+	filter *v1.RelationshipFilter
+	erased ErasedRelationships
+}
+
+// erasureFilters returns the filters matching the relationships mentioning the subject, for each
+// relation which can hold the subject type, as found by the reachability graph, and for each
+// relation of the subject type itself.
+func erasureFilters(ctx context.Context, reader datastore.Reader, subjectType string, subjectID string) ([]erasureFilter, error) {
+	_, vts, err := typesystem.ReadNamespaceAndTypes(ctx, subjectType, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	allNamespaces, err := reader.ListAllNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allDefinitions := make([]*core.NamespaceDefinition, 0, len(allNamespaces))
+	for _, ns := range allNamespaces {
+		allDefinitions = append(allDefinitions, ns.Definition)
+	}
+
+	// The subject may appear without a relation, or with any relation or permission of its type.
+	startingSubjectTypes := []*core.RelationReference{{Namespace: subjectType, Relation: tuple.Ellipsis}}
+	for _, relation := range vts.Namespace().Relation {
+		startingSubjectTypes = append(startingSubjectTypes, &core.RelationReference{Namespace: subjectType, Relation: relation.Name})
+	}
+
+	rg := typesystem.ReachabilityGraphFor(vts)
+	holdingRelations := mapz.NewSet[string]()
+	for _, startingSubjectType := range startingSubjectTypes {
+		encountered, err := rg.RelationsEncounteredForSubject(ctx, allDefinitions, startingSubjectType)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rr := range encountered {
+			ts, err := vts.TypeSystemForNamespace(ctx, rr.Namespace)
+			if err != nil {
+				return nil, err
+			}
+
+			if ts.IsPermission(rr.Relation) {
+				continue
+			}
+
+			// Only relations on which the subject type is allowed directly can hold the subject.
+			allowed, err := ts.IsAllowedDirectNamespace(rr.Relation, subjectType)
+			if err != nil {
+				return nil, err
+			}
+
+			if allowed == typesystem.AllowedNamespaceValid {
+				holdingRelations.Add(tuple.JoinRelRef(rr.Namespace, rr.Relation))
+			}
+		}
+	}
+
+	sortedHoldingRelations := holdingRelations.AsSlice()
+	slices.Sort(sortedHoldingRelations)
+
+	filters := make([]erasureFilter, 0, len(sortedHoldingRelations)+len(vts.Namespace().Relation))
+	for _, relRef := range sortedHoldingRelations {
+		namespace, relation := tuple.MustSplitRelRef(relRef)
+		filters = append(filters, erasureFilter{
+			filter: &v1.RelationshipFilter{
+				ResourceType:     namespace,
+				OptionalRelation: relation,
+				OptionalSubjectFilter: &v1.SubjectFilter{
+					SubjectType:       subjectType,
+					OptionalSubjectId: subjectID,
+				},
+			},
+			erased: ErasedRelationships{
+				Definition: namespace,
+				Relation:   relation,
+				Direction:  shared.AffectedAsSubject,
+			},
+		})
+	}
+
+	resourceRelations := slices.Clone(vts.Namespace().Relation)
+	slices.SortFunc(resourceRelations, func(a, b *core.Relation) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	for _, relation := range resourceRelations {
+		if vts.IsPermission(relation.Name) {
+			continue
+		}
+
+		filters = append(filters, erasureFilter{
+			filter: &v1.RelationshipFilter{
+				ResourceType:       subjectType,
+				OptionalResourceId: subjectID,
+				OptionalRelation:   relation.Name,
+			},
+			erased: ErasedRelationships{
+				Definition: subjectType,
+				Relation:   relation.Name,
+				Direction:  shared.AffectedAsResource,
+			},
+		})
+	}
+
+	return filters, nil
+}
@@ -0,0 +1,170 @@
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/authzed/grpcutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+func TestDeleteRelationshipsSubjectErasure(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServer(require.New(t), 0, memdb.DisableGC, true,
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {
+					relation manager: user
+				}
+
+				definition group {
+					relation member: user | group#member
+				}
+
+				definition document {
+					relation viewer: user | user:* | group#member
+					relation editor: user
+					permission view = viewer + editor
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#viewer@user:tom"),
+				tuple.MustParse("document:first#viewer@user:*"),
+				tuple.MustParse("document:first#viewer@group:eng#member"),
+				tuple.MustParse("document:second#editor@user:tom"),
+				tuple.MustParse("group:eng#member@user:tom"),
+				tuple.MustParse("group:eng#member@user:fred"),
+				tuple.MustParse("user:tom#manager@user:sarah"),
+				tuple.MustParse("user:fred#manager@user:tom"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	client := v1.NewPermissionsServiceClient(conn)
+	ctx := requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestSubjectErasure)
+
+	var trailer metadata.MD
+	resp, err := client.DeleteRelationships(ctx, &v1.DeleteRelationshipsRequest{
+		RelationshipFilter: &v1.RelationshipFilter{
+			OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: "user", OptionalSubjectId: "tom"},
+		},
+	}, grpc.Trailer(&trailer))
+	require.NoError(t, err)
+	require.Equal(t, v1.DeleteRelationshipsResponse_DELETION_PROGRESS_COMPLETE, resp.DeletionProgress)
+
+	encoded := trailer.Get(string(v1svc.SubjectErasureReport))
+	require.Len(t, encoded, 1)
+
+	var report v1svc.ErasureReport
+	require.NoError(t, json.Unmarshal([]byte(encoded[0]), &report))
+	require.Equal(t, "user:tom", report.Subject)
+	require.Equal(t, uint64(5), report.TotalRemoved)
+	require.Equal(t, []v1svc.ErasedRelationships{
+		{Definition: "document", Relation: "editor", Direction: shared.AffectedAsSubject, Count: 1},
+		{Definition: "document", Relation: "viewer", Direction: shared.AffectedAsSubject, Count: 1},
+		{Definition: "group", Relation: "member", Direction: shared.AffectedAsSubject, Count: 1},
+		{Definition: "user", Relation: "manager", Direction: shared.AffectedAsSubject, Count: 1},
+		{Definition: "user", Relation: "manager", Direction: shared.AffectedAsResource, Count: 1},
+	}, report.Removed)
+
+	// Only the relationships not mentioning the subject remain.
+	var remaining []string
+	for _, resourceType := range []string{"document", "group", "user"} {
+		stream, err := client.ReadRelationships(context.Background(), &v1.ReadRelationshipsRequest{
+			Consistency:        &v1.Consistency{Requirement: &v1.Consistency_AtLeastAsFresh{AtLeastAsFresh: resp.DeletedAt}},
+			RelationshipFilter: &v1.RelationshipFilter{ResourceType: resourceType},
+		})
+		require.NoError(t, err)
+
+		for {
+			rel, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			remaining = append(remaining, tuple.MustRelString(rel.Relationship))
+		}
+	}
+
+	require.ElementsMatch(t, []string{
+		"document:first#viewer@user:*",
+		"document:first#viewer@group:eng#member",
+		"group:eng#member@user:fred",
+	}, remaining)
+}
+
+func TestDeleteRelationshipsSubjectErasureInvalid(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServer(require.New(t), 0, memdb.DisableGC, true, tf.StandardDatastoreWithData)
+	t.Cleanup(cleanup)
+
+	client := v1.NewPermissionsServiceClient(conn)
+	ctx := requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestSubjectErasure)
+
+	tcs := []struct {
+		name         string
+		request      *v1.DeleteRelationshipsRequest
+		expectedCode codes.Code
+	}{
+		{
+			name: "missing subject ID",
+			request: &v1.DeleteRelationshipsRequest{RelationshipFilter: &v1.RelationshipFilter{
+				OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: "user"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "wildcard subject",
+			request: &v1.DeleteRelationshipsRequest{RelationshipFilter: &v1.RelationshipFilter{
+				OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: "user", OptionalSubjectId: "*"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "resource type",
+			request: &v1.DeleteRelationshipsRequest{RelationshipFilter: &v1.RelationshipFilter{
+				ResourceType:          "document",
+				OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: "user", OptionalSubjectId: "tom"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "limit",
+			request: &v1.DeleteRelationshipsRequest{
+				RelationshipFilter: &v1.RelationshipFilter{
+					OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: "user", OptionalSubjectId: "tom"},
+				},
+				OptionalLimit: 10,
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "unknown subject type",
+			request: &v1.DeleteRelationshipsRequest{RelationshipFilter: &v1.RelationshipFilter{
+				OptionalSubjectFilter: &v1.SubjectFilter{SubjectType: "unknown", OptionalSubjectId: "tom"},
+			}},
+			expectedCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.DeleteRelationships(ctx, tc.request)
+			grpcutil.RequireStatus(t, tc.expectedCode, err)
+		})
+	}
+}
@@ -509,3 +509,138 @@ func (err ErrTransactionMetadataTooLarge) GRPCStatus() *status.Status {
 		),
 	)
 }
+
+// ErrReservedTransactionMetadataKey indicates that the transaction metadata of a write contains a
+// key reserved for the metadata set by SpiceDB.
+type ErrReservedTransactionMetadataKey struct {
+	error
+	key string
+}
+
+// NewReservedTransactionMetadataKeyErr constructs a new reserved transaction metadata key error.
+func NewReservedTransactionMetadataKeyErr(key string) ErrReservedTransactionMetadataKey {
+	return ErrReservedTransactionMetadataKey{
+		error: fmt.Errorf("transaction metadata cannot contain the reserved key `%s`", key),
+		key:   key,
+	}
+}
+
+func (err ErrReservedTransactionMetadataKey) MarshalZerologObject(e *zerolog.Event) {
+	e.Err(err.error).Str("key", err.key)
+}
+
+// GRPCStatus implements retrieving the gRPC status for the error.
+func (err ErrReservedTransactionMetadataKey) GRPCStatus() *status.Status {
+	return spiceerrors.WithCodeAndDetails(
+		err,
+		codes.InvalidArgument,
+		spiceerrors.ForReason(
+			v1.ErrorReason_ERROR_REASON_UNSPECIFIED,
+			map[string]string{
+				"key": err.key,
+			},
+		),
+	)
+}
+
+// ErrInvalidCheckHintsToken indicates that a check hints token given to a call was invalid.
+type ErrInvalidCheckHintsToken struct {
+	error
+}
+
+// NewInvalidCheckHintsTokenErr constructs a new invalid check hints token error.
+func NewInvalidCheckHintsTokenErr(err error) ErrInvalidCheckHintsToken {
+	return ErrInvalidCheckHintsToken{
+		error: fmt.Errorf("the check hints token provided is not valid: %w", err),
+	}
+}
+
+// GRPCStatus implements retrieving the gRPC status for the error.
+func (err ErrInvalidCheckHintsToken) GRPCStatus() *status.Status {
+	return spiceerrors.WithCodeAndDetails(
+		err,
+		codes.InvalidArgument,
+		spiceerrors.ForReason(
+			v1.ErrorReason_ERROR_REASON_UNSPECIFIED,
+			map[string]string{},
+		),
+	)
+}
+
+// ErrInvalidSubjectErasure indicates that a subject erasure was requested with an invalid request.
+type ErrInvalidSubjectErasure struct {
+	error
+}
+
+// NewInvalidSubjectErasureErr constructs a new invalid subject erasure error.
+func NewInvalidSubjectErasureErr(reason string) ErrInvalidSubjectErasure {
+	return ErrInvalidSubjectErasure{
+		error: fmt.Errorf("invalid subject erasure request: %s", reason),
+	}
+}
+
+// GRPCStatus implements retrieving the gRPC status for the error.
+func (err ErrInvalidSubjectErasure) GRPCStatus() *status.Status {
+	return spiceerrors.WithCodeAndDetails(
+		err,
+		codes.InvalidArgument,
+		spiceerrors.ForReason(
+			v1.ErrorReason_ERROR_REASON_UNSPECIFIED,
+			map[string]string{},
+		),
+	)
+}
+
+// ErrInvalidBulkImportToken indicates that a bulk import token given to resume an import was
+// malformed, or not signed by a server sharing the preshared key.
+type ErrInvalidBulkImportToken struct {
+	error
+}
+
+// NewInvalidBulkImportTokenErr constructs a new invalid bulk import token error.
+func NewInvalidBulkImportTokenErr() ErrInvalidBulkImportToken {
+	return ErrInvalidBulkImportToken{
+		error: fmt.Errorf("the bulk import token provided is invalid; the import must be restarted"),
+	}
+}
+
+// GRPCStatus implements retrieving the gRPC status for the error.
+func (err ErrInvalidBulkImportToken) GRPCStatus() *status.Status {
+	return spiceerrors.WithCodeAndDetails(
+		err,
+		codes.InvalidArgument,
+		spiceerrors.ForReason(
+			v1.ErrorReason_ERROR_REASON_UNSPECIFIED,
+			map[string]string{},
+		),
+	)
+}
+
+// ErrCannotResumeBulkImport indicates that an interrupted bulk import could not be resumed.
+type ErrCannotResumeBulkImport struct {
+	error
+	committed uint64
+}
+
+// NewBulkImportMismatchErr constructs a new error indicating that the relationships sent to resume
+// an import differ from those committed before it was interrupted.
+func NewBulkImportMismatchErr(committed uint64) ErrCannotResumeBulkImport {
+	return ErrCannotResumeBulkImport{
+		error:     fmt.Errorf("the first %d relationships sent do not match those committed by the interrupted bulk import", committed),
+		committed: committed,
+	}
+}
+
+// GRPCStatus implements retrieving the gRPC status for the error.
+func (err ErrCannotResumeBulkImport) GRPCStatus() *status.Status {
+	return spiceerrors.WithCodeAndDetails(
+		err,
+		codes.FailedPrecondition,
+		spiceerrors.ForReason(
+			v1.ErrorReason_ERROR_REASON_UNSPECIFIED,
+			map[string]string{
+				"committed_relationships": strconv.FormatUint(err.committed, 10),
+			},
+		),
+	)
+}
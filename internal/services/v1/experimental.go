@@ -88,6 +88,11 @@ func NewExperimentalServer(dispatch dispatch.Dispatcher, permServerConfig Permis
 		chunkSize = 100
 	}
 
+	bulkImportTokensKey := permServerConfig.BulkImportTokensKey
+	if len(bulkImportTokensKey) == 0 {
+		bulkImportTokensKey = newBulkImportTokensKey()
+	}
+
 	return &experimentalServer{
 		WithServiceSpecificInterceptors: shared.WithServiceSpecificInterceptors{
 			Unary: middleware.ChainUnaryServer(
@@ -110,6 +115,8 @@ func NewExperimentalServer(dispatch dispatch.Dispatcher, permServerConfig Permis
 			dispatch:             dispatch,
 			dispatchChunkSize:    chunkSize,
 		},
+		importChunkSize:     defaultIfZero(permServerConfig.MaxUpdatesPerWrite, 1000),
+		bulkImportTokensKey: bulkImportTokensKey,
 	}
 }
 
@@ -117,9 +124,11 @@ type experimentalServer struct {
 	v1.UnimplementedExperimentalServiceServer
 	shared.WithServiceSpecificInterceptors
 
-	maxBatchSize uint64
+	maxBatchSize    uint64
+	importChunkSize uint16
 
-	bulkChecker *bulkChecker
+	bulkChecker         *bulkChecker
+	bulkImportTokensKey []byte
 }
 
 type bulkLoadAdapter struct {
@@ -209,6 +218,20 @@ func extractBatchNewReferencedNamespacesAndCaveats(
 func (es *experimentalServer) BulkImportRelationships(stream v1.ExperimentalService_BulkImportRelationshipsServer) error {
 	ds := datastoremw.MustFromContext(stream.Context())
 
+	if isBulkImportTouchRequested(stream.Context()) {
+		numLoaded, err := importBulkTouch(stream.Context(), ds, es.bulkImportTokensKey, es.importChunkSize, func() ([]*v1.Relationship, error) {
+			req, err := stream.Recv()
+			return req.GetRelationships(), err
+		})
+		if err != nil {
+			return err
+		}
+
+		return stream.SendAndClose(&v1.BulkImportRelationshipsResponse{
+			NumLoaded: numLoaded,
+		})
+	}
+
 	var numWritten uint64
 	if _, err := ds.ReadWriteTx(stream.Context(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
 		loadedNamespaces := make(map[string]*typesystem.TypeSystem, 2)
@@ -0,0 +1,169 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/graph"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	// RequestCheckExplanation, if specified in a request header on CheckPermission, asks SpiceDB to
+	// return an explanation of the check in the response trailer, under CheckExplanation.
+	//
+	// Unlike tracing, explaining a check does not change how it is executed.
+	// Value: `1`
+	RequestCheckExplanation requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestcheckexplain"
+
+	// CheckExplanation is the key in the response trailer metadata holding the JSON-encoded
+	// explanation of a check, if requested via RequestCheckExplanation. Explanations larger than
+	// explainMaximumSize have dispatches dropped and then their plan pruned to a lower depth.
+	CheckExplanation responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.checkexplain"
+)
+
+const (
+	// explainMaximumPlanDepth is the maximum depth to which permissions are expanded in the plan.
+	explainMaximumPlanDepth = 10
+
+	// explainMaximumFanOutEstimate is the maximum number of relationships read per branch when
+	// estimating its fan-out.
+	explainMaximumFanOutEstimate = 1000
+
+	// explainMaximumSize is the maximum size, in bytes, of the encoded explanation placed in the
+	// response trailer.
+	explainMaximumSize = 16 * 1024
+)
+
+// CheckExplanationResult is the explanation of a check, returned in the response trailer.
+type CheckExplanationResult struct {
+	// Plan is the planned evaluation tree for the check, annotated with the status of each branch.
+	Plan *graph.CheckPlanNode `json:"plan"`
+
+	// Dispatches are the dispatches that ran while performing the check on the node serving it.
+	Dispatches []ExplainedDispatch `json:"dispatches"`
+
+	// DroppedDispatches is the number of dispatches not included due to the maximum being reached.
+	DroppedDispatches int `json:"droppedDispatches,omitempty"`
+}
+
+// ExplainedDispatch is a single dispatch that ran while performing a check.
+type ExplainedDispatch struct {
+	ResourceRelation    string `json:"resourceRelation"`
+	ResourceCount       int    `json:"resourceCount"`
+	ResultCount         int    `json:"resultCount"`
+	DispatchCount       uint32 `json:"dispatchCount"`
+	CachedDispatchCount uint32 `json:"cachedDispatchCount"`
+	Duration            string `json:"duration"`
+	Error               string `json:"error,omitempty"`
+}
+
+func isCheckExplanationRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestCheckExplanation)]
+	return found
+}
+
+// explainCheck builds the explanation for a check recorded with the given recorder and places it
+// into the response trailer.
+func explainCheck(ctx context.Context, reader datastore.Reader, resourceType string, resourceID string, permission string, recorder *graph.CheckRecorder) error {
+	plan, err := graph.BuildCheckPlan(ctx, reader, graph.CheckPlanParameters{
+		ResourceType:          resourceType,
+		ResourceID:            resourceID,
+		Permission:            permission,
+		MaximumDepth:          explainMaximumPlanDepth,
+		MaximumFanOutEstimate: explainMaximumFanOutEstimate,
+	}, recorder)
+	if err != nil {
+		return err
+	}
+
+	recorded, dropped := recorder.Dispatches()
+	dispatches := make([]ExplainedDispatch, 0, len(recorded))
+	for _, rd := range recorded {
+		explained := ExplainedDispatch{
+			ResourceRelation:    tuple.StringRR(rd.ResourceRelation),
+			ResourceCount:       rd.ResourceCount,
+			ResultCount:         rd.ResultCount,
+			DispatchCount:       rd.DispatchCount,
+			CachedDispatchCount: rd.CachedDispatchCount,
+			Duration:            rd.Duration.String(),
+		}
+		if rd.Err != nil {
+			explained.Error = rd.Err.Error()
+		}
+		dispatches = append(dispatches, explained)
+	}
+
+	encoded, err := encodeCheckExplanation(CheckExplanationResult{
+		Plan:              plan,
+		Dispatches:        dispatches,
+		DroppedDispatches: dropped,
+	}, explainMaximumSize)
+	if err != nil {
+		return err
+	}
+
+	return responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		CheckExplanation: string(encoded),
+	})
+}
+
+// encodeCheckExplanation encodes the explanation, halving its dispatches and then pruning its plan
+// one level at a time until it fits within the maximum size.
+func encodeCheckExplanation(explanation CheckExplanationResult, maximumSize int) ([]byte, error) {
+	depth := explainMaximumPlanDepth
+	for {
+		encoded, err := json.Marshal(explanation)
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) <= maximumSize {
+			return encoded, nil
+		}
+
+		switch {
+		case len(explanation.Dispatches) > 0:
+			kept := len(explanation.Dispatches) / 2
+			explanation.DroppedDispatches += len(explanation.Dispatches) - kept
+			explanation.Dispatches = explanation.Dispatches[:kept]
+
+		case depth > 0 && explanation.Plan != nil:
+			depth--
+			explanation.Plan = prunedCheckPlan(explanation.Plan, depth)
+
+		default:
+			return encoded, nil
+		}
+	}
+}
+
+// prunedCheckPlan returns a copy of the plan without the nodes below the given depth, marking the
+// nodes whose children were removed as truncated.
+func prunedCheckPlan(node *graph.CheckPlanNode, depth int) *graph.CheckPlanNode {
+	pruned := *node
+	if len(node.Children) == 0 {
+		return &pruned
+	}
+
+	if depth == 0 {
+		pruned.Children = nil
+		pruned.Truncated = true
+		return &pruned
+	}
+
+	pruned.Children = make([]*graph.CheckPlanNode, 0, len(node.Children))
+	for _, child := range node.Children {
+		pruned.Children = append(pruned.Children, prunedCheckPlan(child, depth-1))
+	}
+	return &pruned
+}
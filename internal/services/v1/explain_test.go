@@ -0,0 +1,155 @@
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/graph"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+func TestCheckPermissionWithExplanation(t *testing.T) {
+	req := require.New(t)
+
+	conn, cleanup, _, revision := testserver.NewTestServer(req, 5*time.Second, memdb.DisableGC, true,
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {}
+
+				definition folder {
+					relation viewer: user
+					permission view = viewer
+				}
+
+				definition document {
+					relation parent: folder
+					relation viewer: user
+					relation banned: user
+					permission view = (viewer + parent->view) - banned
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#parent@folder:somefolder"),
+				tuple.MustParse("folder:somefolder#viewer@user:tom"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	client := v1.NewPermissionsServiceClient(conn)
+	ctx := requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestCheckExplanation)
+
+	var trailer metadata.MD
+	resp, err := client.CheckPermission(ctx, &v1.CheckPermissionRequest{
+		Consistency: &v1.Consistency{
+			Requirement: &v1.Consistency_AtLeastAsFresh{
+				AtLeastAsFresh: zedtoken.MustNewFromRevision(revision),
+			},
+		},
+		Resource:   &v1.ObjectReference{ObjectType: "document", ObjectId: "first"},
+		Permission: "view",
+		Subject:    &v1.SubjectReference{Object: &v1.ObjectReference{ObjectType: "user", ObjectId: "tom"}},
+	}, grpc.Trailer(&trailer))
+	req.NoError(err)
+	req.Equal(v1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, resp.Permissionship)
+
+	encoded, err := responsemeta.GetResponseTrailerMetadata(trailer, v1svc.CheckExplanation)
+	req.NoError(err)
+
+	var explanation v1svc.CheckExplanationResult
+	req.NoError(json.Unmarshal([]byte(encoded), &explanation))
+
+	req.Equal(graph.CheckPlanPermission, explanation.Plan.Kind)
+	req.Equal(graph.CheckPlanEvaluated, explanation.Plan.Status)
+
+	exclusion := explanation.Plan.Children[0]
+	req.Equal(graph.CheckPlanExclusion, exclusion.Kind)
+	req.Equal(graph.CheckPlanEvaluated, exclusion.Status)
+
+	arrow := exclusion.Children[0].Children[1]
+	req.Equal(graph.CheckPlanArrow, arrow.Kind)
+	req.Equal(graph.CheckPlanEvaluated, arrow.Status)
+	req.Equal(uint64(1), *arrow.EstimatedFanOut)
+	req.Equal(graph.CheckPlanEvaluated, arrow.Children[0].Status)
+
+	req.NotEmpty(explanation.Dispatches)
+
+	// Without the header, no explanation is returned.
+	var noExplainTrailer metadata.MD
+	_, err = client.CheckPermission(context.Background(), &v1.CheckPermissionRequest{
+		Consistency: &v1.Consistency{
+			Requirement: &v1.Consistency_AtLeastAsFresh{
+				AtLeastAsFresh: zedtoken.MustNewFromRevision(revision),
+			},
+		},
+		Resource:   &v1.ObjectReference{ObjectType: "document", ObjectId: "first"},
+		Permission: "view",
+		Subject:    &v1.SubjectReference{Object: &v1.ObjectReference{ObjectType: "user", ObjectId: "tom"}},
+	}, grpc.Trailer(&noExplainTrailer))
+	req.NoError(err)
+
+	found, err := responsemeta.GetResponseTrailerMetadataOrNil(noExplainTrailer, v1svc.CheckExplanation)
+	req.NoError(err)
+	req.Nil(found)
+}
+
+func TestCheckPermissionWithLargeExplanation(t *testing.T) {
+	req := require.New(t)
+
+	relations := make([]string, 0, 300)
+	var schema strings.Builder
+	schema.WriteString("definition user {}\n\ndefinition document {\n")
+	for i := 0; i < 300; i++ {
+		relation := fmt.Sprintf("relation%d", i)
+		relations = append(relations, relation)
+		fmt.Fprintf(&schema, "\trelation %s: user\n", relation)
+	}
+	fmt.Fprintf(&schema, "\tpermission view = %s\n}", strings.Join(relations, " + "))
+
+	conn, cleanup, _, revision := testserver.NewTestServer(req, 5*time.Second, memdb.DisableGC, true,
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, schema.String(), []*core.RelationTuple{
+				tuple.MustParse("document:first#relation0@user:tom"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	var trailer metadata.MD
+	_, err := v1.NewPermissionsServiceClient(conn).CheckPermission(requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestCheckExplanation), &v1.CheckPermissionRequest{
+		Consistency: &v1.Consistency{
+			Requirement: &v1.Consistency_AtLeastAsFresh{
+				AtLeastAsFresh: zedtoken.MustNewFromRevision(revision),
+			},
+		},
+		Resource:   &v1.ObjectReference{ObjectType: "document", ObjectId: "first"},
+		Permission: "view",
+		Subject:    &v1.SubjectReference{Object: &v1.ObjectReference{ObjectType: "user", ObjectId: "tom"}},
+	}, grpc.Trailer(&trailer))
+	req.NoError(err)
+
+	encoded, err := responsemeta.GetResponseTrailerMetadata(trailer, v1svc.CheckExplanation)
+	req.NoError(err)
+	req.LessOrEqual(len(encoded), 16*1024)
+
+	// The plan is pruned below the union of the relations, to fit in the trailer.
+	var explanation v1svc.CheckExplanationResult
+	req.NoError(json.Unmarshal([]byte(encoded), &explanation))
+	req.Equal(graph.CheckPlanPermission, explanation.Plan.Kind)
+	req.True(explanation.Plan.Truncated || explanation.Plan.Children[0].Truncated)
+}
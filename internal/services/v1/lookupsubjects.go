@@ -0,0 +1,479 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/jzelinskie/stringz"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/caveats"
+	"github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/graph"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	dispatchv1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/typesystem"
+)
+
+const (
+	// LookupSubjectTypesHeader is the request header in which additional subject types can be given
+	// to LookupSubjects, as a comma-separated list of `type` or `type#relation`. The special value
+	// `*` selects every subject type, without relation, from which the permission can be reached.
+	// The subject type of the request itself is always included.
+	//
+	// All subject types are looked up in a single dispatch. If specified, the type of each subject
+	// returned is given in the response trailer, under LookupSubjectTypes; the subject IDs
+	// themselves are unchanged.
+	LookupSubjectTypesHeader = "io.spicedb.lookupsubjecttypes"
+
+	// LookupSubjectTypes is the key in the response trailer metadata holding the JSON-encoded
+	// LookupSubjectTypesResult, if additional subject types were requested via
+	// LookupSubjectTypesHeader.
+	LookupSubjectTypes responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.lookupsubjecttypes"
+
+	// RequestExpandLookupSubjectsWildcards, if specified in a request header on LookupSubjects, asks
+	// SpiceDB to return, in place of each wildcard subject, the concrete subjects of its type found
+	// in relationships, either as subjects or as resources, less those excluded. Concrete subjects
+	// which were already returned are not returned again. Cannot be combined with
+	// WILDCARD_OPTION_EXCLUDE_WILDCARDS.
+	//
+	// NOTE: expanding a wildcard reads every relationship of its subject type, so it should only be
+	// used for types with a moderate number of relationships.
+	// Value: `1`
+	RequestExpandLookupSubjectsWildcards requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestexpandlookupsubjectswildcards"
+
+	// RequestLookupSubjectsPaths, if specified in a request header on LookupSubjects, asks SpiceDB
+	// to return the path(s) by which each subject was found in the response trailer, under
+	// LookupSubjectsPaths.
+	//
+	// NOTE: the paths are found by a separate, best-effort search for each subject once it has
+	// been streamed, so they are limited to the first subjects and to a maximum encoded size.
+	// Value: `1`
+	RequestLookupSubjectsPaths requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestlookupsubjectspaths"
+
+	// LookupSubjectsPaths is the key in the response trailer metadata holding the JSON-encoded
+	// LookupSubjectsPathsResult, if requested via RequestLookupSubjectsPaths.
+	LookupSubjectsPaths responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.lookupsubjectspaths"
+)
+
+const (
+	// maximumLookupSubjectsPaths is the maximum number of subjects for which paths are returned by a
+	// single LookupSubjects call.
+	maximumLookupSubjectsPaths = 100
+
+	// maximumLookupSubjectsPathsSize is the maximum size, in bytes, of the encoded paths returned in
+	// the response trailer, to remain well within the header size limits of clients and proxies.
+	maximumLookupSubjectsPathsSize = 8 * 1024
+
+	// maximumLookupSubjectTypesSize is the maximum size, in bytes, of the encoded subject types
+	// returned in the response trailer.
+	maximumLookupSubjectTypesSize = 8 * 1024
+)
+
+// LookupSubjectTypesResult holds the types of the subjects returned by a LookupSubjects call,
+// returned in the response trailer.
+type LookupSubjectTypesResult struct {
+	// Runs are the types of the subjects, in the order in which they were returned: each run gives
+	// the type of the given number of consecutive responses. Excluded subjects are of the type of
+	// the wildcard from which they are excluded.
+	Runs []LookupSubjectTypesRun `json:"runs"`
+
+	// Truncated indicates that the types of the last subjects returned are not given, as the
+	// maximum size was reached.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// LookupSubjectTypesRun is the type of consecutive subjects returned by LookupSubjects.
+type LookupSubjectTypesRun struct {
+	// SubjectType is the type of the subjects, in the form `type` or `type#relation`.
+	SubjectType string `json:"subjectType"`
+
+	// Count is the number of consecutive subjects of the type.
+	Count int `json:"count"`
+}
+
+// SubjectTypeAt returns the type of the subject returned at the given index of the stream, in the
+// form `type` or `type#relation`, or false if it is not known.
+func (lstr LookupSubjectTypesResult) SubjectTypeAt(index int) (string, bool) {
+	for _, run := range lstr.Runs {
+		if index < run.Count {
+			return run.SubjectType, true
+		}
+		index -= run.Count
+	}
+	return "", false
+}
+
+// LookupSubjectsPathsResult holds the paths by which the subjects of a LookupSubjects call were
+// found, returned in the response trailer.
+type LookupSubjectsPathsResult struct {
+	// Paths is a map from each subject, in the form `type:id` or `type:id#relation`, to the paths
+	// by which it was found. Each path is the chain of relationships walked from the resource to the
+	// subject. If more than one path is given for a subject, then all were required, such as due to
+	// an intersection.
+	Paths map[string][][]string `json:"paths"`
+
+	// Truncated indicates that paths were not returned for some subjects, as the maximum number of
+	// subjects or the maximum size was reached, or the search for their paths reached its limits.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// lookupSubjectTypes returns the subject types for which to look up subjects, and whether the
+// subjects returned should carry their types.
+func lookupSubjectTypes(ctx context.Context, reader datastore.Reader, req *v1.LookupSubjectsRequest) ([]*core.RelationReference, bool, error) {
+	requested := &core.RelationReference{
+		Namespace: req.SubjectObjectType,
+		Relation:  stringz.DefaultEmpty(req.OptionalSubjectRelation, tuple.Ellipsis),
+	}
+
+	values := metadata.ValueFromIncomingContext(ctx, LookupSubjectTypesHeader)
+	if len(values) == 0 {
+		return []*core.RelationReference{requested}, false, nil
+	}
+
+	subjectTypes := []*core.RelationReference{requested}
+	encountered := mapz.NewSet(tuple.StringRR(requested))
+	add := func(subjectType *core.RelationReference) {
+		if encountered.Add(tuple.StringRR(subjectType)) {
+			subjectTypes = append(subjectTypes, subjectType)
+		}
+	}
+
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "*" {
+				reachable, err := reachableSubjectTypes(ctx, reader, req.Resource.ObjectType, req.Permission)
+				if err != nil {
+					return nil, false, err
+				}
+
+				for _, subjectType := range reachable {
+					add(subjectType)
+				}
+				continue
+			}
+
+			namespaceName, relationName, _ := strings.Cut(entry, "#")
+			if namespaceName == "" {
+				return nil, false, status.Errorf(codes.InvalidArgument, "invalid subject type `%s` in %s header", entry, LookupSubjectTypesHeader)
+			}
+
+			add(&core.RelationReference{Namespace: namespaceName, Relation: stringz.DefaultEmpty(relationName, tuple.Ellipsis)})
+		}
+	}
+
+	return subjectTypes, true, nil
+}
+
+// reachableSubjectTypes returns the subject types, without relation, from which the given permission
+// can be reached.
+func reachableSubjectTypes(ctx context.Context, reader datastore.Reader, resourceType string, permission string) ([]*core.RelationReference, error) {
+	_, vts, err := typesystem.ReadNamespaceAndTypes(ctx, resourceType, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := reader.ListAllNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rg := typesystem.ReachabilityGraphFor(vts)
+	resourceRelation := &core.RelationReference{Namespace: resourceType, Relation: permission}
+
+	subjectTypes := make([]*core.RelationReference, 0, len(namespaces))
+	for _, ns := range namespaces {
+		subjectType := &core.RelationReference{Namespace: ns.Definition.Name, Relation: tuple.Ellipsis}
+		entrypoints, err := rg.AllEntrypointsForSubjectToResource(ctx, subjectType, resourceRelation)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(entrypoints) > 0 {
+			subjectTypes = append(subjectTypes, subjectType)
+		}
+	}
+	return subjectTypes, nil
+}
+
+// lookupSubjectTypesRecorder records the types of the subjects returned by a LookupSubjects call.
+type lookupSubjectTypesRecorder struct {
+	result LookupSubjectTypesResult
+	size   int
+}
+
+func (lstr *lookupSubjectTypesRecorder) addSubject(subjectType *core.RelationReference) {
+	if lstr.result.Truncated {
+		return
+	}
+
+	formatted := subjectType.Namespace
+	if subjectType.Relation != tuple.Ellipsis {
+		formatted = tuple.JoinRelRef(subjectType.Namespace, subjectType.Relation)
+	}
+
+	if count := len(lstr.result.Runs); count > 0 && lstr.result.Runs[count-1].SubjectType == formatted {
+		lstr.result.Runs[count-1].Count++
+		return
+	}
+
+	// Stop once the encoded runs could exceed the maximum size, allowing for the count of each run
+	// to grow to the maximum number of digits.
+	const encodedRunSize = len(`{"subjectType":"","count":},`) + 20
+	if lstr.size+len(formatted)+encodedRunSize > maximumLookupSubjectTypesSize {
+		lstr.result.Truncated = true
+		return
+	}
+
+	lstr.size += len(formatted) + encodedRunSize
+	lstr.result.Runs = append(lstr.result.Runs, LookupSubjectTypesRun{SubjectType: formatted, Count: 1})
+}
+
+func (lstr *lookupSubjectTypesRecorder) setTrailer(ctx context.Context) error {
+	encoded, err := json.Marshal(lstr.result)
+	if err != nil {
+		return err
+	}
+
+	return responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		LookupSubjectTypes: string(encoded),
+	})
+}
+
+// lookupSubjectsPathFinder finds the paths for the subjects returned by a LookupSubjects call.
+type lookupSubjectsPathFinder struct {
+	d      dispatch.Check
+	params graph.PermissionPathParameters
+	result LookupSubjectsPathsResult
+	size   int
+}
+
+func newLookupSubjectsPathFinder(d dispatch.Check, params graph.PermissionPathParameters) *lookupSubjectsPathFinder {
+	return &lookupSubjectsPathFinder{
+		d:      d,
+		params: params,
+		result: LookupSubjectsPathsResult{Paths: map[string][][]string{}},
+	}
+}
+
+func (lspf *lookupSubjectsPathFinder) addSubject(ctx context.Context, subject *core.ObjectAndRelation) error {
+	key := tuple.StringONR(subject)
+	if _, ok := lspf.result.Paths[key]; ok {
+		return nil
+	}
+
+	if lspf.result.Truncated {
+		return nil
+	}
+
+	if len(lspf.result.Paths) >= maximumLookupSubjectsPaths {
+		lspf.result.Truncated = true
+		return nil
+	}
+
+	params := lspf.params
+	params.Subject = subject
+	paths, err := graph.FindPermissionPaths(ctx, lspf.d, params)
+	if errors.Is(err, graph.ErrPermissionPathLimitsReached) {
+		lspf.result.Truncated = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	formatted := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		rels := make([]string, 0, len(path))
+		for _, rel := range path.Relationships() {
+			rels = append(rels, tuple.MustString(rel))
+		}
+		formatted = append(formatted, rels)
+	}
+
+	// Stop once the encoded paths would exceed the maximum size; the entry is encoded as `"key":value,`.
+	encoded, err := json.Marshal(map[string][][]string{key: formatted})
+	if err != nil {
+		return err
+	}
+	if lspf.size+len(encoded) > maximumLookupSubjectsPathsSize {
+		lspf.result.Truncated = true
+		return nil
+	}
+
+	lspf.size += len(encoded)
+	lspf.result.Paths[key] = formatted
+	return nil
+}
+
+func (lspf *lookupSubjectsPathFinder) setTrailer(ctx context.Context) error {
+	encoded, err := json.Marshal(lspf.result)
+	if err != nil {
+		return err
+	}
+
+	return responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		LookupSubjectsPaths: string(encoded),
+	})
+}
+
+// lookupSubjectsWildcardExpander collects the wildcard subjects found by a LookupSubjects call, and
+// expands them into the concrete subjects of their types once all subjects have been found.
+type lookupSubjectsWildcardExpander struct {
+	reader    datastore.Reader
+	wildcards []typedFoundSubject
+	found     map[string]*mapz.Set[string]
+}
+
+type typedFoundSubject struct {
+	subjectType *core.RelationReference
+	subject     *dispatchv1.FoundSubject
+}
+
+func newLookupSubjectsWildcardExpander(reader datastore.Reader) *lookupSubjectsWildcardExpander {
+	return &lookupSubjectsWildcardExpander{
+		reader: reader,
+		found:  map[string]*mapz.Set[string]{},
+	}
+}
+
+// addSubject records a subject found by the dispatch, returning false if it is a wildcard, which is
+// instead returned by expand.
+func (lswe *lookupSubjectsWildcardExpander) addSubject(subjectType *core.RelationReference, subject *dispatchv1.FoundSubject) bool {
+	if subject.SubjectId == tuple.PublicWildcard {
+		lswe.wildcards = append(lswe.wildcards, typedFoundSubject{subjectType, subject})
+		return false
+	}
+
+	lswe.foundOfType(subjectType).Add(subject.SubjectId)
+	return true
+}
+
+func (lswe *lookupSubjectsWildcardExpander) foundOfType(subjectType *core.RelationReference) *mapz.Set[string] {
+	key := tuple.StringRR(subjectType)
+	found, ok := lswe.found[key]
+	if !ok {
+		found = mapz.NewSet[string]()
+		lswe.found[key] = found
+	}
+	return found
+}
+
+// expand calls the handler for each concrete subject granted by a wildcard and not yet found.
+func (lswe *lookupSubjectsWildcardExpander) expand(ctx context.Context, handler func(subjectType *core.RelationReference, subject *dispatchv1.FoundSubject) error) error {
+	for _, wildcard := range lswe.wildcards {
+		objectIDs, err := lswe.objectIDsOfType(ctx, wildcard.subjectType.Namespace)
+		if err != nil {
+			return err
+		}
+
+		excluded := make(map[string]*dispatchv1.FoundSubject, len(wildcard.subject.ExcludedSubjects))
+		for _, excludedSubject := range wildcard.subject.ExcludedSubjects {
+			excluded[excludedSubject.SubjectId] = excludedSubject
+		}
+
+		found := lswe.foundOfType(wildcard.subjectType)
+		for _, objectID := range objectIDs {
+			if found.Has(objectID) {
+				continue
+			}
+
+			caveatExpression := wildcard.subject.CaveatExpression
+			if excludedSubject, ok := excluded[objectID]; ok {
+				if excludedSubject.CaveatExpression == nil {
+					continue
+				}
+
+				// A conditional exclusion makes the subject conditional on its caveat not applying.
+				caveatExpression = caveats.And(caveatExpression, caveats.Invert(excludedSubject.CaveatExpression))
+			}
+
+			found.Add(objectID)
+			if err := handler(wildcard.subjectType, &dispatchv1.FoundSubject{
+				SubjectId:        objectID,
+				CaveatExpression: caveatExpression,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// objectIDsOfType returns the sorted IDs of the objects of the given type found in relationships,
+// either as subjects or as resources.
+func (lswe *lookupSubjectsWildcardExpander) objectIDsOfType(ctx context.Context, objectType string) ([]string, error) {
+	objectIDs := mapz.NewSet[string]()
+
+	it, err := lswe.reader.ReverseQueryRelationships(ctx, datastore.SubjectsFilter{SubjectType: objectType})
+	if err != nil {
+		return nil, err
+	}
+	for tpl := it.Next(); tpl != nil; tpl = it.Next() {
+		if tpl.Subject.ObjectId != tuple.PublicWildcard {
+			objectIDs.Add(tpl.Subject.ObjectId)
+		}
+	}
+	if it.Err() != nil {
+		it.Close()
+		return nil, it.Err()
+	}
+	it.Close()
+
+	it, err = lswe.reader.QueryRelationships(ctx, datastore.RelationshipsFilter{OptionalResourceType: objectType})
+	if err != nil {
+		return nil, err
+	}
+	for tpl := it.Next(); tpl != nil; tpl = it.Next() {
+		objectIDs.Add(tpl.ResourceAndRelation.ObjectId)
+	}
+	if it.Err() != nil {
+		it.Close()
+		return nil, it.Err()
+	}
+	it.Close()
+
+	sorted := objectIDs.AsSlice()
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// isExpandLookupSubjectsWildcardsRequested returns whether wildcard expansion was requested, failing
+// if it cannot be combined with the request.
+func isExpandLookupSubjectsWildcardsRequested(ctx context.Context, req *v1.LookupSubjectsRequest) (bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false, nil
+	}
+
+	if _, found := md[string(RequestExpandLookupSubjectsWildcards)]; !found {
+		return false, nil
+	}
+
+	if req.WildcardOption == v1.LookupSubjectsRequest_WILDCARD_OPTION_EXCLUDE_WILDCARDS {
+		return false, status.Errorf(codes.InvalidArgument, "%s cannot be combined with WILDCARD_OPTION_EXCLUDE_WILDCARDS", RequestExpandLookupSubjectsWildcards)
+	}
+	return true, nil
+}
+
+func isLookupSubjectsPathsRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestLookupSubjectsPaths)]
+	return found
+}
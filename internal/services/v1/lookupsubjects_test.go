@@ -0,0 +1,167 @@
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jzelinskie/stringz"
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+func TestLookupSubjectsMultipleTypesAndPaths(t *testing.T) {
+	req := require.New(t)
+
+	conn, cleanup, _, revision := testserver.NewTestServer(req, 5*time.Second, memdb.DisableGC, true,
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {}
+
+				definition serviceaccount {}
+
+				definition group {
+					relation member: user | serviceaccount
+				}
+
+				definition document {
+					relation viewer: user | user:* | serviceaccount | group#member
+					relation banned: user
+					permission view = viewer
+					permission view_unbanned = viewer - banned
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#viewer@user:tom"),
+				tuple.MustParse("document:first#viewer@user:*"),
+				tuple.MustParse("document:first#viewer@serviceaccount:robot"),
+				tuple.MustParse("document:first#viewer@group:engineering#member"),
+				tuple.MustParse("group:engineering#member@user:sarah"),
+				tuple.MustParse("document:first#banned@user:fred"),
+				tuple.MustParse("document:second#viewer@user:amy"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	client := v1.NewPermissionsServiceClient(conn)
+	lookupPermission := func(ctx context.Context, permission string, wildcardOption v1.LookupSubjectsRequest_WildcardOption) ([]string, metadata.MD, error) {
+		stream, err := client.LookupSubjects(ctx, &v1.LookupSubjectsRequest{
+			Consistency: &v1.Consistency{
+				Requirement: &v1.Consistency_AtLeastAsFresh{AtLeastAsFresh: zedtoken.MustNewFromRevision(revision)},
+			},
+			Resource:          &v1.ObjectReference{ObjectType: "document", ObjectId: "first"},
+			Permission:        permission,
+			SubjectObjectType: "user",
+			WildcardOption:    wildcardOption,
+		})
+		req.NoError(err)
+
+		var found []string
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			found = append(found, resp.Subject.SubjectObjectId)
+		}
+
+		// Subjects whose types are returned are qualified with them, to be compared more easily.
+		trailer := stream.Trailer()
+		if encoded, err := responsemeta.GetResponseTrailerMetadata(trailer, v1svc.LookupSubjectTypes); err == nil {
+			var subjectTypes v1svc.LookupSubjectTypesResult
+			req.NoError(json.Unmarshal([]byte(encoded), &subjectTypes))
+			req.False(subjectTypes.Truncated)
+
+			for index, subjectID := range found {
+				subjectType, ok := subjectTypes.SubjectTypeAt(index)
+				req.True(ok)
+
+				objectType, relation, _ := strings.Cut(subjectType, "#")
+				found[index] = tuple.StringONRStrings(objectType, subjectID, stringz.DefaultEmpty(relation, tuple.Ellipsis))
+			}
+		}
+
+		sort.Strings(found)
+		return found, trailer, nil
+	}
+	lookup := func(ctx context.Context, wildcardOption v1.LookupSubjectsRequest_WildcardOption) ([]string, metadata.MD) {
+		found, trailer, err := lookupPermission(ctx, "view", wildcardOption)
+		req.NoError(err)
+		return found, trailer
+	}
+
+	// Without the header, only the requested type is returned, unqualified.
+	found, _ := lookup(context.Background(), v1.LookupSubjectsRequest_WILDCARD_OPTION_UNSPECIFIED)
+	req.Equal([]string{"*", "sarah", "tom"}, found)
+
+	found, _ = lookup(context.Background(), v1.LookupSubjectsRequest_WILDCARD_OPTION_EXCLUDE_WILDCARDS)
+	req.Equal([]string{"sarah", "tom"}, found)
+
+	// With the header, the additional types are returned, carrying their types.
+	typesCtx := metadata.AppendToOutgoingContext(context.Background(), v1svc.LookupSubjectTypesHeader, "serviceaccount, group#member")
+	found, _ = lookup(typesCtx, v1.LookupSubjectsRequest_WILDCARD_OPTION_EXCLUDE_WILDCARDS)
+	req.Equal([]string{"group:engineering#member", "serviceaccount:robot", "user:sarah", "user:tom"}, found)
+
+	allTypesCtx := metadata.AppendToOutgoingContext(context.Background(), v1svc.LookupSubjectTypesHeader, "*")
+	found, _ = lookup(allTypesCtx, v1.LookupSubjectsRequest_WILDCARD_OPTION_INCLUDE_WILDCARDS)
+	req.Equal([]string{"serviceaccount:robot", "user:*", "user:sarah", "user:tom"}, found)
+
+	// Wildcards can be expanded into the concrete subjects of their type, less those excluded.
+	expandCtx := requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestExpandLookupSubjectsWildcards)
+	found, _ = lookup(expandCtx, v1.LookupSubjectsRequest_WILDCARD_OPTION_INCLUDE_WILDCARDS)
+	req.Equal([]string{"amy", "fred", "sarah", "tom"}, found)
+
+	found, _, err := lookupPermission(expandCtx, "view_unbanned", v1.LookupSubjectsRequest_WILDCARD_OPTION_INCLUDE_WILDCARDS)
+	req.NoError(err)
+	req.Equal([]string{"amy", "sarah", "tom"}, found)
+
+	expandTypesCtx := metadata.AppendToOutgoingContext(expandCtx, v1svc.LookupSubjectTypesHeader, "serviceaccount")
+	found, _ = lookup(expandTypesCtx, v1.LookupSubjectsRequest_WILDCARD_OPTION_INCLUDE_WILDCARDS)
+	req.Equal([]string{"serviceaccount:robot", "user:amy", "user:fred", "user:sarah", "user:tom"}, found)
+
+	_, _, err = lookupPermission(expandCtx, "view", v1.LookupSubjectsRequest_WILDCARD_OPTION_EXCLUDE_WILDCARDS)
+	req.Equal(codes.InvalidArgument, status.Code(err))
+
+	// Paths are returned in the trailer when requested.
+	pathsCtx := requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestLookupSubjectsPaths)
+	_, trailer := lookup(pathsCtx, v1.LookupSubjectsRequest_WILDCARD_OPTION_EXCLUDE_WILDCARDS)
+
+	encoded, err := responsemeta.GetResponseTrailerMetadata(trailer, v1svc.LookupSubjectsPaths)
+	req.NoError(err)
+
+	var result v1svc.LookupSubjectsPathsResult
+	req.NoError(json.Unmarshal([]byte(encoded), &result))
+	req.False(result.Truncated)
+	req.Equal(map[string][][]string{
+		"user:tom": {{"document:first#viewer@user:tom"}},
+		// The wildcard is the shortest path for subjects without a direct relationship.
+		"user:sarah": {{"document:first#viewer@user:*"}},
+	}, result.Paths)
+
+	// Calls without the request header get no trailer.
+	_, trailer = lookup(context.Background(), v1.LookupSubjectsRequest_WILDCARD_OPTION_UNSPECIFIED)
+	value, err := responsemeta.GetResponseTrailerMetadataOrNil(trailer, v1svc.LookupSubjectsPaths)
+	req.NoError(err)
+	req.Nil(value)
+}
@@ -94,21 +94,48 @@ func (ps *permissionServer) CheckPermission(ctx context.Context, req *v1.CheckPe
 		debugOption = computed.BasicDebuggingEnabled
 	}
 
-	cr, metadata, err := computed.ComputeCheck(ctx, ps.dispatch,
+	subject := &core.ObjectAndRelation{
+		Namespace: req.Subject.Object.ObjectType,
+		ObjectId:  req.Subject.Object.ObjectId,
+		Relation:  normalizeSubjectRelation(req.Subject),
+	}
+
+	checkHints, err := checkHintsFromRequest(ctx, ps.config.CheckHintsKey, atRevision, subject)
+	if err != nil {
+		return nil, ps.rewriteError(ctx, err)
+	}
+
+	checkCtx := ctx
+	var recorder *graph.CheckRecorder
+	if isCheckExplanationRequested(ctx) {
+		recorder = graph.NewCheckRecorder(graph.DefaultMaximumRecordedDispatches)
+		checkCtx = graph.ContextWithCheckRecorder(checkCtx, recorder)
+	}
+
+	var hintCollector *graph.CheckHintCollector
+	if isCheckHintsRequested(ctx) {
+		hintCollector = graph.NewCheckHintCollector(graph.DefaultMaximumCollectedCheckHints)
+		checkCtx = graph.ContextWithCheckHintCollector(checkCtx, hintCollector)
+	}
+
+	var proofRecorder *graph.ProofRecorder
+	if isCheckProofRequested(ctx) {
+		proofRecorder = graph.NewProofRecorder(graph.DefaultMaximumRecordedProofRelationships)
+		checkCtx = graph.ContextWithProofRecorder(checkCtx, proofRecorder)
+	}
+
+	cr, metadata, err := computed.ComputeCheck(checkCtx, ps.dispatch,
 		computed.CheckParameters{
 			ResourceType: &core.RelationReference{
 				Namespace: req.Resource.ObjectType,
 				Relation:  req.Permission,
 			},
-			Subject: &core.ObjectAndRelation{
-				Namespace: req.Subject.Object.ObjectType,
-				ObjectId:  req.Subject.Object.ObjectId,
-				Relation:  normalizeSubjectRelation(req.Subject),
-			},
+			Subject:       subject,
 			CaveatContext: caveatContext,
 			AtRevision:    atRevision,
 			MaximumDepth:  ps.config.MaximumAPIDepth,
 			DebugOption:   debugOption,
+			CheckHints:    checkHints,
 		},
 		req.Resource.ObjectId,
 		ps.config.DispatchChunkSize,
@@ -143,6 +170,35 @@ func (ps *permissionServer) CheckPermission(ctx context.Context, req *v1.CheckPe
 		return nil, ps.rewriteErrorWithOptionalDebugTrace(ctx, err, debugTrace)
 	}
 
+	if recorder != nil {
+		if err := explainCheck(ctx, ds, req.Resource.ObjectType, req.Resource.ObjectId, req.Permission, recorder); err != nil {
+			return nil, ps.rewriteError(ctx, err)
+		}
+	}
+
+	if hintCollector != nil {
+		if err := returnCheckHints(ctx, ps.config.CheckHintsKey, atRevision, hintCollector); err != nil {
+			return nil, ps.rewriteError(ctx, err)
+		}
+	}
+
+	if proofRecorder != nil {
+		if err := returnCheckProof(ctx, ps.dispatch, graph.PermissionPathParameters{
+			ResourceType:      req.Resource.ObjectType,
+			ResourceID:        req.Resource.ObjectId,
+			Permission:        req.Permission,
+			Subject:           subject,
+			CaveatContext:     caveatContext,
+			AtRevision:        atRevision,
+			MaximumDepth:      ps.config.MaximumAPIDepth,
+			DispatchChunkSize: ps.config.DispatchChunkSize,
+			Recorder:          proofRecorder,
+			Minimal:           true,
+		}, cr.Membership != dispatch.ResourceCheckResult_NOT_MEMBER); err != nil {
+			return nil, ps.rewriteError(ctx, err)
+		}
+	}
+
 	permissionship, partialCaveat := checkResultToAPITypes(cr)
 
 	return &v1.CheckPermissionResponse{
@@ -702,19 +758,32 @@ func (ps *permissionServer) LookupSubjects(req *v1.LookupSubjectsRequest, resp v
 		return ps.rewriteError(ctx, err)
 	}
 
-	if err := namespace.CheckNamespaceAndRelations(ctx,
-		[]namespace.TypeAndRelationToCheck{
-			{
-				NamespaceName: req.Resource.ObjectType,
-				RelationName:  req.Permission,
-				AllowEllipsis: false,
-			},
-			{
-				NamespaceName: req.SubjectObjectType,
-				RelationName:  stringz.DefaultEmpty(req.OptionalSubjectRelation, tuple.Ellipsis),
-				AllowEllipsis: true,
-			},
-		}, ds); err != nil {
+	subjectTypes, typedSubjects, err := lookupSubjectTypes(ctx, ds, req)
+	if err != nil {
+		return ps.rewriteError(ctx, err)
+	}
+
+	expandWildcards, err := isExpandLookupSubjectsWildcardsRequested(ctx, req)
+	if err != nil {
+		return ps.rewriteError(ctx, err)
+	}
+
+	toCheck := []namespace.TypeAndRelationToCheck{
+		{
+			NamespaceName: req.Resource.ObjectType,
+			RelationName:  req.Permission,
+			AllowEllipsis: false,
+		},
+	}
+	for _, subjectType := range subjectTypes {
+		toCheck = append(toCheck, namespace.TypeAndRelationToCheck{
+			NamespaceName: subjectType.Namespace,
+			RelationName:  subjectType.Relation,
+			AllowEllipsis: true,
+		})
+	}
+
+	if err := namespace.CheckNamespaceAndRelations(ctx, toCheck, ds); err != nil {
 		return ps.rewriteError(ctx, err)
 	}
 
@@ -726,50 +795,105 @@ func (ps *permissionServer) LookupSubjects(req *v1.LookupSubjectsRequest, resp v
 	}
 	usagemetrics.SetInContext(ctx, respMetadata)
 
+	var pathFinder *lookupSubjectsPathFinder
+	if isLookupSubjectsPathsRequested(ctx) {
+		pathFinder = newLookupSubjectsPathFinder(ps.dispatch, graph.PermissionPathParameters{
+			ResourceType:      req.Resource.ObjectType,
+			ResourceID:        req.Resource.ObjectId,
+			Permission:        req.Permission,
+			CaveatContext:     caveatContext,
+			AtRevision:        atRevision,
+			MaximumDepth:      ps.config.MaximumAPIDepth,
+			DispatchChunkSize: ps.config.DispatchChunkSize,
+		})
+	}
+
+	var typesRecorder *lookupSubjectTypesRecorder
+	if typedSubjects {
+		typesRecorder = &lookupSubjectTypesRecorder{}
+	}
+
+	var expander *lookupSubjectsWildcardExpander
+	if expandWildcards {
+		expander = newLookupSubjectsWildcardExpander(ds)
+	}
+
+	sendSubject := func(subjectType *core.RelationReference, foundSubject *dispatch.FoundSubject) error {
+		excludedSubjectIDs := make([]string, 0, len(foundSubject.ExcludedSubjects))
+		for _, excludedSubject := range foundSubject.ExcludedSubjects {
+			excludedSubjectIDs = append(excludedSubjectIDs, excludedSubject.SubjectId)
+		}
+
+		excludedSubjects := make([]*v1.ResolvedSubject, 0, len(foundSubject.ExcludedSubjects))
+		for _, excludedSubject := range foundSubject.ExcludedSubjects {
+			resolvedExcludedSubject, err := foundSubjectToResolvedSubject(ctx, excludedSubject, caveatContext, ds)
+			if err != nil {
+				return err
+			}
+
+			if resolvedExcludedSubject == nil {
+				continue
+			}
+
+			excludedSubjects = append(excludedSubjects, resolvedExcludedSubject)
+		}
+
+		subject, err := foundSubjectToResolvedSubject(ctx, foundSubject, caveatContext, ds)
+		if err != nil {
+			return err
+		}
+		if subject == nil {
+			return nil
+		}
+
+		err = resp.Send(&v1.LookupSubjectsResponse{
+			Subject:            subject,
+			ExcludedSubjects:   excludedSubjects,
+			LookedUpAt:         revisionReadAt,
+			SubjectObjectId:    foundSubject.SubjectId,    // Deprecated
+			ExcludedSubjectIds: excludedSubjectIDs,        // Deprecated
+			Permissionship:     subject.Permissionship,    // Deprecated
+			PartialCaveatInfo:  subject.PartialCaveatInfo, // Deprecated
+		})
+		if err != nil {
+			return err
+		}
+
+		if typesRecorder != nil {
+			typesRecorder.addSubject(subjectType)
+		}
+
+		if pathFinder != nil {
+			return pathFinder.addSubject(ctx, &core.ObjectAndRelation{
+				Namespace: subjectType.Namespace,
+				ObjectId:  foundSubject.SubjectId,
+				Relation:  subjectType.Relation,
+			})
+		}
+		return nil
+	}
+
 	stream := dispatchpkg.NewHandlingDispatchStream(ctx, func(result *dispatch.DispatchLookupSubjectsResponse) error {
 		foundSubjects, ok := result.FoundSubjectsByResourceId[req.Resource.ObjectId]
 		if !ok {
 			return fmt.Errorf("missing resource ID in returned LS")
 		}
 
-		for _, foundSubject := range foundSubjects.FoundSubjects {
-			excludedSubjectIDs := make([]string, 0, len(foundSubject.ExcludedSubjects))
-			for _, excludedSubject := range foundSubject.ExcludedSubjects {
-				excludedSubjectIDs = append(excludedSubjectIDs, excludedSubject.SubjectId)
-			}
-
-			excludedSubjects := make([]*v1.ResolvedSubject, 0, len(foundSubject.ExcludedSubjects))
-			for _, excludedSubject := range foundSubject.ExcludedSubjects {
-				resolvedExcludedSubject, err := foundSubjectToResolvedSubject(ctx, excludedSubject, caveatContext, ds)
-				if err != nil {
-					return err
-				}
-
-				if resolvedExcludedSubject == nil {
-					continue
-				}
+		subjectType := result.SubjectRelation
+		if subjectType == nil {
+			subjectType = subjectTypes[0]
+		}
 
-				excludedSubjects = append(excludedSubjects, resolvedExcludedSubject)
+		for _, foundSubject := range foundSubjects.FoundSubjects {
+			if foundSubject.SubjectId == tuple.PublicWildcard && req.WildcardOption == v1.LookupSubjectsRequest_WILDCARD_OPTION_EXCLUDE_WILDCARDS {
+				continue
 			}
 
-			subject, err := foundSubjectToResolvedSubject(ctx, foundSubject, caveatContext, ds)
-			if err != nil {
-				return err
-			}
-			if subject == nil {
+			if expander != nil && !expander.addSubject(subjectType, foundSubject) {
 				continue
 			}
 
-			err = resp.Send(&v1.LookupSubjectsResponse{
-				Subject:            subject,
-				ExcludedSubjects:   excludedSubjects,
-				LookedUpAt:         revisionReadAt,
-				SubjectObjectId:    foundSubject.SubjectId,    // Deprecated
-				ExcludedSubjectIds: excludedSubjectIDs,        // Deprecated
-				Permissionship:     subject.Permissionship,    // Deprecated
-				PartialCaveatInfo:  subject.PartialCaveatInfo, // Deprecated
-			})
-			if err != nil {
+			if err := sendSubject(subjectType, foundSubject); err != nil {
 				return err
 			}
 		}
@@ -794,17 +918,33 @@ func (ps *permissionServer) LookupSubjects(req *v1.LookupSubjectsRequest, resp v
 				Namespace: req.Resource.ObjectType,
 				Relation:  req.Permission,
 			},
-			ResourceIds: []string{req.Resource.ObjectId},
-			SubjectRelation: &core.RelationReference{
-				Namespace: req.SubjectObjectType,
-				Relation:  stringz.DefaultEmpty(req.OptionalSubjectRelation, tuple.Ellipsis),
-			},
+			ResourceIds:                []string{req.Resource.ObjectId},
+			SubjectRelation:            subjectTypes[0],
+			AdditionalSubjectRelations: subjectTypes[1:],
 		},
 		stream)
 	if err != nil {
 		return ps.rewriteError(ctx, err)
 	}
 
+	if expander != nil {
+		if err := expander.expand(ctx, sendSubject); err != nil {
+			return ps.rewriteError(ctx, err)
+		}
+	}
+
+	if typesRecorder != nil {
+		if err := typesRecorder.setTrailer(ctx); err != nil {
+			return ps.rewriteError(ctx, err)
+		}
+	}
+
+	if pathFinder != nil {
+		if err := pathFinder.setTrailer(ctx); err != nil {
+			return ps.rewriteError(ctx, err)
+		}
+	}
+
 	return nil
 }
 
@@ -935,6 +1075,20 @@ func (a *loadBulkAdapter) Next(_ context.Context) (*core.RelationTuple, error) {
 func (ps *permissionServer) ImportBulkRelationships(stream grpc.ClientStreamingServer[v1.ImportBulkRelationshipsRequest, v1.ImportBulkRelationshipsResponse]) error {
 	ds := datastoremw.MustFromContext(stream.Context())
 
+	if isBulkImportTouchRequested(stream.Context()) {
+		numLoaded, err := importBulkTouch(stream.Context(), ds, ps.config.BulkImportTokensKey, ps.config.MaxUpdatesPerWrite, func() ([]*v1.Relationship, error) {
+			req, err := stream.Recv()
+			return req.GetRelationships(), err
+		})
+		if err != nil {
+			return err
+		}
+
+		return stream.SendAndClose(&v1.ImportBulkRelationshipsResponse{
+			NumLoaded: numLoaded,
+		})
+	}
+
 	var numWritten uint64
 	if _, err := ds.ReadWriteTx(stream.Context(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
 		loadedNamespaces := make(map[string]*typesystem.TypeSystem, 2)
@@ -0,0 +1,479 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	dispatchpkg "github.com/zapravila/spicedb/internal/dispatch"
+	"github.com/zapravila/spicedb/internal/graph/computed"
+	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/internal/middleware/usagemetrics"
+	"github.com/zapravila/spicedb/internal/namespace"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/genutil/mapz"
+	"github.com/zapravila/spicedb/pkg/genutil/slicez"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	dispatchv1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+const (
+	// PermissionWatchServiceName is the name of the gRPC service streaming changes of computed
+	// permissions. Its messages are JSON objects, carried as google.protobuf.Struct.
+	PermissionWatchServiceName = "spicedb.permissionwatch.v1.PermissionWatchService"
+
+	watchPermissionsMethod = "/" + PermissionWatchServiceName + "/WatchPermissions"
+
+	// permissionWatchLookupBatchSize is the number of resources whose subjects are looked up by
+	// each dispatch when computing the changes of an update.
+	permissionWatchLookupBatchSize = 100
+)
+
+const (
+	// PermissionGained indicates that the subject gained the permission, or that its conditional
+	// permission became unconditional.
+	PermissionGained = "gained"
+
+	// PermissionLost indicates that the subject lost the permission, or that its unconditional
+	// permission became conditional.
+	PermissionLost = "lost"
+)
+
+const (
+	// PermissionshipHasPermission indicates that the subject has the permission.
+	PermissionshipHasPermission = "has_permission"
+
+	// PermissionshipConditionalPermission indicates that the subject has the permission depending
+	// on caveats.
+	PermissionshipConditionalPermission = "conditional_permission"
+
+	// PermissionshipNoPermission indicates that the subject does not have the permission.
+	PermissionshipNoPermission = "no_permission"
+)
+
+// PermissionWatchRequest is the request to WatchPermissions.
+type PermissionWatchRequest struct {
+	// Watches are the permissions to watch. At least one is required.
+	Watches []WatchedPermission `json:"watches"`
+
+	// OptionalStartCursor is the ZedToken after which to watch for changes. If empty, changes are
+	// watched from the current revision.
+	OptionalStartCursor string `json:"optionalStartCursor,omitempty"`
+}
+
+// WatchedPermission is a permission whose changes are watched for subjects of a type.
+type WatchedPermission struct {
+	ResourceType string `json:"resourceType"`
+	Permission   string `json:"permission"`
+	SubjectType  string `json:"subjectType"`
+}
+
+// PermissionChange is a change of the computed permission of a subject on a resource, streamed by
+// WatchPermissions.
+type PermissionChange struct {
+	// Resource is the resource, as `type:id`.
+	Resource   string `json:"resource"`
+	Permission string `json:"permission"`
+
+	// Subject is the subject, as `type:id`. The ID is `*` if the change is that of a wildcard.
+	Subject string `json:"subject"`
+
+	// Change is PermissionGained or PermissionLost.
+	Change string `json:"change"`
+
+	// Permissionship is the permissionship of the subject following the change.
+	Permissionship string `json:"permissionship"`
+
+	// ChangedAt is the ZedToken of the revision at which the permission changed.
+	ChangedAt string `json:"changedAt"`
+}
+
+// permissionWatchServiceServer is the server API for the permission watch service.
+type permissionWatchServiceServer interface {
+	// WatchPermissions streams the PermissionChange of each watched permission, from the
+	// PermissionWatchRequest given.
+	WatchPermissions(req *structpb.Struct, stream grpc.ServerStream) error
+}
+
+// PermissionWatchServiceDesc is the description of the permission watch service.
+var PermissionWatchServiceDesc = grpc.ServiceDesc{
+	ServiceName: PermissionWatchServiceName,
+	HandlerType: (*permissionWatchServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPermissions",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				in := new(structpb.Struct)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(permissionWatchServiceServer).WatchPermissions(in, stream)
+			},
+		},
+	},
+}
+
+// RegisterPermissionWatchServer registers the permission watch service with the given server.
+func RegisterPermissionWatchServer(srv *grpc.Server, server any) {
+	srv.RegisterService(&PermissionWatchServiceDesc, server)
+}
+
+// NewPermissionWatchServer creates an instance of the permission watch server, computing the
+// changed permissions with the given dispatcher.
+func NewPermissionWatchServer(dispatch dispatchpkg.Dispatcher, config PermissionsServerConfig, heartbeatDuration time.Duration) any {
+	return &permissionWatchServer{
+		WithStreamServiceSpecificInterceptor: shared.WithStreamServiceSpecificInterceptor{
+			Stream: usagemetrics.StreamServerInterceptor(),
+		},
+		dispatch:             dispatch,
+		maximumAPIDepth:      defaultIfZero(config.MaximumAPIDepth, 50),
+		maxAffectedResources: defaultIfZero(config.MaxPermissionWatchAffectedResources, 1_000),
+		heartbeatDuration:    heartbeatDuration,
+	}
+}
+
+type permissionWatchServer struct {
+	shared.WithStreamServiceSpecificInterceptor
+
+	dispatch             dispatchpkg.Dispatcher
+	maximumAPIDepth      uint32
+	maxAffectedResources uint32
+	heartbeatDuration    time.Duration
+}
+
+// watchedPermission is a watched permission, with the relations from which it is computed.
+type watchedPermission struct {
+	WatchedPermission
+
+	relations *computed.PermissionRelations
+}
+
+func (pws *permissionWatchServer) WatchPermissions(in *structpb.Struct, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+
+	var req PermissionWatchRequest
+	if err := fromStruct(in, &req); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid permission watch request: %s", err)
+	}
+
+	if len(req.Watches) == 0 {
+		return status.Errorf(codes.InvalidArgument, "at least one permission to watch must be given")
+	}
+
+	ds := datastoremw.MustFromContext(ctx)
+
+	var afterRevision datastore.Revision
+	if req.OptionalStartCursor != "" {
+		decodedRevision, err := zedtoken.DecodeRevision(&v1.ZedToken{Token: req.OptionalStartCursor}, ds)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "failed to decode start revision: %s", err)
+		}
+
+		afterRevision = decodedRevision
+	} else {
+		var err error
+		afterRevision, err = ds.OptimizedRevision(ctx)
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "failed to start watch: %s", err)
+		}
+	}
+
+	watched, err := watchedPermissions(ctx, ds.SnapshotReader(afterRevision), req.Watches)
+	if err != nil {
+		return pws.rewriteError(ctx, err)
+	}
+
+	usagemetrics.SetInContext(ctx, &dispatchv1.ResponseMeta{
+		DispatchCount: 1,
+	})
+
+	beforeRevision := afterRevision
+	updates, errchan := ds.Watch(ctx, afterRevision, datastore.WatchOptions{
+		Content:            datastore.WatchRelationships,
+		CheckpointInterval: pws.heartbeatDuration,
+	})
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				continue
+			}
+
+			if len(update.RelationshipChanges) > 0 {
+				changes, err := pws.permissionChanges(ctx, watched, update.RelationshipChanges, beforeRevision, update.Revision)
+				if err != nil {
+					return pws.rewriteError(ctx, err)
+				}
+
+				for _, change := range changes {
+					encoded, err := toStruct(change)
+					if err != nil {
+						return pws.rewriteError(ctx, err)
+					}
+
+					if err := stream.SendMsg(encoded); err != nil {
+						return status.Errorf(codes.Canceled, "watch canceled by user: %s", err)
+					}
+				}
+			}
+
+			beforeRevision = update.Revision
+
+		case err := <-errchan:
+			switch {
+			case errors.As(err, &datastore.ErrWatchCanceled{}):
+				return status.Errorf(codes.Canceled, "watch canceled by user: %s", err)
+			case errors.As(err, &datastore.ErrWatchDisconnected{}):
+				return status.Errorf(codes.ResourceExhausted, "watch disconnected: %s", err)
+			default:
+				return status.Errorf(codes.Internal, "watch error: %s", err)
+			}
+		}
+	}
+}
+
+func (pws *permissionWatchServer) rewriteError(ctx context.Context, err error) error {
+	return shared.RewriteError(ctx, err, &shared.ConfigForErrors{
+		MaximumAPIDepth: pws.maximumAPIDepth,
+	})
+}
+
+// watchedPermissions validates the watched permissions against the schema, and finds the relations
+// from which each is computed.
+func watchedPermissions(ctx context.Context, reader datastore.Reader, watches []WatchedPermission) ([]watchedPermission, error) {
+	watched := make([]watchedPermission, 0, len(watches))
+	for _, watch := range watches {
+		if err := namespace.CheckNamespaceAndRelations(ctx, []namespace.TypeAndRelationToCheck{
+			{
+				NamespaceName: watch.ResourceType,
+				RelationName:  watch.Permission,
+				AllowEllipsis: false,
+			},
+			{
+				NamespaceName: watch.SubjectType,
+				RelationName:  tuple.Ellipsis,
+				AllowEllipsis: true,
+			},
+		}, reader); err != nil {
+			return nil, err
+		}
+
+		relations, err := computed.RelationsForPermission(ctx, reader, &core.RelationReference{
+			Namespace: watch.ResourceType,
+			Relation:  watch.Permission,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		watched = append(watched, watchedPermission{WatchedPermission: watch, relations: relations})
+	}
+	return watched, nil
+}
+
+// permissionChanges returns the changes of the watched permissions made by the relationship changes
+// between the two revisions.
+//
+// The subjects of the resources whose permission may have changed are looked up in batches before
+// and after the change, and compared. If more than the maximum number of resources may have been
+// affected for any watched permission, the update fails with ResourceExhausted, as computing its
+// changes would be too expensive.
+func (pws *permissionWatchServer) permissionChanges(
+	ctx context.Context,
+	watched []watchedPermission,
+	relationshipChanges []*core.RelationTupleUpdate,
+	beforeRevision datastore.Revision,
+	afterRevision datastore.Revision,
+) ([]PermissionChange, error) {
+	changedAt := zedtoken.MustNewFromRevision(afterRevision).Token
+
+	var changes []PermissionChange
+	for _, watch := range watched {
+		resourceRelation := watch.relations.ResourceRelation
+
+		resourceIDs, err := watch.relations.AffectedResources(ctx, pws.dispatch, relationshipChanges, []datastore.Revision{beforeRevision, afterRevision}, pws.maximumAPIDepth, pws.maxAffectedResources)
+		if errors.Is(err, computed.ErrTooManyAffectedResources) {
+			return nil, status.Errorf(codes.ResourceExhausted,
+				"the update at revision %s affects the %s permission of more than %d resources; resume the watch after it and resynchronize the permission",
+				changedAt, tuple.StringRR(resourceRelation), pws.maxAffectedResources)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		subjectType := &core.RelationReference{Namespace: watch.SubjectType, Relation: tuple.Ellipsis}
+
+		var batchErr error
+		slicez.ForEachChunk(resourceIDs, permissionWatchLookupBatchSize, func(batch []string) {
+			if batchErr != nil {
+				return
+			}
+
+			beforeByResource, err := computed.LookupSubjectPermissionships(ctx, pws.dispatch, beforeRevision, resourceRelation, batch, subjectType, pws.maximumAPIDepth)
+			if err != nil {
+				batchErr = err
+				return
+			}
+
+			afterByResource, err := computed.LookupSubjectPermissionships(ctx, pws.dispatch, afterRevision, resourceRelation, batch, subjectType, pws.maximumAPIDepth)
+			if err != nil {
+				batchErr = err
+				return
+			}
+
+			for _, resourceID := range batch {
+				changes = append(changes, resourcePermissionChanges(watch, resourceID, beforeByResource[resourceID], afterByResource[resourceID], changedAt)...)
+			}
+		})
+		if batchErr != nil {
+			return nil, batchErr
+		}
+	}
+
+	return changes, nil
+}
+
+// resourcePermissionChanges returns the changes of the watched permission on the resource, between
+// the permissionships of its subjects before and after.
+func resourcePermissionChanges(watch watchedPermission, resourceID string, before, after map[string]computed.Permissionship, changedAt string) []PermissionChange {
+	subjectIDs := mapz.NewSet[string]()
+	for subjectID := range before {
+		subjectIDs.Add(subjectID)
+	}
+	for subjectID := range after {
+		subjectIDs.Add(subjectID)
+	}
+
+	sortedSubjectIDs := subjectIDs.AsSlice()
+	slices.Sort(sortedSubjectIDs)
+
+	var changes []PermissionChange
+	for _, subjectID := range sortedSubjectIDs {
+		previous, current := before[subjectID], after[subjectID]
+		if previous == current {
+			continue
+		}
+
+		change := PermissionGained
+		if current < previous {
+			change = PermissionLost
+		}
+
+		changes = append(changes, PermissionChange{
+			Resource:       tuple.JoinObjectRef(watch.ResourceType, resourceID),
+			Permission:     watch.Permission,
+			Subject:        tuple.JoinObjectRef(watch.SubjectType, subjectID),
+			Change:         change,
+			Permissionship: permissionshipString(current),
+			ChangedAt:      changedAt,
+		})
+	}
+	return changes
+}
+
+func permissionshipString(p computed.Permissionship) string {
+	switch p {
+	case computed.ConditionalPermission:
+		return PermissionshipConditionalPermission
+	case computed.HasPermission:
+		return PermissionshipHasPermission
+	default:
+		return PermissionshipNoPermission
+	}
+}
+
+// PermissionWatchClient is the client API for the permission watch service.
+type PermissionWatchClient interface {
+	// WatchPermissions starts streaming the changes of the watched permissions.
+	WatchPermissions(ctx context.Context, req *PermissionWatchRequest, opts ...grpc.CallOption) (PermissionWatchStream, error)
+}
+
+// PermissionWatchStream is the stream of changes returned by WatchPermissions.
+type PermissionWatchStream interface {
+	// Recv returns the next change, blocking until one is available.
+	Recv() (*PermissionChange, error)
+}
+
+// NewPermissionWatchClient returns a PermissionWatchClient invoking the permission watch service
+// over the given connection.
+func NewPermissionWatchClient(conn grpc.ClientConnInterface) PermissionWatchClient {
+	return &permissionWatchClient{conn: conn}
+}
+
+type permissionWatchClient struct {
+	conn grpc.ClientConnInterface
+}
+
+func (pwc *permissionWatchClient) WatchPermissions(ctx context.Context, req *PermissionWatchRequest, opts ...grpc.CallOption) (PermissionWatchStream, error) {
+	encoded, err := toStruct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := pwc.conn.NewStream(ctx, &PermissionWatchServiceDesc.Streams[0], watchPermissionsMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.SendMsg(encoded); err != nil {
+		return nil, err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	return &permissionWatchStream{stream: stream}, nil
+}
+
+type permissionWatchStream struct {
+	stream grpc.ClientStream
+}
+
+func (pws *permissionWatchStream) Recv() (*PermissionChange, error) {
+	encoded := new(structpb.Struct)
+	if err := pws.stream.RecvMsg(encoded); err != nil {
+		return nil, err
+	}
+
+	var change PermissionChange
+	if err := fromStruct(encoded, &change); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+func toStruct(v any) (*structpb.Struct, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(structpb.Struct)
+	if err := protojson.Unmarshal(encoded, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func fromStruct(s *structpb.Struct, v any) error {
+	encoded, err := protojson.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, v)
+}
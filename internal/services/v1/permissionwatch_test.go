@@ -0,0 +1,220 @@
+package v1_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/authzed/grpcutil"
+	"github.com/stretchr/testify/require"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc/codes"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+func permissionWatchTestServerConfig(maxAffectedResources uint32) testserver.ServerConfig {
+	config := testserver.DefaultTestServerConfig
+	config.EnablePermissionWatchAPI = true
+	config.MaxPermissionWatchAffectedResources = maxAffectedResources
+	return config
+}
+
+func TestWatchPermissions(t *testing.T) {
+	conn, cleanup, _, revision := testserver.NewTestServerWithConfig(require.New(t), 0, memdb.DisableGC, true,
+		permissionWatchTestServerConfig(0),
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {}
+
+				definition group {
+					relation member: user
+				}
+
+				definition folder {
+					relation viewer: user
+				}
+
+				definition document {
+					relation parent: folder
+					relation viewer: user | group#member
+					relation editor: user
+					permission view = viewer + editor + parent->viewer
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#viewer@group:eng#member"),
+				tuple.MustParse("document:first#editor@user:sarah"),
+				tuple.MustParse("folder:root#viewer@user:jill"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	stream, err := v1svc.NewPermissionWatchClient(conn).WatchPermissions(ctx, &v1svc.PermissionWatchRequest{
+		Watches: []v1svc.WatchedPermission{
+			{ResourceType: "document", Permission: "view", SubjectType: "user"},
+		},
+		OptionalStartCursor: zedtoken.MustNewFromRevision(revision).Token,
+	})
+	require.NoError(t, err)
+
+	changes := make(chan *v1svc.PermissionChange, 10)
+	go func() {
+		defer close(changes)
+		for {
+			change, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			changes <- change
+		}
+	}()
+
+	client := v1.NewPermissionsServiceClient(conn)
+	write := func(operation v1.RelationshipUpdate_Operation, rels ...string) string {
+		updates := make([]*v1.RelationshipUpdate, 0, len(rels))
+		for _, rel := range rels {
+			updates = append(updates, &v1.RelationshipUpdate{Operation: operation, Relationship: tuple.ParseRel(rel)})
+		}
+
+		resp, err := client.WriteRelationships(context.Background(), &v1.WriteRelationshipsRequest{Updates: updates})
+		require.NoError(t, err)
+		return resp.WrittenAt.Token
+	}
+
+	expectChanges := func(changedAt string, expected ...v1svc.PermissionChange) {
+		received := make([]v1svc.PermissionChange, 0, len(expected))
+		for len(received) < len(expected) {
+			select {
+			case change, ok := <-changes:
+				require.True(t, ok, "permission watch stream ended")
+				received = append(received, *change)
+			case <-time.After(3 * time.Second):
+				require.FailNow(t, "timed out waiting for permission changes")
+			}
+		}
+
+		for i := range expected {
+			expected[i].Permission = "view"
+			expected[i].ChangedAt = changedAt
+		}
+		require.ElementsMatch(t, expected, received)
+	}
+
+	// A direct relationship.
+	changedAt := write(v1.RelationshipUpdate_OPERATION_CREATE, "document:second#viewer@user:tom")
+	expectChanges(changedAt, v1svc.PermissionChange{
+		Resource: "document:second", Subject: "user:tom", Change: v1svc.PermissionGained, Permissionship: v1svc.PermissionshipHasPermission,
+	})
+
+	// A relationship reached through a group.
+	changedAt = write(v1.RelationshipUpdate_OPERATION_CREATE, "group:eng#member@user:fred")
+	expectChanges(changedAt, v1svc.PermissionChange{
+		Resource: "document:first", Subject: "user:fred", Change: v1svc.PermissionGained, Permissionship: v1svc.PermissionshipHasPermission,
+	})
+
+	// A relationship reached through an arrow, granting the permission to every viewer of the folder.
+	changedAt = write(v1.RelationshipUpdate_OPERATION_CREATE, "document:first#parent@folder:root", "document:second#parent@folder:root")
+	expectChanges(changedAt,
+		v1svc.PermissionChange{Resource: "document:first", Subject: "user:jill", Change: v1svc.PermissionGained, Permissionship: v1svc.PermissionshipHasPermission},
+		v1svc.PermissionChange{Resource: "document:second", Subject: "user:jill", Change: v1svc.PermissionGained, Permissionship: v1svc.PermissionshipHasPermission},
+	)
+
+	// Relationships which do not change the permission, followed by one which does.
+	write(v1.RelationshipUpdate_OPERATION_CREATE, "document:first#viewer@user:sarah")
+	changedAt = write(v1.RelationshipUpdate_OPERATION_DELETE, "document:second#viewer@user:tom")
+	expectChanges(changedAt, v1svc.PermissionChange{
+		Resource: "document:second", Subject: "user:tom", Change: v1svc.PermissionLost, Permissionship: v1svc.PermissionshipNoPermission,
+	})
+}
+
+func TestWatchPermissionsTooManyAffectedResources(t *testing.T) {
+	conn, cleanup, _, revision := testserver.NewTestServerWithConfig(require.New(t), 0, memdb.DisableGC, true,
+		permissionWatchTestServerConfig(1),
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {}
+
+				definition folder {
+					relation viewer: user
+				}
+
+				definition document {
+					relation parent: folder
+					permission view = parent->viewer
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#parent@folder:root"),
+				tuple.MustParse("document:second#parent@folder:root"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	stream, err := v1svc.NewPermissionWatchClient(conn).WatchPermissions(context.Background(), &v1svc.PermissionWatchRequest{
+		Watches: []v1svc.WatchedPermission{
+			{ResourceType: "document", Permission: "view", SubjectType: "user"},
+		},
+		OptionalStartCursor: zedtoken.MustNewFromRevision(revision).Token,
+	})
+	require.NoError(t, err)
+
+	_, err = v1.NewPermissionsServiceClient(conn).WriteRelationships(context.Background(), &v1.WriteRelationshipsRequest{
+		Updates: []*v1.RelationshipUpdate{{
+			Operation:    v1.RelationshipUpdate_OPERATION_CREATE,
+			Relationship: tuple.ParseRel("folder:root#viewer@user:jill"),
+		}},
+	})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	grpcutil.RequireStatus(t, codes.ResourceExhausted, err)
+}
+
+func TestWatchPermissionsDisabledByDefault(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServer(require.New(t), 0, memdb.DisableGC, true, tf.StandardDatastoreWithData)
+	t.Cleanup(cleanup)
+
+	stream, err := v1svc.NewPermissionWatchClient(conn).WatchPermissions(context.Background(), &v1svc.PermissionWatchRequest{
+		Watches: []v1svc.WatchedPermission{
+			{ResourceType: "document", Permission: "view", SubjectType: "user"},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	grpcutil.RequireStatus(t, codes.Unimplemented, err)
+}
+
+func TestWatchPermissionsInvalid(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServerWithConfig(require.New(t), 0, memdb.DisableGC, true,
+		permissionWatchTestServerConfig(0), tf.StandardDatastoreWithData)
+	t.Cleanup(cleanup)
+
+	for _, tc := range []struct {
+		name    string
+		watches []v1svc.WatchedPermission
+		code    codes.Code
+	}{
+		{"no watches", nil, codes.InvalidArgument},
+		{"unknown permission", []v1svc.WatchedPermission{{ResourceType: "document", Permission: "unknown", SubjectType: "user"}}, codes.FailedPrecondition},
+		{"unknown subject type", []v1svc.WatchedPermission{{ResourceType: "document", Permission: "view", SubjectType: "unknown"}}, codes.FailedPrecondition},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			stream, err := v1svc.NewPermissionWatchClient(conn).WatchPermissions(context.Background(), &v1svc.PermissionWatchRequest{Watches: tc.watches})
+			require.NoError(t, err)
+
+			_, err = stream.Recv()
+			grpcutil.RequireStatus(t, tc.code, err)
+		})
+	}
+}
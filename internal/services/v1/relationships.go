@@ -98,6 +98,19 @@ type PermissionsServerConfig struct {
 
 	// UseExperimentalLookupResources2 enables the experimental LookupResources2 API.
 	UseExperimentalLookupResources2 bool
+
+	// CheckHintsKey is the key used to sign the check hints tokens returned to and accepted from
+	// clients. If empty, a random key is generated, and tokens are only accepted by this process.
+	CheckHintsKey []byte
+
+	// BulkImportTokensKey is the key used to sign the tokens with which interrupted TOUCH bulk
+	// imports are resumed. If empty, a random key is generated, and tokens are only accepted by
+	// this process.
+	BulkImportTokensKey []byte
+
+	// MaxPermissionWatchAffectedResources defines the maximum number of resources whose permission
+	// is recomputed for a single relationship update by the permission watch service.
+	MaxPermissionWatchAffectedResources uint32
 }
 
 // NewPermissionsServer creates a PermissionsServiceServer instance.
@@ -119,6 +132,15 @@ func NewPermissionsServer(
 		MaxBulkExportRelationshipsLimit: defaultIfZero(config.MaxBulkExportRelationshipsLimit, 100_000),
 		UseExperimentalLookupResources2: config.UseExperimentalLookupResources2,
 		DispatchChunkSize:               defaultIfZero(config.DispatchChunkSize, 100),
+		CheckHintsKey:                   config.CheckHintsKey,
+		BulkImportTokensKey:             config.BulkImportTokensKey,
+	}
+
+	if len(configWithDefaults.CheckHintsKey) == 0 {
+		configWithDefaults.CheckHintsKey = newCheckHintsKey()
+	}
+	if len(configWithDefaults.BulkImportTokensKey) == 0 {
+		configWithDefaults.BulkImportTokensKey = newBulkImportTokensKey()
 	}
 
 	return &permissionServer{
@@ -379,6 +401,10 @@ func (ps *permissionServer) validateTransactionMetadata(metadata *structpb.Struc
 		return nil
 	}
 
+	if _, ok := metadata.GetFields()[WatchSchemaChangesKey]; ok {
+		return NewReservedTransactionMetadataKeyErr(WatchSchemaChangesKey)
+	}
+
 	b, err := metadata.MarshalJSON()
 	if err != nil {
 		return err
@@ -396,6 +422,10 @@ func (ps *permissionServer) DeleteRelationships(ctx context.Context, req *v1.Del
 		return nil, ps.rewriteError(ctx, err)
 	}
 
+	if isSubjectErasureRequested(ctx) {
+		return ps.eraseSubject(ctx, req)
+	}
+
 	if len(req.OptionalPreconditions) > int(ps.config.MaxPreconditionsCount) {
 		return nil, ps.rewriteError(
 			ctx,
@@ -4,8 +4,10 @@ import (
 	"context"
 
 	grpcvalidate "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/validator"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
 	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	log "github.com/zapravila/spicedb/internal/logging"
@@ -22,6 +24,22 @@ import (
 	"github.com/zapravila/spicedb/pkg/zedtoken"
 )
 
+const (
+	// ExpectedSchemaHashHeader is the request header in which a schema hash, as returned by
+	// ReadSchema under SchemaHash, may be given to WriteSchema. The schema is then only written if
+	// the stored schema has not changed since.
+	ExpectedSchemaHashHeader = "io.spicedb.expectedschemahash"
+
+	// ExpectedSchemaZedTokenHeader is the request header in which a ZedToken may be given to
+	// WriteSchema. The schema is then only written if the stored schema has not changed since the
+	// revision of the ZedToken.
+	ExpectedSchemaZedTokenHeader = "io.spicedb.expectedschemazedtoken"
+
+	// SchemaHash is the key in the response trailer metadata of ReadSchema and WriteSchema holding
+	// the hash of the schema read or written.
+	SchemaHash responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.schemahash"
+)
+
 // NewSchemaServer creates a SchemaServiceServer instance.
 func NewSchemaServer(additiveOnly bool) v1.SchemaServiceServer {
 	return &schemaServer{
@@ -88,6 +106,17 @@ func (ss *schemaServer) ReadSchema(ctx context.Context, _ *v1.ReadSchemaRequest)
 		return nil, ss.rewriteError(ctx, err)
 	}
 
+	schemaHash, err := shared.SchemaHash(datastore.DefinitionsOf(caveatDefs), datastore.DefinitionsOf(nsDefs))
+	if err != nil {
+		return nil, ss.rewriteError(ctx, err)
+	}
+
+	if err := responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		SchemaHash: schemaHash,
+	}); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("could not report schema hash")
+	}
+
 	dispatchCount, err := genutil.EnsureUInt32(len(nsDefs) + len(caveatDefs))
 	if err != nil {
 		return nil, ss.rewriteError(ctx, err)
@@ -124,9 +153,23 @@ func (ss *schemaServer) WriteSchema(ctx context.Context, in *v1.WriteSchemaReque
 		return nil, ss.rewriteError(ctx, err)
 	}
 
+	expectedHash, err := expectedSchemaHash(ctx, ds)
+	if err != nil {
+		return nil, ss.rewriteError(ctx, err)
+	}
+	if expectedHash != "" {
+		validated = validated.WithExpectedSchemaHash(expectedHash)
+	}
+
+	if isSchemaDryRunRequested(ctx) {
+		return ss.dryRunSchema(ctx, ds, validated)
+	}
+
 	// Update the schema.
+	var applied *shared.AppliedSchemaChanges
 	revision, err := ds.ReadWriteTx(ctx, func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
-		applied, err := shared.ApplySchemaChanges(ctx, rwt, validated)
+		var err error
+		applied, err = shared.ApplySchemaChanges(ctx, rwt, validated)
 		if err != nil {
 			return err
 		}
@@ -145,7 +188,45 @@ func (ss *schemaServer) WriteSchema(ctx context.Context, in *v1.WriteSchemaReque
 		return nil, ss.rewriteError(ctx, err)
 	}
 
+	// The schema has been written, so the write succeeds even if its hash cannot be reported.
+	if err := responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		SchemaHash: applied.SchemaHash,
+	}); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("could not report schema hash")
+	}
+
 	return &v1.WriteSchemaResponse{
 		WrittenAt: zedtoken.MustNewFromRevision(revision),
 	}, nil
 }
+
+// expectedSchemaHash returns the hash of the schema expected to be stored by the writer, if it gave
+// either the hash or a ZedToken in the request headers.
+func expectedSchemaHash(ctx context.Context, ds datastore.Datastore) (string, error) {
+	if hashes := metadata.ValueFromIncomingContext(ctx, ExpectedSchemaHashHeader); len(hashes) > 0 {
+		return hashes[0], nil
+	}
+
+	tokens := metadata.ValueFromIncomingContext(ctx, ExpectedSchemaZedTokenHeader)
+	if len(tokens) == 0 {
+		return "", nil
+	}
+
+	revision, err := zedtoken.DecodeRevision(&v1.ZedToken{Token: tokens[0]}, ds)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid expected schema zedtoken: %s", err)
+	}
+
+	reader := ds.SnapshotReader(revision)
+	caveatDefs, err := reader.ListAllCaveats(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	nsDefs, err := reader.ListAllNamespaces(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return shared.SchemaHash(datastore.DefinitionsOf(caveatDefs), datastore.DefinitionsOf(nsDefs))
+}
@@ -9,9 +9,12 @@ import (
 	"github.com/authzed/grpcutil"
 	"github.com/stretchr/testify/require"
 	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
 	tf "github.com/zapravila/spicedb/internal/testfixtures"
 	"github.com/zapravila/spicedb/internal/testserver"
 	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
@@ -585,3 +588,59 @@ func TestSchemaInvalid(t *testing.T) {
 	grpcutil.RequireStatus(t, codes.InvalidArgument, err)
 	require.ErrorContains(t, err, "found token TokenTypeStar")
 }
+
+func TestSchemaWriteExpectedSchemaHash(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServer(require.New(t), 0, memdb.DisableGC, true, tf.EmptyDatastore)
+	t.Cleanup(cleanup)
+	client := v1.NewSchemaServiceClient(conn)
+
+	var writeTrailer metadata.MD
+	writeResp, err := client.WriteSchema(context.Background(), &v1.WriteSchemaRequest{
+		Schema: "definition example/user {}\n\ndefinition example/document {\n\trelation viewer: example/user\n}",
+	}, grpc.Trailer(&writeTrailer))
+	require.NoError(t, err)
+
+	var readTrailer metadata.MD
+	_, err = client.ReadSchema(context.Background(), &v1.ReadSchemaRequest{}, grpc.Trailer(&readTrailer))
+	require.NoError(t, err)
+
+	schemaHash := readTrailer.Get(string(v1svc.SchemaHash))
+	require.Len(t, schemaHash, 1)
+	require.Equal(t, schemaHash, writeTrailer.Get(string(v1svc.SchemaHash)))
+
+	// A writer holding the current hash or revision succeeds, changing the schema.
+	hashCtx := metadata.AppendToOutgoingContext(context.Background(), v1svc.ExpectedSchemaHashHeader, schemaHash[0])
+	_, err = client.WriteSchema(hashCtx, &v1.WriteSchemaRequest{
+		Schema: "definition example/user {}\n\ndefinition example/document {\n\trelation viewer: example/user\n\trelation editor: example/user\n}",
+	})
+	require.NoError(t, err)
+
+	// Writers holding the previous hash or revision then fail.
+	_, err = client.WriteSchema(hashCtx, &v1.WriteSchemaRequest{
+		Schema: "definition example/user {}",
+	})
+	grpcutil.RequireStatus(t, codes.FailedPrecondition, err)
+
+	tokenCtx := metadata.AppendToOutgoingContext(context.Background(), v1svc.ExpectedSchemaZedTokenHeader, writeResp.WrittenAt.Token)
+	_, err = client.WriteSchema(tokenCtx, &v1.WriteSchemaRequest{
+		Schema: "definition example/user {}",
+	})
+	grpcutil.RequireStatus(t, codes.FailedPrecondition, err)
+
+	readResp, err := client.ReadSchema(context.Background(), &v1.ReadSchemaRequest{})
+	require.NoError(t, err)
+	require.Contains(t, readResp.SchemaText, "relation editor")
+
+	// A writer holding the current revision succeeds.
+	tokenCtx = metadata.AppendToOutgoingContext(context.Background(), v1svc.ExpectedSchemaZedTokenHeader, readResp.ReadAt.Token)
+	_, err = client.WriteSchema(tokenCtx, &v1.WriteSchemaRequest{
+		Schema: "definition example/user {}\n\ndefinition example/document {\n\trelation viewer: example/user\n}",
+	})
+	require.NoError(t, err)
+
+	// Writing the same schema again leaves the hash unchanged.
+	readTrailer = nil
+	_, err = client.ReadSchema(context.Background(), &v1.ReadSchemaRequest{}, grpc.Trailer(&readTrailer))
+	require.NoError(t, err)
+	require.Equal(t, schemaHash, readTrailer.Get(string(v1svc.SchemaHash)))
+}
@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	"github.com/zapravila/authzed-go/pkg/responsemeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/middleware/usagemetrics"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	"github.com/zapravila/spicedb/pkg/genutil"
+	dispatchv1 "github.com/zapravila/spicedb/pkg/proto/dispatch/v1"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+const (
+	// RequestSchemaDryRun, if specified in a request header on WriteSchema, asks SpiceDB to check
+	// the schema against the current schema and relationships without writing it, returning the
+	// JSON-encoded shared.SchemaChangeReport in the response trailer, under SchemaDryRunReport.
+	//
+	// The dry run reports every issue which would cause the write to fail due to existing
+	// relationships, rather than only the first. The WrittenAt of the response is the revision
+	// at which the checks were made.
+	// Value: `1`
+	RequestSchemaDryRun requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestschemadryrun"
+
+	// SchemaDryRunReport is the key in the response trailer metadata holding the JSON-encoded
+	// shared.SchemaChangeReport for a schema write, if requested via RequestSchemaDryRun. Reports
+	// larger than maximumSchemaDryRunReportSize are truncated, omitting first deltas, then changed
+	// permissions, affected relationships and blocking issues, and marked as truncated.
+	SchemaDryRunReport responsemeta.ResponseMetadataTrailerKey = "io.spicedb.respmeta.schemadryrunreport"
+)
+
+const (
+	// maximumSchemaDryRunRelationshipCount is the maximum number of relationships counted for each
+	// definition, relation or allowed type removed by a dry run.
+	maximumSchemaDryRunRelationshipCount = 10_000
+
+	// maximumSchemaDryRunReportSize is the maximum size, in bytes, of the encoded report placed in
+	// the response trailer.
+	maximumSchemaDryRunReportSize = 8 * 1024
+)
+
+func isSchemaDryRunRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestSchemaDryRun)]
+	return found
+}
+
+// dryRunSchema checks the validated schema changes against the schema and relationships at the
+// head revision, and places the report into the response trailer.
+func (ss *schemaServer) dryRunSchema(ctx context.Context, ds datastore.Datastore, validated *shared.ValidatedSchemaChanges) (*v1.WriteSchemaResponse, error) {
+	headRevision, err := ds.HeadRevision(ctx)
+	if err != nil {
+		return nil, ss.rewriteError(ctx, err)
+	}
+
+	report, err := shared.DryRunSchemaChanges(ctx, ds.SnapshotReader(headRevision), validated, maximumSchemaDryRunRelationshipCount)
+	if err != nil {
+		return nil, ss.rewriteError(ctx, err)
+	}
+
+	// One request per relationship check, plus the schema itself.
+	dispatchCount, err := genutil.EnsureUInt32(len(report.AffectedRelationships) + 1)
+	if err != nil {
+		return nil, ss.rewriteError(ctx, err)
+	}
+
+	usagemetrics.SetInContext(ctx, &dispatchv1.ResponseMeta{
+		DispatchCount: dispatchCount,
+	})
+
+	encoded, err := encodeSchemaChangeReport(report, maximumSchemaDryRunReportSize)
+	if err != nil {
+		return nil, ss.rewriteError(ctx, err)
+	}
+
+	if err := responsemeta.SetResponseTrailerMetadata(ctx, map[responsemeta.ResponseMetadataTrailerKey]string{
+		SchemaDryRunReport: string(encoded),
+	}); err != nil {
+		return nil, ss.rewriteError(ctx, err)
+	}
+
+	return &v1.WriteSchemaResponse{
+		WrittenAt: zedtoken.MustNewFromRevision(headRevision),
+	}, nil
+}
+
+// encodeSchemaChangeReport encodes the report, halving its lists from the least to the most
+// important until it fits within the maximum size.
+func encodeSchemaChangeReport(report *shared.SchemaChangeReport, maximumSize int) ([]byte, error) {
+	for {
+		encoded, err := json.Marshal(report)
+		if err != nil {
+			return nil, err
+		}
+		if len(encoded) <= maximumSize {
+			return encoded, nil
+		}
+
+		report.Truncated = true
+		switch {
+		case len(report.Deltas) > 0:
+			report.Deltas = report.Deltas[:len(report.Deltas)/2]
+		case len(report.ChangedPermissions) > 0:
+			report.ChangedPermissions = report.ChangedPermissions[:len(report.ChangedPermissions)/2]
+		case len(report.AffectedRelationships) > 0:
+			report.AffectedRelationships = report.AffectedRelationships[:len(report.AffectedRelationships)/2]
+		case len(report.BlockingIssues) > 0:
+			report.BlockingIssues = report.BlockingIssues[:len(report.BlockingIssues)/2]
+		default:
+			return encoded, nil
+		}
+	}
+}
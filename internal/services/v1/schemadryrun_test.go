@@ -0,0 +1,126 @@
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/services/shared"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
+	tf "github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/internal/testserver"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+func TestSchemaWriteDryRun(t *testing.T) {
+	conn, cleanup, _, _ := testserver.NewTestServer(require.New(t), 0, memdb.DisableGC, true,
+		func(ds datastore.Datastore, require *require.Assertions) (datastore.Datastore, datastore.Revision) {
+			return tf.DatastoreFromSchemaAndTestRelationships(ds, `
+				definition user {}
+
+				definition group {
+					relation member: user
+				}
+
+				definition document {
+					relation viewer: user | group#member
+					relation editor: user
+					relation owner: user
+					permission edit = editor
+					permission view = viewer + edit
+					permission own = owner
+				}
+			`, []*core.RelationTuple{
+				tuple.MustParse("document:first#viewer@user:tom"),
+				tuple.MustParse("document:second#viewer@user:fred"),
+				tuple.MustParse("document:first#editor@user:sarah"),
+				tuple.MustParse("document:first#viewer@group:engineering#member"),
+				tuple.MustParse("group:engineering#member@user:jill"),
+			}, require)
+		})
+	t.Cleanup(cleanup)
+
+	client := v1.NewSchemaServiceClient(conn)
+	dryRun := func(schema string) *shared.SchemaChangeReport {
+		var trailer metadata.MD
+		_, err := client.WriteSchema(requestmeta.AddRequestHeaders(context.Background(), v1svc.RequestSchemaDryRun), &v1.WriteSchemaRequest{
+			Schema: schema,
+		}, grpc.Trailer(&trailer))
+		require.NoError(t, err)
+
+		encoded := trailer.Get(string(v1svc.SchemaDryRunReport))
+		require.Len(t, encoded, 1)
+
+		var report shared.SchemaChangeReport
+		require.NoError(t, json.Unmarshal([]byte(encoded[0]), &report))
+		return &report
+	}
+
+	before, err := client.ReadSchema(context.Background(), &v1.ReadSchemaRequest{})
+	require.NoError(t, err)
+
+	blockedSchema := `
+		definition user {}
+
+		definition document {
+			relation viewer: user
+			relation owner: user
+			permission edit = viewer
+			permission view = viewer + edit
+			permission own = owner
+		}
+	`
+	report := dryRun(blockedSchema)
+
+	require.ElementsMatch(t, []string{
+		"cannot remove allowed type `group#member` from relation `viewer` in object definition `document`, as a relationship exists with it",
+		"cannot delete relation `editor` in object definition `document`, as a relationship exists under it",
+		"cannot delete object definition `group`, as a relationship exists under it",
+		"cannot delete object definition `group`, as a relationship references it",
+	}, report.BlockingIssues)
+
+	require.ElementsMatch(t, []shared.AffectedRelationships{
+		{Change: "relation-allowed-type-removed", Definition: "document", Relation: "viewer", AllowedType: "group#member", Direction: shared.AffectedAsResource, Count: 1},
+		{Change: "removed-relation", Definition: "document", Relation: "editor", Direction: shared.AffectedAsResource, Count: 1},
+		{Change: "removed-relation", Definition: "document", Relation: "editor", Direction: shared.AffectedAsSubject, Count: 0},
+		{Change: "namespace-removed", Definition: "group", Direction: shared.AffectedAsResource, Count: 1},
+		{Change: "namespace-removed", Definition: "group", Direction: shared.AffectedAsSubject, Count: 1},
+	}, report.AffectedRelationships)
+
+	require.Equal(t, []string{"document#edit", "document#view"}, report.ChangedPermissions)
+	require.Contains(t, report.Deltas, shared.SchemaDelta{Definition: "document", Type: "changed-permission-implementation", Relation: "edit"})
+	require.Contains(t, report.Deltas, shared.SchemaDelta{Definition: "group", Type: "namespace-removed"})
+
+	// Nothing was written.
+	after, err := client.ReadSchema(context.Background(), &v1.ReadSchemaRequest{})
+	require.NoError(t, err)
+	require.Equal(t, before.SchemaText, after.SchemaText)
+
+	// A change without blocking issues reports none.
+	report = dryRun(before.SchemaText + "\n\ndefinition folder {\n\trelation viewer: user\n}")
+	require.Empty(t, report.BlockingIssues)
+	require.Empty(t, report.ChangedPermissions)
+	require.Equal(t, []shared.SchemaDelta{{Definition: "folder", Type: "namespace-added"}}, report.Deltas)
+	require.False(t, report.Truncated)
+
+	// Large reports are truncated to fit in the trailer, keeping the blocking issues.
+	var added strings.Builder
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&added, "\n\ndefinition newdefinition%d {}", i)
+	}
+	report = dryRun(blockedSchema + added.String())
+	require.True(t, report.Truncated)
+	require.Less(t, len(report.Deltas), 500)
+	require.Contains(t, report.BlockingIssues, "cannot delete object definition `group`, as a relationship exists under it")
+}
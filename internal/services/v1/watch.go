@@ -6,9 +6,12 @@ import (
 	"time"
 
 	grpcvalidate "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/validator"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
 	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
 	"github.com/zapravila/spicedb/internal/middleware/usagemetrics"
@@ -21,6 +24,31 @@ import (
 	"github.com/zapravila/spicedb/pkg/zedtoken"
 )
 
+const (
+	// RequestWatchSchemaChanges, if specified in a request header on Watch, streams a response for
+	// each revision at which the schema changed. The changes are given in the transaction metadata
+	// of the response, under WatchSchemaChangesKey.
+	// Value: `1`
+	RequestWatchSchemaChanges requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestwatchschemachanges"
+
+	// RequestWatchCheckpoints, if specified in a request header on Watch, streams a response without
+	// updates whenever the datastore reports that all changes through a revision have been streamed,
+	// and for each revision whose changes were all filtered out. Consumers can advance their cursor
+	// to the ChangesThrough of these responses during quiet periods.
+	// Value: `1`
+	RequestWatchCheckpoints requestmeta.BoolRequestMetadataHeaderKey = "io.spicedb.requestwatchcheckpoints"
+)
+
+// WatchSchemaChangesKey is the key, in the transaction metadata of a watch response, holding the
+// schema changes made at its revision, if requested via RequestWatchSchemaChanges. Its value is an
+// object with the names of the `changedDefinitions`, `changedCaveats`, `deletedDefinitions` and
+// `deletedCaveats`.
+//
+// The key is reserved: writes whose transaction metadata contains it are rejected, and when schema
+// changes are requested it is removed from the metadata of every change not made to the schema,
+// so that it is only ever set by the watch itself.
+const WatchSchemaChangesKey = "io.spicedb.schemachanges"
+
 type watchServer struct {
 	v1.UnimplementedWatchServiceServer
 	shared.WithStreamServiceSpecificInterceptor
@@ -85,21 +113,49 @@ func (ws *watchServer) Watch(req *v1.WatchRequest, stream v1.WatchService_WatchS
 		DispatchCount: 1,
 	})
 
+	schemaChangesRequested := isWatchSchemaChangesRequested(ctx)
+	checkpointsRequested := isWatchCheckpointsRequested(ctx)
+
+	content := datastore.WatchRelationships
+	if schemaChangesRequested {
+		content |= datastore.WatchSchema
+	}
+	if checkpointsRequested {
+		content |= datastore.WatchCheckpoints
+	}
+
 	updates, errchan := ds.Watch(ctx, afterRevision, datastore.WatchOptions{
-		Content:            datastore.WatchRelationships,
+		Content:            content,
 		CheckpointInterval: ws.heartbeatDuration,
 	})
 	for {
 		select {
 		case update, ok := <-updates:
 			if ok {
-				filtered := filterUpdates(objectTypes, filters, update.RelationshipChanges)
-				if len(filtered) > 0 {
-					if err := stream.Send(&v1.WatchResponse{
-						Updates:                     filtered,
-						ChangesThrough:              zedtoken.MustNewFromRevision(update.Revision),
-						OptionalTransactionMetadata: update.Metadata,
-					}); err != nil {
+				response := &v1.WatchResponse{
+					ChangesThrough: zedtoken.MustNewFromRevision(update.Revision),
+				}
+
+				if !update.IsCheckpoint {
+					response.Updates = filterUpdates(objectTypes, filters, update.RelationshipChanges)
+					if len(response.Updates) > 0 {
+						response.OptionalTransactionMetadata = update.Metadata
+						if schemaChangesRequested {
+							response.OptionalTransactionMetadata = withoutSchemaChanges(update.Metadata)
+						}
+					}
+
+					if schemaChangesRequested && hasSchemaChanges(update) {
+						metadata, err := withSchemaChanges(update.Metadata, update)
+						if err != nil {
+							return ws.rewriteError(ctx, err)
+						}
+						response.OptionalTransactionMetadata = metadata
+					}
+				}
+
+				if response.OptionalTransactionMetadata != nil || len(response.Updates) > 0 || checkpointsRequested {
+					if err := stream.Send(response); err != nil {
 						return status.Errorf(codes.Canceled, "watch canceled by user: %s", err)
 					}
 				}
@@ -121,6 +177,87 @@ func (ws *watchServer) rewriteError(ctx context.Context, err error) error {
 	return shared.RewriteError(ctx, err, &shared.ConfigForErrors{})
 }
 
+func isWatchSchemaChangesRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestWatchSchemaChanges)]
+	return found
+}
+
+func isWatchCheckpointsRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, found := md[string(RequestWatchCheckpoints)]
+	return found
+}
+
+func hasSchemaChanges(update *datastore.RevisionChanges) bool {
+	return len(update.ChangedDefinitions) > 0 || len(update.DeletedNamespaces) > 0 || len(update.DeletedCaveats) > 0
+}
+
+// withSchemaChanges returns the transaction metadata with the schema changes of the update added
+// under WatchSchemaChangesKey.
+func withSchemaChanges(transactionMetadata *structpb.Struct, update *datastore.RevisionChanges) (*structpb.Struct, error) {
+	changedDefinitions := make([]any, 0, len(update.ChangedDefinitions))
+	changedCaveats := make([]any, 0, len(update.ChangedDefinitions))
+	for _, definition := range update.ChangedDefinitions {
+		if _, ok := definition.(*core.CaveatDefinition); ok {
+			changedCaveats = append(changedCaveats, definition.GetName())
+		} else {
+			changedDefinitions = append(changedDefinitions, definition.GetName())
+		}
+	}
+
+	deletedDefinitions := make([]any, 0, len(update.DeletedNamespaces))
+	for _, name := range update.DeletedNamespaces {
+		deletedDefinitions = append(deletedDefinitions, name)
+	}
+
+	deletedCaveats := make([]any, 0, len(update.DeletedCaveats))
+	for _, name := range update.DeletedCaveats {
+		deletedCaveats = append(deletedCaveats, name)
+	}
+
+	schemaChanges, err := structpb.NewStruct(map[string]any{
+		"changedDefinitions": changedDefinitions,
+		"changedCaveats":     changedCaveats,
+		"deletedDefinitions": deletedDefinitions,
+		"deletedCaveats":     deletedCaveats,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	withChanges := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(transactionMetadata.GetFields())+1)}
+	for key, value := range transactionMetadata.GetFields() {
+		withChanges.Fields[key] = value
+	}
+	withChanges.Fields[WatchSchemaChangesKey] = structpb.NewStructValue(schemaChanges)
+	return withChanges, nil
+}
+
+// withoutSchemaChanges returns the transaction metadata without WatchSchemaChangesKey, in case it
+// was written before the key was reserved.
+func withoutSchemaChanges(transactionMetadata *structpb.Struct) *structpb.Struct {
+	if _, ok := transactionMetadata.GetFields()[WatchSchemaChangesKey]; !ok {
+		return transactionMetadata
+	}
+
+	without := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(transactionMetadata.GetFields())-1)}
+	for key, value := range transactionMetadata.GetFields() {
+		if key != WatchSchemaChangesKey {
+			without.Fields[key] = value
+		}
+	}
+	return without
+}
+
 func filterUpdates(objectTypes *mapz.Set[string], filters []datastore.RelationshipsFilter, candidates []*core.RelationTupleUpdate) []*v1.RelationshipUpdate {
 	updates := tuple.UpdatesToRelationshipUpdates(candidates)
 
@@ -12,11 +12,14 @@ import (
 
 	"github.com/authzed/grpcutil"
 	"github.com/stretchr/testify/require"
+	"github.com/zapravila/authzed-go/pkg/requestmeta"
 	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	v1svc "github.com/zapravila/spicedb/internal/services/v1"
 	"github.com/zapravila/spicedb/internal/testfixtures"
 	"github.com/zapravila/spicedb/internal/testserver"
 	"github.com/zapravila/spicedb/pkg/tuple"
@@ -297,3 +300,93 @@ func sortUpdates(in []*v1.RelationshipUpdate) []*v1.RelationshipUpdate {
 
 	return out
 }
+
+func TestWatchSchemaChangesAndCheckpoints(t *testing.T) {
+	require := require.New(t)
+
+	conn, cleanup, _, revision := testserver.NewTestServer(require, 0, memdb.DisableGC, true, testfixtures.StandardDatastoreWithData)
+	t.Cleanup(cleanup)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := v1.NewWatchServiceClient(conn).Watch(
+		requestmeta.AddRequestHeaders(ctx, v1svc.RequestWatchSchemaChanges, v1svc.RequestWatchCheckpoints),
+		&v1.WatchRequest{
+			OptionalObjectTypes: []string{"folder"},
+			OptionalStartCursor: zedtoken.MustNewFromRevision(revision),
+		})
+	require.NoError(err)
+
+	responses := make(chan *v1.WatchResponse, 10)
+	go func() {
+		defer close(responses)
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			responses <- resp
+		}
+	}()
+
+	// receiveThrough returns the responses received up to that of the given revision.
+	receiveThrough := func(changesThrough *v1.ZedToken) []*v1.WatchResponse {
+		var received []*v1.WatchResponse
+		for {
+			select {
+			case resp, ok := <-responses:
+				require.True(ok, "watch stream ended")
+				received = append(received, resp)
+				if resp.ChangesThrough.Token == changesThrough.Token {
+					return received
+				}
+			case <-time.After(3 * time.Second):
+				require.FailNow("timed out waiting for watch responses")
+			}
+		}
+	}
+
+	// A change filtered out still advances the cursor.
+	written, err := v1.NewPermissionsServiceClient(conn).WriteRelationships(context.Background(), &v1.WriteRelationshipsRequest{
+		Updates: []*v1.RelationshipUpdate{update(v1.RelationshipUpdate_OPERATION_CREATE, "document", "newdoc", "viewer", "user", "tom")},
+	})
+	require.NoError(err)
+
+	for _, resp := range receiveThrough(written.WrittenAt) {
+		require.Empty(resp.Updates)
+		require.Nil(resp.OptionalTransactionMetadata)
+	}
+
+	// The schema changes key cannot be forged by the transaction metadata of a write.
+	forged, err := structpb.NewStruct(map[string]any{
+		v1svc.WatchSchemaChangesKey: map[string]any{"changedDefinitions": []any{"forged"}},
+	})
+	require.NoError(err)
+
+	_, err = v1.NewPermissionsServiceClient(conn).WriteRelationships(context.Background(), &v1.WriteRelationshipsRequest{
+		Updates:                     []*v1.RelationshipUpdate{update(v1.RelationshipUpdate_OPERATION_CREATE, "folder", "forged", "viewer", "user", "tom")},
+		OptionalTransactionMetadata: forged,
+	})
+	grpcutil.RequireStatus(t, codes.InvalidArgument, err)
+
+	// Schema changes are reported in the transaction metadata.
+	schemaClient := v1.NewSchemaServiceClient(conn)
+	schema, err := schemaClient.ReadSchema(context.Background(), &v1.ReadSchemaRequest{})
+	require.NoError(err)
+
+	schemaWritten, err := schemaClient.WriteSchema(context.Background(), &v1.WriteSchemaRequest{
+		Schema: schema.SchemaText + "\n\ndefinition newdefinition {}",
+	})
+	require.NoError(err)
+
+	var schemaChanges *structpb.Struct
+	for _, resp := range receiveThrough(schemaWritten.WrittenAt) {
+		if changes, ok := resp.OptionalTransactionMetadata.GetFields()[v1svc.WatchSchemaChangesKey]; ok {
+			schemaChanges = changes.GetStructValue()
+		}
+	}
+	require.NotNil(schemaChanges, "expected schema changes")
+	require.Contains(schemaChanges.AsMap()["changedDefinitions"], "newdefinition")
+	require.Empty(schemaChanges.AsMap()["deletedDefinitions"])
+}
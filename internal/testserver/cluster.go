@@ -12,13 +12,16 @@ import (
 
 	"github.com/authzed/consistent"
 	"github.com/cespare/xxhash/v2"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/test/bufconn"
 
 	combineddispatch "github.com/zapravila/spicedb/internal/dispatch/combined"
+	"github.com/zapravila/spicedb/internal/dispatch/membership"
 	"github.com/zapravila/spicedb/pkg/cmd/server"
 	"github.com/zapravila/spicedb/pkg/cmd/util"
 	"github.com/zapravila/spicedb/pkg/datastore"
@@ -156,34 +159,105 @@ func TestClusterWithDispatchAndCacheConfig(t testing.TB, size uint, ds datastore
 	}
 	testResolverBuilder.SetAddrs(prefix, addresses)
 
-	dialers := make([]dialerFunc, 0, size)
+	conns, cleanup := testCluster(t, size, ds, prefix, func(uint) (string, []grpc.DialOption) {
+		return "test://" + prefix, nil
+	}, additionalServerOptions...)
+
+	// resolve after dialers have been set to initialize connections
+	testResolverBuilder.ResolveNow(prefix)
+
+	return conns, cleanup
+}
+
+// TestClusterWithGossipMembership creates a cluster with `size` nodes, whose dispatch hashring is
+// fed by a gossip membership per node, with every node joining via the first. The memberships are
+// returned so that nodes can be made to leave the cluster.
+func TestClusterWithGossipMembership(t testing.TB, size uint, ds datastore.Datastore, gossipInterval time.Duration, additionalServerOptions ...server.ConfigOption) ([]*grpc.ClientConn, []*membership.Gossip, func()) {
+	prefix := getPrefix(t)
+
+	listeners := make(map[string]*bufconn.Listener, size)
+	for i := uint(0); i < size; i++ {
+		listeners[fmt.Sprintf("%s_gossip_%d", prefix, i)] = bufconn.Listen(humanize.MiByte)
+	}
+
+	memberships := make([]*membership.Gossip, 0, size)
+	for i := uint(0); i < size; i++ {
+		gossipAddr := fmt.Sprintf("%s_gossip_%d", prefix, i)
+		g, err := membership.NewGossip(membership.GossipConfig{
+			DispatchAddr:   fmt.Sprintf("%s_%d", prefix, i),
+			AdvertiseAddr:  gossipAddr,
+			Seeds:          []string{fmt.Sprintf("%s_gossip_0", prefix)},
+			Interval:       gossipInterval,
+			FailureTimeout: 10 * gossipInterval,
+			Listener:       listeners[gossipAddr],
+			Dialer: func(ctx context.Context, addr string) (net.Conn, error) {
+				listener, ok := listeners[addr]
+				if !ok {
+					return nil, fmt.Errorf("unknown gossip address %s", addr)
+				}
+				return listener.DialContext(ctx)
+			},
+		})
+		require.NoError(t, err)
+		memberships = append(memberships, g)
+	}
+
+	conns, cleanup := testCluster(t, size, ds, prefix, func(i uint) (string, []grpc.DialOption) {
+		return membership.ResolverScheme + ":///" + prefix, []grpc.DialOption{
+			grpc.WithResolvers(membership.NewResolverBuilder(memberships[i])),
+		}
+	}, additionalServerOptions...)
+
+	return conns, memberships, func() {
+		cleanup()
+		for _, g := range memberships {
+			require.NoError(t, g.Close())
+		}
+	}
+}
+
+// testCluster creates a cluster with `size` nodes, whose dispatchers connect to the upstream address
+// and with the additional dial options returned for each node, which must resolve to addresses of
+// the form `<prefix>_<node number>`.
+func testCluster(t testing.TB, size uint, ds datastore.Datastore, prefix string, upstream func(i uint) (string, []grpc.DialOption), additionalServerOptions ...server.ConfigOption) ([]*grpc.ClientConn, func()) {
+	// dialers are set as each node starts; dialing a node which has not yet started waits for it.
+	dialers := make([]dialerFunc, size)
+	dialersReady := make([]chan struct{}, size)
+	for i := range dialersReady {
+		dialersReady[i] = make(chan struct{})
+	}
 	conns := make([]*grpc.ClientConn, 0, size)
 	cancelFuncs := make([]func(), 0, size)
 
 	for i := uint(0); i < size; i++ {
+		upstreamAddr, upstreamDialOpts := upstream(i)
 		dispatcherOptions := []combineddispatch.Option{
-			combineddispatch.UpstreamAddr("test://" + prefix),
+			combineddispatch.UpstreamAddr(upstreamAddr),
 			combineddispatch.PrometheusSubsystem(fmt.Sprintf("%s_%d_client_dispatch", prefix, i)),
-			combineddispatch.GrpcDialOpts(
+			combineddispatch.GrpcDialOpts(append([]grpc.DialOption{
 				grpc.WithDefaultServiceConfig(
 					(&consistent.BalancerConfig{
 						ReplicationFactor: 1500,
 						Spread:            1,
 					}).MustServiceConfigJSON()),
 				grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
-					// it's possible grpc tries to dial before we have set the
-					// buffconn dialers, we have to return a "TempError" so that
-					// grpc knows to retry the connection.
-					if len(dialers) == 0 {
-						return nil, TempError{}
-					}
-					// "s" here will be the address from the manual resolver
+					// "s" here will be the address from the resolver
 					// like `<prefix>_<node number>`
 					i, err := strconv.Atoi(strings.TrimPrefix(s, prefix+"_"))
 					require.NoError(t, err)
-					return dialers[i](ctx, s)
+
+					// it's possible grpc tries to dial before we have set the
+					// buffconn dialer for the node, so we wait for it, returning
+					// a "TempError" so that grpc knows to retry the connection
+					// if the dial is cancelled first.
+					select {
+					case <-dialersReady[i]:
+						return dialers[i](ctx, s)
+					case <-ctx.Done():
+						return nil, TempError{}
+					}
 				}),
-			),
+			}, upstreamDialOpts...)...),
 		}
 
 		dispatcher, err := combineddispatch.NewDispatcher(dispatcherOptions...)
@@ -223,7 +297,8 @@ func TestClusterWithDispatchAndCacheConfig(t testing.TB, size uint, ds datastore
 		}()
 		cancelFuncs = append(cancelFuncs, cancel)
 
-		dialers = append(dialers, srv.DispatchNetDialContext)
+		dialers[i] = srv.DispatchNetDialContext
+		close(dialersReady[i])
 
 		// TODO: move off of WithBlock and WithReturnConnectionError
 		conn, err := srv.GRPCDialContext(ctx,
@@ -240,9 +315,6 @@ func TestClusterWithDispatchAndCacheConfig(t testing.TB, size uint, ds datastore
 		conns = append(conns, conn)
 	}
 
-	// resolve after dialers have been set to initialize connections
-	testResolverBuilder.ResolveNow(prefix)
-
 	return conns, func() {
 		for _, c := range conns {
 			require.NoError(t, c.Close())
@@ -21,11 +21,14 @@ import (
 
 // ServerConfig is configuration for the test server.
 type ServerConfig struct {
-	MaxUpdatesPerWrite              uint16
-	MaxPreconditionsCount           uint16
-	MaxRelationshipContextSize      int
-	StreamingAPITimeout             time.Duration
-	UseExperimentalLookupResources2 bool
+	MaxUpdatesPerWrite                  uint16
+	MaxPreconditionsCount               uint16
+	MaxRelationshipContextSize          int
+	StreamingAPITimeout                 time.Duration
+	UseExperimentalLookupResources2     bool
+	PresharedSecureKeys                 []string
+	EnablePermissionWatchAPI            bool
+	MaxPermissionWatchAffectedResources uint32
 }
 
 var DefaultTestServerConfig = ServerConfig{
@@ -93,6 +96,9 @@ func NewTestServerWithConfigAndDatastore(require *require.Assertions,
 		server.WithMetricsAPI(util.HTTPServerConfig{HTTPEnabled: false}),
 		server.WithDispatchServer(util.GRPCServerConfig{Enabled: false}),
 		server.WithEnableExperimentalLookupResources(config.UseExperimentalLookupResources2),
+		server.WithEnablePermissionWatchAPI(config.EnablePermissionWatchAPI),
+		server.WithMaxPermissionWatchAffectedResources(config.MaxPermissionWatchAffectedResources),
+		server.SetPresharedSecureKey(config.PresharedSecureKeys),
 		server.SetUnaryMiddlewareModification([]server.MiddlewareModification[grpc.UnaryServerInterceptor]{
 			{
 				Operation: server.OperationReplaceAllUnsafe,
@@ -0,0 +1,353 @@
+// Package warmstart persists the keys of the hottest entries of in-memory caches to local disk,
+// so that a restarted node can load their entries before serving rather than starting with empty
+// caches.
+package warmstart
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/pkg/cache"
+)
+
+const (
+	// DefaultSnapshotInterval is the default interval at which caches are snapshotted to disk.
+	DefaultSnapshotInterval = 1 * time.Minute
+
+	// DefaultMaxEntries is the default maximum number of entries snapshotted per cache.
+	DefaultMaxEntries = 10_000
+
+	// snapshotVersion is the version of the snapshot file format, which is increased whenever the
+	// format or the encoding of any persisted key changes.
+	snapshotVersion = 2
+
+	// trackedEntriesFactor is the factor of the maximum number of entries which are tracked
+	// between snapshots; further entries are not tracked until the next snapshot drops the least
+	// used.
+	trackedEntriesFactor = 2
+)
+
+var (
+	restoredEntriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "warmstart",
+		Name:      "restored_entries_total",
+		Help:      "number of cache entries loaded for the keys of a snapshot, by cache",
+	}, []string{"cache"})
+
+	snapshottedEntriesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "spicedb",
+		Subsystem: "warmstart",
+		Name:      "snapshotted_entries",
+		Help:      "number of cache entries in the last snapshot written, by cache",
+	}, []string{"cache"})
+)
+
+func init() {
+	prometheus.MustRegister(restoredEntriesCounter, snapshottedEntriesGauge)
+}
+
+// Codec converts the keys of a cache to the form in which they are persisted, and loads the
+// entries for persisted keys.
+type Codec[K cache.KeyString, V any] interface {
+	// PersistedKey returns the key under which the hits of the entry for the given key are
+	// recorded and persisted. It must be stable across processes and independent of the revision
+	// at which the entry was computed, as a restarted node selects different revisions. Returns
+	// false if the entry is not persisted.
+	PersistedKey(key K) ([]byte, bool)
+
+	// Load loads the current entries for the given persisted keys, returning them under the keys
+	// at which they will be looked up next.
+	Load(ctx context.Context, persistedKeys [][]byte) ([]Entry[K, V], error)
+}
+
+// Entry is an entry loaded for a persisted key, to be added to the cache.
+type Entry[K cache.KeyString, V any] struct {
+	Key   K
+	Value V
+	Cost  int64
+}
+
+// Config configures the persistence of a cache.
+type Config struct {
+	// Path is the path of the snapshot file.
+	Path string
+
+	// SnapshotInterval is the interval at which the cache is snapshotted.
+	SnapshotInterval time.Duration
+
+	// MaxEntries is the maximum number of entries, with the most hits, in a snapshot.
+	MaxEntries int
+}
+
+// Cache is a cache which tracks the hits of its entries, periodically snapshotting the keys of the
+// hottest to disk, and which loads the entries for the keys of the last snapshot on restore.
+type Cache[K cache.KeyString, V any] struct {
+	name   string
+	inner  cache.Cache[K, V]
+	codec  Codec[K, V]
+	config Config
+
+	// tracked maps the persisted keys of entries to their number of hits, so that hits on
+	// existing entries are recorded without locking.
+	tracked      sync.Map
+	trackedCount atomic.Int64
+
+	lock     sync.Mutex
+	ready    bool
+	readyMsg string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// persistedEntry is an entry of a snapshot file.
+type persistedEntry struct {
+	Key  []byte `json:"key"`
+	Hits uint64 `json:"hits"`
+}
+
+type snapshotFile struct {
+	Version int              `json:"version"`
+	Entries []persistedEntry `json:"entries"`
+}
+
+var _ cache.Cache[cache.StringKey, any] = (*Cache[cache.StringKey, any])(nil)
+
+// NewCache returns a Cache in front of the given cache, which is persisted to the configured path
+// once restored from it via Restore or Start.
+func NewCache[K cache.KeyString, V any](name string, inner cache.Cache[K, V], codec Codec[K, V], config Config) *Cache[K, V] {
+	if config.SnapshotInterval <= 0 {
+		config.SnapshotInterval = DefaultSnapshotInterval
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultMaxEntries
+	}
+
+	return &Cache[K, V]{
+		name:     name,
+		inner:    inner,
+		codec:    codec,
+		config:   config,
+		readyMsg: fmt.Sprintf("%s cache has not yet been restored", name),
+	}
+}
+
+// Start restores the cache from its snapshot in the background, then snapshots it periodically
+// until the cache is closed.
+func (c *Cache[K, V]) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		if err := c.Restore(ctx); err != nil {
+			log.Warn().Err(err).Str("cache", c.name).Msg("failed to restore cache snapshot; starting with an empty cache")
+		}
+
+		ticker := time.NewTicker(c.config.SnapshotInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-ticker.C:
+				if err := c.Snapshot(); err != nil {
+					log.Warn().Err(err).Str("cache", c.name).Msg("failed to snapshot cache")
+				}
+			}
+		}
+	}()
+}
+
+// Restore loads the entries for the keys of the snapshot, if any, into the cache. The cache is
+// ready once Restore has returned, whether or not it succeeded.
+func (c *Cache[K, V]) Restore(ctx context.Context) error {
+	defer func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.ready = true
+		c.readyMsg = ""
+	}()
+
+	contents, err := os.ReadFile(c.config.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache snapshot: %w", err)
+	}
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(contents, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse cache snapshot `%s`: %w", c.config.Path, err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("unsupported version %d of cache snapshot `%s`", snapshot.Version, c.config.Path)
+	}
+
+	if len(snapshot.Entries) > c.config.MaxEntries {
+		snapshot.Entries = snapshot.Entries[:c.config.MaxEntries]
+	}
+
+	// Keep the hits of the restored keys, so that they are snapshotted again while still hot.
+	persistedKeys := make([][]byte, 0, len(snapshot.Entries))
+	for _, entry := range snapshot.Entries {
+		persistedKeys = append(persistedKeys, entry.Key)
+		c.track(string(entry.Key), entry.Hits)
+	}
+
+	entries, err := c.codec.Load(ctx, persistedKeys)
+	if err != nil {
+		return fmt.Errorf("failed to load the entries of cache snapshot `%s`: %w", c.config.Path, err)
+	}
+
+	for _, entry := range entries {
+		c.inner.Set(entry.Key, entry.Value, entry.Cost)
+	}
+	c.inner.Wait()
+
+	restoredEntriesCounter.WithLabelValues(c.name).Add(float64(len(entries)))
+	log.Info().Str("cache", c.name).Int("entries", len(entries)).Msg("restored cache snapshot")
+	return nil
+}
+
+// Snapshot writes the keys of the hottest entries of the cache to disk, replacing the previous
+// snapshot, and stops tracking the others.
+func (c *Cache[K, V]) Snapshot() error {
+	entries := make([]persistedEntry, 0, c.trackedCount.Load())
+	c.tracked.Range(func(key, hits any) bool {
+		entries = append(entries, persistedEntry{
+			Key:  []byte(key.(string)),
+			Hits: hits.(*atomic.Uint64).Load(),
+		})
+		return true
+	})
+
+	slices.SortFunc(entries, func(a, b persistedEntry) int {
+		return cmp.Compare(b.Hits, a.Hits)
+	})
+	if len(entries) > c.config.MaxEntries {
+		for _, dropped := range entries[c.config.MaxEntries:] {
+			c.tracked.Delete(string(dropped.Key))
+			c.trackedCount.Add(-1)
+		}
+		entries = entries[:c.config.MaxEntries]
+	}
+
+	contents, err := json.Marshal(snapshotFile{Version: snapshotVersion, Entries: entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+
+	// Write to a temporary file and rename it, so that a crash never leaves a partial snapshot.
+	tempFile, err := os.CreateTemp(filepath.Dir(c.config.Path), filepath.Base(c.config.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache snapshot: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tempFile.Name(), c.config.Path); err != nil {
+		return fmt.Errorf("failed to replace cache snapshot: %w", err)
+	}
+
+	snapshottedEntriesGauge.WithLabelValues(c.name).Set(float64(len(entries)))
+	log.Debug().Str("cache", c.name).Int("entries", len(entries)).Msg("wrote cache snapshot")
+	return nil
+}
+
+// ReadyState returns whether the cache has been restored from its snapshot.
+func (c *Cache[K, V]) ReadyState() (bool, string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ready, c.readyMsg
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	value, ok := c.inner.Get(key)
+	if !ok {
+		return value, false
+	}
+
+	if persistedKey, ok := c.codec.PersistedKey(key); ok {
+		if hits, ok := c.tracked.Load(string(persistedKey)); ok {
+			hits.(*atomic.Uint64).Add(1)
+		}
+	}
+	return value, true
+}
+
+func (c *Cache[K, V]) Set(key K, value V, cost int64) bool {
+	if persistedKey, ok := c.codec.PersistedKey(key); ok {
+		c.track(string(persistedKey), 0)
+	}
+	return c.inner.Set(key, value, cost)
+}
+
+// track starts tracking the hits of the given persisted key, unless it is already tracked or as
+// many keys as are tracked between snapshots already are.
+func (c *Cache[K, V]) track(persistedKey string, hits uint64) {
+	if _, ok := c.tracked.Load(persistedKey); ok {
+		return
+	}
+	if c.trackedCount.Load() >= int64(c.config.MaxEntries*trackedEntriesFactor) {
+		return
+	}
+
+	counter := &atomic.Uint64{}
+	counter.Store(hits)
+	if _, loaded := c.tracked.LoadOrStore(persistedKey, counter); !loaded {
+		c.trackedCount.Add(1)
+	}
+}
+
+func (c *Cache[K, V]) Wait() {
+	c.inner.Wait()
+}
+
+// Close stops snapshotting the cache, writing a final snapshot, and closes the inner cache.
+func (c *Cache[K, V]) Close() {
+	if c.cancel != nil {
+		c.cancel()
+		c.wg.Wait()
+		c.cancel = nil
+
+		if err := c.Snapshot(); err != nil {
+			log.Warn().Err(err).Str("cache", c.name).Msg("failed to snapshot cache on close")
+		}
+	}
+	c.inner.Close()
+}
+
+func (c *Cache[K, V]) GetMetrics() cache.Metrics {
+	return c.inner.GetMetrics()
+}
+
+func (c *Cache[K, V]) MarshalZerologObject(e *zerolog.Event) {
+	e.Object("cache", c.inner).Str("snapshotPath", c.config.Path).Dur("snapshotInterval", c.config.SnapshotInterval)
+}
@@ -0,0 +1,224 @@
+package warmstart
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zapravila/spicedb/pkg/cache"
+)
+
+// revisionedCodec persists keys of the form `name@revision` without their revision, and loads
+// their entries at the current revision.
+type revisionedCodec struct {
+	revision string
+	loadErr  error
+}
+
+func (rc *revisionedCodec) PersistedKey(key cache.StringKey) ([]byte, bool) {
+	name, _, ok := strings.Cut(string(key), "@")
+	return []byte(name), ok && name != "unpersisted"
+}
+
+func (rc *revisionedCodec) Load(_ context.Context, persistedKeys [][]byte) ([]Entry[cache.StringKey, string], error) {
+	if rc.loadErr != nil {
+		return nil, rc.loadErr
+	}
+
+	entries := make([]Entry[cache.StringKey, string], 0, len(persistedKeys))
+	for _, persistedKey := range persistedKeys {
+		value := string(persistedKey) + " value"
+		entries = append(entries, Entry[cache.StringKey, string]{
+			Key:   cache.StringKey(string(persistedKey) + "@" + rc.revision),
+			Value: value,
+			Cost:  int64(len(value)),
+		})
+	}
+	return entries, nil
+}
+
+func newTestCache(t *testing.T, codec *revisionedCodec, config Config) *Cache[cache.StringKey, string] {
+	inner, err := cache.NewStandardCache[cache.StringKey, string](&cache.Config{
+		NumCounters: 1000,
+		MaxCost:     humanize.MiByte,
+	})
+	require.NoError(t, err)
+	return NewCache[cache.StringKey, string]("test", inner, codec, config)
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	config := Config{Path: filepath.Join(t.TempDir(), "test.snapshot")}
+
+	first := newTestCache(t, &revisionedCodec{revision: "1"}, config)
+	require.NoError(t, first.Restore(context.Background()))
+	first.Set("first@1", "first value", 11)
+	first.Set("second@1", "second value", 12)
+	first.Set("unpersisted@1", "unpersisted value", 17)
+	require.NoError(t, first.Snapshot())
+	first.Close()
+
+	// The restarted node selects a different revision, at which the entries are loaded.
+	second := newTestCache(t, &revisionedCodec{revision: "2"}, config)
+	t.Cleanup(second.Close)
+	ready, _ := second.ReadyState()
+	require.False(t, ready)
+
+	require.NoError(t, second.Restore(context.Background()))
+	ready, msg := second.ReadyState()
+	require.True(t, ready)
+	require.Empty(t, msg)
+
+	value, ok := second.Get("first@2")
+	require.True(t, ok)
+	require.Equal(t, "first value", value)
+
+	value, ok = second.Get("second@2")
+	require.True(t, ok)
+	require.Equal(t, "second value", value)
+
+	_, ok = second.Get("first@1")
+	require.False(t, ok)
+
+	_, ok = second.Get("unpersisted@2")
+	require.False(t, ok)
+}
+
+func TestRestoreMissingSnapshot(t *testing.T) {
+	c := newTestCache(t, &revisionedCodec{revision: "1"}, Config{Path: filepath.Join(t.TempDir(), "missing.snapshot")})
+	t.Cleanup(c.Close)
+
+	require.NoError(t, c.Restore(context.Background()))
+	ready, _ := c.ReadyState()
+	require.True(t, ready)
+
+	_, ok := c.Get("first@1")
+	require.False(t, ok)
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.snapshot")
+	require.NoError(t, os.WriteFile(path, []byte("not a snapshot"), 0o600))
+
+	c := newTestCache(t, &revisionedCodec{revision: "1"}, Config{Path: path})
+	t.Cleanup(c.Close)
+
+	require.Error(t, c.Restore(context.Background()))
+
+	// A cache which failed to restore is still ready, starting empty.
+	ready, _ := c.ReadyState()
+	require.True(t, ready)
+
+	contents, err := json.Marshal(snapshotFile{Version: snapshotVersion + 1})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, contents, 0o600))
+	require.ErrorContains(t, c.Restore(context.Background()), "unsupported version")
+}
+
+func TestRestoreLoadFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.snapshot")
+	contents, err := json.Marshal(snapshotFile{
+		Version: snapshotVersion,
+		Entries: []persistedEntry{{Key: []byte("first"), Hits: 1}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, contents, 0o600))
+
+	c := newTestCache(t, &revisionedCodec{revision: "1", loadErr: errors.New("datastore unavailable")}, Config{Path: path})
+	t.Cleanup(c.Close)
+
+	require.ErrorContains(t, c.Restore(context.Background()), "datastore unavailable")
+	ready, _ := c.ReadyState()
+	require.True(t, ready)
+
+	_, ok := c.Get("first@1")
+	require.False(t, ok)
+}
+
+func TestSnapshotKeepsHottestEntries(t *testing.T) {
+	config := Config{Path: filepath.Join(t.TempDir(), "test.snapshot"), MaxEntries: 2}
+
+	c := newTestCache(t, &revisionedCodec{revision: "1"}, config)
+	require.NoError(t, c.Restore(context.Background()))
+	for _, name := range []string{"cold", "warm", "hot"} {
+		c.Set(cache.StringKey(name+"@1"), name+" value", 10)
+	}
+	c.Wait()
+
+	for range 3 {
+		_, ok := c.Get("hot@1")
+		require.True(t, ok)
+	}
+	_, ok := c.Get("warm@1")
+	require.True(t, ok)
+
+	require.NoError(t, c.Snapshot())
+
+	// The least used entries are no longer tracked once snapshotted.
+	require.Equal(t, int64(2), c.trackedCount.Load())
+	_, ok = c.tracked.Load("cold")
+	require.False(t, ok)
+	c.Close()
+
+	contents, err := os.ReadFile(config.Path)
+	require.NoError(t, err)
+
+	var snapshot snapshotFile
+	require.NoError(t, json.Unmarshal(contents, &snapshot))
+	require.Len(t, snapshot.Entries, 2)
+	require.Equal(t, "hot", string(snapshot.Entries[0].Key))
+	require.Equal(t, uint64(3), snapshot.Entries[0].Hits)
+	require.Equal(t, "warm", string(snapshot.Entries[1].Key))
+}
+
+func TestHitsAreRecordedAcrossRevisions(t *testing.T) {
+	config := Config{Path: filepath.Join(t.TempDir(), "test.snapshot")}
+
+	c := newTestCache(t, &revisionedCodec{revision: "1"}, config)
+	t.Cleanup(c.Close)
+	require.NoError(t, c.Restore(context.Background()))
+
+	for _, revision := range []string{"1", "2"} {
+		key := cache.StringKey("first@" + revision)
+		c.Set(key, "first value", 11)
+		c.Wait()
+
+		_, ok := c.Get(key)
+		require.True(t, ok)
+	}
+
+	hits, ok := c.tracked.Load("first")
+	require.True(t, ok)
+	require.Equal(t, int64(1), c.trackedCount.Load())
+	require.Equal(t, uint64(2), hits.(*atomic.Uint64).Load())
+}
+
+func TestCloseWritesSnapshot(t *testing.T) {
+	config := Config{Path: filepath.Join(t.TempDir(), "test.snapshot"), SnapshotInterval: time.Hour}
+
+	c := newTestCache(t, &revisionedCodec{revision: "1"}, config)
+	c.Start()
+	require.Eventually(t, func() bool {
+		ready, _ := c.ReadyState()
+		return ready
+	}, 5*time.Second, 10*time.Millisecond)
+
+	c.Set("first@1", "first value", 11)
+	c.Close()
+
+	restored := newTestCache(t, &revisionedCodec{revision: "2"}, config)
+	t.Cleanup(restored.Close)
+	require.NoError(t, restored.Restore(context.Background()))
+
+	value, ok := restored.Get("first@2")
+	require.True(t, ok)
+	require.Equal(t, "first value", value)
+}
@@ -0,0 +1,246 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultBatchSize is the default maximum number of changes delivered in a single request.
+	DefaultBatchSize = 100
+
+	// DefaultBatchInterval is the default maximum duration for which changes are held to be
+	// batched with later changes.
+	DefaultBatchInterval = time.Second
+
+	// DefaultMaxAttempts is the default number of attempts to deliver a batch, after which it is
+	// written to the dead-letter log.
+	DefaultMaxAttempts = 5
+
+	// DefaultInitialBackoff is the default delay before the first retry of a failed delivery,
+	// doubled for each following retry.
+	DefaultInitialBackoff = time.Second
+
+	// DefaultMaxBackoff is the default maximum delay between the retries of a failed delivery.
+	DefaultMaxBackoff = time.Minute
+
+	// DefaultTimeout is the default timeout of each delivery request.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultDeadLetterMaxBytes is the default size beyond which the dead-letter log of a
+	// subscription is rotated.
+	DefaultDeadLetterMaxBytes = 64 << 20
+)
+
+var subscriptionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Config is the contents of a webhook configuration file, e.g.:
+//
+//	stateDirectory: /var/lib/spicedb/webhooks
+//	deadLetterMaxBytes: 67108864
+//	subscriptions:
+//	  - name: documents
+//	    url: https://example.com/hooks/spicedb
+//	    secret: some-shared-secret
+//	    filters:
+//	      - resourceType: document
+//	        optionalRelation: viewer
+//	      - resourceType: folder
+//	        optionalSubjectFilter:
+//	          subjectType: user
+//	    batchSize: 50
+//	    batchInterval: 500ms
+type Config struct {
+	// StateDirectory is the directory holding the delivery cursor and the dead-letter log of each
+	// subscription. It is local to the node delivering the webhooks, which must be the only one to
+	// do so.
+	StateDirectory string `yaml:"stateDirectory"`
+
+	// DeadLetterMaxBytes is the size beyond which the dead-letter log of a subscription is rotated
+	// to a `.1` file, replacing the one previously rotated, so that at most twice this size is
+	// retained. Defaults to DefaultDeadLetterMaxBytes.
+	DeadLetterMaxBytes int64 `yaml:"deadLetterMaxBytes"`
+
+	Subscriptions []Subscription `yaml:"subscriptions"`
+}
+
+// Subscription is the delivery of the relationship changes matching its filters to a URL.
+type Subscription struct {
+	// Name identifies the subscription in metrics, logs and state files, and must be unique.
+	Name string `yaml:"name"`
+
+	// URL is the URL to which the changes are POSTed.
+	URL string `yaml:"url"`
+
+	// Secret is the key with which the payloads are signed. See Sign.
+	Secret string `yaml:"secret"`
+
+	// Filters select the relationship changes delivered, in the form of a RelationshipFilter of
+	// the API. A change is delivered if it matches any filter, or if there are no filters.
+	Filters []Filter `yaml:"filters"`
+
+	// BatchSize is the maximum number of changes delivered in a single request. Defaults to
+	// DefaultBatchSize.
+	BatchSize int `yaml:"batchSize"`
+
+	// BatchInterval is the maximum duration for which changes are held to be batched with later
+	// changes. Defaults to DefaultBatchInterval.
+	BatchInterval time.Duration `yaml:"batchInterval"`
+
+	// MaxAttempts is the number of attempts to deliver a batch, after which it is written to the
+	// dead-letter log. Defaults to DefaultMaxAttempts.
+	MaxAttempts int `yaml:"maxAttempts"`
+
+	// InitialBackoff is the delay before the first retry of a failed delivery. Defaults to
+	// DefaultInitialBackoff.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+
+	// MaxBackoff is the maximum delay between retries. Defaults to DefaultMaxBackoff.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+
+	// Timeout is the timeout of each delivery request. Defaults to DefaultTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Filter is a RelationshipFilter of the API, given in its JSON form.
+type Filter struct {
+	*v1.RelationshipFilter
+}
+
+// UnmarshalYAML decodes the filter from its JSON form.
+func (f *Filter) UnmarshalYAML(node *yaml.Node) error {
+	var decoded any
+	if err := node.Decode(&decoded); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+
+	filter := &v1.RelationshipFilter{}
+	if err := protojson.Unmarshal(encoded, filter); err != nil {
+		return err
+	}
+
+	f.RelationshipFilter = filter
+	return nil
+}
+
+// LoadConfig reads and parses a webhook configuration file.
+func LoadConfig(path string) (*Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook configuration file: %w", err)
+	}
+
+	config, err := ParseConfig(contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook configuration file `%s`: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseConfig parses and validates the contents of a webhook configuration file, applying the
+// defaults of the subscriptions.
+func ParseConfig(contents []byte) (*Config, error) {
+	config := &Config{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if config.StateDirectory == "" {
+		return nil, fmt.Errorf("a state directory is required")
+	}
+
+	switch {
+	case config.DeadLetterMaxBytes < 0:
+		return nil, fmt.Errorf("the dead-letter maximum size cannot be negative")
+	case config.DeadLetterMaxBytes == 0:
+		config.DeadLetterMaxBytes = DefaultDeadLetterMaxBytes
+	}
+
+	names := make(map[string]struct{}, len(config.Subscriptions))
+	for index := range config.Subscriptions {
+		subscription := &config.Subscriptions[index]
+		if subscription.Name == "" {
+			return nil, fmt.Errorf("subscription #%d is missing a name", index+1)
+		}
+		if _, ok := names[subscription.Name]; ok {
+			return nil, fmt.Errorf("duplicate subscription `%s`", subscription.Name)
+		}
+		names[subscription.Name] = struct{}{}
+
+		if err := subscription.validate(); err != nil {
+			return nil, fmt.Errorf("invalid subscription `%s`: %w", subscription.Name, err)
+		}
+		subscription.applyDefaults()
+	}
+
+	return config, nil
+}
+
+func (s Subscription) validate() error {
+	if !subscriptionNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("name must only contain letters, digits, `-` and `_`")
+	}
+
+	parsed, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("url must be http or https")
+	}
+
+	if s.Secret == "" {
+		return fmt.Errorf("a secret is required")
+	}
+
+	for index, filter := range s.Filters {
+		if err := filter.Validate(); err != nil {
+			return fmt.Errorf("invalid filter #%d: %w", index+1, err)
+		}
+	}
+
+	if s.BatchSize < 0 || s.MaxAttempts < 0 || s.BatchInterval < 0 || s.InitialBackoff < 0 || s.MaxBackoff < 0 || s.Timeout < 0 {
+		return fmt.Errorf("batch sizes, attempts and durations cannot be negative")
+	}
+	return nil
+}
+
+func (s *Subscription) applyDefaults() {
+	if s.BatchSize == 0 {
+		s.BatchSize = DefaultBatchSize
+	}
+	if s.BatchInterval == 0 {
+		s.BatchInterval = DefaultBatchInterval
+	}
+	if s.MaxAttempts == 0 {
+		s.MaxAttempts = DefaultMaxAttempts
+	}
+	if s.InitialBackoff == 0 {
+		s.InitialBackoff = DefaultInitialBackoff
+	}
+	if s.MaxBackoff == 0 {
+		s.MaxBackoff = DefaultMaxBackoff
+	}
+	if s.Timeout == 0 {
+		s.Timeout = DefaultTimeout
+	}
+}
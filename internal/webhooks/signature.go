@@ -0,0 +1,37 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// SignatureHeader is the header holding the signature of a delivery, as returned by Sign.
+	SignatureHeader = "X-SpiceDB-Signature"
+
+	// TimestampHeader is the header holding the Unix time in seconds at which a delivery was
+	// signed.
+	TimestampHeader = "X-SpiceDB-Timestamp"
+
+	// SubscriptionHeader is the header holding the name of the subscription of a delivery.
+	SubscriptionHeader = "X-SpiceDB-Subscription"
+
+	signaturePrefix = "sha256="
+)
+
+// Sign returns the signature of a delivery: the hex-encoded HMAC-SHA256 of the timestamp, a `.`
+// and the body, keyed with the secret of the subscription and prefixed with `sha256=`. Including
+// the timestamp allows receivers to reject replayed deliveries.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns whether the signature is that of the timestamp and body for the secret.
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
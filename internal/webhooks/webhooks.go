@@ -0,0 +1,455 @@
+// Package webhooks delivers the relationship changes of the datastore to HTTP endpoints, as
+// configured by a file of subscriptions.
+//
+// Each subscription watches the datastore from its persisted cursor, batches the changes matching
+// its filters and POSTs them as signed JSON payloads, retrying failed deliveries with an
+// exponential backoff. Batches which cannot be delivered are appended to a dead-letter log, so
+// that a failing endpoint does not stall the subscription. The cursor is only advanced once the
+// changes before it have been delivered or dead-lettered, so that changes are delivered at least
+// once across restarts.
+//
+// The cursors and dead-letter logs are files of a local state directory, so the webhooks must be
+// delivered by a single node: each node delivering them would deliver every change, from its own
+// cursor. Delivery is therefore only enabled on the node designated to deliver them.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+	"github.com/zapravila/spicedb/pkg/zedtoken"
+)
+
+// restartDelay is the delay before watching the datastore again after the watch of a subscription
+// fails.
+const restartDelay = 5 * time.Second
+
+var deliveriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spicedb",
+	Subsystem: "webhooks",
+	Name:      "deliveries_total",
+	Help:      "number of webhook delivery attempts, by subscription and result",
+}, []string{"subscription", "result"})
+
+func init() {
+	prometheus.MustRegister(deliveriesCounter)
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	// Subscription is the name of the subscription.
+	Subscription string `json:"subscription"`
+
+	// Changes are the relationship changes, in the order in which they were made.
+	Changes []Change `json:"changes"`
+}
+
+// Change is a relationship change of a delivery.
+type Change struct {
+	// ChangedAt is the ZedToken of the revision at which the change was made.
+	ChangedAt string `json:"changedAt"`
+
+	// Update is the RelationshipUpdate of the API, in its JSON form.
+	Update json.RawMessage `json:"update"`
+}
+
+// deadLetter is an entry of the dead-letter log of a subscription.
+type deadLetter struct {
+	FailedAt time.Time       `json:"failedAt"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// Deliverer delivers the changes of the datastore to the subscriptions of a configuration file.
+type Deliverer struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDeliverer creates a Deliverer for the subscriptions of the configuration file, and starts
+// delivering their changes in the background until closed.
+func NewDeliverer(path string, ds datastore.Datastore) (*Deliverer, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(config.StateDirectory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create webhook state directory: %w", err)
+	}
+
+	subscriptions := make([]*subscription, 0, len(config.Subscriptions))
+	for _, subscriptionConfig := range config.Subscriptions {
+		s, err := newSubscription(subscriptionConfig, ds)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subscription `%s`: %w", subscriptionConfig.Name, err)
+		}
+		subscriptions = append(subscriptions, s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Deliverer{cancel: cancel}
+	for _, s := range subscriptions {
+		s.cursorPath = filepath.Join(config.StateDirectory, s.Name+".cursor")
+		s.deadLetterPath = filepath.Join(config.StateDirectory, s.Name+".deadletter.jsonl")
+		s.deadLetterMaxBytes = config.DeadLetterMaxBytes
+
+		d.wg.Add(1)
+		go func(s *subscription) {
+			defer d.wg.Done()
+			s.run(ctx)
+		}(s)
+	}
+
+	log.Info().Str("path", path).Int("subscriptions", len(subscriptions)).Msg("started webhook delivery")
+	return d, nil
+}
+
+// Close stops the delivery of changes. Changes which were not yet delivered are delivered again
+// once restarted.
+func (d *Deliverer) Close() error {
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
+
+type subscription struct {
+	Subscription
+
+	ds                 datastore.Datastore
+	filters            []datastore.RelationshipsFilter
+	client             *http.Client
+	cursorPath         string
+	deadLetterPath     string
+	deadLetterMaxBytes int64
+	now                func() time.Time
+}
+
+func newSubscription(config Subscription, ds datastore.Datastore) (*subscription, error) {
+	filters := make([]datastore.RelationshipsFilter, 0, len(config.Filters))
+	for _, filter := range config.Filters {
+		converted, err := datastore.RelationshipsFilterFromPublicFilter(filter.RelationshipFilter)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, converted)
+	}
+
+	return &subscription{
+		Subscription: config,
+		ds:           ds,
+		filters:      filters,
+		client:       &http.Client{Timeout: config.Timeout},
+		now:          time.Now,
+	}, nil
+}
+
+// run delivers the changes of the subscription, watching the datastore again whenever the watch
+// fails, until the context is canceled.
+func (s *subscription) run(ctx context.Context) {
+	for {
+		err := s.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Ctx(ctx).Warn().Err(err).Str("subscription", s.Name).Dur("retry-after", restartDelay).Msg("webhook subscription failed to watch changes; restarting")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+func (s *subscription) watch(ctx context.Context) error {
+	cursor, err := s.loadCursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	updates, errchan := s.ds.Watch(ctx, cursor, datastore.WatchOptions{
+		Content: datastore.WatchRelationships | datastore.WatchCheckpoints,
+	})
+
+	var pending []Change
+	var batchTimer <-chan time.Time
+	through := cursor
+	persistedAt := s.now()
+
+	flush := func() error {
+		for len(pending) > 0 {
+			size := min(len(pending), s.BatchSize)
+			if err := s.deliver(ctx, pending[:size]); err != nil {
+				return err
+			}
+			pending = pending[size:]
+		}
+		batchTimer = nil
+
+		persistedAt = s.now()
+		return s.saveCursor(through)
+	}
+
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return <-errchan
+			}
+
+			changes, err := s.changes(update)
+			if err != nil {
+				return err
+			}
+
+			through = update.Revision
+			pending = append(pending, changes...)
+			switch {
+			case len(pending) >= s.BatchSize:
+				if err := flush(); err != nil {
+					return err
+				}
+
+			case len(pending) > 0:
+				if batchTimer == nil {
+					batchTimer = time.After(s.BatchInterval)
+				}
+
+			case s.now().Sub(persistedAt) >= s.BatchInterval:
+				// Advance the cursor past the checkpoints and the changes not matching the filters,
+				// without writing it for each of them.
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+		case <-batchTimer:
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case err := <-errchan:
+			return err
+		}
+	}
+}
+
+// changes returns the changes of the revision matching the filters of the subscription.
+func (s *subscription) changes(update *datastore.RevisionChanges) ([]Change, error) {
+	if update.IsCheckpoint || len(update.RelationshipChanges) == 0 {
+		return nil, nil
+	}
+
+	changedAt, err := zedtoken.NewFromRevision(update.Revision)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, candidate := range update.RelationshipChanges {
+		if !s.matches(candidate.Tuple) {
+			continue
+		}
+
+		encoded, err := protojson.Marshal(tuple.UpdateToRelationshipUpdate(candidate))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{ChangedAt: changedAt.Token, Update: encoded})
+	}
+	return changes, nil
+}
+
+func (s *subscription) matches(relationship *core.RelationTuple) bool {
+	if len(s.filters) == 0 {
+		return true
+	}
+
+	for _, filter := range s.filters {
+		if filter.Test(relationship) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver delivers the changes, retrying with an exponential backoff up to the maximum number of
+// attempts, after which they are written to the dead-letter log. An error is only returned if the
+// context is canceled or the dead-letter log cannot be written.
+func (s *subscription) deliver(ctx context.Context, changes []Change) error {
+	body, err := json.Marshal(Payload{Subscription: s.Name, Changes: changes})
+	if err != nil {
+		return err
+	}
+
+	backoffInterval := backoff.NewExponentialBackOff()
+	backoffInterval.InitialInterval = s.InitialBackoff
+	backoffInterval.MaxInterval = s.MaxBackoff
+	backoffInterval.Multiplier = 2
+	backoffInterval.MaxElapsedTime = 0
+	backoffInterval.Reset()
+
+	var deliveryErr error
+	for attempt := 1; ; attempt++ {
+		deliveryErr = s.post(ctx, body)
+		if deliveryErr == nil {
+			deliveriesCounter.WithLabelValues(s.Name, "delivered").Inc()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		deliveriesCounter.WithLabelValues(s.Name, "failed").Inc()
+		if attempt >= s.MaxAttempts {
+			break
+		}
+
+		retryAfter := backoffInterval.NextBackOff()
+		log.Ctx(ctx).Debug().Err(deliveryErr).Str("subscription", s.Name).Int("attempt", attempt).Dur("retry-after", retryAfter).Msg("failed to deliver webhook; retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+
+	log.Ctx(ctx).Warn().Err(deliveryErr).Str("subscription", s.Name).Int("changes", len(changes)).Msg("failed to deliver webhook; writing it to the dead-letter log")
+	deliveriesCounter.WithLabelValues(s.Name, "dead_lettered").Inc()
+	return s.writeDeadLetter(deadLetter{
+		FailedAt: s.now().UTC(),
+		Attempts: s.MaxAttempts,
+		Error:    deliveryErr.Error(),
+		Payload:  body,
+	})
+}
+
+func (s *subscription) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SubscriptionHeader, s.Name)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(s.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeDeadLetter appends the entry to the dead-letter log, first rotating the log if the entry
+// would grow it beyond its maximum size.
+func (s *subscription) writeDeadLetter(entry deadLetter) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.deadLetterPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read webhook dead-letter log: %w", err)
+	}
+	if err == nil && info.Size() > 0 && info.Size()+int64(len(encoded))+1 > s.deadLetterMaxBytes {
+		if err := os.Rename(s.deadLetterPath, s.deadLetterPath+".1"); err != nil {
+			return fmt.Errorf("failed to rotate webhook dead-letter log: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(s.deadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open webhook dead-letter log: %w", err)
+	}
+
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write webhook dead-letter log: %w", err)
+	}
+	return file.Close()
+}
+
+// loadCursor returns the revision from which the subscription resumes. On the first start, the
+// subscription starts from the head revision, which is persisted immediately so that the changes
+// made before a restart are not skipped.
+func (s *subscription) loadCursor(ctx context.Context) (datastore.Revision, error) {
+	contents, err := os.ReadFile(s.cursorPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		revision, err := s.ds.HeadRevision(ctx)
+		if err != nil {
+			return datastore.NoRevision, err
+		}
+		return revision, s.saveCursor(revision)
+	}
+	if err != nil {
+		return datastore.NoRevision, fmt.Errorf("failed to read webhook cursor: %w", err)
+	}
+
+	revision, err := zedtoken.DecodeRevision(&v1.ZedToken{Token: strings.TrimSpace(string(contents))}, s.ds)
+	if err != nil {
+		return datastore.NoRevision, fmt.Errorf("invalid webhook cursor `%s`: %w", s.cursorPath, err)
+	}
+	return revision, nil
+}
+
+// saveCursor atomically replaces the persisted cursor with the ZedToken of the revision.
+func (s *subscription) saveCursor(revision datastore.Revision) error {
+	token, err := zedtoken.NewFromRevision(revision)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(s.cursorPath), filepath.Base(s.cursorPath)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write webhook cursor: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.WriteString(token.Token); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write webhook cursor: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write webhook cursor: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), s.cursorPath); err != nil {
+		return fmt.Errorf("failed to write webhook cursor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package webhooks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "github.com/zapravila/authzed-go/proto/authzed/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/zapravila/spicedb/internal/datastore/memdb"
+	"github.com/zapravila/spicedb/internal/testfixtures"
+	"github.com/zapravila/spicedb/pkg/datastore"
+	core "github.com/zapravila/spicedb/pkg/proto/core/v1"
+	"github.com/zapravila/spicedb/pkg/tuple"
+)
+
+const (
+	testSecret = "some-secret"
+
+	testSchema = `
+		definition user {}
+
+		definition folder {
+			relation viewer: user
+		}
+
+		definition document {
+			relation parent: folder
+			relation viewer: user
+		}
+	`
+)
+
+func TestParseConfig(t *testing.T) {
+	tcs := []struct {
+		name          string
+		contents      string
+		expectedError string
+	}{
+		{"valid", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: documents
+    url: https://example.com/hook
+    secret: secret
+    filters:
+      - resourceType: document
+        optionalRelation: viewer
+      - optionalSubjectFilter:
+          subjectType: user
+          optionalSubjectId: tom
+`, ""},
+		{"missing state directory", `
+subscriptions:
+  - name: documents
+    url: https://example.com/hook
+    secret: secret
+`, "a state directory is required"},
+		{"missing name", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - url: https://example.com/hook
+    secret: secret
+`, "subscription #1 is missing a name"},
+		{"duplicate name", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+    secret: secret
+  - name: a
+    url: https://example.com/other
+    secret: secret
+`, "duplicate subscription `a`"},
+		{"invalid name", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: ../a
+    url: https://example.com/hook
+    secret: secret
+`, "name must only contain"},
+		{"invalid url", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: ftp://example.com/hook
+    secret: secret
+`, "url must be http or https"},
+		{"missing secret", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+`, "a secret is required"},
+		{"unknown filter field", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+    secret: secret
+    filters:
+      - objectType: document
+`, "unknown field"},
+		{"invalid filter", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+    secret: secret
+    filters:
+      - resourceType: Document!
+`, "invalid filter #1"},
+		{"unknown field", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+    secret: secret
+    retries: 3
+`, "field retries not found"},
+		{"negative batch size", `
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+    secret: secret
+    batchSize: -1
+`, "cannot be negative"},
+		{"negative dead-letter size", `
+stateDirectory: /tmp/webhooks
+deadLetterMaxBytes: -1
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+    secret: secret
+`, "the dead-letter maximum size cannot be negative"},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tc.contents))
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestParseConfigDefaults(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+stateDirectory: /tmp/webhooks
+subscriptions:
+  - name: a
+    url: https://example.com/hook
+    secret: secret
+    batchInterval: 250ms
+`))
+	require.NoError(t, err)
+	require.Equal(t, int64(DefaultDeadLetterMaxBytes), config.DeadLetterMaxBytes)
+	require.Len(t, config.Subscriptions, 1)
+
+	subscription := config.Subscriptions[0]
+	require.Equal(t, DefaultBatchSize, subscription.BatchSize)
+	require.Equal(t, 250*time.Millisecond, subscription.BatchInterval)
+	require.Equal(t, DefaultMaxAttempts, subscription.MaxAttempts)
+	require.Equal(t, DefaultInitialBackoff, subscription.InitialBackoff)
+	require.Equal(t, DefaultMaxBackoff, subscription.MaxBackoff)
+	require.Equal(t, DefaultTimeout, subscription.Timeout)
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"subscription":"a"}`)
+	signature := Sign(testSecret, "1700000000", body)
+
+	require.True(t, Verify(testSecret, "1700000000", body, signature))
+	require.False(t, Verify("other-secret", "1700000000", body, signature))
+	require.False(t, Verify(testSecret, "1700000001", body, signature))
+	require.False(t, Verify(testSecret, "1700000000", []byte(`{"subscription":"b"}`), signature))
+}
+
+func TestDelivererResumesFromCursor(t *testing.T) {
+	require := require.New(t)
+	ds := newDatastore(t)
+	receiver := newReceiver(t, 0)
+
+	configPath := writeConfig(t, receiver.URL, `
+    filters:
+      - resourceType: document
+    batchSize: 2
+    batchInterval: 10ms
+`)
+
+	deliverer, err := NewDeliverer(configPath, ds)
+	require.NoError(err)
+	waitForCursor(t, configPath)
+
+	write(t, ds,
+		tuple.Create(tuple.MustParse("document:first#viewer@user:tom")),
+		tuple.Create(tuple.MustParse("folder:root#viewer@user:tom")),
+		tuple.Create(tuple.MustParse("document:first#parent@folder:root")),
+		tuple.Create(tuple.MustParse("document:second#viewer@user:jill")),
+	)
+	receiver.requireRelationships(t,
+		"+document:first#viewer@user:tom",
+		"+document:first#parent@folder:root",
+		"+document:second#viewer@user:jill",
+	)
+	require.NoError(deliverer.Close())
+
+	// The changes made while stopped are delivered once restarted, without those already delivered.
+	write(t, ds, tuple.Delete(tuple.MustParse("document:first#viewer@user:tom")))
+
+	deliverer, err = NewDeliverer(configPath, ds)
+	require.NoError(err)
+	t.Cleanup(func() { require.NoError(deliverer.Close()) })
+
+	receiver.requireRelationships(t,
+		"+document:first#viewer@user:tom",
+		"+document:first#parent@folder:root",
+		"+document:second#viewer@user:jill",
+		"-document:first#viewer@user:tom",
+	)
+
+	// The batches are limited to the batch size.
+	for _, payload := range receiver.received() {
+		require.Equal("test", payload.Subscription)
+		require.LessOrEqual(len(payload.Changes), 2)
+	}
+}
+
+func TestDelivererDeadLetters(t *testing.T) {
+	require := require.New(t)
+	ds := newDatastore(t)
+	receiver := newReceiver(t, 2)
+
+	configPath := writeConfig(t, receiver.URL, `
+    batchInterval: 10ms
+    maxAttempts: 2
+    initialBackoff: 1ms
+    maxBackoff: 5ms
+`)
+
+	deliverer, err := NewDeliverer(configPath, ds)
+	require.NoError(err)
+	t.Cleanup(func() { require.NoError(deliverer.Close()) })
+	waitForCursor(t, configPath)
+
+	// The first change fails both attempts and is dead-lettered, while the next is delivered.
+	write(t, ds, tuple.Create(tuple.MustParse("document:first#viewer@user:tom")))
+
+	deadLetterPath := filepath.Join(filepath.Dir(configPath), "state", "test.deadletter.jsonl")
+	require.Eventually(func() bool {
+		_, err := os.Stat(deadLetterPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	write(t, ds, tuple.Create(tuple.MustParse("document:second#viewer@user:tom")))
+	receiver.requireRelationships(t, "+document:second#viewer@user:tom")
+
+	file, err := os.Open(deadLetterPath)
+	require.NoError(err)
+	defer file.Close()
+
+	var entries []deadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry deadLetter
+		require.NoError(json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(scanner.Err())
+	require.Len(entries, 1)
+	require.Equal(2, entries[0].Attempts)
+	require.Contains(entries[0].Error, "status 500")
+
+	var payload Payload
+	require.NoError(json.Unmarshal(entries[0].Payload, &payload))
+	require.Equal([]string{"+document:first#viewer@user:tom"}, relationships(t, payload))
+}
+
+func TestDeadLetterRotation(t *testing.T) {
+	require := require.New(t)
+
+	s := &subscription{
+		deadLetterPath:     filepath.Join(t.TempDir(), "test.deadletter.jsonl"),
+		deadLetterMaxBytes: 200,
+	}
+
+	writeEntry := func(attempts int) {
+		require.NoError(s.writeDeadLetter(deadLetter{Attempts: attempts, Error: "failed", Payload: json.RawMessage(`{}`)}))
+	}
+	read := func(path string) []int {
+		contents, err := os.ReadFile(path)
+		require.NoError(err)
+
+		var attempts []int
+		for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+			var entry deadLetter
+			require.NoError(json.Unmarshal([]byte(line), &entry))
+			attempts = append(attempts, entry.Attempts)
+		}
+		return attempts
+	}
+
+	// Each entry is about 80 bytes, so the log is rotated before every third entry, replacing the
+	// log rotated previously.
+	for attempts := 1; attempts <= 5; attempts++ {
+		writeEntry(attempts)
+	}
+	require.Equal([]int{5}, read(s.deadLetterPath))
+	require.Equal([]int{3, 4}, read(s.deadLetterPath+".1"))
+}
+
+func newDatastore(t *testing.T) datastore.Datastore {
+	rawDS, err := memdb.NewMemdbDatastore(0, 0, memdb.DisableGC)
+	require.NoError(t, err)
+	t.Cleanup(func() { rawDS.Close() })
+
+	ds, _ := testfixtures.DatastoreFromSchemaAndTestRelationships(rawDS, testSchema, nil, require.New(t))
+	return ds
+}
+
+func write(t *testing.T, ds datastore.Datastore, updates ...*core.RelationTupleUpdate) {
+	_, err := ds.ReadWriteTx(context.Background(), func(ctx context.Context, rwt datastore.ReadWriteTransaction) error {
+		return rwt.WriteRelationships(ctx, updates)
+	})
+	require.NoError(t, err)
+}
+
+// writeConfig writes a configuration file of a `test` subscription to the URL, with the additional
+// subscription fields given, and returns its path.
+func writeConfig(t *testing.T, url string, fields string) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "webhooks.yaml")
+	contents := fmt.Sprintf(`
+stateDirectory: %s
+subscriptions:
+  - name: test
+    url: %s
+    secret: %s
+%s`, filepath.Join(dir, "state"), url, testSecret, fields)
+
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+// waitForCursor waits for the subscription to have started watching, once its cursor is written.
+func waitForCursor(t *testing.T, configPath string) {
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(filepath.Dir(configPath), "state", "test.cursor"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// receiver is a webhook endpoint recording the payloads it receives, after failing the given
+// number of requests.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	payloads []Payload
+}
+
+func newReceiver(t *testing.T, failures int) *receiver {
+	r := &receiver{failures: failures}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil || !Verify(testSecret, req.Header.Get(TimestampHeader), body, req.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.payloads = append(r.payloads, payload)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []Payload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Payload(nil), r.payloads...)
+}
+
+// requireRelationships requires the receiver to eventually have received exactly the changes
+// given, as `+` or `-` followed by the relationship.
+func (r *receiver) requireRelationships(t *testing.T, expected ...string) {
+	var found []string
+	require.Eventually(t, func() bool {
+		found = nil
+		for _, payload := range r.received() {
+			found = append(found, relationships(t, payload)...)
+		}
+		return len(found) >= len(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.ElementsMatch(t, expected, found)
+}
+
+func relationships(t *testing.T, payload Payload) []string {
+	found := make([]string, 0, len(payload.Changes))
+	for _, change := range payload.Changes {
+		require.NotEmpty(t, change.ChangedAt)
+
+		update := &v1.RelationshipUpdate{}
+		require.NoError(t, protojson.Unmarshal(change.Update, update))
+
+		operation := "+"
+		if update.Operation == v1.RelationshipUpdate_OPERATION_DELETE {
+			operation = "-"
+		}
+		found = append(found, operation+tuple.MustRelString(update.Relationship))
+	}
+	return found
+}
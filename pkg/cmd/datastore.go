@@ -3,12 +3,15 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
+	"github.com/jzelinskie/cobrautil/v2"
 	"github.com/spf13/cobra"
 
 	"github.com/zapravila/spicedb/internal/datastore/common"
 	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/internal/schemamigration"
 	"github.com/zapravila/spicedb/pkg/cmd/datastore"
 	"github.com/zapravila/spicedb/pkg/cmd/server"
 	"github.com/zapravila/spicedb/pkg/cmd/termination"
@@ -46,6 +49,13 @@ func NewDatastoreCommand(programName string) (*cobra.Command, error) {
 	util.RegisterCommonFlags(repairCmd)
 	datastoreCmd.AddCommand(repairCmd)
 
+	migrateSchemaCmd := NewMigrateSchemaCommand(programName, cfg)
+	if err := datastore.RegisterDatastoreFlagsWithPrefix(migrateSchemaCmd.Flags(), "", cfg); err != nil {
+		return nil, err
+	}
+	RegisterMigrateSchemaFlags(migrateSchemaCmd)
+	datastoreCmd.AddCommand(migrateSchemaCmd)
+
 	headCmd := NewHeadCommand(programName)
 	RegisterHeadFlags(headCmd)
 	datastoreCmd.AddCommand(headCmd)
@@ -135,3 +145,126 @@ func NewRepairDatastoreCommand(programName string, cfg *datastore.Config) *cobra
 		}),
 	}
 }
+
+func RegisterMigrateSchemaFlags(cmd *cobra.Command) {
+	cmd.Flags().String("schema", "", "path to the file containing the schema to migrate to")
+	cmd.Flags().StringSlice("rename-definition", nil, "definition to rename, as `from=to`")
+	cmd.Flags().StringSlice("rename-relation", nil, "relation to rename, as `definition#from=to`")
+	cmd.Flags().StringSlice("split-relation", nil, "subjects of a type to move into another relation, as `definition#relation@subjecttype[#subjectrelation]=to`")
+	cmd.Flags().StringSlice("rename-subject-type", nil, "type of subjects to rename, leaving resources of the type unchanged, as `from=to`")
+	cmd.Flags().Uint64("batch-size", schemamigration.DefaultBatchSize, "number of relationships to rewrite per transaction")
+	cmd.Flags().Bool("cleanup", false, "instead of running the migration, delete the relationships left by a run which did not complete: the rewritten relationships if the schema was not swapped, or those they replace if it was")
+	util.RegisterCommonFlags(cmd)
+}
+
+func NewMigrateSchemaCommand(programName string, cfg *datastore.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate-schema",
+		Short: "migrates the schema, rewriting existing relationships",
+		Long: "Writes a schema which renames definitions or relations, rewriting the existing relationships to match.\n" +
+			"The relationships are rewritten in batches while the current schema remains in use, the schema is then swapped in a single transaction, and the replaced relationships deleted in batches.\n" +
+			"The definitions and relations renamed to must not exist in the current schema. A migration which did not complete may be run again, or cleaned up with --cleanup.",
+		PreRunE: server.DefaultPreRunE(programName),
+		RunE: termination.PublishError(func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			operations, err := migrateSchemaOperations(cmd)
+			if err != nil {
+				return err
+			}
+
+			schemaPath := cobrautil.MustGetString(cmd, "schema")
+			if schemaPath == "" {
+				return fmt.Errorf("a schema must be given with --schema")
+			}
+
+			schema, err := os.ReadFile(schemaPath)
+			if err != nil {
+				return fmt.Errorf("failed to read schema: %w", err)
+			}
+
+			// Disable background GC and hedging.
+			cfg.GCInterval = -1 * time.Hour
+			cfg.RequestHedgingEnabled = false
+
+			ds, err := datastore.NewDatastore(ctx, cfg.ToOption())
+			if err != nil {
+				return fmt.Errorf("failed to create datastore: %w", err)
+			}
+
+			migration := &schemamigration.Migration{
+				Schema:     string(schema),
+				Operations: operations,
+				BatchSize:  cobrautil.MustGetUint64(cmd, "batch-size"),
+				Progress: func(p schemamigration.Progress) {
+					log.Ctx(ctx).Info().
+						Str("phase", string(p.Phase)).
+						Stringer("operation", p.Operation).
+						Uint64("rewritten", p.Rewritten).
+						Msg("Rewriting relationships...")
+				},
+			}
+
+			if cobrautil.MustGetBool(cmd, "cleanup") {
+				log.Ctx(ctx).Info().Int("operations", len(operations)).Msg("Cleaning up schema migration...")
+				phase, err := migration.Cleanup(ctx, ds)
+				if err != nil {
+					return err
+				}
+
+				log.Ctx(ctx).Info().Str("phase", string(phase)).Msg("Schema migration cleaned up")
+				return nil
+			}
+
+			log.Ctx(ctx).Info().Int("operations", len(operations)).Msg("Running schema migration...")
+			result, err := migration.Run(ctx, ds)
+			if err != nil {
+				return err
+			}
+
+			log.Ctx(ctx).Info().
+				Uint64("rewritten", result.Rewritten).
+				Stringer("revision", result.Revision).
+				Str("schema_hash", result.SchemaHash).
+				Msg("Schema migration completed")
+			return nil
+		}),
+	}
+}
+
+func migrateSchemaOperations(cmd *cobra.Command) ([]schemamigration.Operation, error) {
+	var operations []schemamigration.Operation
+	for _, spec := range cobrautil.MustGetStringSlice(cmd, "rename-definition") {
+		op, err := schemamigration.ParseRenameDefinition(spec)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	for _, spec := range cobrautil.MustGetStringSlice(cmd, "rename-relation") {
+		op, err := schemamigration.ParseRenameRelation(spec)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	for _, spec := range cobrautil.MustGetStringSlice(cmd, "split-relation") {
+		op, err := schemamigration.ParseSplitRelation(spec)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	for _, spec := range cobrautil.MustGetStringSlice(cmd, "rename-subject-type") {
+		op, err := schemamigration.ParseRenameSubjectType(spec)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	return operations, nil
+}
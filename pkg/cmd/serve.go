@@ -10,7 +10,16 @@ import (
 	"github.com/jzelinskie/cobrautil/v2/cobraotel"
 	"github.com/spf13/cobra"
 
+	"github.com/zapravila/spicedb/internal/dispatch/graph"
+	"github.com/zapravila/spicedb/internal/dispatch/membership"
+	"github.com/zapravila/spicedb/internal/dispatch/outlier"
+	"github.com/zapravila/spicedb/internal/dispatch/peercache"
+	"github.com/zapravila/spicedb/internal/dispatch/remote"
+	"github.com/zapravila/spicedb/internal/middleware/admission"
+	"github.com/zapravila/spicedb/internal/middleware/dispatchbudget"
+	"github.com/zapravila/spicedb/internal/middleware/ratelimit"
 	"github.com/zapravila/spicedb/internal/telemetry"
+	"github.com/zapravila/spicedb/internal/warmstart"
 	"github.com/zapravila/spicedb/pkg/cmd/datastore"
 	"github.com/zapravila/spicedb/pkg/cmd/server"
 	"github.com/zapravila/spicedb/pkg/cmd/termination"
@@ -47,6 +56,15 @@ var (
 		MaxCost:             "70%",
 		CacheKindForTesting: "",
 	}
+
+	dispatchSharedCacheDefaults = &server.CacheConfig{
+		Name:                "shared_dispatch",
+		Enabled:             false,
+		Metrics:             true,
+		NumCounters:         100_000,
+		MaxCost:             "10%",
+		CacheKindForTesting: "",
+	}
 )
 
 func BoldBlue(name string) string {
@@ -111,6 +129,26 @@ func RegisterServeFlags(cmd *cobra.Command, config *server.Config) error {
 	apiFlags.Uint32Var(&config.MaxDeleteRelationshipsLimit, "max-delete-relationships-limit", 1000, "maximum number of relationships that can be deleted in a single request")
 	apiFlags.Uint32Var(&config.MaxLookupResourcesLimit, "max-lookup-resources-limit", 1000, "maximum number of resources that can be looked up in a single request")
 	apiFlags.Uint32Var(&config.MaxBulkExportRelationshipsLimit, "max-bulk-export-relationships-limit", 10_000, "maximum number of relationships that can be exported in a single request")
+	apiFlags.BoolVar(&config.EnablePermissionWatchAPI, "enable-permission-watch-api", false, "enables the experimental permission watch API, which streams the permission changes made by relationship updates")
+	apiFlags.Uint32Var(&config.MaxPermissionWatchAffectedResources, "max-permission-watch-affected-resources", 1000, "maximum number of resources whose permission is recomputed for a single update by the permission watch API; updates affecting more end the watch")
+
+	apiFlags.StringVar(&config.RateLimitConfigFile, "rate-limit-config-file", "", "path to a YAML file of per-client rate limit and dispatch budget rules, reloaded when it changes")
+	apiFlags.DurationVar(&config.RateLimitConfigReloadInterval, "rate-limit-config-reload-interval", ratelimit.DefaultReloadInterval, "interval at which the rate limit configuration file is checked for changes")
+
+	apiFlags.BoolVar(&config.AdmissionControl.Enabled, "admission-control-enabled", false, "queue and then shed lower priority API requests while dispatch concurrency or datastore connection pools are saturated; requires adaptive dispatch concurrency limits or the postgres datastore")
+	apiFlags.StringToStringVar(&config.AdmissionControl.MethodPriorities, "admission-control-method-priorities", map[string]string{}, "priority class (low, normal or critical) of API methods, by full method, service or method name (e.g. LookupResources=low), overriding the defaults")
+	apiFlags.Float64Var(&config.AdmissionControl.LowPriorityThreshold, "admission-control-low-priority-threshold", admission.DefaultLowPriorityThreshold, "saturation, between 0 and 1, at or above which low priority API requests are queued")
+	apiFlags.Float64Var(&config.AdmissionControl.NormalPriorityThreshold, "admission-control-normal-priority-threshold", admission.DefaultNormalPriorityThreshold, "saturation, between 0 and 1, at or above which normal priority API requests are queued")
+	apiFlags.DurationVar(&config.AdmissionControl.MaxQueueWait, "admission-control-max-queue-wait", admission.DefaultMaxQueueWait, "maximum duration an API request is queued before it is shed")
+	apiFlags.Uint32Var(&config.AdmissionControl.MaxQueueLength, "admission-control-max-queue-length", admission.DefaultMaxQueueLength, "maximum number of queued API requests of each priority class, beyond which requests are shed immediately")
+	apiFlags.DurationVar(&config.AdmissionControl.RetryAfter, "admission-control-retry-after", admission.DefaultRetryAfter, "duration after which clients are told to retry shed API requests")
+
+	apiFlags.Uint32Var(&config.DispatchBudget.DefaultBudget, "dispatch-budget-default", 0, "maximum number of dispatches a single API request may perform before it is aborted, approximate when dispatching across the cluster (0 for no limit)")
+	apiFlags.StringToIntVar(&config.DispatchBudget.MethodBudgets, "dispatch-budget-method-budgets", map[string]int{}, "maximum number of dispatches of API methods, by full method, service or method name (e.g. LookupResources=100000), overriding the default")
+	apiFlags.StringSliceVar(&config.DispatchBudget.TrustedPresharedKeys, "dispatch-budget-trusted-preshared-keys", []string{}, "preshared keys of the clients allowed to raise the dispatch budget of their requests with the "+dispatchbudget.OverrideMetadataKey+" metadata")
+
+	apiFlags.StringVar(&config.WebhookConfigFile, "webhook-config-file", "", "path to a YAML file of webhook subscriptions to which relationship changes are delivered, by the node on which --webhook-delivery-enabled is set")
+	apiFlags.BoolVar(&config.WebhookDeliveryEnabled, "webhook-delivery-enabled", false, "deliver the webhook subscriptions from this node; must be enabled on a single node, as the delivery cursors and dead-letter logs are local to it and every delivering node delivers every change")
 
 	datastoreFlags := nfs.FlagSet(BoldBlue("Datastore"))
 	// Flags for the datastore
@@ -128,15 +166,24 @@ func RegisterServeFlags(cmd *cobra.Command, config *server.Config) error {
 	namespaceCacheFlags.DurationVar(&config.SchemaWatchHeartbeat, "datastore-schema-watch-heartbeat", 1*time.Second, "heartbeat time on the schema watch in the datastore (if supported). 0 means to default to the datastore's minimum.")
 	server.MustRegisterCacheFlags(namespaceCacheFlags, "ns-cache", &config.NamespaceCacheConfig, namespaceCacheDefaults)
 
+	warmStartFlags := nfs.FlagSet(BoldBlue("Cache Warm Start"))
+	// Flags for warming the namespace cache across restarts
+	warmStartFlags.StringVar(&config.CacheWarmStartDirectory, "cache-warm-start-dir", "", "local directory in which the names of the hottest definitions in the namespace cache are periodically snapshotted, to be loaded on start before the server reports ready. disabled if empty")
+	warmStartFlags.DurationVar(&config.CacheWarmStartInterval, "cache-warm-start-interval", warmstart.DefaultSnapshotInterval, "interval at which the caches are snapshotted")
+	warmStartFlags.IntVar(&config.CacheWarmStartMaxEntries, "cache-warm-start-max-entries", warmstart.DefaultMaxEntries, "maximum number of entries snapshotted per cache")
+
 	dispatchFlags := nfs.FlagSet(BoldBlue("Dispatch"))
 	// Flags for configuring the dispatch server
 	util.RegisterGRPCServerFlags(dispatchFlags, &config.DispatchServer, "dispatch-cluster", "dispatch", ":50053", false)
 	server.MustRegisterCacheFlags(dispatchFlags, "dispatch-cache", &config.DispatchCacheConfig, dispatchCacheDefaults)
 	server.MustRegisterCacheFlags(dispatchFlags, "dispatch-cluster-cache", &config.ClusterDispatchCacheConfig, dispatchClusterCacheDefaults)
+	server.MustRegisterCacheFlags(dispatchFlags, "dispatch-shared-cache", &config.DispatchSharedCacheConfig, dispatchSharedCacheDefaults)
+	dispatchFlags.DurationVar(&config.DispatchSharedCacheLookupTimeout, "dispatch-shared-cache-lookup-timeout", peercache.DefaultLookupTimeout, "maximum duration of a lookup in the dispatch cache shared by the cluster, after which it is treated as a miss")
 
 	// Flags for configuring dispatch requests
 	dispatchFlags.Uint16Var(&config.DispatchChunkSize, "dispatch-chunk-size", 100, "maximum number of object IDs in a dispatched request")
 	dispatchFlags.Uint32Var(&config.DispatchMaxDepth, "dispatch-max-depth", 50, "maximum recursion depth for nested calls")
+	dispatchFlags.StringSliceVar(&config.MaterializedPermissions, "dispatch-materialized-permissions", []string{}, "permissions whose subjects are indexed and kept up to date from the datastore's changes, to answer checks and lookups without dispatching (e.g. document#view@user)")
 	dispatchFlags.StringVar(&config.DispatchUpstreamAddr, "dispatch-upstream-addr", "", "upstream grpc address to dispatch to")
 	dispatchFlags.StringVar(&config.DispatchUpstreamCAPath, "dispatch-upstream-ca-path", "", "local path to the TLS CA used when connecting to the dispatch cluster")
 	dispatchFlags.DurationVar(&config.DispatchUpstreamTimeout, "dispatch-upstream-timeout", 60*time.Second, "maximum duration of a dispatch call an upstream cluster before it times out")
@@ -147,10 +194,31 @@ func RegisterServeFlags(cmd *cobra.Command, config *server.Config) error {
 	dispatchFlags.Uint16Var(&config.DispatchConcurrencyLimits.LookupResources, "dispatch-lookup-resources-concurrency-limit", 0, "maximum number of parallel goroutines to create for each lookup resources request or subrequest. defaults to --dispatch-concurrency-limit")
 	dispatchFlags.Uint16Var(&config.DispatchConcurrencyLimits.LookupSubjects, "dispatch-lookup-subjects-concurrency-limit", 0, "maximum number of parallel goroutines to create for each lookup subjects request or subrequest. defaults to --dispatch-concurrency-limit")
 	dispatchFlags.Uint16Var(&config.DispatchConcurrencyLimits.ReachableResources, "dispatch-reachable-resources-concurrency-limit", 0, "maximum number of parallel goroutines to create for each reachable resources request or subrequest. defaults to --dispatch-concurrency-limit")
+	dispatchFlags.BoolVar(&config.DispatchAdaptiveConcurrency.Enabled, "dispatch-adaptive-concurrency-enabled", false, "adapt the dispatch concurrency limits to datastore latency and dispatch errors, starting from the configured limits")
+	dispatchFlags.Uint16Var(&config.DispatchAdaptiveConcurrency.MinLimit, "dispatch-adaptive-concurrency-min-limit", graph.DefaultAdaptiveMinLimit, "floor of each adaptive dispatch concurrency limit")
+	dispatchFlags.Uint16Var(&config.DispatchAdaptiveConcurrency.MaxLimit, "dispatch-adaptive-concurrency-max-limit", graph.DefaultAdaptiveMaxLimit, "ceiling of each adaptive dispatch concurrency limit")
+	dispatchFlags.DurationVar(&config.DispatchAdaptiveConcurrency.LatencyThreshold, "dispatch-adaptive-concurrency-latency-threshold", graph.DefaultAdaptiveLatencyThreshold, "datastore query latency above which the adaptive dispatch concurrency limits are decreased")
+	dispatchFlags.Float64Var(&config.DispatchAdaptiveConcurrency.BackoffRatio, "dispatch-adaptive-concurrency-backoff-ratio", graph.DefaultAdaptiveBackoffRatio, "factor by which an adaptive dispatch concurrency limit is multiplied when decreased")
+
+	dispatchFlags.StringVar(&config.DispatchClusterPeersFile, "dispatch-cluster-peers-file", "", "local path to a file listing the dispatch address of each cluster member, one per line, which is reloaded when changed and used in place of --dispatch-upstream-addr")
+	dispatchFlags.DurationVar(&config.DispatchClusterPeersFileReloadInterval, "dispatch-cluster-peers-file-reload-interval", membership.DefaultStaticFileReloadInterval, "interval at which the dispatch cluster peers file is checked for changes")
+	dispatchFlags.StringVar(&config.DispatchClusterGossipBindAddr, "dispatch-cluster-gossip-bind-addr", "", "address on which to listen for dispatch cluster gossip, which discovers the cluster members in place of --dispatch-upstream-addr")
+	dispatchFlags.StringVar(&config.DispatchClusterGossipAdvertiseAddr, "dispatch-cluster-gossip-advertise-addr", "", "gossip address advertised to the other cluster members. defaults to the bound address")
+	dispatchFlags.StringVar(&config.DispatchClusterGossipDispatchAddr, "dispatch-cluster-gossip-dispatch-addr", "", "dispatch address of this node advertised to the other cluster members")
+	dispatchFlags.StringSliceVar(&config.DispatchClusterGossipSeeds, "dispatch-cluster-gossip-seeds", nil, "gossip addresses of existing cluster members to join through")
+	dispatchFlags.DurationVar(&config.DispatchClusterGossipInterval, "dispatch-cluster-gossip-interval", membership.DefaultGossipInterval, "interval at which cluster members gossip")
+	dispatchFlags.DurationVar(&config.DispatchClusterGossipFailureTimeout, "dispatch-cluster-gossip-failure-timeout", membership.DefaultGossipFailureTimeout, "duration after which a cluster member not heard from is removed from the cluster")
 
 	dispatchFlags.Uint16Var(&config.DispatchHashringReplicationFactor, "dispatch-hashring-replication-factor", 100, "set the replication factor of the consistent hasher used for the dispatcher")
 	dispatchFlags.Uint8Var(&config.DispatchHashringSpread, "dispatch-hashring-spread", 1, "set the spread of the consistent hasher used for the dispatcher")
 
+	dispatchFlags.BoolVar(&config.DispatchPeerEjectionEnabled, "dispatch-peer-ejection-enabled", true, "eject failing or slow dispatch peers from the hashring, rehashing their requests to other peers or evaluating them locally")
+	dispatchFlags.Uint32Var(&config.DispatchPeerEjection.ConsecutiveFailures, "dispatch-peer-ejection-consecutive-failures", outlier.DefaultConsecutiveFailures, "number of consecutive failed dispatches after which a dispatch peer is ejected")
+	dispatchFlags.DurationVar(&config.DispatchPeerEjection.BaseEjectionTime, "dispatch-peer-ejection-base-time", outlier.DefaultBaseEjectionTime, "duration of the first ejection of a dispatch peer, multiplied by the number of consecutive ejections")
+	dispatchFlags.DurationVar(&config.DispatchPeerEjection.MaxEjectionTime, "dispatch-peer-ejection-max-time", outlier.DefaultMaxEjectionTime, "maximum duration of the ejection of a dispatch peer")
+	dispatchFlags.Uint32Var(&config.DispatchPeerEjection.MaxEjectionPercent, "dispatch-peer-ejection-max-percent", outlier.DefaultMaxEjectionPercent, "maximum percentage of the dispatch peers which may be ejected at once")
+	dispatchFlags.DurationVar(&config.DispatchPeerEjection.SlowCheckThreshold, "dispatch-peer-ejection-slow-check-threshold", 0, "duration above which a dispatched check counts as a failure of the peer. 0 disables")
+
 	cmd.Flags().BoolVar(&config.V1SchemaAdditiveOnly, "testing-only-schema-additive-writes", false, "append new definitions to the existing schema, rather than overwriting it")
 	if err := cmd.Flags().MarkHidden("testing-only-schema-additive-writes"); err != nil {
 		return fmt.Errorf("failed to mark flag as hidden: %w", err)
@@ -163,6 +231,10 @@ func RegisterServeFlags(cmd *cobra.Command, config *server.Config) error {
 	// TODO: these two could reasonably be put in either the Dispatch group or the Experimental group. Is there a preference?
 	experimentalFlags.StringToStringVar(&config.DispatchSecondaryUpstreamAddrs, "experimental-dispatch-secondary-upstream-addrs", nil, "secondary upstream addresses for dispatches, each with a name")
 	experimentalFlags.StringToStringVar(&config.DispatchSecondaryUpstreamExprs, "experimental-dispatch-secondary-upstream-exprs", nil, "map from request type (currently supported: `check`) to its associated CEL expression, which returns the secondary upstream(s) to be used for the request")
+	experimentalFlags.StringToStringVar(&config.DispatchSecondaryUpstreamZones, "experimental-dispatch-secondary-upstream-zones", nil, "zone of each named secondary upstream; secondary upstreams in the same zone as this node are preferred")
+	experimentalFlags.StringVar(&config.DispatchZone, "experimental-dispatch-zone", "", "zone of this node and of its dispatch upstream, available to secondary upstream expressions as `zone`")
+	experimentalFlags.DurationVar(&config.DispatchCrossZoneLatencyBudget, "experimental-dispatch-cross-zone-latency-budget", remote.DefaultCrossZoneLatencyBudget, "duration to wait for the upstreams of this node's zone before also dispatching to the secondary upstreams of other zones")
+	experimentalFlags.StringToStringVar(&config.DispatchZoneLatencyBudgets, "experimental-dispatch-zone-latency-budgets", nil, "cross-zone latency budget of the secondary upstreams of specific zones (e.g. eu-west=100ms)")
 
 	observabilityFlags := nfs.FlagSet(BoldBlue("Observability"))
 	// Flags for observability and profiling
@@ -33,9 +33,12 @@ import (
 
 	"github.com/zapravila/spicedb/internal/dispatch"
 	"github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/internal/middleware/admission"
 	consistencymw "github.com/zapravila/spicedb/internal/middleware/consistency"
 	datastoremw "github.com/zapravila/spicedb/internal/middleware/datastore"
+	"github.com/zapravila/spicedb/internal/middleware/dispatchbudget"
 	dispatchmw "github.com/zapravila/spicedb/internal/middleware/dispatcher"
+	"github.com/zapravila/spicedb/internal/middleware/ratelimit"
 	"github.com/zapravila/spicedb/internal/middleware/servicespecific"
 	"github.com/zapravila/spicedb/pkg/datastore"
 	logmw "github.com/zapravila/spicedb/pkg/middleware/logging"
@@ -163,13 +166,16 @@ var alwaysDebugOption = grpclog.WithLevels(func(code codes.Code) grpclog.Level {
 })
 
 const (
-	DefaultMiddlewareRequestID     = "requestid"
-	DefaultMiddlewareLog           = "log"
-	DefaultMiddlewareGRPCLog       = "grpclog"
-	DefaultMiddlewareOTelGRPC      = "otelgrpc"
-	DefaultMiddlewareGRPCAuth      = "grpcauth"
-	DefaultMiddlewareGRPCProm      = "grpcprom"
-	DefaultMiddlewareServerVersion = "serverversion"
+	DefaultMiddlewareRequestID      = "requestid"
+	DefaultMiddlewareLog            = "log"
+	DefaultMiddlewareGRPCLog        = "grpclog"
+	DefaultMiddlewareOTelGRPC       = "otelgrpc"
+	DefaultMiddlewareGRPCAuth       = "grpcauth"
+	DefaultMiddlewareGRPCProm       = "grpcprom"
+	DefaultMiddlewareServerVersion  = "serverversion"
+	DefaultMiddlewareRateLimit      = "ratelimit"
+	DefaultMiddlewareAdmission      = "admission"
+	DefaultMiddlewareDispatchBudget = "dispatchbudget"
 
 	DefaultInternalMiddlewareDispatch       = "dispatch"
 	DefaultInternalMiddlewareDatastore      = "datastore"
@@ -179,13 +185,16 @@ const (
 
 //go:generate go run github.com/ecordell/optgen -output zz_generated.middlewareoption.go . MiddlewareOption
 type MiddlewareOption struct {
-	Logger                  zerolog.Logger      `debugmap:"hidden"`
-	AuthFunc                grpcauth.AuthFunc   `debugmap:"hidden"`
-	EnableVersionResponse   bool                `debugmap:"visible"`
-	DispatcherForMiddleware dispatch.Dispatcher `debugmap:"hidden"`
-	EnableRequestLog        bool                `debugmap:"visible"`
-	EnableResponseLog       bool                `debugmap:"visible"`
-	DisableGRPCHistogram    bool                `debugmap:"visible"`
+	Logger                  zerolog.Logger          `debugmap:"hidden"`
+	AuthFunc                grpcauth.AuthFunc       `debugmap:"hidden"`
+	EnableVersionResponse   bool                    `debugmap:"visible"`
+	DispatcherForMiddleware dispatch.Dispatcher     `debugmap:"hidden"`
+	EnableRequestLog        bool                    `debugmap:"visible"`
+	EnableResponseLog       bool                    `debugmap:"visible"`
+	DisableGRPCHistogram    bool                    `debugmap:"visible"`
+	RateLimiter             *ratelimit.Limiter      `debugmap:"hidden"`
+	AdmissionController     *admission.Controller   `debugmap:"hidden"`
+	DispatchBudgets         *dispatchbudget.Budgets `debugmap:"hidden"`
 
 	unaryDatastoreMiddleware  *ReferenceableMiddleware[grpc.UnaryServerInterceptor]  `debugmap:"hidden"`
 	streamDatastoreMiddleware *ReferenceableMiddleware[grpc.StreamServerInterceptor] `debugmap:"hidden"`
@@ -217,6 +226,9 @@ func (m MiddlewareOption) WithDatastoreMiddleware(middleware Middleware) Middlew
 		EnableRequestLog:          m.EnableRequestLog,
 		EnableResponseLog:         m.EnableResponseLog,
 		DisableGRPCHistogram:      m.DisableGRPCHistogram,
+		RateLimiter:               m.RateLimiter,
+		AdmissionController:       m.AdmissionController,
+		DispatchBudgets:           m.DispatchBudgets,
 		unaryDatastoreMiddleware:  &unary,
 		streamDatastoreMiddleware: &stream,
 	}
@@ -243,6 +255,9 @@ func (m MiddlewareOption) WithDatastore(ds datastore.Datastore) MiddlewareOption
 		EnableRequestLog:          m.EnableRequestLog,
 		EnableResponseLog:         m.EnableResponseLog,
 		DisableGRPCHistogram:      m.DisableGRPCHistogram,
+		RateLimiter:               m.RateLimiter,
+		AdmissionController:       m.AdmissionController,
+		DispatchBudgets:           m.DispatchBudgets,
 		unaryDatastoreMiddleware:  &unary,
 		streamDatastoreMiddleware: &stream,
 	}
@@ -325,6 +340,24 @@ func DefaultUnaryMiddleware(opts MiddlewareOption) (*MiddlewareChain[grpc.UnaryS
 			EnsureAlreadyExecuted(DefaultMiddlewareGRPCProm). // so that prom middleware reports auth failures
 			Done(),
 
+		NewUnaryMiddleware().
+			WithName(DefaultMiddlewareRateLimit).
+			WithInterceptor(ratelimit.UnaryServerInterceptor(opts.RateLimiter)).
+			EnsureAlreadyExecuted(DefaultMiddlewareGRPCAuth). // so that unauthenticated requests do not consume limits
+			Done(),
+
+		NewUnaryMiddleware().
+			WithName(DefaultMiddlewareAdmission).
+			WithInterceptor(admission.UnaryServerInterceptor(opts.AdmissionController)).
+			EnsureAlreadyExecuted(DefaultMiddlewareGRPCAuth). // so that unauthenticated requests are not queued
+			Done(),
+
+		NewUnaryMiddleware().
+			WithName(DefaultMiddlewareDispatchBudget).
+			WithInterceptor(dispatchbudget.UnaryServerInterceptor(opts.DispatchBudgets)).
+			EnsureAlreadyExecuted(DefaultMiddlewareGRPCAuth). // so that only authenticated clients may override budgets
+			Done(),
+
 		NewUnaryMiddleware().
 			WithName(DefaultMiddlewareServerVersion).
 			WithInterceptor(serverversion.UnaryServerInterceptor(opts.EnableVersionResponse)).
@@ -399,6 +432,24 @@ func DefaultStreamingMiddleware(opts MiddlewareOption) (*MiddlewareChain[grpc.St
 			EnsureInterceptorAlreadyExecuted(DefaultMiddlewareGRPCProm). // so that prom middleware reports auth failures
 			Done(),
 
+		NewStreamMiddleware().
+			WithName(DefaultMiddlewareRateLimit).
+			WithInterceptor(ratelimit.StreamServerInterceptor(opts.RateLimiter)).
+			EnsureInterceptorAlreadyExecuted(DefaultMiddlewareGRPCAuth). // so that unauthenticated requests do not consume limits
+			Done(),
+
+		NewStreamMiddleware().
+			WithName(DefaultMiddlewareAdmission).
+			WithInterceptor(admission.StreamServerInterceptor(opts.AdmissionController)).
+			EnsureInterceptorAlreadyExecuted(DefaultMiddlewareGRPCAuth). // so that unauthenticated requests are not queued
+			Done(),
+
+		NewStreamMiddleware().
+			WithName(DefaultMiddlewareDispatchBudget).
+			WithInterceptor(dispatchbudget.StreamServerInterceptor(opts.DispatchBudgets)).
+			EnsureInterceptorAlreadyExecuted(DefaultMiddlewareGRPCAuth). // so that only authenticated clients may override budgets
+			Done(),
+
 		NewStreamMiddleware().
 			WithName(DefaultMiddlewareServerVersion).
 			WithInterceptor(serverversion.StreamServerInterceptor(opts.EnableVersionResponse)).
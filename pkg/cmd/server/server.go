@@ -2,10 +2,14 @@ package server
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -32,13 +36,22 @@ import (
 	combineddispatch "github.com/zapravila/spicedb/internal/dispatch/combined"
 	"github.com/zapravila/spicedb/internal/dispatch/graph"
 	"github.com/zapravila/spicedb/internal/dispatch/keys"
+	"github.com/zapravila/spicedb/internal/dispatch/materialized"
+	"github.com/zapravila/spicedb/internal/dispatch/membership"
+	"github.com/zapravila/spicedb/internal/dispatch/outlier"
+	"github.com/zapravila/spicedb/internal/dispatch/peercache"
 	"github.com/zapravila/spicedb/internal/gateway"
 	log "github.com/zapravila/spicedb/internal/logging"
+	"github.com/zapravila/spicedb/internal/middleware/admission"
+	"github.com/zapravila/spicedb/internal/middleware/dispatchbudget"
+	"github.com/zapravila/spicedb/internal/middleware/ratelimit"
 	"github.com/zapravila/spicedb/internal/services"
 	dispatchSvc "github.com/zapravila/spicedb/internal/services/dispatch"
 	"github.com/zapravila/spicedb/internal/services/health"
 	v1svc "github.com/zapravila/spicedb/internal/services/v1"
 	"github.com/zapravila/spicedb/internal/telemetry"
+	"github.com/zapravila/spicedb/internal/warmstart"
+	"github.com/zapravila/spicedb/internal/webhooks"
 	"github.com/zapravila/spicedb/pkg/cache"
 	datastorecfg "github.com/zapravila/spicedb/pkg/cmd/datastore"
 	"github.com/zapravila/spicedb/pkg/cmd/util"
@@ -51,6 +64,10 @@ import (
 // underlying hash for the ConsistentHashringBalancers it creates.
 var ConsistentHashringBuilder = consistent.NewBuilder(xxhash.Sum64)
 
+// OutlierEjectingHashringBuilder is a balancer Builder that ejects failing
+// dispatch peers from the hashrings built by ConsistentHashringBuilder.
+var OutlierEjectingHashringBuilder = outlier.NewBuilder(ConsistentHashringBuilder)
+
 //go:generate go run github.com/ecordell/optgen -output zz_generated.options.go . Config
 type Config struct {
 	// API config
@@ -80,45 +97,84 @@ type Config struct {
 	SchemaWatchHeartbeat                   time.Duration `debugmap:"visible"`
 	NamespaceCacheConfig                   CacheConfig   `debugmap:"visible"`
 
+	// Cache warm start
+	CacheWarmStartDirectory  string        `debugmap:"visible"`
+	CacheWarmStartInterval   time.Duration `debugmap:"visible"`
+	CacheWarmStartMaxEntries int           `debugmap:"visible"`
+
 	// Schema options
 	SchemaPrefixesRequired bool `debugmap:"visible"`
 
 	// Dispatch options
-	DispatchServer                    util.GRPCServerConfig   `debugmap:"visible"`
-	DispatchMaxDepth                  uint32                  `debugmap:"visible"`
-	GlobalDispatchConcurrencyLimit    uint16                  `debugmap:"visible"`
-	DispatchConcurrencyLimits         graph.ConcurrencyLimits `debugmap:"visible"`
-	DispatchUpstreamAddr              string                  `debugmap:"visible"`
-	DispatchUpstreamCAPath            string                  `debugmap:"visible"`
-	DispatchUpstreamTimeout           time.Duration           `debugmap:"visible"`
-	DispatchClientMetricsEnabled      bool                    `debugmap:"visible"`
-	DispatchClientMetricsPrefix       string                  `debugmap:"visible"`
-	DispatchClusterMetricsEnabled     bool                    `debugmap:"visible"`
-	DispatchClusterMetricsPrefix      string                  `debugmap:"visible"`
-	Dispatcher                        dispatch.Dispatcher     `debugmap:"visible"`
-	DispatchHashringReplicationFactor uint16                  `debugmap:"visible"`
-	DispatchHashringSpread            uint8                   `debugmap:"visible"`
-	DispatchChunkSize                 uint16                  `debugmap:"visible" default:"100"`
+	DispatchServer                    util.GRPCServerConfig           `debugmap:"visible"`
+	DispatchMaxDepth                  uint32                          `debugmap:"visible"`
+	GlobalDispatchConcurrencyLimit    uint16                          `debugmap:"visible"`
+	DispatchConcurrencyLimits         graph.ConcurrencyLimits         `debugmap:"visible"`
+	DispatchAdaptiveConcurrency       graph.AdaptiveConcurrencyConfig `debugmap:"visible"`
+	DispatchUpstreamAddr              string                          `debugmap:"visible"`
+	DispatchUpstreamCAPath            string                          `debugmap:"visible"`
+	DispatchUpstreamTimeout           time.Duration                   `debugmap:"visible"`
+	DispatchClientMetricsEnabled      bool                            `debugmap:"visible"`
+	DispatchClientMetricsPrefix       string                          `debugmap:"visible"`
+	DispatchClusterMetricsEnabled     bool                            `debugmap:"visible"`
+	DispatchClusterMetricsPrefix      string                          `debugmap:"visible"`
+	Dispatcher                        dispatch.Dispatcher             `debugmap:"visible"`
+	DispatchHashringReplicationFactor uint16                          `debugmap:"visible"`
+	DispatchHashringSpread            uint8                           `debugmap:"visible"`
+	DispatchPeerEjectionEnabled       bool                            `debugmap:"visible"`
+	DispatchPeerEjection              outlier.Config                  `debugmap:"visible"`
+	DispatchChunkSize                 uint16                          `debugmap:"visible" default:"100"`
 
 	DispatchSecondaryUpstreamAddrs map[string]string `debugmap:"visible"`
 	DispatchSecondaryUpstreamExprs map[string]string `debugmap:"visible"`
+	DispatchSecondaryUpstreamZones map[string]string `debugmap:"visible"`
+	DispatchZone                   string            `debugmap:"visible"`
+	DispatchCrossZoneLatencyBudget time.Duration     `debugmap:"visible"`
+	DispatchZoneLatencyBudgets     map[string]string `debugmap:"visible"`
+
+	DispatchClusterPeersFile               string        `debugmap:"visible"`
+	DispatchClusterPeersFileReloadInterval time.Duration `debugmap:"visible"`
+	DispatchClusterGossipBindAddr          string        `debugmap:"visible"`
+	DispatchClusterGossipAdvertiseAddr     string        `debugmap:"visible"`
+	DispatchClusterGossipDispatchAddr      string        `debugmap:"visible"`
+	DispatchClusterGossipSeeds             []string      `debugmap:"visible"`
+	DispatchClusterGossipInterval          time.Duration `debugmap:"visible"`
+	DispatchClusterGossipFailureTimeout    time.Duration `debugmap:"visible"`
 
 	DispatchCacheConfig        CacheConfig `debugmap:"visible"`
 	ClusterDispatchCacheConfig CacheConfig `debugmap:"visible"`
 
+	DispatchSharedCacheConfig        CacheConfig   `debugmap:"visible"`
+	DispatchSharedCacheLookupTimeout time.Duration `debugmap:"visible"`
+
 	// API Behavior
-	DisableV1SchemaAPI                bool          `debugmap:"visible"`
-	V1SchemaAdditiveOnly              bool          `debugmap:"visible"`
-	MaximumUpdatesPerWrite            uint16        `debugmap:"visible"`
-	MaximumPreconditionCount          uint16        `debugmap:"visible"`
-	MaxDatastoreReadPageSize          uint64        `debugmap:"visible"`
-	StreamingAPITimeout               time.Duration `debugmap:"visible"`
-	WatchHeartbeat                    time.Duration `debugmap:"visible"`
-	MaxReadRelationshipsLimit         uint32        `debugmap:"visible"`
-	MaxDeleteRelationshipsLimit       uint32        `debugmap:"visible"`
-	MaxLookupResourcesLimit           uint32        `debugmap:"visible"`
-	MaxBulkExportRelationshipsLimit   uint32        `debugmap:"visible"`
-	EnableExperimentalLookupResources bool          `debugmap:"visible"`
+	DisableV1SchemaAPI                  bool          `debugmap:"visible"`
+	V1SchemaAdditiveOnly                bool          `debugmap:"visible"`
+	MaximumUpdatesPerWrite              uint16        `debugmap:"visible"`
+	MaximumPreconditionCount            uint16        `debugmap:"visible"`
+	MaxDatastoreReadPageSize            uint64        `debugmap:"visible"`
+	StreamingAPITimeout                 time.Duration `debugmap:"visible"`
+	WatchHeartbeat                      time.Duration `debugmap:"visible"`
+	MaxReadRelationshipsLimit           uint32        `debugmap:"visible"`
+	MaxDeleteRelationshipsLimit         uint32        `debugmap:"visible"`
+	MaxLookupResourcesLimit             uint32        `debugmap:"visible"`
+	MaxBulkExportRelationshipsLimit     uint32        `debugmap:"visible"`
+	EnablePermissionWatchAPI            bool          `debugmap:"visible"`
+	MaxPermissionWatchAffectedResources uint32        `debugmap:"visible"`
+	EnableExperimentalLookupResources   bool          `debugmap:"visible"`
+	MaterializedPermissions             []string      `debugmap:"visible"`
+
+	// Rate limiting and admission control
+	RateLimitConfigFile           string           `debugmap:"visible"`
+	RateLimitConfigReloadInterval time.Duration    `debugmap:"visible"`
+	AdmissionControl              admission.Config `debugmap:"visible"`
+
+	// Dispatch budgets
+	DispatchBudget dispatchbudget.Config `debugmap:"sensitive"`
+
+	// Webhooks
+	WebhookConfigFile      string `debugmap:"visible"`
+	WebhookDeliveryEnabled bool   `debugmap:"visible"`
 
 	// Additional Services
 	MetricsAPI util.HTTPServerConfig `debugmap:"visible"`
@@ -234,10 +290,19 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 	}
 	closeables.AddWithError(ds.Close)
 
+	// Caches restored from a snapshot on disk, which must complete before the server is ready.
+	var warmStartedCaches []health.ComponentChecker
+
 	nscc, err := CompleteCache[cache.StringKey, schemacaching.CacheEntry](&c.NamespaceCacheConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create namespace cache: %w", err)
 	}
+	if c.CacheWarmStartDirectory != "" && c.NamespaceCacheConfig.Enabled {
+		persisted := warmstart.NewCache("namespace", nscc, schemacaching.NewPersistenceCodec(ds), c.warmStartConfig("namespace"))
+		persisted.Start()
+		warmStartedCaches = append(warmStartedCaches, persisted)
+		nscc = persisted
+	}
 	log.Ctx(ctx).Info().EmbedObject(nscc).Msg("configured namespace cache")
 
 	cachingMode := schemacaching.JustInTimeCaching
@@ -253,6 +318,12 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 	specificConcurrencyLimits := c.DispatchConcurrencyLimits
 	concurrencyLimits := specificConcurrencyLimits.WithOverallDefaultLimit(c.GlobalDispatchConcurrencyLimit)
 
+	var adaptiveLimits *graph.AdaptiveConcurrencyLimits
+	if c.DispatchAdaptiveConcurrency.Enabled {
+		adaptiveLimits = graph.NewAdaptiveConcurrencyLimits(concurrencyLimits, c.DispatchAdaptiveConcurrency)
+		log.Ctx(ctx).Info().EmbedObject(adaptiveLimits).Msg("configured adaptive dispatch concurrency limits")
+	}
+
 	dispatcher := c.Dispatcher
 	if dispatcher == nil {
 		cc, err := CompleteCache[keys.DispatchCacheKey, any](c.DispatchCacheConfig.WithRevisionParameters(
@@ -271,34 +342,71 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 			dispatchPresharedKey = c.PresharedSecureKey[0]
 		}
 
-		hashringConfigJSON, err := (&consistent.BalancerConfig{
+		hashringConfig := &consistent.BalancerConfig{
 			ReplicationFactor: c.DispatchHashringReplicationFactor,
 			Spread:            c.DispatchHashringSpread,
-		}).ServiceConfigJSON()
+		}
+		hashringConfigJSON, err := hashringConfig.ServiceConfigJSON()
+		if c.DispatchPeerEjectionEnabled {
+			hashringConfigJSON, err = (&outlier.BalancerConfig{
+				Config:   c.DispatchPeerEjection,
+				Hashring: hashringConfig,
+			}).ServiceConfigJSON()
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to create gRPC hashring balancer config: %w", err)
 		}
 
+		upstreamAddr := c.DispatchUpstreamAddr
+		dialOpts := []grpc.DialOption{
+			grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+			grpc.WithDefaultServiceConfig(hashringConfigJSON),
+			grpc.WithChainUnaryInterceptor(
+				requestid.UnaryClientInterceptor(),
+			),
+			grpc.WithChainStreamInterceptor(
+				requestid.StreamClientInterceptor(),
+			),
+		}
+
+		clusterMembership, err := c.dispatchClusterMembership()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dispatch cluster membership: %w", err)
+		}
+		if clusterMembership != nil {
+			closeables.AddWithError(clusterMembership.Close)
+			log.Ctx(ctx).Info().Strs("members", clusterMembership.Members()).Msg("configured dispatch cluster membership")
+
+			upstreamAddr = membership.ResolverScheme + ":///dispatch"
+			dialOpts = append(dialOpts, grpc.WithResolvers(membership.NewResolverBuilder(clusterMembership)))
+		}
+
+		zoneLatencyBudgets := make(map[string]time.Duration, len(c.DispatchZoneLatencyBudgets))
+		for zone, budget := range c.DispatchZoneLatencyBudgets {
+			zoneLatencyBudgets[zone], err = time.ParseDuration(budget)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dispatch latency budget for zone `%s`: %w", zone, err)
+			}
+		}
+
 		dispatcher, err = combineddispatch.NewDispatcher(
-			combineddispatch.UpstreamAddr(c.DispatchUpstreamAddr),
+			combineddispatch.UpstreamAddr(upstreamAddr),
 			combineddispatch.UpstreamCAPath(c.DispatchUpstreamCAPath),
 			combineddispatch.SecondaryUpstreamAddrs(c.DispatchSecondaryUpstreamAddrs),
 			combineddispatch.SecondaryUpstreamExprs(c.DispatchSecondaryUpstreamExprs),
+			combineddispatch.SecondaryUpstreamZones(c.DispatchSecondaryUpstreamZones),
+			combineddispatch.Zone(c.DispatchZone),
+			combineddispatch.CrossZoneLatencyBudget(c.DispatchCrossZoneLatencyBudget),
+			combineddispatch.ZoneLatencyBudgets(zoneLatencyBudgets),
 			combineddispatch.GrpcPresharedKey(dispatchPresharedKey),
-			combineddispatch.GrpcDialOpts(
-				grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-				grpc.WithDefaultServiceConfig(hashringConfigJSON),
-				grpc.WithChainUnaryInterceptor(
-					requestid.UnaryClientInterceptor(),
-				),
-				grpc.WithChainStreamInterceptor(
-					requestid.StreamClientInterceptor(),
-				),
-			),
+			combineddispatch.GrpcDialOpts(dialOpts...),
 			combineddispatch.MetricsEnabled(c.DispatchClientMetricsEnabled),
 			combineddispatch.PrometheusSubsystem(c.DispatchClientMetricsPrefix),
 			combineddispatch.Cache(cc),
+			combineddispatch.SharedCache(c.DispatchSharedCacheConfig.Enabled),
+			combineddispatch.SharedCacheLookupTimeout(c.DispatchSharedCacheLookupTimeout),
 			combineddispatch.ConcurrencyLimits(concurrencyLimits),
+			combineddispatch.AdaptiveConcurrencyLimits(adaptiveLimits),
 			combineddispatch.DispatchChunkSize(c.DispatchChunkSize),
 		)
 		if err != nil {
@@ -307,6 +415,20 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 
 		log.Ctx(ctx).Info().EmbedObject(concurrencyLimits).RawJSON("balancerconfig", []byte(hashringConfigJSON)).Msg("configured dispatcher")
 	}
+
+	if len(c.MaterializedPermissions) > 0 {
+		indexed := make([]materialized.IndexedPermission, 0, len(c.MaterializedPermissions))
+		for _, value := range c.MaterializedPermissions {
+			permission, err := materialized.ParseIndexedPermission(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure materialized permissions: %w", err)
+			}
+			indexed = append(indexed, permission)
+		}
+
+		dispatcher = materialized.NewDispatcher(dispatcher, ds, indexed, c.DispatchMaxDepth)
+		log.Ctx(ctx).Info().Strs("permissions", c.MaterializedPermissions).Msg("configured materialized permission indexes")
+	}
 	closeables.AddWithError(dispatcher.Close)
 
 	if len(c.DispatchUnaryMiddleware) == 0 && len(c.DispatchStreamingMiddleware) == 0 {
@@ -318,6 +440,7 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 	}
 
 	var cachingClusterDispatch dispatch.Dispatcher
+	var sharedDispatchCache cache.Cache[cache.StringKey, []byte]
 	if c.DispatchServer.Enabled {
 		cdcc, err := CompleteCache[keys.DispatchCacheKey, any](c.ClusterDispatchCacheConfig.WithRevisionParameters(
 			c.DatastoreConfig.RevisionQuantization,
@@ -337,17 +460,34 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 			clusterdispatch.Cache(cdcc),
 			clusterdispatch.RemoteDispatchTimeout(c.DispatchUpstreamTimeout),
 			clusterdispatch.ConcurrencyLimits(concurrencyLimits),
+			clusterdispatch.AdaptiveConcurrencyLimits(adaptiveLimits),
 			clusterdispatch.DispatchChunkSize(c.DispatchChunkSize),
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to configure cluster dispatch: %w", err)
 		}
 		closeables.AddWithError(cachingClusterDispatch.Close)
+
+		if c.DispatchSharedCacheConfig.Enabled {
+			sharedDispatchCache, err = CompleteCache[cache.StringKey, []byte](c.DispatchSharedCacheConfig.WithRevisionParameters(
+				c.DatastoreConfig.RevisionQuantization,
+				c.DatastoreConfig.FollowerReadDelay,
+				c.DatastoreConfig.MaxRevisionStalenessPercent,
+			))
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure shared dispatch cache: %w", err)
+			}
+			log.Ctx(ctx).Info().EmbedObject(sharedDispatchCache).Msg("configured shared dispatch cache")
+			closeables.AddWithoutError(sharedDispatchCache.Close)
+		}
 	}
 
 	dispatchGrpcServer, err := c.DispatchServer.Complete(zerolog.InfoLevel,
 		func(server *grpc.Server) {
 			dispatchSvc.RegisterGrpcServices(server, cachingClusterDispatch)
+			if sharedDispatchCache != nil {
+				peercache.RegisterServer(server, sharedDispatchCache)
+			}
 		},
 		grpc.ChainUnaryInterceptor(c.DispatchUnaryMiddleware...),
 		grpc.ChainStreamInterceptor(c.DispatchStreamingMiddleware...),
@@ -376,6 +516,76 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 		watchServiceOption = services.WatchServiceDisabled
 	}
 
+	permissionWatchServiceOption := services.PermissionWatchServiceDisabled
+	if c.EnablePermissionWatchAPI {
+		if watchServiceOption == services.WatchServiceEnabled {
+			permissionWatchServiceOption = services.PermissionWatchServiceEnabled
+		} else {
+			log.Ctx(ctx).Warn().Msg("permission watch api disabled; it requires the watch api")
+		}
+	}
+
+	var rateLimiter *ratelimit.Limiter
+	if c.RateLimitConfigFile != "" {
+		reloadInterval := c.RateLimitConfigReloadInterval
+		if reloadInterval <= 0 {
+			reloadInterval = ratelimit.DefaultReloadInterval
+		}
+
+		rateLimiter, err = ratelimit.NewLimiter(c.RateLimitConfigFile, reloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rate limiter: %w", err)
+		}
+		closeables.AddWithError(rateLimiter.Close)
+	}
+
+	switch {
+	case c.WebhookDeliveryEnabled && c.WebhookConfigFile == "":
+		return nil, fmt.Errorf("webhook delivery requires a webhook configuration file")
+
+	case c.WebhookDeliveryEnabled:
+		if datastoreFeatures.Watch.Status != datastore.FeatureSupported {
+			return nil, fmt.Errorf("webhooks require the watch api, which the datastore does not support: %s", datastoreFeatures.Watch.Reason)
+		}
+
+		deliverer, err := webhooks.NewDeliverer(c.WebhookConfigFile, ds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create webhook deliverer: %w", err)
+		}
+		closeables.AddWithError(deliverer.Close)
+
+	case c.WebhookConfigFile != "":
+		// The webhooks are delivered by the single node on which delivery is enabled, as their
+		// delivery state is local to it; the configuration is only validated on the other nodes.
+		if _, err := webhooks.LoadConfig(c.WebhookConfigFile); err != nil {
+			return nil, err
+		}
+		log.Ctx(ctx).Info().Msg("webhook delivery is not enabled on this node")
+	}
+
+	var admissionController *admission.Controller
+	if c.AdmissionControl.Enabled {
+		signals := map[string]admission.Signal{
+			"datastore-pool": admission.DatastorePoolSignal(ds),
+		}
+		if adaptiveLimits != nil {
+			signals["dispatch-concurrency"] = adaptiveLimits
+		}
+
+		admissionController, err = admission.NewController(c.AdmissionControl, signals)
+		if errors.Is(err, admission.ErrNoSignals) {
+			return nil, fmt.Errorf("failed to create admission controller: %w; it requires a datastore reporting connection pool stats (postgres) or adaptive dispatch concurrency limits", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create admission controller: %w", err)
+		}
+	}
+
+	dispatchBudgets, err := dispatchbudget.New(c.DispatchBudget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure dispatch budgets: %w", err)
+	}
+
 	opts := MiddlewareOption{
 		log.Logger,
 		c.GRPCAuthFunc,
@@ -384,6 +594,9 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 		c.EnableRequestLogs,
 		c.EnableResponseLogs,
 		c.DisableGRPCLatencyHistogram,
+		rateLimiter,
+		admissionController,
+		dispatchBudgets,
 		nil,
 		nil,
 	}
@@ -418,22 +631,25 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 	}
 
 	permSysConfig := v1svc.PermissionsServerConfig{
-		MaxPreconditionsCount:           c.MaximumPreconditionCount,
-		MaxUpdatesPerWrite:              c.MaximumUpdatesPerWrite,
-		MaximumAPIDepth:                 c.DispatchMaxDepth,
-		MaxCaveatContextSize:            c.MaxCaveatContextSize,
-		MaxRelationshipContextSize:      c.MaxRelationshipContextSize,
-		MaxDatastoreReadPageSize:        c.MaxDatastoreReadPageSize,
-		StreamingAPITimeout:             c.StreamingAPITimeout,
-		MaxReadRelationshipsLimit:       c.MaxReadRelationshipsLimit,
-		MaxDeleteRelationshipsLimit:     c.MaxDeleteRelationshipsLimit,
-		MaxLookupResourcesLimit:         c.MaxLookupResourcesLimit,
-		MaxBulkExportRelationshipsLimit: c.MaxBulkExportRelationshipsLimit,
-		UseExperimentalLookupResources2: c.EnableExperimentalLookupResources,
-		DispatchChunkSize:               c.DispatchChunkSize,
-	}
-
-	healthManager := health.NewHealthManager(dispatcher, ds)
+		MaxPreconditionsCount:               c.MaximumPreconditionCount,
+		MaxUpdatesPerWrite:                  c.MaximumUpdatesPerWrite,
+		MaximumAPIDepth:                     c.DispatchMaxDepth,
+		MaxCaveatContextSize:                c.MaxCaveatContextSize,
+		MaxRelationshipContextSize:          c.MaxRelationshipContextSize,
+		MaxDatastoreReadPageSize:            c.MaxDatastoreReadPageSize,
+		StreamingAPITimeout:                 c.StreamingAPITimeout,
+		MaxReadRelationshipsLimit:           c.MaxReadRelationshipsLimit,
+		MaxDeleteRelationshipsLimit:         c.MaxDeleteRelationshipsLimit,
+		MaxLookupResourcesLimit:             c.MaxLookupResourcesLimit,
+		MaxBulkExportRelationshipsLimit:     c.MaxBulkExportRelationshipsLimit,
+		UseExperimentalLookupResources2:     c.EnableExperimentalLookupResources,
+		DispatchChunkSize:                   c.DispatchChunkSize,
+		CheckHintsKey:                       derivedKey(c.PresharedSecureKey, "spicedb-check-hints"),
+		BulkImportTokensKey:                 derivedKey(c.PresharedSecureKey, "spicedb-bulk-import-tokens"),
+		MaxPermissionWatchAffectedResources: c.MaxPermissionWatchAffectedResources,
+	}
+
+	healthManager := health.NewHealthManager(dispatcher, ds, warmStartedCaches...)
 	grpcServer, err := c.GRPCServer.Complete(zerolog.InfoLevel,
 		func(server *grpc.Server) {
 			services.RegisterGrpcServices(
@@ -442,6 +658,7 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 				dispatcher,
 				v1SchemaServiceOption,
 				watchServiceOption,
+				permissionWatchServiceOption,
 				permSysConfig,
 				c.WatchHeartbeat,
 			)
@@ -514,6 +731,64 @@ func (c *Config) Complete(ctx context.Context) (RunnableServer, error) {
 	}, nil
 }
 
+// derivedKey derives the key used for the given purpose, such as signing check hints tokens or
+// authenticating dispatch cluster gossip, from the first preshared key, so that only nodes sharing
+// the key accept what is signed by one another.
+func derivedKey(presharedKeys []string, purpose string) []byte {
+	if len(presharedKeys) == 0 {
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(presharedKeys[0]))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// warmStartConfig returns the configuration for persisting the cache with the given name to the
+// warm start directory.
+func (c *Config) warmStartConfig(name string) warmstart.Config {
+	return warmstart.Config{
+		Path:             filepath.Join(c.CacheWarmStartDirectory, name+".snapshot"),
+		SnapshotInterval: c.CacheWarmStartInterval,
+		MaxEntries:       c.CacheWarmStartMaxEntries,
+	}
+}
+
+// dispatchClusterMembership returns the membership source configured for the dispatch cluster, if
+// any, which is used in place of resolving the upstream dispatch address.
+func (c *Config) dispatchClusterMembership() (membership.Membership, error) {
+	peersFileEnabled := c.DispatchClusterPeersFile != ""
+	gossipEnabled := c.DispatchClusterGossipBindAddr != ""
+	if !peersFileEnabled && !gossipEnabled {
+		return nil, nil
+	}
+
+	if peersFileEnabled && gossipEnabled {
+		return nil, fmt.Errorf("a dispatch cluster peers file and gossip cannot both be configured")
+	}
+	if c.DispatchUpstreamAddr != "" {
+		return nil, fmt.Errorf("a dispatch upstream address cannot be configured alongside dispatch cluster membership")
+	}
+
+	if peersFileEnabled {
+		reloadInterval := c.DispatchClusterPeersFileReloadInterval
+		if reloadInterval <= 0 {
+			reloadInterval = membership.DefaultStaticFileReloadInterval
+		}
+		return membership.NewStaticFile(c.DispatchClusterPeersFile, reloadInterval)
+	}
+
+	return membership.NewGossip(membership.GossipConfig{
+		DispatchAddr:   c.DispatchClusterGossipDispatchAddr,
+		BindAddr:       c.DispatchClusterGossipBindAddr,
+		AdvertiseAddr:  c.DispatchClusterGossipAdvertiseAddr,
+		Seeds:          c.DispatchClusterGossipSeeds,
+		SecretKey:      derivedKey(c.PresharedSecureKey, "spicedb-dispatch-gossip"),
+		Interval:       c.DispatchClusterGossipInterval,
+		FailureTimeout: c.DispatchClusterGossipFailureTimeout,
+	})
+}
+
 func (c *Config) buildUnaryMiddleware(defaultMiddleware *MiddlewareChain[grpc.UnaryServerInterceptor]) ([]grpc.UnaryServerInterceptor, error) {
 	chain := MiddlewareChain[grpc.UnaryServerInterceptor]{}
 	if defaultMiddleware != nil {
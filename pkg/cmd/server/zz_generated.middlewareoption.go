@@ -3,6 +3,9 @@ package server
 
 import (
 	dispatch "github.com/zapravila/spicedb/internal/dispatch"
+	admission "github.com/zapravila/spicedb/internal/middleware/admission"
+	dispatchbudget "github.com/zapravila/spicedb/internal/middleware/dispatchbudget"
+	ratelimit "github.com/zapravila/spicedb/internal/middleware/ratelimit"
 	defaults "github.com/creasty/defaults"
 	helpers "github.com/ecordell/optgen/helpers"
 	auth "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/auth"
@@ -40,6 +43,9 @@ func (m *MiddlewareOption) ToOption() MiddlewareOptionOption {
 		to.EnableRequestLog = m.EnableRequestLog
 		to.EnableResponseLog = m.EnableResponseLog
 		to.DisableGRPCHistogram = m.DisableGRPCHistogram
+		to.RateLimiter = m.RateLimiter
+		to.AdmissionController = m.AdmissionController
+		to.DispatchBudgets = m.DispatchBudgets
 		to.unaryDatastoreMiddleware = m.unaryDatastoreMiddleware
 		to.streamDatastoreMiddleware = m.streamDatastoreMiddleware
 	}
@@ -119,3 +125,24 @@ func WithDisableGRPCHistogram(disableGRPCHistogram bool) MiddlewareOptionOption
 		m.DisableGRPCHistogram = disableGRPCHistogram
 	}
 }
+
+// WithRateLimiter returns an option that can set RateLimiter on a MiddlewareOption
+func WithRateLimiter(rateLimiter *ratelimit.Limiter) MiddlewareOptionOption {
+	return func(m *MiddlewareOption) {
+		m.RateLimiter = rateLimiter
+	}
+}
+
+// WithAdmissionController returns an option that can set AdmissionController on a MiddlewareOption
+func WithAdmissionController(admissionController *admission.Controller) MiddlewareOptionOption {
+	return func(m *MiddlewareOption) {
+		m.AdmissionController = admissionController
+	}
+}
+
+// WithDispatchBudgets returns an option that can set DispatchBudgets on a MiddlewareOption
+func WithDispatchBudgets(dispatchBudgets *dispatchbudget.Budgets) MiddlewareOptionOption {
+	return func(m *MiddlewareOption) {
+		m.DispatchBudgets = dispatchBudgets
+	}
+}
@@ -4,6 +4,9 @@ package server
 import (
 	dispatch "github.com/zapravila/spicedb/internal/dispatch"
 	graph "github.com/zapravila/spicedb/internal/dispatch/graph"
+	outlier "github.com/zapravila/spicedb/internal/dispatch/outlier"
+	admission "github.com/zapravila/spicedb/internal/middleware/admission"
+	dispatchbudget "github.com/zapravila/spicedb/internal/middleware/dispatchbudget"
 	datastore "github.com/zapravila/spicedb/pkg/cmd/datastore"
 	util "github.com/zapravila/spicedb/pkg/cmd/util"
 	datastore1 "github.com/zapravila/spicedb/pkg/datastore"
@@ -55,11 +58,15 @@ func (c *Config) ToOption() ConfigOption {
 		to.EnableExperimentalWatchableSchemaCache = c.EnableExperimentalWatchableSchemaCache
 		to.SchemaWatchHeartbeat = c.SchemaWatchHeartbeat
 		to.NamespaceCacheConfig = c.NamespaceCacheConfig
+		to.CacheWarmStartDirectory = c.CacheWarmStartDirectory
+		to.CacheWarmStartInterval = c.CacheWarmStartInterval
+		to.CacheWarmStartMaxEntries = c.CacheWarmStartMaxEntries
 		to.SchemaPrefixesRequired = c.SchemaPrefixesRequired
 		to.DispatchServer = c.DispatchServer
 		to.DispatchMaxDepth = c.DispatchMaxDepth
 		to.GlobalDispatchConcurrencyLimit = c.GlobalDispatchConcurrencyLimit
 		to.DispatchConcurrencyLimits = c.DispatchConcurrencyLimits
+		to.DispatchAdaptiveConcurrency = c.DispatchAdaptiveConcurrency
 		to.DispatchUpstreamAddr = c.DispatchUpstreamAddr
 		to.DispatchUpstreamCAPath = c.DispatchUpstreamCAPath
 		to.DispatchUpstreamTimeout = c.DispatchUpstreamTimeout
@@ -70,11 +77,27 @@ func (c *Config) ToOption() ConfigOption {
 		to.Dispatcher = c.Dispatcher
 		to.DispatchHashringReplicationFactor = c.DispatchHashringReplicationFactor
 		to.DispatchHashringSpread = c.DispatchHashringSpread
+		to.DispatchPeerEjectionEnabled = c.DispatchPeerEjectionEnabled
+		to.DispatchPeerEjection = c.DispatchPeerEjection
 		to.DispatchChunkSize = c.DispatchChunkSize
 		to.DispatchSecondaryUpstreamAddrs = c.DispatchSecondaryUpstreamAddrs
 		to.DispatchSecondaryUpstreamExprs = c.DispatchSecondaryUpstreamExprs
+		to.DispatchSecondaryUpstreamZones = c.DispatchSecondaryUpstreamZones
+		to.DispatchZone = c.DispatchZone
+		to.DispatchCrossZoneLatencyBudget = c.DispatchCrossZoneLatencyBudget
+		to.DispatchZoneLatencyBudgets = c.DispatchZoneLatencyBudgets
+		to.DispatchClusterPeersFile = c.DispatchClusterPeersFile
+		to.DispatchClusterPeersFileReloadInterval = c.DispatchClusterPeersFileReloadInterval
+		to.DispatchClusterGossipBindAddr = c.DispatchClusterGossipBindAddr
+		to.DispatchClusterGossipAdvertiseAddr = c.DispatchClusterGossipAdvertiseAddr
+		to.DispatchClusterGossipDispatchAddr = c.DispatchClusterGossipDispatchAddr
+		to.DispatchClusterGossipSeeds = c.DispatchClusterGossipSeeds
+		to.DispatchClusterGossipInterval = c.DispatchClusterGossipInterval
+		to.DispatchClusterGossipFailureTimeout = c.DispatchClusterGossipFailureTimeout
 		to.DispatchCacheConfig = c.DispatchCacheConfig
 		to.ClusterDispatchCacheConfig = c.ClusterDispatchCacheConfig
+		to.DispatchSharedCacheConfig = c.DispatchSharedCacheConfig
+		to.DispatchSharedCacheLookupTimeout = c.DispatchSharedCacheLookupTimeout
 		to.DisableV1SchemaAPI = c.DisableV1SchemaAPI
 		to.V1SchemaAdditiveOnly = c.V1SchemaAdditiveOnly
 		to.MaximumUpdatesPerWrite = c.MaximumUpdatesPerWrite
@@ -86,7 +109,16 @@ func (c *Config) ToOption() ConfigOption {
 		to.MaxDeleteRelationshipsLimit = c.MaxDeleteRelationshipsLimit
 		to.MaxLookupResourcesLimit = c.MaxLookupResourcesLimit
 		to.MaxBulkExportRelationshipsLimit = c.MaxBulkExportRelationshipsLimit
+		to.EnablePermissionWatchAPI = c.EnablePermissionWatchAPI
+		to.MaxPermissionWatchAffectedResources = c.MaxPermissionWatchAffectedResources
 		to.EnableExperimentalLookupResources = c.EnableExperimentalLookupResources
+		to.MaterializedPermissions = c.MaterializedPermissions
+		to.RateLimitConfigFile = c.RateLimitConfigFile
+		to.RateLimitConfigReloadInterval = c.RateLimitConfigReloadInterval
+		to.AdmissionControl = c.AdmissionControl
+		to.DispatchBudget = c.DispatchBudget
+		to.WebhookConfigFile = c.WebhookConfigFile
+		to.WebhookDeliveryEnabled = c.WebhookDeliveryEnabled
 		to.MetricsAPI = c.MetricsAPI
 		to.UnaryMiddlewareModification = c.UnaryMiddlewareModification
 		to.StreamingMiddlewareModification = c.StreamingMiddlewareModification
@@ -122,11 +154,15 @@ func (c Config) DebugMap() map[string]any {
 	debugMap["EnableExperimentalWatchableSchemaCache"] = helpers.DebugValue(c.EnableExperimentalWatchableSchemaCache, false)
 	debugMap["SchemaWatchHeartbeat"] = helpers.DebugValue(c.SchemaWatchHeartbeat, false)
 	debugMap["NamespaceCacheConfig"] = helpers.DebugValue(c.NamespaceCacheConfig, false)
+	debugMap["CacheWarmStartDirectory"] = helpers.DebugValue(c.CacheWarmStartDirectory, false)
+	debugMap["CacheWarmStartInterval"] = helpers.DebugValue(c.CacheWarmStartInterval, false)
+	debugMap["CacheWarmStartMaxEntries"] = helpers.DebugValue(c.CacheWarmStartMaxEntries, false)
 	debugMap["SchemaPrefixesRequired"] = helpers.DebugValue(c.SchemaPrefixesRequired, false)
 	debugMap["DispatchServer"] = helpers.DebugValue(c.DispatchServer, false)
 	debugMap["DispatchMaxDepth"] = helpers.DebugValue(c.DispatchMaxDepth, false)
 	debugMap["GlobalDispatchConcurrencyLimit"] = helpers.DebugValue(c.GlobalDispatchConcurrencyLimit, false)
 	debugMap["DispatchConcurrencyLimits"] = helpers.DebugValue(c.DispatchConcurrencyLimits, false)
+	debugMap["DispatchAdaptiveConcurrency"] = helpers.DebugValue(c.DispatchAdaptiveConcurrency, false)
 	debugMap["DispatchUpstreamAddr"] = helpers.DebugValue(c.DispatchUpstreamAddr, false)
 	debugMap["DispatchUpstreamCAPath"] = helpers.DebugValue(c.DispatchUpstreamCAPath, false)
 	debugMap["DispatchUpstreamTimeout"] = helpers.DebugValue(c.DispatchUpstreamTimeout, false)
@@ -137,11 +173,27 @@ func (c Config) DebugMap() map[string]any {
 	debugMap["Dispatcher"] = helpers.DebugValue(c.Dispatcher, false)
 	debugMap["DispatchHashringReplicationFactor"] = helpers.DebugValue(c.DispatchHashringReplicationFactor, false)
 	debugMap["DispatchHashringSpread"] = helpers.DebugValue(c.DispatchHashringSpread, false)
+	debugMap["DispatchPeerEjectionEnabled"] = helpers.DebugValue(c.DispatchPeerEjectionEnabled, false)
+	debugMap["DispatchPeerEjection"] = helpers.DebugValue(c.DispatchPeerEjection, false)
 	debugMap["DispatchChunkSize"] = helpers.DebugValue(c.DispatchChunkSize, false)
 	debugMap["DispatchSecondaryUpstreamAddrs"] = helpers.DebugValue(c.DispatchSecondaryUpstreamAddrs, false)
 	debugMap["DispatchSecondaryUpstreamExprs"] = helpers.DebugValue(c.DispatchSecondaryUpstreamExprs, false)
+	debugMap["DispatchSecondaryUpstreamZones"] = helpers.DebugValue(c.DispatchSecondaryUpstreamZones, false)
+	debugMap["DispatchZone"] = helpers.DebugValue(c.DispatchZone, false)
+	debugMap["DispatchCrossZoneLatencyBudget"] = helpers.DebugValue(c.DispatchCrossZoneLatencyBudget, false)
+	debugMap["DispatchZoneLatencyBudgets"] = helpers.DebugValue(c.DispatchZoneLatencyBudgets, false)
+	debugMap["DispatchClusterPeersFile"] = helpers.DebugValue(c.DispatchClusterPeersFile, false)
+	debugMap["DispatchClusterPeersFileReloadInterval"] = helpers.DebugValue(c.DispatchClusterPeersFileReloadInterval, false)
+	debugMap["DispatchClusterGossipBindAddr"] = helpers.DebugValue(c.DispatchClusterGossipBindAddr, false)
+	debugMap["DispatchClusterGossipAdvertiseAddr"] = helpers.DebugValue(c.DispatchClusterGossipAdvertiseAddr, false)
+	debugMap["DispatchClusterGossipDispatchAddr"] = helpers.DebugValue(c.DispatchClusterGossipDispatchAddr, false)
+	debugMap["DispatchClusterGossipSeeds"] = helpers.DebugValue(c.DispatchClusterGossipSeeds, false)
+	debugMap["DispatchClusterGossipInterval"] = helpers.DebugValue(c.DispatchClusterGossipInterval, false)
+	debugMap["DispatchClusterGossipFailureTimeout"] = helpers.DebugValue(c.DispatchClusterGossipFailureTimeout, false)
 	debugMap["DispatchCacheConfig"] = helpers.DebugValue(c.DispatchCacheConfig, false)
 	debugMap["ClusterDispatchCacheConfig"] = helpers.DebugValue(c.ClusterDispatchCacheConfig, false)
+	debugMap["DispatchSharedCacheConfig"] = helpers.DebugValue(c.DispatchSharedCacheConfig, false)
+	debugMap["DispatchSharedCacheLookupTimeout"] = helpers.DebugValue(c.DispatchSharedCacheLookupTimeout, false)
 	debugMap["DisableV1SchemaAPI"] = helpers.DebugValue(c.DisableV1SchemaAPI, false)
 	debugMap["V1SchemaAdditiveOnly"] = helpers.DebugValue(c.V1SchemaAdditiveOnly, false)
 	debugMap["MaximumUpdatesPerWrite"] = helpers.DebugValue(c.MaximumUpdatesPerWrite, false)
@@ -153,7 +205,16 @@ func (c Config) DebugMap() map[string]any {
 	debugMap["MaxDeleteRelationshipsLimit"] = helpers.DebugValue(c.MaxDeleteRelationshipsLimit, false)
 	debugMap["MaxLookupResourcesLimit"] = helpers.DebugValue(c.MaxLookupResourcesLimit, false)
 	debugMap["MaxBulkExportRelationshipsLimit"] = helpers.DebugValue(c.MaxBulkExportRelationshipsLimit, false)
+	debugMap["EnablePermissionWatchAPI"] = helpers.DebugValue(c.EnablePermissionWatchAPI, false)
+	debugMap["MaxPermissionWatchAffectedResources"] = helpers.DebugValue(c.MaxPermissionWatchAffectedResources, false)
 	debugMap["EnableExperimentalLookupResources"] = helpers.DebugValue(c.EnableExperimentalLookupResources, false)
+	debugMap["MaterializedPermissions"] = helpers.DebugValue(c.MaterializedPermissions, false)
+	debugMap["RateLimitConfigFile"] = helpers.DebugValue(c.RateLimitConfigFile, false)
+	debugMap["RateLimitConfigReloadInterval"] = helpers.DebugValue(c.RateLimitConfigReloadInterval, false)
+	debugMap["AdmissionControl"] = helpers.DebugValue(c.AdmissionControl, false)
+	debugMap["DispatchBudget"] = helpers.SensitiveDebugValue(c.DispatchBudget)
+	debugMap["WebhookConfigFile"] = helpers.DebugValue(c.WebhookConfigFile, false)
+	debugMap["WebhookDeliveryEnabled"] = helpers.DebugValue(c.WebhookDeliveryEnabled, false)
 	debugMap["MetricsAPI"] = helpers.DebugValue(c.MetricsAPI, false)
 	debugMap["SilentlyDisableTelemetry"] = helpers.DebugValue(c.SilentlyDisableTelemetry, false)
 	debugMap["TelemetryCAOverridePath"] = helpers.DebugValue(c.TelemetryCAOverridePath, false)
@@ -314,6 +375,27 @@ func WithNamespaceCacheConfig(namespaceCacheConfig CacheConfig) ConfigOption {
 	}
 }
 
+// WithCacheWarmStartDirectory returns an option that can set CacheWarmStartDirectory on a Config
+func WithCacheWarmStartDirectory(cacheWarmStartDirectory string) ConfigOption {
+	return func(c *Config) {
+		c.CacheWarmStartDirectory = cacheWarmStartDirectory
+	}
+}
+
+// WithCacheWarmStartInterval returns an option that can set CacheWarmStartInterval on a Config
+func WithCacheWarmStartInterval(cacheWarmStartInterval time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.CacheWarmStartInterval = cacheWarmStartInterval
+	}
+}
+
+// WithCacheWarmStartMaxEntries returns an option that can set CacheWarmStartMaxEntries on a Config
+func WithCacheWarmStartMaxEntries(cacheWarmStartMaxEntries int) ConfigOption {
+	return func(c *Config) {
+		c.CacheWarmStartMaxEntries = cacheWarmStartMaxEntries
+	}
+}
+
 // WithSchemaPrefixesRequired returns an option that can set SchemaPrefixesRequired on a Config
 func WithSchemaPrefixesRequired(schemaPrefixesRequired bool) ConfigOption {
 	return func(c *Config) {
@@ -349,6 +431,13 @@ func WithDispatchConcurrencyLimits(dispatchConcurrencyLimits graph.ConcurrencyLi
 	}
 }
 
+// WithDispatchAdaptiveConcurrency returns an option that can set DispatchAdaptiveConcurrency on a Config
+func WithDispatchAdaptiveConcurrency(dispatchAdaptiveConcurrency graph.AdaptiveConcurrencyConfig) ConfigOption {
+	return func(c *Config) {
+		c.DispatchAdaptiveConcurrency = dispatchAdaptiveConcurrency
+	}
+}
+
 // WithDispatchUpstreamAddr returns an option that can set DispatchUpstreamAddr on a Config
 func WithDispatchUpstreamAddr(dispatchUpstreamAddr string) ConfigOption {
 	return func(c *Config) {
@@ -419,6 +508,20 @@ func WithDispatchHashringSpread(dispatchHashringSpread uint8) ConfigOption {
 	}
 }
 
+// WithDispatchPeerEjectionEnabled returns an option that can set DispatchPeerEjectionEnabled on a Config
+func WithDispatchPeerEjectionEnabled(dispatchPeerEjectionEnabled bool) ConfigOption {
+	return func(c *Config) {
+		c.DispatchPeerEjectionEnabled = dispatchPeerEjectionEnabled
+	}
+}
+
+// WithDispatchPeerEjection returns an option that can set DispatchPeerEjection on a Config
+func WithDispatchPeerEjection(dispatchPeerEjection outlier.Config) ConfigOption {
+	return func(c *Config) {
+		c.DispatchPeerEjection = dispatchPeerEjection
+	}
+}
+
 // WithDispatchChunkSize returns an option that can set DispatchChunkSize on a Config
 func WithDispatchChunkSize(dispatchChunkSize uint16) ConfigOption {
 	return func(c *Config) {
@@ -454,6 +557,111 @@ func SetDispatchSecondaryUpstreamExprs(dispatchSecondaryUpstreamExprs map[string
 	}
 }
 
+// WithDispatchSecondaryUpstreamZones returns an option that can append DispatchSecondaryUpstreamZoness to Config.DispatchSecondaryUpstreamZones
+func WithDispatchSecondaryUpstreamZones(key string, value string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchSecondaryUpstreamZones[key] = value
+	}
+}
+
+// SetDispatchSecondaryUpstreamZones returns an option that can set DispatchSecondaryUpstreamZones on a Config
+func SetDispatchSecondaryUpstreamZones(dispatchSecondaryUpstreamZones map[string]string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchSecondaryUpstreamZones = dispatchSecondaryUpstreamZones
+	}
+}
+
+// WithDispatchZone returns an option that can set DispatchZone on a Config
+func WithDispatchZone(dispatchZone string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchZone = dispatchZone
+	}
+}
+
+// WithDispatchCrossZoneLatencyBudget returns an option that can set DispatchCrossZoneLatencyBudget on a Config
+func WithDispatchCrossZoneLatencyBudget(dispatchCrossZoneLatencyBudget time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.DispatchCrossZoneLatencyBudget = dispatchCrossZoneLatencyBudget
+	}
+}
+
+// WithDispatchZoneLatencyBudgets returns an option that can append DispatchZoneLatencyBudgetss to Config.DispatchZoneLatencyBudgets
+func WithDispatchZoneLatencyBudgets(key string, value string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchZoneLatencyBudgets[key] = value
+	}
+}
+
+// SetDispatchZoneLatencyBudgets returns an option that can set DispatchZoneLatencyBudgets on a Config
+func SetDispatchZoneLatencyBudgets(dispatchZoneLatencyBudgets map[string]string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchZoneLatencyBudgets = dispatchZoneLatencyBudgets
+	}
+}
+
+// WithDispatchClusterPeersFile returns an option that can set DispatchClusterPeersFile on a Config
+func WithDispatchClusterPeersFile(dispatchClusterPeersFile string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterPeersFile = dispatchClusterPeersFile
+	}
+}
+
+// WithDispatchClusterPeersFileReloadInterval returns an option that can set DispatchClusterPeersFileReloadInterval on a Config
+func WithDispatchClusterPeersFileReloadInterval(dispatchClusterPeersFileReloadInterval time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterPeersFileReloadInterval = dispatchClusterPeersFileReloadInterval
+	}
+}
+
+// WithDispatchClusterGossipBindAddr returns an option that can set DispatchClusterGossipBindAddr on a Config
+func WithDispatchClusterGossipBindAddr(dispatchClusterGossipBindAddr string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterGossipBindAddr = dispatchClusterGossipBindAddr
+	}
+}
+
+// WithDispatchClusterGossipAdvertiseAddr returns an option that can set DispatchClusterGossipAdvertiseAddr on a Config
+func WithDispatchClusterGossipAdvertiseAddr(dispatchClusterGossipAdvertiseAddr string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterGossipAdvertiseAddr = dispatchClusterGossipAdvertiseAddr
+	}
+}
+
+// WithDispatchClusterGossipDispatchAddr returns an option that can set DispatchClusterGossipDispatchAddr on a Config
+func WithDispatchClusterGossipDispatchAddr(dispatchClusterGossipDispatchAddr string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterGossipDispatchAddr = dispatchClusterGossipDispatchAddr
+	}
+}
+
+// WithDispatchClusterGossipSeeds returns an option that can append DispatchClusterGossipSeedss to Config.DispatchClusterGossipSeeds
+func WithDispatchClusterGossipSeeds(dispatchClusterGossipSeeds string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterGossipSeeds = append(c.DispatchClusterGossipSeeds, dispatchClusterGossipSeeds)
+	}
+}
+
+// SetDispatchClusterGossipSeeds returns an option that can set DispatchClusterGossipSeeds on a Config
+func SetDispatchClusterGossipSeeds(dispatchClusterGossipSeeds []string) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterGossipSeeds = dispatchClusterGossipSeeds
+	}
+}
+
+// WithDispatchClusterGossipInterval returns an option that can set DispatchClusterGossipInterval on a Config
+func WithDispatchClusterGossipInterval(dispatchClusterGossipInterval time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterGossipInterval = dispatchClusterGossipInterval
+	}
+}
+
+// WithDispatchClusterGossipFailureTimeout returns an option that can set DispatchClusterGossipFailureTimeout on a Config
+func WithDispatchClusterGossipFailureTimeout(dispatchClusterGossipFailureTimeout time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.DispatchClusterGossipFailureTimeout = dispatchClusterGossipFailureTimeout
+	}
+}
+
 // WithDispatchCacheConfig returns an option that can set DispatchCacheConfig on a Config
 func WithDispatchCacheConfig(dispatchCacheConfig CacheConfig) ConfigOption {
 	return func(c *Config) {
@@ -468,6 +676,20 @@ func WithClusterDispatchCacheConfig(clusterDispatchCacheConfig CacheConfig) Conf
 	}
 }
 
+// WithDispatchSharedCacheConfig returns an option that can set DispatchSharedCacheConfig on a Config
+func WithDispatchSharedCacheConfig(dispatchSharedCacheConfig CacheConfig) ConfigOption {
+	return func(c *Config) {
+		c.DispatchSharedCacheConfig = dispatchSharedCacheConfig
+	}
+}
+
+// WithDispatchSharedCacheLookupTimeout returns an option that can set DispatchSharedCacheLookupTimeout on a Config
+func WithDispatchSharedCacheLookupTimeout(dispatchSharedCacheLookupTimeout time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.DispatchSharedCacheLookupTimeout = dispatchSharedCacheLookupTimeout
+	}
+}
+
 // WithDisableV1SchemaAPI returns an option that can set DisableV1SchemaAPI on a Config
 func WithDisableV1SchemaAPI(disableV1SchemaAPI bool) ConfigOption {
 	return func(c *Config) {
@@ -545,6 +767,20 @@ func WithMaxBulkExportRelationshipsLimit(maxBulkExportRelationshipsLimit uint32)
 	}
 }
 
+// WithEnablePermissionWatchAPI returns an option that can set EnablePermissionWatchAPI on a Config
+func WithEnablePermissionWatchAPI(enablePermissionWatchAPI bool) ConfigOption {
+	return func(c *Config) {
+		c.EnablePermissionWatchAPI = enablePermissionWatchAPI
+	}
+}
+
+// WithMaxPermissionWatchAffectedResources returns an option that can set MaxPermissionWatchAffectedResources on a Config
+func WithMaxPermissionWatchAffectedResources(maxPermissionWatchAffectedResources uint32) ConfigOption {
+	return func(c *Config) {
+		c.MaxPermissionWatchAffectedResources = maxPermissionWatchAffectedResources
+	}
+}
+
 // WithEnableExperimentalLookupResources returns an option that can set EnableExperimentalLookupResources on a Config
 func WithEnableExperimentalLookupResources(enableExperimentalLookupResources bool) ConfigOption {
 	return func(c *Config) {
@@ -552,6 +788,62 @@ func WithEnableExperimentalLookupResources(enableExperimentalLookupResources boo
 	}
 }
 
+// WithMaterializedPermissions returns an option that can append MaterializedPermissionss to Config.MaterializedPermissions
+func WithMaterializedPermissions(materializedPermissions string) ConfigOption {
+	return func(c *Config) {
+		c.MaterializedPermissions = append(c.MaterializedPermissions, materializedPermissions)
+	}
+}
+
+// SetMaterializedPermissions returns an option that can set MaterializedPermissions on a Config
+func SetMaterializedPermissions(materializedPermissions []string) ConfigOption {
+	return func(c *Config) {
+		c.MaterializedPermissions = materializedPermissions
+	}
+}
+
+// WithRateLimitConfigFile returns an option that can set RateLimitConfigFile on a Config
+func WithRateLimitConfigFile(rateLimitConfigFile string) ConfigOption {
+	return func(c *Config) {
+		c.RateLimitConfigFile = rateLimitConfigFile
+	}
+}
+
+// WithRateLimitConfigReloadInterval returns an option that can set RateLimitConfigReloadInterval on a Config
+func WithRateLimitConfigReloadInterval(rateLimitConfigReloadInterval time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.RateLimitConfigReloadInterval = rateLimitConfigReloadInterval
+	}
+}
+
+// WithAdmissionControl returns an option that can set AdmissionControl on a Config
+func WithAdmissionControl(admissionControl admission.Config) ConfigOption {
+	return func(c *Config) {
+		c.AdmissionControl = admissionControl
+	}
+}
+
+// WithDispatchBudget returns an option that can set DispatchBudget on a Config
+func WithDispatchBudget(dispatchBudget dispatchbudget.Config) ConfigOption {
+	return func(c *Config) {
+		c.DispatchBudget = dispatchBudget
+	}
+}
+
+// WithWebhookConfigFile returns an option that can set WebhookConfigFile on a Config
+func WithWebhookConfigFile(webhookConfigFile string) ConfigOption {
+	return func(c *Config) {
+		c.WebhookConfigFile = webhookConfigFile
+	}
+}
+
+// WithWebhookDeliveryEnabled returns an option that can set WebhookDeliveryEnabled on a Config
+func WithWebhookDeliveryEnabled(webhookDeliveryEnabled bool) ConfigOption {
+	return func(c *Config) {
+		c.WebhookDeliveryEnabled = webhookDeliveryEnabled
+	}
+}
+
 // WithMetricsAPI returns an option that can set MetricsAPI on a Config
 func WithMetricsAPI(metricsAPI util.HTTPServerConfig) ConfigOption {
 	return func(c *Config) {
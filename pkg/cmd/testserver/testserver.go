@@ -75,6 +75,7 @@ func (c *Config) Complete() (RunnableTestServer, error) {
 			dispatcher,
 			services.V1SchemaServiceEnabled,
 			services.WatchServiceEnabled,
+			services.PermissionWatchServiceDisabled,
 			v1svc.PermissionsServerConfig{
 				MaxPreconditionsCount:           c.MaximumPreconditionCount,
 				MaxUpdatesPerWrite:              c.MaximumUpdatesPerWrite,
@@ -658,6 +658,25 @@ type RepairableDatastore interface {
 	RepairOperations() []RepairOperation
 }
 
+// ConnPoolStats are the stats of one of the connection pools of a datastore.
+type ConnPoolStats struct {
+	// Name is the name of the pool, such as "read" or "write".
+	Name string
+
+	// AcquiredConns is the number of connections currently in use.
+	AcquiredConns uint32
+
+	// MaxConns is the maximum number of connections in the pool.
+	MaxConns uint32
+}
+
+// ConnPoolStatsReporter is an optional extension to the datastore interface that, when implemented,
+// reports the utilization of the datastore's connection pools.
+type ConnPoolStatsReporter interface {
+	// ConnPoolStats returns the current stats of each connection pool of the datastore.
+	ConnPoolStats() []ConnPoolStats
+}
+
 // UnwrappableDatastore represents a datastore that can be unwrapped into the underlying
 // datastore.
 type UnwrappableDatastore interface {
@@ -68,6 +68,13 @@ func (ls *DispatchLookupSubjectsRequest) MarshalZerologObject(e *zerolog.Event)
 	e.Str("resource-type", tuple.StringRR(ls.ResourceRelation))
 	e.Str("subject-type", tuple.StringRR(ls.SubjectRelation))
 	e.Array("resource-ids", strArray(ls.ResourceIds))
+	if len(ls.AdditionalSubjectRelations) > 0 {
+		additional := make([]string, 0, len(ls.AdditionalSubjectRelations))
+		for _, rr := range ls.AdditionalSubjectRelations {
+			additional = append(additional, tuple.StringRR(rr))
+		}
+		e.Array("additional-subject-types", strArray(additional))
+	}
 }
 
 type strArray []string
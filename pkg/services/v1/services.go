@@ -15,3 +15,69 @@ import (
 func BulkExport(ctx context.Context, ds datastore.ReadOnlyDatastore, batchSize uint64, req *v1.BulkExportRelationshipsRequest, fallbackRevision datastore.Revision, sender func(response *v1.BulkExportRelationshipsResponse) error) error {
 	return servicesv1.BulkExport(ctx, ds, batchSize, req, fallbackRevision, sender)
 }
+
+const (
+	// RequestCheckExplanation, if specified in a request header on CheckPermission, asks SpiceDB to
+	// return an explanation of the check in the response trailer, under CheckExplanation.
+	RequestCheckExplanation = servicesv1.RequestCheckExplanation
+
+	// CheckExplanation is the key in the response trailer metadata holding the JSON-encoded
+	// CheckExplanationResult for a check, if requested via RequestCheckExplanation.
+	CheckExplanation = servicesv1.CheckExplanation
+
+	// RequestCheckHints, if specified in a request header on CheckPermission, asks SpiceDB to return
+	// a check hints token in the response trailer, under CheckHints.
+	RequestCheckHints = servicesv1.RequestCheckHints
+
+	// CheckHintsTokenHeader is the request header in which check hints tokens can be given to
+	// CheckPermission, to reuse their results in checks at the same revision.
+	CheckHintsTokenHeader = servicesv1.CheckHintsTokenHeader
+
+	// CheckHints is the key in the response trailer metadata holding the check hints token, if
+	// requested via RequestCheckHints.
+	CheckHints = servicesv1.CheckHints
+
+	// LookupSubjectTypesHeader is the request header in which additional subject types can be given
+	// to LookupSubjects.
+	LookupSubjectTypesHeader = servicesv1.LookupSubjectTypesHeader
+
+	// LookupSubjectTypes is the key in the response trailer metadata holding the JSON-encoded
+	// LookupSubjectTypesResult, if additional subject types were requested via
+	// LookupSubjectTypesHeader.
+	LookupSubjectTypes = servicesv1.LookupSubjectTypes
+
+	// RequestExpandLookupSubjectsWildcards, if specified in a request header on LookupSubjects, asks
+	// SpiceDB to return the concrete subjects granted by each wildcard in its place.
+	RequestExpandLookupSubjectsWildcards = servicesv1.RequestExpandLookupSubjectsWildcards
+
+	// RequestLookupSubjectsPaths, if specified in a request header on LookupSubjects, asks SpiceDB to
+	// return the paths by which the subjects were found in the response trailer, under
+	// LookupSubjectsPaths.
+	RequestLookupSubjectsPaths = servicesv1.RequestLookupSubjectsPaths
+
+	// LookupSubjectsPaths is the key in the response trailer metadata holding the JSON-encoded
+	// LookupSubjectsPathsResult, if requested via RequestLookupSubjectsPaths.
+	LookupSubjectsPaths = servicesv1.LookupSubjectsPaths
+
+	// RequestCheckProof, if specified in a request header on CheckPermission, asks SpiceDB to return
+	// the minimal proof of the permission in the response trailer, under CheckProof.
+	RequestCheckProof = servicesv1.RequestCheckProof
+
+	// CheckProof is the key in the response trailer metadata holding the JSON-encoded
+	// CheckProofResult for a check, if requested via RequestCheckProof.
+	CheckProof = servicesv1.CheckProof
+)
+
+// CheckExplanationResult is the explanation of a check, returned JSON-encoded in the response trailer.
+type CheckExplanationResult = servicesv1.CheckExplanationResult
+
+// LookupSubjectsPathsResult holds the paths by which the subjects of a LookupSubjects call were found,
+// returned JSON-encoded in the response trailer.
+type LookupSubjectsPathsResult = servicesv1.LookupSubjectsPathsResult
+
+// LookupSubjectTypesResult holds the types of the subjects returned by a LookupSubjects call,
+// returned JSON-encoded in the response trailer.
+type LookupSubjectTypesResult = servicesv1.LookupSubjectTypesResult
+
+// CheckProofResult is the proof of a check, returned JSON-encoded in the response trailer.
+type CheckProofResult = servicesv1.CheckProofResult